@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"agent-bot/actionitems"
+	"agent-bot/asana"
+	"agent-bot/types"
+)
+
+const (
+	// actionItemsBucket stores the Asana project of each channel that opted
+	// in to action item offers
+	actionItemsBucket = "action_item_channels"
+	// actionItemOffersBucket stores offered tasks until someone creates or
+	// dismisses them
+	actionItemOffersBucket = "action_item_offers"
+)
+
+// actionItemOfferTTL is how long an offer's buttons keep working
+const actionItemOfferTTL = 7 * 24 * time.Hour
+
+// actionItemContextPosts bounds the earlier thread posts sent with a message
+const actionItemContextPosts = 10
+
+// actionItemsPath is where Mattermost posts the offer buttons' clicks
+const actionItemsPath = "/mattermost/actions/action-items"
+
+type actionItemChannel struct {
+	ProjectGID string `json:"project_gid"`
+}
+
+// actionItemOffer is a task the bot offered to create for a commitment
+type actionItemOffer struct {
+	ChannelID    string    `json:"channel_id"`
+	SourcePostID string    `json:"source_post_id"`
+	SpeakerID    string    `json:"speaker_id"`
+	AssigneeID   string    `json:"assignee_id,omitempty"`
+	Task         string    `json:"task"`
+	DueOn        string    `json:"due_on,omitempty"`
+	Quote        string    `json:"quote"`
+	ProjectGID   string    `json:"project_gid"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// actionItems offers Asana tasks for commitments made in opted-in channels
+type actionItems struct {
+	extract func(speaker, message, context string) (actionitems.Commitment, error)
+	// callbackURL is where the offer buttons post; empty when
+	// BUTTON_CALLBACK_URL is unset, which keeps the feature off
+	callbackURL string
+}
+
+// newActionItems wires commitment extraction to the decision LLM
+func (b *Bot) newActionItems(decisionLLM types.LLM) *actionItems {
+	extract := func(speaker, message, thread string) (actionitems.Commitment, error) {
+		response, err := decisionLLM.Prompt(context.Background(), actionitems.ExtractionPrompt(speaker, message, thread, time.Now()))
+		if err != nil {
+			return actionitems.Commitment{}, err
+		}
+		return actionitems.ParseCommitment(response)
+	}
+
+	return &actionItems{extract: extract, callbackURL: b.buttonCallbackURL(actionItemsPath)}
+}
+
+// buttonCallbackURL returns the URL Mattermost posts clicks on buttons served
+// at path to, or "" when BUTTON_CALLBACK_URL is unset
+func (b *Bot) buttonCallbackURL(path string) string {
+	if b.config.ButtonCallbackURL == "" {
+		return ""
+	}
+	// Other workspaces serve their routes under /servers/<name>/
+	prefix := ""
+	if b.config.Name != "" {
+		prefix = "/servers/" + b.config.Name
+	}
+	return strings.TrimRight(b.config.ButtonCallbackURL, "/") + prefix + path
+}
+
+// summary describes BUTTON_CALLBACK_URL for !config
+func (a *actionItems) summary() string {
+	if a == nil || a.callbackURL == "" {
+		return "off (BUTTON_CALLBACK_URL not set)"
+	}
+	return "buttons call " + a.callbackURL
+}
+
+// actionItemsProject returns the Asana project of an opted-in channel
+func (b *Bot) actionItemsProject(channelID string) string {
+	var channel actionItemChannel
+	found, err := b.store.Get(actionItemsBucket, channelID, &channel)
+	if err != nil || !found {
+		return ""
+	}
+	return channel.ProjectGID
+}
+
+// observeActionItems looks for commitments in messages from opted-in channels
+func (b *Bot) observeActionItems(message types.PostedMessage) {
+	if message.IsDM || message.UserId == b.config.BotUserID || b.actionItems.callbackURL == "" {
+		return
+	}
+	projectGID := b.actionItemsProject(message.ChannelId)
+	if projectGID == "" || !actionitems.LooksLikeCommitment(message.Message) {
+		return
+	}
+	go b.offerActionItem(message, projectGID)
+}
+
+// offerActionItem asks the decision LLM whether message holds a commitment
+// and, if so, offers to track it in Asana in the message's thread
+func (b *Bot) offerActionItem(message types.PostedMessage, projectGID string) {
+	speaker, err := b.chat.GetUser(message.UserId)
+	if err != nil || speaker.IsBot {
+		return
+	}
+
+	commitment, err := b.actionItems.extract(speaker.Username, message.Message, b.actionItemContext(message))
+	if err != nil {
+		log.Printf("[%s] ACTION_ITEMS: Failed to check %s for a commitment: %v", time.Now().Format("2006-01-02 15:04:05"), message.PostId, err)
+		return
+	}
+	if !commitment.Found {
+		return
+	}
+
+	offer := actionItemOffer{
+		ChannelID:    message.ChannelId,
+		SourcePostID: message.PostId,
+		SpeakerID:    message.UserId,
+		AssigneeID:   message.UserId,
+		Task:         commitment.Task,
+		DueOn:        commitment.DueOn,
+		Quote:        message.Message,
+		ProjectGID:   projectGID,
+		CreatedAt:    time.Now(),
+	}
+	assignee := "@" + speaker.Username
+	if commitment.Assignee != "" && !strings.EqualFold(commitment.Assignee, "me") && !strings.EqualFold(commitment.Assignee, speaker.Username) {
+		user, _, err := b.client.GetUserByUsername(commitment.Assignee, "")
+		if err != nil {
+			// Someone we can't find stays unassigned rather than landing on the speaker
+			offer.AssigneeID = ""
+			assignee = "Unassigned"
+		} else {
+			offer.AssigneeID = user.Id
+			assignee = "@" + user.Username
+		}
+	}
+
+	b.expireActionItemOffers()
+	offerID := newButtonID()
+	if err := b.store.Put(actionItemOffersBucket, offerID, offer); err != nil {
+		log.Printf("[%s] ACTION_ITEMS: Failed to store offer: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return
+	}
+
+	threadID := message.ThreadId
+	if threadID == "" {
+		threadID = message.PostId
+	}
+	card := types.Attachment{
+		Fallback: "Track in Asana: " + offer.Task,
+		Color:    "#f06a6a",
+		Pretext:  fmt.Sprintf("@%s, sounds like an action item. Want me to track it in Asana?", speaker.Username),
+		Title:    offer.Task,
+		Fields:   []types.AttachmentField{{Title: "Assignee", Value: assignee, Short: true}},
+		Actions: []types.ActionButton{
+			{ID: "create", Label: "Create task", Style: "primary", URL: b.actionItems.callbackURL, Context: map[string]interface{}{"offer": offerID, "action": "create"}},
+			{ID: "dismiss", Label: "Dismiss", URL: b.actionItems.callbackURL, Context: map[string]interface{}{"offer": offerID, "action": "dismiss"}},
+		},
+	}
+	if offer.DueOn != "" {
+		card.Fields = append(card.Fields, types.AttachmentField{Title: "Due", Value: offer.DueOn, Short: true})
+	}
+	if _, err := b.chat.PostMessage(types.ChatMessage{ChannelId: message.ChannelId, ThreadId: threadID, Attachments: []types.Attachment{card}}); err != nil {
+		log.Printf("[%s] ACTION_ITEMS: Failed to post offer: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		b.store.Delete(actionItemOffersBucket, offerID)
+		return
+	}
+
+	log.Printf("[%s] ACTION_ITEMS: Offered task %q for post %s", time.Now().Format("2006-01-02 15:04:05"), offer.Task, message.PostId)
+}
+
+// actionItemContext renders the thread posts before message, oldest first
+func (b *Bot) actionItemContext(message types.PostedMessage) string {
+	if message.ThreadId == "" {
+		return "(none)"
+	}
+	posts, err := b.chat.GetThreadMessages(message.ThreadId)
+	if err != nil {
+		return "(none)"
+	}
+
+	var earlier []*types.Message
+	for _, post := range posts {
+		if post.ID != message.PostId {
+			earlier = append(earlier, post)
+		}
+	}
+	if len(earlier) > actionItemContextPosts {
+		earlier = earlier[len(earlier)-actionItemContextPosts:]
+	}
+
+	var lines []string
+	for _, post := range earlier {
+		name := "User"
+		if user, err := b.chat.GetUser(post.UserID); err == nil {
+			name = user.Username
+		}
+		lines = append(lines, fmt.Sprintf("@%s: %s", name, post.Content))
+	}
+	if len(lines) == 0 {
+		return "(none)"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// newButtonID returns a random ID for a button's stored state; it is the
+// only thing the button's context carries
+func newButtonID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// expireActionItemOffers drops offers whose buttons nobody clicked in time
+func (b *Bot) expireActionItemOffers() {
+	for _, offerID := range b.store.Keys(actionItemOffersBucket) {
+		var offer actionItemOffer
+		if found, err := b.store.Get(actionItemOffersBucket, offerID, &offer); err == nil && found && time.Since(offer.CreatedAt) > actionItemOfferTTL {
+			b.store.Delete(actionItemOffersBucket, offerID)
+		}
+	}
+}
+
+// handleActionItemButton serves the clicks on an offer's Create task and
+// Dismiss buttons. The offer ID in the context is never shown to users, so
+// it doubles as the request's credential.
+func (b *Bot) handleActionItemButton(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	offerID, _ := req.Context["offer"].(string)
+	action, _ := req.Context["action"].(string)
+
+	var offer actionItemOffer
+	found, err := b.store.Get(actionItemOffersBucket, offerID, &offer)
+	if offerID == "" || err != nil || !found || time.Since(offer.CreatedAt) > actionItemOfferTTL {
+		writeJSON(w, http.StatusOK, model.PostActionIntegrationResponse{EphemeralText: "This offer has expired or was already handled."})
+		return
+	}
+	if req.UserId != offer.SpeakerID && req.UserId != offer.AssigneeID {
+		writeJSON(w, http.StatusOK, model.PostActionIntegrationResponse{EphemeralText: "Only the person who made the commitment or its assignee can act on this offer."})
+		return
+	}
+
+	clicker := "someone"
+	if user, err := b.chat.GetUser(req.UserId); err == nil {
+		clicker = "@" + user.Username
+	}
+
+	switch action {
+	case "dismiss":
+		b.store.Delete(actionItemOffersBucket, offerID)
+		writeJSON(w, http.StatusOK, model.PostActionIntegrationResponse{Update: &model.Post{Message: fmt.Sprintf("_Action item %q dismissed by %s._", offer.Task, clicker)}})
+
+	case "create":
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		task, taskURL, assigned, err := b.createActionItemTask(ctx, offer)
+		if err != nil {
+			log.Printf("[%s] ACTION_ITEMS: Failed to create task for offer %s: %v", time.Now().Format("2006-01-02 15:04:05"), offerID, err)
+			writeJSON(w, http.StatusOK, model.PostActionIntegrationResponse{EphemeralText: fmt.Sprintf("Couldn't create the Asana task: %v", err)})
+			return
+		}
+		b.store.Delete(actionItemOffersBucket, offerID)
+
+		text := fmt.Sprintf("Created [%s](%s) in Asana for %s.", task.Name, taskURL, clicker)
+		if !assigned && offer.AssigneeID != "" {
+			text += " _The assignee isn't linked to an Asana user, so it is unassigned._"
+		}
+		log.Printf("[%s] ACTION_ITEMS: Created Asana task %s from post %s", time.Now().Format("2006-01-02 15:04:05"), task.GID, offer.SourcePostID)
+		writeJSON(w, http.StatusOK, model.PostActionIntegrationResponse{Update: &model.Post{Message: text}})
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// createActionItemTask creates the offered task, assigned to the Asana user
+// matching the assignee when there is one
+func (b *Bot) createActionItemTask(ctx context.Context, offer actionItemOffer) (*asana.TaskSummary, string, bool, error) {
+	newTask := asana.NewTask{
+		Name:       offer.Task,
+		Notes:      fmt.Sprintf("From %s/_redirect/pl/%s\n\n> %s", strings.TrimRight(b.config.ServerURL, "/"), offer.SourcePostID, offer.Quote),
+		DueOn:      offer.DueOn,
+		ProjectGID: offer.ProjectGID,
+	}
+	if offer.AssigneeID != "" {
+		gid, err := b.asanaUserGID(ctx, offer.AssigneeID)
+		if err != nil {
+			log.Printf("[%s] ACTION_ITEMS: Failed to map user %s to Asana: %v", time.Now().Format("2006-01-02 15:04:05"), offer.AssigneeID, err)
+		}
+		newTask.Assignee = gid
+	}
+
+	task, taskURL, err := b.asanaHooks.client.CreateTask(ctx, newTask)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return task, taskURL, newTask.Assignee != "", nil
+}
+
+// handleActionItemsCommand implements "!actionitems on <channel_id|here> <project_gid>",
+// "!actionitems off <channel_id|here>" and "!actionitems list"
+func (b *Bot) handleActionItemsCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!actionitems on <channel_id|here> <project_gid>`, `!actionitems off <channel_id|here>`, `!actionitems list`"
+	if len(args) == 0 {
+		return usage
+	}
+
+	channelID := ""
+	if len(args) >= 2 {
+		channelID = args[1]
+		if channelID == "here" {
+			channelID = message.ChannelId
+		}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		var channels []string
+		for _, id := range b.store.Keys(actionItemsBucket) {
+			if project := b.actionItemsProject(id); project != "" {
+				channels = append(channels, fmt.Sprintf("`%s` → project `%s`", id, project))
+			}
+		}
+		if len(channels) == 0 {
+			return "Action item offers are not enabled in any channel."
+		}
+		return "Action items are offered in: " + strings.Join(channels, ", ")
+
+	case "on":
+		if len(args) != 3 {
+			return usage
+		}
+		if b.actionItems.callbackURL == "" {
+			return "Set `BUTTON_CALLBACK_URL` to the address Mattermost can reach the bot at first; the offers need buttons."
+		}
+		if err := b.store.Put(actionItemsBucket, channelID, actionItemChannel{ProjectGID: args[2]}); err != nil {
+			return fmt.Sprintf("Failed to enable action items: %v", err)
+		}
+		return fmt.Sprintf("Commitments made in `%s` will be offered as tasks in Asana project `%s`.", channelID, args[2])
+
+	case "off":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := b.store.Delete(actionItemsBucket, channelID); err != nil {
+			return fmt.Sprintf("Failed to disable action items: %v", err)
+		}
+		return fmt.Sprintf("Action items won't be offered in `%s` anymore.", channelID)
+	}
+
+	return usage
+}
@@ -0,0 +1,85 @@
+// Package actionitems spots commitments in chat messages, such as "I'll fix
+// the flaky test tomorrow", so they can be offered as tasks.
+package actionitems
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// commitmentPattern finds first-person promises. Only messages matching it
+// are sent to the LLM, which keeps the cost of watching a busy channel low.
+var commitmentPattern = regexp.MustCompile(`(?i)\b(i'll|i will|i'm going to|im going to|i am going to|i can take|i'll take|let me|i'm on it|i'll handle|i'll look into|i'll get|i'll have|leave it with me|assign (it|this) to me)\b`)
+
+// questionPattern skips offers phrased as questions ("should I fix it?")
+var questionPattern = regexp.MustCompile(`(?i)^(should|shall|can|could|would|do|does)\s+i\b`)
+
+// LooksLikeCommitment reports whether text may contain a commitment worth
+// asking the LLM about
+func LooksLikeCommitment(text string) bool {
+	text = strings.TrimSpace(text)
+	return commitmentPattern.MatchString(text) && !questionPattern.MatchString(text)
+}
+
+// Commitment is an action item found in a message
+type Commitment struct {
+	// Found is false when the message holds no explicit commitment
+	Found bool `json:"commitment"`
+	// Task is a short imperative task title, e.g. "Fix the flaky checkout test"
+	Task string `json:"task"`
+	// Assignee is "me" for the speaker or the username of someone the speaker
+	// committed on behalf of
+	Assignee string `json:"assignee"`
+	// DueOn is the due date as YYYY-MM-DD, or empty
+	DueOn string `json:"due_on"`
+}
+
+// ExtractionPrompt asks for the commitment in message, if any. context is
+// the preceding messages of the conversation, oldest first, and today
+// resolves relative dates such as "tomorrow".
+func ExtractionPrompt(speaker, message, context string, today time.Time) string {
+	return fmt.Sprintf(`Decide whether @%s made an explicit commitment to do a specific piece of work in their latest chat message, and if so extract it as a task.
+
+Only count clear promises ("I'll fix the flaky test tomorrow", "I'm going to write the migration", "leave the release notes with me"). Suggestions, questions, vague intentions ("I'll think about it", "I'll see"), jokes and things already done are not commitments.
+
+Today is %s.
+
+Earlier messages, for context:
+%s
+
+Latest message from @%s:
+%s
+
+Respond with ONLY a JSON object of the form:
+{"commitment": <true or false>, "task": "<short imperative task title, under 80 characters>", "assignee": "<me, or the username without @ if they committed someone else>", "due_on": "<YYYY-MM-DD if they named a day or deadline, else empty>"}`,
+		speaker, today.Format("Monday 2006-01-02"), context, speaker, message)
+}
+
+// ParseCommitment extracts the JSON commitment from an LLM response
+func ParseCommitment(response string) (Commitment, error) {
+	var commitment Commitment
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start < 0 || end <= start {
+		return commitment, fmt.Errorf("no JSON object in response: %q", response)
+	}
+
+	if err := json.Unmarshal([]byte(response[start:end+1]), &commitment); err != nil {
+		return commitment, fmt.Errorf("failed to parse commitment: %w", err)
+	}
+	commitment.Task = strings.TrimSpace(commitment.Task)
+	commitment.Assignee = strings.TrimPrefix(strings.TrimSpace(commitment.Assignee), "@")
+	if commitment.Found && commitment.Task == "" {
+		return commitment, fmt.Errorf("commitment without a task: %q", response)
+	}
+	if commitment.DueOn != "" {
+		if _, err := time.Parse("2006-01-02", commitment.DueOn); err != nil {
+			// A bad date shouldn't lose the task
+			commitment.DueOn = ""
+		}
+	}
+	return commitment, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-bot/reqid"
+	"agent-bot/store"
+	"agent-bot/types"
+)
+
+// activeThreadsBucket stores the threads the agent participates in, keyed by
+// root post ID, so a restart doesn't drop it out of conversations
+const activeThreadsBucket = "active_threads"
+
+// leftThreadsBucket stores when someone asked the bot to leave a thread,
+// keyed by root post ID; the bot stays out of it until mentioned there again
+const leftThreadsBucket = "left_threads"
+
+// leftThreadRetention is how long a request to leave a thread is kept
+const leftThreadRetention = 30 * 24 * time.Hour
+
+// leaveRequestPattern recognizes "leave this thread", "stop following this conversation", "go away" and similar
+var leaveRequestPattern = regexp.MustCompile(`(?i)^(please\s+|can you\s+|could you\s+)?(leave|stop following|stop watching|go away from|go away|bow out of)(\s+(this|the))?(\s+(thread|conversation|discussion))?\s*(please)?[.!?]*$`)
+
+// threadState is what's persisted for an active thread
+type threadState struct {
+	JoinedAt time.Time `json:"joined_at"`
+	// The last message in the thread the agent handled
+	LastPostID string    `json:"last_post_id,omitempty"`
+	LastPostAt time.Time `json:"last_post_at,omitempty"`
+}
+
+// lastActivity is when the thread was joined or last had a message handled
+func (s threadState) lastActivity() time.Time {
+	if s.LastPostAt.After(s.JoinedAt) {
+		return s.LastPostAt
+	}
+	return s.JoinedAt
+}
+
+// restoreThreads loads the threads persisted in stateStore and keeps
+// persisting changes there. Restored threads are checked for deletion by the
+// periodic stale thread cleanup like any other.
+func (a *BotAgent) restoreThreads(stateStore *store.Store) {
+	a.threadStore = stateStore
+	for _, threadID := range stateStore.Keys(activeThreadsBucket) {
+		var state threadState
+		if _, err := stateStore.Get(activeThreadsBucket, threadID, &state); err != nil {
+			log.Printf("[%s] THREAD: Dropping unreadable state for thread %s: %v", time.Now().Format("2006-01-02 15:04:05"), threadID, err)
+			stateStore.Delete(activeThreadsBucket, threadID)
+			continue
+		}
+		a.threadsMu.Lock()
+		a.activeThreads[threadID] = state.lastActivity()
+		a.threadsMu.Unlock()
+	}
+	for _, threadID := range stateStore.Keys(leftThreadsBucket) {
+		var leftAt time.Time
+		if found, err := stateStore.Get(leftThreadsBucket, threadID, &leftAt); found && err == nil {
+			a.threadsMu.Lock()
+			a.leftThreads[threadID] = leftAt
+			a.threadsMu.Unlock()
+		}
+	}
+	if threads := a.activeThreadIDs(); len(threads) > 0 {
+		log.Printf("[%s] THREAD: Restored %d active threads", time.Now().Format("2006-01-02 15:04:05"), len(threads))
+	}
+}
+
+// isActiveThread reports whether the agent participates in a thread. A
+// thread without activity for threadTTL no longer counts.
+func (a *BotAgent) isActiveThread(threadID string) bool {
+	if threadID == "" {
+		return false
+	}
+	if a.sharedThreads {
+		// Other replicas join threads too; the store is the source of truth
+		state, found := a.threadState(threadID)
+		return found && !a.threadExpired(state.lastActivity())
+	}
+	a.threadsMu.Lock()
+	defer a.threadsMu.Unlock()
+	lastActivity, found := a.activeThreads[threadID]
+	return found && !a.threadExpired(lastActivity)
+}
+
+// threadExpired reports whether a thread last active at lastActivity has
+// been quiet for longer than threadTTL
+func (a *BotAgent) threadExpired(lastActivity time.Time) bool {
+	return a.threadTTL > 0 && a.now().Sub(lastActivity) > a.threadTTL
+}
+
+// activeThreadIDs returns the threads the agent participates in, sorted
+func (a *BotAgent) activeThreadIDs() []string {
+	if a.sharedThreads {
+		return a.threadStore.Keys(activeThreadsBucket)
+	}
+	a.threadsMu.Lock()
+	defer a.threadsMu.Unlock()
+	threadIDs := make([]string, 0, len(a.activeThreads))
+	for threadID := range a.activeThreads {
+		threadIDs = append(threadIDs, threadID)
+	}
+	sort.Strings(threadIDs)
+	return threadIDs
+}
+
+// joinThread marks a thread as one the agent participates in
+func (a *BotAgent) joinThread(threadID string) {
+	if a.sharedThreads {
+		if !a.isActiveThread(threadID) {
+			a.saveThread(threadID, threadState{JoinedAt: a.now()})
+		}
+		return
+	}
+	a.threadsMu.Lock()
+	lastActivity, joined := a.activeThreads[threadID]
+	joined = joined && !a.threadExpired(lastActivity)
+	if !joined {
+		a.activeThreads[threadID] = a.now()
+	}
+	a.threadsMu.Unlock()
+	if joined {
+		return
+	}
+	a.saveThread(threadID, threadState{JoinedAt: a.now()})
+}
+
+// optOut leaves a thread at someone's request and keeps the bot out of it
+// until it is mentioned there again
+func (a *BotAgent) optOut(threadID string) {
+	a.leaveThread(threadID)
+	a.threadsMu.Lock()
+	a.leftThreads[threadID] = a.now()
+	a.threadsMu.Unlock()
+	if a.threadStore == nil {
+		return
+	}
+	if err := a.threadStore.Put(leftThreadsBucket, threadID, a.now()); err != nil {
+		log.Printf("[%s] THREAD: Failed to persist leaving thread %s: %v", time.Now().Format("2006-01-02 15:04:05"), threadID, err)
+	}
+}
+
+// hasLeft reports whether the bot was asked to leave a thread
+func (a *BotAgent) hasLeft(threadID string) bool {
+	if threadID == "" {
+		return false
+	}
+	if a.sharedThreads {
+		found, err := a.threadStore.Get(leftThreadsBucket, threadID, new(time.Time))
+		return found && err == nil
+	}
+	a.threadsMu.Lock()
+	defer a.threadsMu.Unlock()
+	_, left := a.leftThreads[threadID]
+	return left
+}
+
+// rejoin forgets a request to leave a thread, once the bot is mentioned there
+func (a *BotAgent) rejoin(threadID string) {
+	a.threadsMu.Lock()
+	_, left := a.leftThreads[threadID]
+	delete(a.leftThreads, threadID)
+	a.threadsMu.Unlock()
+	if !left && !a.sharedThreads || a.threadStore == nil {
+		return
+	}
+	if err := a.threadStore.Delete(leftThreadsBucket, threadID); err != nil {
+		log.Printf("[%s] THREAD: Failed to forget leaving thread %s: %v", time.Now().Format("2006-01-02 15:04:05"), threadID, err)
+	}
+}
+
+// expireThreads leaves threads quiet for longer than threadTTL and forgets
+// old requests to leave, returning how many threads it left
+func (a *BotAgent) expireThreads() int {
+	var expired, forgotten []string
+	a.threadsMu.Lock()
+	for threadID, lastActivity := range a.activeThreads {
+		if a.threadExpired(lastActivity) {
+			expired = append(expired, threadID)
+		}
+	}
+	for threadID, leftAt := range a.leftThreads {
+		if a.now().Sub(leftAt) > leftThreadRetention {
+			forgotten = append(forgotten, threadID)
+		}
+	}
+	a.threadsMu.Unlock()
+
+	if a.sharedThreads {
+		for _, threadID := range a.threadStore.Keys(activeThreadsBucket) {
+			if state, found := a.threadState(threadID); found && a.threadExpired(state.lastActivity()) {
+				expired = append(expired, threadID)
+			}
+		}
+		for _, threadID := range a.threadStore.Keys(leftThreadsBucket) {
+			var leftAt time.Time
+			if found, err := a.threadStore.Get(leftThreadsBucket, threadID, &leftAt); found && err == nil && a.now().Sub(leftAt) > leftThreadRetention {
+				forgotten = append(forgotten, threadID)
+			}
+		}
+	}
+	for _, threadID := range expired {
+		a.leaveThread(threadID)
+		log.Printf("[%s] THREAD: Left thread %s after %v without activity", time.Now().Format("2006-01-02 15:04:05"), threadID, a.threadTTL)
+	}
+	for _, threadID := range forgotten {
+		a.rejoin(threadID)
+	}
+	return len(expired)
+}
+
+// leaveThread forgets a thread
+func (a *BotAgent) leaveThread(threadID string) {
+	a.threadsMu.Lock()
+	delete(a.activeThreads, threadID)
+	a.threadsMu.Unlock()
+	if a.threadStore == nil {
+		return
+	}
+	if err := a.threadStore.Delete(activeThreadsBucket, threadID); err != nil {
+		log.Printf("[%s] THREAD: Failed to forget thread %s: %v", time.Now().Format("2006-01-02 15:04:05"), threadID, err)
+	}
+}
+
+// markProcessed records message as the last one handled in its thread
+func (a *BotAgent) markProcessed(message types.PostedMessage) {
+	threadID := message.ThreadId
+	if threadID == "" {
+		threadID = message.PostId
+	}
+	if !a.isActiveThread(threadID) {
+		return
+	}
+	a.threadsMu.Lock()
+	if _, ok := a.activeThreads[threadID]; ok {
+		a.activeThreads[threadID] = a.now()
+	}
+	a.threadsMu.Unlock()
+	if a.threadStore == nil {
+		return
+	}
+
+	var state threadState
+	a.threadStore.Get(activeThreadsBucket, threadID, &state)
+	if state.JoinedAt.IsZero() {
+		state.JoinedAt = a.now()
+	}
+	state.LastPostID = message.PostId
+	state.LastPostAt = a.now()
+	a.saveThread(threadID, state)
+}
+
+// threadState returns the persisted state of a thread, if any
+func (a *BotAgent) threadState(threadID string) (threadState, bool) {
+	var state threadState
+	if a.threadStore == nil {
+		return state, false
+	}
+	found, err := a.threadStore.Get(activeThreadsBucket, threadID, &state)
+	return state, found && err == nil
+}
+
+func (a *BotAgent) saveThread(threadID string, state threadState) {
+	if a.threadStore == nil {
+		return
+	}
+	if err := a.threadStore.Put(activeThreadsBucket, threadID, state); err != nil {
+		log.Printf("[%s] THREAD: Failed to persist thread %s: %v", time.Now().Format("2006-01-02 15:04:05"), threadID, err)
+	}
+}
+
+// isLeaveRequest reports whether a message addressed to the bot asks it to
+// leave the thread
+func (a *BotAgent) isLeaveRequest(message types.PostedMessage) bool {
+	text := strings.ReplaceAll(message.Message, "@"+a.botUsername, "")
+	return leaveRequestPattern.MatchString(strings.TrimSpace(text))
+}
+
+// leaveOnRequest leaves the thread the message was sent in and tells the
+// sender how to bring the bot back
+func (a *BotAgent) leaveOnRequest(ctx context.Context, message types.PostedMessage) {
+	if message.ThreadId == "" {
+		a.postNotice(ctx, types.PostedMessage{UserId: message.UserId, ChannelId: message.ChannelId, ThreadId: message.PostId}, "Ask me from inside the thread you want me to leave.")
+		return
+	}
+	a.optOut(message.ThreadId)
+	reqid.Logf(ctx, "THREAD: Left thread %s at the request of %s", message.ThreadId, message.UserId)
+	a.postNotice(ctx, message, "I'll stay out of this thread unless you mention me again.")
+}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-bot/approvals"
+	"agent-bot/llms"
+	"agent-bot/metrics"
+	"agent-bot/prompts"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// CommandHandler runs an admin command and returns the reply to post
+type CommandHandler func(message types.PostedMessage, args []string) string
+
+type adminCommand struct {
+	description string
+	handler     CommandHandler
+	// public commands may be run by any user, not just admins
+	public bool
+}
+
+// AdminCommands routes "!command" direct messages. Most commands are restricted
+// to admin users; those registered with RegisterPublic are open to everyone.
+type AdminCommands struct {
+	admins   map[string]bool
+	commands map[string]adminCommand
+}
+
+func NewAdminCommands(adminUserIDs []string) *AdminCommands {
+	admins := make(map[string]bool, len(adminUserIDs))
+	for _, id := range adminUserIDs {
+		admins[id] = true
+	}
+
+	return &AdminCommands{
+		admins:   admins,
+		commands: make(map[string]adminCommand),
+	}
+}
+
+// Register adds a command, invoked as "!name arg1 arg2..."
+func (c *AdminCommands) Register(name, description string, handler CommandHandler) {
+	c.commands[name] = adminCommand{description: description, handler: handler}
+}
+
+// RegisterPublic adds a command any user may run in a DM with the bot
+func (c *AdminCommands) RegisterPublic(name, description string, handler CommandHandler) {
+	c.commands[name] = adminCommand{description: description, handler: handler, public: true}
+}
+
+// IsAdmin reports whether the user may run admin commands
+func (c *AdminCommands) IsAdmin(userID string) bool {
+	return c.admins[userID]
+}
+
+// Handle runs the command contained in message, if any. The second return
+// value reports whether the message was treated as a command.
+func (c *AdminCommands) Handle(message types.PostedMessage) (string, bool) {
+	if !message.IsDM || !strings.HasPrefix(strings.TrimSpace(message.Message), "!") {
+		return "", false
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(message.Message), "!"))
+	if len(fields) == 0 {
+		return "", false
+	}
+	name, args := strings.ToLower(fields[0]), fields[1:]
+
+	isAdmin := c.IsAdmin(message.UserId)
+	if name == "help" {
+		return c.help(isAdmin), true
+	}
+
+	command, ok := c.commands[name]
+	if !isAdmin && !command.public {
+		log.Printf("[%s] ADMIN: Rejected command !%s from non-admin user %s", time.Now().Format("2006-01-02 15:04:05"), name, message.UserId)
+		return "Sorry, admin commands are restricted to configured admin users.", true
+	}
+	if !ok {
+		return fmt.Sprintf("Unknown command `!%s`. Send `!help` for a list of commands.", name), true
+	}
+
+	log.Printf("[%s] ADMIN: Running command !%s for user %s", time.Now().Format("2006-01-02 15:04:05"), name, message.UserId)
+	return command.handler(message, args), true
+}
+
+// help lists the commands the user may run
+func (c *AdminCommands) help(isAdmin bool) string {
+	names := make([]string, 0, len(c.commands))
+	for name, command := range c.commands {
+		if isAdmin || command.public {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	if isAdmin {
+		b.WriteString("**Admin commands**\n")
+	} else {
+		b.WriteString("**Commands**\n")
+	}
+	for _, name := range names {
+		b.WriteString(fmt.Sprintf("- `!%s` — %s\n", name, c.commands[name].description))
+	}
+	return b.String()
+}
+
+// parseCommandOptions splits "key=value" arguments from positional ones
+func parseCommandOptions(args []string) ([]string, map[string]string) {
+	var positional []string
+	options := make(map[string]string)
+	for _, arg := range args {
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			options[strings.ToLower(key)] = value
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	return positional, options
+}
+
+// handleTemplatesCommand implements "!templates"
+func (b *Bot) handleTemplatesCommand(message types.PostedMessage, args []string) string {
+	all := b.templates.All()
+	if len(all) == 0 {
+		return "No response templates are configured. Add them under `response_templates` in the config file."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Response templates**\n")
+	for _, t := range all {
+		fields := make([]string, 0, len(t.Fields))
+		for _, field := range t.Fields {
+			if field.Required {
+				fields = append(fields, field.Name+"*")
+			} else {
+				fields = append(fields, field.Name)
+			}
+		}
+		sb.WriteString(fmt.Sprintf("- `%s` — %s (fields: %s)\n", t.Name, t.Description, strings.Join(fields, ", ")))
+	}
+	sb.WriteString("\n_* required_")
+	return sb.String()
+}
+
+// handlePromptsCommand implements "!prompts"
+func (b *Bot) handlePromptsCommand(message types.PostedMessage, args []string) string {
+	if len(args) > 0 && strings.ToLower(args[0]) == "reload" {
+		if b.prompts.Dir() == "" {
+			return "PROMPTS_DIR is not set, so only the built-in prompts are used."
+		}
+		if err := b.prompts.Reload(); err != nil {
+			return fmt.Sprintf("Failed to reload prompts, keeping the current ones: %v", err)
+		}
+		log.Printf("[%s] PROMPTS: Reloaded prompts from %s", time.Now().Format("2006-01-02 15:04:05"), b.prompts.Dir())
+	}
+
+	overridden := make(map[string]bool)
+	for _, name := range b.prompts.Overridden() {
+		overridden[name] = true
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Prompts**\n")
+	for _, name := range prompts.Names() {
+		source := "built-in"
+		if overridden[name] {
+			source = fmt.Sprintf("`%s.tmpl` in %s", name, b.prompts.Dir())
+		}
+		sb.WriteString(fmt.Sprintf("- `%s` — %s\n", name, source))
+	}
+	return sb.String()
+}
+
+// promptsSummary describes where prompt templates come from
+func promptsSummary(c Config) string {
+	if c.PromptsDir == "" {
+		return "built-in"
+	}
+	return fmt.Sprintf("built-in, overridden from %s", c.PromptsDir)
+}
+
+// handlePendingCommand implements "!pending"
+func (b *Bot) handlePendingCommand(message types.PostedMessage, args []string) string {
+	pending := b.approvals.Pending()
+	if len(pending) == 0 {
+		return "No actions are waiting for approval."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Pending actions**\n")
+	for _, action := range pending {
+		sb.WriteString(fmt.Sprintf("- `%s` — %s (requested %s ago)\n", action.ID, action.Description, time.Since(action.CreatedAt).Round(time.Second)))
+	}
+	return sb.String()
+}
+
+// handleApproveCommand implements "!approve <id>"
+func (b *Bot) handleApproveCommand(message types.PostedMessage, args []string) string {
+	if len(args) != 1 {
+		return "Usage: `!approve <id>`"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	action, result, err := b.approvals.Approve(ctx, args[0])
+	if errors.Is(err, approvals.ErrNotFound) {
+		return fmt.Sprintf("No pending action `%s` (it may have expired).", args[0])
+	}
+	if err != nil {
+		log.Printf("[%s] APPROVAL: Action %s failed: %v", time.Now().Format("2006-01-02 15:04:05"), action.ID, err)
+		result = fmt.Sprintf("Approved action `%s` failed: %v", action.ID, err)
+	} else {
+		log.Printf("[%s] APPROVAL: Action %s approved by %s: %s", time.Now().Format("2006-01-02 15:04:05"), action.ID, message.UserId, action.Description)
+	}
+
+	// Let the conversation that asked for the change know the outcome
+	if action.ChannelID != "" && action.ChannelID != message.ChannelId {
+		post := &model.Post{ChannelId: action.ChannelID, RootId: action.ThreadID, Message: result}
+		if _, _, err := b.client.CreatePost(post); err != nil {
+			log.Printf("[%s] APPROVAL: Failed to post result to requesting thread: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+	}
+
+	return result
+}
+
+// handleDenyCommand implements "!deny <id>"
+func (b *Bot) handleDenyCommand(message types.PostedMessage, args []string) string {
+	if len(args) != 1 {
+		return "Usage: `!deny <id>`"
+	}
+
+	action, err := b.approvals.Deny(args[0])
+	if err != nil {
+		return fmt.Sprintf("No pending action `%s` (it may have expired).", args[0])
+	}
+
+	log.Printf("[%s] APPROVAL: Action %s denied by %s", time.Now().Format("2006-01-02 15:04:05"), action.ID, message.UserId)
+	return fmt.Sprintf("Denied action `%s`: %s", action.ID, action.Description)
+}
+
+// handleStatusCommand implements "!status"
+func (b *Bot) handleStatusCommand(message types.PostedMessage, args []string) string {
+	websocket := "connected"
+	if b.config.EventSource == eventSourceWebhook {
+		websocket = "not used, receiving webhooks"
+	} else if !b.isWebSocketConnected() {
+		websocket = "disconnected"
+	}
+
+	decisionEngine := "LLM"
+	if metrics.Default.Value("decision_llm_degraded") == 1 || !b.features.Enabled(FeatureDecisionLLM) {
+		decisionEngine = "heuristic"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Status**\n")
+	sb.WriteString(fmt.Sprintf("- WebSocket: %s\n", websocket))
+	sb.WriteString(fmt.Sprintf("- Uptime: %s\n", time.Since(b.startedAt).Round(time.Second)))
+	sb.WriteString(fmt.Sprintf("- Tools loaded: %d\n", len(b.registry.List())))
+	sb.WriteString(fmt.Sprintf("- Pending approvals: %d\n", len(b.approvals.Pending())))
+	sb.WriteString(fmt.Sprintf("- DMs held for quiet hours: %d\n", len(b.store.Keys(heldMessagesBucket))))
+	sb.WriteString(fmt.Sprintf("- Thread decisions: %s\n", decisionEngine))
+	sb.WriteString(fmt.Sprintf("- LLM circuit: %s\n", breakerSummary(b.llmBreaker)))
+	sb.WriteString(fmt.Sprintf("- Decision LLM circuit: %s\n", breakerSummary(b.decisionBreaker)))
+	if b.simpleBreaker != nil {
+		sb.WriteString(fmt.Sprintf("- Small model circuit: %s\n", breakerSummary(b.simpleBreaker)))
+	}
+	sb.WriteString(fmt.Sprintf("- Goroutines: %d\n", runtime.NumGoroutine()))
+	return sb.String()
+}
+
+// handleConfigCommand implements "!config"
+func (b *Bot) handleConfigCommand(message types.PostedMessage, args []string) string {
+	secret := func(value string) string {
+		if value == "" {
+			return "_not set_"
+		}
+		return "_set_"
+	}
+
+	c := b.config
+	rows := [][2]string{
+		{"Workspace", workspaceSummary(c)},
+		{"Server URL", c.ServerURL},
+		{"Access token", secret(c.AccessToken)},
+		{"Bot user", fmt.Sprintf("%s (@%s, %s)", c.BotUserID, c.BotUsername, c.BotDisplayName)},
+		{"Anthropic key", secret(c.AnthropicKey)},
+		{"Model", fmt.Sprintf("%s (max %d tokens, %d web searches)", c.AnthropicModel, c.MaxTokens, c.MaxWebSearch)},
+		{"Decision model", fmt.Sprintf("%s (max %d tokens)", c.DecisionModel, c.DecisionMaxTokens)},
+		{"Decision degradation", fmt.Sprintf("median > %v or %d tokens/hour", c.DecisionMaxLatency, c.DecisionTokenBudget)},
+		{"Reply debounce", debounceSummary(c)},
+		{"Thread cooldown", cooldownSummary(c)},
+		{"Thread expiry", threadTTLSummary(c)},
+		{"Reaction actions", reactionActionsSummary(c)},
+		{"Quiet hours", c.QuietHours.String()},
+		{"Default language", languageSummary(c.DefaultLanguage)},
+		{"Moderation", b.moderationSummary()},
+		{"Moderation API key", secret(c.ModerationAPIKey)},
+		{"PII redaction", b.piiSummary()},
+		{"Prompt injection", b.injectionSummary()},
+		{"Daily quotas", b.quotaSummary()},
+		{"Channel intro", b.channelIntroSummary()},
+		{"Context budget", fmt.Sprintf("%d messages / %d tokens", c.ContextMaxMsgs, c.ContextMaxTokens)},
+		{"Context strategy", c.ContextStrategy},
+		{"Asana key", secret(c.AsanaKey)},
+		{"Asana cache", asanaCacheSummary(c)},
+		{"Asana rate limit", asanaRateLimitSummary(c)},
+		{"Asana notifications", b.asanaHooks.summary()},
+		{"Action items", b.actionItems.summary()},
+		{"Jira", jiraSummary(c)},
+		{"Jira token", secret(c.JiraAPIToken)},
+		{"PagerDuty", pagerDutySummary(c)},
+		{"PagerDuty token", secret(c.PagerDutyAPIToken)},
+		{"Prometheus", prometheusSummary(c)},
+		{"Kubernetes", kubeSummary(c)},
+		{"Diagram renderer", rendererSummary(c)},
+		{"Image generation", imageSummary(c)},
+		{"Wiki", wikiSummary(c)},
+		{"Wiki token", secret(c.WikiAPIToken)},
+		{"Webhook signatures", webhookSignatureSummary(c)},
+		{"Tool timeout", fmt.Sprintf("%v (overrides in tool_timeouts)", c.ToolTimeout)},
+		{"Tool parallelism", fmt.Sprint(c.ToolParallelism)},
+		{"Tool loop budget", fmt.Sprintf("%d model calls / %d tokens per request", c.ToolMaxTurns, c.ToolMaxRequestTokens)},
+		{"LLM circuit breaker", breakerConfigSummary(c)},
+		{"LLM retries", retrySummary(c)},
+		{"Extended thinking", thinkingSummary(c)},
+		{"Model routing", routingSummary(c)},
+		{"Audit log", auditLogSummary(c)},
+		{"Error reporting", errorReportingSummary(c)},
+		{"Web fetch", webFetchSummary(c)},
+		{"Admins", strings.Join(c.AdminUserIDs, ", ")},
+		{"State", stateSummary(c)},
+		{"Config file", c.ConfigFile},
+		{"Prompts", promptsSummary(c)},
+		{"Knowledge index", c.KnowledgeIndexFile},
+		{"Tool preselection", toolPreselectSummary(c)},
+		{"Canary", canarySummary(c)},
+		{"Distributed mode", distributedSummary(c)},
+		{"Event source", eventSourceSummary(c)},
+		{"Admin API token", secret(c.AdminAPIToken)},
+		{"gRPC control plane", grpcSummary(c)},
+		{"Audio transcription", transcriptionSummary(c)},
+		{"Spoken replies", speechSummary(c)},
+		{"Usage export channel", c.UsageExportChannel},
+		{"Daily digest", fmt.Sprintf("%s %s", c.DigestTime, c.DigestTimezone)},
+		{"TLS", fmt.Sprintf("CA file %q, skip verify %v, websocket dial timeout %v", c.TLSCAFile, c.TLSInsecureSkipVerify, c.WebSocketDialTimeout)},
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Setting | Value |\n|---|---|\n")
+	for _, row := range rows {
+		sb.WriteString(fmt.Sprintf("| %s | %s |\n", row[0], row[1]))
+	}
+	return sb.String()
+}
+
+// handleToolsCommand implements "!tools"
+func (b *Bot) handleToolsCommand(message types.PostedMessage, args []string) string {
+	registered := b.registry.List()
+	if len(registered) == 0 {
+		return "No tools are registered."
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Tools (%d)**\n", len(registered)))
+	for _, tool := range registered {
+		sb.WriteString(fmt.Sprintf("- `%s` — %s\n", tool.Name, tool.Description))
+	}
+	if !b.features.Enabled(FeatureTools) {
+		sb.WriteString("\n_Tool use is currently disabled (`!feature tools on` to enable)._")
+	}
+	return sb.String()
+}
+
+// handleFeatureCommand implements "!feature [name on|off]"
+func (b *Bot) handleFeatureCommand(message types.PostedMessage, args []string) string {
+	if len(args) == 0 {
+		var sb strings.Builder
+		sb.WriteString("| Feature | State | Description |\n|---|---|---|\n")
+		for _, f := range b.features.List() {
+			state := "off"
+			if f.Enabled {
+				state = "on"
+			}
+			sb.WriteString(fmt.Sprintf("| `%s` | %s | %s |\n", f.Name, state, f.Description))
+		}
+		return sb.String()
+	}
+
+	if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+		return "Usage: `!feature` or `!feature <name> on|off`"
+	}
+
+	if err := b.features.Set(args[0], args[1] == "on"); err != nil {
+		return err.Error()
+	}
+
+	log.Printf("[%s] ADMIN: Feature %s turned %s by %s", time.Now().Format("2006-01-02 15:04:05"), args[0], args[1], message.UserId)
+	return fmt.Sprintf("Feature `%s` is now %s.", args[0], args[1])
+}
+
+func toolPreselectSummary(c Config) string {
+	if c.ToolPreselectTopK <= 0 {
+		return "off (all tools sent)"
+	}
+	return fmt.Sprintf("top %d via %s embeddings, %.0f%% shadow sampled", c.ToolPreselectTopK, c.EmbeddingsProvider, c.ToolPreselectSampleRate*100)
+}
+
+func canarySummary(c Config) string {
+	switch {
+	case c.CanaryMode:
+		return fmt.Sprintf("this instance is a dry-run canary reporting to %s", c.CanaryReportChannel)
+	case c.CanaryURL != "":
+		return "mirroring traffic to " + c.CanaryURL
+	default:
+		return "off"
+	}
+}
+
+// stateSummary leaves the credentials out of STATE_DATABASE_URL
+func stateSummary(c Config) string {
+	switch {
+	case strings.HasPrefix(c.StateDatabaseURL, "sqlite:"):
+		return "SQLite database " + strings.TrimPrefix(strings.TrimPrefix(c.StateDatabaseURL, "sqlite:"), "//")
+	case c.StateDatabaseURL != "":
+		host := "?"
+		if u, err := url.Parse(c.StateDatabaseURL); err == nil {
+			host = u.Host + u.Path
+		}
+		return fmt.Sprintf("Postgres database %s, namespace %q", host, workspaceName(c))
+	case sharesState(c):
+		return fmt.Sprintf("Redis, prefix %q", redisPrefix(c))
+	}
+	return "file " + c.StateFile
+}
+
+// distributedSummary leaves the credentials out of REDIS_URL
+func eventSourceSummary(c Config) string {
+	if c.EventSource == eventSourceWebhook {
+		return fmt.Sprintf("webhook (%d tokens)", len(c.WebhookTokens))
+	}
+	return c.EventSource
+}
+
+func distributedSummary(c Config) string {
+	if !sharesState(c) {
+		return "off"
+	}
+	host := "?"
+	if u, err := url.Parse(c.RedisURL); err == nil {
+		host = u.Host
+	}
+	if c.LeaderElection {
+		return fmt.Sprintf("leader election via Redis at %s, prefix %q", host, redisPrefix(c))
+	}
+	return fmt.Sprintf("%s via Redis at %s, prefix %q, %d partitions", c.DistributedMode, host, redisPrefix(c), c.DistributedPartitions)
+}
+
+func asanaCacheSummary(c Config) string {
+	if c.AsanaCacheTTL <= 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%v for workspaces, users and projects", c.AsanaCacheTTL)
+}
+
+func asanaRateLimitSummary(c Config) string {
+	if c.AsanaRateLimit <= 0 {
+		return "off (429s still retried)"
+	}
+	return fmt.Sprintf("%d requests/minute", c.AsanaRateLimit)
+}
+
+func webhookSignatureSummary(c Config) string {
+	var signed []string
+	if c.GitHubWebhookSecret != "" {
+		signed = append(signed, "github")
+	}
+	if c.GitLabWebhookToken != "" {
+		signed = append(signed, "gitlab")
+	}
+	if len(signed) == 0 {
+		return "off (API keys only)"
+	}
+	return "verified for " + strings.Join(signed, ", ")
+}
+
+func jiraSummary(c Config) string {
+	if c.JiraBaseURL == "" {
+		return "off"
+	}
+	auth := "personal access token"
+	if c.JiraEmail != "" {
+		auth = "API token for " + c.JiraEmail
+	}
+	return fmt.Sprintf("%s (%s)", c.JiraBaseURL, auth)
+}
+
+func pagerDutySummary(c Config) string {
+	if c.PagerDutyAPIToken == "" {
+		return "off"
+	}
+	if c.PagerDutyEmail == "" {
+		return "read-only (set PAGERDUTY_FROM_EMAIL to acknowledge and trigger)"
+	}
+	return "acknowledges and triggers as " + c.PagerDutyEmail
+}
+
+func prometheusSummary(c Config) string {
+	if c.PrometheusURL == "" {
+		return "off"
+	}
+	if c.PrometheusToken != "" {
+		return c.PrometheusURL + " (bearer token)"
+	}
+	return c.PrometheusURL
+}
+
+func rendererSummary(c Config) string {
+	if c.KrokiURL == "" {
+		return "off"
+	}
+	return "Kroki at " + c.KrokiURL
+}
+
+func wikiSummary(c Config) string {
+	if c.WikiProvider == wikiOff {
+		return "off"
+	}
+	summary := c.WikiProvider
+	if c.WikiURL != "" {
+		summary += " at " + c.WikiURL
+	}
+	spaces := "all spaces"
+	if len(c.WikiSpaces) > 0 {
+		spaces = strings.Join(c.WikiSpaces, ", ")
+	}
+	return fmt.Sprintf("%s (%s, %d chars per page)", summary, spaces, c.WikiMaxChars)
+}
+
+func imageSummary(c Config) string {
+	if c.ImageProvider == imageOff {
+		return "off"
+	}
+	summary := c.ImageProvider
+	if c.ImageModel != "" {
+		summary += " " + c.ImageModel
+	}
+	if c.ImageAPIURL != "" {
+		summary += " at " + c.ImageAPIURL
+	}
+	return summary
+}
+
+func kubeSummary(c Config) string {
+	if len(c.KubeNamespaces) == 0 {
+		return "off"
+	}
+	source := "in-cluster service account"
+	if c.KubeConfig != "" {
+		source = c.KubeConfig
+	}
+	return fmt.Sprintf("read-only in %s (%s)", strings.Join(c.KubeNamespaces, ", "), source)
+}
+
+func webFetchSummary(c Config) string {
+	if !c.WebFetchEnabled {
+		return "off"
+	}
+	summary := fmt.Sprintf("timeout %v, max %d characters", c.WebFetchTimeout, c.WebFetchMaxChars)
+	if c.WebFetchAllowPrivate {
+		summary += ", private addresses allowed"
+	}
+	return summary
+}
+
+func breakerSummary(breaker *llms.CircuitBreaker) string {
+	if breaker == nil {
+		return "disabled"
+	}
+	state, retryIn := breaker.Status()
+	if state == llms.BreakerOpen {
+		return fmt.Sprintf("open (retrying in %v)", retryIn.Round(time.Second))
+	}
+	return string(state)
+}
+
+func debounceSummary(c Config) string {
+	if c.DebounceWindow <= 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%v for follow-ups", c.DebounceWindow)
+}
+
+func cooldownSummary(c Config) string {
+	if c.ThreadReplyCooldown <= 0 {
+		return "off (never twice in a row)"
+	}
+	return fmt.Sprintf("one unsolicited reply per thread per %v, never twice in a row", c.ThreadReplyCooldown)
+}
+
+func threadTTLSummary(c Config) string {
+	if c.ThreadTTL <= 0 {
+		return "off (threads are followed until deleted)"
+	}
+	return fmt.Sprintf("leave threads after %v without activity", c.ThreadTTL)
+}
+
+func reactionActionsSummary(c Config) string {
+	if len(c.ReactionActions) == 0 {
+		return "off"
+	}
+	pairs := make([]string, 0, len(c.ReactionActions))
+	for emoji, action := range c.ReactionActions {
+		pairs = append(pairs, ":"+emoji+": "+action)
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("%s (translating into %s)", strings.Join(pairs, ", "), c.TranslateLanguage)
+}
+
+func retrySummary(c Config) string {
+	if c.LLMRetryMaxAttempts <= 1 {
+		return "off"
+	}
+	return fmt.Sprintf("up to %d attempts within %v", c.LLMRetryMaxAttempts, c.LLMRetryDeadline)
+}
+
+func breakerConfigSummary(c Config) string {
+	if c.LLMBreakerThreshold <= 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("opens after %d failures, %v cooldown", c.LLMBreakerThreshold, c.LLMBreakerCooldown)
+}
+
+func workspaceSummary(c Config) string {
+	name := c.Name
+	if name == "" {
+		name = "default"
+	}
+	teams := "all teams"
+	if len(c.TeamIDs) > 0 {
+		teams = "teams " + strings.Join(c.TeamIDs, ", ")
+	}
+	if c.IgnoreDirectMessages {
+		return fmt.Sprintf("%s (%s, DMs ignored)", name, teams)
+	}
+	return fmt.Sprintf("%s (%s)", name, teams)
+}
+
+func errorReportingSummary(c Config) string {
+	var sinks []string
+	if c.SentryDSN != "" {
+		sinks = append(sinks, "Sentry ("+c.SentryEnvironment+")")
+	}
+	if c.ErrorWebhookURL != "" {
+		sinks = append(sinks, "webhook")
+	}
+	if len(sinks) == 0 {
+		return "off"
+	}
+	return strings.Join(sinks, ", ")
+}
+
+func auditLogSummary(c Config) string {
+	if c.AuditLogFile == "" {
+		return "off"
+	}
+	return c.AuditLogFile
+}
+
+func grpcSummary(c Config) string {
+	if c.GRPCPort == "" {
+		return "off"
+	}
+	return "port " + c.GRPCPort
+}
+
+// transcriptionSummary describes the transcription settings for !config
+func transcriptionSummary(c Config) string {
+	switch c.TranscriptionProvider {
+	case transcriptionOpenAI:
+		return fmt.Sprintf("%s (%s at %s)", c.TranscriptionProvider, c.TranscriptionModel, c.TranscriptionAPIURL)
+	case transcriptionCommand:
+		return fmt.Sprintf("%s (%s)", c.TranscriptionProvider, c.TranscriptionCommand)
+	default:
+		return c.TranscriptionProvider
+	}
+}
+
+// speechSummary describes the text-to-speech settings for !config
+func speechSummary(c Config) string {
+	switch c.TTSProvider {
+	case speechOpenAI:
+		return fmt.Sprintf("%s (%s, voice %s, %s at %s)", c.TTSProvider, c.TTSModel, c.TTSVoice, c.TTSFormat, c.TTSAPIURL)
+	case speechCommand:
+		return fmt.Sprintf("%s (%s, %s)", c.TTSProvider, c.TTSCommand, c.TTSFormat)
+	default:
+		return c.TTSProvider
+	}
+}
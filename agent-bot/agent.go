@@ -2,12 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"agent-bot/knowledge"
+	"agent-bot/llms"
+	"agent-bot/memory"
+	"agent-bot/metrics"
+	"agent-bot/policy"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/store"
+	"agent-bot/templates"
+	"agent-bot/tools"
+	"agent-bot/tracing"
 	"agent-bot/types"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // BotAgent implements the Agent interface to handle incoming messages
@@ -18,68 +35,415 @@ type BotAgent struct {
 	llm            types.LLM
 	decisionLLM    types.LLM
 	chat           types.Chat
-	activeThreads  map[string]bool
-	lastCleanup    time.Time
+	threadsMu      sync.Mutex
+	activeThreads  map[string]time.Time // thread ID → last activity
+	leftThreads    map[string]time.Time // thread ID → when asked to leave
+	threadTTL      time.Duration        // leave threads idle this long; 0 never
+	lastCleanup    time.Time            // guarded by threadsMu
+	commands       *AdminCommands
+	templates      *templates.Set
+	styles         *channelStyles
+	policies       *channelPolicies
+	languages      *channelLanguages
+	prompts        *prompts.Set
+	thinking       *channelThinking
+	// simpleLLM answers replies routed to the small model; nil when routing is off
+	simpleLLM types.LLM
+	routing   string
+
+	contextMaxMessages int
+	contextMaxTokens   int
+	// contexts picks each channel's entry in contextBuilders; nil uses summarized
+	contexts        *channelContexts
+	contextBuilders map[string]ContextBuilder
+	channelHistory  *channelHistory
+	summariesMu     sync.Mutex
+	threadSummaries map[string]threadSummary
+	decisionGuard   *decisionGuard
+	features        *Features
+	// decisions keeps the latest reply decisions for the admin dashboard
+	decisions *decisionLog
+	// transcriber turns voice messages into text; nil when transcription is off
+	transcriber Transcriber
+	transcripts *transcriptCache
+	// speech attaches audio versions of replies; nil when text-to-speech is off
+	speech *replySpeech
+
+	// observers see every incoming message, whether or not the bot responds
+	observers []func(types.PostedMessage)
+
+	// interceptors may claim a message before the agent considers replying;
+	// one returning true stops further handling
+	interceptors []func(types.PostedMessage) bool
+
+	// replyObservers see the final LLM reply to each message the bot answered
+	replyObservers []func(message types.PostedMessage, reply string)
+
+	// knowledge holds imported workspace history used to enrich prompts
+	knowledge *knowledge.Index
+
+	// memory holds facts users asked the bot to remember about them
+	memory *memory.Memory
+
+	// threadStore persists activeThreads across restarts; nil keeps them in memory
+	threadStore *store.Store
+	// sharedThreads reads active threads from threadStore instead, because
+	// other replicas share it (see DISTRIBUTED_MODE)
+	sharedThreads bool
+
+	// reactionActions runs actions for emoji reactions; nil ignores reactions
+	reactionActions *reactionActions
+
+	// moderator screens messages and replies; nil when moderation is off
+	moderator *moderator
+
+	// injection applies PROMPT_INJECTION to messages and quoted posts; nil when off
+	injection *injectionGuard
+
+	// quotas enforces daily per-user and per-channel budgets; nil for none
+	quotas *quotas
+
+	// onboarding registers and introduces the bot in channels it joins; nil ignores joins
+	onboarding *onboarding
+
+	// debounce holds replies briefly to answer quick follow-ups together;
+	// nil answers every message straight away
+	debounce *debouncer
+
+	// turns spaces out unsolicited replies per thread and keeps the bot from
+	// posting twice in a row unasked
+	turns *threadTurns
+
+	// replies being generated, keyed by replyKey, so a follow-up can cancel them
+	inFlightMu sync.Mutex
+	inFlight   map[string]*inFlightReply
+
+	// posts currently being streamed into, keyed by post ID
+	streamsMu sync.Mutex
+	streams   map[string]*streamTarget
+
+	// now is the agent's clock; tests swap it out with SetClock
+	now func() time.Time
 }
 
+// Default bounds for thread context sent to the LLM
+const (
+	defaultContextMaxMessages = 50
+	defaultContextMaxTokens   = 8000
+)
+
 // NewBotAgent creates a new agent that handles messages
 func NewBotAgent(botUserID, botUsername, botDisplayName string, llm types.LLM, decisionLLM types.LLM, chat types.Chat) *BotAgent {
-	return &BotAgent{
+	a := &BotAgent{
 		botUserID:      botUserID,
 		botUsername:    botUsername,
 		botDisplayName: botDisplayName,
 		llm:            llm,
 		decisionLLM:    decisionLLM,
 		chat:           chat,
-		activeThreads:  make(map[string]bool),
+		activeThreads:  make(map[string]time.Time),
+		leftThreads:    make(map[string]time.Time),
 		lastCleanup:    time.Now(),
+
+		contextMaxMessages: defaultContextMaxMessages,
+		contextMaxTokens:   defaultContextMaxTokens,
+		threadSummaries:    make(map[string]threadSummary),
+		decisionGuard:      newDecisionGuard(0, 0),
+		decisions:          newDecisionLog(decisionLogSize),
+		transcripts:        newTranscriptCache(transcriptCacheSize),
+		features:           NewFeatures(),
+		prompts:            prompts.Default(),
+		streams:            make(map[string]*streamTarget),
+		inFlight:           make(map[string]*inFlightReply),
+		turns:              newThreadTurns(0),
+		channelHistory:     newChannelHistory(),
+		now:                time.Now,
 	}
+	a.contextBuilders = newContextBuilders(a)
+	return a
+}
+
+// SetClock replaces the clock used for stream updates and thread cleanup
+func (a *BotAgent) SetClock(now func() time.Time) {
+	a.now = now
+	a.threadsMu.Lock()
+	a.lastCleanup = now()
+	a.threadsMu.Unlock()
 }
 
 // MessagePosted handles incoming messages from the websocket
 func (a *BotAgent) MessagePosted(message types.PostedMessage) {
+	a.MessagePostedContext(context.Background(), message)
+}
+
+// MessagePostedContext handles an incoming message as part of the trace in
+// ctx, e.g. the span of the websocket event that delivered it
+func (a *BotAgent) MessagePostedContext(ctx context.Context, message types.PostedMessage) {
+	// Log lines, spans and LLM calls for this message share one correlation ID
+	ctx = reqid.Ensure(ctx)
+
+	// Every step of handling the message is traced under this span
+	ctx, span := tracing.Start(ctx, "agent.message",
+		attribute.String("chat.post_id", message.PostId),
+		attribute.String("chat.channel_id", message.ChannelId),
+		attribute.String("chat.thread_id", message.ThreadId),
+		attribute.Bool("chat.is_dm", message.IsDM),
+		attribute.Bool("chat.mentioned", message.Mentioned),
+		attribute.String("request.id", reqid.From(ctx)),
+	)
+	defer span.End()
+
 	// Periodically clean up stale thread references
 	a.cleanupStaleThreads()
+	defer a.markProcessed(message)
+	a.turns.human(message, a.now())
+
+	// Admin commands are handled directly without involving the LLM
+	if a.commands != nil {
+		if reply, handled := a.commands.Handle(message); handled {
+			span.SetAttributes(attribute.String("agent.outcome", "command"))
+			a.postNotice(ctx, message, reply)
+			return
+		}
+	}
+
+	// Voice messages are answered like typed ones
+	message = a.transcribeAudio(ctx, message)
+
+	for _, intercept := range a.interceptors {
+		if intercept(message) {
+			span.SetAttributes(attribute.String("agent.outcome", "intercepted"))
+			return
+		}
+	}
+
+	for _, observe := range a.observers {
+		observe(message)
+	}
+	a.channelHistory.record(message, a.now().UnixMilli())
 
 	// Log all incoming messages
-	log.Printf("[%s] INCOMING: Message in channel %s: %s",
-		time.Now().Format("2006-01-02 15:04:05"),
+	reqid.Logf(ctx, "INCOMING: Message in channel %s: %s",
 		message.ChannelId,
 		message.Message)
 
+	// "@bot summarize this thread" gets a structured recap instead of a reply
+	if a.isAddressed(message) && a.isSummarizeRequest(message) {
+		span.SetAttributes(attribute.String("agent.outcome", "summary"))
+		if a.withinQuota(ctx, message) {
+			a.summarizeThread(ctx, message)
+		}
+		return
+	}
+
+	// "@bot leave this thread" stops the bot from following the thread
+	if !message.IsDM && message.Mentioned && a.isLeaveRequest(message) {
+		span.SetAttributes(attribute.String("agent.outcome", "left_thread"))
+		a.leaveOnRequest(ctx, message)
+		return
+	}
+
+	// "@bot say it" attaches an audio version of the bot's last reply
+	if a.speech != nil && a.isAddressed(message) && a.isSayItRequest(message) {
+		span.SetAttributes(attribute.String("agent.outcome", "speech"))
+		a.sayLastReply(ctx, message)
+		return
+	}
+
+	// "@bot translate to Spanish" translates the thread
+	if a.isAddressed(message) {
+		if into := a.translateRequest(message); into != "" {
+			span.SetAttributes(attribute.String("agent.outcome", "translation"))
+			if a.withinQuota(ctx, message) {
+				a.translateThread(ctx, message, into)
+			}
+			return
+		}
+	}
+
+	// A quick follow-up joins the messages already waiting for a reply
+	if a.debounce != nil && a.debounce.extend(ctx, message) {
+		span.SetAttributes(attribute.String("agent.outcome", "debounced"))
+		a.acknowledge(message)
+		return
+	}
+
 	// Check if we should respond
-	shouldRespond := a.shouldRespond(message)
+	shouldRespond := a.shouldRespond(ctx, message)
+
+	if shouldRespond && !a.isAddressed(message) {
+		ctx = withUnsolicited(ctx, true)
+	}
 
 	if shouldRespond {
+		// A reply still being written to this user's previous message is now stale
+		a.supersede(message)
+		a.acknowledge(message)
+	}
+
+	if shouldRespond && a.debounce != nil {
+		span.SetAttributes(attribute.String("agent.outcome", "debounced"))
+		a.debounce.start(ctx, message, a.respondToBatch)
+	} else if shouldRespond {
+		span.SetAttributes(attribute.String("agent.outcome", "responded"))
 		a.logResponseReason(message)
-		a.respondToMessage(message)
+		outcome := a.respondToMessage(ctx, message)
+		a.settleAcknowledgements([]types.PostedMessage{message}, outcome)
 	} else {
-		log.Printf("[%s] SKIP: No mention/DM/thread participation needed", time.Now().Format("2006-01-02 15:04:05"))
+		span.SetAttributes(attribute.String("agent.outcome", "skipped"))
+		reqid.Logf(ctx, "SKIP: No mention/DM/thread participation needed")
+	}
+}
+
+// respondToBatch answers messages held back by the debouncer with one reply
+func (a *BotAgent) respondToBatch(ctx context.Context, batch []types.PostedMessage) {
+	message := coalesce(batch)
+	ctx, span := tracing.Start(ctx, "agent.debounced_reply",
+		attribute.String("chat.post_id", message.PostId),
+		attribute.Int("debounce.messages", len(batch)),
+	)
+	defer span.End()
+
+	// The batch carries the context of its latest message, and follow-ups
+	// skip the reply decision, so the merged message decides whether the
+	// reply was asked for
+	ctx = withUnsolicited(ctx, !a.isAddressed(message))
+
+	a.logResponseReason(message)
+	outcome := a.respondToMessage(withCoalescedPosts(ctx, batch), message)
+	a.settleAcknowledgements(batch, outcome)
+	a.markProcessed(message)
+}
+
+// postNotice shows an error, refusal or command reply only to the sender of
+// message, and posts it normally if the chat can't show ephemeral messages
+func (a *BotAgent) postNotice(ctx context.Context, message types.PostedMessage, notice string) {
+	chatMsg := types.ChatMessage{
+		ChannelId: message.ChannelId,
+		ThreadId:  message.ThreadId,
+		Message:   notice,
+	}
+	_, span := tracing.Start(ctx, "chat.post_ephemeral",
+		attribute.String("chat.channel_id", message.ChannelId),
+		attribute.String("chat.thread_id", message.ThreadId),
+		attribute.Int("chat.message_chars", len(notice)),
+	)
+	err := a.chat.PostEphemeral(message.UserId, chatMsg)
+	tracing.End(span, err)
+	if err == nil {
+		return
 	}
+
+	reqid.Logf(ctx, "WARNING: Failed to post ephemeral message, posting it publicly: %v", err)
+	if _, err := a.postMessage(ctx, chatMsg); err != nil {
+		reqid.Logf(ctx, "ERROR: Failed to post notice: %v", err)
+	}
+}
+
+// isAddressed reports whether the message mentions the bot or is a DM
+func (a *BotAgent) isAddressed(message types.PostedMessage) bool {
+	return message.Mentioned || message.IsDM
 }
 
-func (a *BotAgent) shouldRespond(message types.PostedMessage) bool {
-	// Check for direct mentions and DMs first - always respond to these
-	mention := "@" + a.botUsername
-	isMentioned := strings.Contains(message.Message, mention) || strings.Contains(message.Message, a.botUserID)
-	
-	if isMentioned || message.IsDM {
+func (a *BotAgent) shouldRespond(ctx context.Context, message types.PostedMessage) (respond bool) {
+	reason := "not_addressed"
+	defer func() {
+		a.decisions.add(decisionRecord{
+			Time:      a.now(),
+			PostID:    message.PostId,
+			ChannelID: message.ChannelId,
+			ThreadID:  message.ThreadId,
+			UserID:    message.UserId,
+			Respond:   respond,
+			Reason:    reason,
+		})
+	}()
+
+	// DMs are always answered; channels follow their reply policy
+	if message.IsDM {
+		reason = "dm"
 		return true
 	}
+	var p policy.Policy
+	if a.policies != nil {
+		p = a.policies.For(message.ChannelId)
+	}
+	mode := p.ModeAt(a.now(), a.policyLocation())
+	if mode == policy.Never {
+		reason = "policy_never"
+		return false
+	}
+	if message.Mentioned {
+		// Mentioning the bot brings it back into a thread it was asked to leave
+		if a.hasLeft(message.ThreadId) {
+			a.rejoin(message.ThreadId)
+		}
+		reason = "mention"
+		return true
+	}
+	if a.hasLeft(message.ThreadId) {
+		reason = "thread_left"
+		return false
+	}
+
+	// Everything else is unsolicited and counts against the channel's cap
+	defer func() {
+		if !respond {
+			return
+		}
+		a.turns.unsolicited(message, a.now())
+		if a.policies != nil {
+			a.policies.recordUnsolicited(message.ChannelId, a.now())
+		}
+	}()
+	if a.policies != nil && !a.policies.allowUnsolicited(message.ChannelId, p.MaxUnsolicitedPerHour, a.now()) {
+		reason = "policy_rate_limited"
+		return false
+	}
+	if a.turns.coolingDown(message, a.now()) {
+		reason = "thread_cooldown"
+		return false
+	}
+	if keyword := p.Triggered(message.Message); keyword != "" {
+		reqid.Logf(ctx, "POLICY: Keyword %q triggered a reply in channel %s", keyword, message.ChannelId)
+		reason = "keyword"
+		return true
+	}
+
+	switch mode {
+	case policy.Always:
+		reason = "policy_always"
+		return true
+	case policy.MentionOnly:
+		reason = "policy_mention_only"
+		return false
+	}
 
 	// For active threads, use LLM to decide if we should respond
-	isInActiveThread := a.activeThreads[message.ThreadId] && message.ThreadId != ""
-	if isInActiveThread {
-		return a.shouldRespondInThreadLLM(message)
+	isInActiveThread := a.isActiveThread(message.ThreadId)
+	if isInActiveThread && !a.features.Enabled(FeatureThreadParticipation) {
+		reason = "thread_participation_off"
+	} else if isInActiveThread {
+		var engine string
+		respond, engine = a.shouldRespondInThreadLLM(ctx, message)
+		reason = "thread_" + engine
+		return respond
 	}
 
 	return false
 }
 
+// policyLocation is the zone reply policy schedules without a timezone use
+func (a *BotAgent) policyLocation() *time.Location {
+	if a.policies == nil {
+		return time.Local
+	}
+	return a.policies.location
+}
+
 func (a *BotAgent) logResponseReason(message types.PostedMessage) {
-	mention := "@" + a.botUsername
-	isMentioned := strings.Contains(message.Message, mention) || strings.Contains(message.Message, a.botUserID)
-	isInActiveThread := a.activeThreads[message.ThreadId] && message.ThreadId != ""
+	isMentioned := message.Mentioned
+	isInActiveThread := a.isActiveThread(message.ThreadId)
 
 	if isMentioned {
 		log.Printf("[%s] MENTION: Bot mentioned, preparing response", time.Now().Format("2006-01-02 15:04:05"))
@@ -87,53 +451,67 @@ func (a *BotAgent) logResponseReason(message types.PostedMessage) {
 		log.Printf("[%s] DM: Direct message received, preparing response", time.Now().Format("2006-01-02 15:04:05"))
 	} else if isInActiveThread {
 		log.Printf("[%s] THREAD: Responding in active thread", time.Now().Format("2006-01-02 15:04:05"))
+	} else {
+		log.Printf("[%s] POLICY: Responding under the channel's reply policy", time.Now().Format("2006-01-02 15:04:05"))
 	}
 }
 
-// shouldRespondInThreadLLM uses a fast LLM to decide if we should respond in an active thread
-func (a *BotAgent) shouldRespondInThreadLLM(message types.PostedMessage) bool {
+// shouldRespondInThreadLLM uses a fast LLM to decide if we should respond in
+// an active thread. engine is "llm", or "heuristic" when it fell back.
+func (a *BotAgent) shouldRespondInThreadLLM(ctx context.Context, message types.PostedMessage) (respond bool, engine string) {
+	ctx, span := tracing.Start(ctx, "agent.decision")
+	defer func() {
+		span.SetAttributes(attribute.Bool("agent.decision.respond", respond))
+		span.End()
+	}()
+
+	if !a.features.Enabled(FeatureDecisionLLM) {
+		metrics.Inc("thread_decisions_total", "engine", "heuristic")
+		return a.shouldRespondInThreadFallback(message), "heuristic"
+	}
+
+	// Skip the decision LLM entirely while it is slow or over budget
+	if allowed, reason := a.decisionGuard.allow(); !allowed {
+		reqid.Logf(ctx, "DECISION: Decision LLM skipped (%s), using heuristic", reason)
+		metrics.Inc("thread_decisions_total", "engine", "heuristic")
+		return a.shouldRespondInThreadFallback(message), "heuristic"
+	}
+
 	// Get recent thread context for decision making
-	context, err := a.getThreadContext(message)
+	context, err := a.getThreadContext(ctx, message)
 	if err != nil {
-		log.Printf("[%s] DECISION: Failed to get thread context, defaulting to simple heuristic: %v", time.Now().Format("2006-01-02 15:04:05"), err)
-		return a.shouldRespondInThreadFallback(message)
+		reqid.Logf(ctx, "DECISION: Failed to get thread context, defaulting to simple heuristic: %v", err)
+		return a.shouldRespondInThreadFallback(message), "heuristic"
 	}
 
 	// Create a focused prompt for the decision LLM
-	decisionPrompt := fmt.Sprintf(`You are a chat bot assistant. Based on this conversation context, should you respond to the latest message?
-
-Context:
-%s
-
-Your bot username is "%s" and display name is "%s".
-
-Respond with ONLY "YES" if you should respond (if the message is:
-- A direct question to anyone
-- Asking for help or information
-- Continuing a conversation you're already part of
-- Requesting an action or task
-
-Respond with ONLY "NO" if you should not respond (if the message is:
-- Casual conversation between others
-- Off-topic chatter
-- Simple acknowledgments like "ok", "thanks", "lol"
-- Private conversation between specific people
-
-Answer:`, context, a.botUsername, a.botDisplayName)
+	decisionPrompt, err := a.prompts.Render(prompts.Decision, prompts.DecisionData{
+		Context:        context,
+		BotUsername:    a.botUsername,
+		BotDisplayName: a.botDisplayName,
+	})
+	if err != nil {
+		reqid.Logf(ctx, "DECISION: Failed to render decision prompt, using fallback: %v", err)
+		return a.shouldRespondInThreadFallback(message), "heuristic"
+	}
 
 	// Use the fast decision LLM
-	response, err := a.decisionLLM.Prompt(decisionPrompt)
+	startTime := time.Now()
+	response, err := a.promptDecisionLLM(ctx, "respond_decision", decisionPrompt)
+	a.decisionGuard.record(time.Since(startTime), estimateTokens(decisionPrompt)+estimateTokens(response))
 	if err != nil {
-		log.Printf("[%s] DECISION: LLM call failed, using fallback: %v", time.Now().Format("2006-01-02 15:04:05"), err)
-		return a.shouldRespondInThreadFallback(message)
+		reqid.Logf(ctx, "DECISION: LLM call failed, using fallback: %v", err)
+		metrics.Inc("thread_decisions_total", "engine", "heuristic")
+		return a.shouldRespondInThreadFallback(message), "heuristic"
 	}
+	metrics.Inc("thread_decisions_total", "engine", "llm")
 
 	// Parse the response
 	response = strings.TrimSpace(strings.ToUpper(response))
 	shouldRespond := strings.Contains(response, "YES")
-	
-	log.Printf("[%s] DECISION: LLM response '%s' -> %v", time.Now().Format("2006-01-02 15:04:05"), response, shouldRespond)
-	return shouldRespond
+
+	reqid.Logf(ctx, "DECISION: LLM response '%s' -> %v", response, shouldRespond)
+	return shouldRespond, "llm"
 }
 
 // shouldRespondInThreadFallback is the original simple heuristic as a fallback
@@ -162,37 +540,142 @@ func (a *BotAgent) shouldRespondInThreadFallback(message types.PostedMessage) bo
 	return true // Default to participating in active threads
 }
 
-func (a *BotAgent) respondToMessage(message types.PostedMessage) {
+func (a *BotAgent) respondToMessage(ctx context.Context, message types.PostedMessage) replyOutcome {
+	ctx, done := a.beginReply(ctx, message)
+	defer done()
+
+	// Users and channels past their daily budget get a notice instead
+	if !a.withinQuota(ctx, message) {
+		return replyFailed
+	}
+
+	// Disallowed words are redacted before the model sees them, or the
+	// message isn't answered at all
+	message, allowed := a.moderateIncoming(ctx, message)
+	if !allowed {
+		return replyFailed
+	}
+
+	// Messages trying to rewrite the bot's instructions are refused or flagged
+	ctx, allowed = a.screenInjection(ctx, message)
+	if !allowed {
+		return replyFailed
+	}
+
 	// Send typing indicator
 	a.sendTypingIndicator(message.ChannelId, message.ThreadId)
 
-	// Get thread context for coherent responses
-	prompt, err := a.getThreadContext(message)
+	// Get thread context for coherent responses; what the bot already
+	// answered goes to the model as earlier turns of the conversation
+	ctx, prompt, err := a.threadConversation(ctx, message)
 	if err != nil {
-		log.Printf("[%s] ERROR: Failed to get thread context: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		reqid.Logf(ctx, "ERROR: Failed to get thread context: %v", err)
 		prompt = message.Message // Fallback to just the current message
 	}
 
+	// Simple questions can be answered by the small model
+	ctx = a.routeReply(ctx, message, prompt)
+
+	// Recurring request types get a consistent, admin-defined structure
+	prompt = a.applyResponseTemplate(ctx, prompt)
+
+	// Channel reply style: length, verbosity, emoji and sources
+	prompt = a.withStyle(message.ChannelId, prompt)
+
+	// Answer in the language the message was written in
+	prompt = a.withLanguage(message, prompt)
+
+	// Personal facts the sender asked the bot to remember
+	prompt = a.withUserMemory(message.UserId, prompt)
+
+	// Web search citations and knowledge excerpts are listed under the reply
+	ctx = a.withSources(ctx, message.ChannelId)
+
+	// Bring in relevant history imported from exports
+	prompt = a.withKnowledge(ctx, message.ChannelId, message.Message, prompt)
+
 	// Use streaming response
-	a.respondWithStream(message, prompt)
+	return a.respondWithStream(ctx, message, prompt)
+}
+
+// applyResponseTemplate asks the decision LLM to classify the request and, if it
+// matches a configured template, appends the template's instructions to the prompt
+func (a *BotAgent) applyResponseTemplate(ctx context.Context, prompt string) string {
+	if a.templates.Empty() || !a.features.Enabled(FeatureTemplates) {
+		return prompt
+	}
+
+	answer, err := a.promptDecisionLLM(ctx, "template_classification", a.templates.ClassificationPrompt(prompt))
+	if err != nil {
+		reqid.Logf(ctx, "TEMPLATE: Classification failed, answering without template: %v", err)
+		return prompt
+	}
+
+	template, ok := a.templates.Match(answer)
+	if !ok {
+		reqid.Logf(ctx, "TEMPLATE: No template matched (classified as '%s')", strings.TrimSpace(answer))
+		return prompt
+	}
+
+	reqid.Logf(ctx, "TEMPLATE: Using response template '%s'", template.Name)
+	return prompt + "\n\n" + template.Instructions()
 }
 
 // respondWithStream handles streaming LLM responses with periodic message updates
-func (a *BotAgent) respondWithStream(message types.PostedMessage, prompt string) {
+func (a *BotAgent) respondWithStream(ctx context.Context, message types.PostedMessage, prompt string) replyOutcome {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	log.Printf("[%s] STREAM: Starting streaming response", timestamp)
+	reqid.Logf(ctx, "STREAM: Starting streaming response")
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
+	// Let tools know who is asking and where
+	threadID := message.ThreadId
+	if threadID == "" {
+		threadID = message.PostId
+	}
+	ctx = tools.WithRequest(ctx, tools.Request{
+		UserID:    message.UserId,
+		ChannelID: message.ChannelId,
+		ThreadID:  threadID,
+	})
+
+	// Screenshots and other images are sent to the LLM alongside the prompt
+	if len(message.FileIds) > 0 {
+		images, err := a.chat.GetImages(message.FileIds)
+		if err != nil {
+			reqid.Logf(ctx, "WARNING: Failed to load image attachments: %v", err)
+		}
+		if len(images) > 0 {
+			reqid.Logf(ctx, "STREAM: Attaching %d images to the prompt", len(images))
+			ctx = llms.WithImages(ctx, images)
+		}
+	}
+
+	// Who the bot is and today's date
+	ctx = a.withSystemPrompt(ctx)
+
+	// Channels set up for harder questions get extended thinking
+	ctx = a.withThinking(ctx, message.ChannelId)
+
+	// Channels styled footer=on get the model, tools and response time
+	ctx = a.withReplyFooter(ctx, message.ChannelId)
+
+	// Tool calls show up in the placeholder until the answer arrives
+	ctx, progress := withToolProgress(ctx)
+
 	// Start the streaming request
-	chunkChan, err := a.llm.PromptStream(ctx, prompt)
+	llm := a.replyLLM(ctx)
+	chunkChan, err := llm.PromptStream(ctx, prompt)
+	if err != nil && llm != a.llm {
+		reqid.Logf(ctx, "WARNING: Small model unavailable, using the main model: %v", err)
+		chunkChan, err = a.llm.PromptStream(ctx, prompt)
+	}
 	if err != nil {
-		log.Printf("[%s] ERROR: Failed to start streaming: %v", timestamp, err)
+		reqid.Logf(ctx, "ERROR: Failed to start streaming: %v", err)
 		// Fallback to non-streaming response
-		a.respondWithFallback(message, prompt)
-		return
+		return a.respondWithFallback(ctx, message, prompt)
 	}
 
 	// Create initial empty message
@@ -205,116 +688,239 @@ func (a *BotAgent) respondWithStream(message types.PostedMessage, prompt string)
 	if message.ThreadId != "" {
 		// This is already part of a thread, continue in it
 		initialMsg.ThreadId = message.ThreadId
-		a.activeThreads[message.ThreadId] = true
-		log.Printf("[%s] THREAD: Continuing in existing thread %s", timestamp, message.ThreadId)
-	} else if strings.Contains(message.Message, "@"+a.botUsername) || strings.Contains(message.Message, a.botUserID) {
+		a.joinThread(message.ThreadId)
+		reqid.Logf(ctx, "THREAD: Continuing in existing thread %s", message.ThreadId)
+	} else if message.Mentioned {
 		// This is a new mention, create a thread
 		if a.canCreateThread(message.PostId) {
 			initialMsg.ThreadId = message.PostId
-			a.activeThreads[message.PostId] = true
-			log.Printf("[%s] THREAD: Created thread for post %s", timestamp, message.PostId)
+			a.joinThread(message.PostId)
+			reqid.Logf(ctx, "THREAD: Created thread for post %s", message.PostId)
 		}
 	}
 
+	if superseded(ctx) {
+		reqid.Logf(ctx, "STREAM: Superseded before posting, dropping response")
+		return replyDropped
+	}
+
+	// An unsolicited reply never directly follows one of the bot's own posts,
+	// e.g. when two people wrote before the first reply went out
+	if isUnsolicited(ctx) && a.turns.botSpokeLast(initialMsg.ChannelId, initialMsg.ThreadId) {
+		reqid.Logf(ctx, "STREAM: Bot posted last in this conversation, dropping unsolicited response")
+		return replyDropped
+	}
+
 	// Post initial message and get its ID
-	messageID, err := a.chat.PostMessage(initialMsg)
+	messageID, err := a.postMessage(ctx, initialMsg)
+	if err == nil && messageID == "" {
+		err = fmt.Errorf("chat returned no post ID")
+	}
 	if err != nil {
-		log.Printf("[%s] ERROR: Failed to post initial message: %v", timestamp, err)
-		return
+		reqid.Logf(ctx, "ERROR: Failed to post initial message: %v", err)
+		return replyFailed
 	}
 
-	log.Printf("[%s] STREAM: Posted initial message with ID %s", timestamp, messageID)
+	reqid.Logf(ctx, "STREAM: Posted initial message with ID %s", messageID)
+	a.rememberReply(ctx, messageID, message, prompt)
+
+	// Watch for moderators editing or deleting the post while we stream into it
+	a.trackStream(messageID, initialMsg.Message)
+	defer a.untrackStream(messageID)
 
 	// Start streaming and updating
-	a.processStream(ctx, chunkChan, messageID, timestamp)
+	content, outcome := a.processStream(ctx, chunkChan, progress, messageID, initialMsg, timestamp)
+	if content != "" {
+		a.notifyReply(message, content)
+	}
+	return outcome
 }
 
-// processStream handles the streaming response and periodic updates
-func (a *BotAgent) processStream(ctx context.Context, chunkChan <-chan types.StreamChunk, messageID string, timestamp string) {
+// notifyReply passes the bot's final reply to the reply observers
+func (a *BotAgent) notifyReply(message types.PostedMessage, reply string) {
+	for _, observe := range a.replyObservers {
+		observe(message, reply)
+	}
+}
+
+// processStream handles the streaming response and periodic updates.
+// Until the first content arrives, tools named on progress replace the
+// placeholder with a status line.
+// reply describes where the streamed post lives, in case it has to be reposted.
+// It returns the final content, or "" if the response was abandoned, and how
+// the reply ended.
+func (a *BotAgent) processStream(ctx context.Context, chunkChan <-chan types.StreamChunk, progress <-chan string, messageID string, reply types.ChatMessage, timestamp string) (string, replyOutcome) {
 	var responseBuffer strings.Builder
+	lastStatus := reply.Message
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	lastUpdate := time.Now()
+	lastUpdate := a.now()
 	updateInterval := 1 * time.Second
 
-	log.Printf("[%s] STREAM: Starting to process chunks", timestamp)
+	reqid.Logf(ctx, "STREAM: Starting to process chunks")
 
 	for {
 		select {
 		case chunk, ok := <-chunkChan:
 			if !ok {
 				// Channel closed, stream ended
-				log.Printf("[%s] STREAM: Channel closed, finalizing", timestamp)
-				a.finalizeStreamResponse(messageID, responseBuffer.String(), timestamp)
-				return
+				reqid.Logf(ctx, "STREAM: Channel closed, finalizing")
+				return delivered(a.finalizeStreamResponse(ctx, messageID, reply, a.finishReply(ctx, responseBuffer.String()), timestamp))
 			}
 
 			if chunk.Error != nil {
-				log.Printf("[%s] STREAM: Error received: %v", timestamp, chunk.Error)
-				a.finalizeStreamResponse(messageID, responseBuffer.String()+"\n\n_Error: Failed to complete response_", timestamp)
-				return
+				reqid.Logf(ctx, "STREAM: Error received: %v", chunk.Error)
+				return a.finalizeStreamResponse(ctx, messageID, reply, responseBuffer.String()+"\n\n_Error: Failed to complete response_", timestamp), replyFailed
 			}
 
 			if chunk.Done {
-				log.Printf("[%s] STREAM: Received completion signal", timestamp)
-				a.finalizeStreamResponse(messageID, responseBuffer.String(), timestamp)
-				return
+				reqid.Logf(ctx, "STREAM: Received completion signal")
+				return delivered(a.finalizeStreamResponse(ctx, messageID, reply, a.finishReply(ctx, responseBuffer.String()), timestamp))
 			}
 
 			// Append new content
 			if chunk.Content != "" {
 				responseBuffer.WriteString(chunk.Content)
-				log.Printf("[%s] STREAM: Added chunk (%d chars), total: %d chars", timestamp, len(chunk.Content), responseBuffer.Len())
+				reqid.Logf(ctx, "STREAM: Added chunk (%d chars), total: %d chars", len(chunk.Content), responseBuffer.Len())
+			}
+
+		case tool := <-progress:
+			if responseBuffer.Len() > 0 {
+				continue
+			}
+			if edited, deleted := a.streamState(messageID); edited || deleted {
+				continue
+			}
+			status := "_" + toolStatus(tool) + "_"
+			if status == lastStatus {
+				continue
+			}
+			if err := a.updateStream(ctx, messageID, status); err != nil {
+				reqid.Logf(ctx, "STREAM: Failed to show progress for %s: %v", tool, err)
+			} else {
+				reqid.Logf(ctx, "STREAM: Showing progress for %s", tool)
+				lastStatus = status
 			}
 
 		case <-ticker.C:
+			edited, deleted := a.streamState(messageID)
+			if deleted {
+				// Nothing left to update; returning cancels the LLM request
+				reqid.Logf(ctx, "STREAM: Target message deleted, abandoning response (%d chars)", responseBuffer.Len())
+				return "", replyDropped
+			}
+			if edited {
+				// Leave the edited post alone; the final answer is reposted when done
+				continue
+			}
+
 			// Periodic update
-			if time.Since(lastUpdate) >= updateInterval && responseBuffer.Len() > 0 {
-				currentResponse := responseBuffer.String()
-				if err := a.chat.UpdateMessage(messageID, currentResponse); err != nil {
-					log.Printf("[%s] STREAM: Failed to update message: %v", timestamp, err)
+			if a.now().Sub(lastUpdate) >= updateInterval && responseBuffer.Len() > 0 {
+				currentResponse, show := a.moderatePartial(responseBuffer.String())
+				if !show {
+					// Blocked words stay hidden until the finished reply is screened
+					continue
+				}
+				if err := a.updateStream(ctx, messageID, currentResponse); err != nil {
+					reqid.Logf(ctx, "STREAM: Failed to update message: %v", err)
 				} else {
-					log.Printf("[%s] STREAM: Updated message (%d chars)", timestamp, len(currentResponse))
-					lastUpdate = time.Now()
+					reqid.Logf(ctx, "STREAM: Updated message (%d chars)", len(currentResponse))
+					lastUpdate = a.now()
 				}
 			}
 
 		case <-ctx.Done():
-			log.Printf("[%s] STREAM: Context cancelled", timestamp)
-			a.finalizeStreamResponse(messageID, responseBuffer.String()+"\n\n_Response cancelled_", timestamp)
-			return
+			if superseded(ctx) {
+				reqid.Logf(ctx, "STREAM: Superseded by a newer message (%d chars)", responseBuffer.Len())
+				partial := responseBuffer.String()
+				if partial != "" {
+					partial += "\n\n"
+				}
+				a.finalizeStreamResponse(ctx, messageID, reply, partial+supersededNote, timestamp)
+				return "", replyDropped
+			}
+			reqid.Logf(ctx, "STREAM: Context cancelled")
+			return a.finalizeStreamResponse(ctx, messageID, reply, responseBuffer.String()+"\n\n_Response cancelled_", timestamp), replyFailed
 		}
 	}
 }
 
-// finalizeStreamResponse sends the final update and logs completion
-func (a *BotAgent) finalizeStreamResponse(messageID string, finalContent string, timestamp string) {
+// finishReply adds the sources, the footer and the reasoning to a complete
+// streamed answer, in that order so the reasoning stays last
+func (a *BotAgent) finishReply(ctx context.Context, answer string) string {
+	return withReasoning(ctx, a.withFooterLine(ctx, withSourcesFooter(ctx, answer)))
+}
+
+// delivered is the outcome of finalizing a complete response: posted, unless
+// the post was deleted meanwhile
+func delivered(content string) (string, replyOutcome) {
+	if content == "" {
+		return "", replyDropped
+	}
+	return content, replyPosted
+}
+
+// finalizeStreamResponse sends the final update and logs completion. If the
+// streamed post was deleted the response is dropped; if someone else edited
+// it, the response is posted as a new reply instead of overwriting their edit.
+// It returns the content delivered, or "" if the response was dropped.
+func (a *BotAgent) finalizeStreamResponse(ctx context.Context, messageID string, reply types.ChatMessage, finalContent string, timestamp string) string {
 	if finalContent == "" {
 		finalContent = "_No response generated_"
 	}
+	finalContent = a.moderateReply(ctx, finalContent)
 
-	if err := a.chat.UpdateMessage(messageID, finalContent); err != nil {
-		log.Printf("[%s] STREAM: Failed to finalize message: %v", timestamp, err)
+	edited, deleted := a.streamState(messageID)
+	if deleted {
+		reqid.Logf(ctx, "STREAM: Target message %s was deleted, discarding response (%d chars)", messageID, len(finalContent))
+		return ""
+	}
+	if edited {
+		reply.Message = finalContent
+		if newID, err := a.postMessage(ctx, reply); err != nil {
+			reqid.Logf(ctx, "STREAM: Failed to repost response after edit: %v", err)
+		} else {
+			reqid.Logf(ctx, "STREAM: Message %s was edited, reposted response as %s (%d chars)", messageID, newID, len(finalContent))
+		}
+		return finalContent
+	}
+
+	if err := a.updateStream(ctx, messageID, finalContent); err != nil {
+		reqid.Logf(ctx, "STREAM: Failed to finalize message: %v", err)
 	} else {
-		log.Printf("[%s] STREAM: Response completed (%d chars total)", timestamp, len(finalContent))
+		reqid.Logf(ctx, "STREAM: Response completed (%d chars total)", len(finalContent))
 	}
+	return finalContent
 }
 
 // respondWithFallback uses the original non-streaming approach
-func (a *BotAgent) respondWithFallback(message types.PostedMessage, prompt string) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	log.Printf("[%s] FALLBACK: Using non-streaming response", timestamp)
-
-	// Get LLM response with full context
-	response, err := a.llm.Prompt(prompt)
+func (a *BotAgent) respondWithFallback(ctx context.Context, message types.PostedMessage, prompt string) replyOutcome {
+	reqid.Logf(ctx, "FALLBACK: Using non-streaming response")
+
+	// Get LLM response with full context from the model the reply was
+	// routed to, keeping the request's history, tools and cancellation
+	llm := a.replyLLM(ctx)
+	response, err := llm.Prompt(ctx, prompt)
+	if err != nil && llm != a.llm && !superseded(ctx) {
+		reqid.Logf(ctx, "WARNING: Small model unavailable, using the main model: %v", err)
+		response, err = a.llm.Prompt(ctx, prompt)
+	}
+	if superseded(ctx) {
+		reqid.Logf(ctx, "FALLBACK: Superseded by a newer message, dropping response")
+		return replyDropped
+	}
+	outcome := replyPosted
 	if err != nil {
-		log.Printf("[%s] ERROR: LLM request failed: %v", timestamp, err)
+		outcome = replyFailed
+		reqid.Logf(ctx, "ERROR: LLM request failed: %v", err)
 		response = "I'm sorry, I'm having trouble processing your request right now. Please try again later."
+		if errors.Is(err, llms.ErrCircuitOpen) {
+			response = "I can't reach my language model at the moment, so I'm taking a short break. Please try again in a few minutes."
+		}
 	}
 
-	log.Printf("[%s] OUTGOING: Sending fallback response to channel %s: %s",
-		timestamp,
+	reqid.Logf(ctx, "OUTGOING: Sending fallback response to channel %s: %s",
 		message.ChannelId,
 		response[:min(100, len(response))]+"...")
 
@@ -328,23 +934,78 @@ func (a *BotAgent) respondWithFallback(message types.PostedMessage, prompt strin
 	if message.ThreadId != "" {
 		// This is already part of a thread, continue in it
 		chatMsg.ThreadId = message.ThreadId
-		a.activeThreads[message.ThreadId] = true
-		log.Printf("[%s] THREAD: Continuing in existing thread %s", timestamp, message.ThreadId)
-	} else if strings.Contains(message.Message, "@"+a.botUsername) || strings.Contains(message.Message, a.botUserID) {
+		a.joinThread(message.ThreadId)
+		reqid.Logf(ctx, "THREAD: Continuing in existing thread %s", message.ThreadId)
+	} else if message.Mentioned {
 		// This is a new mention, create a thread
 		if a.canCreateThread(message.PostId) {
 			chatMsg.ThreadId = message.PostId
-			a.activeThreads[message.PostId] = true
-			log.Printf("[%s] THREAD: Created thread for post %s", timestamp, message.PostId)
+			a.joinThread(message.PostId)
+			reqid.Logf(ctx, "THREAD: Created thread for post %s", message.PostId)
 		}
 	}
 
+	// Only the sender needs to see that the model failed
+	if outcome == replyFailed {
+		a.postNotice(ctx, types.PostedMessage{UserId: message.UserId, ChannelId: chatMsg.ChannelId, ThreadId: chatMsg.ThreadId}, response)
+		return replyFailed
+	}
+
 	// Send the response
-	if messageID, err := a.chat.PostMessage(chatMsg); err != nil {
-		log.Printf("[%s] ERROR: Failed to send message: %v", timestamp, err)
-	} else {
-		log.Printf("[%s] SUCCESS: Message sent successfully with ID %s", timestamp, messageID)
+	chatMsg.Message = a.moderateReply(ctx, chatMsg.Message)
+	messageID, err := a.postMessage(ctx, chatMsg)
+	if err != nil {
+		reqid.Logf(ctx, "ERROR: Failed to send message: %v", err)
+		return replyFailed
+	}
+	reqid.Logf(ctx, "SUCCESS: Message sent successfully with ID %s", messageID)
+	a.rememberReply(ctx, messageID, message, prompt)
+	a.notifyReply(message, response)
+	return replyPosted
+}
+
+// postMessage posts to the chat inside a span
+func (a *BotAgent) postMessage(ctx context.Context, message types.ChatMessage) (string, error) {
+	_, span := tracing.Start(ctx, "chat.post",
+		attribute.String("chat.channel_id", message.ChannelId),
+		attribute.String("chat.thread_id", message.ThreadId),
+		attribute.Int("chat.message_chars", len(message.Message)),
+	)
+	messageID, err := a.chat.PostMessage(message)
+	span.SetAttributes(attribute.String("chat.post_id", messageID))
+	tracing.End(span, err)
+	if err == nil {
+		reqid.Logf(ctx, "CHAT: Posted message %s to channel %s", messageID, message.ChannelId)
+		a.turns.bot(message.ChannelId, message.ThreadId, a.now())
+	}
+	return messageID, err
+}
+
+// promptDecisionLLM calls the decision LLM inside a span; purpose tells the
+// calls apart in traces
+func (a *BotAgent) promptDecisionLLM(ctx context.Context, purpose, prompt string) (string, error) {
+	ctx, span := tracing.Start(ctx, "llm.decision",
+		attribute.String("llm.purpose", purpose),
+		attribute.Int("llm.prompt_chars", len(prompt)),
+	)
+	response, err := a.decisionLLM.Prompt(ctx, prompt)
+	span.SetAttributes(attribute.Int("llm.response_chars", len(response)))
+	tracing.End(span, err)
+	return response, err
+}
+
+// withSystemPrompt attaches the rendered system prompt to requests made with ctx
+func (a *BotAgent) withSystemPrompt(ctx context.Context) context.Context {
+	system, err := a.prompts.Render(prompts.System, prompts.SystemData{
+		BotName:     a.botDisplayName,
+		BotUsername: a.botUsername,
+		Date:        a.now().Format("Monday, January 2, 2006"),
+	})
+	if err != nil {
+		reqid.Logf(ctx, "WARNING: Failed to render system prompt: %v", err)
+		return ctx
 	}
+	return llms.WithSystemPrompt(ctx, system)
 }
 
 func (a *BotAgent) sendTypingIndicator(channelID, threadID string) {
@@ -364,84 +1025,224 @@ func (a *BotAgent) canCreateThread(postID string) bool {
 	return true
 }
 
-func (a *BotAgent) getThreadContext(message types.PostedMessage) (string, error) {
-	// If this is not a threaded message, just return the current message
-	rootId := message.ThreadId
-	if rootId == "" {
-		rootId = message.PostId // If this will become the root of a new thread
-	}
-
-	// Get all posts in the thread
-	posts, err := a.chat.GetThreadMessages(rootId)
+// loadThread returns the thread's posts oldest first, without excludeID, and
+// resolves every participant (plus extraUserID) up front, concurrently
+func (a *BotAgent) loadThread(rootID, excludeID, extraUserID string) ([]*types.Message, map[string]*types.User, error) {
+	posts, err := a.chat.GetThreadMessages(rootID)
 	if err != nil {
-		log.Printf("[%s] THREAD: Failed to get thread context: %v", time.Now().Format("2006-01-02 15:04:05"), err)
-		return message.Message, nil // Fallback to just the current message
+		return nil, nil, err
 	}
 
-	// Build context string
-	var contextBuilder strings.Builder
-	contextBuilder.WriteString("Previous conversation context:\n\n")
-
 	// Sort posts by timestamp
-	for i := 0; i < len(posts); i++ {
-		for j := i + 1; j < len(posts); j++ {
-			if posts[i].Timestamp > posts[j].Timestamp {
-				posts[i], posts[j] = posts[j], posts[i]
-			}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].Timestamp < posts[j].Timestamp
+	})
+
+	history := make([]*types.Message, 0, len(posts))
+	for _, p := range posts {
+		if p.ID != excludeID {
+			history = append(history, p)
 		}
 	}
 
-	// Format each post with speaker identification
+	userIDs := make([]string, 0, len(history)+1)
+	for _, p := range history {
+		userIDs = append(userIDs, p.UserID)
+	}
+	if extraUserID != "" {
+		userIDs = append(userIDs, extraUserID)
+	}
+	return history, a.lookupUsers(userIDs), nil
+}
+
+// formatPost renders a post as "speaker: content"
+func (a *BotAgent) formatPost(p *types.Message, users map[string]*types.User) string {
+	return fmt.Sprintf("%s: %s\n", a.speaker(p, users), p.Content)
+}
+
+// speaker names the author of a post in prompts
+func (a *BotAgent) speaker(p *types.Message, users map[string]*types.User) string {
+	user, found := users[p.UserID]
+	if !found {
+		return "Unknown User"
+	} else if p.UserID == a.botUserID {
+		return a.botDisplayName // This is the bot
+	}
+	return user.Username
+}
+
+// promptPosts converts posts for prompt templates, escaped and screened for
+// prompt injection
+func (a *BotAgent) promptPosts(posts []*types.Message, users map[string]*types.User) []prompts.Post {
+	converted := make([]prompts.Post, 0, len(posts))
 	for _, p := range posts {
-		if p.ID == message.PostId {
-			continue // Skip the current message, we'll add it separately
-		}
+		post := a.screenPost(a.withTranscript(p))
+		post.Speaker = a.speaker(p, users)
+		converted = append(converted, post)
+	}
+	return converted
+}
 
-		// Get user info for this post
-		user, err := a.chat.GetUser(p.UserID)
-		var speaker string
-		if err != nil {
-			speaker = "Unknown User"
-		} else if p.UserID == a.botUserID {
-			speaker = a.botDisplayName // This is the bot
-		} else {
-			speaker = user.Username
+// estimateTokens approximates the token count of text (roughly 4 chars per token)
+func estimateTokens(text string) int {
+	return len(text)/4 + 1
+}
+
+// boundHistory splits chronologically sorted posts into the older ones that
+// don't fit the context budget and the most recent ones that do
+func (a *BotAgent) boundHistory(posts []*types.Message) (elided, kept []*types.Message) {
+	tokens := 0
+	start := len(posts)
+	for start > 0 {
+		cost := estimateTokens(posts[start-1].Content)
+		if len(posts)-start >= a.contextMaxMessages || tokens+cost > a.contextMaxTokens {
+			break
 		}
+		tokens += cost
+		start--
+	}
+	return posts[:start], posts[start:]
+}
+
+// threadSummary is a rolling summary of the oldest messages of a thread
+type threadSummary struct {
+	text    string
+	covered int // number of leading posts included in text
+}
 
-		contextBuilder.WriteString(fmt.Sprintf("%s: %s\n", speaker, p.Content))
+// summarizeElided returns a summary of posts that fell out of the context window.
+// Summaries are cached per thread and extended incrementally as more posts are elided.
+func (a *BotAgent) summarizeElided(ctx context.Context, threadID string, elided []*types.Message, users map[string]*types.User) string {
+	a.summariesMu.Lock()
+	cached := a.threadSummaries[threadID]
+	a.summariesMu.Unlock()
+
+	if cached.covered == len(elided) && cached.text != "" {
+		return cached.text
 	}
 
-	// Add current message with speaker info
-	if message.UserId != "" {
-		user, err := a.chat.GetUser(message.UserId)
-		var speaker string
-		if err != nil {
-			speaker = "User"
-		} else {
-			speaker = user.Username
+	start := 0
+	if cached.covered > 0 && cached.covered < len(elided) {
+		start = cached.covered
+	}
+
+	data := prompts.ContextSummaryData{Posts: a.promptPosts(elided[start:], users)}
+	if start > 0 {
+		data.Previous = cached.text
+	}
+
+	prompt, err := a.prompts.Render(prompts.ContextSummary, data)
+	var summary string
+	if err == nil {
+		summary, err = a.promptDecisionLLM(ctx, "context_summary", prompt)
+	}
+	if err != nil {
+		reqid.Logf(ctx, "THREAD: Failed to summarize %d elided posts: %v", len(elided), err)
+		if cached.text != "" {
+			return cached.text
 		}
-		contextBuilder.WriteString(fmt.Sprintf("\n%s: %s", speaker, message.Message))
-	} else {
-		contextBuilder.WriteString(fmt.Sprintf("\nUser: %s", message.Message))
+		return fmt.Sprintf("(%d earlier messages omitted)", len(elided))
+	}
+
+	summary = strings.TrimSpace(summary)
+	a.summariesMu.Lock()
+	a.threadSummaries[threadID] = threadSummary{text: summary, covered: len(elided)}
+	a.summariesMu.Unlock()
+
+	reqid.Logf(ctx, "THREAD: Summarized %d elided posts for thread %s", len(elided), threadID)
+	return summary
+}
+
+// maxConcurrentUserLookups bounds parallel GetUser calls while building context
+const maxConcurrentUserLookups = 8
+
+// lookupUsers fetches the distinct users concurrently; users that fail to load are omitted
+func (a *BotAgent) lookupUsers(userIDs []string) map[string]*types.User {
+	unique := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		if id != "" {
+			unique[id] = true
+		}
+	}
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, maxConcurrentUserLookups)
+		users = make(map[string]*types.User, len(unique))
+	)
+
+	for id := range unique {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(userID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			user, err := a.chat.GetUser(userID)
+			if err != nil {
+				log.Printf("[%s] THREAD: Failed to look up user %s: %v", time.Now().Format("2006-01-02 15:04:05"), userID, err)
+				return
+			}
+
+			mu.Lock()
+			users[userID] = user
+			mu.Unlock()
+		}(id)
 	}
+	wg.Wait()
 
-	result := contextBuilder.String()
-	log.Printf("[%s] THREAD: Built context with %d posts (%d chars)", time.Now().Format("2006-01-02 15:04:05"), len(posts), len(result))
-	return result, nil
+	return users
+}
+
+// handleThreadsCommand implements "!threads"
+func (a *BotAgent) handleThreadsCommand(message types.PostedMessage, args []string) string {
+	threadIDs := a.activeThreadIDs()
+	if len(threadIDs) == 0 {
+		return "Not participating in any threads."
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("**Active threads (%d)**\n", len(threadIDs)))
+	for _, threadID := range threadIDs {
+		preview := ""
+		if root, err := a.chat.GetMessage(threadID); err == nil {
+			preview = root.Content
+			if len(preview) > 60 {
+				preview = preview[:60] + "…"
+			}
+		}
+		if state, ok := a.threadState(threadID); ok && !state.LastPostAt.IsZero() {
+			preview += fmt.Sprintf(" _(last message %s)_", state.LastPostAt.Format("Jan 2 15:04"))
+		}
+		b.WriteString(fmt.Sprintf("- `%s` %s\n", threadID, strings.ReplaceAll(preview, "\n", " ")))
+	}
+	return b.String()
 }
 
 func (a *BotAgent) cleanupStaleThreads() {
-	// Clean up stale thread tracking every 10 minutes
-	if time.Since(a.lastCleanup) < 10*time.Minute {
+	// Clean up stale thread tracking every 10 minutes. Messages arrive
+	// concurrently, so the first to see the interval pass claims the run.
+	a.threadsMu.Lock()
+	if a.now().Sub(a.lastCleanup) < 10*time.Minute {
+		a.threadsMu.Unlock()
 		return
 	}
+	a.lastCleanup = a.now()
+	a.threadsMu.Unlock()
 
 	log.Printf("[%s] CLEANUP: Cleaning up stale thread references", time.Now().Format("2006-01-02 15:04:05"))
 
-	// Test a few thread IDs to see if they're still accessible
+	// Leave threads that went quiet
+	a.expireThreads()
+
+	// Test a few thread IDs to see if they're still accessible, in random
+	// order so every thread gets checked over time
+	threadIDs := a.activeThreadIDs()
+	rand.Shuffle(len(threadIDs), func(i, j int) { threadIDs[i], threadIDs[j] = threadIDs[j], threadIDs[i] })
 	staleThreads := make([]string, 0)
 	count := 0
-	for threadId := range a.activeThreads {
+	for _, threadId := range threadIDs {
 		if count >= 5 { // Only check first 5 to avoid too many API calls
 			break
 		}
@@ -453,10 +1254,15 @@ func (a *BotAgent) cleanupStaleThreads() {
 
 	// Remove stale threads
 	for _, threadId := range staleThreads {
-		delete(a.activeThreads, threadId)
+		a.leaveThread(threadId)
+		a.summariesMu.Lock()
+		delete(a.threadSummaries, threadId)
+		a.summariesMu.Unlock()
 		log.Printf("[%s] CLEANUP: Removed stale thread %s", time.Now().Format("2006-01-02 15:04:05"), threadId)
 	}
 
-	a.lastCleanup = time.Now()
-	log.Printf("[%s] CLEANUP: Completed, %d active threads remaining", time.Now().Format("2006-01-02 15:04:05"), len(a.activeThreads))
-}
\ No newline at end of file
+	// Turn tracking only matters while a conversation is going
+	a.turns.cleanup(a.now(), max(a.turns.cooldown, 24*time.Hour))
+
+	log.Printf("[%s] CLEANUP: Completed, %d active threads remaining", time.Now().Format("2006-01-02 15:04:05"), len(a.activeThreadIDs()))
+}
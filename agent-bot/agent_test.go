@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"agent-bot/agenttest"
+)
+
+const (
+	testBotUserID   = "bot"
+	testBotUsername = "agent"
+)
+
+// newTestAgent attaches an agent with default settings to a fresh harness
+func newTestAgent(t *testing.T) *agenttest.Harness {
+	t.Helper()
+	h := agenttest.New(testBotUserID, testBotUsername)
+	agent := NewBotAgent(testBotUserID, testBotUsername, "Agent", h.LLM, h.DecisionLLM, h.Chat)
+	agent.SetClock(h.Clock.Now)
+	h.Attach(agent)
+	return h
+}
+
+func TestRepliesToMention(t *testing.T) {
+	h := newTestAgent(t)
+	h.LLM.Queue("Deploys go out every Tuesday.")
+
+	question := h.Post("alice", "@agent when do deploys go out?")
+
+	posts := h.Chat.Posts()
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts, want 1", len(posts))
+	}
+	if posts[0].Content != "Deploys go out every Tuesday." {
+		t.Errorf("reply = %q", posts[0].Content)
+	}
+	if posts[0].ThreadID != question.PostId {
+		t.Errorf("reply thread = %q, want %q", posts[0].ThreadID, question.PostId)
+	}
+}
+
+func TestFollowsUpInActiveThread(t *testing.T) {
+	h := newTestAgent(t)
+	h.LLM.Queue("Deploys go out every Tuesday.", "Yes, the freeze starts on the 20th.")
+	h.DecisionLLM.Default("YES")
+
+	question := h.Post("alice", "@agent when do deploys go out?")
+	h.Reply("alice", question.PostId, "is there a freeze over the holidays?")
+
+	posts := h.Chat.Posts()
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want 2", len(posts))
+	}
+	if posts[1].Content != "Yes, the freeze starts on the 20th." {
+		t.Errorf("follow-up reply = %q", posts[1].Content)
+	}
+	if posts[1].ThreadID != question.PostId {
+		t.Errorf("follow-up thread = %q, want %q", posts[1].ThreadID, question.PostId)
+	}
+	if calls := h.DecisionLLM.Calls(); len(calls) != 1 {
+		t.Errorf("decision LLM called %d times, want 1", len(calls))
+	}
+}
+
+func TestSkipsUnaddressedMessage(t *testing.T) {
+	h := newTestAgent(t)
+	h.LLM.Default("unexpected reply")
+
+	h.Post("alice", "lunch anyone?")
+
+	if posts := h.Chat.Posts(); len(posts) != 0 {
+		t.Fatalf("got %d posts, want none: %+v", len(posts), posts)
+	}
+	if calls := h.LLM.Calls(); len(calls) != 0 {
+		t.Errorf("LLM called %d times, want none", len(calls))
+	}
+}
@@ -0,0 +1,397 @@
+package agenttest
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"agent-bot/types"
+)
+
+// Post is a message the bot posted, with its latest content
+type Post struct {
+	ID        string
+	ChannelID string
+	ThreadID  string
+	Content   string
+
+	// Updates counts UpdateMessage calls on the post, e.g. while streaming
+	Updates int
+	// Attachments are the cards posted with the message
+	Attachments []types.Attachment
+}
+
+// Ephemeral is a message the bot showed to one user
+type Ephemeral struct {
+	UserID    string
+	ChannelID string
+	ThreadID  string
+	Content   string
+}
+
+// Typing is one typing indicator the bot sent
+type Typing struct {
+	ChannelID string
+	ThreadID  string
+}
+
+// Chat is an in-memory types.Chat. Messages from users are added with
+// AddMessage (the Harness helpers do this), the bot's posts are recorded and
+// every message is visible through GetMessage and GetThreadMessages.
+type Chat struct {
+	mu        sync.Mutex
+	botUserID string
+	nextID    int
+	now       func() int64
+
+	messages map[string]*types.Message
+	order    []string
+	users    map[string]*types.User
+	channels map[string]*types.Channel
+	images   map[string]types.Image
+	audio    map[string]types.Audio
+
+	posts       []string
+	updates     map[string]int
+	attachments map[string][]types.Attachment
+	typing      []Typing
+	uploads     []types.FileUpload
+	// ephemerals aren't messages, so threads and GetMessage don't see them
+	ephemerals []Ephemeral
+	// reactions are the bot's current reactions per message, in the order added
+	reactions map[string][]string
+
+	postErr   error
+	updateErr error
+}
+
+// NewChat creates an empty chat where the bot is botUserID
+func NewChat(botUserID string) *Chat {
+	return &Chat{
+		botUserID: botUserID,
+		now:       func() int64 { return 0 },
+		messages:  make(map[string]*types.Message),
+		users:     make(map[string]*types.User),
+		channels:  make(map[string]*types.Channel),
+		images:    make(map[string]types.Image),
+		audio:     make(map[string]types.Audio),
+		updates:   make(map[string]int),
+		reactions: make(map[string][]string),
+
+		attachments: make(map[string][]types.Attachment),
+	}
+}
+
+// AddUser makes a user known to GetUser
+func (c *Chat) AddUser(user types.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[user.ID] = &user
+}
+
+// AddChannel makes a channel's details known to GetChannel
+func (c *Chat) AddChannel(channel types.Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.channels[channel.ID] = &channel
+}
+
+// AddImage makes an image downloadable under fileID
+func (c *Chat) AddImage(fileID string, image types.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.images[fileID] = image
+}
+
+// AddAudio makes an audio file downloadable under fileID
+func (c *Chat) AddAudio(fileID string, audio types.Audio) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.audio[fileID] = audio
+}
+
+// AddMessage records a message from a user and returns its ID. An empty ID is
+// assigned; an empty Timestamp is taken from the chat's clock.
+func (c *Chat) AddMessage(message types.Message) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addLocked(message)
+}
+
+// FailPosts makes PostMessage return err until called again with nil
+func (c *Chat) FailPosts(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.postErr = err
+}
+
+// FailUpdates makes UpdateMessage return err until called again with nil
+func (c *Chat) FailUpdates(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updateErr = err
+}
+
+// Delete removes a message, as if a user deleted it; later updates to it
+// fail with types.ErrMessageDeleted
+func (c *Chat) Delete(messageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.messages, messageID)
+}
+
+// Posts returns the bot's posts in the order they were made
+func (c *Chat) Posts() []Post {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	posts := make([]Post, 0, len(c.posts))
+	for _, id := range c.posts {
+		posts = append(posts, c.postLocked(id))
+	}
+	return posts
+}
+
+// LastPost returns the bot's most recent post, or a zero Post if it hasn't posted
+func (c *Chat) LastPost() Post {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.posts) == 0 {
+		return Post{}
+	}
+	return c.postLocked(c.posts[len(c.posts)-1])
+}
+
+// TypingIndicators returns every typing indicator sent, oldest first
+func (c *Chat) TypingIndicators() []Typing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Typing(nil), c.typing...)
+}
+
+// Uploads returns every file the bot uploaded, oldest first
+func (c *Chat) Uploads() []types.FileUpload {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]types.FileUpload(nil), c.uploads...)
+}
+
+// UploadFile records the file; its message counts as a post by the bot
+func (c *Chat) UploadFile(upload types.FileUpload) error {
+	if _, err := c.PostMessage(types.ChatMessage{ChannelId: upload.ChannelId, ThreadId: upload.ThreadId, Message: upload.Message}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.uploads = append(c.uploads, upload)
+	return nil
+}
+
+// Ephemerals returns the messages the bot showed to single users, oldest first
+func (c *Chat) Ephemerals() []Ephemeral {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Ephemeral(nil), c.ephemerals...)
+}
+
+// PostEphemeral records a message shown to one user. It fails like
+// PostMessage when FailPosts is set.
+func (c *Chat) PostEphemeral(userID string, message types.ChatMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.postErr != nil {
+		return c.postErr
+	}
+	c.ephemerals = append(c.ephemerals, Ephemeral{UserID: userID, ChannelID: message.ChannelId, ThreadID: message.ThreadId, Content: message.Message})
+	return nil
+}
+
+// Reactions returns the bot's current reactions to a message
+func (c *Chat) Reactions(messageID string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.reactions[messageID]...)
+}
+
+// AddReaction records the bot's reaction
+func (c *Chat) AddReaction(messageID, emoji string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.messages[messageID]; !ok {
+		return fmt.Errorf("no message %s", messageID)
+	}
+	if !slices.Contains(c.reactions[messageID], emoji) {
+		c.reactions[messageID] = append(c.reactions[messageID], emoji)
+	}
+	return nil
+}
+
+// RemoveReaction removes the bot's reaction
+func (c *Chat) RemoveReaction(messageID, emoji string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reactions[messageID] = slices.DeleteFunc(c.reactions[messageID], func(e string) bool { return e == emoji })
+	return nil
+}
+
+// PostMessage records a post by the bot
+func (c *Chat) PostMessage(message types.ChatMessage) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.postErr != nil {
+		return "", c.postErr
+	}
+	if message.ThreadId != "" {
+		if _, ok := c.messages[message.ThreadId]; !ok {
+			return "", fmt.Errorf("no thread %s", message.ThreadId)
+		}
+	}
+	id := c.addLocked(types.Message{
+		UserID:    c.botUserID,
+		ChannelID: message.ChannelId,
+		ThreadID:  message.ThreadId,
+		Content:   message.Message,
+	})
+	c.posts = append(c.posts, id)
+	if len(message.Attachments) > 0 {
+		c.attachments[id] = message.Attachments
+	}
+	return id, nil
+}
+
+// UpdateMessage replaces a message's content
+func (c *Chat) UpdateMessage(messageID string, newContent string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.updateErr != nil {
+		return c.updateErr
+	}
+	message, ok := c.messages[messageID]
+	if !ok {
+		return types.ErrMessageDeleted
+	}
+	message.Content = newContent
+	c.updates[messageID]++
+	return nil
+}
+
+// SendTypingIndicator records the indicator
+func (c *Chat) SendTypingIndicator(channelID, threadID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.typing = append(c.typing, Typing{ChannelID: channelID, ThreadID: threadID})
+	return nil
+}
+
+// GetMessage returns a copy of a recorded message
+func (c *Chat) GetMessage(messageID string) (*types.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	message, ok := c.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("no message %s", messageID)
+	}
+	copied := *message
+	return &copied, nil
+}
+
+// GetThreadMessages returns a thread's root and replies, oldest first
+func (c *Chat) GetThreadMessages(threadID string) ([]*types.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	root, ok := c.messages[threadID]
+	if !ok {
+		return nil, fmt.Errorf("no thread %s", threadID)
+	}
+	copiedRoot := *root
+	result := []*types.Message{&copiedRoot}
+	for _, id := range c.order {
+		if message, ok := c.messages[id]; ok && message.ThreadID == threadID {
+			copied := *message
+			result = append(result, &copied)
+		}
+	}
+	return result, nil
+}
+
+// GetUser returns a user added with AddUser. The bot and unknown IDs are
+// answered with a user named after the ID, so tests only add users whose
+// names matter.
+func (c *Chat) GetUser(userID string) (*types.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if user, ok := c.users[userID]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return &types.User{ID: userID, Username: userID, IsBot: userID == c.botUserID}, nil
+}
+
+// GetChannel returns a channel added with AddChannel; unknown IDs are
+// answered with a channel named after the ID and no purpose or header
+func (c *Chat) GetChannel(channelID string) (*types.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if channel, ok := c.channels[channelID]; ok {
+		copied := *channel
+		return &copied, nil
+	}
+	return &types.Channel{ID: channelID, Name: channelID, DisplayName: channelID}, nil
+}
+
+// GetImages returns images added with AddImage; other file IDs are skipped
+func (c *Chat) GetImages(fileIDs []string) ([]types.Image, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var images []types.Image
+	for _, id := range fileIDs {
+		if image, ok := c.images[id]; ok {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// GetAudio returns audio added with AddAudio; other file IDs are skipped
+func (c *Chat) GetAudio(fileIDs []string) ([]types.Audio, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var audio []types.Audio
+	for _, id := range fileIDs {
+		if file, ok := c.audio[id]; ok {
+			audio = append(audio, file)
+		}
+	}
+	return audio, nil
+}
+
+// edit changes a message's content without counting it as a bot update
+func (c *Chat) edit(messageID, content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if message, ok := c.messages[messageID]; ok {
+		message.Content = content
+	}
+}
+
+func (c *Chat) addLocked(message types.Message) string {
+	if message.ID == "" {
+		c.nextID++
+		message.ID = fmt.Sprintf("post-%d", c.nextID)
+	}
+	if message.Timestamp == 0 {
+		message.Timestamp = c.now()
+	}
+	c.messages[message.ID] = &message
+	c.order = append(c.order, message.ID)
+	return message.ID
+}
+
+func (c *Chat) postLocked(id string) Post {
+	post := Post{ID: id, Updates: c.updates[id], Attachments: c.attachments[id]}
+	if message, ok := c.messages[id]; ok {
+		post.ChannelID = message.ChannelID
+		post.ThreadID = message.ThreadID
+		post.Content = message.Content
+	}
+	return post
+}
@@ -0,0 +1,53 @@
+// Package agenttest provides scripted fakes of the chat and LLM interfaces,
+// a controllable clock and helpers that drive an agent's MessagePosted, so
+// agent behavior can be exercised without a chat server or model API.
+//
+// A typical test wires a harness into the agent under test:
+//
+//	h := agenttest.New("bot-id", "agent")
+//	agent := NewBotAgent("bot-id", "agent", "Agent", h.LLM, h.DecisionLLM, h.Chat)
+//	agent.SetClock(h.Clock.Now)
+//	h.Attach(agent)
+//
+//	h.LLM.Queue("Hello!")
+//	h.DM("alice", "hi")
+//	if got := h.Chat.LastPost().Content; got != "Hello!" { ... }
+package agenttest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a manually advanced clock. Its Now method can stand in for
+// time.Now wherever the code under test accepts a clock.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock creates a clock stopped at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the clock's current time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
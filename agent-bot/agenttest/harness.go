@@ -0,0 +1,120 @@
+package agenttest
+
+import (
+	"strings"
+	"time"
+
+	"agent-bot/types"
+)
+
+// Channels the harness helpers post to
+const (
+	Channel   = "town-square"
+	DMChannel = "dm"
+)
+
+// Harness bundles the fakes an agent needs and drives its MessagePosted.
+// Each helper records the user's message in Chat before delivering it, so
+// the agent sees it in thread history just as it would on a real server.
+type Harness struct {
+	Chat        *Chat
+	LLM         *LLM
+	DecisionLLM *LLM
+	Clock       *Clock
+
+	botUsername string
+	agent       types.Agent
+}
+
+// New creates a harness for a bot with the given user ID and username. The
+// clock starts at a fixed time so timestamps are reproducible.
+func New(botUserID, botUsername string) *Harness {
+	clock := NewClock(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC))
+	chat := NewChat(botUserID)
+	chat.now = func() int64 { return clock.Now().UnixMilli() }
+	return &Harness{
+		Chat:        chat,
+		LLM:         NewLLM(),
+		DecisionLLM: NewLLM(),
+		Clock:       clock,
+		botUsername: botUsername,
+	}
+}
+
+// Attach sets the agent the helpers deliver messages to
+func (h *Harness) Attach(agent types.Agent) {
+	h.agent = agent
+}
+
+// Post has userID post text in a channel. The message counts as a mention
+// when it contains @<bot username>.
+func (h *Harness) Post(userID, text string) types.PostedMessage {
+	return h.Deliver(types.PostedMessage{UserId: userID, ChannelId: Channel, Message: text})
+}
+
+// Reply has userID reply in the thread rooted at threadID
+func (h *Harness) Reply(userID, threadID, text string) types.PostedMessage {
+	channelID := Channel
+	if root, err := h.Chat.GetMessage(threadID); err == nil {
+		channelID = root.ChannelID
+	}
+	return h.Deliver(types.PostedMessage{
+		UserId:    userID,
+		ChannelId: channelID,
+		ThreadId:  threadID,
+		Message:   text,
+		IsDM:      channelID == DMChannel,
+	})
+}
+
+// DM sends the bot a direct message from userID
+func (h *Harness) DM(userID, text string) types.PostedMessage {
+	return h.Deliver(types.PostedMessage{UserId: userID, ChannelId: DMChannel, Message: text, IsDM: true})
+}
+
+// Deliver records message in Chat, filling in its PostId and Mentioned when
+// unset, and hands it to the agent. MessagePosted is synchronous, so the
+// agent's reply has been posted by the time Deliver returns.
+func (h *Harness) Deliver(message types.PostedMessage) types.PostedMessage {
+	if h.agent == nil {
+		panic("agenttest: Deliver called before Attach")
+	}
+	message.PostId = h.Chat.AddMessage(types.Message{
+		ID:        message.PostId,
+		UserID:    message.UserId,
+		ChannelID: message.ChannelId,
+		ThreadID:  message.ThreadId,
+		Content:   message.Message,
+	})
+	if !message.Mentioned && h.botUsername != "" {
+		message.Mentioned = strings.Contains(message.Message, "@"+h.botUsername)
+	}
+	h.agent.MessagePosted(message)
+	return message
+}
+
+// EditBotPost edits one of the bot's posts as a user would, and reports it
+func (h *Harness) EditBotPost(messageID, content string) {
+	h.Chat.edit(messageID, content)
+	h.agent.MessageEdited(messageID, content)
+}
+
+// DeleteBotPost deletes one of the bot's posts and reports it
+func (h *Harness) DeleteBotPost(messageID string) {
+	h.Chat.Delete(messageID)
+	h.agent.MessageDeleted(messageID)
+}
+
+// Join reports that the bot was added to channelID
+func (h *Harness) Join(channelID string) {
+	h.agent.ChannelJoined(channelID)
+}
+
+// React reports that userID added an emoji reaction to a message
+func (h *Harness) React(userID, messageID, emoji string) {
+	reaction := types.Reaction{PostId: messageID, UserId: userID, Emoji: emoji}
+	if message, err := h.Chat.GetMessage(messageID); err == nil {
+		reaction.ChannelId = message.ChannelID
+	}
+	h.agent.ReactionAdded(reaction)
+}
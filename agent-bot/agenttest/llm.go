@@ -0,0 +1,161 @@
+package agenttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"agent-bot/types"
+)
+
+// ErrUnscripted is returned for a prompt the LLM has no reply for
+var ErrUnscripted = errors.New("agenttest: no scripted reply for prompt")
+
+// Reply is one scripted LLM answer
+type Reply struct {
+	Text string
+	Err  error
+}
+
+type rule struct {
+	substring string
+	reply     Reply
+}
+
+// LLM is a scripted types.LLM. A prompt is answered by the first rule whose
+// substring it contains, then by the next queued reply, then by the default;
+// with none of those it fails with ErrUnscripted. Every prompt is recorded.
+type LLM struct {
+	mu       sync.Mutex
+	rules    []rule
+	queue    []Reply
+	fallback *Reply
+	calls    []string
+
+	// ChunkSize splits streamed replies into chunks of this many runes; zero
+	// streams each reply as a single chunk
+	ChunkSize int
+}
+
+// NewLLM creates an LLM with nothing scripted
+func NewLLM() *LLM {
+	return &LLM{}
+}
+
+// Queue adds replies to be returned in order, one per prompt
+func (l *LLM) Queue(replies ...string) *LLM {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, text := range replies {
+		l.queue = append(l.queue, Reply{Text: text})
+	}
+	return l
+}
+
+// QueueError makes the next queued prompt fail with err
+func (l *LLM) QueueError(err error) *LLM {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queue = append(l.queue, Reply{Err: err})
+	return l
+}
+
+// When answers every prompt containing substring with reply, ahead of the queue
+func (l *LLM) When(substring, reply string) *LLM {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = append(l.rules, rule{substring: substring, reply: Reply{Text: reply}})
+	return l
+}
+
+// Default answers prompts that no rule or queued reply covers
+func (l *LLM) Default(reply string) *LLM {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fallback = &Reply{Text: reply}
+	return l
+}
+
+// Calls returns every prompt received, oldest first
+func (l *LLM) Calls() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.calls...)
+}
+
+// LastCall returns the most recent prompt, or "" if there was none
+func (l *LLM) LastCall() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.calls) == 0 {
+		return ""
+	}
+	return l.calls[len(l.calls)-1]
+}
+
+// Pending returns how many queued replies have not been used yet
+func (l *LLM) Pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queue)
+}
+
+// Prompt records the prompt and returns its scripted reply
+func (l *LLM) Prompt(ctx context.Context, message string) (string, error) {
+	reply := l.next(message)
+	return reply.Text, reply.Err
+}
+
+// PromptStream records the prompt and streams its scripted reply. A scripted
+// error fails the call itself rather than arriving as a chunk.
+func (l *LLM) PromptStream(ctx context.Context, message string) (<-chan types.StreamChunk, error) {
+	reply := l.next(message)
+	if reply.Err != nil {
+		return nil, reply.Err
+	}
+
+	chunks := splitChunks(reply.Text, l.ChunkSize)
+	chunkChan := make(chan types.StreamChunk, len(chunks)+1)
+	for _, chunk := range chunks {
+		chunkChan <- types.StreamChunk{Content: chunk}
+	}
+	chunkChan <- types.StreamChunk{Done: true}
+	close(chunkChan)
+	return chunkChan, nil
+}
+
+func (l *LLM) next(message string) Reply {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, message)
+
+	for _, r := range l.rules {
+		if strings.Contains(message, r.substring) {
+			return r.reply
+		}
+	}
+	if len(l.queue) > 0 {
+		reply := l.queue[0]
+		l.queue = l.queue[1:]
+		return reply
+	}
+	if l.fallback != nil {
+		return *l.fallback
+	}
+	return Reply{Err: fmt.Errorf("%w: %.80q", ErrUnscripted, message)}
+}
+
+func splitChunks(text string, size int) []string {
+	runes := []rune(text)
+	if size <= 0 || len(runes) <= size {
+		return []string{text}
+	}
+	var chunks []string
+	for len(runes) > size {
+		chunks = append(chunks, string(runes[:size]))
+		runes = runes[size:]
+	}
+	return append(chunks, string(runes))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-bot/apikeys"
+	"agent-bot/llms"
+	"agent-bot/reqid"
+	"agent-bot/tools"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// apiMessageRequest is the body accepted by POST /api/v1/messages.
+// Either Message is posted verbatim, or Prompt is sent to the LLM and its
+// answer is posted.
+type apiMessageRequest struct {
+	ChannelID string `json:"channel_id"`
+	ThreadID  string `json:"thread_id,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Prompt    string `json:"prompt,omitempty"`
+}
+
+type apiMessageResponse struct {
+	PostID  string `json:"post_id"`
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// authenticateAPIKey resolves the bearer token on the request and applies its rate limit
+func (b *Bot) authenticateAPIKey(w http.ResponseWriter, r *http.Request) (*apikeys.Key, bool) {
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "missing API key"})
+		return nil, false
+	}
+
+	key, err := b.apiKeys.Authenticate(token)
+	if err != nil {
+		if !errors.Is(err, apikeys.ErrInvalidKey) && !errors.Is(err, apikeys.ErrRevokedKey) {
+			log.Printf("[%s] API: Failed to authenticate key: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: err.Error()})
+		return nil, false
+	}
+
+	if !b.apiKeys.Allow(key) {
+		w.Header().Set("Retry-After", "60")
+		writeJSON(w, http.StatusTooManyRequests, apiError{Error: "rate limit exceeded"})
+		return nil, false
+	}
+
+	return key, true
+}
+
+// apiRequestContext gives an API request its correlation ID. Callers can pass
+// their own to find the request in our logs.
+func apiRequestContext(w http.ResponseWriter, r *http.Request) context.Context {
+	ctx := reqid.With(r.Context(), reqid.New())
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 64 {
+		ctx = reqid.With(r.Context(), id)
+	}
+	w.Header().Set("X-Request-ID", reqid.From(ctx))
+	return ctx
+}
+
+func (b *Bot) handleAPIMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	key, ok := b.authenticateAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := apiRequestContext(w, r)
+
+	var req apiMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON body"})
+		return
+	}
+
+	if req.ChannelID == "" || (req.Message == "" && req.Prompt == "") {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "channel_id and one of message or prompt are required"})
+		return
+	}
+
+	if !key.CanPostTo(req.ChannelID) {
+		log.Printf("[%s] API: Key %s denied access to channel %s", time.Now().Format("2006-01-02 15:04:05"), key.ID, req.ChannelID)
+		writeJSON(w, http.StatusForbidden, apiError{Error: "API key is not scoped to this channel"})
+		return
+	}
+
+	content := req.Message
+	if req.Prompt != "" {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		defer cancel()
+		if !key.Scope.AllowTools {
+			ctx = llms.WithoutTools(ctx)
+		}
+		// Attribute usage to the key so API traffic shows up in exports
+		ctx = tools.WithRequest(ctx, tools.Request{UserID: "apikey:" + key.ID, ChannelID: req.ChannelID, ThreadID: req.ThreadID})
+
+		reqid.Logf(ctx, "API: Key %s prompting LLM for channel %s (tools: %v)", key.ID, req.ChannelID, key.Scope.AllowTools)
+		response, err := b.llmBackend.Prompt(ctx, req.Prompt)
+		if err != nil {
+			reqid.Logf(ctx, "API: LLM request failed: %v", err)
+			writeJSON(w, http.StatusBadGateway, apiError{Error: "LLM request failed"})
+			return
+		}
+		content = response
+	}
+
+	post, _, err := b.client.CreatePost(&model.Post{
+		ChannelId: req.ChannelID,
+		RootId:    req.ThreadID,
+		Message:   content,
+	})
+	if err != nil {
+		reqid.Logf(ctx, "API: Failed to post message: %v", err)
+		writeJSON(w, http.StatusBadGateway, apiError{Error: "failed to post message"})
+		return
+	}
+
+	reqid.Logf(ctx, "API: Key %s posted message %s to channel %s", key.ID, post.Id, req.ChannelID)
+	writeJSON(w, http.StatusOK, apiMessageResponse{PostID: post.Id, Message: content})
+}
+
+// handleAPIKeyCommand implements "!apikey create|revoke|list"
+func (b *Bot) handleAPIKeyCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!apikey create <name> [channels=id1,id2] [tools=true] [rate=60]`, `!apikey revoke <id>`, `!apikey list`"
+	if len(args) == 0 {
+		return usage
+	}
+
+	positional, options := parseCommandOptions(args[1:])
+
+	switch strings.ToLower(args[0]) {
+	case "create":
+		if len(positional) != 1 {
+			return usage
+		}
+
+		scope := apikeys.Scope{AllowTools: options["tools"] == "true"}
+		if channels := options["channels"]; channels != "" {
+			scope.Channels = strings.Split(channels, ",")
+		}
+		if rate := options["rate"]; rate != "" {
+			limit, err := strconv.Atoi(rate)
+			if err != nil || limit < 0 {
+				return "`rate` must be a non-negative number of requests per minute"
+			}
+			scope.RateLimit = limit
+		}
+
+		token, key, err := b.apiKeys.Create(positional[0], message.UserId, scope)
+		if err != nil {
+			return fmt.Sprintf("Failed to create API key: %v", err)
+		}
+		return fmt.Sprintf("Created API key `%s` (%s). Store this token now, it will not be shown again:\n```\n%s\n```", key.ID, key.Name, token)
+
+	case "revoke":
+		if len(positional) != 1 {
+			return usage
+		}
+		if err := b.apiKeys.Revoke(positional[0]); err != nil {
+			return fmt.Sprintf("Failed to revoke API key: %v", err)
+		}
+		return fmt.Sprintf("Revoked API key `%s`.", positional[0])
+
+	case "list":
+		keys := b.apiKeys.List()
+		if len(keys) == 0 {
+			return "No API keys have been created."
+		}
+
+		var sb strings.Builder
+		sb.WriteString("| ID | Name | Channels | Tools | Rate/min | Status |\n|---|---|---|---|---|---|\n")
+		for _, key := range keys {
+			channels := "any"
+			if len(key.Scope.Channels) > 0 {
+				channels = strings.Join(key.Scope.Channels, ", ")
+			}
+			status := "active"
+			if key.Revoked {
+				status = "revoked"
+			}
+			sb.WriteString(fmt.Sprintf("| `%s` | %s | %s | %v | %d | %s |\n", key.ID, key.Name, channels, key.Scope.AllowTools, key.Scope.RateLimit, status))
+		}
+		return sb.String()
+	}
+
+	return usage
+}
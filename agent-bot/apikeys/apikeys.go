@@ -0,0 +1,200 @@
+// Package apikeys manages the API keys that authenticate the external
+// message, completion, webhook and hook endpoints. Only the SHA-256 hash of
+// each key is stored; a key's Scope limits the channels it may post to,
+// whether the model may use tools and its requests per minute.
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/store"
+)
+
+const (
+	bucket      = "api_keys"
+	tokenPrefix = "mmab"
+)
+
+var (
+	ErrInvalidKey  = errors.New("invalid API key")
+	ErrRevokedKey  = errors.New("API key has been revoked")
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+// Scope restricts what an API key may do
+type Scope struct {
+	// Channels the key may post into; empty means any channel
+	Channels []string `json:"channels"`
+	// AllowTools lets prompts sent with this key use LLM tools
+	AllowTools bool `json:"allow_tools"`
+	// RateLimit is the maximum number of requests per minute; 0 means unlimited
+	RateLimit int `json:"rate_limit"`
+}
+
+// Key is a stored API key. Only the SHA-256 hash of the secret is kept.
+type Key struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scope     Scope     `json:"scope"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// CanPostTo reports whether the key is scoped to the given channel
+func (k *Key) CanPostTo(channelID string) bool {
+	if len(k.Scope.Channels) == 0 {
+		return true
+	}
+	for _, id := range k.Scope.Channels {
+		if id == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Manager creates, revokes and authenticates API keys
+type Manager struct {
+	store *store.Store
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+func NewManager(s *store.Store) *Manager {
+	return &Manager{
+		store:   s,
+		windows: make(map[string]*window),
+	}
+}
+
+// Create generates a new key and returns its plaintext token, which is never stored
+func (m *Manager) Create(name, createdBy string, scope Scope) (string, *Key, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := fmt.Sprintf("%s_%s_%s", tokenPrefix, id, secret)
+	key := &Key{
+		ID:        id,
+		Name:      name,
+		Hash:      hashToken(token),
+		Scope:     scope,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	if err := m.store.Put(bucket, id, key); err != nil {
+		return "", nil, err
+	}
+
+	return token, key, nil
+}
+
+// Revoke marks a key as revoked so it can no longer authenticate
+func (m *Manager) Revoke(id string) error {
+	var key Key
+	found, err := m.store.Get(bucket, id, &key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	key.Revoked = true
+	return m.store.Put(bucket, id, &key)
+}
+
+// List returns all keys, including revoked ones
+func (m *Manager) List() []Key {
+	var keys []Key
+	for _, id := range m.store.Keys(bucket) {
+		var key Key
+		if found, err := m.store.Get(bucket, id, &key); err == nil && found {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Authenticate resolves a plaintext token to its key
+func (m *Manager) Authenticate(token string) (*Key, error) {
+	parts := strings.Split(token, "_")
+	if len(parts) != 3 || parts[0] != tokenPrefix {
+		return nil, ErrInvalidKey
+	}
+
+	var key Key
+	found, err := m.store.Get(bucket, parts[1], &key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrInvalidKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashToken(token))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if key.Revoked {
+		return nil, ErrRevokedKey
+	}
+
+	return &key, nil
+}
+
+// Allow applies the key's per-minute rate limit, counting this request
+func (m *Manager) Allow(key *Key) bool {
+	if key.Scope.RateLimit <= 0 {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.windows[key.ID]
+	if !ok || time.Since(w.start) >= time.Minute {
+		w = &window{start: time.Now()}
+		m.windows[key.ID] = w
+	}
+
+	if w.count >= key.Scope.RateLimit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,151 @@
+package apikeys
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent-bot/store"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	s, err := store.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(s)
+}
+
+func TestCreateStoresOnlyTheHash(t *testing.T) {
+	m := newTestManager(t)
+	token, key, err := m.Create("ci", "admin", Scope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, tokenPrefix+"_"+key.ID+"_") {
+		t.Errorf("token %q doesn't start with the prefix and key ID", token)
+	}
+	for _, stored := range m.List() {
+		if strings.Contains(stored.Hash, token) || stored.Hash != hashToken(token) {
+			t.Errorf("stored hash = %q, want the SHA-256 of the token", stored.Hash)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	m := newTestManager(t)
+	token, key, err := m.Create("ci", "admin", Scope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedToken, revoked, err := m.Create("old", "admin", Scope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantID  string
+		wantErr error
+	}{
+		{name: "valid", token: token, wantID: key.ID},
+		{name: "wrong secret", token: tokenPrefix + "_" + key.ID + "_" + strings.Repeat("0", 48), wantErr: ErrInvalidKey},
+		{name: "unknown id", token: tokenPrefix + "_ffffffffffff_" + strings.Repeat("0", 48), wantErr: ErrInvalidKey},
+		{name: "wrong prefix", token: strings.Replace(token, tokenPrefix, "sk", 1), wantErr: ErrInvalidKey},
+		{name: "malformed", token: "not-a-key", wantErr: ErrInvalidKey},
+		{name: "empty", token: "", wantErr: ErrInvalidKey},
+		{name: "revoked", token: revokedToken, wantErr: ErrRevokedKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Authenticate(tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if got.ID != tt.wantID {
+				t.Errorf("Authenticate() key = %s, want %s", got.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestRevokeUnknownKey(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.Revoke("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Revoke() error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestCanPostTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels []string
+		channel  string
+		want     bool
+	}{
+		{name: "unscoped key", channel: "town-square", want: true},
+		{name: "channel in scope", channels: []string{"ops", "deploys"}, channel: "deploys", want: true},
+		{name: "channel out of scope", channels: []string{"ops"}, channel: "deploys", want: false},
+		{name: "missing channel on scoped key", channels: []string{"ops"}, channel: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &Key{Scope: Scope{Channels: tt.channels}}
+			if got := key.CanPostTo(tt.channel); got != tt.want {
+				t.Errorf("CanPostTo(%q) = %v, want %v", tt.channel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllow(t *testing.T) {
+	tests := []struct {
+		name      string
+		rateLimit int
+		requests  int
+		wantAllow int
+	}{
+		{name: "unlimited", rateLimit: 0, requests: 50, wantAllow: 50},
+		{name: "under the limit", rateLimit: 5, requests: 3, wantAllow: 3},
+		{name: "over the limit", rateLimit: 2, requests: 5, wantAllow: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			key := &Key{ID: "k1", Scope: Scope{RateLimit: tt.rateLimit}}
+			allowed := 0
+			for i := 0; i < tt.requests; i++ {
+				if m.Allow(key) {
+					allowed++
+				}
+			}
+			if allowed != tt.wantAllow {
+				t.Errorf("allowed %d of %d requests, want %d", allowed, tt.requests, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestAllowCountsKeysSeparately(t *testing.T) {
+	m := newTestManager(t)
+	first := &Key{ID: "k1", Scope: Scope{RateLimit: 1}}
+	second := &Key{ID: "k2", Scope: Scope{RateLimit: 1}}
+	if !m.Allow(first) || m.Allow(first) {
+		t.Fatal("first key should get exactly one request")
+	}
+	if !m.Allow(second) {
+		t.Fatal("second key was limited by the first key's requests")
+	}
+}
@@ -0,0 +1,147 @@
+// Package approvals queues side-effecting tool actions until an admin
+// approves or denies them by ID. Pending actions live in memory and expire
+// after the manager's TTL.
+package approvals
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"agent-bot/tools"
+)
+
+var ErrNotFound = errors.New("no pending action with that ID")
+
+// idBytes is the size of random action IDs, which admins type to approve
+const idBytes = 8
+
+// idAttempts bounds the retries when a new ID collides with a pending one
+const idAttempts = 5
+
+// RunFunc performs an approved action and returns a human-readable result
+type RunFunc func(ctx context.Context) (string, error)
+
+// Action is a side-effecting operation waiting for an admin's approval
+type Action struct {
+	ID          string
+	Description string
+	RequestedBy string
+	ChannelID   string
+	ThreadID    string
+	CreatedAt   time.Time
+
+	run RunFunc
+}
+
+// Manager holds pending actions until they are approved, denied or expire
+type Manager struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	actions map[string]*Action
+}
+
+func NewManager(ttl time.Duration) *Manager {
+	return &Manager{
+		ttl:     ttl,
+		actions: make(map[string]*Action),
+	}
+}
+
+// Request queues an action on behalf of the tool request's user
+func (m *Manager) Request(req tools.Request, description string, run RunFunc) (*Action, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked()
+
+	id, err := m.newIDLocked()
+	if err != nil {
+		return nil, err
+	}
+	action := &Action{
+		ID:          id,
+		Description: description,
+		RequestedBy: req.UserID,
+		ChannelID:   req.ChannelID,
+		ThreadID:    req.ThreadID,
+		CreatedAt:   time.Now(),
+		run:         run,
+	}
+	m.actions[id] = action
+
+	return action, nil
+}
+
+// newIDLocked returns a random ID no pending action has, so approving an
+// ID never runs someone else's action
+func (m *Manager) newIDLocked() (string, error) {
+	buf := make([]byte, idBytes)
+	for attempt := 0; attempt < idAttempts; attempt++ {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("failed to generate action ID: %w", err)
+		}
+		id := hex.EncodeToString(buf)
+		if _, taken := m.actions[id]; !taken {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate an unused action ID")
+}
+
+// Approve removes the action from the queue and runs it
+func (m *Manager) Approve(ctx context.Context, id string) (*Action, string, error) {
+	action, err := m.take(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result, err := action.run(ctx)
+	return action, result, err
+}
+
+// Deny removes the action from the queue without running it
+func (m *Manager) Deny(id string) (*Action, error) {
+	return m.take(id)
+}
+
+// Pending returns queued actions, oldest first
+func (m *Manager) Pending() []*Action {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked()
+
+	pending := make([]*Action, 0, len(m.actions))
+	for _, action := range m.actions {
+		pending = append(pending, action)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	return pending
+}
+
+func (m *Manager) take(id string) (*Action, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked()
+
+	action, ok := m.actions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(m.actions, id)
+	return action, nil
+}
+
+func (m *Manager) expireLocked() {
+	for id, action := range m.actions {
+		if time.Since(action.CreatedAt) > m.ttl {
+			delete(m.actions, id)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package approvals
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"agent-bot/tools"
+)
+
+var testRequest = tools.Request{UserID: "alice", ChannelID: "ops", ThreadID: "t1"}
+
+func TestApproveAndDeny(t *testing.T) {
+	tests := []struct {
+		name       string
+		approve    bool
+		runErr     error
+		wantRan    bool
+		wantResult string
+		wantErr    error
+	}{
+		{name: "approve runs the action", approve: true, wantRan: true, wantResult: "done"},
+		{name: "approve reports a failed run", approve: true, runErr: errors.New("boom"), wantRan: true, wantErr: errors.New("boom")},
+		{name: "deny doesn't run it", approve: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(time.Hour)
+			ran := false
+			action, err := m.Request(testRequest, "delete channel", func(ctx context.Context) (string, error) {
+				ran = true
+				return "done", tt.runErr
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if action.RequestedBy != "alice" || action.ChannelID != "ops" || action.ThreadID != "t1" {
+				t.Errorf("action = %+v, want it to carry the request", action)
+			}
+
+			if tt.approve {
+				_, result, err := m.Approve(context.Background(), action.ID)
+				if (err != nil) != (tt.wantErr != nil) {
+					t.Fatalf("Approve() error = %v, want %v", err, tt.wantErr)
+				}
+				if result != tt.wantResult && tt.wantErr == nil {
+					t.Errorf("Approve() result = %q, want %q", result, tt.wantResult)
+				}
+			} else if _, err := m.Deny(action.ID); err != nil {
+				t.Fatalf("Deny() error = %v", err)
+			}
+			if ran != tt.wantRan {
+				t.Errorf("ran = %v, want %v", ran, tt.wantRan)
+			}
+			if _, err := m.Deny(action.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("action still pending after it was handled: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnknownOrExpiredAction(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		id   func(*Action) string
+	}{
+		{name: "unknown id", ttl: time.Hour, id: func(*Action) string { return "0123456789abcdef" }},
+		{name: "expired", ttl: -time.Second, id: func(a *Action) string { return a.ID }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(tt.ttl)
+			action, err := m.Request(testRequest, "kick user", func(ctx context.Context) (string, error) {
+				t.Error("rejected action ran")
+				return "", nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := m.Approve(context.Background(), tt.id(action)); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Approve() error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestRequestGivesUniqueIDs(t *testing.T) {
+	m := NewManager(time.Hour)
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		action, err := m.Request(testRequest, "post", func(ctx context.Context) (string, error) { return "", nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(action.ID) != 2*idBytes {
+			t.Fatalf("ID %q has %d characters, want %d", action.ID, len(action.ID), 2*idBytes)
+		}
+		if seen[action.ID] {
+			t.Fatalf("ID %q handed out twice", action.ID)
+		}
+		seen[action.ID] = true
+	}
+	if got := len(m.Pending()); got != 200 {
+		t.Errorf("Pending() has %d actions, want 200", got)
+	}
+}
@@ -0,0 +1,66 @@
+package asana
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long workspaces, users and project lists are reused
+// before being fetched again
+const DefaultCacheTTL = 5 * time.Minute
+
+type cachedResponse struct {
+	body    []byte
+	expires time.Time
+}
+
+// responseCache is an in-memory TTL cache of response bodies by request path
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedResponse
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: make(map[string]cachedResponse)}
+}
+
+func (c *responseCache) get(path string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, path)
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (c *responseCache) put(path string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[path] = cachedResponse{body: body, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *responseCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+	c.entries = make(map[string]cachedResponse)
+}
+
+func (c *responseCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cachedResponse)
+}
@@ -1,17 +1,38 @@
+// Package asana is an Asana API client and the tools built on it. Requests
+// are paced client-side, 429s and GET server errors are retried, and
+// workspace, user and project lists are cached briefly.
 package asana
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-bot/metrics"
 )
 
 const BaseURL = "https://app.asana.com/api/1.0"
 
+// errPaymentRequired is returned for 402 responses, which Asana sends for
+// premium-only endpoints such as task search on free workspaces
+var errPaymentRequired = errors.New("payment required")
+
 type Client struct {
 	APIKey     string
 	HTTPClient *http.Client
+
+	cache   *responseCache
+	limiter *limiter
 }
 
 type ListProjectsArgs struct {
@@ -27,6 +48,16 @@ type ListUserTasksArgs struct {
 	WorkspaceGID string `json:"workspace_gid,omitempty" jsonschema_description:"The workspace GID to search within (optional - will use default workspace if only one exists)"`
 }
 
+type GetTaskArgs struct {
+	TaskGID string `json:"task_gid" jsonschema_description:"The task GID, from the task lists or an Asana task URL"`
+}
+
+type SearchTasksArgs struct {
+	Query        string `json:"query" jsonschema_description:"Words from the task name to search for"`
+	WorkspaceGID string `json:"workspace_gid,omitempty" jsonschema_description:"The workspace GID to search within (optional - will use default workspace if only one exists)"`
+	MaxResults   int    `json:"max_results,omitempty" jsonschema_description:"Maximum number of tasks to return (optional - default 20, max 50)"`
+}
+
 type ListUsersArgs struct {
 	WorkspaceGID string `json:"workspace_gid,omitempty" jsonschema_description:"The workspace GID to list users from (optional - will use default workspace if only one exists)"`
 }
@@ -54,6 +85,91 @@ type Task struct {
 	Notes     string `json:"notes"`
 }
 
+// TaskDetail is a task with its owner, schedule, projects, custom fields and subtasks
+type TaskDetail struct {
+	GID          string        `json:"gid"`
+	Name         string        `json:"name"`
+	Completed    bool          `json:"completed"`
+	CompletedAt  string        `json:"completed_at,omitempty"`
+	Assignee     string        `json:"assignee,omitempty"`
+	StartOn      string        `json:"start_on,omitempty"`
+	DueOn        string        `json:"due_on,omitempty"`
+	DueAt        string        `json:"due_at,omitempty"`
+	Projects     []string      `json:"projects,omitempty"`
+	CustomFields []CustomField `json:"custom_fields,omitempty"`
+	Notes        string        `json:"notes,omitempty"`
+	Subtasks     []TaskSummary `json:"subtasks,omitempty"`
+	URL          string        `json:"url,omitempty"`
+}
+
+// CustomField is a custom field value as Asana displays it
+type CustomField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// TaskSummary is a short view of a task, used for subtasks and search results
+type TaskSummary struct {
+	GID       string   `json:"gid"`
+	Name      string   `json:"name"`
+	Completed bool     `json:"completed"`
+	Assignee  string   `json:"assignee,omitempty"`
+	DueOn     string   `json:"due_on,omitempty"`
+	Projects  []string `json:"projects,omitempty"`
+}
+
+// taskFields are the opt_fields requested for a task's details
+const taskFields = "name,completed,completed_at,assignee.name,start_on,due_on,due_at,projects.name,custom_fields.name,custom_fields.display_value,notes,permalink_url"
+
+// subtaskFields are the opt_fields requested for each subtask
+const subtaskFields = "name,completed,assignee.name,due_on"
+
+// searchFields are the opt_fields requested for each search result
+const searchFields = "name,completed,assignee.name,due_on,projects.name"
+
+type named struct {
+	Name string `json:"name"`
+}
+
+type rawTask struct {
+	GID          string  `json:"gid"`
+	Name         string  `json:"name"`
+	Completed    bool    `json:"completed"`
+	CompletedAt  string  `json:"completed_at"`
+	Assignee     *named  `json:"assignee"`
+	StartOn      string  `json:"start_on"`
+	DueOn        string  `json:"due_on"`
+	DueAt        string  `json:"due_at"`
+	Projects     []named `json:"projects"`
+	CustomFields []struct {
+		Name         string  `json:"name"`
+		DisplayValue *string `json:"display_value"`
+	} `json:"custom_fields"`
+	Notes        string `json:"notes"`
+	PermalinkURL string `json:"permalink_url"`
+}
+
+func (t rawTask) summary() TaskSummary {
+	summary := TaskSummary{
+		GID:       t.GID,
+		Name:      t.Name,
+		Completed: t.Completed,
+		Assignee:  t.Assignee.name(),
+		DueOn:     t.DueOn,
+	}
+	for _, project := range t.Projects {
+		summary.Projects = append(summary.Projects, project.Name)
+	}
+	return summary
+}
+
+func (n *named) name() string {
+	if n == nil {
+		return ""
+	}
+	return n.Name
+}
+
 type ListResponse struct {
 	Data []json.RawMessage `json:"data"`
 }
@@ -65,13 +181,105 @@ func NewClient(apiKey string, httpClient *http.Client) *Client {
 	return &Client{
 		APIKey:     apiKey,
 		HTTPClient: httpClient,
+		cache:      newResponseCache(DefaultCacheTTL),
+		limiter:    newLimiter(DefaultRequestsPerMinute),
+	}
+}
+
+// SetRateLimit sets how many requests a minute the client sends at most, to
+// match the workspace's plan. Zero turns client-side pacing off; 429
+// responses are still retried after Retry-After.
+func (c *Client) SetRateLimit(perMinute int) {
+	c.limiter.setRate(perMinute)
+}
+
+// SetCacheTTL sets how long workspaces, users and project lists are cached.
+// Zero turns caching off. Entries cached so far are dropped.
+func (c *Client) SetCacheTTL(ttl time.Duration) {
+	c.cache.setTTL(ttl)
+}
+
+// InvalidateCache drops every cached response, so the next calls see changes
+// made outside the bot. Writes through the client invalidate it themselves.
+func (c *Client) InvalidateCache() {
+	c.cache.clear()
+}
+
+// cachedRequest is a GET for data that rarely changes, served from the cache
+// while it is fresh
+func (c *Client) cachedRequest(ctx context.Context, path string) ([]byte, error) {
+	if body, ok := c.cache.get(path); ok {
+		return body, nil
+	}
+	body, err := c.makeRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.put(path, body)
+	return body, nil
+}
+
+func (c *Client) makeRequest(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	var raw []byte
+	if payload != nil {
+		var err error
+		if raw, err = json.Marshal(payload); err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, fmt.Errorf("waiting for rate limit: %w", err)
+		}
+
+		status, header, body, err := c.send(ctx, method, path, raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if retryableStatus(method, status) {
+			delay := retryDelay(header, attempt)
+			if status == http.StatusTooManyRequests {
+				// The quota is shared by every request, so all of them wait
+				c.limiter.block(delay)
+			}
+			if attempt < maxAttempts {
+				metrics.Inc("asana_retries_total", "status", strconv.Itoa(status))
+				endpoint, _, _ := strings.Cut(path, "?")
+				log.Printf("[%s] ASANA: %s %s failed with %d, retrying in %v (attempt %d/%d)", time.Now().Format("2006-01-02 15:04:05"), method, endpoint, status, delay.Round(time.Millisecond), attempt, maxAttempts)
+				if err := sleep(ctx, delay); err != nil {
+					return nil, fmt.Errorf("waiting to retry: %w", err)
+				}
+				continue
+			}
+		}
+
+		if status == http.StatusPaymentRequired {
+			return nil, fmt.Errorf("%w: %s", errPaymentRequired, string(body))
+		}
+		if status < 200 || status >= 300 {
+			return nil, fmt.Errorf("API request failed with status %d: %s", status, string(body))
+		}
+
+		// Writes may rename or add projects and users, so cached lists are dropped
+		if method != http.MethodGet {
+			c.cache.clear()
+		}
+
+		return body, nil
 	}
 }
 
-func (c *Client) makeRequest(method, path string) ([]byte, error) {
-	req, err := http.NewRequest(method, BaseURL+path, nil)
+// send makes a single request and reads the whole response
+func (c *Client) send(ctx context.Context, method, path string, payload []byte) (int, http.Header, []byte, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, BaseURL+path, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return 0, nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.APIKey)
@@ -79,24 +287,19 @@ func (c *Client) makeRequest(method, path string) ([]byte, error) {
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return 0, nil, nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return 0, nil, nil, fmt.Errorf("failed to read response: %w", err)
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return body, nil
+	return resp.StatusCode, resp.Header, respBody, nil
 }
 
-func (c *Client) GetWorkspaces() ([]Workspace, error) {
-	body, err := c.makeRequest("GET", "/workspaces")
+func (c *Client) GetWorkspaces(ctx context.Context) ([]Workspace, error) {
+	body, err := c.cachedRequest(ctx, "/workspaces")
 	if err != nil {
 		return nil, err
 	}
@@ -118,8 +321,8 @@ func (c *Client) GetWorkspaces() ([]Workspace, error) {
 	return workspaces, nil
 }
 
-func (c *Client) getDefaultWorkspace() (string, error) {
-	workspaces, err := c.GetWorkspaces()
+func (c *Client) getDefaultWorkspace(ctx context.Context) (string, error) {
+	workspaces, err := c.GetWorkspaces(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to get workspaces: %w", err)
 	}
@@ -135,10 +338,10 @@ func (c *Client) getDefaultWorkspace() (string, error) {
 	return "", fmt.Errorf("multiple workspaces found (%d), workspace_gid must be specified", len(workspaces))
 }
 
-func (c *Client) ListProjects(workspaceGID string) ([]Project, error) {
+func (c *Client) ListProjects(ctx context.Context, workspaceGID string) ([]Project, error) {
 	// Use default workspace if not specified
 	if workspaceGID == "" {
-		defaultWorkspace, err := c.getDefaultWorkspace()
+		defaultWorkspace, err := c.getDefaultWorkspace(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -146,7 +349,7 @@ func (c *Client) ListProjects(workspaceGID string) ([]Project, error) {
 	}
 
 	path := fmt.Sprintf("/workspaces/%s/projects", workspaceGID)
-	body, err := c.makeRequest("GET", path)
+	body, err := c.cachedRequest(ctx, path)
 	if err != nil {
 		return nil, err
 	}
@@ -168,9 +371,9 @@ func (c *Client) ListProjects(workspaceGID string) ([]Project, error) {
 	return projects, nil
 }
 
-func (c *Client) ListProjectTasks(projectGID string) ([]Task, error) {
+func (c *Client) ListProjectTasks(ctx context.Context, projectGID string) ([]Task, error) {
 	path := fmt.Sprintf("/projects/%s/tasks?completed_since=now", projectGID)
-	body, err := c.makeRequest("GET", path)
+	body, err := c.makeRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -192,10 +395,10 @@ func (c *Client) ListProjectTasks(projectGID string) ([]Task, error) {
 	return tasks, nil
 }
 
-func (c *Client) ListUsers(workspaceGID string) ([]User, error) {
+func (c *Client) ListUsers(ctx context.Context, workspaceGID string) ([]User, error) {
 	// Use default workspace if not specified
 	if workspaceGID == "" {
-		defaultWorkspace, err := c.getDefaultWorkspace()
+		defaultWorkspace, err := c.getDefaultWorkspace(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -203,7 +406,7 @@ func (c *Client) ListUsers(workspaceGID string) ([]User, error) {
 	}
 
 	path := fmt.Sprintf("/workspaces/%s/users", workspaceGID)
-	body, err := c.makeRequest("GET", path)
+	body, err := c.cachedRequest(ctx, path)
 	if err != nil {
 		return nil, err
 	}
@@ -225,10 +428,10 @@ func (c *Client) ListUsers(workspaceGID string) ([]User, error) {
 	return users, nil
 }
 
-func (c *Client) ListUserTasks(assigneeGID, workspaceGID string) ([]Task, error) {
+func (c *Client) ListUserTasks(ctx context.Context, assigneeGID, workspaceGID string) ([]Task, error) {
 	// Use default workspace if not specified
 	if workspaceGID == "" {
-		defaultWorkspace, err := c.getDefaultWorkspace()
+		defaultWorkspace, err := c.getDefaultWorkspace(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -236,7 +439,7 @@ func (c *Client) ListUserTasks(assigneeGID, workspaceGID string) ([]Task, error)
 	}
 
 	path := fmt.Sprintf("/tasks?assignee=%s&workspace=%s&completed_since=now", assigneeGID, workspaceGID)
-	body, err := c.makeRequest("GET", path)
+	body, err := c.makeRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -257,3 +460,244 @@ func (c *Client) ListUserTasks(assigneeGID, workspaceGID string) ([]Task, error)
 
 	return tasks, nil
 }
+
+// GetTask returns the details of a task, including its subtasks
+func (c *Client) GetTask(ctx context.Context, taskGID string) (*TaskDetail, error) {
+	path := fmt.Sprintf("/tasks/%s?opt_fields=%s", url.PathEscape(taskGID), taskFields)
+	body, err := c.makeRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data rawTask `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	raw := response.Data
+	task := &TaskDetail{
+		GID:         raw.GID,
+		Name:        raw.Name,
+		Completed:   raw.Completed,
+		CompletedAt: raw.CompletedAt,
+		Assignee:    raw.Assignee.name(),
+		StartOn:     raw.StartOn,
+		DueOn:       raw.DueOn,
+		DueAt:       raw.DueAt,
+		Notes:       raw.Notes,
+		URL:         raw.PermalinkURL,
+	}
+	for _, project := range raw.Projects {
+		task.Projects = append(task.Projects, project.Name)
+	}
+	for _, field := range raw.CustomFields {
+		// Fields without a value on this task are left out
+		if field.DisplayValue == nil || *field.DisplayValue == "" {
+			continue
+		}
+		task.CustomFields = append(task.CustomFields, CustomField{Name: field.Name, Value: *field.DisplayValue})
+	}
+
+	subtasks, err := c.listSubtasks(ctx, taskGID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subtasks: %w", err)
+	}
+	task.Subtasks = subtasks
+
+	return task, nil
+}
+
+// NewTask is a task to create in a project
+type NewTask struct {
+	Name  string
+	Notes string
+	// Assignee is a user GID or email; empty leaves the task unassigned
+	Assignee   string
+	DueOn      string
+	ProjectGID string
+}
+
+// CreateTask creates a task in its project and returns the task's GID and URL
+func (c *Client) CreateTask(ctx context.Context, task NewTask) (*TaskSummary, string, error) {
+	data := map[string]interface{}{
+		"name":     task.Name,
+		"notes":    task.Notes,
+		"projects": []string{task.ProjectGID},
+	}
+	if task.Assignee != "" {
+		data["assignee"] = task.Assignee
+	}
+	if task.DueOn != "" {
+		data["due_on"] = task.DueOn
+	}
+	body, err := c.makeRequest(ctx, "POST", "/tasks?opt_fields=name,assignee.name,due_on,permalink_url", map[string]interface{}{"data": data})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var response struct {
+		Data rawTask `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	summary := response.Data.summary()
+	return &summary, response.Data.PermalinkURL, nil
+}
+
+// AgendaTask is an incomplete task with a due date, for personal briefings
+type AgendaTask struct {
+	GID      string   `json:"gid"`
+	Name     string   `json:"name"`
+	DueOn    string   `json:"due_on"`
+	Projects []string `json:"projects,omitempty"`
+	URL      string   `json:"url,omitempty"`
+}
+
+// agendaFields are the opt_fields requested for each agenda task
+const agendaFields = "name,due_on,projects.name,permalink_url"
+
+// ListUserAgenda returns the user's incomplete tasks due on or before dueBy
+// (YYYY-MM-DD), soonest first
+func (c *Client) ListUserAgenda(ctx context.Context, assigneeGID, workspaceGID, dueBy string) ([]AgendaTask, error) {
+	if workspaceGID == "" {
+		defaultWorkspace, err := c.getDefaultWorkspace(ctx)
+		if err != nil {
+			return nil, err
+		}
+		workspaceGID = defaultWorkspace
+	}
+
+	path := fmt.Sprintf("/tasks?assignee=%s&workspace=%s&completed_since=now&limit=100&opt_fields=%s",
+		url.QueryEscape(assigneeGID), url.QueryEscape(workspaceGID), agendaFields)
+	body, err := c.makeRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []rawTask `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var tasks []AgendaTask
+	for _, raw := range response.Data {
+		// Dates compare as strings in YYYY-MM-DD form
+		if raw.DueOn == "" || raw.DueOn > dueBy {
+			continue
+		}
+		task := AgendaTask{GID: raw.GID, Name: raw.Name, DueOn: raw.DueOn, URL: raw.PermalinkURL}
+		for _, project := range raw.Projects {
+			task.Projects = append(task.Projects, project.Name)
+		}
+		tasks = append(tasks, task)
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].DueOn < tasks[j].DueOn })
+	return tasks, nil
+}
+
+// CompleteTask marks a task completed
+func (c *Client) CompleteTask(ctx context.Context, taskGID string) error {
+	return c.updateTask(ctx, taskGID, map[string]interface{}{"completed": true})
+}
+
+// SetTaskDueOn moves a task's due date to dueOn (YYYY-MM-DD)
+func (c *Client) SetTaskDueOn(ctx context.Context, taskGID, dueOn string) error {
+	return c.updateTask(ctx, taskGID, map[string]interface{}{"due_on": dueOn})
+}
+
+func (c *Client) updateTask(ctx context.Context, taskGID string, data map[string]interface{}) error {
+	_, err := c.makeRequest(ctx, "PUT", "/tasks/"+url.PathEscape(taskGID), map[string]interface{}{"data": data})
+	return err
+}
+
+func (c *Client) listSubtasks(ctx context.Context, taskGID string) ([]TaskSummary, error) {
+	path := fmt.Sprintf("/tasks/%s/subtasks?opt_fields=%s", url.PathEscape(taskGID), subtaskFields)
+	body, err := c.makeRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response ListResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var subtasks []TaskSummary
+	for _, item := range response.Data {
+		var raw rawTask
+		if err := json.Unmarshal(item, &raw); err != nil {
+			continue // Skip malformed entries
+		}
+		subtasks = append(subtasks, raw.summary())
+	}
+
+	return subtasks, nil
+}
+
+// SearchTasks finds tasks whose name matches query. It uses the full-text
+// search API where the workspace has it and typeahead on free workspaces,
+// which only matches names but needs no premium plan.
+func (c *Client) SearchTasks(ctx context.Context, workspaceGID, query string, maxResults int) ([]TaskSummary, error) {
+	if maxResults <= 0 {
+		maxResults = 20
+	}
+	if maxResults > 50 {
+		maxResults = 50
+	}
+
+	// Use default workspace if not specified
+	if workspaceGID == "" {
+		defaultWorkspace, err := c.getDefaultWorkspace(ctx)
+		if err != nil {
+			return nil, err
+		}
+		workspaceGID = defaultWorkspace
+	}
+
+	search := url.Values{}
+	search.Set("text", query)
+	search.Set("sort_by", "modified_at")
+	search.Set("limit", fmt.Sprint(maxResults))
+	search.Set("opt_fields", searchFields)
+	body, err := c.makeRequest(ctx, "GET", fmt.Sprintf("/workspaces/%s/tasks/search?%s", url.PathEscape(workspaceGID), search.Encode()), nil)
+	if errors.Is(err, errPaymentRequired) {
+		typeahead := url.Values{}
+		typeahead.Set("resource_type", "task")
+		typeahead.Set("query", query)
+		typeahead.Set("count", fmt.Sprint(maxResults))
+		typeahead.Set("opt_fields", searchFields)
+		body, err = c.makeRequest(ctx, "GET", fmt.Sprintf("/workspaces/%s/typeahead?%s", url.PathEscape(workspaceGID), typeahead.Encode()), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var response ListResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var tasks []TaskSummary
+	for _, item := range response.Data {
+		var raw rawTask
+		if err := json.Unmarshal(item, &raw); err != nil {
+			continue // Skip malformed entries
+		}
+		tasks = append(tasks, raw.summary())
+	}
+
+	return tasks, nil
+}
+
+// Ping verifies the API key by fetching the authenticated user
+func (c *Client) Ping(ctx context.Context) error {
+	if _, err := c.makeRequest(ctx, "GET", "/users/me", nil); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package asana
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRequestsPerMinute is Asana's quota for free workspaces; paid plans
+// allow 1500
+const DefaultRequestsPerMinute = 150
+
+// Retry bounds for rate-limited and failed requests
+const (
+	maxAttempts    = 4
+	baseRetryDelay = time.Second
+	maxRetryDelay  = 30 * time.Second
+)
+
+// limiterBurst is how many requests may go out back to back before the
+// limiter starts pacing them
+const limiterBurst = 10
+
+// limiter paces requests to stay under the per-minute quota with a token
+// bucket, and holds every request back after a 429 until Asana's Retry-After
+type limiter struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	tokens     float64
+	last       time.Time
+	blockUntil time.Time
+}
+
+func newLimiter(perMinute int) *limiter {
+	l := &limiter{}
+	l.setRate(perMinute)
+	return l
+}
+
+// setRate sets the quota; zero or less turns pacing off
+func (l *limiter) setRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval = 0
+	if perMinute > 0 {
+		l.interval = time.Minute / time.Duration(perMinute)
+	}
+	l.tokens = limiterBurst
+	l.last = time.Now()
+}
+
+// wait blocks until a request may be sent
+func (l *limiter) wait(ctx context.Context) error {
+	for {
+		delay := l.reserve()
+		if delay <= 0 {
+			return nil
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token and returns zero, or returns how long to wait before
+// trying again
+func (l *limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if wait := l.blockUntil.Sub(now); wait > 0 {
+		return wait
+	}
+	if l.interval == 0 {
+		return 0
+	}
+
+	l.tokens = min(l.tokens+float64(now.Sub(l.last))/float64(l.interval), limiterBurst)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) * float64(l.interval))
+}
+
+// block holds requests back for delay, after Asana answered 429
+func (l *limiter) block(delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(delay); until.After(l.blockUntil) {
+		l.blockUntil = until
+	}
+}
+
+// retryableStatus reports whether a response is worth retrying: rate limits
+// always, server errors only for reads, which are safe to repeat
+func retryableStatus(method string, status int) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	return method == http.MethodGet && status >= 500
+}
+
+// retryDelay is the wait before retrying after attempt: Retry-After when
+// Asana sends it, otherwise an exponential delay jittered down by up to half
+func retryDelay(header http.Header, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+		return min(time.Duration(seconds)*time.Second, maxRetryDelay)
+	}
+	delay := min(baseRetryDelay<<(attempt-1), maxRetryDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// sleep waits for delay or until ctx is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
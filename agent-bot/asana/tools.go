@@ -0,0 +1,86 @@
+package asana
+
+import (
+	"context"
+	"fmt"
+
+	"agent-bot/tools"
+)
+
+// Tools returns the Asana tools backed by this client
+func (c *Client) Tools() []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "list_asana_projects",
+			Description: "List projects in an Asana workspace",
+			Schema:      tools.SchemaFor[ListProjectsArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input ListProjectsArgs) (interface{}, error) {
+				projects, err := c.ListProjects(ctx, input.WorkspaceGID)
+				if err != nil {
+					return nil, fmt.Errorf("error listing projects: %w", err)
+				}
+				return projects, nil
+			}),
+		},
+		{
+			Name:        "list_asana_project_tasks",
+			Description: "List incomplete tasks in an Asana project",
+			Schema:      tools.SchemaFor[ListProjectTasksArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input ListProjectTasksArgs) (interface{}, error) {
+				tasks, err := c.ListProjectTasks(ctx, input.ProjectGID)
+				if err != nil {
+					return nil, fmt.Errorf("error listing project tasks: %w", err)
+				}
+				return tasks, nil
+			}),
+		},
+		{
+			Name:        "list_asana_user_tasks",
+			Description: "List incomplete tasks assigned to a user in Asana",
+			Schema:      tools.SchemaFor[ListUserTasksArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input ListUserTasksArgs) (interface{}, error) {
+				tasks, err := c.ListUserTasks(ctx, input.AssigneeGID, input.WorkspaceGID)
+				if err != nil {
+					return nil, fmt.Errorf("error listing user tasks: %w", err)
+				}
+				return tasks, nil
+			}),
+		},
+		{
+			Name:        "search_asana_tasks",
+			Description: "Search Asana tasks by name across a workspace. Use this to find a task's GID instead of listing whole projects.",
+			Schema:      tools.SchemaFor[SearchTasksArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input SearchTasksArgs) (interface{}, error) {
+				tasks, err := c.SearchTasks(ctx, input.WorkspaceGID, input.Query, input.MaxResults)
+				if err != nil {
+					return nil, fmt.Errorf("error searching tasks: %w", err)
+				}
+				return tasks, nil
+			}),
+		},
+		{
+			Name:        "get_asana_task",
+			Description: "Get an Asana task's details: assignee, start and due dates, projects, custom fields, notes and subtasks",
+			Schema:      tools.SchemaFor[GetTaskArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input GetTaskArgs) (interface{}, error) {
+				task, err := c.GetTask(ctx, input.TaskGID)
+				if err != nil {
+					return nil, fmt.Errorf("error getting task: %w", err)
+				}
+				return task, nil
+			}),
+		},
+		{
+			Name:        "list_asana_users",
+			Description: "List users in an Asana workspace to get their GIDs for other operations",
+			Schema:      tools.SchemaFor[ListUsersArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input ListUsersArgs) (interface{}, error) {
+				users, err := c.ListUsers(ctx, input.WorkspaceGID)
+				if err != nil {
+					return nil, fmt.Errorf("error listing users: %w", err)
+				}
+				return users, nil
+			}),
+		},
+	}
+}
@@ -0,0 +1,191 @@
+package asana
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// Kinds of project activity a Subscription can notify about
+const (
+	TaskCompleted = "task_completed"
+	TaskAdded     = "task_added"
+	CommentAdded  = "comment_added"
+)
+
+// Kinds lists every kind of activity, in the order they are documented
+var Kinds = []string{TaskCompleted, TaskAdded, CommentAdded}
+
+// Subscription posts notifications about activity in an Asana project to a
+// chat channel
+type Subscription struct {
+	ProjectGID string `yaml:"project_gid"`
+	ChannelID  string `yaml:"channel_id"`
+	// Events are the kinds to notify about; empty means all of them
+	Events []string `yaml:"events"`
+}
+
+// Wants reports whether the subscription notifies about kind
+func (s Subscription) Wants(kind string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, kind)
+}
+
+// ValidateSubscriptions checks a list of project subscriptions
+func ValidateSubscriptions(subscriptions []Subscription) error {
+	for i, s := range subscriptions {
+		if s.ProjectGID == "" {
+			return fmt.Errorf("subscription %d has no project_gid", i+1)
+		}
+		if s.ChannelID == "" {
+			return fmt.Errorf("subscription for project %s has no channel_id", s.ProjectGID)
+		}
+		for _, kind := range s.Events {
+			if !slices.Contains(Kinds, kind) {
+				return fmt.Errorf("subscription for project %s has unknown event %q (expected one of %v)", s.ProjectGID, kind, Kinds)
+			}
+		}
+	}
+	return nil
+}
+
+// Event is one change reported by an Asana webhook. Events are compact: they
+// name the resource that changed, and details must be fetched separately.
+type Event struct {
+	Action   string   `json:"action"`
+	Resource Resource `json:"resource"`
+	Parent   Resource `json:"parent"`
+	Change   struct {
+		Field  string `json:"field"`
+		Action string `json:"action"`
+	} `json:"change"`
+	User      Resource `json:"user"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// Resource identifies an Asana object in an event
+type Resource struct {
+	GID             string `json:"gid"`
+	ResourceType    string `json:"resource_type"`
+	ResourceSubtype string `json:"resource_subtype"`
+}
+
+// ParseEvents reads the events of a webhook delivery
+func ParseEvents(body []byte) ([]Event, error) {
+	var delivery struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal(body, &delivery); err != nil {
+		return nil, fmt.Errorf("failed to parse events: %w", err)
+	}
+	return delivery.Events, nil
+}
+
+// Kind returns which kind of activity an event is, or "" for changes no
+// subscription can ask for. A completed change may also be a task being
+// reopened; check the task before notifying.
+func (e Event) Kind() string {
+	switch {
+	case e.Resource.ResourceType == "task" && e.Action == "changed" && e.Change.Field == "completed":
+		return TaskCompleted
+	case e.Resource.ResourceType == "task" && e.Action == "added" && e.Parent.ResourceType == "project":
+		return TaskAdded
+	case e.Resource.ResourceType == "story" && e.Resource.ResourceSubtype == "comment_added" && e.Action == "added":
+		return CommentAdded
+	}
+	return ""
+}
+
+// VerifySignature checks a delivery's X-Hook-Signature, the hex HMAC-SHA256
+// of the body keyed with the secret from the webhook's handshake
+func VerifySignature(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Story is a comment on a task
+type Story struct {
+	GID       string `json:"gid"`
+	Text      string `json:"text"`
+	Author    string `json:"author,omitempty"`
+	TaskGID   string `json:"task_gid"`
+	CreatedAt string `json:"created_at"`
+}
+
+// GetStory returns a comment and the task it is on
+func (c *Client) GetStory(ctx context.Context, storyGID string) (*Story, error) {
+	path := fmt.Sprintf("/stories/%s?opt_fields=text,created_by.name,target,created_at", url.PathEscape(storyGID))
+	body, err := c.makeRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data struct {
+			GID       string `json:"gid"`
+			Text      string `json:"text"`
+			CreatedBy *named `json:"created_by"`
+			Target    struct {
+				GID string `json:"gid"`
+			} `json:"target"`
+			CreatedAt string `json:"created_at"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	raw := response.Data
+	return &Story{GID: raw.GID, Text: raw.Text, Author: raw.CreatedBy.name(), TaskGID: raw.Target.GID, CreatedAt: raw.CreatedAt}, nil
+}
+
+// Webhook is a registered Asana webhook
+type Webhook struct {
+	GID      string `json:"gid"`
+	Resource string `json:"resource"`
+	Target   string `json:"target"`
+	Active   bool   `json:"active"`
+}
+
+// CreateWebhook asks Asana to deliver events for resourceGID to target. Asana
+// sends target a handshake carrying X-Hook-Secret before this returns, so the
+// endpoint must already be serving it.
+func (c *Client) CreateWebhook(ctx context.Context, resourceGID, target string) (*Webhook, error) {
+	payload := map[string]interface{}{
+		"data": map[string]string{"resource": resourceGID, "target": target},
+	}
+	body, err := c.makeRequest(ctx, "POST", "/webhooks", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data struct {
+			GID      string `json:"gid"`
+			Resource struct {
+				GID string `json:"gid"`
+			} `json:"resource"`
+			Target string `json:"target"`
+			Active bool   `json:"active"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	raw := response.Data
+	return &Webhook{GID: raw.GID, Resource: raw.Resource.GID, Target: raw.Target, Active: raw.Active}, nil
+}
+
+// DeleteWebhook stops a webhook's deliveries
+func (c *Client) DeleteWebhook(ctx context.Context, webhookGID string) error {
+	_, err := c.makeRequest(ctx, "DELETE", "/webhooks/"+url.PathEscape(webhookGID), nil)
+	return err
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-bot/asana"
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+// asanaUsersBucket links Mattermost user IDs to Asana user GIDs
+const asanaUsersBucket = "asana_users"
+
+const (
+	// asanaLinkManual is a link an admin made with !asanauser link
+	asanaLinkManual = "manual"
+	// asanaLinkEmail is a link found by matching email addresses
+	asanaLinkEmail = "email"
+)
+
+// asanaIdentity is the Asana user a Mattermost user is linked to
+type asanaIdentity struct {
+	GID      string    `json:"gid"`
+	Name     string    `json:"name,omitempty"`
+	Source   string    `json:"source"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+type getAsanaUserArgs struct {
+	Username string `json:"username,omitempty" jsonschema_description:"Mattermost username, with or without @ (optional - defaults to the user asking)"`
+}
+
+// asanaUserTools lets the model find a chat user's Asana GID, so "list my
+// tasks" works without anyone knowing their GID
+func (b *Bot) asanaUserTools() []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "get_asana_user",
+			Description: "Get the Asana user GID and name of a chat user, by default the user asking. Use it before list_asana_user_tasks for requests like \"my tasks\" or \"what is @alice working on\".",
+			Schema:      tools.SchemaFor[getAsanaUserArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input getAsanaUserArgs) (interface{}, error) {
+				userID := ""
+				if username := strings.TrimPrefix(strings.TrimSpace(input.Username), "@"); username != "" {
+					user, _, err := b.client.GetUserByUsername(username, "")
+					if err != nil {
+						return nil, fmt.Errorf("no chat user named @%s", username)
+					}
+					userID = user.Id
+				} else if req, ok := tools.RequestFrom(ctx); ok {
+					userID = req.UserID
+				}
+				if userID == "" {
+					return nil, fmt.Errorf("no user to look up")
+				}
+
+				identity, err := b.asanaIdentity(ctx, userID)
+				if err != nil {
+					return nil, err
+				}
+				if identity == nil {
+					return nil, fmt.Errorf("this user isn't linked to an Asana account and no Asana user has their email; an admin can link them with !asanauser link")
+				}
+				return identity, nil
+			}),
+		},
+	}
+}
+
+// asanaUserGID returns the Asana GID linked to a Mattermost user, or "" when
+// there is none
+func (b *Bot) asanaUserGID(ctx context.Context, userID string) (string, error) {
+	identity, err := b.asanaIdentity(ctx, userID)
+	if err != nil || identity == nil {
+		return "", err
+	}
+	return identity.GID, nil
+}
+
+// asanaIdentity returns the Asana user linked to a Mattermost user. Users
+// without a link are matched by email in the default workspace and the match
+// is remembered; nil means no Asana user has their email.
+func (b *Bot) asanaIdentity(ctx context.Context, userID string) (*asanaIdentity, error) {
+	var identity asanaIdentity
+	if found, err := b.store.Get(asanaUsersBucket, userID, &identity); err == nil && found {
+		return &identity, nil
+	}
+
+	user, _, err := b.client.GetUser(userID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Email == "" {
+		return nil, nil
+	}
+	asanaUsers, err := b.asanaHooks.client.ListUsers(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	match := matchAsanaUser(asanaUsers, user.Email)
+	if match == nil {
+		return nil, nil
+	}
+
+	identity = asanaIdentity{GID: match.GID, Name: match.Name, Source: asanaLinkEmail, LinkedAt: time.Now()}
+	if err := b.store.Put(asanaUsersBucket, userID, identity); err != nil {
+		log.Printf("[%s] ASANA: Failed to remember the Asana user of %s: %v", time.Now().Format("2006-01-02 15:04:05"), userID, err)
+	}
+	return &identity, nil
+}
+
+func matchAsanaUser(asanaUsers []asana.User, email string) *asana.User {
+	for i, asanaUser := range asanaUsers {
+		if asanaUser.Email != "" && strings.EqualFold(asanaUser.Email, email) {
+			return &asanaUsers[i]
+		}
+	}
+	return nil
+}
+
+// matchAsanaUsers links every unlinked Mattermost user whose email matches an
+// Asana user's and returns how many it linked
+func (b *Bot) matchAsanaUsers(ctx context.Context) (int, error) {
+	asanaUsers, err := b.asanaHooks.client.ListUsers(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	linked := 0
+	for page := 0; page < 50; page++ {
+		users, _, err := b.client.GetUsers(page, 200, "")
+		if err != nil {
+			return linked, fmt.Errorf("failed to list users: %w", err)
+		}
+		for _, user := range users {
+			if user.IsBot || user.Email == "" {
+				continue
+			}
+			var existing asanaIdentity
+			if found, err := b.store.Get(asanaUsersBucket, user.Id, &existing); err == nil && found {
+				continue
+			}
+			match := matchAsanaUser(asanaUsers, user.Email)
+			if match == nil {
+				continue
+			}
+			identity := asanaIdentity{GID: match.GID, Name: match.Name, Source: asanaLinkEmail, LinkedAt: time.Now()}
+			if err := b.store.Put(asanaUsersBucket, user.Id, identity); err != nil {
+				return linked, fmt.Errorf("failed to save link: %w", err)
+			}
+			linked++
+		}
+		if len(users) < 200 {
+			break
+		}
+	}
+	return linked, nil
+}
+
+// resolveChatUser accepts a user ID or an @username
+func (b *Bot) resolveChatUser(arg string) (id, username string, err error) {
+	if strings.HasPrefix(arg, "@") {
+		user, _, err := b.client.GetUserByUsername(strings.TrimPrefix(arg, "@"), "")
+		if err != nil {
+			return "", "", fmt.Errorf("no user named %s", arg)
+		}
+		return user.Id, user.Username, nil
+	}
+	user, _, err := b.client.GetUser(arg, "")
+	if err != nil {
+		return "", "", fmt.Errorf("no user with ID `%s`", arg)
+	}
+	return user.Id, user.Username, nil
+}
+
+// handleAsanaUserCommand implements "!asanauser list | match | link <user> <asana_gid> | unlink <user>"
+func (b *Bot) handleAsanaUserCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!asanauser list`, `!asanauser match`, `!asanauser link <@username|user_id> <asana_gid>`, `!asanauser unlink <@username|user_id>`"
+	if len(args) == 0 {
+		return usage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		var lines []string
+		for _, userID := range b.store.Keys(asanaUsersBucket) {
+			var identity asanaIdentity
+			if found, err := b.store.Get(asanaUsersBucket, userID, &identity); err != nil || !found {
+				continue
+			}
+			name := userID
+			if user, err := b.chat.GetUser(userID); err == nil {
+				name = "@" + user.Username
+			}
+			lines = append(lines, fmt.Sprintf("- %s → `%s` %s (%s)", name, identity.GID, identity.Name, identity.Source))
+		}
+		if len(lines) == 0 {
+			return "No users are linked to Asana yet. Run `!asanauser match` to link them by email."
+		}
+		sort.Strings(lines)
+		return "**Asana users**\n" + strings.Join(lines, "\n")
+
+	case "match":
+		linked, err := b.matchAsanaUsers(ctx)
+		if err != nil {
+			return fmt.Sprintf("Linked %d users before failing: %v", linked, err)
+		}
+		return fmt.Sprintf("Linked %d users to Asana by email.", linked)
+
+	case "link":
+		if len(args) != 3 {
+			return usage
+		}
+		userID, username, err := b.resolveChatUser(args[1])
+		if err != nil {
+			return err.Error()
+		}
+		identity := asanaIdentity{GID: args[2], Source: asanaLinkManual, LinkedAt: time.Now()}
+		if asanaUsers, err := b.asanaHooks.client.ListUsers(ctx, ""); err == nil {
+			for _, asanaUser := range asanaUsers {
+				if asanaUser.GID == identity.GID {
+					identity.Name = asanaUser.Name
+				}
+			}
+		}
+		if err := b.store.Put(asanaUsersBucket, userID, identity); err != nil {
+			return fmt.Sprintf("Failed to link @%s: %v", username, err)
+		}
+		return fmt.Sprintf("Linked @%s to Asana user `%s`.", username, identity.GID)
+
+	case "unlink":
+		if len(args) != 2 {
+			return usage
+		}
+		userID, username, err := b.resolveChatUser(args[1])
+		if err != nil {
+			return err.Error()
+		}
+		if err := b.store.Delete(asanaUsersBucket, userID); err != nil {
+			return fmt.Sprintf("Failed to unlink @%s: %v", username, err)
+		}
+		return fmt.Sprintf("Unlinked @%s from Asana; they will be matched by email again when needed.", username)
+	}
+
+	return usage
+}
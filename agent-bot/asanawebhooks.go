@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/asana"
+	"agent-bot/llms"
+	"agent-bot/metrics"
+	"agent-bot/prompts"
+	"agent-bot/store"
+	"agent-bot/tools"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// asanaWebhooksBucket stores each subscribed project's webhook GID and the
+// secret from its handshake, keyed by project GID
+const asanaWebhooksBucket = "asana_webhooks"
+
+// asanaEventTimeout bounds fetching details and summarizing one delivery
+const asanaEventTimeout = 2 * time.Minute
+
+// asanaEventDescriptions phrase each kind of activity for the prompt
+var asanaEventDescriptions = map[string]string{
+	asana.TaskCompleted: "was completed",
+	asana.TaskAdded:     "was added to a project",
+	asana.CommentAdded:  "got a new comment",
+}
+
+type asanaHook struct {
+	WebhookGID string `json:"webhook_gid"`
+	Secret     string `json:"secret"`
+}
+
+// asanaWebhooks bridges Asana project activity into channels configured with
+// asana_notifications. Webhooks are registered with !asana register; Asana
+// then calls /webhooks/asana?project=<gid> with a handshake and events.
+type asanaWebhooks struct {
+	client        *asana.Client
+	subscriptions []asana.Subscription
+	// target is the public URL Asana delivers to, without the project
+	target string
+	store  *store.Store
+
+	// projects being registered, whose handshake is expected
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func newAsanaWebhooks(config Config, fileConfig *FileConfig, client *asana.Client, stateStore *store.Store) *asanaWebhooks {
+	target := ""
+	if config.AsanaWebhookURL != "" {
+		// Other workspaces serve their routes under /servers/<name>/
+		prefix := ""
+		if config.Name != "" {
+			prefix = "/servers/" + config.Name
+		}
+		target = strings.TrimRight(config.AsanaWebhookURL, "/") + prefix + "/webhooks/asana"
+	}
+	return &asanaWebhooks{
+		client:        client,
+		subscriptions: fileConfig.AsanaNotifications,
+		target:        target,
+		store:         stateStore,
+		pending:       make(map[string]bool),
+	}
+}
+
+// projects returns the distinct subscribed project GIDs, sorted
+func (h *asanaWebhooks) projects() []string {
+	var projects []string
+	for _, s := range h.subscriptions {
+		if !containsString(projects, s.ProjectGID) {
+			projects = append(projects, s.ProjectGID)
+		}
+	}
+	sort.Strings(projects)
+	return projects
+}
+
+func (h *asanaWebhooks) hook(projectGID string) (asanaHook, bool) {
+	var hook asanaHook
+	found, err := h.store.Get(asanaWebhooksBucket, projectGID, &hook)
+	return hook, found && err == nil
+}
+
+// expectHandshake marks projectGID as being registered, or clears the mark
+func (h *asanaWebhooks) expectHandshake(projectGID string, expected bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if expected {
+		h.pending[projectGID] = true
+	} else {
+		delete(h.pending, projectGID)
+	}
+}
+
+func (h *asanaWebhooks) handshakeExpected(projectGID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.pending[projectGID]
+}
+
+// isAsanaDelivery reports whether a /webhooks/asana request comes from an
+// Asana webhook rather than a notification rule integration
+func isAsanaDelivery(r *http.Request) bool {
+	return r.Header.Get("X-Hook-Secret") != "" || r.Header.Get("X-Hook-Signature") != ""
+}
+
+// handleAsanaWebhook answers Asana's handshake and accepts signed event
+// deliveries. Handshakes are only accepted while !asana register waits for
+// them; deliveries must be signed with the secret the handshake carried.
+func (b *Bot) handleAsanaWebhook(w http.ResponseWriter, r *http.Request) {
+	hooks := b.asanaHooks
+	projectGID := r.URL.Query().Get("project")
+
+	if secret := r.Header.Get("X-Hook-Secret"); secret != "" {
+		if !hooks.handshakeExpected(projectGID) {
+			log.Printf("[%s] ASANA: Rejected unexpected handshake for project %q", time.Now().Format("2006-01-02 15:04:05"), projectGID)
+			writeJSON(w, http.StatusForbidden, apiError{Error: "no webhook registration in progress for this project"})
+			return
+		}
+		if err := b.store.Put(asanaWebhooksBucket, projectGID, asanaHook{Secret: secret}); err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{Error: "failed to save webhook secret"})
+			return
+		}
+		w.Header().Set("X-Hook-Secret", secret)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read body"})
+		return
+	}
+	hook, ok := hooks.hook(projectGID)
+	if !ok || !asana.VerifySignature(hook.Secret, body, r.Header.Get("X-Hook-Signature")) {
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid signature"})
+		return
+	}
+	events, err := asana.ParseEvents(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON body"})
+		return
+	}
+
+	// Asana expects an answer within seconds; fetching details and
+	// summarizing happen afterwards
+	w.WriteHeader(http.StatusOK)
+	if len(events) > 0 {
+		go b.processAsanaEvents(projectGID, events)
+	}
+}
+
+func (b *Bot) processAsanaEvents(projectGID string, events []asana.Event) {
+	for _, event := range events {
+		kind := event.Kind()
+		if kind == "" {
+			continue
+		}
+		var channelIDs []string
+		for _, s := range b.asanaHooks.subscriptions {
+			if s.ProjectGID == projectGID && s.Wants(kind) && !containsString(channelIDs, s.ChannelID) {
+				channelIDs = append(channelIDs, s.ChannelID)
+			}
+		}
+		if len(channelIDs) == 0 {
+			continue
+		}
+		if err := b.notifyAsanaEvent(kind, event, channelIDs); err != nil {
+			log.Printf("[%s] ASANA: Failed to notify about %s on %s: %v", time.Now().Format("2006-01-02 15:04:05"), kind, event.Resource.GID, err)
+		}
+	}
+}
+
+// notifyAsanaEvent fetches what an event refers to, summarizes it and posts
+// the summary to channelIDs
+func (b *Bot) notifyAsanaEvent(kind string, event asana.Event, channelIDs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), asanaEventTimeout)
+	defer cancel()
+
+	data := prompts.AsanaEventData{Event: asanaEventDescriptions[kind]}
+	taskGID := event.Resource.GID
+	if kind == asana.CommentAdded {
+		story, err := b.asanaHooks.client.GetStory(ctx, event.Resource.GID)
+		if err != nil {
+			return fmt.Errorf("failed to get comment: %w", err)
+		}
+		data.Comment = story.Text
+		data.CommentAuthor = story.Author
+		taskGID = story.TaskGID
+	}
+
+	task, err := b.asanaHooks.client.GetTask(ctx, taskGID)
+	if err != nil {
+		return fmt.Errorf("failed to get task: %w", err)
+	}
+	// The completed field also changes when a task is reopened
+	if kind == asana.TaskCompleted && !task.Completed {
+		return nil
+	}
+	details, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to encode task: %w", err)
+	}
+	data.Task = string(details)
+
+	message := b.summarizeAsanaEvent(ctx, data, channelIDs[0])
+	if message == "" {
+		message = fmt.Sprintf("Asana task **%s** %s.", task.Name, data.Event)
+	}
+	if task.URL != "" {
+		message += fmt.Sprintf("\n\n[%s](%s)", task.Name, task.URL)
+	}
+
+	for _, channelID := range channelIDs {
+		if _, _, err := b.client.CreatePost(&model.Post{ChannelId: channelID, Message: message}); err != nil {
+			log.Printf("[%s] ASANA: Failed to post %s notification to channel %s: %v", time.Now().Format("2006-01-02 15:04:05"), kind, channelID, err)
+			continue
+		}
+		metrics.Inc("asana_notifications_total", "event", kind)
+	}
+	log.Printf("[%s] ASANA: Notified %d channels that task %s %s", time.Now().Format("2006-01-02 15:04:05"), len(channelIDs), task.GID, data.Event)
+	return nil
+}
+
+// summarizeAsanaEvent asks the model for the notification text. It returns ""
+// when that fails, so the caller can fall back to a plain message.
+func (b *Bot) summarizeAsanaEvent(ctx context.Context, data prompts.AsanaEventData, channelID string) string {
+	prompt, err := b.prompts.Render(prompts.AsanaEvent, data)
+	if err != nil {
+		log.Printf("[%s] ASANA: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return ""
+	}
+	ctx = llms.WithoutTools(ctx)
+	ctx = tools.WithRequest(ctx, tools.Request{UserID: "asana", ChannelID: channelID})
+	summary, err := b.llmBackend.Prompt(ctx, prompt)
+	if err != nil {
+		log.Printf("[%s] ASANA: Failed to summarize event, posting it plainly: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return ""
+	}
+	return strings.TrimSpace(summary)
+}
+
+// handleAsanaCommand implements "!asana"
+func (b *Bot) handleAsanaCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!asana webhooks`, `!asana register`, `!asana unregister <project_gid>`"
+	hooks := b.asanaHooks
+	if len(hooks.subscriptions) == 0 {
+		return "No Asana notifications are configured. Add them under `asana_notifications` in the config file."
+	}
+	if len(args) == 0 {
+		args = []string{"webhooks"}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "webhooks":
+		var sb strings.Builder
+		sb.WriteString("**Asana notifications**\n")
+		for _, s := range hooks.subscriptions {
+			events := "all events"
+			if len(s.Events) > 0 {
+				events = strings.Join(s.Events, ", ")
+			}
+			status := "not registered"
+			if hook, ok := hooks.hook(s.ProjectGID); ok && hook.WebhookGID != "" {
+				status = "webhook `" + hook.WebhookGID + "`"
+			}
+			sb.WriteString(fmt.Sprintf("- Project `%s` → channel `%s` (%s): %s\n", s.ProjectGID, s.ChannelID, events, status))
+		}
+		return sb.String()
+
+	case "register":
+		if hooks.target == "" {
+			return "Set ASANA_WEBHOOK_URL to the bot's public URL so Asana can reach it."
+		}
+		var results []string
+		for _, projectGID := range hooks.projects() {
+			if hook, ok := hooks.hook(projectGID); ok && hook.WebhookGID != "" {
+				results = append(results, fmt.Sprintf("- `%s`: already registered", projectGID))
+				continue
+			}
+			webhook, err := b.registerAsanaWebhook(projectGID)
+			if err != nil {
+				results = append(results, fmt.Sprintf("- `%s`: failed: %v", projectGID, err))
+				continue
+			}
+			results = append(results, fmt.Sprintf("- `%s`: registered webhook `%s`", projectGID, webhook.GID))
+		}
+		return "**Asana webhooks**\n" + strings.Join(results, "\n")
+
+	case "unregister":
+		if len(args) != 2 {
+			return usage
+		}
+		projectGID := args[1]
+		hook, ok := hooks.hook(projectGID)
+		if !ok || hook.WebhookGID == "" {
+			return fmt.Sprintf("No webhook is registered for project `%s`.", projectGID)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := hooks.client.DeleteWebhook(ctx, hook.WebhookGID); err != nil {
+			return fmt.Sprintf("Failed to delete webhook: %v", err)
+		}
+		if err := b.store.Delete(asanaWebhooksBucket, projectGID); err != nil {
+			return fmt.Sprintf("Deleted the webhook but failed to forget it: %v", err)
+		}
+		return fmt.Sprintf("Unregistered the webhook for project `%s`.", projectGID)
+	}
+	return usage
+}
+
+// registerAsanaWebhook creates a webhook for a project. Asana sends the
+// handshake before CreateWebhook returns, which stores the secret.
+func (b *Bot) registerAsanaWebhook(projectGID string) (*asana.Webhook, error) {
+	hooks := b.asanaHooks
+	hooks.expectHandshake(projectGID, true)
+	defer hooks.expectHandshake(projectGID, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	webhook, err := hooks.client.CreateWebhook(ctx, projectGID, hooks.target+"?project="+url.QueryEscape(projectGID))
+	if err != nil {
+		return nil, err
+	}
+
+	hook, ok := hooks.hook(projectGID)
+	if !ok {
+		return nil, fmt.Errorf("webhook %s was created but no handshake arrived", webhook.GID)
+	}
+	hook.WebhookGID = webhook.GID
+	if err := b.store.Put(asanaWebhooksBucket, projectGID, hook); err != nil {
+		return nil, fmt.Errorf("failed to save webhook: %w", err)
+	}
+	log.Printf("[%s] ASANA: Registered webhook %s for project %s", time.Now().Format("2006-01-02 15:04:05"), webhook.GID, projectGID)
+	return webhook, nil
+}
+
+// summary describes the subscriptions and where Asana delivers events
+func (h *asanaWebhooks) summary() string {
+	if h == nil || len(h.subscriptions) == 0 {
+		return "off"
+	}
+	if h.target == "" {
+		return fmt.Sprintf("%d subscriptions, ASANA_WEBHOOK_URL not set", len(h.subscriptions))
+	}
+	return fmt.Sprintf("%d subscriptions via %s", len(h.subscriptions), h.target)
+}
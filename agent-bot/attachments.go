@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"agent-bot/imagegen"
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+// maxAttachmentBytes bounds a file the model attaches
+const maxAttachmentBytes = 1 << 20
+
+type attachFileArgs struct {
+	Filename string `json:"filename" jsonschema_description:"File name with an extension that matches the content, e.g. open-tasks.csv, deploy.log or summary.md"`
+	Content  string `json:"content" jsonschema_description:"The full text of the file"`
+	Message  string `json:"message,omitempty" jsonschema_description:"Short note posted with the file (optional)"`
+}
+
+// registerUploadTools registers the tools that post files and cards through
+// chat: attach_file, post_cards, render_diagram when a renderer is configured,
+// generate_image when an image provider is and, when Prometheus is, a
+// query_prometheus that can post charts in place of the shared table-only one
+func registerUploadTools(registry *tools.Registry, shared *sharedTools, chat types.Chat) {
+	registry.Register(attachFileTool(chat))
+	registry.Register(postCardsTool(chat))
+	if shared.renderer != nil {
+		for _, tool := range shared.renderer.Tools(chat.UploadFile) {
+			registry.Register(tool)
+		}
+	}
+	if shared.images != nil {
+		for _, tool := range imagegen.Tools(shared.images, chat.UploadFile) {
+			registry.Register(tool)
+		}
+	}
+	if shared.prometheus != nil {
+		for _, tool := range shared.prometheus.Tools(chat.UploadFile) {
+			registry.Register(tool)
+		}
+	}
+}
+
+// attachFileTool lets the model attach generated text (CSV exports, long
+// logs, Markdown reports) to the conversation instead of pasting it
+func attachFileTool(chat types.Chat) tools.Tool {
+	return tools.Tool{
+		Name:        "attach_file",
+		Description: "Attach a text file (CSV, log, Markdown, JSON, ...) to the current conversation. Use it for long or structured output such as exports, full logs or reports, then reply with a short summary instead of pasting the content.",
+		Schema:      tools.SchemaFor[attachFileArgs](),
+		Handler: tools.Typed(func(ctx context.Context, input attachFileArgs) (interface{}, error) {
+			req, ok := tools.RequestFrom(ctx)
+			if !ok || req.ChannelID == "" {
+				return nil, fmt.Errorf("no conversation to attach the file to")
+			}
+			filename, err := attachmentName(input.Filename)
+			if err != nil {
+				return nil, err
+			}
+			if input.Content == "" {
+				return nil, fmt.Errorf("content is empty")
+			}
+			if len(input.Content) > maxAttachmentBytes {
+				return nil, fmt.Errorf("content is %d bytes, more than the %d allowed", len(input.Content), maxAttachmentBytes)
+			}
+
+			upload := types.FileUpload{
+				ChannelId: req.ChannelID,
+				ThreadId:  req.ThreadID,
+				Filename:  filename,
+				Data:      []byte(input.Content),
+				Message:   input.Message,
+			}
+			if err := chat.UploadFile(upload); err != nil {
+				return nil, fmt.Errorf("error attaching file: %w", err)
+			}
+			return map[string]interface{}{"attached": filename, "bytes": len(upload.Data)}, nil
+		}),
+	}
+}
+
+// attachmentName checks a file name is a plain name, without directories or
+// control characters
+func attachmentName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "" || name == "." || name == "..":
+		return "", fmt.Errorf("filename is required")
+	case strings.ContainsAny(name, `/\`):
+		return "", fmt.Errorf("filename must not contain directories")
+	case utf8.RuneCountInString(name) > 100:
+		return "", fmt.Errorf("filename is too long")
+	}
+	for _, r := range name {
+		if r < ' ' || r == 0x7f {
+			return "", fmt.Errorf("filename must not contain control characters")
+		}
+	}
+	return name, nil
+}
+
+// Image generation providers
+const (
+	imageOff       = "off"
+	imageOpenAI    = "openai"
+	imageStability = "stability"
+)
+
+// newImageProvider builds the generate_image provider for IMAGE_PROVIDER, or
+// nil when it is off
+func newImageProvider(config Config) (imagegen.Provider, error) {
+	httpClient := &http.Client{Timeout: 2 * time.Minute}
+	switch config.ImageProvider {
+	case imageOff:
+		return nil, nil
+	case imageOpenAI:
+		return imagegen.NewOpenAI(config.ImageAPIURL, config.ImageAPIKey, config.ImageModel, httpClient), nil
+	case imageStability:
+		if config.ImageAPIKey == "" {
+			return nil, fmt.Errorf("IMAGE_PROVIDER=stability requires IMAGE_API_KEY")
+		}
+		return imagegen.NewStability(config.ImageAPIURL, config.ImageAPIKey, config.ImageModel, httpClient), nil
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_PROVIDER %q (use off, openai or stability)", config.ImageProvider)
+	}
+}
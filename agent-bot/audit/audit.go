@@ -0,0 +1,158 @@
+// Package audit keeps an append-only JSON Lines log of tool calls: who asked,
+// where, the arguments (capped at 4 KB), the result size, the duration and
+// any error. Each entry is fsynced before Record returns.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"agent-bot/reqid"
+	"agent-bot/tools"
+)
+
+// MaxArgumentsLength caps how much of a tool's input is kept per entry
+const MaxArgumentsLength = 4096
+
+// Entry records one tool invocation
+type Entry struct {
+	Time        time.Time `json:"time"`
+	Tool        string    `json:"tool"`
+	Arguments   string    `json:"arguments"`
+	UserID      string    `json:"user_id,omitempty"`
+	ChannelID   string    `json:"channel_id,omitempty"`
+	ThreadID    string    `json:"thread_id,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
+	ResultBytes int       `json:"result_bytes"`
+	DurationMS  int64     `json:"duration_ms"`
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Filter selects entries; zero fields match everything
+type Filter struct {
+	UserID    string
+	ChannelID string
+	Tool      string
+	Since     time.Time
+	// Limit keeps only the most recent matches
+	Limit int
+}
+
+func (f Filter) matches(e Entry) bool {
+	return (f.UserID == "" || e.UserID == f.UserID) &&
+		(f.ChannelID == "" || e.ChannelID == f.ChannelID) &&
+		(f.Tool == "" || e.Tool == f.Tool) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since))
+}
+
+// Log is an append-only JSON Lines file of tool invocations. Entries are
+// never rewritten, so the file can be shipped to log retention as is.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// Open opens or creates the audit log at path
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{path: path, file: file}, nil
+}
+
+// Close closes the underlying file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Record appends an entry and syncs it to disk
+func (l *Log) Record(entry Entry) error {
+	if len(entry.Arguments) > MaxArgumentsLength {
+		entry.Arguments = entry.Arguments[:MaxArgumentsLength] + "…"
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// RecordToolCall records a tool call made on behalf of the request in ctx
+func (l *Log) RecordToolCall(ctx context.Context, tool string, input json.RawMessage, resultBytes int, duration time.Duration, callErr error) error {
+	entry := Entry{
+		Time:        time.Now().UTC(),
+		Tool:        tool,
+		Arguments:   string(input),
+		ResultBytes: resultBytes,
+		DurationMS:  duration.Milliseconds(),
+		Success:     callErr == nil,
+	}
+	if callErr != nil {
+		entry.Error = callErr.Error()
+	}
+	entry.RequestID = reqid.From(ctx)
+	if req, ok := tools.RequestFrom(ctx); ok {
+		entry.UserID = req.UserID
+		entry.ChannelID = req.ChannelID
+		entry.ThreadID = req.ThreadID
+	}
+	return l.Record(entry)
+}
+
+// Query returns matching entries, oldest first
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Skip partially written lines
+		}
+		if !filter.matches(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		if filter.Limit > 0 && len(entries) > 2*filter.Limit {
+			entries = append(entries[:0], entries[len(entries)-filter.Limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, nil
+}
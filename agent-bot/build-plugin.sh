@@ -0,0 +1,25 @@
+#!/bin/bash
+
+# Builds the agent as a Mattermost server plugin bundle for
+# System Console > Plugins > Plugin Management > Upload Plugin
+
+set -e
+
+cd "$(dirname "$0")"
+
+ID=$(grep -o '"id": *"[^"]*"' plugin.json | head -1 | cut -d'"' -f4)
+VERSION=$(grep -o '"version": *"[^"]*"' plugin.json | head -1 | cut -d'"' -f4)
+BUNDLE="dist/$ID"
+
+rm -rf "$BUNDLE"
+mkdir -p "$BUNDLE/server/dist"
+cp plugin.json "$BUNDLE/"
+
+for ARCH in amd64 arm64; do
+    echo "Building linux-$ARCH..."
+    CGO_ENABLED=0 GOOS=linux GOARCH=$ARCH go build -tags "mattermostplugin $BUILD_TAGS" \
+        -o "$BUNDLE/server/dist/plugin-linux-$ARCH" .
+done
+
+tar -C dist -czf "dist/$ID-$VERSION.tar.gz" "$ID"
+echo "Plugin bundle: dist/$ID-$VERSION.tar.gz"
@@ -0,0 +1,173 @@
+package canary
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/types"
+)
+
+// Comparison pairs the live and canary replies to one message
+type Comparison struct {
+	Message   types.PostedMessage
+	Live      string
+	Canary    string
+	HasLive   bool
+	HasCanary bool
+	seen      time.Time
+}
+
+// Comparator collects live and canary replies by post ID and reports each
+// pair once both arrive, or after a timeout when only one side replied
+type Comparator struct {
+	mu      sync.Mutex
+	pending map[string]*Comparison
+	timeout time.Duration
+	report  func(Comparison)
+}
+
+func NewComparator(timeout time.Duration, report func(Comparison)) *Comparator {
+	return &Comparator{
+		pending: make(map[string]*Comparison),
+		timeout: timeout,
+		report:  report,
+	}
+}
+
+func (c *Comparator) entry(postID string) *Comparison {
+	comparison, ok := c.pending[postID]
+	if !ok {
+		comparison = &Comparison{Message: types.PostedMessage{PostId: postID}, seen: time.Now()}
+		c.pending[postID] = comparison
+	}
+	return comparison
+}
+
+// Expect registers a mirrored message so a reply from either side can be matched to it
+func (c *Comparator) Expect(message types.PostedMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entry(message.PostId).Message = message
+}
+
+// Live records the live bot's reply
+func (c *Comparator) Live(postID, content string) {
+	c.mu.Lock()
+	comparison := c.entry(postID)
+	comparison.Live, comparison.HasLive = content, true
+	done := c.completeLocked(postID)
+	c.mu.Unlock()
+	c.emit(done)
+}
+
+// Canary records the canary's would-be reply
+func (c *Comparator) Canary(postID, content string) {
+	c.mu.Lock()
+	comparison := c.entry(postID)
+	comparison.Canary, comparison.HasCanary = content, true
+	done := c.completeLocked(postID)
+	c.mu.Unlock()
+	c.emit(done)
+}
+
+func (c *Comparator) completeLocked(postID string) *Comparison {
+	comparison := c.pending[postID]
+	if !comparison.HasLive || !comparison.HasCanary {
+		return nil
+	}
+	delete(c.pending, postID)
+	return comparison
+}
+
+func (c *Comparator) emit(comparison *Comparison) {
+	if comparison != nil {
+		c.report(*comparison)
+	}
+}
+
+// Expire reports messages only one side replied to once the timeout has
+// passed and forgets messages neither side replied to
+func (c *Comparator) Expire() {
+	var expired []*Comparison
+	c.mu.Lock()
+	for postID, comparison := range c.pending {
+		if time.Since(comparison.seen) < c.timeout {
+			continue
+		}
+		delete(c.pending, postID)
+		if comparison.HasLive || comparison.HasCanary {
+			expired = append(expired, comparison)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, comparison := range expired {
+		c.report(*comparison)
+	}
+}
+
+// Run expires stale entries periodically until stop is closed
+func (c *Comparator) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Expire()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// maxReportDiffLines bounds the diff included in a report
+const maxReportDiffLines = 40
+
+// Outcome classifies a comparison for metrics
+func (c Comparison) Outcome() string {
+	switch {
+	case !c.HasLive:
+		return "canary_only"
+	case !c.HasCanary:
+		return "live_only"
+	case strings.TrimSpace(c.Live) == strings.TrimSpace(c.Canary):
+		return "identical"
+	default:
+		return "different"
+	}
+}
+
+// Format renders the comparison as a report post
+func (c Comparison) Format() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("#### Canary comparison for post `%s` in `%s`\n", c.Message.PostId, c.Message.ChannelId))
+	if c.Message.Message != "" {
+		sb.WriteString("> " + strings.ReplaceAll(truncate(c.Message.Message, 300), "\n", "\n> ") + "\n\n")
+	}
+
+	switch c.Outcome() {
+	case "canary_only":
+		sb.WriteString("**The canary replied but the live bot did not.**\n\n```\n" + truncate(c.Canary, 2000) + "\n```")
+	case "live_only":
+		sb.WriteString("**The live bot replied but the canary did not.**")
+	case "identical":
+		sb.WriteString("Replies are identical.")
+	default:
+		sb.WriteString(fmt.Sprintf("Word similarity: **%.0f%%** (live %d chars, canary %d chars)\n\n", Similarity(c.Live, c.Canary)*100, len(c.Live), len(c.Canary)))
+		lines := Diff(c.Live, c.Canary)
+		if len(lines) > maxReportDiffLines {
+			lines = append(lines[:maxReportDiffLines], fmt.Sprintf("… %d more lines", len(lines)-maxReportDiffLines))
+		}
+		sb.WriteString("```diff\n" + strings.Join(lines, "\n") + "\n```")
+	}
+	return sb.String()
+}
+
+func truncate(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	return strings.ToValidUTF8(text[:max], "") + "…"
+}
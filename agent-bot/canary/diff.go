@@ -0,0 +1,76 @@
+package canary
+
+import "strings"
+
+// maxSimilarityWords bounds how many words of each reply are compared
+const maxSimilarityWords = 2000
+
+// Diff returns a line diff from live to canary, with "- " for lines only in
+// live, "+ " for lines only in the canary and "  " for shared lines
+func Diff(live, canary string) []string {
+	a := strings.Split(strings.TrimSpace(live), "\n")
+	b := strings.Split(strings.TrimSpace(canary), "\n")
+	table := lcsTable(a, b)
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, "+ "+b[j])
+	}
+	return lines
+}
+
+// Similarity is the share of words the two texts have in common, in order,
+// from 0 (nothing shared) to 1 (same words)
+func Similarity(live, canary string) float64 {
+	a, b := strings.Fields(live), strings.Fields(canary)
+	// Bound the quadratic table for very long replies
+	if len(a) > maxSimilarityWords {
+		a = a[:maxSimilarityWords]
+	}
+	if len(b) > maxSimilarityWords {
+		b = b[:maxSimilarityWords]
+	}
+	if len(a)+len(b) == 0 {
+		return 1
+	}
+	return 2 * float64(lcsTable(a, b)[0][0]) / float64(len(a)+len(b))
+}
+
+// lcsTable returns suffix longest-common-subsequence lengths: table[i][j]
+// is the LCS length of a[i:] and b[j:]
+func lcsTable(a, b []string) [][]int {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+	return table
+}
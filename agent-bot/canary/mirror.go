@@ -0,0 +1,114 @@
+// Package canary shadows a candidate build against the live bot. The live
+// bot's Mirror forwards posted messages and its final replies to a canary
+// instance, and the canary's Comparator pairs the two replies by post ID
+// and reports a line diff.
+package canary
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"agent-bot/types"
+)
+
+// mirrorQueueSize bounds how many events wait to be forwarded; the live bot
+// never blocks on a slow canary, it drops events instead
+const mirrorQueueSize = 256
+
+// Event is a message mirrored from the live bot
+type Event struct {
+	Message types.PostedMessage `json:"message"`
+}
+
+// Response is the reply the live bot actually posted for a message
+type Response struct {
+	PostID  string `json:"post_id"`
+	Content string `json:"content"`
+}
+
+type delivery struct {
+	path string
+	body interface{}
+}
+
+// Mirror forwards incoming messages and the live bot's replies to a canary instance
+type Mirror struct {
+	baseURL string
+	token   string
+	client  *http.Client
+	queue   chan delivery
+}
+
+func NewMirror(baseURL, token string, client *http.Client) *Mirror {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Mirror{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		client:  client,
+		queue:   make(chan delivery, mirrorQueueSize),
+	}
+}
+
+// Event mirrors an incoming message to the canary
+func (m *Mirror) Event(message types.PostedMessage) {
+	m.enqueue(delivery{path: "/canary/events", body: Event{Message: message}})
+}
+
+// Response sends the live reply to a message to the canary for comparison
+func (m *Mirror) Response(message types.PostedMessage, content string) {
+	m.enqueue(delivery{path: "/canary/responses", body: Response{PostID: message.PostId, Content: content}})
+}
+
+func (m *Mirror) enqueue(d delivery) {
+	select {
+	case m.queue <- d:
+	default:
+		log.Printf("[%s] CANARY: Mirror queue full, dropping %s", time.Now().Format("2006-01-02 15:04:05"), d.path)
+	}
+}
+
+// Pending is the number of deliveries waiting to be sent
+func (m *Mirror) Pending() int {
+	return len(m.queue)
+}
+
+// Run delivers queued events in order until the process exits
+func (m *Mirror) Run() {
+	for d := range m.queue {
+		if err := m.send(d); err != nil {
+			log.Printf("[%s] CANARY: Failed to mirror %s: %v", time.Now().Format("2006-01-02 15:04:05"), d.path, err)
+		}
+	}
+}
+
+func (m *Mirror) send(d delivery) error {
+	body, err := json.Marshal(d.body)
+	if err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, m.baseURL+d.path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.token)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("canary returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"agent-bot/canary"
+	"agent-bot/metrics"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// canaryReplyTimeout is how long a canary waits for both replies to a
+// mirrored message before reporting the one it has
+const canaryReplyTimeout = 10 * time.Minute
+
+// canaryQueueSize bounds mirrored messages waiting for the canary agent
+const canaryQueueSize = 100
+
+// dryRunChat is the chat used by a canary: reads go to Mattermost, writes are discarded
+type dryRunChat struct {
+	types.Chat
+	nextID atomic.Int64
+}
+
+func (c *dryRunChat) PostMessage(message types.ChatMessage) (string, error) {
+	id := fmt.Sprintf("canary-%d", c.nextID.Add(1))
+	log.Printf("[%s] CANARY: Dry run, not posting %d chars to channel %s (as %s)", time.Now().Format("2006-01-02 15:04:05"), len(message.Message), message.ChannelId, id)
+	return id, nil
+}
+
+func (c *dryRunChat) UpdateMessage(messageID string, newContent string) error {
+	return nil
+}
+
+func (c *dryRunChat) PostEphemeral(userID string, message types.ChatMessage) error {
+	log.Printf("[%s] CANARY: Dry run, not showing %d chars to %s in channel %s", time.Now().Format("2006-01-02 15:04:05"), len(message.Message), userID, message.ChannelId)
+	return nil
+}
+
+func (c *dryRunChat) UploadFile(upload types.FileUpload) error {
+	log.Printf("[%s] CANARY: Dry run, not uploading %s (%d bytes) to channel %s", time.Now().Format("2006-01-02 15:04:05"), upload.Filename, len(upload.Data), upload.ChannelId)
+	return nil
+}
+
+func (c *dryRunChat) AddReaction(messageID, emoji string) error {
+	return nil
+}
+
+func (c *dryRunChat) RemoveReaction(messageID, emoji string) error {
+	return nil
+}
+
+func (c *dryRunChat) SendTypingIndicator(channelID, threadID string) error {
+	return nil
+}
+
+// startCanary runs the bot as a dry-run canary: instead of listening to the
+// websocket it answers messages mirrored from the live bot, and posts a
+// comparison of both replies to the report channel
+func (b *Bot) startCanary() {
+	log.Printf("[%s] CANARY: Running in canary mode, reporting to channel %s", time.Now().Format("2006-01-02 15:04:05"), b.config.CanaryReportChannel)
+
+	go b.canaryComparator.Run(make(chan struct{}))
+
+	// The agent handles one message at a time, as it does behind the websocket
+	b.canaryEvents = make(chan types.PostedMessage, canaryQueueSize)
+	go func() {
+		for message := range b.canaryEvents {
+			b.agent.MessagePosted(message)
+		}
+	}()
+
+	http.HandleFunc("/canary/events", b.handleCanaryEvent)
+	http.HandleFunc("/canary/responses", b.handleCanaryResponse)
+}
+
+func (b *Bot) authorizeCanary(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(b.config.CanaryToken)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid canary token"})
+		return false
+	}
+	return true
+}
+
+func (b *Bot) handleCanaryEvent(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeCanary(w, r) {
+		return
+	}
+
+	var event canary.Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.Message.PostId == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid event"})
+		return
+	}
+
+	b.canaryComparator.Expect(event.Message)
+	select {
+	case b.canaryEvents <- event.Message:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		metrics.Inc("canary_events_dropped_total")
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "canary is busy"})
+	}
+}
+
+func (b *Bot) handleCanaryResponse(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeCanary(w, r) {
+		return
+	}
+
+	var response canary.Response
+	if err := json.NewDecoder(r.Body).Decode(&response); err != nil || response.PostID == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid response"})
+		return
+	}
+
+	b.canaryComparator.Live(response.PostID, response.Content)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// reportCanaryComparison posts a comparison to the report channel
+func (b *Bot) reportCanaryComparison(comparison canary.Comparison) {
+	outcome := comparison.Outcome()
+	metrics.Inc("canary_comparisons_total", "outcome", outcome)
+	if comparison.HasLive && comparison.HasCanary {
+		metrics.Add("canary_similarity_sum", canary.Similarity(comparison.Live, comparison.Canary))
+	}
+
+	if _, _, err := b.client.CreatePost(&model.Post{ChannelId: b.config.CanaryReportChannel, Message: comparison.Format()}); err != nil {
+		log.Printf("[%s] CANARY: Failed to post comparison for %s: %v", time.Now().Format("2006-01-02 15:04:05"), comparison.Message.PostId, err)
+		return
+	}
+	log.Printf("[%s] CANARY: Reported %s comparison for post %s", time.Now().Format("2006-01-02 15:04:05"), outcome, comparison.Message.PostId)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+// maxCards bounds the cards in one post_cards call
+const maxCards = 10
+
+// cardColors are the named colors the model may use, as in Slack
+var cardColors = map[string]string{
+	"good":    "#2eb886",
+	"warning": "#daa038",
+	"danger":  "#a30200",
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type postCardsArgs struct {
+	Message string     `json:"message,omitempty" jsonschema_description:"Short text posted above the cards (optional)"`
+	Cards   []cardArgs `json:"cards" jsonschema_description:"One card per item, at most 10"`
+}
+
+type cardArgs struct {
+	Title   string       `json:"title" jsonschema_description:"Item name, e.g. the task or issue title"`
+	Link    string       `json:"link,omitempty" jsonschema_description:"URL the title opens"`
+	Text    string       `json:"text,omitempty" jsonschema_description:"Short Markdown description"`
+	Color   string       `json:"color,omitempty" jsonschema_description:"good (green), warning (amber), danger (red) or a hex color such as #439fe0"`
+	Author  string       `json:"author,omitempty" jsonschema_description:"Owner or author shown above the title"`
+	Fields  []cardField  `json:"fields,omitempty" jsonschema_description:"Labelled values such as status, assignee or due date"`
+	Footer  string       `json:"footer,omitempty" jsonschema_description:"Small text at the bottom, e.g. the source system"`
+	Buttons []cardButton `json:"buttons,omitempty" jsonschema_description:"Buttons that open links"`
+}
+
+type cardField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty" jsonschema_description:"Show side by side with other short fields"`
+}
+
+type cardButton struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// postCardsTool lets the model show lists of items, such as tasks or
+// incidents, as structured cards instead of long Markdown lists
+func postCardsTool(chat types.Chat) tools.Tool {
+	return tools.Tool{
+		Name:        "post_cards",
+		Description: "Post structured cards in the current conversation, one per item, e.g. Asana tasks or Jira issues with status, assignee and due date as short fields and a link to open each. Use it instead of a long Markdown list of tool results, then reply with a short summary without repeating the cards.",
+		Schema:      tools.SchemaFor[postCardsArgs](),
+		Handler: tools.Typed(func(ctx context.Context, input postCardsArgs) (interface{}, error) {
+			req, ok := tools.RequestFrom(ctx)
+			if !ok || req.ChannelID == "" {
+				return nil, fmt.Errorf("no conversation to post the cards to")
+			}
+			attachments, err := cardAttachments(input.Cards)
+			if err != nil {
+				return nil, err
+			}
+
+			message := types.ChatMessage{
+				ChannelId:   req.ChannelID,
+				ThreadId:    req.ThreadID,
+				Message:     input.Message,
+				Attachments: attachments,
+			}
+			if _, err := chat.PostMessage(message); err != nil {
+				return nil, fmt.Errorf("error posting cards: %w", err)
+			}
+			return map[string]interface{}{"posted": len(attachments)}, nil
+		}),
+	}
+}
+
+// cardAttachments validates the model's cards and converts them
+func cardAttachments(cards []cardArgs) ([]types.Attachment, error) {
+	switch {
+	case len(cards) == 0:
+		return nil, fmt.Errorf("cards is empty")
+	case len(cards) > maxCards:
+		return nil, fmt.Errorf("%d cards is more than the %d allowed; summarize the rest in your reply", len(cards), maxCards)
+	}
+
+	attachments := make([]types.Attachment, 0, len(cards))
+	for i, card := range cards {
+		title := strings.TrimSpace(card.Title)
+		if title == "" {
+			return nil, fmt.Errorf("card %d has no title", i+1)
+		}
+		color, err := cardColor(card.Color)
+		if err != nil {
+			return nil, fmt.Errorf("card %d: %w", i+1, err)
+		}
+		if err := checkCardURL(card.Link); err != nil {
+			return nil, fmt.Errorf("card %d link: %w", i+1, err)
+		}
+
+		attachment := types.Attachment{
+			Fallback:   title,
+			Color:      color,
+			AuthorName: card.Author,
+			Title:      title,
+			TitleLink:  card.Link,
+			Text:       card.Text,
+			Footer:     card.Footer,
+		}
+		for _, field := range card.Fields {
+			attachment.Fields = append(attachment.Fields, types.AttachmentField(field))
+		}
+		for _, button := range card.Buttons {
+			if button.Label == "" || button.URL == "" {
+				return nil, fmt.Errorf("card %d has a button without a label or URL", i+1)
+			}
+			if err := checkCardURL(button.URL); err != nil {
+				return nil, fmt.Errorf("card %d button %q: %w", i+1, button.Label, err)
+			}
+			attachment.Buttons = append(attachment.Buttons, types.LinkButton(button))
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+func cardColor(color string) (string, error) {
+	color = strings.TrimSpace(color)
+	if named, ok := cardColors[strings.ToLower(color)]; ok {
+		return named, nil
+	}
+	if color != "" && !hexColor.MatchString(color) {
+		return "", fmt.Errorf("color %q is not good, warning, danger or #rrggbb", color)
+	}
+	return color, nil
+}
+
+// checkCardURL accepts empty and http(s) URLs; chats reject anything else
+func checkCardURL(url string) error {
+	if url != "" && !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return fmt.Errorf("%q is not an http or https URL", url)
+	}
+	return nil
+}
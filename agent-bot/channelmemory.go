@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"agent-bot/types"
+)
+
+// handleMemoryCommand implements "!memory list <channel_id>" and "!memory forget <channel_id> <key>"
+func (b *Bot) handleMemoryCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!memory list <channel_id>`, `!memory forget <channel_id> <key>`"
+	if len(args) < 2 {
+		return usage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		entries := b.memory.List(args[1])
+		if len(entries) == 0 {
+			return fmt.Sprintf("Nothing is remembered in `%s`.", args[1])
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("**Remembered in `%s`**\n", args[1]))
+		for _, entry := range entries {
+			line := fmt.Sprintf("- `%s`: %s (set %s", entry.Key, entry.Value, entry.UpdatedAt)
+			if entry.ExpiresAt != "" {
+				line += ", expires " + entry.ExpiresAt
+			}
+			sb.WriteString(line + ")\n")
+		}
+		return sb.String()
+
+	case "forget":
+		if len(args) != 3 {
+			return usage
+		}
+		if err := b.memory.Forget(args[1], args[2]); err != nil {
+			return fmt.Sprintf("Failed to forget: %v", err)
+		}
+		return fmt.Sprintf("Forgot `%s` in `%s`.", args[2], args[1])
+	}
+
+	return usage
+}
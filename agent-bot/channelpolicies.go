@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/policy"
+	"agent-bot/store"
+	"agent-bot/types"
+)
+
+// channelPoliciesBucket stores reply policies set with !policy, which take
+// precedence over channel_policies in the config file
+const channelPoliciesBucket = "channel_policies"
+
+// channelPolicies resolves the reply policy for a channel: reply_policy from
+// the config file, then the channel's channel_policies entry, then !policy.
+// It also counts the unsolicited replies of the last hour per channel.
+type channelPolicies struct {
+	defaults   policy.Policy
+	configured map[string]policy.Policy
+	store      *store.Store
+	// location reads schedules without a timezone, DIGEST_TIMEZONE
+	location *time.Location
+
+	mu          sync.Mutex
+	unsolicited map[string][]time.Time
+}
+
+func newChannelPolicies(config Config, fileConfig *FileConfig, stateStore *store.Store) *channelPolicies {
+	configured := make(map[string]policy.Policy, len(fileConfig.ChannelPolicies))
+	for _, channel := range fileConfig.ChannelPolicies {
+		configured[channel.ChannelID] = channel.Policy
+	}
+	location, err := time.LoadLocation(config.DigestTimezone)
+	if err != nil {
+		location = time.UTC
+	}
+	return &channelPolicies{
+		defaults:    fileConfig.ReplyPolicy,
+		configured:  configured,
+		store:       stateStore,
+		location:    location,
+		unsolicited: make(map[string][]time.Time),
+	}
+}
+
+// For returns the policy for replies in channelID
+func (c *channelPolicies) For(channelID string) policy.Policy {
+	p := c.defaults.Merge(c.configured[channelID])
+	return p.Merge(c.override(channelID))
+}
+
+// override returns the policy set with !policy for a channel
+func (c *channelPolicies) override(channelID string) policy.Policy {
+	var p policy.Policy
+	if c.store != nil {
+		c.store.Get(channelPoliciesBucket, channelID, &p)
+	}
+	return p
+}
+
+// allowUnsolicited reports whether the channel has room for another
+// unsolicited reply under limit; 0 means no limit
+func (c *channelPolicies) allowUnsolicited(channelID string, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.recentUnsolicited(channelID, now)) < limit
+}
+
+// recordUnsolicited counts an unsolicited reply in the channel
+func (c *channelPolicies) recordUnsolicited(channelID string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unsolicited[channelID] = append(c.recentUnsolicited(channelID, now), now)
+}
+
+// recentUnsolicited drops replies older than an hour and returns the rest;
+// c.mu must be held
+func (c *channelPolicies) recentUnsolicited(channelID string, now time.Time) []time.Time {
+	times := c.unsolicited[channelID]
+	kept := times[:0]
+	for _, t := range times {
+		if now.Sub(t) < time.Hour {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		delete(c.unsolicited, channelID)
+		return nil
+	}
+	c.unsolicited[channelID] = kept
+	return kept
+}
+
+// handlePolicyCommand implements "!policy"
+func (b *Bot) handlePolicyCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!policy [channel_id|here]` to show a channel's reply policy, `!policy <channel_id|here> mode=always|never|mention_only|llm keywords=deploy,outage max_per_hour=4 timezone=Europe/Berlin` to change it (`setting=default` clears one), `!policy <channel_id|here> reset`, `!policy list`"
+	if len(args) == 0 {
+		args = []string{"here"}
+	}
+
+	if strings.ToLower(args[0]) == "list" {
+		return b.listChannelPolicies()
+	}
+
+	channelID := args[0]
+	if channelID == "here" {
+		channelID = message.ChannelId
+	}
+	if len(args) == 1 {
+		return fmt.Sprintf("Reply policy for `%s`: %s", channelID, b.policies.For(channelID))
+	}
+
+	if len(args) == 2 && strings.ToLower(args[1]) == "reset" {
+		if err := b.store.Delete(channelPoliciesBucket, channelID); err != nil {
+			return fmt.Sprintf("Failed to reset policy: %v", err)
+		}
+		return fmt.Sprintf("Reply policy for `%s` reset to %s.", channelID, b.policies.For(channelID))
+	}
+
+	override := b.policies.override(channelID)
+	for _, arg := range args[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return usage
+		}
+		if err := override.Set(name, value); err != nil {
+			return fmt.Sprintf("Invalid policy: %v", err)
+		}
+	}
+
+	var err error
+	if override.Empty() {
+		err = b.store.Delete(channelPoliciesBucket, channelID)
+	} else {
+		err = b.store.Put(channelPoliciesBucket, channelID, override)
+	}
+	if err != nil {
+		return fmt.Sprintf("Failed to save policy: %v", err)
+	}
+	return fmt.Sprintf("Reply policy for `%s` is now: %s", channelID, b.policies.For(channelID))
+}
+
+func (b *Bot) listChannelPolicies() string {
+	seen := make(map[string]bool)
+	var channelIDs []string
+	for channelID := range b.policies.configured {
+		seen[channelID] = true
+		channelIDs = append(channelIDs, channelID)
+	}
+	for _, channelID := range b.store.Keys(channelPoliciesBucket) {
+		if !seen[channelID] {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	sort.Strings(channelIDs)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Reply policies**\n- Default: %s\n", b.policies.defaults))
+	for _, channelID := range channelIDs {
+		sb.WriteString(fmt.Sprintf("- `%s`: %s\n", channelID, b.policies.For(channelID)))
+	}
+	return sb.String()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-bot/store"
+	"agent-bot/styles"
+	"agent-bot/types"
+)
+
+// channelStylesBucket stores styles set with !style, which take precedence
+// over channel_styles in the config file
+const channelStylesBucket = "channel_styles"
+
+// channelStyles resolves the reply style for a channel: response_style from
+// the config file, then the channel's channel_styles entry, then !style
+type channelStyles struct {
+	defaults   styles.Style
+	configured map[string]styles.Style
+	store      *store.Store
+}
+
+func newChannelStyles(fileConfig *FileConfig, stateStore *store.Store) *channelStyles {
+	configured := make(map[string]styles.Style, len(fileConfig.ChannelStyles))
+	for _, channel := range fileConfig.ChannelStyles {
+		configured[channel.ChannelID] = channel.Style
+	}
+	return &channelStyles{defaults: fileConfig.ResponseStyle, configured: configured, store: stateStore}
+}
+
+// For returns the style replies in channelID should follow
+func (c *channelStyles) For(channelID string) styles.Style {
+	style := c.defaults.Merge(c.configured[channelID])
+	return style.Merge(c.override(channelID))
+}
+
+// override returns the style set with !style for a channel
+func (c *channelStyles) override(channelID string) styles.Style {
+	var style styles.Style
+	if c.store != nil {
+		c.store.Get(channelStylesBucket, channelID, &style)
+	}
+	return style
+}
+
+// withStyle appends the channel's reply style to the prompt
+func (a *BotAgent) withStyle(channelID, prompt string) string {
+	if a.styles == nil {
+		return prompt
+	}
+	instructions := a.styles.For(channelID).Instructions()
+	if instructions == "" {
+		return prompt
+	}
+	log.Printf("[%s] STYLE: Applying reply style for channel %s", time.Now().Format("2006-01-02 15:04:05"), channelID)
+	return prompt + "\n\n" + instructions
+}
+
+// handleStyleCommand implements "!style"
+func (b *Bot) handleStyleCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!style [channel_id|here]` to show a channel's style, `!style <channel_id|here> verbosity=concise|detailed max_length=600 emoji=on|off sources=on|off footer=on|off` to change it (`setting=default` clears one), `!style <channel_id|here> reset`, `!style list`"
+	if len(args) == 0 {
+		args = []string{"here"}
+	}
+
+	if strings.ToLower(args[0]) == "list" {
+		return b.listChannelStyles()
+	}
+
+	channelID := args[0]
+	if channelID == "here" {
+		channelID = message.ChannelId
+	}
+	if len(args) == 1 {
+		return fmt.Sprintf("Reply style for `%s`: %s", channelID, b.styles.For(channelID))
+	}
+
+	if len(args) == 2 && strings.ToLower(args[1]) == "reset" {
+		if err := b.store.Delete(channelStylesBucket, channelID); err != nil {
+			return fmt.Sprintf("Failed to reset style: %v", err)
+		}
+		return fmt.Sprintf("Reply style for `%s` reset to %s.", channelID, b.styles.For(channelID))
+	}
+
+	override := b.styles.override(channelID)
+	for _, arg := range args[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return usage
+		}
+		if err := override.Set(name, value); err != nil {
+			return fmt.Sprintf("Invalid style: %v", err)
+		}
+	}
+
+	var err error
+	if override.Empty() {
+		err = b.store.Delete(channelStylesBucket, channelID)
+	} else {
+		err = b.store.Put(channelStylesBucket, channelID, override)
+	}
+	if err != nil {
+		return fmt.Sprintf("Failed to save style: %v", err)
+	}
+	return fmt.Sprintf("Reply style for `%s` is now: %s", channelID, b.styles.For(channelID))
+}
+
+func (b *Bot) listChannelStyles() string {
+	seen := make(map[string]bool)
+	var channelIDs []string
+	for channelID := range b.styles.configured {
+		seen[channelID] = true
+		channelIDs = append(channelIDs, channelID)
+	}
+	for _, channelID := range b.store.Keys(channelStylesBucket) {
+		if !seen[channelID] {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	sort.Strings(channelIDs)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Reply styles**\n- Default: %s\n", b.styles.defaults))
+	for _, channelID := range channelIDs {
+		sb.WriteString(fmt.Sprintf("- `%s`: %s\n", channelID, b.styles.For(channelID)))
+	}
+	return sb.String()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"agent-bot/llms"
+)
+
+// maxSources bounds the sources listed under a reply
+const maxSources = 10
+
+// withSources collects the web search citations and knowledge excerpts a
+// reply draws on, for the Sources footer. Channels styled sources=off get
+// no footer.
+func (a *BotAgent) withSources(ctx context.Context, channelID string) context.Context {
+	if a.styles != nil {
+		if show := a.styles.For(channelID).Sources; show != nil && !*show {
+			return ctx
+		}
+	}
+	return llms.WithSources(ctx, &llms.Sources{})
+}
+
+// withSourcesFooter appends the sources the reply cites by [n] marker, one
+// link per line, so they render in Mattermost instead of being lost. Lines
+// keep their [n] rather than forming a Markdown list, which would renumber
+// them when a source goes uncited.
+func withSourcesFooter(ctx context.Context, reply string) string {
+	if reply == "" {
+		return reply
+	}
+
+	var lines []string
+	for i, source := range llms.SourcesFrom(ctx).List() {
+		n := i + 1
+		if !strings.Contains(reply, fmt.Sprintf("[%d]", n)) {
+			continue
+		}
+		if len(lines) == maxSources {
+			break
+		}
+		title := strings.TrimSpace(source.Title)
+		if title == "" {
+			title = source.URL
+		}
+		if source.URL == "" {
+			lines = append(lines, fmt.Sprintf("[%d] %s", n, title))
+			continue
+		}
+		title = strings.NewReplacer("[", "(", "]", ")").Replace(title)
+		lines = append(lines, fmt.Sprintf("[%d] [%s](%s)", n, title, source.URL))
+	}
+	if len(lines) == 0 {
+		return reply
+	}
+	return reply + "\n\n**Sources**\n" + strings.Join(lines, "\n")
+}
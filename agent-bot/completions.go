@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"agent-bot/injection"
+	"agent-bot/llms"
+	"agent-bot/moderation"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+// completionModel is the only model the OpenAI-compatible API offers; the
+// Claude models behind it are chosen by ANTHROPIC_MODEL and MODEL_ROUTING
+const completionModel = "agent-bot"
+
+// Errors for requests the agent won't answer
+var (
+	errCompletionQuota   = errors.New("daily quota exceeded")
+	errCompletionBlocked = errors.New("message refused")
+)
+
+// chatCompletionRequest is the part of an OpenAI chat completions request
+// the agent understands. Sampling parameters are accepted and ignored.
+type chatCompletionRequest struct {
+	Model    string                  `json:"model"`
+	Messages []chatCompletionMessage `json:"messages"`
+	Stream   bool                    `json:"stream,omitempty"`
+	// ChannelID is an extension: the reply follows that channel's style,
+	// language, thinking budget and memory
+	ChannelID string `json:"channel_id,omitempty"`
+}
+
+type chatCompletionMessage struct {
+	Role string `json:"role"`
+	// Content is a string or a list of parts, of which text parts are used
+	Content json.RawMessage `json:"content"`
+}
+
+// text returns the message's text content
+func (m chatCompletionMessage) text() string {
+	var text string
+	if json.Unmarshal(m.Content, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(m.Content, &parts) != nil {
+		return ""
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// chatCompletion is a response, or with stream set one chunk of it
+type chatCompletion struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []chatCompletionChoice `json:"choices"`
+}
+
+type chatCompletionChoice struct {
+	Index        int                  `json:"index"`
+	Message      *chatCompletionReply `json:"message,omitempty"`
+	Delta        *chatCompletionReply `json:"delta,omitempty"`
+	FinishReason *string              `json:"finish_reason"`
+}
+
+type chatCompletionReply struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+// completion is a conversation answered through the agent outside any chat
+type completion struct {
+	UserID    string
+	ChannelID string
+	// Instructions are the caller's system messages
+	Instructions string
+	History      []*types.Message
+	Message      string
+}
+
+// handleModels serves GET /v1/models for clients that list models first
+func (b *Bot) handleModels(w http.ResponseWriter, r *http.Request) {
+	if _, ok := b.authenticateAPIKey(w, r); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data": []map[string]interface{}{
+			{"id": completionModel, "object": "model", "created": b.startedAt.Unix(), "owned_by": "agent-bot"},
+		},
+	})
+}
+
+// handleChatCompletions serves POST /v1/chat/completions, answering with the
+// same prompts, tools, memory and knowledge as in chat
+func (b *Bot) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+	key, ok := b.authenticateAPIKey(w, r)
+	if !ok {
+		return
+	}
+	agent, ok := b.agent.(*BotAgent)
+	if !ok {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "agent unavailable"})
+		return
+	}
+	ctx := apiRequestContext(w, r)
+
+	var req chatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON body"})
+		return
+	}
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != "user" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "messages must end with a user message"})
+		return
+	}
+	// A channel-scoped key can't leave the channel out to escape its scope
+	if req.ChannelID == "" && len(key.Scope.Channels) > 0 {
+		reqid.Logf(ctx, "API: Key %s is channel-scoped but sent no channel_id", key.ID)
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "channel_id is required for channel-scoped API keys"})
+		return
+	}
+	if req.ChannelID != "" && !key.CanPostTo(req.ChannelID) {
+		reqid.Logf(ctx, "API: Key %s denied access to channel %s", key.ID, req.ChannelID)
+		writeJSON(w, http.StatusForbidden, apiError{Error: "API key is not scoped to this channel"})
+		return
+	}
+
+	c := completion{UserID: "apikey:" + key.ID, ChannelID: req.ChannelID}
+	var instructions []string
+	for _, message := range req.Messages[:len(req.Messages)-1] {
+		switch message.Role {
+		case "system", "developer":
+			instructions = append(instructions, message.text())
+		case "user":
+			c.History = append(c.History, &types.Message{UserID: c.UserID, Content: message.text()})
+		case "assistant":
+			c.History = append(c.History, &types.Message{UserID: agent.botUserID, Content: message.text()})
+		}
+	}
+	c.Instructions = strings.Join(instructions, "\n\n")
+	c.Message = req.Messages[len(req.Messages)-1].text()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	if !key.Scope.AllowTools {
+		ctx = llms.WithoutTools(ctx)
+	}
+	ctx = tools.WithRequest(ctx, tools.Request{UserID: c.UserID, ChannelID: c.ChannelID, ThreadID: reqid.From(ctx)})
+	reqid.Logf(ctx, "API: Key %s requested a chat completion with %d messages (stream: %v, tools: %v)", key.ID, len(req.Messages), req.Stream, key.Scope.AllowTools)
+
+	response := chatCompletion{
+		ID:      "chatcmpl-" + reqid.From(ctx),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   completionModel,
+	}
+	if !req.Stream {
+		reply, err := agent.answerCompletion(ctx, c, nil)
+		if err != nil {
+			writeCompletionError(ctx, w, err)
+			return
+		}
+		stop := "stop"
+		response.Choices = []chatCompletionChoice{{Message: &chatCompletionReply{Role: "assistant", Content: reply}, FinishReason: &stop}}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	// Server-sent events, as OpenAI streams them
+	response.Object = "chat.completion.chunk"
+	flusher, _ := w.(http.Flusher)
+	started := false
+	send := func(choice chatCompletionChoice) {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		response.Choices = []chatCompletionChoice{choice}
+		data, _ := json.Marshal(response)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	streamed := false
+	reply, err := agent.answerCompletion(ctx, c, func(delta string) {
+		if !streamed {
+			send(chatCompletionChoice{Delta: &chatCompletionReply{Role: "assistant"}})
+			streamed = true
+		}
+		send(chatCompletionChoice{Delta: &chatCompletionReply{Content: delta}})
+	})
+	if err != nil && !started {
+		writeCompletionError(ctx, w, err)
+		return
+	}
+	if err != nil {
+		reqid.Logf(ctx, "API: Chat completion failed mid-stream: %v", err)
+		data, _ := json.Marshal(apiError{Error: "LLM request failed"})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		return
+	}
+	if !streamed {
+		// Screened replies arrive in one piece
+		send(chatCompletionChoice{Delta: &chatCompletionReply{Role: "assistant", Content: reply}})
+	}
+	stop := "stop"
+	send(chatCompletionChoice{Delta: &chatCompletionReply{}, FinishReason: &stop})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeCompletionError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCompletionQuota):
+		w.Header().Set("Retry-After", "3600")
+		writeJSON(w, http.StatusTooManyRequests, apiError{Error: err.Error()})
+	case errors.Is(err, errCompletionBlocked):
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+	default:
+		reqid.Logf(ctx, "API: Chat completion failed: %v", err)
+		writeJSON(w, http.StatusBadGateway, apiError{Error: "LLM request failed"})
+	}
+}
+
+// answerCompletion builds the prompt the way respondToMessage does and
+// returns the finished reply. Unless moderation has to see the whole reply
+// first, each piece of text is passed to deliver as it streams in.
+func (a *BotAgent) answerCompletion(ctx context.Context, c completion, deliver func(delta string)) (string, error) {
+	message := types.PostedMessage{PostId: reqid.From(ctx), UserId: c.UserID, ChannelId: c.ChannelID, Message: c.Message}
+
+	if a.quotas != nil && a.features.Enabled(FeatureQuotas) {
+		if scope, limit, _, over := a.quotas.exceeded(c.UserID, c.ChannelID); over {
+			reqid.Logf(ctx, "QUOTA: %s is over its daily quota (%s), not answering %s", scope, limit, c.UserID)
+			return "", fmt.Errorf("%w: %s daily budget of %s used up", errCompletionQuota, scope, limit)
+		}
+	}
+	if a.moderating() {
+		req, _ := tools.RequestFrom(ctx)
+		verdict := a.moderator.screen(ctx, moderation.Incoming, message.Message, req)
+		if verdict.Action == moderation.Block {
+			return "", fmt.Errorf("%w: content moderation flagged it (%s)", errCompletionBlocked, verdict.Category)
+		}
+		message.Message = verdict.Text
+		deliver = nil
+	}
+	if a.guardingInjection() {
+		if reason := a.injectionReason(ctx, message.Message); reason != "" {
+			reqid.Logf(ctx, "INJECTION: Completion for %s looks like prompt injection (%s), policy %s", c.UserID, reason, a.injection.policy)
+			if a.injection.policy == injectionRefuse {
+				return "", fmt.Errorf("%w: it looks like an attempt to change the instructions (%s)", errCompletionBlocked, reason)
+			}
+			ctx = context.WithValue(ctx, injectionKey{}, true)
+		}
+	}
+
+	// Earlier messages that don't fit the context budget are left out
+	elided, kept := a.boundHistory(c.History)
+	data := prompts.ContextData{
+		Posts:          make([]prompts.Post, 0, len(kept)),
+		Speaker:        "User",
+		Message:        injection.Escape(message.Message),
+		MessageFlagged: injectionFlagged(ctx),
+	}
+	for _, p := range kept {
+		post := a.screenPost(p)
+		post.Speaker = "User"
+		if p.UserID == a.botUserID {
+			post.Speaker = a.botDisplayName
+		}
+		data.Posts = append(data.Posts, post)
+	}
+	// Earlier exchanges are sent as turns of their own, like in threads
+	if turns := conversationTurns(&data); len(turns) > 0 {
+		ctx = llms.WithHistory(ctx, turns)
+	}
+	prompt, err := a.prompts.Render(prompts.Context, data)
+	if err != nil {
+		return "", err
+	}
+	reqid.Logf(ctx, "API: Built context with %d messages, %d left out (%d chars)", len(kept), len(elided), len(prompt))
+
+	ctx = a.routeReply(ctx, message, prompt)
+	prompt = a.applyResponseTemplate(ctx, prompt)
+	prompt = a.withStyle(c.ChannelID, prompt)
+	prompt = a.withLanguage(message, prompt)
+	prompt = a.withUserMemory(c.UserID, prompt)
+	ctx = a.withSources(ctx, c.ChannelID)
+	prompt = a.withKnowledge(ctx, c.ChannelID, message.Message, prompt)
+	if c.Instructions != "" {
+		prompt += "\n\nInstructions from the service making this request:\n" + c.Instructions
+	}
+
+	ctx = a.withSystemPrompt(ctx)
+	ctx = a.withThinking(ctx, c.ChannelID)
+
+	llm := a.replyLLM(ctx)
+	chunks, err := llm.PromptStream(ctx, prompt)
+	if err != nil && llm != a.llm {
+		reqid.Logf(ctx, "WARNING: Small model unavailable, using the main model: %v", err)
+		chunks, err = a.llm.PromptStream(ctx, prompt)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var reply strings.Builder
+	for chunk := range chunks {
+		if chunk.Error != nil {
+			return reply.String(), chunk.Error
+		}
+		if chunk.Done {
+			break
+		}
+		reply.WriteString(chunk.Content)
+		if deliver != nil && chunk.Content != "" {
+			deliver(chunk.Content)
+		}
+	}
+	if ctx.Err() != nil {
+		return reply.String(), ctx.Err()
+	}
+	answer := reply.String()
+	if withFooter := withSourcesFooter(ctx, answer); withFooter != answer {
+		if deliver != nil {
+			deliver(strings.TrimPrefix(withFooter, answer))
+		}
+		answer = withFooter
+	}
+	return a.moderateReply(ctx, answer), nil
+}
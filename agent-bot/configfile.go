@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"agent-bot/asana"
+	"agent-bot/hooks"
+	"agent-bot/mcpclient"
+	"agent-bot/moderation"
+	"agent-bot/notify"
+	"agent-bot/pii"
+	"agent-bot/policy"
+	"agent-bot/standup"
+	"agent-bot/styles"
+	"agent-bot/templates"
+	"agent-bot/usage"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig holds structured settings that don't fit in environment variables.
+// It is loaded from the YAML file named by CONFIG_FILE.
+type FileConfig struct {
+	// MCPServers are local MCP servers spawned over stdio whose tools are bridged into the tool registry
+	MCPServers []mcpclient.ServerConfig `yaml:"mcp_servers"`
+
+	// ResponseTemplates structure answers to recurring request types
+	ResponseTemplates []templates.Template `yaml:"response_templates"`
+
+	// ResponseStyle is the default reply style: max_length, verbosity, emoji, sources and footer
+	ResponseStyle styles.Style `yaml:"response_style"`
+
+	// ChannelStyles override ResponseStyle for individual channels
+	ChannelStyles []styles.ChannelStyle `yaml:"channel_styles"`
+
+	// ReplyPolicy is the default policy for answering without a mention: mode, keywords, schedule and cap
+	ReplyPolicy policy.Policy `yaml:"reply_policy"`
+
+	// ChannelPolicies override ReplyPolicy for individual channels
+	ChannelPolicies []policy.ChannelPolicy `yaml:"channel_policies"`
+
+	// ModelPrices overrides the built-in USD per million token prices used for usage exports
+	ModelPrices map[string]usage.Price `yaml:"model_prices"`
+
+	// NotificationRules route incoming webhooks to channels and users
+	NotificationRules []notify.Rule `yaml:"notification_rules"`
+
+	// Hooks are named endpoints at /hooks/<name> whose JSON payloads the agent triages into a channel
+	Hooks []hooks.Config `yaml:"hooks"`
+
+	// AsanaNotifications post Asana project activity to channels, via webhooks registered with !asana register
+	AsanaNotifications []asana.Subscription `yaml:"asana_notifications"`
+
+	// Standups DM members at a set time and post a compiled summary to a channel
+	Standups []standup.Config `yaml:"standups"`
+
+	// ModerationKeywords are word lists redacted or blocked in messages to and replies from the bot
+	ModerationKeywords []moderation.KeywordList `yaml:"moderation_keywords"`
+
+	// PIIRules are extra patterns and word lists redacted from prompts before they reach the LLM
+	PIIRules []pii.Rule `yaml:"pii_rules"`
+
+	// ToolTimeouts override TOOL_TIMEOUT_SECONDS for individual tools, e.g. fetch_url: 45s
+	ToolTimeouts map[string]time.Duration `yaml:"tool_timeouts"`
+
+	// Servers are further Mattermost workspaces served by this process
+	Servers []ServerProfile `yaml:"servers"`
+}
+
+// loadFileConfig reads the YAML config file; a missing file yields an empty config
+func loadFileConfig(path string) (*FileConfig, error) {
+	config := &FileConfig{}
+	if path == "" {
+		return config, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return config, nil
+}
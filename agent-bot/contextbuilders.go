@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"agent-bot/injection"
+	"agent-bot/knowledge"
+	"agent-bot/llms"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/store"
+	"agent-bot/tracing"
+	"agent-bot/types"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// contextStrategiesBucket stores per-channel context strategies set with
+// !context, which replace CONTEXT_STRATEGY in that channel
+const contextStrategiesBucket = "context_strategies"
+
+// Context strategies: how a conversation is turned into the prompt
+const (
+	// contextFull sends every post of the thread, whatever the budget
+	contextFull = "full"
+	// contextRecent sends the most recent posts that fit the budget
+	contextRecent = "recent"
+	// contextSummarized also sends a rolling summary of the posts that don't
+	// fit; it is the default
+	contextSummarized = "summarized"
+	// contextRAG also sends the older posts most relevant to the message
+	contextRAG = "rag"
+	// contextChannel is summarized plus the latest top-level posts elsewhere
+	// in the channel
+	contextChannel = "channel"
+)
+
+var contextStrategies = []string{contextFull, contextRecent, contextSummarized, contextRAG, contextChannel}
+
+const (
+	// ragContextPosts bounds the older posts the rag strategy brings back
+	ragContextPosts = 5
+	// channelHistorySize is how many top-level posts are kept per channel
+	// for the channel strategy
+	channelHistorySize = 20
+)
+
+// validContextStrategy checks a CONTEXT_STRATEGY or !context value
+func validContextStrategy(strategy string) error {
+	for _, known := range contextStrategies {
+		if strategy == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown context strategy %q (use %s)", strategy, strings.Join(contextStrategies, ", "))
+}
+
+// ContextBuilder gathers the conversation a message belongs to for the
+// context prompt. ok is false when the conversation can't be loaded, and the
+// message is then answered on its own.
+type ContextBuilder interface {
+	Build(ctx context.Context, message types.PostedMessage) (data prompts.ContextData, ok bool)
+}
+
+// newContextBuilders returns a builder for every context strategy
+func newContextBuilders(a *BotAgent) map[string]ContextBuilder {
+	return map[string]ContextBuilder{
+		contextFull:       fullContext{a},
+		contextRecent:     recentContext{a},
+		contextSummarized: summarizedContext{a},
+		contextRAG:        ragContext{a},
+		contextChannel:    channelContext{a},
+	}
+}
+
+// channelContexts resolves the context strategy of a channel
+type channelContexts struct {
+	defaultStrategy string
+	store           *store.Store
+}
+
+func newChannelContexts(config Config, stateStore *store.Store) *channelContexts {
+	return &channelContexts{defaultStrategy: config.ContextStrategy, store: stateStore}
+}
+
+// For returns the strategy for replies in channelID
+func (c *channelContexts) For(channelID string) string {
+	strategy, _ := c.override(channelID)
+	return strategy
+}
+
+// override returns the strategy set with !context for a channel, or the
+// default and false when there is none
+func (c *channelContexts) override(channelID string) (string, bool) {
+	if c.store != nil {
+		var strategy string
+		if found, err := c.store.Get(contextStrategiesBucket, channelID, &strategy); found && err == nil {
+			return strategy, true
+		}
+	}
+	return c.defaultStrategy, false
+}
+
+// buildContext gathers the message's conversation with its channel's
+// context builder
+func (a *BotAgent) buildContext(ctx context.Context, message types.PostedMessage) (prompts.ContextData, bool) {
+	strategy := contextSummarized
+	if a.contexts != nil {
+		strategy = a.contexts.For(message.ChannelId)
+	}
+	builder, ok := a.contextBuilders[strategy]
+	if !ok {
+		builder = a.contextBuilders[contextSummarized]
+	}
+
+	ctx, span := tracing.Start(ctx, "agent.build_context",
+		attribute.String("chat.thread_id", message.ThreadId),
+		attribute.String("context.strategy", strategy),
+	)
+	defer span.End()
+
+	data, ok := builder.Build(ctx, message)
+	span.SetAttributes(
+		attribute.Int("context.posts", len(data.Posts)),
+		attribute.Int("context.channel_posts", len(data.Channel)),
+	)
+	return data, ok
+}
+
+// getThreadContext renders the message's conversation as one prompt, as the
+// decision LLM reads it
+func (a *BotAgent) getThreadContext(ctx context.Context, message types.PostedMessage) (string, error) {
+	data, ok := a.buildContext(ctx, message)
+	if !ok {
+		return message.Message, nil
+	}
+	return a.renderContext(ctx, data)
+}
+
+// threadConversation returns the prompt for answering the message, with the
+// posts up to the bot's last reply moved out of it into the conversation
+// turns of the returned context
+func (a *BotAgent) threadConversation(ctx context.Context, message types.PostedMessage) (context.Context, string, error) {
+	data, ok := a.buildContext(ctx, message)
+	if !ok {
+		return ctx, message.Message, nil
+	}
+	if turns := conversationTurns(&data); len(turns) > 0 {
+		reqid.Logf(ctx, "THREAD: Sending %d earlier posts as conversation turns", len(turns))
+		ctx = llms.WithHistory(ctx, turns)
+	}
+	prompt, err := a.renderContext(ctx, data)
+	return ctx, prompt, err
+}
+
+// conversationTurns moves the posts up to the bot's last one out of data,
+// as assistant turns for the bot's posts and quoted user turns for everyone
+// else's. The posts after it, which the bot hasn't answered, stay quoted in
+// the prompt with the message.
+func conversationTurns(data *prompts.ContextData) []llms.Turn {
+	last := -1
+	for i, post := range data.Posts {
+		if post.FromBot {
+			last = i
+		}
+	}
+	if last < 0 {
+		return nil
+	}
+
+	turns := make([]llms.Turn, 0, last+1)
+	for _, post := range data.Posts[:last+1] {
+		if post.FromBot {
+			turns = append(turns, llms.Turn{Role: llms.RoleAssistant, Text: post.Content})
+			continue
+		}
+		turns = append(turns, llms.Turn{Role: llms.RoleUser, Text: post.Quote()})
+	}
+	data.Posts = data.Posts[last+1:]
+	return turns
+}
+
+// threadHistory returns the posts of the message's thread before it, oldest
+// first, and their authors. ok is false when the thread can't be loaded.
+func (a *BotAgent) threadHistory(ctx context.Context, message types.PostedMessage) (history []*types.Message, users map[string]*types.User, ok bool) {
+	rootID := message.ThreadId
+	if rootID == "" {
+		rootID = message.PostId // If this will become the root of a new thread
+	}
+
+	// Get all posts in the thread, except the current message which is added separately
+	history, users, err := a.loadThread(rootID, message.PostId, message.UserId)
+	if err != nil {
+		reqid.Logf(ctx, "THREAD: Failed to get thread context: %v", err)
+		return nil, nil, false
+	}
+	// Debounced follow-ups are part of the current message
+	return withoutCoalescedPosts(ctx, history), users, true
+}
+
+// contextData starts the context data with the message being answered
+func (a *BotAgent) contextData(ctx context.Context, message types.PostedMessage, users map[string]*types.User) prompts.ContextData {
+	data := prompts.ContextData{Speaker: "User", Message: injection.Escape(message.Message), MessageFlagged: injectionFlagged(ctx)}
+	if user, found := users[message.UserId]; found {
+		data.Speaker = user.Username
+	}
+	return data
+}
+
+// renderContext renders the context prompt
+func (a *BotAgent) renderContext(ctx context.Context, data prompts.ContextData) (string, error) {
+	result, err := a.prompts.Render(prompts.Context, data)
+	if err != nil {
+		return "", err
+	}
+	reqid.Logf(ctx, "THREAD: Built context with %d posts, %d from the channel (%d chars)", len(data.Posts), len(data.Channel), len(result))
+	return result, nil
+}
+
+// fullContext sends the whole thread
+type fullContext struct{ agent *BotAgent }
+
+func (b fullContext) Build(ctx context.Context, message types.PostedMessage) (prompts.ContextData, bool) {
+	history, users, ok := b.agent.threadHistory(ctx, message)
+	if !ok {
+		return prompts.ContextData{}, false
+	}
+	data := b.agent.contextData(ctx, message, users)
+	data.Posts = b.agent.promptPosts(history, users)
+	return data, true
+}
+
+// recentContext sends the most recent posts that fit the budget and notes
+// how many were left out
+type recentContext struct{ agent *BotAgent }
+
+func (b recentContext) Build(ctx context.Context, message types.PostedMessage) (prompts.ContextData, bool) {
+	history, users, ok := b.agent.threadHistory(ctx, message)
+	if !ok {
+		return prompts.ContextData{}, false
+	}
+	elided, kept := b.agent.boundHistory(history)
+	data := b.agent.contextData(ctx, message, users)
+	data.Posts = b.agent.promptPosts(kept, users)
+	if len(elided) > 0 {
+		data.Summary = fmt.Sprintf("(%d earlier messages omitted)", len(elided))
+	}
+	return data, true
+}
+
+// summarizedContext sends the most recent posts that fit the budget and a
+// rolling summary of the rest
+type summarizedContext struct{ agent *BotAgent }
+
+func (b summarizedContext) Build(ctx context.Context, message types.PostedMessage) (prompts.ContextData, bool) {
+	data, _, ok := b.agent.summarizedThread(ctx, message)
+	return data, ok
+}
+
+// summarizedThread is the context data of the summarized strategy
+func (a *BotAgent) summarizedThread(ctx context.Context, message types.PostedMessage) (prompts.ContextData, map[string]*types.User, bool) {
+	history, users, ok := a.threadHistory(ctx, message)
+	if !ok {
+		return prompts.ContextData{}, nil, false
+	}
+	elided, kept := a.boundHistory(history)
+	data := a.contextData(ctx, message, users)
+	data.Posts = a.promptPosts(kept, users)
+	if len(elided) > 0 {
+		rootID := message.ThreadId
+		if rootID == "" {
+			rootID = message.PostId
+		}
+		data.Summary = a.summarizeElided(ctx, rootID, elided, users)
+	}
+	return data, users, true
+}
+
+// ragContext sends the most recent posts that fit the budget, preceded by
+// the older posts that match the message best
+type ragContext struct{ agent *BotAgent }
+
+func (b ragContext) Build(ctx context.Context, message types.PostedMessage) (prompts.ContextData, bool) {
+	history, users, ok := b.agent.threadHistory(ctx, message)
+	if !ok {
+		return prompts.ContextData{}, false
+	}
+	elided, kept := b.agent.boundHistory(history)
+	relevant := b.relevant(message.Message, elided)
+	data := b.agent.contextData(ctx, message, users)
+	data.Posts = b.agent.promptPosts(append(relevant, kept...), users)
+	if len(elided) > 0 {
+		data.Summary = fmt.Sprintf("(%d earlier messages omitted; the %d most relevant to the latest message are included)", len(elided)-len(relevant), len(relevant))
+	}
+	return data, true
+}
+
+// relevant returns up to ragContextPosts of posts ranked by relevance to
+// query, within a quarter of the token budget, in their original order
+func (b ragContext) relevant(query string, posts []*types.Message) []*types.Message {
+	if len(posts) == 0 {
+		return nil
+	}
+	index, _ := knowledge.Open("")
+	for _, p := range posts {
+		index.Add(knowledge.Document{ID: p.ID, Text: p.Content, CreatedAt: p.Timestamp})
+	}
+	matched := make(map[string]bool)
+	tokens := 0
+	for _, result := range index.Search(query, ragContextPosts, knowledge.Scope{}) {
+		cost := estimateTokens(result.Text)
+		if tokens+cost > b.agent.contextMaxTokens/4 {
+			break
+		}
+		tokens += cost
+		matched[result.ID] = true
+	}
+
+	var relevant []*types.Message
+	for _, p := range posts {
+		if matched[p.ID] {
+			relevant = append(relevant, p)
+		}
+	}
+	return relevant
+}
+
+// channelContext sends the summarized thread and the latest top-level posts
+// in the channel, for conversations that refer to what was said around them
+type channelContext struct{ agent *BotAgent }
+
+func (b channelContext) Build(ctx context.Context, message types.PostedMessage) (prompts.ContextData, bool) {
+	data, users, ok := b.agent.summarizedThread(ctx, message)
+	if !ok {
+		return data, false
+	}
+
+	var recent []*types.Message
+	for _, p := range b.agent.channelHistory.recent(message.ChannelId) {
+		// The thread's root and the message itself are already in the prompt
+		if p.ID != message.ThreadId && p.ID != message.PostId {
+			recent = append(recent, p)
+		}
+	}
+	if len(recent) > 0 {
+		userIDs := make([]string, 0, len(recent))
+		for _, p := range recent {
+			if _, known := users[p.UserID]; !known {
+				userIDs = append(userIDs, p.UserID)
+			}
+		}
+		for id, user := range b.agent.lookupUsers(userIDs) {
+			users[id] = user
+		}
+		data.Channel = b.agent.promptPosts(recent, users)
+	}
+	return data, true
+}
+
+// channelHistory keeps the latest top-level posts of each channel, as they
+// arrive, for the channel context strategy
+type channelHistory struct {
+	mu    sync.Mutex
+	posts map[string][]*types.Message
+}
+
+func newChannelHistory() *channelHistory {
+	return &channelHistory{posts: make(map[string][]*types.Message)}
+}
+
+// record keeps message if it starts a conversation in a channel
+func (h *channelHistory) record(message types.PostedMessage, timestamp int64) {
+	if message.IsDM || message.ThreadId != "" || strings.TrimSpace(message.Message) == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	posts := append(h.posts[message.ChannelId], &types.Message{
+		ID:        message.PostId,
+		UserID:    message.UserId,
+		ChannelID: message.ChannelId,
+		Content:   message.Message,
+		Timestamp: timestamp,
+	})
+	if len(posts) > channelHistorySize {
+		posts = posts[len(posts)-channelHistorySize:]
+	}
+	h.posts[message.ChannelId] = posts
+}
+
+// recent returns the channel's kept posts, oldest first
+func (h *channelHistory) recent(channelID string) []*types.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*types.Message(nil), h.posts[channelID]...)
+}
+
+// handleContextCommand implements "!context"
+func (b *Bot) handleContextCommand(message types.PostedMessage, args []string) string {
+	usage := fmt.Sprintf("Usage: `!context [channel_id|here]` to show how a channel's conversations are sent to the model, `!context <channel_id|here> %s`, `!context <channel_id|here> default`, `!context list`", strings.Join(contextStrategies, "|"))
+	if len(args) == 0 {
+		args = []string{"here"}
+	}
+
+	if strings.ToLower(args[0]) == "list" {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("**Context strategies**\n- Default: %s\n", b.contexts.defaultStrategy))
+		channelIDs := b.store.Keys(contextStrategiesBucket)
+		sort.Strings(channelIDs)
+		for _, channelID := range channelIDs {
+			sb.WriteString(fmt.Sprintf("- `%s`: %s\n", channelID, b.contexts.For(channelID)))
+		}
+		return sb.String()
+	}
+
+	channelID := args[0]
+	if channelID == "here" {
+		channelID = message.ChannelId
+	}
+	if len(args) == 1 {
+		strategy, overridden := b.contexts.override(channelID)
+		if !overridden {
+			return fmt.Sprintf("Context strategy in `%s`: %s (default)", channelID, strategy)
+		}
+		return fmt.Sprintf("Context strategy in `%s`: %s", channelID, strategy)
+	}
+	if len(args) != 2 {
+		return usage
+	}
+
+	strategy := strings.ToLower(args[1])
+	if strategy == "default" {
+		if err := b.store.Delete(contextStrategiesBucket, channelID); err != nil {
+			return fmt.Sprintf("Failed to reset context strategy: %v", err)
+		}
+		return fmt.Sprintf("Context strategy in `%s` reset to the default: %s", channelID, b.contexts.defaultStrategy)
+	}
+	if err := validContextStrategy(strategy); err != nil {
+		return usage
+	}
+	if err := b.store.Put(contextStrategiesBucket, channelID, strategy); err != nil {
+		return fmt.Sprintf("Failed to save context strategy: %v", err)
+	}
+	return fmt.Sprintf("Context strategy in `%s` is now: %s", channelID, strategy)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"agent-bot/controlplane"
+	"agent-bot/controlplane/controlpb"
+	"agent-bot/notify"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/tools"
+	"agent-bot/usage"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// controlService implements the gRPC control plane over the workspaces
+type controlService struct {
+	controlpb.UnimplementedControlServiceServer
+	bots []*Bot
+	logs *controlplane.Logs
+}
+
+// startControlPlane serves the gRPC control plane on port, authenticated
+// with the primary workspace's ADMIN_API_TOKEN
+func startControlPlane(port string, bots []*Bot, logs *controlplane.Logs) error {
+	if bots[0].config.AdminAPIToken == "" {
+		return fmt.Errorf("GRPC_PORT requires ADMIN_API_TOKEN")
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %w", err)
+	}
+
+	server := controlplane.NewServer(bots[0].config.AdminAPIToken, &controlService{bots: bots, logs: logs})
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Printf("[%s] GRPC: Control plane stopped: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+	}()
+	log.Printf("[%s] GRPC: Control plane listening on port %s", time.Now().Format("2006-01-02 15:04:05"), port)
+	return nil
+}
+
+// workspace finds a workspace by profile name, the primary one when name is empty
+func (s *controlService) workspace(name string) (*Bot, error) {
+	if name == "" {
+		return s.bots[0], nil
+	}
+	for _, bot := range s.bots {
+		if bot.profileName() == name {
+			return bot, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "no workspace named %q", name)
+}
+
+func (s *controlService) SendMessage(ctx context.Context, req *controlpb.SendMessageRequest) (*controlpb.SendMessageResponse, error) {
+	b, err := s.workspace(req.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	if req.ChannelId == "" || (req.Message == "") == (req.Prompt == "") {
+		return nil, status.Error(codes.InvalidArgument, "channel_id and one of message or prompt are required")
+	}
+	ctx = reqid.Ensure(ctx)
+
+	content := req.Message
+	if req.Prompt != "" {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		defer cancel()
+		ctx = tools.WithRequest(ctx, tools.Request{UserID: "admin:grpc", ChannelID: req.ChannelId, ThreadID: req.ThreadId})
+
+		reqid.Logf(ctx, "GRPC: Prompting LLM for channel %s", req.ChannelId)
+		response, err := b.llmBackend.Prompt(ctx, req.Prompt)
+		if err != nil {
+			reqid.Logf(ctx, "GRPC: LLM request failed: %v", err)
+			return nil, status.Error(codes.Unavailable, "LLM request failed")
+		}
+		content = response
+	}
+
+	post, _, err := b.client.CreatePost(&model.Post{
+		ChannelId: req.ChannelId,
+		RootId:    req.ThreadId,
+		Message:   content,
+	})
+	if err != nil {
+		reqid.Logf(ctx, "GRPC: Failed to post message: %v", err)
+		return nil, status.Error(codes.Unavailable, "failed to post message")
+	}
+
+	reqid.Logf(ctx, "GRPC: Posted message %s to channel %s", post.Id, req.ChannelId)
+	return &controlpb.SendMessageResponse{PostId: post.Id, Message: content}, nil
+}
+
+func (s *controlService) GetUsage(ctx context.Context, req *controlpb.GetUsageRequest) (*controlpb.GetUsageResponse, error) {
+	b, err := s.workspace(req.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	month := req.Month
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	}
+	if err := usage.ValidMonth(month); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	rows := b.usage.Month(month)
+	resp := &controlpb.GetUsageResponse{Month: month, Rows: make([]*controlpb.UsageRow, 0, len(rows))}
+	for _, row := range rows {
+		resp.Rows = append(resp.Rows, usageRow(row))
+	}
+	resp.Totals = usageRow(usage.Totals(rows))
+	return resp, nil
+}
+
+func usageRow(row usage.Row) *controlpb.UsageRow {
+	return &controlpb.UsageRow{
+		TeamId:       row.TeamID,
+		ChannelId:    row.ChannelID,
+		UserId:       row.UserID,
+		Model:        row.Model,
+		Tool:         row.Tool,
+		Requests:     row.Requests,
+		InputTokens:  row.InputTokens,
+		OutputTokens: row.OutputTokens,
+		CostUsd:      row.CostUSD,
+	}
+}
+
+func (s *controlService) ListThreads(ctx context.Context, req *controlpb.ListThreadsRequest) (*controlpb.ListThreadsResponse, error) {
+	b, err := s.workspace(req.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	agent, ok := b.agent.(*BotAgent)
+	if !ok {
+		return nil, status.Error(codes.Unavailable, "agent is not running")
+	}
+
+	resp := &controlpb.ListThreadsResponse{Threads: []*controlpb.Thread{}}
+	for _, threadID := range agent.activeThreadIDs() {
+		thread := &controlpb.Thread{ThreadId: threadID}
+		if state, found := agent.threadState(threadID); found {
+			thread.LastPostId = state.LastPostID
+			if !state.JoinedAt.IsZero() {
+				thread.JoinedAt = timestamppb.New(state.JoinedAt)
+			}
+			if !state.LastPostAt.IsZero() {
+				thread.LastPostAt = timestamppb.New(state.LastPostAt)
+			}
+		}
+		resp.Threads = append(resp.Threads, thread)
+	}
+	return resp, nil
+}
+
+func (s *controlService) ReloadConfig(ctx context.Context, req *controlpb.ReloadConfigRequest) (*controlpb.ReloadConfigResponse, error) {
+	bots := s.bots
+	if req.Workspace != "" {
+		b, err := s.workspace(req.Workspace)
+		if err != nil {
+			return nil, err
+		}
+		bots = []*Bot{b}
+	}
+
+	resp := &controlpb.ReloadConfigResponse{}
+	for _, b := range bots {
+		if err := b.reloadConfig(); err != nil {
+			return resp, status.Errorf(codes.FailedPrecondition, "workspace %s: %v", b.profileName(), err)
+		}
+		resp.Workspaces = append(resp.Workspaces, b.profileName())
+	}
+	return resp, nil
+}
+
+func (s *controlService) StreamLogs(req *controlpb.StreamLogsRequest, stream controlpb.ControlService_StreamLogsServer) error {
+	lines, unsubscribe := s.logs.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case line := <-lines:
+			if !strings.Contains(line, req.Filter) {
+				continue
+			}
+			if err := stream.Send(&controlpb.LogEntry{Time: timestamppb.Now(), Line: line}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reloadConfig re-reads the workspace's prompt overrides, and the response
+// templates and notification rules in its CONFIG_FILE. Other settings need a
+// restart. Nothing changes unless everything is valid.
+func (b *Bot) reloadConfig() error {
+	fileConfig, err := loadFileConfig(b.config.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if _, err := notify.NewEngine(fileConfig.NotificationRules); err != nil {
+		return fmt.Errorf("invalid notification_rules: %w", err)
+	}
+	if _, err := prompts.Load(b.prompts.Dir()); err != nil {
+		return fmt.Errorf("invalid prompts: %w", err)
+	}
+
+	if err := b.prompts.Reload(); err != nil {
+		return fmt.Errorf("invalid prompts: %w", err)
+	}
+	b.notifications.Replace(fileConfig.NotificationRules)
+	b.templates.Replace(fileConfig.ResponseTemplates)
+	log.Printf("[%s] CONFIG: Reloaded prompts, %d response templates and %d notification rules for workspace %s", time.Now().Format("2006-01-02 15:04:05"), len(fileConfig.ResponseTemplates), len(fileConfig.NotificationRules), b.profileName())
+	return nil
+}
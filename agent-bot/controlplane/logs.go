@@ -0,0 +1,54 @@
+package controlplane
+
+import (
+	"strings"
+	"sync"
+)
+
+// logBuffer is how many lines a subscriber may fall behind before lines are
+// dropped for it
+const logBuffer = 256
+
+// Logs fans out log output to StreamLogs subscribers. It is an io.Writer to
+// combine with the standard logger's output.
+type Logs struct {
+	mu          sync.Mutex
+	subscribers map[chan string]struct{}
+}
+
+func NewLogs() *Logs {
+	return &Logs{subscribers: make(map[chan string]struct{})}
+}
+
+// Write sends each line of p to the subscribers. A subscriber that can't keep
+// up misses lines rather than block logging.
+func (l *Logs) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.subscribers) == 0 {
+		return len(p), nil
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		for subscriber := range l.subscribers {
+			select {
+			case subscriber <- line:
+			default:
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Subscribe returns a channel of log lines written from now on, and a
+// function to stop receiving them
+func (l *Logs) Subscribe() (<-chan string, func()) {
+	lines := make(chan string, logBuffer)
+	l.mu.Lock()
+	l.subscribers[lines] = struct{}{}
+	l.mu.Unlock()
+	return lines, func() {
+		l.mu.Lock()
+		delete(l.subscribers, lines)
+		l.mu.Unlock()
+	}
+}
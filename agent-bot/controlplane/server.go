@@ -0,0 +1,54 @@
+// Package controlplane serves the gRPC admin API defined in
+// controlpb/control.proto. Every call must carry the admin token as
+// "authorization: Bearer <token>" metadata. Server reflection is enabled so
+// tools like grpcurl work without the .proto file.
+package controlplane
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative controlpb/control.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"agent-bot/controlplane/controlpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// NewServer returns a gRPC server for service that rejects calls without token
+func NewServer(token string, service controlpb.ControlServiceServer) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorize(stream.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+	controlpb.RegisterControlServiceServer(server, service)
+	reflection.Register(server)
+	return server
+}
+
+// authorize checks the bearer token in the call's metadata
+func authorize(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		given := strings.TrimPrefix(value, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid admin token")
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"time"
+
+	"agent-bot/audit"
+	"agent-bot/dashboard"
+	"agent-bot/usage"
+)
+
+// Limits on what the dashboard lists
+const (
+	dashboardToolCalls   = 50
+	dashboardDecisions   = 50
+	dashboardTopChannels = 10
+)
+
+// channelToggles are the per-channel settings the dashboard can switch, by
+// the store bucket that holds the channels they are on for
+var channelToggles = map[string]string{
+	"digest":    digestBucket,
+	"welcome":   welcomeBucket,
+	"sentiment": sentimentBucket,
+}
+
+// dashboardState is served at /admin/dashboard/state for the admin web UI
+type dashboardState struct {
+	Workspace       string             `json:"workspace"`
+	Time            time.Time          `json:"time"`
+	Threads         []dashboardThread  `json:"threads"`
+	InFlightReplies []inFlightState    `json:"in_flight_replies"`
+	Spend           dashboardSpend     `json:"spend"`
+	ToolCalls       []audit.Entry      `json:"tool_calls"`
+	Decisions       []decisionRecord   `json:"decisions"`
+	Features        []FeatureState     `json:"features"`
+	Channels        []dashboardChannel `json:"channels"`
+}
+
+// dashboardThread is a thread the agent participates in
+type dashboardThread struct {
+	ThreadID   string    `json:"thread_id"`
+	JoinedAt   time.Time `json:"joined_at"`
+	LastPostAt time.Time `json:"last_post_at"`
+}
+
+// dashboardSpend is the month's LLM usage, in total and its biggest parts
+type dashboardSpend struct {
+	Month     string      `json:"month"`
+	Totals    usage.Row   `json:"totals"`
+	ByModel   []usage.Row `json:"by_model"`
+	ByChannel []usage.Row `json:"by_channel"`
+}
+
+// dashboardChannel is a channel the bot was added to, with its toggles
+type dashboardChannel struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	JoinedAt time.Time       `json:"joined_at"`
+	Today    usage.Daily     `json:"today"`
+	Settings map[string]bool `json:"settings"`
+}
+
+// handleDashboard serves the admin web UI at /admin/dashboard
+func (b *Bot) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAdmin(w, r) {
+		return
+	}
+	dashboard.Handler().ServeHTTP(w, r)
+}
+
+// handleDashboardState serves GET /admin/dashboard/state
+func (b *Bot) handleDashboardState(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAdmin(w, r) {
+		return
+	}
+
+	state := dashboardState{
+		Workspace:       b.profileName(),
+		Time:            time.Now().UTC(),
+		Threads:         []dashboardThread{},
+		InFlightReplies: []inFlightState{},
+		ToolCalls:       []audit.Entry{},
+		Decisions:       []decisionRecord{},
+		Features:        b.features.List(),
+		Channels:        b.dashboardChannels(),
+	}
+
+	if agent, ok := b.agent.(*BotAgent); ok {
+		for _, threadID := range agent.activeThreadIDs() {
+			thread := dashboardThread{ThreadID: threadID}
+			if saved, found := agent.threadState(threadID); found {
+				thread.JoinedAt = saved.JoinedAt
+				thread.LastPostAt = saved.LastPostAt
+			}
+			state.Threads = append(state.Threads, thread)
+		}
+		// Most recently active first
+		sort.SliceStable(state.Threads, func(i, j int) bool {
+			return state.Threads[i].LastPostAt.After(state.Threads[j].LastPostAt)
+		})
+
+		agent.inFlightMu.Lock()
+		for key, reply := range agent.inFlight {
+			state.InFlightReplies = append(state.InFlightReplies, inFlightState{Conversation: key, PostID: reply.postID})
+		}
+		agent.inFlightMu.Unlock()
+		state.Decisions = agent.decisions.recent(dashboardDecisions)
+	}
+
+	month := time.Now().UTC().Format("2006-01")
+	rows := b.usage.Month(month)
+	state.Spend = dashboardSpend{
+		Month:     month,
+		Totals:    usage.Totals(rows),
+		ByModel:   sumUsage(rows, func(row usage.Row) usage.Row { return usage.Row{Model: row.Model} }),
+		ByChannel: sumUsage(rows, func(row usage.Row) usage.Row { return usage.Row{ChannelID: row.ChannelID} }),
+	}
+	if len(state.Spend.ByChannel) > dashboardTopChannels {
+		state.Spend.ByChannel = state.Spend.ByChannel[:dashboardTopChannels]
+	}
+
+	if b.auditLog != nil {
+		entries, err := b.auditLog.Query(audit.Filter{Limit: dashboardToolCalls})
+		if err != nil {
+			log.Printf("[%s] AUDIT: Failed to read tool calls for the dashboard: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+		// Newest first
+		for i := len(entries) - 1; i >= 0; i-- {
+			state.ToolCalls = append(state.ToolCalls, entries[i])
+		}
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
+
+// sumUsage adds up rows by the fields group keeps, most expensive first
+func sumUsage(rows []usage.Row, group func(usage.Row) usage.Row) []usage.Row {
+	sums := make(map[usage.Row]*usage.Row)
+	for _, row := range rows {
+		key := group(row)
+		sum, ok := sums[key]
+		if !ok {
+			sum = &usage.Row{Month: row.Month, Model: key.Model, ChannelID: key.ChannelID}
+			sums[key] = sum
+		}
+		sum.Requests += row.Requests
+		sum.InputTokens += row.InputTokens
+		sum.OutputTokens += row.OutputTokens
+		sum.CostUSD += row.CostUSD
+	}
+
+	result := make([]usage.Row, 0, len(sums))
+	for _, sum := range sums {
+		result = append(result, *sum)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CostUSD != result[j].CostUSD {
+			return result[i].CostUSD > result[j].CostUSD
+		}
+		return result[i].InputTokens+result[i].OutputTokens > result[j].InputTokens+result[j].OutputTokens
+	})
+	return result
+}
+
+// dashboardChannels lists the channels the bot was added to
+func (b *Bot) dashboardChannels() []dashboardChannel {
+	channels := []dashboardChannel{}
+	for _, channelID := range b.store.Keys(channelsBucket) {
+		var entry joinedChannel
+		if found, err := b.store.Get(channelsBucket, channelID, &entry); err != nil || !found {
+			continue
+		}
+		channels = append(channels, dashboardChannel{
+			ID:       channelID,
+			Name:     entry.Name,
+			JoinedAt: entry.JoinedAt,
+			Today:    b.usage.Today(usage.ScopeChannel, channelID),
+			Settings: map[string]bool{
+				"digest":    b.digestEnabled(channelID),
+				"welcome":   b.welcomeEnabled(channelID),
+				"sentiment": b.sentimentEnabled(channelID),
+			},
+		})
+	}
+	return channels
+}
+
+// dashboardToggle is the body of the dashboard's toggle requests
+type dashboardToggle struct {
+	// Name is the feature, or the channel setting (digest, welcome, sentiment)
+	Name      string `json:"name"`
+	ChannelID string `json:"channel_id,omitempty"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// decodeToggle authorizes a toggle request and reads its body. Only JSON is
+// accepted, so a cross-site form can't reuse a browser's basic auth.
+func (b *Bot) decodeToggle(w http.ResponseWriter, r *http.Request) (dashboardToggle, bool) {
+	var toggle dashboardToggle
+	if !b.authorizeAdminMethod(w, r, http.MethodPost) {
+		return toggle, false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, apiError{Error: "body must be application/json"})
+		return toggle, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&toggle); err != nil || toggle.Name == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "name and enabled are required"})
+		return toggle, false
+	}
+	return toggle, true
+}
+
+// handleDashboardFeature serves POST /admin/dashboard/features
+func (b *Bot) handleDashboardFeature(w http.ResponseWriter, r *http.Request) {
+	toggle, ok := b.decodeToggle(w, r)
+	if !ok {
+		return
+	}
+	if err := b.features.Set(toggle.Name, toggle.Enabled); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	log.Printf("[%s] ADMIN: Feature %s turned %s from the dashboard", time.Now().Format("2006-01-02 15:04:05"), toggle.Name, onOff(toggle.Enabled))
+	writeJSON(w, http.StatusOK, b.features.List())
+}
+
+// handleDashboardChannel serves POST /admin/dashboard/channels
+func (b *Bot) handleDashboardChannel(w http.ResponseWriter, r *http.Request) {
+	toggle, ok := b.decodeToggle(w, r)
+	if !ok {
+		return
+	}
+	bucket, known := channelToggles[toggle.Name]
+	if !known || toggle.ChannelID == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "channel_id and a name of digest, welcome or sentiment are required"})
+		return
+	}
+
+	var err error
+	if toggle.Enabled {
+		err = b.store.Put(bucket, toggle.ChannelID, true)
+	} else {
+		err = b.store.Delete(bucket, toggle.ChannelID)
+		if toggle.Name == "sentiment" && b.sentiment != nil {
+			b.sentiment.Forget(toggle.ChannelID)
+		}
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+
+	log.Printf("[%s] ADMIN: %s turned %s for channel %s from the dashboard", time.Now().Format("2006-01-02 15:04:05"), toggle.Name, onOff(toggle.Enabled), toggle.ChannelID)
+	writeJSON(w, http.StatusOK, b.dashboardChannels())
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
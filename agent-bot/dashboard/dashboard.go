@@ -0,0 +1,22 @@
+// Package dashboard serves the admin web UI, a single page that polls the
+// admin API of the workspace it is served from.
+package dashboard
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed index.html
+var page []byte
+
+// Handler serves the dashboard page. Callers check authentication first.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Write(page)
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/metrics"
+	"agent-bot/reqid"
+	"agent-bot/types"
+)
+
+// debouncer holds messages the agent is about to answer for a short window,
+// so a user's quick follow-ups in the same thread get one reply instead of
+// one overlapping LLM call each
+type debouncer struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*debounceBatch
+}
+
+// debounceBatch is a user's messages waiting for the window to pass
+type debounceBatch struct {
+	ctx      context.Context
+	messages []types.PostedMessage
+	timer    *time.Timer
+}
+
+// newDebouncer returns nil when window is zero, which turns debouncing off
+func newDebouncer(window time.Duration) *debouncer {
+	if window <= 0 {
+		return nil
+	}
+	return &debouncer{window: window, pending: make(map[string]*debounceBatch)}
+}
+
+// debounceKey groups messages by sender and thread. Top-level messages are
+// grouped by channel, so separate DMs sent in a burst are answered together.
+func debounceKey(message types.PostedMessage) string {
+	if message.ThreadId != "" {
+		return message.UserId + "/" + message.ThreadId
+	}
+	return message.UserId + "/" + message.ChannelId
+}
+
+// start holds message and calls respond with the whole batch once window
+// passes without a follow-up
+func (d *debouncer) start(ctx context.Context, message types.PostedMessage, respond func(ctx context.Context, batch []types.PostedMessage)) {
+	key := debounceKey(message)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.extendLocked(ctx, key, message) {
+		return
+	}
+
+	batch := &debounceBatch{ctx: context.WithoutCancel(ctx), messages: []types.PostedMessage{message}}
+	batch.timer = time.AfterFunc(d.window, func() {
+		d.mu.Lock()
+		if d.pending[key] == batch {
+			delete(d.pending, key)
+		}
+		messages := batch.messages
+		d.mu.Unlock()
+
+		if len(messages) > 1 {
+			reqid.Logf(ctx, "DEBOUNCE: Answering %d messages from %s together", len(messages), message.UserId)
+			metrics.Add("debounced_messages_total", float64(len(messages)-1))
+		}
+		respond(batch.ctx, messages)
+	})
+	d.pending[key] = batch
+}
+
+// extend adds message to a batch waiting for the same sender and thread,
+// restarting its window, and reports whether there was one. Follow-ups
+// skip the reply decision because the batch already gets an answer.
+func (d *debouncer) extend(ctx context.Context, message types.PostedMessage) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.extendLocked(ctx, debounceKey(message), message)
+}
+
+func (d *debouncer) extendLocked(ctx context.Context, key string, message types.PostedMessage) bool {
+	batch, ok := d.pending[key]
+	if !ok || !batch.timer.Stop() {
+		// None waiting, or its window just passed and the reply is starting
+		return false
+	}
+	batch.ctx = context.WithoutCancel(ctx)
+	batch.messages = append(batch.messages, message)
+	batch.timer.Reset(d.window)
+	return true
+}
+
+// waiting is the number of batches waiting for their window to pass
+func (d *debouncer) waiting() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending)
+}
+
+// coalesce merges a batch into the message to answer: the latest one, with
+// every message's text and files, mentioned if any of them was
+func coalesce(batch []types.PostedMessage) types.PostedMessage {
+	merged := batch[len(batch)-1]
+	if len(batch) == 1 {
+		return merged
+	}
+
+	texts := make([]string, 0, len(batch))
+	var fileIDs []string
+	for _, message := range batch {
+		texts = append(texts, message.Message)
+		fileIDs = append(fileIDs, message.FileIds...)
+		merged.Mentioned = merged.Mentioned || message.Mentioned
+	}
+	merged.Message = strings.Join(texts, "\n")
+	merged.FileIds = fileIDs
+	return merged
+}
+
+type coalescedPostsKey struct{}
+
+// withCoalescedPosts marks the posts merged into the message being answered,
+// so thread context doesn't repeat them
+func withCoalescedPosts(ctx context.Context, batch []types.PostedMessage) context.Context {
+	ids := make(map[string]bool, len(batch))
+	for _, message := range batch {
+		ids[message.PostId] = true
+	}
+	return context.WithValue(ctx, coalescedPostsKey{}, ids)
+}
+
+// withoutCoalescedPosts drops posts marked by withCoalescedPosts from history
+func withoutCoalescedPosts(ctx context.Context, history []*types.Message) []*types.Message {
+	ids, _ := ctx.Value(coalescedPostsKey{}).(map[string]bool)
+	if len(ids) == 0 {
+		return history
+	}
+	kept := history[:0:0]
+	for _, post := range history {
+		if !ids[post.ID] {
+			kept = append(kept, post)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	_ "net/http/pprof" // registers /debug/pprof/ on the default mux, see guardPprof
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// debugState is served at /debug/state to diagnose goroutine and memory
+// leaks, e.g. replies that never finish streaming
+type debugState struct {
+	Time           time.Time        `json:"time"`
+	Goroutines     int              `json:"goroutines"`
+	HeapAllocBytes uint64           `json:"heap_alloc_bytes"`
+	HeapObjects    uint64           `json:"heap_objects"`
+	SysBytes       uint64           `json:"sys_bytes"`
+	NumGC          uint32           `json:"num_gc"`
+	Workspaces     []workspaceState `json:"workspaces"`
+	// GoroutinesByCreator counts goroutines by the function that started them
+	GoroutinesByCreator map[string]int `json:"goroutines_by_creator"`
+}
+
+// workspaceState is what one workspace is holding on to
+type workspaceState struct {
+	Name             string          `json:"name"`
+	ActiveThreads    []string        `json:"active_threads"`
+	InFlightReplies  []inFlightState `json:"in_flight_replies"`
+	StreamingPosts   []string        `json:"streaming_posts"`
+	DebouncedBatches int             `json:"debounced_batches"`
+	PendingUpdates   int             `json:"pending_updates"`
+	// Queue is set on distributed gateways and workers
+	Queue *queueState `json:"queue,omitempty"`
+}
+
+// inFlightState is a reply still being generated
+type inFlightState struct {
+	Conversation string `json:"conversation"`
+	PostID       string `json:"post_id"`
+}
+
+// queueState is the backlog of the distributed event queue
+type queueState struct {
+	Waiting         int64   `json:"waiting"`
+	Depth           []int64 `json:"depth,omitempty"`
+	OwnedPartitions []int   `json:"owned_partitions,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// guardPprof puts the net/http/pprof handlers, which register themselves
+// on the default mux, behind the admin token of admin
+func guardPprof(admin *Bot, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/pprof") && !admin.authorizeAdmin(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleDebugState serves GET /debug/state, authenticated with the
+// ADMIN_API_TOKEN of b
+func (b *Bot) handleDebugState(workspaces func(ctx context.Context) []workspaceState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !b.authorizeAdmin(w, r) {
+			return
+		}
+
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		writeJSON(w, http.StatusOK, debugState{
+			Time:                time.Now().UTC(),
+			Goroutines:          runtime.NumGoroutine(),
+			HeapAllocBytes:      mem.HeapAlloc,
+			HeapObjects:         mem.HeapObjects,
+			SysBytes:            mem.Sys,
+			NumGC:               mem.NumGC,
+			Workspaces:          workspaces(r.Context()),
+			GoroutinesByCreator: goroutinesByCreator(),
+		})
+	}
+}
+
+// runtimeState collects what the workspace's agent, chat adapter and
+// event queue are holding
+func (b *Bot) runtimeState(ctx context.Context) workspaceState {
+	state := workspaceState{
+		Name:            b.profileName(),
+		ActiveThreads:   []string{},
+		InFlightReplies: []inFlightState{},
+		StreamingPosts:  []string{},
+	}
+
+	if agent, ok := b.agent.(*BotAgent); ok {
+		state.ActiveThreads = append(state.ActiveThreads, agent.activeThreadIDs()...)
+		sort.Strings(state.ActiveThreads)
+
+		agent.inFlightMu.Lock()
+		for key, reply := range agent.inFlight {
+			state.InFlightReplies = append(state.InFlightReplies, inFlightState{Conversation: key, PostID: reply.postID})
+		}
+		agent.inFlightMu.Unlock()
+		sort.Slice(state.InFlightReplies, func(i, j int) bool {
+			return state.InFlightReplies[i].Conversation < state.InFlightReplies[j].Conversation
+		})
+
+		agent.streamsMu.Lock()
+		for messageID := range agent.streams {
+			state.StreamingPosts = append(state.StreamingPosts, messageID)
+		}
+		agent.streamsMu.Unlock()
+		sort.Strings(state.StreamingPosts)
+
+		if agent.debounce != nil {
+			state.DebouncedBatches = agent.debounce.waiting()
+		}
+	}
+
+	if b.chat != nil {
+		state.PendingUpdates = b.chat.updates.depth()
+	}
+
+	if b.queue != nil {
+		queue := &queueState{}
+		ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+		defer cancel()
+		if depth, err := b.queue.Depth(ctx); err != nil {
+			queue.Error = err.Error()
+		} else {
+			queue.Depth = depth
+			for _, n := range depth {
+				queue.Waiting += n
+			}
+		}
+		if b.worker != nil {
+			queue.OwnedPartitions = b.worker.Owned()
+			sort.Ints(queue.OwnedPartitions)
+		}
+		state.Queue = queue
+	}
+
+	return state
+}
+
+// goroutinesByCreator groups the stacks of all goroutines by the function
+// that started them; a count that keeps growing points at the leak
+func goroutinesByCreator() map[string]int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	counts := make(map[string]int)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		creator := "main"
+		for _, line := range strings.Split(string(stack), "\n") {
+			if name, ok := strings.CutPrefix(line, "created by "); ok {
+				creator, _, _ = strings.Cut(name, " in goroutine")
+				break
+			}
+		}
+		counts[creator]++
+	}
+	return counts
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"agent-bot/metrics"
+)
+
+const (
+	// decisionLatencyWindow is how many recent decision calls the median is computed over
+	decisionLatencyWindow = 20
+	// decisionProbeInterval is how often a single probe call is let through while
+	// degraded for latency, so recovery can be detected
+	decisionProbeInterval = 5 * time.Minute
+)
+
+// decisionGuard tracks decision LLM latency and token spend and decides when
+// thread-participation decisions should fall back to the heuristic engine
+type decisionGuard struct {
+	mu sync.Mutex
+
+	maxMedianLatency time.Duration // 0 disables the latency check
+	hourlyBudget     int           // approximate tokens per hour, 0 disables the budget
+
+	latencies   []time.Duration
+	windowStart time.Time
+	tokensUsed  int
+
+	degradedReason string
+	lastProbe      time.Time
+}
+
+func newDecisionGuard(maxMedianLatency time.Duration, hourlyBudget int) *decisionGuard {
+	return &decisionGuard{
+		maxMedianLatency: maxMedianLatency,
+		hourlyBudget:     hourlyBudget,
+		windowStart:      time.Now(),
+	}
+}
+
+// allow reports whether the decision LLM should be called, or the reason it should be skipped
+func (g *decisionGuard) allow() (bool, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if time.Since(g.windowStart) >= time.Hour {
+		g.windowStart = time.Now()
+		g.tokensUsed = 0
+	}
+
+	if g.hourlyBudget > 0 && g.tokensUsed >= g.hourlyBudget {
+		g.setDegraded("budget")
+		return false, "budget"
+	}
+
+	if g.maxMedianLatency > 0 && g.medianLocked() > g.maxMedianLatency {
+		// Let one call through now and then to find out whether the provider recovered
+		if time.Since(g.lastProbe) >= decisionProbeInterval {
+			g.lastProbe = time.Now()
+			return true, ""
+		}
+		g.setDegraded("latency")
+		return false, "latency"
+	}
+
+	g.setDegraded("")
+	return true, ""
+}
+
+// record adds the latency and approximate token cost of a decision call
+func (g *decisionGuard) record(latency time.Duration, tokens int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// A fast probe while degraded means the provider recovered; forget the slow history
+	if g.degradedReason == "latency" && latency <= g.maxMedianLatency {
+		g.latencies = g.latencies[:0]
+	}
+
+	g.latencies = append(g.latencies, latency)
+	if len(g.latencies) > decisionLatencyWindow {
+		g.latencies = g.latencies[len(g.latencies)-decisionLatencyWindow:]
+	}
+	g.tokensUsed += tokens
+
+	metrics.Set("decision_llm_median_latency_seconds", g.medianLocked().Seconds())
+	metrics.Set("decision_llm_tokens_used_this_hour", float64(g.tokensUsed))
+}
+
+// setDegraded records transitions between the LLM and heuristic engines; callers must hold the lock
+func (g *decisionGuard) setDegraded(reason string) {
+	if reason == g.degradedReason {
+		return
+	}
+
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	if reason == "" {
+		log.Printf("[%s] DECISION: Decision LLM healthy again, leaving heuristic mode", timestamp)
+		metrics.Set("decision_llm_degraded", 0)
+	} else {
+		log.Printf("[%s] DECISION: Downgrading thread decisions to heuristics (reason: %s)", timestamp, reason)
+		metrics.Inc("decision_downgrades_total", "reason", reason)
+		metrics.Set("decision_llm_degraded", 1)
+	}
+	g.degradedReason = reason
+}
+
+func (g *decisionGuard) medianLocked() time.Duration {
+	if len(g.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), g.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// decisionLogSize is how many recent reply decisions are kept
+const decisionLogSize = 200
+
+// decisionRecord is whether the agent replied to a message, and why
+type decisionRecord struct {
+	Time      time.Time `json:"time"`
+	PostID    string    `json:"post_id"`
+	ChannelID string    `json:"channel_id"`
+	ThreadID  string    `json:"thread_id,omitempty"`
+	UserID    string    `json:"user_id"`
+	Respond   bool      `json:"respond"`
+	// Reason is mention, dm, keyword, thread_llm, thread_heuristic,
+	// thread_participation_off, policy_always, policy_never,
+	// policy_mention_only, policy_rate_limited, thread_cooldown, thread_left
+	// or not_addressed
+	Reason string `json:"reason"`
+}
+
+// decisionLog is a ring of the latest reply decisions, kept in memory only
+type decisionLog struct {
+	mu      sync.Mutex
+	records []decisionRecord
+	next    int
+}
+
+func newDecisionLog(size int) *decisionLog {
+	return &decisionLog{records: make([]decisionRecord, 0, size)}
+}
+
+func (l *decisionLog) add(record decisionRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) < cap(l.records) {
+		l.records = append(l.records, record)
+		return
+	}
+	l.records[l.next] = record
+	l.next = (l.next + 1) % len(l.records)
+}
+
+// recent returns up to limit decisions, newest first
+func (l *decisionLog) recent(limit int) []decisionRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]decisionRecord, 0, min(limit, len(l.records)))
+	for i := 0; i < len(l.records) && len(result) < limit; i++ {
+		// next is the oldest record once the ring is full, so next-1 is the newest
+		index := (l.next - 1 - i + 2*len(l.records)) % len(l.records)
+		result = append(result, l.records[index])
+	}
+	return result
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/llms"
+	"agent-bot/tools"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// digestBucket stores the channels that opted in to the daily digest
+const digestBucket = "digest_channels"
+
+// maxDigestTranscriptChars bounds how much of a busy day is sent to the LLM
+const maxDigestTranscriptChars = 60000
+
+func (b *Bot) digestEnabled(channelID string) bool {
+	var enabled bool
+	found, err := b.store.Get(digestBucket, channelID, &enabled)
+	return err == nil && found && enabled
+}
+
+// runDailyDigests posts a digest to every opted-in channel
+func (b *Bot) runDailyDigests() {
+	for _, channelID := range b.store.Keys(digestBucket) {
+		if !b.digestEnabled(channelID) {
+			continue
+		}
+		if err := b.postDigest(channelID, 24*time.Hour); err != nil {
+			log.Printf("[%s] DIGEST: Failed to post digest for channel %s: %v", time.Now().Format("2006-01-02 15:04:05"), channelID, err)
+		}
+	}
+}
+
+// postDigest summarizes the channel's posts from the last period and posts the result
+func (b *Bot) postDigest(channelID string, period time.Duration) error {
+	since := time.Now().Add(-period)
+	transcript, count, err := b.channelTranscript(channelID, since)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		log.Printf("[%s] DIGEST: No posts in channel %s since %s, skipping", time.Now().Format("2006-01-02 15:04:05"), channelID, since.Format("2006-01-02 15:04:05"))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	ctx = llms.WithoutTools(ctx)
+	ctx = tools.WithRequest(ctx, tools.Request{UserID: "digest", ChannelID: channelID})
+
+	summary, err := b.llmBackend.Prompt(ctx, digestPrompt(transcript))
+	if err != nil {
+		return fmt.Errorf("failed to generate digest: %v", err)
+	}
+
+	message := fmt.Sprintf("#### Daily digest (%d messages since %s)\n\n%s", count, since.Format("Jan 2 15:04 MST"), strings.TrimSpace(summary))
+	if _, _, err := b.client.CreatePost(&model.Post{ChannelId: channelID, Message: message}); err != nil {
+		return fmt.Errorf("failed to post digest: %v", err)
+	}
+
+	log.Printf("[%s] DIGEST: Posted digest of %d messages to channel %s", time.Now().Format("2006-01-02 15:04:05"), count, channelID)
+	return nil
+}
+
+// channelTranscript returns the channel's user posts since a time, oldest first
+func (b *Bot) channelTranscript(channelID string, since time.Time) (string, int, error) {
+	postList, _, err := b.client.GetPostsSince(channelID, since.UnixMilli(), false)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch posts: %v", err)
+	}
+	postList.SortByCreateAt()
+
+	// SortByCreateAt orders newest first
+	posts := postList.ToSlice()
+	var lines []string
+	for i := len(posts) - 1; i >= 0; i-- {
+		post := posts[i]
+		// GetPostsSince also returns older posts that were edited or deleted recently
+		if post.CreateAt < since.UnixMilli() || post.DeleteAt != 0 || post.Type != "" || post.UserId == b.config.BotUserID {
+			continue
+		}
+
+		speaker := "User"
+		if user, err := b.chat.GetUser(post.UserId); err == nil {
+			speaker = user.Username
+		}
+		prefix := ""
+		if post.RootId != "" {
+			prefix = "  ↳ "
+		}
+		lines = append(lines, fmt.Sprintf("%s%s: %s", prefix, speaker, post.Message))
+	}
+
+	transcript := strings.Join(lines, "\n")
+	if len(transcript) > maxDigestTranscriptChars {
+		transcript = "[earlier messages omitted]\n" + transcript[len(transcript)-maxDigestTranscriptChars:]
+	}
+	return transcript, len(lines), nil
+}
+
+func digestPrompt(transcript string) string {
+	return fmt.Sprintf(`Here are the last 24 hours of messages from a team chat channel. Replies in threads are indented.
+
+%s
+
+Write a concise digest for teammates who were away. Use these Markdown sections, omitting any that would be empty:
+**Decisions** - what was agreed
+**Action items** - who committed to what, with owners when known
+**Open questions** - unresolved questions or blockers
+**Other highlights** - anything else worth knowing
+
+Keep it short and factual; do not invent details.`, transcript)
+}
+
+// handleDigestCommand implements "!digest on|off|now <channel_id>" and "!digest list"
+func (b *Bot) handleDigestCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!digest on <channel_id>`, `!digest off <channel_id>`, `!digest now <channel_id>`, `!digest list`"
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		var channels []string
+		for _, channelID := range b.store.Keys(digestBucket) {
+			if b.digestEnabled(channelID) {
+				channels = append(channels, "`"+channelID+"`")
+			}
+		}
+		if len(channels) == 0 {
+			return "The daily digest is not enabled in any channel."
+		}
+		return fmt.Sprintf("Daily digest at %s (%s) is enabled in: %s", b.config.DigestTime, b.config.DigestTimezone, strings.Join(channels, ", "))
+
+	case "on":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := b.store.Put(digestBucket, args[1], true); err != nil {
+			return fmt.Sprintf("Failed to enable the digest: %v", err)
+		}
+		return fmt.Sprintf("Daily digest enabled for `%s`; it will be posted at %s (%s).", args[1], b.config.DigestTime, b.config.DigestTimezone)
+
+	case "off":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := b.store.Delete(digestBucket, args[1]); err != nil {
+			return fmt.Sprintf("Failed to disable the digest: %v", err)
+		}
+		return fmt.Sprintf("Daily digest disabled for `%s`.", args[1])
+
+	case "now":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := b.postDigest(args[1], 24*time.Hour); err != nil {
+			return fmt.Sprintf("Failed to post the digest: %v", err)
+		}
+		return fmt.Sprintf("Posted the digest for `%s` (nothing is posted if the channel was quiet).", args[1])
+	}
+
+	return usage
+}
@@ -0,0 +1,554 @@
+package discord
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"agent-bot/types"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxMessageLength is Discord's limit on message content; longer replies are
+// split across several messages
+const maxMessageLength = 2000
+
+// threadArchiveMinutes is how long an idle thread the bot started stays open
+const threadArchiveMinutes = 1440
+
+// Image limits match the Mattermost adapter
+const (
+	maxImageAttachments = 5
+	maxImageBytes       = 5 * 1024 * 1024
+)
+
+var supportedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Audio limits match the Mattermost adapter
+const (
+	maxAudioAttachments = 3
+	maxAudioBytes       = 25 * 1024 * 1024
+)
+
+// isAudio reports whether an attachment is a voice message or audio file
+func isAudio(contentType string) bool {
+	return strings.HasPrefix(contentType, "audio/")
+}
+
+// PostMessage posts to a channel, or into a thread when ThreadId is set. A
+// thread is started from the root message the first time the bot replies.
+func (c *Client) PostMessage(message types.ChatMessage) (string, error) {
+	target := message.ChannelId
+	var reference *discordgo.MessageReference
+	if message.ThreadId != "" {
+		parentID, rootID, err := SplitMessageID(message.ThreadId)
+		if err != nil {
+			return "", err
+		}
+		target, reference, err = c.threadTarget(parentID, rootID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	chunks := splitContent(message.Message)
+	send := &discordgo.MessageSend{Content: chunks[0], Reference: reference}
+	if len(message.Attachments) > 0 {
+		// Cards go with the first message; they need no placeholder text
+		if strings.TrimSpace(message.Message) == "" {
+			send.Content = ""
+		}
+		send.Embeds, send.Components = embeds(message.Attachments)
+	}
+	first, err := c.session.ChannelMessageSendComplex(target, send)
+	if err != nil {
+		return "", fmt.Errorf("failed to post message: %w", err)
+	}
+	id := MessageID(target, first.ID)
+
+	var extra []string
+	for _, chunk := range chunks[1:] {
+		sent, err := c.session.ChannelMessageSend(target, chunk)
+		if err != nil {
+			return id, fmt.Errorf("failed to post message continuation: %w", err)
+		}
+		extra = append(extra, sent.ID)
+	}
+	if len(extra) > 0 {
+		c.mu.Lock()
+		c.overflow[id] = extra
+		c.mu.Unlock()
+	}
+	return id, nil
+}
+
+// PostEphemeral posts the message normally: Discord only has ephemeral
+// messages in replies to slash commands and other interactions
+func (c *Client) PostEphemeral(userID string, message types.ChatMessage) error {
+	_, err := c.PostMessage(message)
+	return err
+}
+
+// Discord's limits on embeds and link buttons in one message
+const (
+	maxEmbeds        = 10
+	maxEmbedFields   = 25
+	maxButtonRows    = 5
+	maxButtonsPerRow = 5
+)
+
+// embeds converts cards to embeds. Buttons belong to the message rather than
+// an embed, so every card's link buttons are collected below them.
+func embeds(cards []types.Attachment) ([]*discordgo.MessageEmbed, []discordgo.MessageComponent) {
+	var converted []*discordgo.MessageEmbed
+	var buttons []discordgo.MessageComponent
+	for _, card := range cards[:min(len(cards), maxEmbeds)] {
+		embed := &discordgo.MessageEmbed{
+			Title:       clip(card.Title, 256),
+			URL:         card.TitleLink,
+			Description: clip(strings.TrimSpace(card.Pretext+"\n\n"+card.Text), 4096),
+			Color:       embedColor(card.Color),
+		}
+		if card.AuthorName != "" {
+			embed.Author = &discordgo.MessageEmbedAuthor{Name: clip(card.AuthorName, 256), URL: card.AuthorLink, IconURL: card.AuthorIcon}
+		}
+		if card.Footer != "" {
+			embed.Footer = &discordgo.MessageEmbedFooter{Text: clip(card.Footer, 2048)}
+		}
+		for _, field := range card.Fields[:min(len(card.Fields), maxEmbedFields)] {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: clip(field.Title, 256), Value: clip(field.Value, 1024), Inline: field.Short})
+		}
+		converted = append(converted, embed)
+
+		for _, button := range card.Buttons {
+			buttons = append(buttons, discordgo.Button{Label: clip(button.Label, 80), Style: discordgo.LinkButton, URL: button.URL})
+		}
+	}
+
+	var rows []discordgo.MessageComponent
+	for len(buttons) > 0 && len(rows) < maxButtonRows {
+		n := min(len(buttons), maxButtonsPerRow)
+		rows = append(rows, discordgo.ActionsRow{Components: buttons[:n]})
+		buttons = buttons[n:]
+	}
+	return converted, rows
+}
+
+// embedColor parses a "#rrggbb" color; anything else leaves the default
+func embedColor(color string) int {
+	value, err := strconv.ParseInt(strings.TrimPrefix(color, "#"), 16, 32)
+	if err != nil {
+		return 0
+	}
+	return int(value)
+}
+
+// clip cuts s to at most n runes, Discord's limit for the field
+func clip(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// UploadFile posts the message with the file attached, starting the thread
+// like PostMessage when needed
+func (c *Client) UploadFile(upload types.FileUpload) error {
+	target := upload.ChannelId
+	var reference *discordgo.MessageReference
+	if upload.ThreadId != "" {
+		parentID, rootID, err := SplitMessageID(upload.ThreadId)
+		if err != nil {
+			return err
+		}
+		target, reference, err = c.threadTarget(parentID, rootID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only the first chunk of a long message fits in the same message
+	var content string
+	if upload.Message != "" {
+		content = splitContent(upload.Message)[0]
+	}
+	_, err := c.session.ChannelMessageSendComplex(target, &discordgo.MessageSend{
+		Content:   content,
+		Reference: reference,
+		Files:     []*discordgo.File{{Name: upload.Filename, Reader: bytes.NewReader(upload.Data)}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", upload.Filename, err)
+	}
+	return nil
+}
+
+// emoji maps the reaction names the agent uses to Discord's unicode emoji;
+// other names are passed through, so custom emoji work as name:id
+var emoji = map[string]string{
+	"eyes":                 "\U0001F440",
+	"white_check_mark":     "\u2705",
+	"x":                    "\u274C",
+	"thread":               "\U0001F9F5",
+	"globe_with_meridians": "\U0001F310",
+	"repeat":               "\U0001F501",
+}
+
+// emojiName is the reverse of emoji, for reactions users add
+func emojiName(unicode string) string {
+	for name, u := range emoji {
+		if u == unicode {
+			return name
+		}
+	}
+	return unicode
+}
+
+func emojiID(name string) string {
+	if unicode, ok := emoji[name]; ok {
+		return unicode
+	}
+	return name
+}
+
+// AddReaction reacts to a message
+func (c *Client) AddReaction(messageID, name string) error {
+	channelID, id, err := SplitMessageID(messageID)
+	if err != nil {
+		return err
+	}
+	if err := c.session.MessageReactionAdd(channelID, id, emojiID(name)); err != nil {
+		return fmt.Errorf("failed to add reaction: %w", err)
+	}
+	return nil
+}
+
+// RemoveReaction removes the bot's reaction from a message
+func (c *Client) RemoveReaction(messageID, name string) error {
+	channelID, id, err := SplitMessageID(messageID)
+	if err != nil {
+		return err
+	}
+	if err := c.session.MessageReactionRemove(channelID, id, emojiID(name), "@me"); err != nil {
+		return fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	return nil
+}
+
+// threadTarget returns the channel to post a reply to rootID in. DMs have no
+// threads, so replies there reference the root message instead.
+func (c *Client) threadTarget(parentID, rootID string) (string, *discordgo.MessageReference, error) {
+	if channel, err := c.channel(rootID); err == nil && channel.IsThread() {
+		return rootID, nil, nil
+	}
+
+	parent, err := c.channel(parentID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get channel %s: %w", parentID, err)
+	}
+	if parent.Type == discordgo.ChannelTypeDM || parent.Type == discordgo.ChannelTypeGroupDM {
+		return parentID, &discordgo.MessageReference{ChannelID: parentID, MessageID: rootID}, nil
+	}
+
+	name := "Conversation"
+	if root, err := c.session.ChannelMessage(parentID, rootID); err == nil {
+		name = threadName(c.fromDiscord(root.Content))
+	}
+	thread, err := c.session.MessageThreadStart(parentID, rootID, name, threadArchiveMinutes)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start thread: %w", err)
+	}
+	c.session.State.ChannelAdd(thread)
+	return thread.ID, nil, nil
+}
+
+// UpdateMessage replaces the content of one of the bot's messages, adding or
+// removing continuation messages as the content grows or shrinks
+func (c *Client) UpdateMessage(messageID string, newContent string) error {
+	channelID, id, err := SplitMessageID(messageID)
+	if err != nil {
+		return err
+	}
+
+	chunks := splitContent(newContent)
+	if _, err := c.session.ChannelMessageEdit(channelID, id, chunks[0]); err != nil {
+		if isCode(err, discordgo.ErrCodeUnknownMessage) {
+			return types.ErrMessageDeleted
+		}
+		return fmt.Errorf("failed to update message: %w", err)
+	}
+
+	c.mu.Lock()
+	extra := c.overflow[messageID]
+	c.mu.Unlock()
+
+	var kept []string
+	for i, chunk := range chunks[1:] {
+		if i < len(extra) {
+			if _, err := c.session.ChannelMessageEdit(channelID, extra[i], chunk); err != nil {
+				return fmt.Errorf("failed to update message continuation: %w", err)
+			}
+			kept = append(kept, extra[i])
+			continue
+		}
+		sent, err := c.session.ChannelMessageSend(channelID, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to post message continuation: %w", err)
+		}
+		kept = append(kept, sent.ID)
+	}
+	for _, stale := range extra[len(kept):] {
+		if err := c.session.ChannelMessageDelete(channelID, stale); err != nil && !isCode(err, discordgo.ErrCodeUnknownMessage) {
+			log.Printf("[%s] DISCORD: Failed to delete continuation %s: %v", time.Now().Format("2006-01-02 15:04:05"), stale, err)
+		}
+	}
+
+	c.mu.Lock()
+	if len(kept) > 0 {
+		c.overflow[messageID] = kept
+	} else {
+		delete(c.overflow, messageID)
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// SendTypingIndicator shows the bot typing in the thread, or in the channel
+// when the thread hasn't been started yet
+func (c *Client) SendTypingIndicator(channelID, threadID string) error {
+	if threadID != "" {
+		if _, rootID, err := SplitMessageID(threadID); err == nil {
+			if err := c.session.ChannelTyping(rootID); err == nil {
+				return nil
+			}
+		}
+	}
+	return c.session.ChannelTyping(channelID)
+}
+
+// GetMessage fetches a single message
+func (c *Client) GetMessage(messageID string) (*types.Message, error) {
+	channelID, id, err := SplitMessageID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := c.session.ChannelMessage(channelID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	return c.toMessage(channelID, "", msg), nil
+}
+
+// GetThreadMessages returns the root message and, once a thread has been
+// started from it, every message in the thread, oldest first
+func (c *Client) GetThreadMessages(threadID string) ([]*types.Message, error) {
+	parentID, rootID, err := SplitMessageID(threadID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*types.Message
+	root, err := c.session.ChannelMessage(parentID, rootID)
+	if err != nil && !isCode(err, discordgo.ErrCodeUnknownMessage, discordgo.ErrCodeUnknownChannel) {
+		return nil, fmt.Errorf("failed to get thread root: %w", err)
+	}
+	if root != nil {
+		result = append(result, c.toMessage(parentID, "", root))
+	}
+
+	if channel, err := c.channel(rootID); err != nil || !channel.IsThread() {
+		return result, nil
+	}
+
+	var replies []*discordgo.Message
+	before := ""
+	for {
+		page, err := c.session.ChannelMessages(rootID, 100, before, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get thread: %w", err)
+		}
+		replies = append(replies, page...)
+		if len(page) < 100 {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+
+	// Pages come newest first
+	slices.Reverse(replies)
+	for _, msg := range replies {
+		if msg.Type != discordgo.MessageTypeDefault && msg.Type != discordgo.MessageTypeReply {
+			continue
+		}
+		result = append(result, c.toMessage(rootID, threadID, msg))
+	}
+	return result, nil
+}
+
+// GetUser looks up a user by ID
+func (c *Client) GetUser(userID string) (*types.User, error) {
+	user, err := c.session.User(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &types.User{ID: user.ID, Username: user.Username, IsBot: user.Bot}, nil
+}
+
+// GetChannel looks up a channel's name and topic, which serves as its purpose
+func (c *Client) GetChannel(channelID string) (*types.Channel, error) {
+	channel, err := c.session.Channel(channelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel: %w", err)
+	}
+	return &types.Channel{
+		ID:          channel.ID,
+		Name:        channel.Name,
+		DisplayName: channel.Name,
+		Purpose:     channel.Topic,
+		Private:     channel.Type == discordgo.ChannelTypeDM || channel.Type == discordgo.ChannelTypeGroupDM,
+	}, nil
+}
+
+// GetImages downloads image attachments. Attachments are only reachable
+// through their CDN URL, so the file IDs are URLs of images already checked
+// against the type and size limits when the message arrived.
+func (c *Client) GetImages(fileIDs []string) ([]types.Image, error) {
+	var images []types.Image
+	for _, url := range fileIDs {
+		if len(images) >= maxImageAttachments {
+			log.Printf("[%s] IMAGES: Skipping attachments beyond the first %d images", time.Now().Format("2006-01-02 15:04:05"), maxImageAttachments)
+			break
+		}
+
+		resp, err := c.session.Client.Get(url)
+		if err != nil {
+			return images, fmt.Errorf("failed to download %s: %w", url, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+		resp.Body.Close()
+		if err != nil {
+			return images, fmt.Errorf("failed to download %s: %w", url, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return images, fmt.Errorf("failed to download %s: status %d", url, resp.StatusCode)
+		}
+
+		mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+		if !supportedImageTypes[mediaType] || len(data) > maxImageBytes {
+			continue
+		}
+		name := path.Base(strings.Split(url, "?")[0])
+		images = append(images, types.Image{Name: name, MediaType: mediaType, Data: data})
+	}
+	return images, nil
+}
+
+// GetAudio downloads voice messages and audio attachments; like GetImages,
+// the file IDs are CDN URLs
+func (c *Client) GetAudio(fileIDs []string) ([]types.Audio, error) {
+	var audio []types.Audio
+	for _, url := range fileIDs {
+		if len(audio) >= maxAudioAttachments {
+			log.Printf("[%s] TRANSCRIBE: Skipping attachments beyond the first %d audio files", time.Now().Format("2006-01-02 15:04:05"), maxAudioAttachments)
+			break
+		}
+
+		resp, err := c.session.Client.Get(url)
+		if err != nil {
+			return audio, fmt.Errorf("failed to download %s: %w", url, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return audio, fmt.Errorf("failed to download %s: status %d", url, resp.StatusCode)
+		}
+		// Images share the file IDs; skip them before reading the body
+		mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+		if !isAudio(mediaType) {
+			resp.Body.Close()
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxAudioBytes+1))
+		resp.Body.Close()
+		if err != nil {
+			return audio, fmt.Errorf("failed to download %s: %w", url, err)
+		}
+		if len(data) > maxAudioBytes {
+			continue
+		}
+		name := path.Base(strings.Split(url, "?")[0])
+		audio = append(audio, types.Audio{Name: name, MediaType: mediaType, Data: data})
+	}
+	return audio, nil
+}
+
+func (c *Client) toMessage(channelID, threadID string, msg *discordgo.Message) *types.Message {
+	message := &types.Message{
+		ID:        MessageID(channelID, msg.ID),
+		ChannelID: channelID,
+		ThreadID:  threadID,
+		Content:   c.fromDiscord(msg.Content),
+		Timestamp: msg.Timestamp.UnixMilli(),
+	}
+	if msg.Author != nil {
+		message.UserID = msg.Author.ID
+	}
+	return message
+}
+
+// splitContent splits content into chunks Discord accepts, preferring to
+// break at newlines. It always returns at least one non-empty chunk.
+func splitContent(content string) []string {
+	if strings.TrimSpace(content) == "" {
+		return []string{"\u200b"}
+	}
+
+	var chunks []string
+	for len(content) > 0 {
+		if utf8.RuneCountInString(content) <= maxMessageLength {
+			chunks = append(chunks, content)
+			break
+		}
+
+		// Byte offset of the first rune past the limit
+		cut, runes := len(content), 0
+		for i := range content {
+			if runes == maxMessageLength {
+				cut = i
+				break
+			}
+			runes++
+		}
+		if newline := strings.LastIndex(content[:cut], "\n"); newline > cut/2 {
+			cut = newline + 1
+		}
+		chunks = append(chunks, content[:cut])
+		content = content[cut:]
+	}
+	return chunks
+}
+
+// threadName is a thread title taken from the first line of its root message
+func threadName(content string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if name == "" {
+		return "Conversation"
+	}
+	if utf8.RuneCountInString(name) > 90 {
+		name = string([]rune(name)[:90]) + "…"
+	}
+	return name
+}
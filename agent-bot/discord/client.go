@@ -0,0 +1,122 @@
+// Package discord connects the agent core to Discord servers over the
+// gateway. Discord threads are channels whose ID is the ID of the message
+// they were started from, which maps directly onto the agent's threads.
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Client is a Discord bot connection. It implements types.Chat. Messages are
+// fetched by channel, so message IDs have the form "<channel>:<message>"; a
+// thread's ID is the ID of its starter message in the parent channel.
+type Client struct {
+	session *discordgo.Session
+
+	// Identity of the bot, filled in by Identify
+	BotUserID   string
+	BotUsername string
+
+	connected atomic.Bool
+
+	// overflow holds the extra messages a long reply was split into, keyed by
+	// the ID of its first message
+	mu       sync.Mutex
+	overflow map[string][]string
+}
+
+// NewClient creates a client for a bot token. The bot needs the Message
+// Content privileged intent to read what users write.
+func NewClient(token string, httpClient *http.Client) (*Client, error) {
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discord session: %w", err)
+	}
+	session.Client = httpClient
+	session.Identify.Intents = discordgo.IntentsGuilds |
+		discordgo.IntentsGuildMessages |
+		discordgo.IntentsDirectMessages |
+		discordgo.IntentsGuildMessageReactions |
+		discordgo.IntentsDirectMessageReactions |
+		discordgo.IntentsMessageContent
+	return &Client{session: session, overflow: make(map[string][]string)}, nil
+}
+
+// Identify verifies the token and records who the bot is
+func (c *Client) Identify(ctx context.Context) error {
+	user, err := c.session.User("@me", discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("discord token check failed: %w", err)
+	}
+	c.BotUserID = user.ID
+	c.BotUsername = user.Username
+	return nil
+}
+
+// Identity returns the bot's user ID and username
+func (c *Client) Identity() (userID, username string) {
+	return c.BotUserID, c.BotUsername
+}
+
+// Connected reports whether the gateway connection is up
+func (c *Client) Connected() bool {
+	return c.connected.Load()
+}
+
+// TeamOf returns the server (guild) a channel belongs to; empty for DMs
+func (c *Client) TeamOf(channelID string) string {
+	channel, err := c.channel(channelID)
+	if err != nil {
+		return ""
+	}
+	return channel.GuildID
+}
+
+// MessageID joins a channel and message ID
+func MessageID(channelID, messageID string) string {
+	return channelID + ":" + messageID
+}
+
+// SplitMessageID splits a message ID into its channel and message
+func SplitMessageID(id string) (channelID, messageID string, err error) {
+	channelID, messageID, ok := strings.Cut(id, ":")
+	if !ok || channelID == "" || messageID == "" {
+		return "", "", fmt.Errorf("invalid discord message id %q", id)
+	}
+	return channelID, messageID, nil
+}
+
+// channel looks a channel up in the gateway state, falling back to the API
+func (c *Client) channel(channelID string) (*discordgo.Channel, error) {
+	if channel, err := c.session.State.Channel(channelID); err == nil {
+		return channel, nil
+	}
+	channel, err := c.session.Channel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	c.session.State.ChannelAdd(channel)
+	return channel, nil
+}
+
+// isCode reports whether err is a Discord API error with one of codes
+func isCode(err error, codes ...int) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Message == nil {
+		return false
+	}
+	for _, code := range codes {
+		if restErr.Message.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"agent-bot/types"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// userMention matches <@id> and the legacy nickname form <@!id>
+var userMention = regexp.MustCompile(`<@!?(\d+)>`)
+
+// Listen opens the gateway and delivers message events to agent until ctx is
+// cancelled. discordgo reconnects on its own after a dropped connection.
+func (c *Client) Listen(ctx context.Context, agent types.Agent) error {
+	c.session.AddHandler(func(s *discordgo.Session, event *discordgo.Connect) {
+		c.connected.Store(true)
+		log.Printf("[%s] DISCORD: Connected as @%s (%s)", time.Now().Format("2006-01-02 15:04:05"), c.BotUsername, c.BotUserID)
+	})
+	c.session.AddHandler(func(s *discordgo.Session, event *discordgo.Disconnect) {
+		c.connected.Store(false)
+		log.Printf("[%s] DISCORD: Connection lost, reconnecting...", time.Now().Format("2006-01-02 15:04:05"))
+	})
+	c.session.AddHandler(func(s *discordgo.Session, event *discordgo.MessageCreate) {
+		c.handleMessage(event.Message, agent)
+	})
+	c.session.AddHandler(func(s *discordgo.Session, event *discordgo.MessageUpdate) {
+		if event.Author != nil && event.Author.ID == c.BotUserID {
+			agent.MessageEdited(MessageID(event.ChannelID, event.ID), c.fromDiscord(event.Content))
+		}
+	})
+	c.session.AddHandler(func(s *discordgo.Session, event *discordgo.MessageReactionAdd) {
+		if event.UserID == c.BotUserID {
+			return
+		}
+		agent.ReactionAdded(types.Reaction{
+			PostId:    MessageID(event.ChannelID, event.MessageID),
+			UserId:    event.UserID,
+			ChannelId: event.ChannelID,
+			Emoji:     emojiName(event.Emoji.Name),
+		})
+	})
+	c.session.AddHandler(func(s *discordgo.Session, event *discordgo.MessageDelete) {
+		// Deletes carry no author; the agent ignores IDs it didn't post
+		agent.MessageDeleted(MessageID(event.ChannelID, event.ID))
+	})
+
+	if err := c.session.Open(); err != nil {
+		return fmt.Errorf("failed to open discord gateway: %w", err)
+	}
+	<-ctx.Done()
+	return c.session.Close()
+}
+
+func (c *Client) handleMessage(msg *discordgo.Message, agent types.Agent) {
+	if msg.Type != discordgo.MessageTypeDefault && msg.Type != discordgo.MessageTypeReply {
+		// Joins, pins, thread creation notices and other system messages
+		return
+	}
+
+	// Don't respond to our own messages
+	if msg.Author == nil || msg.Author.ID == c.BotUserID {
+		return
+	}
+
+	message := types.PostedMessage{
+		PostId:    MessageID(msg.ChannelID, msg.ID),
+		UserId:    msg.Author.ID,
+		ChannelId: msg.ChannelID,
+		Message:   c.fromDiscord(msg.Content),
+		IsDM:      msg.GuildID == "",
+	}
+	for _, user := range msg.Mentions {
+		if user.ID == c.BotUserID {
+			message.Mentioned = true
+		}
+	}
+
+	// Messages inside a thread belong to the parent channel; the thread is
+	// identified by its starter message there
+	if channel, err := c.channel(msg.ChannelID); err == nil && channel.IsThread() {
+		message.ChannelId = channel.ParentID
+		message.ThreadId = MessageID(channel.ParentID, channel.ID)
+	}
+
+	for _, attachment := range msg.Attachments {
+		if supportedImageTypes[attachment.ContentType] && attachment.Size <= maxImageBytes ||
+			isAudio(attachment.ContentType) && attachment.Size <= maxAudioBytes {
+			message.FileIds = append(message.FileIds, attachment.URL)
+		}
+	}
+
+	agent.MessagePosted(message)
+}
+
+// fromDiscord rewrites the bot's mention to @username, the form the agent expects
+func (c *Client) fromDiscord(text string) string {
+	return userMention.ReplaceAllStringFunc(text, func(mention string) string {
+		if userMention.FindStringSubmatch(mention)[1] == c.BotUserID {
+			return "@" + c.BotUsername
+		}
+		return mention
+	})
+}
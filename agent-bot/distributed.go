@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-bot/distributed"
+	"agent-bot/metrics"
+	"agent-bot/redis"
+)
+
+// Distributed modes (DISTRIBUTED_MODE). A gateway holds the websocket and
+// queues events in Redis; workers answer them. Both keep their state there.
+const (
+	distributedOff     = "off"
+	distributedGateway = "gateway"
+	distributedWorker  = "worker"
+)
+
+// validateDistributed checks the distributed mode and leader election
+// settings before anything connects
+func validateDistributed(config Config) error {
+	switch config.DistributedMode {
+	case distributedOff:
+	case distributedGateway, distributedWorker:
+		if config.LeaderElection {
+			return errors.New("LEADER_ELECTION cannot be combined with DISTRIBUTED_MODE")
+		}
+		if config.DistributedPartitions < 1 {
+			return errors.New("DISTRIBUTED_PARTITIONS must be at least 1")
+		}
+	default:
+		return fmt.Errorf("DISTRIBUTED_MODE must be off, gateway or worker, not %q", config.DistributedMode)
+	}
+	if !sharesState(config) {
+		return nil
+	}
+	if config.ChatPlatform != "mattermost" {
+		return errors.New("DISTRIBUTED_MODE and LEADER_ELECTION are only supported with CHAT_PLATFORM=mattermost")
+	}
+	if config.RedisURL == "" {
+		return errors.New("DISTRIBUTED_MODE and LEADER_ELECTION require REDIS_URL")
+	}
+	if config.CanaryMode || config.CanaryURL != "" {
+		return errors.New("DISTRIBUTED_MODE and LEADER_ELECTION cannot be combined with canary shadowing")
+	}
+	if strings.HasPrefix(config.StateDatabaseURL, "sqlite:") {
+		return errors.New("replicas can't share a SQLite STATE_DATABASE_URL; use Postgres or leave it empty to share state in Redis")
+	}
+	return nil
+}
+
+// sharesState reports whether replicas share their state in Redis
+func sharesState(config Config) bool {
+	return config.DistributedMode != distributedOff || config.LeaderElection
+}
+
+// redisPrefix keeps workspaces sharing a Redis server apart
+func redisPrefix(config Config) string {
+	return config.RedisPrefix + workspaceName(config) + ":"
+}
+
+// startDistributed sets up the event queue of a gateway or worker
+func (b *Bot) startDistributed(client *redis.Client) {
+	b.redis = client
+	if b.config.DistributedMode == distributedOff {
+		// Leader election only shares the state
+		return
+	}
+	b.queue = distributed.NewQueue(client, redisPrefix(b.config), b.config.DistributedPartitions)
+	if b.config.DistributedMode == distributedWorker {
+		b.worker = distributed.NewWorker(b.queue, replicaID(), b.agent)
+	}
+}
+
+// replicaID names this replica among the workers or election candidates
+func replicaID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "replica"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid())
+}
+
+// publish queues an event for the workers on the partition of affinity,
+// normally the thread, so one worker handles a thread's events in order.
+// Events whose seenKey was queued before, e.g. replayed after a reconnect,
+// are dropped.
+func (b *Bot) publish(affinity, seenKey string, event distributed.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if seenKey != "" {
+		first, err := b.queue.FirstSeen(ctx, seenKey)
+		if err != nil {
+			// Better to risk a duplicate than to drop the event
+			log.Printf("[%s] DISTRIBUTED: Failed to check whether %s was queued before: %v", time.Now().Format("2006-01-02 15:04:05"), seenKey, err)
+		} else if !first {
+			log.Printf("[%s] DISTRIBUTED: Skipping %s, already queued", time.Now().Format("2006-01-02 15:04:05"), seenKey)
+			return
+		}
+	}
+	if err := b.queue.Publish(ctx, affinity, event); err != nil {
+		log.Printf("[%s] DISTRIBUTED: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		metrics.Inc("distributed_publish_errors_total")
+		return
+	}
+	metrics.Inc("distributed_events_total", "kind", string(event.Kind))
+}
+
+// reactionThread finds the thread of a reacted-to post, which reaction
+// events don't carry, falling back to the post itself
+func (b *Bot) reactionThread(postID string) string {
+	message, err := b.chat.GetMessage(postID)
+	if err != nil || message.ThreadID == "" {
+		return postID
+	}
+	return message.ThreadID
+}
+
+// eventsConnected reports whether events reach the bot: over the websocket,
+// from Redis on a worker, or from webhooks, which need no connection
+func (b *Bot) eventsConnected() bool {
+	if b.worker != nil {
+		return b.worker.Healthy()
+	}
+	if b.config.EventSource == eventSourceWebhook {
+		return true
+	}
+	return b.isWebSocketConnected()
+}
+
+// redisDependency checks the Redis server shared by the replicas
+func (b *Bot) redisDependency() dependency {
+	name := "redis"
+	if b.config.Name != "" {
+		name += "/" + b.config.Name
+	}
+	return dependency{name: name, required: true, check: func(ctx context.Context) (string, error) {
+		if err := b.redis.Ping(ctx); err != nil {
+			return "", err
+		}
+		return "ping " + b.redis.Addr(), nil
+	}}
+}
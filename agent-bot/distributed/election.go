@@ -0,0 +1,96 @@
+package distributed
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"agent-bot/redis"
+)
+
+// Election picks one leader among replicas with a lease key in Redis. The
+// leader renews the lease; when it stops, another replica takes over once the
+// lease expires.
+type Election struct {
+	client *redis.Client
+	key    string
+	id     string
+}
+
+// NewElection campaigns for key as id, which must be unique among the replicas
+func NewElection(client *redis.Client, key, id string) *Election {
+	return &Election{client: client, key: key, id: id}
+}
+
+// Leader returns the ID of the current leader, or "" when there is none
+func (e *Election) Leader(ctx context.Context) (string, error) {
+	leader, err := e.client.String(ctx, "GET", e.key)
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return leader, err
+}
+
+// Elect blocks until this replica holds the lease or ctx is done, then keeps
+// renewing it in the background. The returned channel is closed when the
+// lease is lost: another replica took it, or Redis couldn't be reached for
+// longer than the lease lasts.
+func (e *Election) Elect(ctx context.Context) (<-chan struct{}, error) {
+	ticker := time.NewTicker(rebalanceEvery)
+	defer ticker.Stop()
+	for {
+		won, err := e.client.SetNX(ctx, e.key, e.id, leaseTTL)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[%s] LEADER: Failed to campaign: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+		if won {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	lost := make(chan struct{})
+	go e.hold(ctx, lost)
+	return lost, nil
+}
+
+// hold renews the lease until it is lost or ctx is done
+func (e *Election) hold(ctx context.Context, lost chan struct{}) {
+	defer close(lost)
+	ticker := time.NewTicker(rebalanceEvery)
+	defer ticker.Stop()
+	renewedAt := time.Now()
+	ttl := strconv.FormatInt(leaseTTL.Milliseconds(), 10)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		renewed, err := e.client.Int(ctx, "EVAL", renewScript, "1", e.key, e.id, ttl)
+		switch {
+		case err != nil && time.Since(renewedAt) < leaseTTL:
+			log.Printf("[%s] LEADER: Failed to renew the lease, retrying: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		case err != nil:
+			log.Printf("[%s] LEADER: Lease expired while Redis was unreachable: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+			return
+		case renewed == 0:
+			log.Printf("[%s] LEADER: Another replica took the lease", time.Now().Format("2006-01-02 15:04:05"))
+			return
+		default:
+			renewedAt = time.Now()
+		}
+	}
+}
+
+// Resign hands the lease back, so a follower takes over without waiting for
+// it to expire
+func (e *Election) Resign(ctx context.Context) error {
+	_, err := e.client.Do(ctx, "EVAL", releaseScript, "1", e.key, e.id)
+	return err
+}
@@ -0,0 +1,126 @@
+// Package distributed spreads chat events over worker replicas through
+// Redis. A gateway holds the chat connection and pushes each event onto one
+// of a fixed number of partition lists, picked by hashing the event's thread,
+// so everything in a thread lands on the same list. Workers share out the
+// partitions with leases and handle each partition's events in order.
+package distributed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"agent-bot/redis"
+	"agent-bot/types"
+)
+
+// Kind is what happened in the chat
+type Kind string
+
+const (
+	KindPosted   Kind = "posted"
+	KindEdited   Kind = "edited"
+	KindDeleted  Kind = "deleted"
+	KindReaction Kind = "reaction"
+	KindJoined   Kind = "joined"
+)
+
+// Event is a chat event on its way from the gateway to a worker
+type Event struct {
+	Kind     Kind                 `json:"kind"`
+	Message  *types.PostedMessage `json:"message,omitempty"`
+	Reaction *types.Reaction      `json:"reaction,omitempty"`
+	// PostID and Content describe edited and deleted posts
+	PostID  string `json:"post_id,omitempty"`
+	Content string `json:"content,omitempty"`
+	// ChannelID is the channel the bot joined
+	ChannelID string `json:"channel_id,omitempty"`
+}
+
+// seenTTL is how long published posts are remembered, so redelivered
+// events aren't queued twice
+const seenTTL = time.Hour
+
+// Queue is the set of partition lists shared by a gateway and its workers
+type Queue struct {
+	client     *redis.Client
+	prefix     string
+	partitions int
+}
+
+// NewQueue returns the queue under prefix, e.g. "agent-bot:default:"
+func NewQueue(client *redis.Client, prefix string, partitions int) *Queue {
+	return &Queue{client: client, prefix: prefix, partitions: partitions}
+}
+
+// Partitions is the number of partition lists
+func (q *Queue) Partitions() int {
+	return q.partitions
+}
+
+// Partition picks the list for an affinity key, normally a thread ID
+func (q *Queue) Partition(affinity string) int {
+	h := fnv.New32a()
+	h.Write([]byte(affinity))
+	return int(h.Sum32() % uint32(q.partitions))
+}
+
+// Publish queues an event on the partition of its affinity key
+func (q *Queue) Publish(ctx context.Context, affinity string, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event.Kind, err)
+	}
+	if _, err := q.client.Do(ctx, "LPUSH", q.list(q.Partition(affinity)), string(data)); err != nil {
+		return fmt.Errorf("failed to queue %s event: %w", event.Kind, err)
+	}
+	return nil
+}
+
+// FirstSeen records key and reports whether it wasn't recorded already. The
+// gateway uses it to drop events it has queued before, e.g. when a
+// reconnecting websocket replays them.
+func (q *Queue) FirstSeen(ctx context.Context, key string) (bool, error) {
+	return q.client.SetNX(ctx, q.prefix+"seen:"+key, "1", seenTTL)
+}
+
+// next waits up to timeout for the oldest event of a partition
+func (q *Queue) next(ctx context.Context, partition int, timeout time.Duration) (*Event, error) {
+	_, data, err := q.client.BRPop(ctx, timeout, q.list(partition))
+	if err != nil {
+		return nil, err
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+	return &event, nil
+}
+
+// Depth returns the number of events waiting on each partition
+func (q *Queue) Depth(ctx context.Context) ([]int64, error) {
+	depths := make([]int64, q.partitions)
+	for partition := range depths {
+		n, err := q.client.Int(ctx, "LLEN", q.list(partition))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the depth of partition %d: %w", partition, err)
+		}
+		depths[partition] = n
+	}
+	return depths, nil
+}
+
+func (q *Queue) list(partition int) string {
+	return q.prefix + "events:" + strconv.Itoa(partition)
+}
+
+func (q *Queue) lease(partition int) string {
+	return q.prefix + "lease:" + strconv.Itoa(partition)
+}
+
+func (q *Queue) workers() string {
+	return q.prefix + "workers"
+}
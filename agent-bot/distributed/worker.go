@@ -0,0 +1,230 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"agent-bot/redis"
+	"agent-bot/types"
+)
+
+const (
+	// leaseTTL is how long a partition or the leadership stays with a
+	// replica that stopped renewing it, and how long a silent worker counts
+	// as live
+	leaseTTL = 15 * time.Second
+	// rebalanceEvery is how often workers heartbeat, renew their leases and
+	// even out the partitions, and how often leases are campaigned for
+	rebalanceEvery = 5 * time.Second
+	// popTimeout bounds each wait for an event, so released partitions stop
+	// promptly
+	popTimeout = time.Second
+)
+
+// renewScript extends a lease only if the replica still holds it
+const renewScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+
+// releaseScript drops a lease only if the replica still holds it
+const releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+// Worker consumes the events of the partitions it holds leases on and hands
+// them to its agent. Each partition is consumed by one goroutine, so the
+// events of a thread are handled one at a time and in order.
+type Worker struct {
+	queue *Queue
+	id    string
+	agent types.Agent
+
+	mu    sync.Mutex
+	owned map[int]context.CancelFunc
+	// healthy is whether the last rebalance reached Redis
+	healthy atomic.Bool
+}
+
+// NewWorker returns a worker named id, which must be unique among the replicas
+func NewWorker(queue *Queue, id string, agent types.Agent) *Worker {
+	return &Worker{queue: queue, id: id, agent: agent, owned: make(map[int]context.CancelFunc)}
+}
+
+// Owned lists the partitions the worker currently consumes
+func (w *Worker) Owned() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	partitions := make([]int, 0, len(w.owned))
+	for p := range w.owned {
+		partitions = append(partitions, p)
+	}
+	return partitions
+}
+
+// Healthy reports whether the worker could reach Redis when it last rebalanced
+func (w *Worker) Healthy() bool {
+	return w.healthy.Load()
+}
+
+// Run takes part in sharing out the partitions until ctx is done, then hands
+// its partitions back
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(rebalanceEvery)
+	defer ticker.Stop()
+	for {
+		err := w.rebalance(ctx)
+		w.healthy.Store(err == nil)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[%s] DISTRIBUTED: Failed to rebalance partitions: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+		select {
+		case <-ctx.Done():
+			w.releaseAll()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rebalance heartbeats, renews the worker's leases and claims or gives up
+// partitions until it holds its share of them
+func (w *Worker) rebalance(ctx context.Context) error {
+	client := w.queue.client
+	now := time.Now()
+	if _, err := client.Do(ctx, "ZADD", w.queue.workers(), strconv.FormatInt(now.UnixMilli(), 10), w.id); err != nil {
+		return err
+	}
+	if _, err := client.Do(ctx, "ZREMRANGEBYSCORE", w.queue.workers(), "-inf", strconv.FormatInt(now.Add(-leaseTTL).UnixMilli(), 10)); err != nil {
+		return err
+	}
+	live, err := client.Int(ctx, "ZCARD", w.queue.workers())
+	if err != nil {
+		return err
+	}
+	partitions := w.queue.partitions
+	share := (partitions + int(live) - 1) / max(int(live), 1)
+
+	ttl := strconv.FormatInt(leaseTTL.Milliseconds(), 10)
+	for _, p := range w.Owned() {
+		renewed, err := client.Int(ctx, "EVAL", renewScript, "1", w.queue.lease(p), w.id, ttl)
+		if err != nil {
+			return err
+		}
+		if renewed == 0 {
+			log.Printf("[%s] DISTRIBUTED: Lost the lease on partition %d", time.Now().Format("2006-01-02 15:04:05"), p)
+			w.stop(p)
+		}
+	}
+
+	owned := w.Owned()
+	for i := share; i < len(owned); i++ {
+		w.release(ctx, owned[i])
+	}
+
+	// Start looking at a different partition on each worker, so they don't
+	// all race for the same ones
+	start := w.queue.Partition(w.id)
+	for i := 0; i < partitions && len(w.Owned()) < share; i++ {
+		p := (start + i) % partitions
+		if w.owns(p) {
+			continue
+		}
+		claimed, err := client.SetNX(ctx, w.queue.lease(p), w.id, leaseTTL)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			w.consume(ctx, p)
+		}
+	}
+	return nil
+}
+
+func (w *Worker) owns(partition int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.owned[partition]
+	return ok
+}
+
+// consume starts handling a partition's events
+func (w *Worker) consume(ctx context.Context, partition int) {
+	ctx, cancel := context.WithCancel(ctx)
+	w.mu.Lock()
+	w.owned[partition] = cancel
+	w.mu.Unlock()
+	log.Printf("[%s] DISTRIBUTED: Consuming partition %d", time.Now().Format("2006-01-02 15:04:05"), partition)
+
+	go func() {
+		for ctx.Err() == nil {
+			event, err := w.queue.next(ctx, partition, popTimeout)
+			if errors.Is(err, redis.ErrNil) {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("[%s] DISTRIBUTED: Failed to read partition %d: %v", time.Now().Format("2006-01-02 15:04:05"), partition, err)
+					time.Sleep(popTimeout)
+				}
+				continue
+			}
+			w.deliver(*event)
+		}
+	}()
+}
+
+// deliver hands an event to the agent
+func (w *Worker) deliver(event Event) {
+	switch event.Kind {
+	case KindPosted:
+		if event.Message != nil {
+			w.agent.MessagePosted(*event.Message)
+		}
+	case KindEdited:
+		w.agent.MessageEdited(event.PostID, event.Content)
+	case KindDeleted:
+		w.agent.MessageDeleted(event.PostID)
+	case KindReaction:
+		if event.Reaction != nil {
+			w.agent.ReactionAdded(*event.Reaction)
+		}
+	case KindJoined:
+		w.agent.ChannelJoined(event.ChannelID)
+	default:
+		log.Printf("[%s] DISTRIBUTED: Skipping event of unknown kind %q", time.Now().Format("2006-01-02 15:04:05"), event.Kind)
+	}
+}
+
+// stop stops consuming a partition without touching its lease
+func (w *Worker) stop(partition int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cancel, ok := w.owned[partition]; ok {
+		cancel()
+		delete(w.owned, partition)
+	}
+}
+
+// release stops consuming a partition and hands its lease back
+func (w *Worker) release(ctx context.Context, partition int) {
+	w.stop(partition)
+	if _, err := w.queue.client.Do(ctx, "EVAL", releaseScript, "1", w.queue.lease(partition), w.id); err != nil {
+		log.Printf("[%s] DISTRIBUTED: Failed to release partition %d: %v", time.Now().Format("2006-01-02 15:04:05"), partition, err)
+		return
+	}
+	log.Printf("[%s] DISTRIBUTED: Released partition %d", time.Now().Format("2006-01-02 15:04:05"), partition)
+}
+
+// releaseAll hands every lease back and leaves the worker set, so the other
+// workers take over without waiting for the leases to expire
+func (w *Worker) releaseAll() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, p := range w.Owned() {
+		w.release(ctx, p)
+	}
+	if _, err := w.queue.client.Do(ctx, "ZREM", w.queue.workers(), w.id); err != nil {
+		log.Printf("[%s] DISTRIBUTED: Failed to leave the worker set: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+}
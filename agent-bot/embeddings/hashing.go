@@ -0,0 +1,68 @@
+// Package embeddings turns text into vectors for tool preselection: Hashing
+// runs locally with no model, Voyage calls the Voyage AI embeddings API.
+package embeddings
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// hashingDimensions is the vector size of the hashing embedder
+const hashingDimensions = 1024
+
+// Hashing is a local embedder using the hashing trick over word unigrams and
+// character trigrams. It needs no network access and works well enough to
+// route between tools with distinct vocabularies.
+type Hashing struct{}
+
+func NewHashing() *Hashing {
+	return &Hashing{}
+}
+
+func (h *Hashing) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		vectors[i] = hashText(text)
+	}
+	return vectors, nil
+}
+
+func hashText(text string) []float64 {
+	vector := make([]float64, hashingDimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		add(vector, "w:"+word, 1)
+		padded := "^" + word + "$"
+		for i := 0; i+3 <= len(padded); i++ {
+			add(vector, "t:"+padded[i:i+3], 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vector {
+			vector[i] /= norm
+		}
+	}
+	return vector
+}
+
+func add(vector []float64, feature string, weight float64) {
+	hash := fnv.New32a()
+	hash.Write([]byte(feature))
+	sum := hash.Sum32()
+	// The top bit picks the sign so collisions tend to cancel out
+	if sum&0x80000000 != 0 {
+		weight = -weight
+	}
+	vector[sum%hashingDimensions] += weight
+}
@@ -0,0 +1,89 @@
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const voyageURL = "https://api.voyageai.com/v1/embeddings"
+
+// Voyage embeds texts with the Voyage AI embeddings API
+type Voyage struct {
+	APIKey     string
+	Model      string
+	HTTPClient *http.Client
+}
+
+func NewVoyage(apiKey, model string, httpClient *http.Client) *Voyage {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Voyage{
+		APIKey:     apiKey,
+		Model:      model,
+		HTTPClient: httpClient,
+	}
+}
+
+type voyageRequest struct {
+	Input []string `json:"input"`
+	Model string   `json:"model"`
+}
+
+type voyageResponse struct {
+	Data []struct {
+		Embedding []float64 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+}
+
+func (v *Voyage) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	body, err := json.Marshal(voyageRequest{Input: texts, Model: v.Model})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, voyageURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+v.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("voyage API error %d: %s", resp.StatusCode, string(raw))
+	}
+
+	var parsed voyageResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	vectors := make([][]float64, len(texts))
+	for _, item := range parsed.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return nil, fmt.Errorf("voyage returned out of range index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("voyage returned no embedding for input %d", i)
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,254 @@
+// Package errorsink reports errors that would otherwise only reach the
+// container logs, such as panics, failed tool calls and an LLM that keeps
+// failing, to Sentry and/or a generic webhook. Reports are sent in the
+// background and never block the caller; without a sink they are dropped.
+package errorsink
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"agent-bot/metrics"
+	"agent-bot/reqid"
+	"agent-bot/tools"
+)
+
+// Kinds of reports
+const (
+	KindPanic = "panic"
+	KindTool  = "tool"
+	KindLLM   = "llm"
+)
+
+// queueSize bounds the reports waiting to be sent; more are dropped
+const queueSize = 100
+
+// repeatInterval is how often the same error is reported at most, so a
+// failing dependency doesn't flood the sink
+const repeatInterval = time.Minute
+
+// sendTimeout bounds each delivery to a sink
+const sendTimeout = 10 * time.Second
+
+// Config selects where reports go. With neither SentryDSN nor WebhookURL
+// set, reporting is off.
+type Config struct {
+	SentryDSN   string
+	WebhookURL  string
+	Environment string
+}
+
+// Event is a report, as posted to the webhook
+type Event struct {
+	ID          string            `json:"id"`
+	Time        time.Time         `json:"time"`
+	Kind        string            `json:"kind"`
+	Level       string            `json:"level"`
+	Message     string            `json:"message"`
+	Environment string            `json:"environment,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Stack       []Frame           `json:"stack,omitempty"`
+	// Fingerprint groups reports of the same problem: the kind and the
+	// values of the tags passed to Report
+	Fingerprint []string `json:"fingerprint"`
+}
+
+// Frame is a stack frame, innermost last
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+type sink interface {
+	name() string
+	send(ctx context.Context, event Event) error
+}
+
+type reporter struct {
+	sinks       []sink
+	events      chan Event
+	environment string
+	serverName  string
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+}
+
+var current atomic.Pointer[reporter]
+
+// Setup starts reporting to the sinks in config
+func Setup(config Config) error {
+	client := &http.Client{Timeout: sendTimeout}
+	var sinks []sink
+	if config.SentryDSN != "" {
+		sentry, err := newSentrySink(config.SentryDSN, client)
+		if err != nil {
+			return fmt.Errorf("invalid SENTRY_DSN: %w", err)
+		}
+		sinks = append(sinks, sentry)
+	}
+	if config.WebhookURL != "" {
+		sinks = append(sinks, &webhookSink{url: config.WebhookURL, client: client})
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	r := &reporter{
+		sinks:       sinks,
+		events:      make(chan Event, queueSize),
+		environment: config.Environment,
+		serverName:  host,
+		lastSent:    make(map[string]time.Time),
+	}
+	go r.run()
+	current.Store(r)
+	return nil
+}
+
+// Enabled reports whether a sink is configured
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Report sends err as a report of kind. tags are key/value pairs, like
+// metric labels, that identify what failed (e.g. "tool", "jira_search") and
+// group the reports; the request ID, user, channel and thread in ctx are
+// added as tags too.
+func Report(ctx context.Context, kind string, err error, tags ...string) {
+	r := current.Load()
+	if r == nil || err == nil {
+		return
+	}
+	r.report(ctx, kind, "error", err.Error(), nil, tags)
+}
+
+// ReportPanic sends a recovered panic with the stack it unwound. Call it
+// from the deferred function that recovered.
+func ReportPanic(ctx context.Context, recovered any, tags ...string) {
+	r := current.Load()
+	if r == nil {
+		return
+	}
+	r.report(ctx, KindPanic, "fatal", fmt.Sprint(recovered), panicStack(), tags)
+}
+
+func (r *reporter) report(ctx context.Context, kind, level, message string, stack []Frame, tags []string) {
+	event := Event{
+		ID:          newEventID(),
+		Time:        time.Now().UTC(),
+		Kind:        kind,
+		Level:       level,
+		Message:     message,
+		Environment: r.environment,
+		ServerName:  r.serverName,
+		Tags:        map[string]string{"kind": kind},
+		Stack:       stack,
+		Fingerprint: []string{kind},
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		event.Tags[tags[i]] = tags[i+1]
+		event.Fingerprint = append(event.Fingerprint, tags[i+1])
+	}
+	if id := reqid.From(ctx); id != "" {
+		event.Tags["request_id"] = id
+	}
+	if req, ok := tools.RequestFrom(ctx); ok {
+		for key, value := range map[string]string{"user_id": req.UserID, "channel_id": req.ChannelID, "thread_id": req.ThreadID} {
+			if value != "" {
+				event.Tags[key] = value
+			}
+		}
+	}
+
+	if !r.due(strings.Join(event.Fingerprint, "|")+"|"+message, event.Time) {
+		metrics.Inc("error_reports_suppressed_total", "kind", kind)
+		return
+	}
+	select {
+	case r.events <- event:
+		metrics.Inc("error_reports_total", "kind", kind)
+	default:
+		metrics.Inc("error_reports_dropped_total", "kind", kind)
+	}
+}
+
+// due reports whether the error with key wasn't reported within the last
+// repeatInterval, and records it as reported now
+func (r *reporter) due(key string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.lastSent[key]; ok && now.Sub(last) < repeatInterval {
+		return false
+	}
+	if len(r.lastSent) > 1000 {
+		for k, last := range r.lastSent {
+			if now.Sub(last) >= repeatInterval {
+				delete(r.lastSent, k)
+			}
+		}
+	}
+	r.lastSent[key] = now
+	return true
+}
+
+func (r *reporter) run() {
+	for event := range r.events {
+		for _, s := range r.sinks {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			if err := s.send(ctx, event); err != nil {
+				log.Printf("[%s] ERRORS: Failed to report %s error to %s: %v", time.Now().Format("2006-01-02 15:04:05"), event.Kind, s.name(), err)
+				metrics.Inc("error_report_failures_total", "sink", s.name())
+			}
+			cancel()
+		}
+	}
+}
+
+// panicStack returns the stack of the panicking goroutine, innermost last.
+// The deferred function recovering sits above runtime.gopanic and is left
+// out, as are the runtime's own frames.
+func panicStack() []Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []Frame
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" {
+			stack = nil
+		} else if !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, Frame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return stack
+}
+
+// newEventID returns a random UUID in the 32 hex digit form Sentry expects
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return hex.EncodeToString(b)
+}
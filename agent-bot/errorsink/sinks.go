@@ -0,0 +1,162 @@
+package errorsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webhookSink posts each Event as JSON
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) name() string { return "webhook" }
+
+func (s *webhookSink) send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(s.client, req)
+}
+
+// sentrySink sends events to Sentry's envelope endpoint, which is all a
+// report needs from the Sentry SDK
+type sentrySink struct {
+	dsn      string
+	endpoint string
+	auth     string
+	client   *http.Client
+}
+
+// newSentrySink parses a DSN like https://<key>@o123.ingest.sentry.io/<project>
+func newSentrySink(dsn string, client *http.Client) (*sentrySink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	key := u.User.Username()
+	path, project := "", strings.TrimPrefix(u.Path, "/")
+	if i := strings.LastIndex(u.Path, "/"); i >= 0 {
+		path, project = u.Path[:i], u.Path[i+1:]
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || key == "" || project == "" {
+		return nil, errors.New("expected https://<key>@<host>/<project>")
+	}
+	return &sentrySink{
+		dsn:      dsn,
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, path, project),
+		auth:     "Sentry sentry_version=7, sentry_client=agent-bot/1.0, sentry_key=" + key,
+		client:   client,
+	}, nil
+}
+
+func (s *sentrySink) name() string { return "sentry" }
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Fingerprint []string          `json:"fingerprint,omitempty"`
+	Exception   sentryExceptions  `json:"exception"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+func (s *sentrySink) send(ctx context.Context, event Event) error {
+	exception := sentryException{Type: event.Kind, Value: event.Message}
+	if len(event.Stack) > 0 {
+		exception.Stacktrace = &sentryStacktrace{}
+		for _, frame := range event.Stack {
+			exception.Stacktrace.Frames = append(exception.Stacktrace.Frames, sentryFrame{
+				Function: frame.Function,
+				AbsPath:  frame.File,
+				Lineno:   frame.Line,
+				InApp:    strings.HasPrefix(frame.Function, "main.") || strings.HasPrefix(frame.Function, "agent-bot/"),
+			})
+		}
+	}
+	payload := sentryEvent{
+		EventID:     event.ID,
+		Timestamp:   event.Time.Format(time.RFC3339Nano),
+		Platform:    "go",
+		Level:       event.Level,
+		Logger:      "agent-bot",
+		ServerName:  event.ServerName,
+		Environment: event.Environment,
+		Tags:        event.Tags,
+		Exception:   sentryExceptions{Values: []sentryException{exception}},
+	}
+	if event.Kind != KindPanic {
+		// Panics group by their stack
+		payload.Fingerprint = event.Fingerprint
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	header := map[string]string{"event_id": event.ID, "dsn": s.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339)}
+	for _, part := range []any{header, map[string]string{"type": "event"}, payload} {
+		if err := encoder.Encode(part); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	return do(s.client, req)
+}
+
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
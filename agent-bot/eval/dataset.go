@@ -0,0 +1,118 @@
+// Package eval replays recorded conversations through the agent and scores
+// whether it chose to reply when it should have, and how good its replies
+// were, so prompt and model changes can be compared before they ship.
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Conversation is one recorded conversation. Its first message starts a
+// thread in a channel (or a DM when DM is set) and later messages are
+// replies in that thread.
+type Conversation struct {
+	Name  string `json:"name"`
+	DM    bool   `json:"dm,omitempty"`
+	Turns []Turn `json:"turns"`
+}
+
+// Turn is one recorded message. Messages by the bot aren't replayed; they
+// mark that the user message before them got a reply and serve as the
+// reference answer when grading.
+type Turn struct {
+	User string `json:"user,omitempty"`
+	Text string `json:"text"`
+	Bot  bool   `json:"bot,omitempty"`
+
+	// ExpectReply overrides whether the bot should answer this message;
+	// by default it should when the next turn is the bot's
+	ExpectReply *bool `json:"expect_reply,omitempty"`
+
+	// Criteria tells the judge what a good reply to this message contains
+	Criteria string `json:"criteria,omitempty"`
+}
+
+// Load reads conversations from a JSONL file, one conversation per line
+func Load(path string) ([]Conversation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var conversations []Conversation
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "//") {
+			continue
+		}
+
+		var conversation Conversation
+		if err := json.Unmarshal([]byte(text), &conversation); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if conversation.Name == "" {
+			conversation.Name = fmt.Sprintf("line-%d", line)
+		}
+		if err := conversation.validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		conversations = append(conversations, conversation)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return conversations, nil
+}
+
+func (c Conversation) validate() error {
+	if len(c.Turns) == 0 {
+		return fmt.Errorf("conversation %q has no turns", c.Name)
+	}
+	if c.Turns[0].Bot {
+		return fmt.Errorf("conversation %q starts with a bot message", c.Name)
+	}
+	for i, turn := range c.Turns {
+		if !turn.Bot && turn.User == "" {
+			return fmt.Errorf("conversation %q turn %d has no user", c.Name, i+1)
+		}
+	}
+	return nil
+}
+
+// ExpectReply reports whether the bot should answer turn i
+func (c Conversation) ExpectReply(i int) bool {
+	if c.Turns[i].ExpectReply != nil {
+		return *c.Turns[i].ExpectReply
+	}
+	return i+1 < len(c.Turns) && c.Turns[i+1].Bot
+}
+
+// Reference returns the recorded bot reply to turn i, if there is one
+func (c Conversation) Reference(i int) string {
+	if i+1 < len(c.Turns) && c.Turns[i+1].Bot {
+		return c.Turns[i+1].Text
+	}
+	return ""
+}
+
+// Transcript renders the turns before i, one "user: text" line each
+func (c Conversation) Transcript(i int, botName string) string {
+	var sb strings.Builder
+	for _, turn := range c.Turns[:i] {
+		name := turn.User
+		if turn.Bot {
+			name = botName
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s\n", name, turn.Text))
+	}
+	return sb.String()
+}
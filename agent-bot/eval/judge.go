@@ -0,0 +1,78 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"agent-bot/types"
+)
+
+// MaxScore is the best grade the judge gives
+const MaxScore = 5
+
+var scoreLine = regexp.MustCompile(`(?i)score:\s*([1-5])`)
+
+// Judge grades replies with an LLM
+type Judge struct {
+	llm     types.LLM
+	botName string
+}
+
+// NewJudge creates a judge that asks llm for grades
+func NewJudge(llm types.LLM, botName string) *Judge {
+	return &Judge{llm: llm, botName: botName}
+}
+
+// Grade scores the bot's reply to turn i from 1 to MaxScore, with the judge's reason
+func (j *Judge) Grade(ctx context.Context, conversation Conversation, i int, reply string) (int, string, error) {
+	answer, err := j.llm.Prompt(ctx, j.prompt(conversation, i, reply))
+	if err != nil {
+		return 0, "", fmt.Errorf("judge request failed: %w", err)
+	}
+	return ParseGrade(answer)
+}
+
+func (j *Judge) prompt(conversation Conversation, i int, reply string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("You are grading a reply written by %s, an assistant in a team chat.\n\n", j.botName))
+	if transcript := conversation.Transcript(i, j.botName); transcript != "" {
+		sb.WriteString("Conversation so far:\n" + transcript + "\n")
+	}
+	turn := conversation.Turns[i]
+	sb.WriteString(fmt.Sprintf("Latest message from %s:\n%s\n\n", turn.User, turn.Text))
+	if reference := conversation.Reference(i); reference != "" {
+		sb.WriteString("A reply that was considered good at the time (the new reply does not need to match it word for word):\n" + reference + "\n\n")
+	}
+	if turn.Criteria != "" {
+		sb.WriteString("A good reply: " + turn.Criteria + "\n\n")
+	}
+	sb.WriteString("Reply to grade:\n" + reply + "\n\n")
+	sb.WriteString(`Grade how well the reply answers the latest message: correctness, helpfulness, and fit for a chat message.
+1 = wrong or unhelpful, 3 = acceptable, 5 = excellent.
+
+Answer in exactly this format:
+SCORE: <1-5>
+REASON: <one sentence>`)
+	return sb.String()
+}
+
+// ParseGrade reads a judge answer in the SCORE/REASON format
+func ParseGrade(answer string) (int, string, error) {
+	match := scoreLine.FindStringSubmatch(answer)
+	if match == nil {
+		return 0, "", fmt.Errorf("judge answer has no score: %.100q", answer)
+	}
+	score, _ := strconv.Atoi(match[1])
+
+	reason := ""
+	for _, line := range strings.Split(answer, "\n") {
+		if label, text, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(label), "reason") {
+			reason = strings.TrimSpace(text)
+			break
+		}
+	}
+	return score, reason, nil
+}
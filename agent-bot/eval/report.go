@@ -0,0 +1,133 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// TurnResult is the outcome of replaying one user message
+type TurnResult struct {
+	Turn        int    `json:"turn"`
+	ExpectReply bool   `json:"expect_reply"`
+	Replied     bool   `json:"replied"`
+	Reply       string `json:"reply,omitempty"`
+
+	// Score is the judge's grade, zero when the reply wasn't graded
+	Score  int    `json:"score,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Correct reports whether the bot made the expected reply decision
+func (t TurnResult) Correct() bool {
+	return t.ExpectReply == t.Replied
+}
+
+// ConversationResult holds the turn results of one conversation
+type ConversationResult struct {
+	Name  string       `json:"name"`
+	Turns []TurnResult `json:"turns"`
+}
+
+// Report collects the results of an evaluation run
+type Report struct {
+	Model         string               `json:"model"`
+	DecisionModel string               `json:"decision_model"`
+	JudgeModel    string               `json:"judge_model"`
+	Conversations []ConversationResult `json:"conversations"`
+
+	DecisionAccuracy float64 `json:"decision_accuracy"`
+	MissedReplies    int     `json:"missed_replies"`
+	UnwantedReplies  int     `json:"unwanted_replies"`
+	MeanScore        float64 `json:"mean_score"`
+	Graded           int     `json:"graded"`
+}
+
+// Add records a conversation and updates the totals
+func (r *Report) Add(result ConversationResult) {
+	r.Conversations = append(r.Conversations, result)
+
+	decisions, correct, graded, total := 0, 0, 0, 0
+	r.MissedReplies, r.UnwantedReplies = 0, 0
+	for _, conversation := range r.Conversations {
+		for _, turn := range conversation.Turns {
+			decisions++
+			switch {
+			case turn.Correct():
+				correct++
+			case turn.ExpectReply:
+				r.MissedReplies++
+			default:
+				r.UnwantedReplies++
+			}
+			if turn.Score > 0 {
+				graded++
+				total += turn.Score
+			}
+		}
+	}
+	if decisions > 0 {
+		r.DecisionAccuracy = float64(correct) / float64(decisions)
+	}
+	r.Graded = graded
+	r.MeanScore = 0
+	if graded > 0 {
+		r.MeanScore = float64(total) / float64(graded)
+	}
+}
+
+// WriteText writes a per-conversation table, the wrong decisions and low
+// grades, and the totals
+func (r *Report) WriteText(w io.Writer) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CONVERSATION\tDECISIONS\tSCORE")
+	for _, conversation := range r.Conversations {
+		correct, graded, total := 0, 0, 0
+		for _, turn := range conversation.Turns {
+			if turn.Correct() {
+				correct++
+			}
+			if turn.Score > 0 {
+				graded++
+				total += turn.Score
+			}
+		}
+		score := "-"
+		if graded > 0 {
+			score = fmt.Sprintf("%.1f", float64(total)/float64(graded))
+		}
+		fmt.Fprintf(table, "%s\t%d/%d\t%s\n", conversation.Name, correct, len(conversation.Turns), score)
+	}
+	table.Flush()
+
+	var notes []string
+	for _, conversation := range r.Conversations {
+		for _, turn := range conversation.Turns {
+			switch {
+			case turn.Error != "":
+				notes = append(notes, fmt.Sprintf("%s turn %d: %s", conversation.Name, turn.Turn, turn.Error))
+			case !turn.Correct() && turn.ExpectReply:
+				notes = append(notes, fmt.Sprintf("%s turn %d: expected a reply, got none", conversation.Name, turn.Turn))
+			case !turn.Correct():
+				notes = append(notes, fmt.Sprintf("%s turn %d: replied when it should have stayed quiet", conversation.Name, turn.Turn))
+			case turn.Score > 0 && turn.Score <= 2:
+				notes = append(notes, fmt.Sprintf("%s turn %d: scored %d/%d: %s", conversation.Name, turn.Turn, turn.Score, MaxScore, turn.Reason))
+			}
+		}
+	}
+	if len(notes) > 0 {
+		fmt.Fprintln(w)
+		for _, note := range notes {
+			fmt.Fprintln(w, note)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Decision accuracy: %.1f%% (%d missed, %d unwanted)\n", r.DecisionAccuracy*100, r.MissedReplies, r.UnwantedReplies)
+	if r.Graded > 0 {
+		fmt.Fprintf(w, "Response quality: %.2f/%d over %d replies\n", r.MeanScore, MaxScore, r.Graded)
+	} else {
+		fmt.Fprintln(w, "Response quality: no replies graded")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"agent-bot/agenttest"
+	"agent-bot/eval"
+	"agent-bot/llms"
+	"agent-bot/templates"
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+// evalBotUserID is the bot's user ID in replayed conversations
+const evalBotUserID = "eval-bot"
+
+// runEval implements the "eval" subcommand, which replays recorded
+// conversations through the agent and scores its decisions and replies.
+// It fails when the scores fall below the given thresholds, so it can gate
+// a deploy.
+func runEval(config Config, args []string) error {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	model := flags.String("model", config.AnthropicModel, "model that writes replies")
+	decisionModel := flags.String("decision-model", config.DecisionModel, "model that decides whether to reply")
+	judgeModel := flags.String("judge-model", "", "model that grades replies (default: -model)")
+	configFile := flags.String("config", config.ConfigFile, "config file with the response templates to evaluate")
+	botUsername := flags.String("bot-username", "agent", "username the conversations mention the bot by")
+	withTools := flags.Bool("tools", false, "give the model the real tools; they run against live services")
+	out := flags.String("out", "", "also write the full results as JSON to this file")
+	minAccuracy := flags.Float64("min-accuracy", 0, "fail if decision accuracy is below this fraction (0-1)")
+	minScore := flags.Float64("min-score", 0, "fail if the mean reply score is below this (1-5)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: agent-bot eval [flags] <conversations.jsonl>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no conversation files given")
+	}
+	if *judgeModel == "" {
+		*judgeModel = *model
+	}
+
+	var conversations []eval.Conversation
+	for _, path := range flags.Args() {
+		loaded, err := eval.Load(path)
+		if err != nil {
+			return err
+		}
+		conversations = append(conversations, loaded...)
+	}
+
+	fileConfig, err := loadFileConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config file: %v", err)
+	}
+
+	config.AnthropicModel = *model
+	config.DecisionModel = *decisionModel
+	config.BotUserID = evalBotUserID
+	config.BotUsername = *botUsername
+
+	registry := tools.NewRegistry()
+	var toolSelector *tools.Selector
+	if *withTools {
+		if config.AsanaKey == "" {
+			return fmt.Errorf("-tools needs ASANA_API_KEY")
+		}
+		shared := startSharedTools(config, fileConfig)
+		defer shared.Close()
+		registry = shared.registry
+		if toolSelector, err = newToolSelector(config); err != nil {
+			return fmt.Errorf("invalid tool preselection settings: %v", err)
+		}
+	}
+
+	llmBackend, decisionLLMBackend := newLLMBackends(config, registry, toolSelector)
+	judgeBackend := llms.NewAnthropicBackend(config.AnthropicKey, *judgeModel, config.DecisionMaxTokens, 0, false, nil)
+	features := NewFeatures()
+	responseTemplates := templates.NewSet(fileConfig.ResponseTemplates)
+
+	newAgent := func(chat types.Chat) *BotAgent {
+		agent := NewBotAgent(config.BotUserID, config.BotUsername, config.BotDisplayName,
+			&LLMAdapter{backend: llmBackend, features: features},
+			&LLMAdapter{backend: decisionLLMBackend, features: features},
+			chat)
+		agent.features = features
+		agent.templates = responseTemplates
+		agent.contextMaxMessages = config.ContextMaxMsgs
+		agent.contextMaxTokens = config.ContextMaxTokens
+		return agent
+	}
+
+	judge := eval.NewJudge(&LLMAdapter{backend: judgeBackend}, config.BotUsername)
+	report := &eval.Report{Model: *model, DecisionModel: *decisionModel, JudgeModel: *judgeModel}
+	for _, conversation := range conversations {
+		log.Printf("[%s] EVAL: Replaying %s (%d turns)", time.Now().Format("2006-01-02 15:04:05"), conversation.Name, len(conversation.Turns))
+		report.Add(replayConversation(conversation, newAgent, judge, config.BotUsername))
+	}
+
+	report.WriteText(os.Stdout)
+	if *out != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*out, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", *out, err)
+		}
+	}
+
+	if report.DecisionAccuracy < *minAccuracy {
+		return fmt.Errorf("decision accuracy %.3f is below %.3f", report.DecisionAccuracy, *minAccuracy)
+	}
+	if *minScore > 0 && report.MeanScore < *minScore {
+		return fmt.Errorf("mean reply score %.2f is below %.2f", report.MeanScore, *minScore)
+	}
+	return nil
+}
+
+// replayConversation delivers each user message of conversation to a fresh
+// agent, records whether it replied and has the judge grade expected replies
+func replayConversation(conversation eval.Conversation, newAgent func(types.Chat) *BotAgent, judge *eval.Judge, botUsername string) eval.ConversationResult {
+	h := agenttest.New(evalBotUserID, botUsername)
+	h.Attach(newAgent(h.Chat))
+
+	result := eval.ConversationResult{Name: conversation.Name}
+	root := ""
+	for i, turn := range conversation.Turns {
+		if turn.Bot {
+			continue
+		}
+
+		before := len(h.Chat.Posts())
+		switch {
+		case root != "":
+			h.Reply(turn.User, root, turn.Text)
+		case conversation.DM:
+			root = h.DM(turn.User, turn.Text).PostId
+		default:
+			root = h.Post(turn.User, turn.Text).PostId
+		}
+
+		turnResult := eval.TurnResult{Turn: i + 1, ExpectReply: conversation.ExpectReply(i)}
+		if posts := h.Chat.Posts(); len(posts) > before {
+			turnResult.Replied = true
+			turnResult.Reply = posts[len(posts)-1].Content
+		}
+
+		if turnResult.Replied && turnResult.ExpectReply {
+			score, reason, err := judge.Grade(context.Background(), conversation, i, turnResult.Reply)
+			if err != nil {
+				log.Printf("[%s] EVAL: Failed to grade %s turn %d: %v", time.Now().Format("2006-01-02 15:04:05"), conversation.Name, i+1, err)
+				turnResult.Error = err.Error()
+			}
+			turnResult.Score, turnResult.Reason = score, reason
+		}
+		result.Turns = append(result.Turns, turnResult)
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Runtime feature flags that admins can toggle without a restart
+const (
+	FeatureTools               = "tools"
+	FeatureThreadParticipation = "thread_participation"
+	FeatureDecisionLLM         = "decision_llm"
+	FeatureTemplates           = "templates"
+	FeatureKnowledge           = "knowledge"
+	FeatureUserMemory          = "user_memory"
+	FeatureReactions           = "reactions"
+	FeatureReactionActions     = "reaction_actions"
+	FeatureQuietHours          = "quiet_hours"
+	FeatureLanguageDetection   = "language_detection"
+	FeatureModeration          = "moderation"
+	FeaturePIIRedaction        = "pii_redaction"
+	FeatureInjectionGuard      = "injection_guard"
+	FeatureQuotas              = "quotas"
+	FeatureChannelIntro        = "channel_intro"
+)
+
+type feature struct {
+	enabled     bool
+	description string
+}
+
+// Features is a set of named on/off switches
+type Features struct {
+	mu    sync.RWMutex
+	flags map[string]*feature
+}
+
+func NewFeatures() *Features {
+	f := &Features{flags: make(map[string]*feature)}
+	f.Define(FeatureTools, true, "Let the main LLM use tools (web search, Asana, MCP, ...)")
+	f.Define(FeatureThreadParticipation, true, "Reply unprompted in threads the bot is active in")
+	f.Define(FeatureDecisionLLM, true, "Use the decision LLM for thread participation (heuristics otherwise)")
+	f.Define(FeatureTemplates, true, "Apply structured response templates")
+	f.Define(FeatureKnowledge, true, "Add relevant imported history to prompts")
+	f.Define(FeatureUserMemory, true, "Add facts users asked the bot to remember about them to prompts")
+	f.Define(FeatureReactions, true, "React to mentions with :eyes:, then :white_check_mark: or :x: when the reply is done")
+	f.Define(FeatureReactionActions, true, "Run the actions mapped to emoji in REACTION_ACTIONS when users react with them")
+	f.Define(FeatureQuietHours, true, "Hold standup questions and other proactive DMs while users are in DND or quiet hours")
+	f.Define(FeatureLanguageDetection, true, "Reply in the language each message is written in")
+	f.Define(FeatureModeration, true, "Screen messages and replies with the configured moderation lists and classifier")
+	f.Define(FeaturePIIRedaction, true, "Replace emails, phone numbers and other personal data in prompts with placeholders")
+	f.Define(FeatureInjectionGuard, true, "Refuse or flag messages and quoted posts that look like prompt injection")
+	f.Define(FeatureChannelIntro, true, "Introduce the bot in channels it is added to")
+	f.Define(FeatureQuotas, true, "Stop replying to users and channels past their daily token or cost quota")
+	return f
+}
+
+// Define registers a feature with its default state
+func (f *Features) Define(name string, enabled bool, description string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flags[name] = &feature{enabled: enabled, description: description}
+}
+
+// Enabled reports whether a feature is on; unknown features are off
+func (f *Features) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	flag, ok := f.flags[name]
+	return ok && flag.enabled
+}
+
+// Set turns a feature on or off
+func (f *Features) Set(name string, enabled bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	flag, ok := f.flags[name]
+	if !ok {
+		return fmt.Errorf("unknown feature %q", name)
+	}
+	flag.enabled = enabled
+	return nil
+}
+
+// FeatureState describes a feature for display
+type FeatureState struct {
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+}
+
+// List returns all features sorted by name
+func (f *Features) List() []FeatureState {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	states := make([]FeatureState, 0, len(f.flags))
+	for name, flag := range f.flags {
+		states = append(states, FeatureState{Name: name, Enabled: flag.enabled, Description: flag.description})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"agent-bot/llms"
+)
+
+// readyCacheTTL keeps frequent readiness probes from calling every
+// dependency each time
+const readyCacheTTL = 15 * time.Second
+
+// dependencyTimeout bounds each readiness check
+const dependencyTimeout = 5 * time.Second
+
+// Overall statuses; unavailable is served with 503
+const (
+	statusOK          = "ok"
+	statusDegraded    = "degraded"
+	statusUnavailable = "unavailable"
+)
+
+// dependency is an external service checked by /readyz. The bot can't answer
+// without a required one; the others only take tools away.
+type dependency struct {
+	name     string
+	required bool
+	check    func(ctx context.Context) (string, error)
+}
+
+// dependencyCheck is the result of checking a dependency
+type dependencyCheck struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Required  bool   `json:"required"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// workspaceHealth is what a workspace knows about itself without calling out
+type workspaceHealth struct {
+	Name             string     `json:"name"`
+	Connected        bool       `json:"connected"`
+	LastEventAt      *time.Time `json:"last_event_at,omitempty"`
+	LastMessageAt    *time.Time `json:"last_message_at,omitempty"`
+	LLMCircuit       string     `json:"llm_circuit"`
+	DecisionCircuit  string     `json:"decision_circuit"`
+	PendingUpdates   int        `json:"pending_updates"`
+	PendingApprovals int        `json:"pending_approvals"`
+	CanaryQueue      int        `json:"canary_queue,omitempty"`
+	// Standby is set on replicas waiting to be elected leader
+	Standby bool `json:"standby,omitempty"`
+}
+
+// status rates the workspace: it can't answer while disconnected or with the
+// main LLM's circuit open, and falls back to heuristics without the decision LLM
+func (w workspaceHealth) status() string {
+	if w.Standby {
+		return statusOK
+	}
+	if !w.Connected || (w.LLMCircuit != string(llms.BreakerClosed) && w.LLMCircuit != "disabled") {
+		return statusUnavailable
+	}
+	if w.DecisionCircuit != string(llms.BreakerClosed) && w.DecisionCircuit != "disabled" {
+		return statusDegraded
+	}
+	return statusOK
+}
+
+// healthReport is the JSON body of /healthz and /readyz
+type healthReport struct {
+	Status        string            `json:"status"`
+	StartedAt     time.Time         `json:"started_at"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	Workspaces    []workspaceHealth `json:"workspaces"`
+	Checks        []dependencyCheck `json:"checks,omitempty"`
+	ChecksAt      *time.Time        `json:"checks_at,omitempty"`
+}
+
+// healthChecker serves /healthz, which reports connection state, circuit
+// breakers, queue depths and when events last arrived, and /readyz, which
+// also checks every dependency
+type healthChecker struct {
+	startedAt    time.Time
+	workspaces   func() []workspaceHealth
+	dependencies []dependency
+
+	mu        sync.Mutex
+	checks    []dependencyCheck
+	checkedAt time.Time
+}
+
+func newHealthChecker(workspaces func() []workspaceHealth, dependencies []dependency) *healthChecker {
+	return &healthChecker{
+		startedAt:    time.Now(),
+		workspaces:   workspaces,
+		dependencies: dependencies,
+	}
+}
+
+// handleLive serves /healthz without calling any dependency
+func (h *healthChecker) handleLive(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, h.report(nil, time.Time{}))
+}
+
+// handleReady serves /readyz. Dependency results are reused for
+// readyCacheTTL; ?fresh=1 checks again.
+func (h *healthChecker) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks, checkedAt := h.check(r.Context(), r.URL.Query().Get("fresh") != "")
+	writeHealthReport(w, h.report(checks, checkedAt))
+}
+
+func (h *healthChecker) report(checks []dependencyCheck, checkedAt time.Time) healthReport {
+	report := healthReport{
+		Status:        statusOK,
+		StartedAt:     h.startedAt,
+		UptimeSeconds: int64(time.Since(h.startedAt).Seconds()),
+		Workspaces:    h.workspaces(),
+		Checks:        checks,
+	}
+	if !checkedAt.IsZero() {
+		report.ChecksAt = &checkedAt
+	}
+
+	worsen := func(status string) {
+		if status == statusUnavailable || report.Status == statusOK {
+			report.Status = status
+		}
+	}
+	for _, workspace := range report.Workspaces {
+		if status := workspace.status(); status != statusOK {
+			worsen(status)
+		}
+	}
+	for _, check := range checks {
+		if check.Status == statusOK {
+			continue
+		}
+		if check.Required {
+			worsen(statusUnavailable)
+		} else {
+			worsen(statusDegraded)
+		}
+	}
+	return report
+}
+
+// check runs every dependency check concurrently, or returns the previous
+// results while they are fresh
+func (h *healthChecker) check(ctx context.Context, fresh bool) ([]dependencyCheck, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !fresh && h.checks != nil && time.Since(h.checkedAt) < readyCacheTTL {
+		return h.checks, h.checkedAt
+	}
+
+	checks := make([]dependencyCheck, len(h.dependencies))
+	var wg sync.WaitGroup
+	for i, dep := range h.dependencies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checks[i] = runDependencyCheck(ctx, dep)
+		}()
+	}
+	wg.Wait()
+
+	for _, check := range checks {
+		if check.Status != statusOK {
+			log.Printf("[%s] HEALTH: %s check failed: %s", time.Now().Format("2006-01-02 15:04:05"), check.Name, check.Error)
+		}
+	}
+	h.checks, h.checkedAt = checks, time.Now()
+	return h.checks, h.checkedAt
+}
+
+func runDependencyCheck(ctx context.Context, dep dependency) dependencyCheck {
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+
+	started := time.Now()
+	detail, err := dep.check(ctx)
+	check := dependencyCheck{
+		Name:      dep.name,
+		Status:    statusOK,
+		Required:  dep.required,
+		LatencyMS: time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		check.Status = "fail"
+		check.Error = err.Error()
+	} else {
+		check.Detail = detail
+	}
+	return check
+}
+
+func writeHealthReport(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == statusUnavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// llmDependency pings an LLM backend without spending tokens
+func llmDependency(name string, backend llms.LLMBackend, required bool) dependency {
+	return dependency{name: name, required: required, check: func(ctx context.Context) (string, error) {
+		pinger, ok := backend.(llms.Pinger)
+		if !ok {
+			return "not checked", nil
+		}
+		return "reachable", pinger.Ping(ctx)
+	}}
+}
+
+// toolDependencies checks the services behind the shared tools: Asana; Jira,
+// PagerDuty, Prometheus, Kubernetes and the diagram renderer when configured;
+// and every MCP server in the config file
+func toolDependencies(shared *sharedTools, fileConfig *FileConfig) []dependency {
+	deps := []dependency{{name: "asana", check: func(ctx context.Context) (string, error) {
+		return "token accepted", shared.asana.Ping(ctx)
+	}}}
+	if shared.jira != nil {
+		deps = append(deps, dependency{name: "jira", check: func(ctx context.Context) (string, error) {
+			return "token accepted", shared.jira.Ping(ctx)
+		}})
+	}
+	if shared.pagerduty != nil {
+		deps = append(deps, dependency{name: "pagerduty", check: func(ctx context.Context) (string, error) {
+			return "token accepted", shared.pagerduty.Ping(ctx)
+		}})
+	}
+	if shared.prometheus != nil {
+		deps = append(deps, dependency{name: "prometheus", check: func(ctx context.Context) (string, error) {
+			return "answered a query", shared.prometheus.Ping(ctx)
+		}})
+	}
+	if shared.kube != nil {
+		deps = append(deps, dependency{name: "kubernetes", check: func(ctx context.Context) (string, error) {
+			return "pods listable", shared.kube.Ping(ctx)
+		}})
+	}
+	if shared.renderer != nil {
+		deps = append(deps, dependency{name: "renderer", check: func(ctx context.Context) (string, error) {
+			return "healthy", shared.renderer.Ping(ctx)
+		}})
+	}
+
+	started := make(map[string]bool, len(shared.mcpClients))
+	for _, client := range shared.mcpClients {
+		client := client
+		started[client.Name()] = true
+		deps = append(deps, dependency{name: "mcp/" + client.Name(), check: func(ctx context.Context) (string, error) {
+			remote, err := client.ListTools(ctx)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d tools", len(remote)), nil
+		}})
+	}
+	for _, server := range fileConfig.MCPServers {
+		if !started[server.Name] {
+			deps = append(deps, dependency{name: "mcp/" + server.Name, check: func(ctx context.Context) (string, error) {
+				return "", fmt.Errorf("server did not start")
+			}})
+		}
+	}
+	return deps
+}
+
+// mattermostDependency checks the workspace's REST API
+func (b *Bot) mattermostDependency() dependency {
+	name := "mattermost"
+	if b.config.Name != "" {
+		name += "/" + b.config.Name
+	}
+	return dependency{name: name, required: true, check: func(ctx context.Context) (string, error) {
+		type pingResult struct {
+			status string
+			err    error
+		}
+		done := make(chan pingResult, 1)
+		go func() {
+			status, _, err := b.client.GetPing()
+			done <- pingResult{status, err}
+		}()
+		select {
+		case result := <-done:
+			if result.err != nil {
+				return "", result.err
+			}
+			return "ping " + result.status, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}}
+}
+
+// workspaceHealth reports the workspace's own state; connected comes from
+// the websocket on Mattermost and from the platform client elsewhere
+func (b *Bot) workspaceHealth(connected bool) workspaceHealth {
+	health := workspaceHealth{
+		Name:            b.profileName(),
+		Connected:       connected,
+		LastEventAt:     stampTime(&b.lastEventAt),
+		LastMessageAt:   stampTime(&b.lastMessageAt),
+		LLMCircuit:      breakerState(b.llmBreaker),
+		DecisionCircuit: breakerState(b.decisionBreaker),
+		Standby:         b.standby.Load(),
+	}
+	if b.chat != nil {
+		health.PendingUpdates = b.chat.updates.depth()
+	}
+	if b.approvals != nil {
+		health.PendingApprovals = len(b.approvals.Pending())
+	}
+	if b.canaryMirror != nil {
+		health.CanaryQueue = b.canaryMirror.Pending()
+	}
+	if b.canaryEvents != nil {
+		health.CanaryQueue += len(b.canaryEvents)
+	}
+	return health
+}
+
+func breakerState(breaker *llms.CircuitBreaker) string {
+	if breaker == nil {
+		return "disabled"
+	}
+	state, _ := breaker.Status()
+	return string(state)
+}
+
+// stamp records the current time in a Unix-nanosecond timestamp
+func stamp(at *atomic.Int64) {
+	at.Store(time.Now().UnixNano())
+}
+
+// stampTime reads a timestamp written by stamp; nil if it never was
+func stampTime(at *atomic.Int64) *time.Time {
+	nanos := at.Load()
+	if nanos == 0 {
+		return nil
+	}
+	t := time.Unix(0, nanos).UTC()
+	return &t
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"agent-bot/hooks"
+	"agent-bot/llms"
+	"agent-bot/metrics"
+	"agent-bot/notify"
+	"agent-bot/prompts"
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+// maxHookPayloadChars bounds the payload JSON given to the model
+const maxHookPayloadChars = 20000
+
+// hookTimeout bounds triaging one payload, including tool calls
+const hookTimeout = 3 * time.Minute
+
+// maxHookFallbackChars bounds the raw payload posted when triage fails
+const maxHookFallbackChars = 3000
+
+type hookResponse struct {
+	Hook   string `json:"hook"`
+	Queued bool   `json:"queued"`
+}
+
+// handleHook accepts POST /hooks/<name> with any JSON body, answers 202 and
+// has the agent triage the payload into the hook's channel. Requests are
+// authenticated by API key, as a Bearer token or ?token=.
+func (b *Bot) handleHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/hooks/"), "/")
+	hook := b.hooks.Get(name)
+	if hook == nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "unknown hook"})
+		return
+	}
+
+	if r.Header.Get("Authorization") == "" {
+		if token := r.URL.Query().Get("token"); token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	key, ok := b.authenticateAPIKey(w, r)
+	if !ok {
+		return
+	}
+	if !key.CanPostTo(hook.ChannelID) {
+		writeJSON(w, http.StatusForbidden, apiError{Error: "API key is not scoped to this hook's channel"})
+		return
+	}
+
+	var payload map[string]interface{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes)).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON body"})
+		return
+	}
+
+	log.Printf("[%s] HOOK: %s payload from key %s queued for triage", time.Now().Format("2006-01-02 15:04:05"), hook.Name, key.ID)
+	go b.triageHook(hook, payload)
+	writeJSON(w, http.StatusAccepted, hookResponse{Hook: hook.Name, Queued: true})
+}
+
+// triageHook has the model triage a payload and posts the result to the
+// hook's channel, or the raw payload when the model fails
+func (b *Bot) triageHook(hook *hooks.Hook, payload map[string]interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	outcome := "triaged"
+	message, err := b.renderHookTriage(ctx, hook, payload)
+	if err != nil {
+		log.Printf("[%s] HOOK: %s triage failed, posting the payload: %v", time.Now().Format("2006-01-02 15:04:05"), hook.Name, err)
+		outcome = "raw"
+		message = fmt.Sprintf("**%s** received a payload I couldn't triage:\n```json\n%s\n```", hook.Name, notify.Compact(payload, maxHookFallbackChars))
+	}
+
+	notification := notify.Notification{
+		Rule:     "hook:" + hook.Name,
+		Target:   notify.Target{ChannelID: hook.ChannelID},
+		Message:  message,
+		FollowUp: hook.FollowUp,
+	}
+	if err := b.deliverNotification(notification); err != nil {
+		log.Printf("[%s] HOOK: %s failed to post: %v", time.Now().Format("2006-01-02 15:04:05"), hook.Name, err)
+		outcome = "failed"
+	}
+	metrics.Inc("hook_payloads_total", "hook", hook.Name, "outcome", outcome)
+}
+
+func (b *Bot) renderHookTriage(ctx context.Context, hook *hooks.Hook, payload map[string]interface{}) (string, error) {
+	data := prompts.HookData{Name: hook.Name, Payload: payload, JSON: notify.Compact(payload, maxHookPayloadChars)}
+	var prompt string
+	var err error
+	if hook.Custom() {
+		prompt, err = hook.Render(data)
+	} else {
+		prompt, err = b.prompts.Render(prompts.Hook, data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !hook.Tools {
+		ctx = llms.WithoutTools(ctx)
+	}
+	ctx = tools.WithRequest(ctx, tools.Request{UserID: "hook:" + hook.Name, ChannelID: hook.ChannelID})
+	triage, err := b.llmBackend.Prompt(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	if triage = strings.TrimSpace(triage); triage == "" {
+		return "", fmt.Errorf("empty triage")
+	}
+	return triage, nil
+}
+
+// handleHooksCommand implements "!hooks"
+func (b *Bot) handleHooksCommand(message types.PostedMessage, args []string) string {
+	all := b.hooks.All()
+	if len(all) == 0 {
+		return "No hooks are configured. Add them under `hooks` in the config file."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Hooks** (POST JSON to `/hooks/<name>` with an API key)\n")
+	for _, hook := range all {
+		var extras []string
+		if hook.Custom() {
+			extras = append(extras, "own prompt")
+		}
+		if hook.Tools {
+			extras = append(extras, "tools")
+		}
+		if hook.FollowUp {
+			extras = append(extras, "follows up in thread")
+		}
+		line := fmt.Sprintf("- `%s` → channel `%s`", hook.Name, hook.ChannelID)
+		if len(extras) > 0 {
+			line += " (" + strings.Join(extras, ", ") + ")"
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,111 @@
+// Package hooks configures named inbound webhooks whose JSON payloads are
+// handed to the agent with a prompt, e.g. to triage Alertmanager or Sentry
+// alerts into a channel.
+package hooks
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"agent-bot/prompts"
+)
+
+// namePattern keeps hook names usable in URL paths
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Config is a named hook served at /hooks/<name>
+type Config struct {
+	Name      string `yaml:"name"`
+	ChannelID string `yaml:"channel_id"`
+	// Prompt is a text/template rendered with prompts.HookData; empty uses
+	// the built-in hook prompt
+	Prompt string `yaml:"prompt"`
+	// Tools lets the model use its tools while triaging, e.g. to look up
+	// related Jira issues
+	Tools bool `yaml:"tools"`
+	// FollowUp makes the bot follow the thread under the post and answer
+	// replies there
+	FollowUp bool `yaml:"follow_up"`
+}
+
+// Hook is a configured hook with its prompt compiled
+type Hook struct {
+	Config
+	prompt *template.Template
+}
+
+// Custom reports whether the hook has its own prompt
+func (h *Hook) Custom() bool {
+	return h.prompt != nil
+}
+
+// Render renders the hook's own prompt with data
+func (h *Hook) Render(data prompts.HookData) (string, error) {
+	var b strings.Builder
+	if err := h.prompt.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render %s prompt: %w", h.Name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// Set is the collection of configured hooks
+type Set struct {
+	hooks []*Hook
+}
+
+// NewSet validates the hooks and compiles their prompts
+func NewSet(configs []Config) (*Set, error) {
+	set := &Set{}
+	seen := make(map[string]bool)
+	for i, c := range configs {
+		if !namePattern.MatchString(c.Name) {
+			return nil, fmt.Errorf("hook %d: name %q must be lowercase letters, digits, '-' or '_'", i+1, c.Name)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("duplicate hook name %q", c.Name)
+		}
+		seen[c.Name] = true
+		if c.ChannelID == "" {
+			return nil, fmt.Errorf("hook %s has no channel_id", c.Name)
+		}
+
+		hook := &Hook{Config: c}
+		if c.Prompt != "" {
+			tmpl, err := template.New(c.Name).Funcs(prompts.Funcs()).Parse(c.Prompt)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: bad prompt: %w", c.Name, err)
+			}
+			// Catch references to fields HookData doesn't have
+			if err := tmpl.Execute(io.Discard, prompts.HookData{}); err != nil {
+				return nil, fmt.Errorf("hook %s: bad prompt: %w", c.Name, err)
+			}
+			hook.prompt = tmpl
+		}
+		set.hooks = append(set.hooks, hook)
+	}
+	return set, nil
+}
+
+// Get returns the hook named name, or nil
+func (s *Set) Get(name string) *Hook {
+	if s == nil {
+		return nil
+	}
+	for _, hook := range s.hooks {
+		if hook.Name == name {
+			return hook
+		}
+	}
+	return nil
+}
+
+// All returns the configured hooks
+func (s *Set) All() []*Hook {
+	if s == nil {
+		return nil
+	}
+	return s.hooks
+}
@@ -0,0 +1,107 @@
+// Package imagegen generates images from text prompts written by the model,
+// with the OpenAI images API or Stability AI
+package imagegen
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+const (
+	// maxPromptChars bounds a prompt; both APIs reject much longer ones
+	maxPromptChars = 4000
+	// maxImageBytes bounds a generated image
+	maxImageBytes = 20 * 1024 * 1024
+	// toolTimeout is generate_image's deadline; larger models take up to a
+	// minute per image, longer than the default tool timeout
+	toolTimeout = 2 * time.Minute
+)
+
+// Shapes are the image shapes the model can ask for
+var Shapes = []string{"square", "landscape", "portrait"}
+
+// Provider generates one PNG image for a prompt in one of Shapes
+type Provider interface {
+	Generate(ctx context.Context, prompt, shape string) ([]byte, error)
+}
+
+type GenerateArgs struct {
+	Prompt  string `json:"prompt" jsonschema_description:"Detailed description of the image: subject, layout, labels to include and visual style, e.g. a whiteboard-style architecture sketch"`
+	Shape   string `json:"shape,omitempty" jsonschema_description:"square (default), landscape or portrait"`
+	Title   string `json:"title,omitempty" jsonschema_description:"Short title, used for the file name (optional)"`
+	Message string `json:"message,omitempty" jsonschema_description:"Short note posted with the image (optional)"`
+}
+
+// Tools returns generate_image, which posts the generated image to the
+// requesting conversation with upload, e.g. types.Chat.UploadFile
+func Tools(provider Provider, upload func(types.FileUpload) error) []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "generate_image",
+			Description: "Generate an image from a description and post it to the conversation: illustrations, sketches, mockups, icons or informal architecture drawings. Generated images can misspell labels and aren't exact; use render_diagram instead when it is available and the diagram must be precise. Refer to the image in your reply instead of describing it again.",
+			Schema:      tools.SchemaFor[GenerateArgs](),
+			Timeout:     toolTimeout,
+			Handler: tools.Typed(func(ctx context.Context, input GenerateArgs) (interface{}, error) {
+				req, ok := tools.RequestFrom(ctx)
+				if !ok || req.ChannelID == "" {
+					return nil, fmt.Errorf("no conversation to post the image to")
+				}
+				prompt := strings.TrimSpace(input.Prompt)
+				if prompt == "" {
+					return nil, fmt.Errorf("the prompt is empty")
+				}
+				if len(prompt) > maxPromptChars {
+					return nil, fmt.Errorf("the prompt is %d characters, more than the %d allowed", len(prompt), maxPromptChars)
+				}
+				shape, err := Shape(input.Shape)
+				if err != nil {
+					return nil, err
+				}
+
+				image, err := provider.Generate(ctx, prompt, shape)
+				if err != nil {
+					return nil, fmt.Errorf("error generating image: %w", err)
+				}
+
+				filename := fileName(input.Title) + ".png"
+				err = upload(types.FileUpload{ChannelId: req.ChannelID, ThreadId: req.ThreadID, Filename: filename, Data: image, Message: input.Message})
+				if err != nil {
+					return nil, fmt.Errorf("error posting image: %w", err)
+				}
+				return map[string]interface{}{"posted": filename, "bytes": len(image)}, nil
+			}),
+		},
+	}
+}
+
+// Shape normalizes a shape name, defaulting to square
+func Shape(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		return "square", nil
+	case "square", "landscape", "portrait":
+		return name, nil
+	}
+	return "", fmt.Errorf("unsupported shape %q (expected one of %s)", name, strings.Join(Shapes, ", "))
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// fileName turns a title into a file name such as auth-flow-sketch
+func fileName(title string) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(name) > 60 {
+		name = strings.TrimRight(name[:60], "-")
+	}
+	if name == "" {
+		return "image"
+	}
+	return name
+}
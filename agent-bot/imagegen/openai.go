@@ -0,0 +1,156 @@
+package imagegen
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultOpenAIURL is the base URL of the OpenAI API
+	DefaultOpenAIURL = "https://api.openai.com/v1"
+	// DefaultOpenAIModel is the image model used when none is configured
+	DefaultOpenAIModel = "gpt-image-1"
+)
+
+// OpenAI generates images with the /images/generations endpoint of the
+// OpenAI API, or of a server offering the same API such as LocalAI
+type OpenAI struct {
+	BaseURL    string
+	APIKey     string
+	Model      string
+	HTTPClient *http.Client
+}
+
+func NewOpenAI(baseURL, apiKey, model string, httpClient *http.Client) *OpenAI {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIURL
+	}
+	if model == "" {
+		model = DefaultOpenAIModel
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &OpenAI{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		Model:      model,
+		HTTPClient: httpClient,
+	}
+}
+
+type openAIRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	Size   string `json:"size"`
+	N      int    `json:"n"`
+	// ResponseFormat is only accepted by the DALL-E models; gpt-image-1
+	// always returns base64
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+type openAIResponse struct {
+	Data []struct {
+		B64JSON string `json:"b64_json"`
+		URL     string `json:"url"`
+	} `json:"data"`
+}
+
+// size returns the model's image size for a shape
+func (o *OpenAI) size(shape string) string {
+	wide, tall := "1536x1024", "1024x1536"
+	if strings.HasPrefix(o.Model, "dall-e-3") {
+		wide, tall = "1792x1024", "1024x1792"
+	}
+	switch shape {
+	case "landscape":
+		return wide
+	case "portrait":
+		return tall
+	}
+	return "1024x1024"
+}
+
+func (o *OpenAI) Generate(ctx context.Context, prompt, shape string) ([]byte, error) {
+	request := openAIRequest{Model: o.Model, Prompt: prompt, Size: o.size(shape), N: 1}
+	if strings.HasPrefix(o.Model, "dall-e") {
+		request.ResponseFormat = "b64_json"
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.BaseURL+"/images/generations", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 2*maxImageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("images API error %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+
+	var parsed openAIResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(parsed.Data) == 0 {
+		return nil, fmt.Errorf("images API returned no image")
+	}
+	if parsed.Data[0].B64JSON != "" {
+		image, err := base64.StdEncoding.DecodeString(parsed.Data[0].B64JSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		return image, nil
+	}
+	// Some compatible servers only return a link
+	return o.download(ctx, parsed.Data[0].URL)
+}
+
+func (o *OpenAI) download(ctx context.Context, url string) ([]byte, error) {
+	if url == "" {
+		return nil, fmt.Errorf("images API returned no image")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image: status %d", resp.StatusCode)
+	}
+	if len(image) > maxImageBytes {
+		return nil, fmt.Errorf("the generated image is larger than %d bytes", maxImageBytes)
+	}
+	return image, nil
+}
@@ -0,0 +1,97 @@
+package imagegen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultStabilityURL is the base URL of the Stability AI API
+	DefaultStabilityURL = "https://api.stability.ai"
+	// DefaultStabilityModel is the Stable Image service used when none is
+	// configured: core, ultra or sd3
+	DefaultStabilityModel = "core"
+)
+
+// stabilityAspectRatios maps Shapes to Stability's aspect ratios
+var stabilityAspectRatios = map[string]string{
+	"square":    "1:1",
+	"landscape": "16:9",
+	"portrait":  "9:16",
+}
+
+// Stability generates images with the Stability AI Stable Image API
+type Stability struct {
+	BaseURL    string
+	APIKey     string
+	Model      string
+	HTTPClient *http.Client
+}
+
+func NewStability(baseURL, apiKey, model string, httpClient *http.Client) *Stability {
+	if baseURL == "" {
+		baseURL = DefaultStabilityURL
+	}
+	if model == "" {
+		model = DefaultStabilityModel
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Stability{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		Model:      model,
+		HTTPClient: httpClient,
+	}
+}
+
+func (s *Stability) Generate(ctx context.Context, prompt, shape string) ([]byte, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"prompt", prompt},
+		{"aspect_ratio", stabilityAspectRatios[shape]},
+		{"output_format", "png"},
+	}
+	for _, field := range fields {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v2beta/stable-image/generate/%s", s.BaseURL, s.Model)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stability API error %d: %s", resp.StatusCode, strings.TrimSpace(string(image)))
+	}
+	if len(image) > maxImageBytes {
+		return nil, fmt.Errorf("the generated image is larger than %d bytes", maxImageBytes)
+	}
+	return image, nil
+}
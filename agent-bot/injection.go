@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"agent-bot/injection"
+	"agent-bot/metrics"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/types"
+)
+
+// PROMPT_INJECTION policies
+const (
+	injectionOff = "off"
+	// injectionFlag answers, with suspicious text marked in the prompt
+	injectionFlag = "flag"
+	// injectionRefuse won't answer suspicious messages and leaves suspicious
+	// posts out of the context
+	injectionRefuse = "refuse"
+)
+
+// removedPost replaces the content of suspicious context posts under the refuse policy
+const removedPost = "[removed: this message looked like a prompt injection attempt]"
+
+// injectionGuard applies the prompt injection policy to the message being
+// answered and the posts quoted with it
+type injectionGuard struct {
+	policy string
+	// classifier double-checks messages the heuristics let through; nil
+	// unless INJECTION_CLASSIFIER=llm
+	classifier *injection.LLMClassifier
+}
+
+// newInjectionGuard returns the guard for the configured policy, or nil when it is off
+func newInjectionGuard(config Config, decisionLLM types.LLM, promptSet *prompts.Set) *injectionGuard {
+	if config.PromptInjection == injectionOff {
+		return nil
+	}
+	guard := &injectionGuard{policy: config.PromptInjection}
+	if config.InjectionClassifier == "llm" {
+		guard.classifier = &injection.LLMClassifier{Prompt: func(ctx context.Context, text string) (string, error) {
+			prompt, err := promptSet.Render(prompts.Injection, prompts.InjectionData{Text: text})
+			if err != nil {
+				return "", err
+			}
+			return decisionLLM.Prompt(ctx, prompt)
+		}}
+	}
+	return guard
+}
+
+type injectionKey struct{}
+
+// injectionFlagged reports whether the message being answered with ctx was flagged
+func injectionFlagged(ctx context.Context) bool {
+	flagged, _ := ctx.Value(injectionKey{}).(bool)
+	return flagged
+}
+
+// guardingInjection reports whether the injection policy applies right now
+func (a *BotAgent) guardingInjection() bool {
+	return a.injection != nil && a.features.Enabled(FeatureInjectionGuard)
+}
+
+// screenInjection checks the message about to be answered. Under the refuse
+// policy a suspicious message gets a notice instead of an answer (false);
+// otherwise ctx records that it was flagged, for the context prompt.
+func (a *BotAgent) screenInjection(ctx context.Context, message types.PostedMessage) (context.Context, bool) {
+	if !a.guardingInjection() {
+		return ctx, true
+	}
+
+	reason := a.injectionReason(ctx, message.Message)
+	if reason == "" {
+		return ctx, true
+	}
+
+	reqid.Logf(ctx, "INJECTION: Message %s from %s in %s looks like prompt injection (%s), policy %s", message.PostId, message.UserId, message.ChannelId, reason, a.injection.policy)
+	metrics.Inc("prompt_injections_total", "policy", a.injection.policy)
+	if a.injection.policy == injectionRefuse {
+		notice := types.PostedMessage{UserId: message.UserId, ChannelId: message.ChannelId, ThreadId: message.ThreadId}
+		if notice.ThreadId == "" && message.Mentioned {
+			notice.ThreadId = message.PostId
+		}
+		a.postNotice(ctx, notice, fmt.Sprintf("Sorry, I won't act on that message: it looks like an attempt to change my instructions (%s).", reason))
+		return ctx, false
+	}
+	return context.WithValue(ctx, injectionKey{}, true), true
+}
+
+// injectionReason says why text looks like prompt injection, or returns ""
+// when neither the heuristics nor the classifier object to it
+func (a *BotAgent) injectionReason(ctx context.Context, text string) string {
+	reason := injection.Detect(text).String()
+	if reason == "" && a.injection.classifier != nil {
+		suspicious, why, err := a.injection.classifier.Classify(ctx, text)
+		if err != nil {
+			reqid.Logf(ctx, "INJECTION: Classifier failed, using heuristics only: %v", err)
+		} else if suspicious {
+			reason = why
+		}
+	}
+	return reason
+}
+
+// screenPost applies the policy to a post quoted in a prompt. The bot's own
+// posts are trusted.
+func (a *BotAgent) screenPost(p *types.Message) prompts.Post {
+	post := prompts.Post{Content: injection.Escape(p.Content), FromBot: p.UserID == a.botUserID}
+	if p.UserID == a.botUserID || !a.guardingInjection() {
+		return post
+	}
+	if finding := injection.Detect(p.Content); finding.Suspicious() {
+		log.Printf("[%s] INJECTION: Context post %s looks like prompt injection (%s)", time.Now().Format("2006-01-02 15:04:05"), p.ID, finding)
+		post.Flagged = true
+		if a.injection.policy == injectionRefuse {
+			post.Content = removedPost
+		}
+	}
+	return post
+}
+
+// injectionSummary describes the prompt injection policy for !config
+func (b *Bot) injectionSummary() string {
+	if b.config.PromptInjection == injectionOff || b.config.InjectionClassifier == "off" {
+		return b.config.PromptInjection
+	}
+	return b.config.PromptInjection + " (with llm classifier)"
+}
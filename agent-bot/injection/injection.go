@@ -0,0 +1,105 @@
+// Package injection defends prompts against instructions smuggled in through
+// chat content. Escape keeps user text from breaking out of the <message>
+// tags prompts quote it in, Detect spots common injection phrasing, and
+// LLMClassifier asks a model about text the heuristics let through.
+package injection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// signal is one heuristic for injected instructions
+type signal struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var signals = []signal{
+	// "ignore all previous instructions", "disregard the system prompt"
+	{"override", regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override|bypass)\b[^.\n]{0,40}\b(previous|prior|above|earlier|all|any|your|the|system)\b[^.\n]{0,30}\b(instructions?|prompts?|rules|guidelines|directions)\b`)},
+	// "you are now DAN", "from now on you will", "pretend you are"
+	{"role_change", regexp.MustCompile(`(?i)\b(you are now|from now on,? you (are|will|must)|pretend (to be|that you are|you are)|your new (role|persona|instructions))\b`)},
+	// "print your system prompt", "reveal your hidden instructions"
+	{"prompt_leak", regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|output|leak|tell me)\b[^.\n]{0,30}\b(system prompt|initial prompt|hidden (instructions|prompt)|your (instructions|prompt|rules))\b`)},
+	{"jailbreak", regexp.MustCompile(`(?i)\b(developer mode|DAN mode|do anything now|jailbreak(ed)?|without (any )?(restrictions|filters|guardrails))\b`)},
+	// Lines posing as another turn of the conversation, and chat template tokens
+	{"role_marker", regexp.MustCompile(`(?im)^\s*(system|assistant|human|developer)\s*:|<\|(im_start|im_end|system|endoftext)\|>|\[/?INST\]|<</?SYS>>`)},
+	// Attempts to close the tag the text is quoted in
+	{"delimiter", regexp.MustCompile(`(?i)</?message\b`)},
+	// "New instructions:", "Updated system prompt:"
+	{"new_instructions", regexp.MustCompile(`(?i)\b(new|updated|real|actual|admin|system) (instructions|rules|system prompt)\s*:`)},
+}
+
+// Finding lists the heuristics a text matched
+type Finding struct {
+	Signals []string
+}
+
+// Suspicious reports whether any heuristic matched
+func (f Finding) Suspicious() bool {
+	return len(f.Signals) > 0
+}
+
+// String describes the finding, e.g. "override, role_marker"
+func (f Finding) String() string {
+	return strings.Join(f.Signals, ", ")
+}
+
+// Detect checks text for common prompt injection phrasing. It is a cheap
+// first pass: it flags users talking about such phrases too.
+func Detect(text string) Finding {
+	var finding Finding
+	for _, s := range signals {
+		if s.pattern.MatchString(text) {
+			finding.Signals = append(finding.Signals, s.name)
+		}
+	}
+	return finding
+}
+
+var (
+	delimiterTag = regexp.MustCompile(`(?i)<(/?message\b)`)
+	specialToken = regexp.MustCompile(`<\|([A-Za-z_]+)\|>`)
+)
+
+// Escape neutralizes text that could end the <message> tag it is quoted in
+// or pass for a chat template token. Everything else, such as code, is left
+// as written.
+func Escape(text string) string {
+	text = delimiterTag.ReplaceAllString(text, "&lt;$1")
+	return specialToken.ReplaceAllString(text, "&lt;|$1|&gt;")
+}
+
+// LLMClassifier asks a language model whether text tries to give the bot
+// instructions. Prompt sends the request and returns the model's answer,
+// JSON like {"injection": true, "reason": "asks to reveal the system prompt"}.
+type LLMClassifier struct {
+	Prompt func(ctx context.Context, text string) (string, error)
+}
+
+// Classify returns whether the model thinks text is an injection attempt and why
+func (c LLMClassifier) Classify(ctx context.Context, text string) (bool, string, error) {
+	response, err := c.Prompt(ctx, text)
+	if err != nil {
+		return false, "", fmt.Errorf("injection model: %w", err)
+	}
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return false, "", fmt.Errorf("injection model answered without JSON: %q", response)
+	}
+	var answer struct {
+		Injection bool   `json:"injection"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &answer); err != nil {
+		return false, "", fmt.Errorf("failed to parse injection answer: %w", err)
+	}
+	if answer.Reason == "" {
+		answer.Reason = "classifier"
+	}
+	return answer.Injection, answer.Reason, nil
+}
@@ -0,0 +1,331 @@
+// Package jira is a Jira REST API v2 client and the tools built on it. It
+// authenticates with an email and API token on Jira Cloud or a bearer
+// personal access token on Server and Data Center, and falls back from
+// /search/jql to /search on servers that don't have it.
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errNotFound is returned for 404 responses so callers can fall back to older endpoints
+var errNotFound = errors.New("not found")
+
+type Client struct {
+	BaseURL    string
+	Email      string
+	APIToken   string
+	HTTPClient *http.Client
+}
+
+type SearchIssuesArgs struct {
+	JQL        string `json:"jql" jsonschema_description:"JQL query, e.g. project = OPS AND status != Done ORDER BY updated DESC"`
+	MaxResults int    `json:"max_results,omitempty" jsonschema_description:"Maximum number of issues to return (optional - default 20, max 50)"`
+}
+
+type GetIssueArgs struct {
+	IssueKey string `json:"issue_key" jsonschema_description:"The issue key, e.g. OPS-123"`
+}
+
+type CreateIssueArgs struct {
+	ProjectKey  string `json:"project_key" jsonschema_description:"The project key, e.g. OPS"`
+	Summary     string `json:"summary" jsonschema_description:"One-line issue title"`
+	Description string `json:"description,omitempty" jsonschema_description:"Issue description (optional)"`
+	IssueType   string `json:"issue_type,omitempty" jsonschema_description:"Issue type name such as Task, Bug or Story (optional - defaults to Task)"`
+}
+
+type TransitionIssueArgs struct {
+	IssueKey   string `json:"issue_key" jsonschema_description:"The issue key, e.g. OPS-123"`
+	Transition string `json:"transition" jsonschema_description:"Name of the transition or target status, e.g. In Progress or Done"`
+}
+
+// Issue is a summary of a Jira issue
+type Issue struct {
+	Key         string `json:"key"`
+	Summary     string `json:"summary"`
+	Status      string `json:"status"`
+	Type        string `json:"type,omitempty"`
+	Priority    string `json:"priority,omitempty"`
+	Assignee    string `json:"assignee,omitempty"`
+	Reporter    string `json:"reporter,omitempty"`
+	Description string `json:"description,omitempty"`
+	Updated     string `json:"updated,omitempty"`
+	URL         string `json:"url"`
+}
+
+// Transition is a workflow transition available on an issue
+type Transition struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"to_status"`
+}
+
+type rawIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary     string          `json:"summary"`
+		Description json.RawMessage `json:"description"`
+		Updated     string          `json:"updated"`
+		Status      *named          `json:"status"`
+		IssueType   *named          `json:"issuetype"`
+		Priority    *named          `json:"priority"`
+		Assignee    *person         `json:"assignee"`
+		Reporter    *person         `json:"reporter"`
+	} `json:"fields"`
+}
+
+type named struct {
+	Name string `json:"name"`
+}
+
+type person struct {
+	DisplayName string `json:"displayName"`
+}
+
+type rawTransition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	To   named  `json:"to"`
+}
+
+// issueFields are the fields requested when listing issues
+const issueFields = "summary,status,issuetype,priority,assignee,reporter,updated"
+
+// NewClient creates a Jira client. With an email, the token is sent as basic
+// auth (Jira Cloud); without one it is sent as a bearer personal access
+// token (Jira Server/Data Center).
+func NewClient(baseURL, email, apiToken string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Email:      email,
+		APIToken:   apiToken,
+		HTTPClient: httpClient,
+	}
+}
+
+func (c *Client) makeRequest(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if c.Email != "" {
+		req.SetBasicAuth(c.Email, c.APIToken)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.APIToken)
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errNotFound, string(respBody))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return respBody, nil
+}
+
+// Ping verifies the credentials by fetching the authenticated user
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.makeRequest(ctx, "GET", "/rest/api/2/myself", nil)
+	return err
+}
+
+func (c *Client) issueURL(key string) string {
+	return c.BaseURL + "/browse/" + key
+}
+
+func (c *Client) toIssue(raw rawIssue) Issue {
+	issue := Issue{
+		Key:     raw.Key,
+		Summary: raw.Fields.Summary,
+		Updated: raw.Fields.Updated,
+		URL:     c.issueURL(raw.Key),
+	}
+	if raw.Fields.Status != nil {
+		issue.Status = raw.Fields.Status.Name
+	}
+	if raw.Fields.IssueType != nil {
+		issue.Type = raw.Fields.IssueType.Name
+	}
+	if raw.Fields.Priority != nil {
+		issue.Priority = raw.Fields.Priority.Name
+	}
+	if raw.Fields.Assignee != nil {
+		issue.Assignee = raw.Fields.Assignee.DisplayName
+	}
+	if raw.Fields.Reporter != nil {
+		issue.Reporter = raw.Fields.Reporter.DisplayName
+	}
+	// API v2 returns descriptions as wiki markup strings
+	var description string
+	if json.Unmarshal(raw.Fields.Description, &description) == nil {
+		issue.Description = description
+	}
+	return issue
+}
+
+func (c *Client) SearchIssues(ctx context.Context, jql string, maxResults int) ([]Issue, error) {
+	if maxResults <= 0 {
+		maxResults = 20
+	}
+	if maxResults > 50 {
+		maxResults = 50
+	}
+
+	query := url.Values{}
+	query.Set("jql", jql)
+	query.Set("maxResults", fmt.Sprint(maxResults))
+	query.Set("fields", issueFields)
+
+	// Jira Cloud serves /search/jql; Server and Data Center only have /search
+	body, err := c.makeRequest(ctx, "GET", "/rest/api/2/search/jql?"+query.Encode(), nil)
+	if errors.Is(err, errNotFound) {
+		body, err = c.makeRequest(ctx, "GET", "/rest/api/2/search?"+query.Encode(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Issues []rawIssue `json:"issues"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	issues := make([]Issue, 0, len(response.Issues))
+	for _, raw := range response.Issues {
+		issue := c.toIssue(raw)
+		issue.Description = ""
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+func (c *Client) GetIssue(ctx context.Context, key string) (*Issue, error) {
+	body, err := c.makeRequest(ctx, "GET", "/rest/api/2/issue/"+url.PathEscape(key)+"?fields="+issueFields+",description", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw rawIssue
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	issue := c.toIssue(raw)
+	return &issue, nil
+}
+
+func (c *Client) CreateIssue(ctx context.Context, projectKey, summary, description, issueType string) (*Issue, error) {
+	if issueType == "" {
+		issueType = "Task"
+	}
+
+	fields := map[string]interface{}{
+		"project":   map[string]string{"key": projectKey},
+		"summary":   summary,
+		"issuetype": map[string]string{"name": issueType},
+	}
+	if description != "" {
+		fields["description"] = description
+	}
+
+	body, err := c.makeRequest(ctx, "POST", "/rest/api/2/issue", map[string]interface{}{"fields": fields})
+	if err != nil {
+		return nil, err
+	}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &Issue{Key: created.Key, Summary: summary, Type: issueType, URL: c.issueURL(created.Key)}, nil
+}
+
+func (c *Client) ListTransitions(ctx context.Context, key string) ([]Transition, error) {
+	body, err := c.makeRequest(ctx, "GET", "/rest/api/2/issue/"+url.PathEscape(key)+"/transitions", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Transitions []rawTransition `json:"transitions"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	transitions := make([]Transition, 0, len(response.Transitions))
+	for _, t := range response.Transitions {
+		transitions = append(transitions, Transition{ID: t.ID, Name: t.Name, Status: t.To.Name})
+	}
+	return transitions, nil
+}
+
+// TransitionIssue moves an issue through the transition whose name or target
+// status matches, case-insensitively
+func (c *Client) TransitionIssue(ctx context.Context, key, name string) (*Transition, error) {
+	transitions, err := c.ListTransitions(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var match *Transition
+	for i, t := range transitions {
+		if strings.EqualFold(t.Name, name) || strings.EqualFold(t.Status, name) || t.ID == name {
+			match = &transitions[i]
+			break
+		}
+	}
+	if match == nil {
+		available := make([]string, 0, len(transitions))
+		for _, t := range transitions {
+			available = append(available, fmt.Sprintf("%s (to %s)", t.Name, t.Status))
+		}
+		return nil, fmt.Errorf("no transition %q on %s; available: %s", name, key, strings.Join(available, ", "))
+	}
+
+	payload := map[string]interface{}{"transition": map[string]string{"id": match.ID}}
+	if _, err := c.makeRequest(ctx, "POST", "/rest/api/2/issue/"+url.PathEscape(key)+"/transitions", payload); err != nil {
+		return nil, err
+	}
+	return match, nil
+}
@@ -0,0 +1,62 @@
+package jira
+
+import (
+	"context"
+	"fmt"
+
+	"agent-bot/tools"
+)
+
+// Tools returns the Jira tools backed by this client
+func (c *Client) Tools() []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "search_jira_issues",
+			Description: "Search Jira issues with a JQL query",
+			Schema:      tools.SchemaFor[SearchIssuesArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input SearchIssuesArgs) (interface{}, error) {
+				issues, err := c.SearchIssues(ctx, input.JQL, input.MaxResults)
+				if err != nil {
+					return nil, fmt.Errorf("error searching issues: %w", err)
+				}
+				return issues, nil
+			}),
+		},
+		{
+			Name:        "get_jira_issue",
+			Description: "Get the details of a Jira issue, including its description",
+			Schema:      tools.SchemaFor[GetIssueArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input GetIssueArgs) (interface{}, error) {
+				issue, err := c.GetIssue(ctx, input.IssueKey)
+				if err != nil {
+					return nil, fmt.Errorf("error getting issue: %w", err)
+				}
+				return issue, nil
+			}),
+		},
+		{
+			Name:        "create_jira_issue",
+			Description: "Create a Jira issue. Only create issues the user explicitly asked for.",
+			Schema:      tools.SchemaFor[CreateIssueArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input CreateIssueArgs) (interface{}, error) {
+				issue, err := c.CreateIssue(ctx, input.ProjectKey, input.Summary, input.Description, input.IssueType)
+				if err != nil {
+					return nil, fmt.Errorf("error creating issue: %w", err)
+				}
+				return issue, nil
+			}),
+		},
+		{
+			Name:        "transition_jira_issue",
+			Description: "Move a Jira issue to another status by transition or status name. Only change status when the user asked for it.",
+			Schema:      tools.SchemaFor[TransitionIssueArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input TransitionIssueArgs) (interface{}, error) {
+				transition, err := c.TransitionIssue(ctx, input.IssueKey, input.Transition)
+				if err != nil {
+					return nil, fmt.Errorf("error transitioning issue: %w", err)
+				}
+				return fmt.Sprintf("%s moved to %s via %q", input.IssueKey, transition.Status, transition.Name), nil
+			}),
+		},
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"agent-bot/knowledge"
+	"agent-bot/llms"
+	"agent-bot/wiki"
+)
+
+// Retrieval limits for knowledge added to prompts
+const (
+	knowledgeResults  = 3
+	knowledgeMaxChars = 6000
+)
+
+// Team wiki providers
+const (
+	wikiOff        = "off"
+	wikiConfluence = "confluence"
+	wikiNotion     = "notion"
+)
+
+// newWikiProvider builds the search_wiki and get_wiki_page provider for
+// WIKI_PROVIDER, or nil when it is off
+func newWikiProvider(config Config) (wiki.Provider, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	switch config.WikiProvider {
+	case wikiOff:
+		return nil, nil
+	case wikiConfluence:
+		if config.WikiURL == "" || config.WikiAPIToken == "" {
+			return nil, fmt.Errorf("WIKI_PROVIDER=confluence requires WIKI_URL and WIKI_API_TOKEN")
+		}
+		return wiki.NewConfluence(config.WikiURL, config.WikiEmail, config.WikiAPIToken, config.WikiSpaces, httpClient), nil
+	case wikiNotion:
+		if config.WikiAPIToken == "" {
+			return nil, fmt.Errorf("WIKI_PROVIDER=notion requires WIKI_API_TOKEN")
+		}
+		return wiki.NewNotion(config.WikiAPIToken, config.WikiSpaces, httpClient), nil
+	default:
+		return nil, fmt.Errorf("unknown WIKI_PROVIDER %q (use off, confluence or notion)", config.WikiProvider)
+	}
+}
+
+// runImportKnowledge implements the "import-knowledge" subcommand, which
+// indexes exported Mattermost history without connecting to a server
+func runImportKnowledge(args []string) error {
+	flags := flag.NewFlagSet("import-knowledge", flag.ContinueOnError)
+	indexPath := flags.String("index", getEnvWithDefault("KNOWLEDGE_INDEX_FILE", "data/knowledge.json"), "knowledge index file to update")
+	channels := flags.String("channels", "", "comma-separated channel names to import (default: all)")
+	channel := flags.String("channel", "", "channel name for single-channel CSV exports (default: file name)")
+	includePrivate := flags.Bool("include-private", false, "also import private channels of bulk exports, searched only from the same channel")
+	team := flags.String("team", "", "team name for single-channel CSV exports (default: searched from every team)")
+	private := flags.Bool("private", false, "the single-channel CSV export is of a private channel")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: agent-bot import-knowledge [flags] <export.zip|export.jsonl|channel.csv>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no export files given")
+	}
+
+	opts := knowledge.ImportOptions{Channel: *channel, IncludePrivate: *includePrivate, Team: *team, Private: *private}
+	for _, name := range strings.Split(*channels, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Channels = append(opts.Channels, name)
+		}
+	}
+
+	index, err := knowledge.Open(*indexPath)
+	if err != nil {
+		return err
+	}
+	before := index.Len()
+
+	for _, path := range flags.Args() {
+		docs, err := knowledge.ImportFile(path, opts)
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %v", path, err)
+		}
+		index.Add(docs...)
+		log.Printf("[%s] KNOWLEDGE: Imported %d documents from %s", time.Now().Format("2006-01-02 15:04:05"), len(docs), path)
+	}
+
+	if err := index.Save(); err != nil {
+		return err
+	}
+	log.Printf("[%s] KNOWLEDGE: Index %s now holds %d documents (%d new)", time.Now().Format("2006-01-02 15:04:05"), *indexPath, index.Len(), index.Len()-before)
+	return nil
+}
+
+// withKnowledge appends the most relevant indexed history for query to the
+// prompt. Only history visible in channelID is searched: public channels of
+// its team, and the channel itself if it is private. When the request
+// collects sources each excerpt is numbered, so the reply can cite it with [n].
+func (a *BotAgent) withKnowledge(ctx context.Context, channelID, query, prompt string) string {
+	if a.knowledge == nil || a.knowledge.Len() == 0 || !a.features.Enabled(FeatureKnowledge) {
+		return prompt
+	}
+
+	scope, err := a.knowledgeScope(channelID)
+	if err != nil {
+		log.Printf("[%s] KNOWLEDGE: Failed to get channel %s, leaving out indexed history: %v", time.Now().Format("2006-01-02 15:04:05"), channelID, err)
+		return prompt
+	}
+	results := a.knowledge.Search(query, knowledgeResults, scope)
+	if len(results) == 0 {
+		return prompt
+	}
+
+	sources := llms.SourcesFrom(ctx)
+	var sb strings.Builder
+	sb.WriteString("\n\nPossibly relevant history from this workspace (may be outdated; use only if it helps):\n")
+	used := 0
+	for _, result := range results {
+		if used+len(result.Text) > knowledgeMaxChars {
+			break
+		}
+		label := fmt.Sprintf("~%s, %s", result.Channel, time.UnixMilli(result.CreatedAt).Format("2006-01-02"))
+		if sources != nil {
+			label = fmt.Sprintf("[%d] %s", sources.Add(llms.Source{Title: label}), label)
+		}
+		sb.WriteString(fmt.Sprintf("\n--- %s ---\n%s\n", label, result.Text))
+		used += len(result.Text)
+	}
+	if used == 0 {
+		return prompt
+	}
+	if sources != nil {
+		sb.WriteString("\nIf you use an excerpt, cite it with its number in brackets, e.g. [1].\n")
+	}
+
+	log.Printf("[%s] KNOWLEDGE: Added %d chars of indexed history to the prompt", time.Now().Format("2006-01-02 15:04:05"), used)
+	return prompt + sb.String()
+}
+
+// knowledgeScope is where indexed history may be shown for channelID.
+// Requests without a channel only see history of no particular team.
+func (a *BotAgent) knowledgeScope(channelID string) (knowledge.Scope, error) {
+	if channelID == "" {
+		return knowledge.Scope{}, nil
+	}
+	channel, err := a.chat.GetChannel(channelID)
+	if err != nil {
+		return knowledge.Scope{}, err
+	}
+	return knowledge.Scope{Team: channel.Team, Channel: channel.Name}, nil
+}
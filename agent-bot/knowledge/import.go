@@ -0,0 +1,300 @@
+package knowledge
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxChunkChars bounds the size of a single document so that retrieved
+// context stays small
+const maxChunkChars = 4000
+
+// ImportOptions filters what is imported
+type ImportOptions struct {
+	// Channels limits the import to these channel names; empty imports all public channels
+	Channels []string
+	// IncludePrivate also imports private channels of bulk exports. Their
+	// documents are only searched from the channel they came from.
+	IncludePrivate bool
+	// Channel names the channel for single-channel exports (CSV), defaulting to the file name
+	Channel string
+	// Team and Private describe the channel of a single-channel export,
+	// which the CSV doesn't record
+	Team    string
+	Private bool
+}
+
+func (o ImportOptions) wants(channel string) bool {
+	if len(o.Channels) == 0 {
+		return true
+	}
+	for _, name := range o.Channels {
+		if strings.EqualFold(name, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportFile reads a Mattermost bulk export (.jsonl, or the .zip produced by
+// mmctl export) or a single-channel CSV export and returns the documents to index
+func ImportFile(path string, opts ImportOptions) ([]Document, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip":
+		return importZip(path, opts)
+	case ".csv":
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open export: %w", err)
+		}
+		defer file.Close()
+		if opts.Channel == "" {
+			opts.Channel = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		return ParseChannelCSV(file, opts)
+	default:
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open export: %w", err)
+		}
+		defer file.Close()
+		return ParseBulkExport(file, opts)
+	}
+}
+
+func importZip(path string, opts ImportOptions) ([]Document, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export archive: %w", err)
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		if strings.HasSuffix(entry.Name, ".jsonl") {
+			reader, err := entry.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", entry.Name, err)
+			}
+			defer reader.Close()
+			return ParseBulkExport(reader, opts)
+		}
+	}
+	return nil, fmt.Errorf("no .jsonl file found in %s", path)
+}
+
+// bulkLine is one line of a Mattermost bulk export; only channels and posts
+// are used. Direct messages are never imported.
+type bulkLine struct {
+	Type    string       `json:"type"`
+	Channel *bulkChannel `json:"channel"`
+	Post    *bulkPost    `json:"post"`
+}
+
+type bulkChannel struct {
+	Team string `json:"team"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// bulkChannelOpen is the type of public channels in bulk exports
+const bulkChannelOpen = "O"
+
+type bulkPost struct {
+	Team     string      `json:"team"`
+	Channel  string      `json:"channel"`
+	User     string      `json:"user"`
+	Message  string      `json:"message"`
+	CreateAt int64       `json:"create_at"`
+	Replies  []bulkReply `json:"replies"`
+}
+
+type bulkReply struct {
+	User     string `json:"user"`
+	Message  string `json:"message"`
+	CreateAt int64  `json:"create_at"`
+}
+
+// ParseBulkExport turns each channel post and its replies into thread
+// documents. Posts of channels the export doesn't list as public are
+// treated as private.
+func ParseBulkExport(r io.Reader, opts ImportOptions) ([]Document, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	public := make(map[string]bool) // team/channel -> open to the team
+	var docs []Document
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		var line bulkLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if line.Type == "channel" && line.Channel != nil {
+			public[line.Channel.Team+"/"+line.Channel.Name] = line.Channel.Type == bulkChannelOpen
+			continue
+		}
+		if line.Type != "post" || line.Post == nil || !opts.wants(line.Post.Channel) {
+			continue
+		}
+
+		post := line.Post
+		private := !public[post.Team+"/"+post.Channel]
+		if private && !opts.IncludePrivate {
+			continue
+		}
+		lines := []string{formatLine(post.User, post.Message)}
+		for _, reply := range post.Replies {
+			lines = append(lines, formatLine(reply.User, reply.Message))
+		}
+
+		threadID := fmt.Sprintf("%s/%s/%d/%s", post.Team, post.Channel, post.CreateAt, post.User)
+		docs = append(docs, chunk("bulk-export", post.Team, post.Channel, private, threadID, post.CreateAt, lines)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+
+	return docs, nil
+}
+
+// ParseChannelCSV reads a single-channel CSV export. Columns are matched by
+// header name; consecutive messages are grouped into chunks.
+func ParseChannelCSV(r io.Reader, opts ImportOptions) ([]Document, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	column := func(names ...string) int {
+		for i, h := range header {
+			for _, name := range names {
+				if strings.EqualFold(strings.TrimSpace(h), name) {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	messageCol := column("Message", "Post Message")
+	userCol := column("User Name", "Username", "User")
+	timeCol := column("Post Creation Time", "Create At", "Timestamp")
+	if messageCol < 0 {
+		return nil, fmt.Errorf("CSV export has no Message column")
+	}
+
+	var docs []Document
+	var lines []string
+	var started int64
+	size := 0
+	flush := func() {
+		if len(lines) > 0 {
+			threadID := fmt.Sprintf("%s/%d", opts.Channel, started)
+			docs = append(docs, chunk("channel-export", opts.Team, opts.Channel, opts.Private, threadID, started, lines)...)
+		}
+		lines, size, started = nil, 0, 0
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+
+		line := formatLine(field(userCol), field(messageCol))
+		if size+len(line) > maxChunkChars {
+			flush()
+		}
+		if started == 0 {
+			started = parseTimestamp(field(timeCol))
+		}
+		lines = append(lines, line)
+		size += len(line)
+	}
+	flush()
+
+	return docs, nil
+}
+
+func formatLine(user, message string) string {
+	if user == "" {
+		user = "User"
+	}
+	return user + ": " + strings.TrimSpace(message)
+}
+
+// parseTimestamp accepts epoch milliseconds or common date formats
+func parseTimestamp(value string) int64 {
+	value = strings.TrimSpace(value)
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ms
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05 -0700 MST", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UnixMilli()
+		}
+	}
+	return 0
+}
+
+// chunk splits a thread into documents of at most maxChunkChars
+func chunk(source, team, channel string, private bool, threadID string, createdAt int64, lines []string) []Document {
+	var docs []Document
+	var current strings.Builder
+	emit := func() {
+		if current.Len() == 0 {
+			return
+		}
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%d", source, threadID, len(docs))))
+		docs = append(docs, Document{
+			ID:        hex.EncodeToString(sum[:8]),
+			Source:    source,
+			Team:      team,
+			Channel:   channel,
+			Private:   private,
+			ThreadID:  threadID,
+			Text:      current.String(),
+			CreatedAt: createdAt,
+		})
+		current.Reset()
+	}
+
+	for _, line := range lines {
+		if len(line) > maxChunkChars {
+			line = strings.ToValidUTF8(line[:maxChunkChars], "")
+		}
+		if current.Len()+len(line)+1 > maxChunkChars {
+			emit()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n")
+		}
+		current.WriteString(line)
+	}
+	emit()
+	return docs
+}
@@ -0,0 +1,266 @@
+// Package knowledge imports exported chat history as thread documents and
+// searches them with a BM25 index persisted as JSON. Each document records
+// its team and whether its channel is private, and Search only returns
+// documents visible in the asking Scope.
+package knowledge
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Document is a searchable chunk of historical conversation, usually one thread
+type Document struct {
+	ID        string `json:"id"`
+	Source    string `json:"source"`
+	Team      string `json:"team,omitempty"`
+	Channel   string `json:"channel"`
+	Private   bool   `json:"private,omitempty"`
+	ThreadID  string `json:"thread_id,omitempty"`
+	Text      string `json:"text"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Scope is where search results will be shown
+type Scope struct {
+	// Team is the team name, empty for direct messages and requests
+	// outside a team
+	Team string
+	// Channel is the channel name
+	Channel string
+}
+
+// visibleIn reports whether doc may be shown in scope: documents of a team
+// stay in that team, and private channel documents in their channel
+func (doc *Document) visibleIn(scope Scope) bool {
+	if doc.Team != "" && !strings.EqualFold(doc.Team, scope.Team) {
+		return false
+	}
+	if doc.Private {
+		return scope.Channel != "" && strings.EqualFold(doc.Channel, scope.Channel)
+	}
+	return true
+}
+
+// Result is a document matched by a search
+type Result struct {
+	Document
+	Score float64
+}
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Index is a lexical (BM25) search index over documents, persisted as JSON.
+// An empty path keeps the index in memory.
+type Index struct {
+	mu       sync.RWMutex
+	path     string
+	docs     map[string]*Document
+	lengths  map[string]int
+	postings map[string]map[string]int // term -> doc ID -> term frequency
+	totalLen int
+}
+
+// Open loads the index from path, creating it on first save if missing
+func Open(path string) (*Index, error) {
+	idx := &Index{
+		path:     path,
+		docs:     make(map[string]*Document),
+		lengths:  make(map[string]int),
+		postings: make(map[string]map[string]int),
+	}
+
+	if path == "" {
+		return idx, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read knowledge index: %w", err)
+	}
+
+	var docs []Document
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &docs); err != nil {
+			return nil, fmt.Errorf("failed to parse knowledge index: %w", err)
+		}
+	}
+	for i := range docs {
+		idx.addLocked(&docs[i])
+	}
+
+	return idx, nil
+}
+
+// Add indexes documents, replacing any with the same ID. Call Save to persist.
+func (idx *Index) Add(docs ...Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for i := range docs {
+		doc := docs[i]
+		idx.removeLocked(doc.ID)
+		idx.addLocked(&doc)
+	}
+}
+
+func (idx *Index) addLocked(doc *Document) {
+	terms := Tokenize(doc.Text + " " + doc.Channel)
+	idx.docs[doc.ID] = doc
+	idx.lengths[doc.ID] = len(terms)
+	idx.totalLen += len(terms)
+	for _, term := range terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][doc.ID]++
+	}
+}
+
+func (idx *Index) removeLocked(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range Tokenize(doc.Text + " " + doc.Channel) {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLen -= idx.lengths[id]
+	delete(idx.lengths, id)
+	delete(idx.docs, id)
+}
+
+// Len returns the number of indexed documents
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Search returns up to limit documents visible in scope, ranked by BM25
+// relevance to query
+func (idx *Index) Search(query string, limit int, scope Scope) []Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if len(idx.docs) == 0 {
+		return nil
+	}
+
+	avgLen := float64(idx.totalLen) / float64(len(idx.docs))
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(len(idx.docs))-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for id, tf := range postings {
+			norm := float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*(1-bm25B+bm25B*float64(idx.lengths[id])/avgLen))
+			scores[id] += idf * norm
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		if doc := idx.docs[id]; doc.visibleIn(scope) {
+			results = append(results, Result{Document: *doc, Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Save writes the index atomically
+func (idx *Index) Save() error {
+	if idx.path == "" {
+		return nil
+	}
+
+	idx.mu.RLock()
+	docs := make([]*Document, 0, len(idx.docs))
+	for _, doc := range idx.docs {
+		docs = append(docs, doc)
+	}
+	idx.mu.RUnlock()
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+
+	raw, err := json.Marshal(docs)
+	if err != nil {
+		return fmt.Errorf("failed to encode knowledge index: %w", err)
+	}
+
+	if dir := filepath.Dir(idx.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create knowledge directory: %w", err)
+		}
+	}
+
+	tmp := idx.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write knowledge index: %w", err)
+	}
+	if err := os.Rename(tmp, idx.path); err != nil {
+		return fmt.Errorf("failed to replace knowledge index: %w", err)
+	}
+	return nil
+}
+
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true,
+	"you": true, "all": true, "any": true, "can": true, "had": true, "her": true,
+	"was": true, "one": true, "our": true, "out": true, "has": true, "have": true,
+	"this": true, "that": true, "with": true, "from": true, "they": true, "will": true,
+	"what": true, "when": true, "where": true, "which": true, "who": true, "how": true,
+	"its": true, "it's": true, "is": true, "in": true, "on": true, "of": true,
+	"to": true, "a": true, "an": true, "be": true, "we": true, "it": true, "at": true,
+	"or": true, "as": true, "by": true, "do": true, "if": true, "so": true, "me": true,
+	"my": true, "he": true, "she": true, "them": true, "there": true, "their": true,
+}
+
+// Tokenize lowercases text and splits it into indexable terms
+func Tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+
+	terms := fields[:0]
+	for _, field := range fields {
+		if len(field) < 2 || stopWords[field] {
+			continue
+		}
+		terms = append(terms, field)
+	}
+	return terms
+}
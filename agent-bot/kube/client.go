@@ -0,0 +1,352 @@
+// Package kube is a read-only Kubernetes client for the bot's pod, event
+// and rollout tools. It is not called kubernetes so it doesn't clash with
+// client-go, and every call is checked against the allowlisted namespaces.
+package kube
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// maxEvents bounds the events returned by one call
+const maxEvents = 50
+
+// Client reads pod, event and deployment status in allowlisted namespaces.
+// It only ever lists and gets, so a read-only role is enough.
+type Client struct {
+	clientset  kubernetes.Interface
+	namespaces []string
+}
+
+type PodsArgs struct {
+	Namespace     string `json:"namespace,omitempty" jsonschema_description:"Namespace to list (optional - omit for every allowed namespace)"`
+	LabelSelector string `json:"label_selector,omitempty" jsonschema_description:"Label selector such as app=checkout (optional)"`
+	ProblemsOnly  bool   `json:"problems_only,omitempty" jsonschema_description:"Only return pods that are not running and ready, are crashlooping or have restarted (optional)"`
+}
+
+type EventsArgs struct {
+	Namespace string `json:"namespace,omitempty" jsonschema_description:"Namespace to list (optional - omit for every allowed namespace)"`
+	Object    string `json:"object,omitempty" jsonschema_description:"Only events about objects with this name, e.g. a pod or deployment (optional)"`
+	All       bool   `json:"all,omitempty" jsonschema_description:"Include Normal events, not just warnings (optional)"`
+}
+
+type RolloutsArgs struct {
+	Namespace  string `json:"namespace,omitempty" jsonschema_description:"Namespace to list (optional - omit for every allowed namespace)"`
+	Deployment string `json:"deployment,omitempty" jsonschema_description:"Deployment name (optional - omit for all deployments)"`
+}
+
+// Pod is the status of one pod
+type Pod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Phase     string `json:"phase"`
+	// Ready is ready/total containers, e.g. 1/2
+	Ready    string `json:"ready"`
+	Restarts int32  `json:"restarts"`
+	// Reason explains why a container is not running, e.g. CrashLoopBackOff,
+	// or why it last terminated, e.g. OOMKilled
+	Reason string `json:"reason,omitempty"`
+	Node   string `json:"node,omitempty"`
+	Age    string `json:"age"`
+}
+
+// Event is a Kubernetes event
+type Event struct {
+	Namespace string `json:"namespace"`
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Object    string `json:"object"`
+	Message   string `json:"message"`
+	Count     int32  `json:"count,omitempty"`
+	LastSeen  string `json:"last_seen"`
+}
+
+// Rollout is the rollout state of a deployment
+type Rollout struct {
+	Namespace   string   `json:"namespace"`
+	Name        string   `json:"name"`
+	Desired     int32    `json:"desired"`
+	Updated     int32    `json:"updated"`
+	Ready       int32    `json:"ready"`
+	Available   int32    `json:"available"`
+	Unavailable int32    `json:"unavailable,omitempty"`
+	Complete    bool     `json:"complete"`
+	Paused      bool     `json:"paused,omitempty"`
+	Message     string   `json:"message,omitempty"`
+	Images      []string `json:"images"`
+}
+
+// NewClient connects with kubeconfig, or with the pod's service account when
+// kubeconfig is empty, limited to namespaces
+func NewClient(kubeconfig string, namespaces []string) (*Client, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Kubernetes config: %w", err)
+	}
+	config.Timeout = 30 * time.Second
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return NewClientFromClientset(clientset, namespaces), nil
+}
+
+// NewClientFromClientset wraps an existing clientset
+func NewClientFromClientset(clientset kubernetes.Interface, namespaces []string) *Client {
+	return &Client{clientset: clientset, namespaces: namespaces}
+}
+
+// Namespaces returns the allowlisted namespaces
+func (c *Client) Namespaces() []string {
+	return c.namespaces
+}
+
+// scope returns the namespaces a request covers: the one asked for if it is
+// allowed, or all of them
+func (c *Client) scope(namespace string) ([]string, error) {
+	if namespace == "" {
+		return c.namespaces, nil
+	}
+	if !slices.Contains(c.namespaces, namespace) {
+		return nil, fmt.Errorf("namespace %q is not allowed (allowed: %s)", namespace, strings.Join(c.namespaces, ", "))
+	}
+	return []string{namespace}, nil
+}
+
+// ListPods returns pod status, only problem pods when problemsOnly is set
+func (c *Client) ListPods(ctx context.Context, namespace, labelSelector string, problemsOnly bool) ([]Pod, error) {
+	namespaces, err := c.scope(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := []Pod{}
+	for _, ns := range namespaces {
+		list, err := c.clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods in %s: %w", ns, err)
+		}
+		for i := range list.Items {
+			pod, healthy := podStatus(&list.Items[i])
+			if problemsOnly && healthy {
+				continue
+			}
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// podStatus summarizes a pod and reports whether it looks healthy: running
+// (or completed) with every container ready and no restarts
+func podStatus(pod *corev1.Pod) (Pod, bool) {
+	status := Pod{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Phase:     string(pod.Status.Phase),
+		Node:      pod.Spec.NodeName,
+		Age:       age(pod.CreationTimestamp.Time),
+	}
+
+	var ready int
+	for _, container := range pod.Status.ContainerStatuses {
+		if container.Ready {
+			ready++
+		}
+		status.Restarts += container.RestartCount
+		switch {
+		case container.State.Waiting != nil && container.State.Waiting.Reason != "":
+			status.Reason = container.State.Waiting.Reason
+		case container.State.Terminated != nil && container.State.Terminated.Reason != "":
+			status.Reason = container.State.Terminated.Reason
+		case status.Reason == "" && container.LastTerminationState.Terminated != nil:
+			status.Reason = "last terminated: " + container.LastTerminationState.Terminated.Reason
+		}
+	}
+	if status.Reason == "" && pod.Status.Reason != "" {
+		status.Reason = pod.Status.Reason
+	}
+	if pod.DeletionTimestamp != nil {
+		status.Reason = "Terminating"
+	}
+	status.Ready = fmt.Sprintf("%d/%d", ready, len(pod.Status.ContainerStatuses))
+
+	healthy := pod.Status.Phase == corev1.PodSucceeded ||
+		(pod.Status.Phase == corev1.PodRunning && ready == len(pod.Status.ContainerStatuses) && status.Restarts == 0)
+	return status, healthy
+}
+
+// ListEvents returns recent events, newest first, only warnings unless all
+// is set
+func (c *Client) ListEvents(ctx context.Context, namespace, object string, all bool) ([]Event, error) {
+	namespaces, err := c.scope(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	type timedEvent struct {
+		Event
+		at time.Time
+	}
+	var timed []timedEvent
+	for _, ns := range namespaces {
+		options := metav1.ListOptions{}
+		if object != "" {
+			options.FieldSelector = "involvedObject.name=" + object
+		}
+		list, err := c.clientset.CoreV1().Events(ns).List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list events in %s: %w", ns, err)
+		}
+		for _, event := range list.Items {
+			if !all && event.Type != corev1.EventTypeWarning {
+				continue
+			}
+			at := lastSeen(&event)
+			timed = append(timed, timedEvent{
+				Event: Event{
+					Namespace: event.Namespace,
+					Type:      event.Type,
+					Reason:    event.Reason,
+					Object:    strings.ToLower(event.InvolvedObject.Kind) + "/" + event.InvolvedObject.Name,
+					Message:   event.Message,
+					Count:     event.Count,
+					LastSeen:  age(at) + " ago",
+				},
+				at: at,
+			})
+		}
+	}
+
+	sort.SliceStable(timed, func(i, j int) bool { return timed[i].at.After(timed[j].at) })
+	events := []Event{}
+	for i, event := range timed {
+		if i == maxEvents {
+			break
+		}
+		events = append(events, event.Event)
+	}
+	return events, nil
+}
+
+func lastSeen(event *corev1.Event) time.Time {
+	switch {
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// ListRollouts returns the rollout state of deployments
+func (c *Client) ListRollouts(ctx context.Context, namespace, deployment string) ([]Rollout, error) {
+	namespaces, err := c.scope(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := []Rollout{}
+	for _, ns := range namespaces {
+		options := metav1.ListOptions{}
+		if deployment != "" {
+			options.FieldSelector = "metadata.name=" + deployment
+		}
+		list, err := c.clientset.AppsV1().Deployments(ns).List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deployments in %s: %w", ns, err)
+		}
+		for i := range list.Items {
+			rollouts = append(rollouts, rolloutStatus(&list.Items[i]))
+		}
+	}
+	if deployment != "" && len(rollouts) == 0 {
+		return nil, fmt.Errorf("no deployment named %q", deployment)
+	}
+	return rollouts, nil
+}
+
+// rolloutStatus mirrors kubectl rollout status: a rollout is complete once
+// the controller has seen the latest spec and every replica is updated and
+// available
+func rolloutStatus(d *appsv1.Deployment) Rollout {
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	rollout := Rollout{
+		Namespace:   d.Namespace,
+		Name:        d.Name,
+		Desired:     desired,
+		Updated:     d.Status.UpdatedReplicas,
+		Ready:       d.Status.ReadyReplicas,
+		Available:   d.Status.AvailableReplicas,
+		Unavailable: d.Status.UnavailableReplicas,
+		Paused:      d.Spec.Paused,
+		Images:      []string{},
+	}
+	for _, container := range d.Spec.Template.Spec.Containers {
+		rollout.Images = append(rollout.Images, container.Image)
+	}
+
+	rollout.Complete = d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas == desired &&
+		d.Status.Replicas == desired &&
+		d.Status.AvailableReplicas == desired
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && (condition.Status != corev1.ConditionTrue || !rollout.Complete) {
+			rollout.Message = condition.Message
+		}
+		if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue {
+			rollout.Message = condition.Message
+		}
+	}
+	return rollout
+}
+
+// Ping checks the credentials can list pods in every allowed namespace
+func (c *Client) Ping(ctx context.Context) error {
+	for _, ns := range c.namespaces {
+		if _, err := c.clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{Limit: 1}); err != nil {
+			return fmt.Errorf("cannot list pods in %s: %w", ns, err)
+		}
+	}
+	return nil
+}
+
+// age formats how long ago t was, like kubectl: 45s, 12m, 5h, 3d
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
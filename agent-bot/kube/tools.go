@@ -0,0 +1,52 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"agent-bot/tools"
+)
+
+// Tools returns the read-only Kubernetes tools
+func (c *Client) Tools() []tools.Tool {
+	allowed := strings.Join(c.namespaces, ", ")
+	return []tools.Tool{
+		{
+			Name:        "list_kubernetes_pods",
+			Description: "List pod status (phase, ready containers, restarts, reasons such as CrashLoopBackOff or OOMKilled) in Kubernetes. Allowed namespaces: " + allowed,
+			Schema:      tools.SchemaFor[PodsArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input PodsArgs) (interface{}, error) {
+				pods, err := c.ListPods(ctx, input.Namespace, input.LabelSelector, input.ProblemsOnly)
+				if err != nil {
+					return nil, fmt.Errorf("error listing pods: %w", err)
+				}
+				return pods, nil
+			}),
+		},
+		{
+			Name:        "list_kubernetes_events",
+			Description: "List recent Kubernetes events, newest first (warnings only unless all is set). Allowed namespaces: " + allowed,
+			Schema:      tools.SchemaFor[EventsArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input EventsArgs) (interface{}, error) {
+				events, err := c.ListEvents(ctx, input.Namespace, input.Object, input.All)
+				if err != nil {
+					return nil, fmt.Errorf("error listing events: %w", err)
+				}
+				return events, nil
+			}),
+		},
+		{
+			Name:        "get_kubernetes_rollouts",
+			Description: "Get the rollout state of Kubernetes deployments: desired, updated, ready and available replicas, whether the rollout is complete, and images. Allowed namespaces: " + allowed,
+			Schema:      tools.SchemaFor[RolloutsArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input RolloutsArgs) (interface{}, error) {
+				rollouts, err := c.ListRollouts(ctx, input.Namespace, input.Deployment)
+				if err != nil {
+					return nil, fmt.Errorf("error getting rollouts: %w", err)
+				}
+				return rollouts, nil
+			}),
+		},
+	}
+}
@@ -0,0 +1,231 @@
+// Package language guesses which language a chat message is written in, so
+// the bot can answer in the same language, and normalizes the language
+// names and codes people type.
+package language
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// minWords is the fewest words a Latin-script message needs before its
+// language is guessed; "ok thanks" could be anything
+const minWords = 3
+
+// stopwords are common short words of the Latin-script languages Detect
+// tells apart
+var stopwords = map[string][]string{
+	"English":    {"the", "and", "is", "are", "you", "to", "of", "it", "that", "this", "what", "how", "can", "for", "with", "do", "does", "i", "we", "my", "not", "be", "have", "please", "thanks", "on", "why", "when", "there"},
+	"Spanish":    {"el", "la", "los", "las", "de", "que", "y", "es", "en", "un", "una", "por", "para", "con", "no", "se", "lo", "como", "qué", "cómo", "está", "pero", "mi", "gracias", "hola", "del", "al", "puedes", "puedo", "estoy"},
+	"French":     {"le", "la", "les", "de", "des", "et", "est", "un", "une", "que", "qui", "pour", "dans", "pas", "vous", "je", "nous", "il", "ce", "avec", "sur", "au", "du", "merci", "bonjour", "c'est", "quoi", "comment", "peux", "suis"},
+	"German":     {"der", "die", "das", "und", "ist", "ich", "nicht", "ein", "eine", "zu", "mit", "sie", "es", "den", "auf", "für", "von", "wie", "was", "bitte", "danke", "wir", "kannst", "du", "auch", "sind", "hallo", "kann"},
+	"Portuguese": {"o", "a", "os", "as", "de", "que", "e", "é", "em", "um", "uma", "para", "com", "não", "se", "do", "da", "no", "na", "você", "como", "obrigado", "obrigada", "olá", "por", "mas", "está", "posso"},
+	"Italian":    {"il", "lo", "la", "gli", "le", "di", "che", "e", "è", "un", "una", "per", "con", "non", "sono", "come", "cosa", "grazie", "ciao", "del", "della", "questo", "mi", "ti", "anche", "posso", "puoi"},
+	"Dutch":      {"de", "het", "een", "en", "is", "van", "ik", "je", "niet", "dat", "op", "te", "met", "voor", "zijn", "wat", "hoe", "maar", "ook", "bedankt", "alsjeblieft", "kun", "er", "we", "hallo", "kan"},
+}
+
+// letterHints are letters that only some of those languages use
+var letterHints = map[rune]string{
+	'ñ': "Spanish", '¿': "Spanish", '¡': "Spanish",
+	'ß': "German", 'ä': "German", 'ö': "German", 'ü': "German",
+	'ã': "Portuguese", 'õ': "Portuguese",
+	'è': "French", 'ê': "French", 'ë': "French", 'î': "French", 'ï': "French", 'û': "French", 'œ': "French",
+	'ò': "Italian", 'ì': "Italian",
+	'ĳ': "Dutch",
+}
+
+var stopwordLanguages = indexStopwords()
+
+func indexStopwords() map[string][]string {
+	index := make(map[string][]string)
+	for language, words := range stopwords {
+		for _, word := range words {
+			index[word] = append(index[word], language)
+		}
+	}
+	return index
+}
+
+// noise is text that says nothing about the language: code, links,
+// mentions and emoji names
+var noise = regexp.MustCompile("(?s)```.*?```|`[^`]*`|https?://\\S+|[@~][\\w.\\-]+|:[a-z0-9_+\\-]+:")
+
+// Detect returns the English name of the language text is written in, or ""
+// when it is too short or too mixed to tell
+func Detect(text string) string {
+	text = noise.ReplaceAllString(text, " ")
+
+	var latin, kana, han, total int
+	scripts := make(map[string]int)
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["Korean"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts[cyrillic(r)]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["Greek"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts[arabic(r)]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["Hebrew"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["Thai"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["Hindi"]++
+		default:
+			continue
+		}
+		total++
+	}
+	if total == 0 {
+		return ""
+	}
+
+	// Japanese mixes kana with kanji; kanji alone is Chinese
+	switch {
+	case kana > 0 && (kana+han)*2 > total:
+		return "Japanese"
+	case han*2 > total:
+		return "Chinese"
+	}
+	if latin*2 > total {
+		return detectLatin(text)
+	}
+	return nonLatin(scripts)
+}
+
+// cyrillic tells Ukrainian from Russian by the letters only Ukrainian uses
+func cyrillic(r rune) string {
+	switch unicode.ToLower(r) {
+	case 'і', 'ї', 'є', 'ґ':
+		return "Ukrainian"
+	}
+	return "Russian"
+}
+
+// arabic tells Persian from Arabic by the letters only Persian uses
+func arabic(r rune) string {
+	switch r {
+	case 'پ', 'چ', 'ژ', 'گ', 'ک', 'ی':
+		return "Persian"
+	}
+	return "Arabic"
+}
+
+// nonLatin picks the most used script. A Ukrainian or Persian letter
+// anywhere decides between the languages sharing a script.
+func nonLatin(scripts map[string]int) string {
+	if scripts["Ukrainian"] > 0 {
+		scripts["Ukrainian"] += scripts["Russian"]
+		delete(scripts, "Russian")
+	}
+	if scripts["Persian"] > 0 {
+		scripts["Persian"] += scripts["Arabic"]
+		delete(scripts, "Arabic")
+	}
+	best, count := "", 0
+	for language, n := range scripts {
+		if n > count || n == count && language < best {
+			best, count = language, n
+		}
+	}
+	return best
+}
+
+// detectLatin scores Latin-script text by stopwords and telltale letters,
+// and only answers when one language clearly wins
+func detectLatin(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	if len(words) < minWords {
+		return ""
+	}
+
+	scores := make(map[string]int)
+	for _, word := range words {
+		for _, language := range stopwordLanguages[strings.Trim(word, "'")] {
+			scores[language] += 2
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		if language, ok := letterHints[r]; ok {
+			scores[language]++
+		}
+	}
+
+	best, second := "", 0
+	for language, score := range scores {
+		switch {
+		case score > scores[best] || score == scores[best] && language < best:
+			if best != "" {
+				second = max(second, scores[best])
+			}
+			best = language
+		case score > second:
+			second = score
+		}
+	}
+	// At least two stopwords, and a third more evidence than the runner-up
+	if scores[best] < 4 || scores[best]*3 < second*4 {
+		return ""
+	}
+	return best
+}
+
+// names maps ISO 639-1 codes and native names to English names
+var names = map[string]string{
+	"en": "English", "es": "Spanish", "español": "Spanish", "espanol": "Spanish",
+	"fr": "French", "français": "French", "francais": "French",
+	"de": "German", "deutsch": "German",
+	"pt": "Portuguese", "português": "Portuguese", "portugues": "Portuguese",
+	"it": "Italian", "italiano": "Italian",
+	"nl": "Dutch", "nederlands": "Dutch",
+	"ru": "Russian", "русский": "Russian",
+	"uk": "Ukrainian", "українська": "Ukrainian",
+	"pl": "Polish", "polski": "Polish",
+	"sv": "Swedish", "svenska": "Swedish",
+	"tr": "Turkish", "türkçe": "Turkish",
+	"ja": "Japanese", "日本語": "Japanese",
+	"zh": "Chinese", "中文": "Chinese",
+	"ko": "Korean", "한국어": "Korean",
+	"ar": "Arabic", "العربية": "Arabic",
+	"fa": "Persian", "فارسی": "Persian",
+	"he": "Hebrew", "עברית": "Hebrew",
+	"el": "Greek", "ελληνικά": "Greek",
+	"hi": "Hindi", "हिन्दी": "Hindi",
+	"th": "Thai", "ไทย": "Thai",
+}
+
+// validName accepts language names such as "Brazilian Portuguese" or
+// "Swiss German", not whole sentences
+var validName = regexp.MustCompile(`^[\p{L}][\p{L} ()\-]{0,39}$`)
+
+// Name returns the English name of a language given by name or ISO 639-1
+// code, e.g. "es" and "Español" both give "Spanish". Other names are returned
+// capitalized, since the model understands them; "" means value isn't a
+// language name at all.
+func Name(value string) string {
+	value = strings.TrimSpace(value)
+	if name, ok := names[strings.ToLower(value)]; ok {
+		return name
+	}
+	if !validName.MatchString(value) {
+		return ""
+	}
+	words := strings.Fields(value)
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/language"
+	"agent-bot/store"
+	"agent-bot/types"
+)
+
+// channelLanguagesBucket stores default reply languages set with !language,
+// which replace DEFAULT_LANGUAGE in that channel
+const channelLanguagesBucket = "channel_languages"
+
+// channelLanguages resolves the language replies fall back to in a channel
+// when a message's own language can't be detected
+type channelLanguages struct {
+	defaultLanguage string
+	store           *store.Store
+}
+
+func newChannelLanguages(config Config, stateStore *store.Store) *channelLanguages {
+	return &channelLanguages{defaultLanguage: config.DefaultLanguage, store: stateStore}
+}
+
+// For returns the default reply language of channelID, empty for none
+func (c *channelLanguages) For(channelID string) string {
+	if name, ok := c.override(channelID); ok {
+		return name
+	}
+	return c.defaultLanguage
+}
+
+// override returns the language set with !language for a channel
+func (c *channelLanguages) override(channelID string) (string, bool) {
+	if c.store == nil {
+		return "", false
+	}
+	var name string
+	found, err := c.store.Get(channelLanguagesBucket, channelID, &name)
+	return name, found && err == nil
+}
+
+// withLanguage tells the model which language to reply in: the message's
+// own when it can be detected, otherwise the channel's default
+func (a *BotAgent) withLanguage(message types.PostedMessage, prompt string) string {
+	if a.languages == nil || !a.features.Enabled(FeatureLanguageDetection) {
+		return prompt
+	}
+	if detected := language.Detect(message.Message); detected != "" {
+		log.Printf("[%s] LANGUAGE: Message in channel %s is in %s", time.Now().Format("2006-01-02 15:04:05"), message.ChannelId, detected)
+		return prompt + fmt.Sprintf("\n\nThe message you are answering is written in %s. Reply in %s unless the user asks for another language.", detected, detected)
+	}
+	if fallback := a.languages.For(message.ChannelId); fallback != "" {
+		return prompt + fmt.Sprintf("\n\nReply in %s unless the user writes in or asks for another language.", fallback)
+	}
+	return prompt
+}
+
+// handleLanguageCommand implements "!language"
+func (b *Bot) handleLanguageCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!language [channel_id|here]` to show a channel's default reply language, `!language <channel_id|here> <language>` to change it, `!language <channel_id|here> default`, `!language list`"
+	if len(args) == 0 {
+		args = []string{"here"}
+	}
+
+	if strings.ToLower(args[0]) == "list" {
+		return b.listChannelLanguages()
+	}
+
+	channelID := args[0]
+	if channelID == "here" {
+		channelID = message.ChannelId
+	}
+	if len(args) == 1 {
+		return fmt.Sprintf("Default reply language for `%s`: %s", channelID, languageSummary(b.languages.For(channelID)))
+	}
+
+	value := strings.Join(args[1:], " ")
+	if strings.EqualFold(value, "default") {
+		if err := b.store.Delete(channelLanguagesBucket, channelID); err != nil {
+			return fmt.Sprintf("Failed to reset language: %v", err)
+		}
+		return fmt.Sprintf("Default reply language for `%s` reset to %s.", channelID, languageSummary(b.languages.For(channelID)))
+	}
+
+	name := language.Name(value)
+	if name == "" {
+		return usage
+	}
+	if err := b.store.Put(channelLanguagesBucket, channelID, name); err != nil {
+		return fmt.Sprintf("Failed to save language: %v", err)
+	}
+	return fmt.Sprintf("Replies in `%s` now default to %s when a message's language can't be detected.", channelID, name)
+}
+
+func (b *Bot) listChannelLanguages() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Default reply languages**\n- Default: %s\n", languageSummary(b.languages.defaultLanguage)))
+	for _, channelID := range b.store.Keys(channelLanguagesBucket) {
+		name, _ := b.languages.override(channelID)
+		sb.WriteString(fmt.Sprintf("- `%s`: %s\n", channelID, name))
+	}
+	return sb.String()
+}
+
+func languageSummary(name string) string {
+	if name == "" {
+		return "none (follows the conversation)"
+	}
+	return name
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"agent-bot/distributed"
+	"agent-bot/redis"
+)
+
+// runForLeader keeps the bots on standby until this replica is elected
+// leader, then starts them. A leader that loses the lease exits, so its
+// supervisor restarts it as a follower instead of two replicas answering;
+// one that is stopped hands the lease over right away.
+func runForLeader(config Config, bots []*Bot, start func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	client, err := redis.Open(ctx, config.RedisURL)
+	cancel()
+	if err != nil {
+		log.Fatalf("[%s] FATAL: Failed to connect to Redis for leader election: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+	id := replicaID()
+	election := distributed.NewElection(client, config.RedisPrefix+"leader", id)
+
+	for _, bot := range bots {
+		bot.standby.Store(true)
+	}
+	if leader, err := election.Leader(context.Background()); err == nil && leader != "" && leader != id {
+		log.Printf("[%s] LEADER: %s is the leader, standing by", time.Now().Format("2006-01-02 15:04:05"), leader)
+	}
+	lost, err := election.Elect(context.Background())
+	if err != nil {
+		log.Fatalf("[%s] FATAL: Leader election failed: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+	log.Printf("[%s] LEADER: Elected as %s, connecting", time.Now().Format("2006-01-02 15:04:05"), id)
+	for _, bot := range bots {
+		bot.standby.Store(false)
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		select {
+		case <-lost:
+			log.Fatalf("[%s] FATAL: Lost the leader lease, exiting so only the new leader answers", time.Now().Format("2006-01-02 15:04:05"))
+		case sig := <-signals:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := election.Resign(ctx); err != nil {
+				log.Printf("[%s] LEADER: Failed to hand the lease over: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+			}
+			log.Printf("[%s] LEADER: Stepping down on %v", time.Now().Format("2006-01-02 15:04:05"), sig)
+			os.Exit(0)
+		}
+	}()
+
+	start()
+}
@@ -1,20 +1,31 @@
+// Package llms talks to the Anthropic API. AnthropicBackend runs the tool
+// loop, streams replies and reports tokens, tool calls, sources and
+// progress to collectors carried in the request context. Transient API
+// errors are retried inside a CircuitBreaker, so one exhausted request
+// counts as one failure.
 package llms
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
-	"github.com/invopop/jsonschema"
+	"go.opentelemetry.io/otel/attribute"
 
-	"agent-bot/asana"
+	"agent-bot/errorsink"
+	"agent-bot/metrics"
+	"agent-bot/reqid"
+	"agent-bot/tools"
+	"agent-bot/tracing"
 	"agent-bot/types"
 )
 
@@ -24,6 +35,11 @@ type LLMBackend interface {
 	PromptStream(ctx context.Context, text string) (<-chan types.StreamChunk, error)
 }
 
+// Pinger is a backend that can check it is reachable without prompting
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
 // AnthropicBackend implements LLMBackend using Anthropic's Claude
 type AnthropicBackend struct {
 	client       *anthropic.Client
@@ -31,47 +47,172 @@ type AnthropicBackend struct {
 	maxTokens    int
 	maxWebSearch int
 	enableTools  bool
-	asanaClient  *asana.Client
+	registry     *tools.Registry
+	usage        UsageRecorder
+	selector     *tools.Selector
+
+	// toolParallelism bounds concurrent tool calls within one model turn
+	toolParallelism int
+	auditor         ToolAuditor
+
+	// Per-request limits on the tool-use loop; zero disables a limit
+	maxTurns         int
+	maxRequestTokens int64
+
+	retry RetryPolicy
 }
 
-func NewAnthropicBackend(apiKey, asanaKey, model string, maxTokens, maxWebSearch int, enableTools bool) *AnthropicBackend {
+func NewAnthropicBackend(apiKey, model string, maxTokens, maxWebSearch int, enableTools bool, registry *tools.Registry) *AnthropicBackend {
 	// Set API key as environment variable for the client
 	os.Setenv("ANTHROPIC_API_KEY", apiKey)
-	
+
 	// Initialize client with MCP beta support
 	client := anthropic.NewClient(
 		option.WithHeader("anthropic-beta", "mcp-client-2025-04-04"),
+		// Retries are handled by withRetry, which honours RetryPolicy
+		option.WithMaxRetries(0),
 	)
-	
-	// Initialize Asana client with required API key
-	asanaClient := asana.NewClient(asanaKey, &http.Client{})
-	
+
+	if registry == nil {
+		registry = tools.NewRegistry()
+	}
+
 	return &AnthropicBackend{
 		client:       &client,
 		model:        model,
 		maxTokens:    maxTokens,
 		maxWebSearch: maxWebSearch,
 		enableTools:  enableTools,
-		asanaClient:  asanaClient,
+		registry:     registry,
+
+		toolParallelism: 4,
+		maxTurns:        10,
+		retry:           DefaultRetryPolicy,
+	}
+}
+
+// SetUsageRecorder makes the backend report token usage and tool calls
+func (a *AnthropicBackend) SetUsageRecorder(recorder UsageRecorder) {
+	a.usage = recorder
+}
+
+// SetToolSelector limits each request to the registered tools the selector
+// considers relevant
+func (a *AnthropicBackend) SetToolSelector(selector *tools.Selector) {
+	a.selector = selector
+}
+
+// SetToolParallelism sets how many tool calls from one model turn run at once
+func (a *AnthropicBackend) SetToolParallelism(n int) {
+	a.toolParallelism = n
+}
+
+// SetToolLoopLimits caps how many model calls one request may make and how
+// many tokens (input and output, summed over calls) it may use before the
+// backend stops running tools and returns what it has
+func (a *AnthropicBackend) SetToolLoopLimits(maxTurns int, maxRequestTokens int64) {
+	a.maxTurns = maxTurns
+	a.maxRequestTokens = maxRequestTokens
+}
+
+// Ping checks the API key and model by looking the model up, which doesn't
+// use any tokens
+func (a *AnthropicBackend) Ping(ctx context.Context) error {
+	if _, err := a.client.Models.Get(ctx, a.model, anthropic.ModelGetParams{}); err != nil {
+		return fmt.Errorf("model %s: %w", a.model, err)
+	}
+	return nil
+}
+
+// SetRetryPolicy sets how API calls failing with rate limit, overload or
+// server errors are retried
+func (a *AnthropicBackend) SetRetryPolicy(policy RetryPolicy) {
+	a.retry = policy
+}
+
+// SetToolAuditor makes the backend report every tool call for auditing
+func (a *AnthropicBackend) SetToolAuditor(auditor ToolAuditor) {
+	a.auditor = auditor
+}
+
+// selectTools returns the registered tools to offer for a prompt
+func (a *AnthropicBackend) selectTools(ctx context.Context, text string) tools.Selection {
+	registered := a.registry.List()
+	if a.selector == nil {
+		return tools.Selection{Tools: registered}
+	}
+
+	selection, err := a.selector.Select(ctx, text, registered)
+	if err != nil {
+		reqid.Logf(ctx, "LLM: Tool preselection failed, sending all tools: %v", err)
+		metrics.Inc("tool_preselect_errors_total")
+		return tools.Selection{Tools: registered}
+	}
+	if selection.Selected != nil {
+		metrics.Inc("tool_preselect_requests_total")
+		metrics.Add("tool_preselect_tools_offered_total", float64(len(selection.Tools)))
+		metrics.Add("tool_preselect_tools_registered_total", float64(len(registered)))
+	}
+	return selection
+}
+
+// recordToolSelection scores the preselection against the tool the model called
+func recordToolSelection(selection tools.Selection, name string) {
+	rank, ranked := selection.Rank[name]
+	if !ranked {
+		return
+	}
+	switch {
+	case selection.Shadow && selection.Selected[name]:
+		metrics.Inc("tool_preselect_shadow_total", "outcome", "hit")
+	case selection.Shadow:
+		metrics.Inc("tool_preselect_shadow_total", "outcome", "miss")
+		log.Printf("[%s] LLM: Tool preselection would have missed %s (ranked %d)", time.Now().Format("2006-01-02 15:04:05"), name, rank)
+	}
+	metrics.Inc("tool_preselect_calls_total", "rank", rankBucket(rank))
+}
+
+func rankBucket(rank int) string {
+	switch {
+	case rank == 1:
+		return "1"
+	case rank <= 3:
+		return "2-3"
+	case rank <= 5:
+		return "4-5"
+	default:
+		return "6+"
 	}
 }
 
 func (a *AnthropicBackend) Prompt(ctx context.Context, text string) (string, error) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	log.Printf("[%s] LLM: Starting Anthropic API call", timestamp)
-	log.Printf("[%s] LLM: Model: %s", timestamp, a.model)
-	log.Printf("[%s] LLM: Input prompt (%d chars): %s", timestamp, len(text), text)
-	log.Printf("[%s] LLM: Max tokens: %d", timestamp, a.maxTokens)
-	if a.enableTools {
-		log.Printf("[%s] LLM: Web search enabled (max %d searches)", timestamp, a.maxWebSearch)
+	reqid.Logf(ctx, "LLM: Starting Anthropic API call")
+	reqid.Logf(ctx, "LLM: Model: %s", a.model)
+	reqid.Logf(ctx, "LLM: Input prompt (%d chars): %s", len(text), text)
+	reqid.Logf(ctx, "LLM: Max tokens: %d", a.maxTokens)
+	enableTools := a.enableTools && toolsAllowed(ctx)
+	thinking, thinkingEnabled := thinkingFrom(ctx)
+	ctx, span := tracing.Start(ctx, "llm.request",
+		attribute.String("llm.model", a.model),
+		attribute.Bool("llm.tools_enabled", enableTools),
+		attribute.Int("llm.prompt_chars", len(text)),
+		attribute.Int("llm.history_turns", len(HistoryFrom(ctx))),
+		attribute.Int("llm.thinking_budget", thinking.budgetTokens),
+	)
+	if thinkingEnabled {
+		reqid.Logf(ctx, "LLM: Extended thinking enabled (budget %d tokens)", thinking.budgetTokens)
+	}
+	if enableTools {
+		reqid.Logf(ctx, "LLM: Web search enabled (max %d searches)", a.maxWebSearch)
 	} else {
-		log.Printf("[%s] LLM: Tools disabled", timestamp)
+		reqid.Logf(ctx, "LLM: Tools disabled")
 	}
 
 	// Build tools array conditionally
-	var tools []anthropic.BetaToolUnionParam
-	if a.enableTools {
-		tools = []anthropic.BetaToolUnionParam{
+	var toolParams []anthropic.BetaToolUnionParam
+	var selection tools.Selection
+	if enableTools {
+		toolParams = []anthropic.BetaToolUnionParam{
 			{
 				OfWebSearchTool20250305: &anthropic.BetaWebSearchTool20250305Param{
 					MaxUses: anthropic.Int(int64(a.maxWebSearch)), // Configurable max searches per request
@@ -79,56 +220,59 @@ func (a *AnthropicBackend) Prompt(ctx context.Context, text string) (string, err
 			},
 		}
 
-		// Add Asana tools
-		log.Printf("[%s] LLM: Adding Asana tools", timestamp)
-		asanaTools := []anthropic.BetaToolUnionParam{
-			{
-				OfTool: &anthropic.BetaToolParam{
-					Name:        "list_asana_projects",
-					Description: anthropic.String("List projects in an Asana workspace"),
-					InputSchema: ListProjectsBetaInputSchema,
-				},
-			},
-			{
-				OfTool: &anthropic.BetaToolParam{
-					Name:        "list_asana_project_tasks",
-					Description: anthropic.String("List incomplete tasks in an Asana project"),
-					InputSchema: ListProjectTasksBetaInputSchema,
-				},
-			},
-			{
-				OfTool: &anthropic.BetaToolParam{
-					Name:        "list_asana_user_tasks",
-					Description: anthropic.String("List incomplete tasks assigned to a user in Asana"),
-					InputSchema: ListUserTasksBetaInputSchema,
-				},
-			},
-			{
+		// Add registered tools (Asana, local MCP servers, ...), narrowed to
+		// the relevant ones when a selector is configured
+		selection = a.selectTools(ctx, text)
+		if selection.Selected != nil && !selection.Shadow {
+			reqid.Logf(ctx, "LLM: Preselected %d of %d tools", len(selection.Tools), len(selection.Rank))
+		}
+		for _, tool := range selection.Tools {
+			reqid.Logf(ctx, "LLM: Adding tool: %s", tool.Name)
+			toolParams = append(toolParams, anthropic.BetaToolUnionParam{
 				OfTool: &anthropic.BetaToolParam{
-					Name:        "list_asana_users",
-					Description: anthropic.String("List users in an Asana workspace to get their GIDs for other operations"),
-					InputSchema: ListUsersBetaInputSchema,
+					Name:        tool.Name,
+					Description: anthropic.String(tool.Description),
+					InputSchema: anthropic.BetaToolInputSchemaParam{
+						Properties: tool.Schema.Properties,
+						Required:   tool.Schema.Required,
+					},
 				},
-			},
+			})
 		}
-		tools = append(tools, asanaTools...)
 	}
 
-	// Initialize conversation
-	messages := []anthropic.BetaMessageParam{
-		anthropic.NewBetaUserMessage(anthropic.NewBetaTextBlock(text)),
+	// Initialize conversation; attached images go before the text so the
+	// question can refer to them
+	var userContent []anthropic.BetaContentBlockParamUnion
+	for _, image := range imagesFrom(ctx) {
+		userContent = append(userContent, anthropic.NewBetaImageBlock(anthropic.BetaBase64ImageSourceParam{
+			Data:      base64.StdEncoding.EncodeToString(image.Data),
+			MediaType: anthropic.BetaBase64ImageSourceMediaType(image.MediaType),
+		}))
+		reqid.Logf(ctx, "LLM: Attached image %s (%s, %d bytes)", image.Name, image.MediaType, len(image.Data))
+	}
+	userContent = append(userContent, anthropic.NewBetaTextBlock(text))
+
+	// Earlier posts of the thread go before it as turns of their own
+	history := HistoryFrom(ctx)
+	if len(history) > 0 {
+		reqid.Logf(ctx, "LLM: Sending %d earlier conversation turns", len(history))
 	}
+	messages := conversation(history, userContent)
 
 	var finalResult strings.Builder
+	var turns int
+	var requestTokens int64
 
-	// Tool use conversation loop
+	// Tool use conversation loop, bounded by maxTurns and maxRequestTokens
 	for {
+		turns++
 		startTime := time.Now()
-		
+
 		// Configure MCP servers
 		var mcpServers []anthropic.BetaRequestMCPServerURLDefinitionParam
-		if a.enableTools {
-			log.Printf("[%s] LLM: Adding MCP server: hello-world-mcp", timestamp)
+		if enableTools {
+			reqid.Logf(ctx, "LLM: Adding MCP server: hello-world-mcp")
 			mcpServers = []anthropic.BetaRequestMCPServerURLDefinitionParam{
 				{
 					Type: "url",
@@ -140,51 +284,94 @@ func (a *AnthropicBackend) Prompt(ctx context.Context, text string) (string, err
 				},
 			}
 		}
-		
+
 		params := anthropic.BetaMessageNewParams{
-			Model:     anthropic.Model(a.model),
-			MaxTokens: int64(a.maxTokens),
-			Messages:  messages,
+			Model:      anthropic.Model(a.model),
+			MaxTokens:  int64(a.maxTokens),
+			Messages:   messages,
 			MCPServers: mcpServers,
 		}
-		if a.enableTools && len(tools) > 0 {
-			params.Tools = tools
+		if enableTools && len(toolParams) > 0 {
+			params.Tools = toolParams
+		}
+		if system := systemPromptFrom(ctx); system != "" {
+			params.System = []anthropic.BetaTextBlockParam{{Text: system}}
+		}
+		if id := reqid.From(ctx); id != "" {
+			// Lets a request be matched up with its Anthropic console logs
+			params.Metadata = anthropic.BetaMetadataParam{UserID: anthropic.String(id)}
 		}
-		resp, err := a.client.Beta.Messages.New(ctx, params)
-		
+		if thinkingEnabled {
+			// max_tokens covers the thinking as well as the answer
+			params.MaxTokens += int64(thinking.budgetTokens)
+			params.Thinking = anthropic.BetaThinkingConfigParamOfEnabled(int64(thinking.budgetTokens))
+		}
+		callCtx, callSpan := tracing.Start(ctx, "anthropic.messages", attribute.Int("llm.turn", turns))
+		resp, err := withRetry(callCtx, a.retry, a.model, func() (*anthropic.BetaMessage, error) {
+			return a.client.Beta.Messages.New(callCtx, params)
+		})
+		if err == nil {
+			callSpan.SetAttributes(
+				attribute.String("llm.stop_reason", string(resp.StopReason)),
+				attribute.Int64("llm.input_tokens", resp.Usage.InputTokens+resp.Usage.CacheCreationInputTokens+resp.Usage.CacheReadInputTokens),
+				attribute.Int64("llm.output_tokens", resp.Usage.OutputTokens),
+			)
+		}
+		tracing.End(callSpan, err)
+
 		duration := time.Since(startTime)
-		
+
 		if err != nil {
-			log.Printf("[%s] LLM: API call failed after %v: %v", timestamp, duration, err)
+			reqid.Logf(ctx, "LLM: API call failed after %v: %v", duration, err)
+			tracing.End(span, err)
 			return "", fmt.Errorf("anthropic API error: %v", err)
 		}
-		
-		log.Printf("[%s] LLM: API call completed in %v", timestamp, duration)
-		log.Printf("[%s] LLM: Response ID: %s", timestamp, resp.ID)
-		log.Printf("[%s] LLM: Model used: %s", timestamp, resp.Model)
-		log.Printf("[%s] LLM: Stop reason: %s", timestamp, resp.StopReason)
-		log.Printf("[%s] LLM: Usage - Input tokens: %d, Output tokens: %d", timestamp, resp.Usage.InputTokens, resp.Usage.OutputTokens)
-		log.Printf("[%s] LLM: Content blocks received: %d", timestamp, len(resp.Content))
+
+		reqid.Logf(ctx, "LLM: API call completed in %v", duration)
+		reqid.Logf(ctx, "LLM: Response ID: %s", resp.ID)
+		reqid.Logf(ctx, "LLM: Model used: %s", resp.Model)
+		ReplyInfoFrom(ctx).setModel(string(resp.Model))
+		reqid.Logf(ctx, "LLM: Stop reason: %s", resp.StopReason)
+		reqid.Logf(ctx, "LLM: Usage - Input tokens: %d, Output tokens: %d", resp.Usage.InputTokens, resp.Usage.OutputTokens)
+		reqid.Logf(ctx, "LLM: Content blocks received: %d", len(resp.Content))
+
+		// Cache writes and reads are billed as input, at different rates; count them all as input
+		inputTokens := resp.Usage.InputTokens + resp.Usage.CacheCreationInputTokens + resp.Usage.CacheReadInputTokens
+		requestTokens += inputTokens + resp.Usage.OutputTokens
+		if a.usage != nil {
+			a.usage.RecordLLM(ctx, a.model, inputTokens, resp.Usage.OutputTokens)
+			if searches := resp.Usage.ServerToolUse.WebSearchRequests; searches > 0 {
+				a.usage.RecordTool(ctx, a.model, "web_search", searches)
+			}
+		}
 
 		// Process response blocks
 		for i, block := range resp.Content {
-			log.Printf("[%s] LLM: Processing content block %d", timestamp, i)
-			
+			reqid.Logf(ctx, "LLM: Processing content block %d", i)
+
 			switch content := block.AsAny().(type) {
 			case anthropic.BetaTextBlock:
-				text := content.Text
-				log.Printf("[%s] LLM: Extracted text from block %d (%d chars): %s", timestamp, i, len(text), text)
+				text := content.Text + citationMarkers(ctx, content.Citations)
+				reqid.Logf(ctx, "LLM: Extracted text from block %d (%d chars): %s", i, len(text), text)
 				finalResult.WriteString(text)
+			case anthropic.BetaThinkingBlock:
+				reqid.Logf(ctx, "LLM: Thinking block %d (%d chars)", i, len(content.Thinking))
+				thinking.reasoning.add(content.Thinking)
 			case anthropic.BetaToolUseBlock:
-				log.Printf("[%s] LLM: Tool use block %d: %s", timestamp, i, content.Name)
+				reqid.Logf(ctx, "LLM: Tool use block %d: %s", i, content.Name)
 				inputJSON, _ := json.Marshal(content.Input)
-				log.Printf("[%s] LLM: Tool input: %s", timestamp, string(inputJSON))
+				reqid.Logf(ctx, "LLM: Tool input: %s", string(inputJSON))
 			case anthropic.BetaMCPToolUseBlock:
-				log.Printf("[%s] LLM: MCP tool use block %d: %s from server %s", timestamp, i, content.Name, content.ServerName)
+				reqid.Logf(ctx, "LLM: MCP tool use block %d: %s from server %s", i, content.Name, content.ServerName)
 				inputJSON, _ := json.Marshal(content.Input)
-				log.Printf("[%s] LLM: MCP tool input: %s", timestamp, string(inputJSON))
+				reqid.Logf(ctx, "LLM: MCP tool input: %s", string(inputJSON))
+				reportToolUse(ctx, content.Name)
+			case anthropic.BetaServerToolUseBlock:
+				// Web search runs on the API side, so this arrives once it is done
+				reqid.Logf(ctx, "LLM: Server tool use block %d: %s", i, content.Name)
+				reportToolUse(ctx, string(content.Name))
 			default:
-				log.Printf("[%s] LLM: Block %d is not a text or tool use block, type: %T", timestamp, i, content)
+				reqid.Logf(ctx, "LLM: Block %d is not a text or tool use block, type: %T", i, content)
 			}
 		}
 
@@ -192,97 +379,45 @@ func (a *AnthropicBackend) Prompt(ctx context.Context, text string) (string, err
 		messages = append(messages, resp.ToParam())
 
 		// Handle tool use
-		toolResults := []anthropic.BetaContentBlockParamUnion{}
-		
+		var toolCalls []anthropic.BetaToolUseBlock
+
 		for _, block := range resp.Content {
 			switch content := block.AsAny().(type) {
 			case anthropic.BetaToolUseBlock:
-				log.Printf("[%s] LLM: Executing tool: %s", timestamp, content.Name)
-				
-				var response interface{}
-				var err error
-				
-				switch content.Name {
-				case "list_asana_projects":
-					var input asana.ListProjectsArgs
-					inputBytes, _ := json.Marshal(content.Input)
-					if err := json.Unmarshal(inputBytes, &input); err == nil {
-						projects, err := a.asanaClient.ListProjects(input.WorkspaceGID)
-						if err != nil {
-							response = fmt.Sprintf("Error listing projects: %v", err)
-						} else {
-							response = projects
-						}
-					} else {
-						response = fmt.Sprintf("Invalid input: %v", err)
-					}
-					
-				case "list_asana_project_tasks":
-					var input asana.ListProjectTasksArgs
-					inputBytes, _ := json.Marshal(content.Input)
-					if err := json.Unmarshal(inputBytes, &input); err == nil {
-						tasks, err := a.asanaClient.ListProjectTasks(input.ProjectGID)
-						if err != nil {
-							response = fmt.Sprintf("Error listing project tasks: %v", err)
-						} else {
-							response = tasks
-						}
-					} else {
-						response = fmt.Sprintf("Invalid input: %v", err)
-					}
-					
-				case "list_asana_user_tasks":
-					var input asana.ListUserTasksArgs
-					inputBytes, _ := json.Marshal(content.Input)
-					if err := json.Unmarshal(inputBytes, &input); err == nil {
-						tasks, err := a.asanaClient.ListUserTasks(input.AssigneeGID, input.WorkspaceGID)
-						if err != nil {
-							response = fmt.Sprintf("Error listing user tasks: %v", err)
-						} else {
-							response = tasks
-						}
-					} else {
-						response = fmt.Sprintf("Invalid input: %v", err)
-					}
-					
-				case "list_asana_users":
-					var input asana.ListUsersArgs
-					inputBytes, _ := json.Marshal(content.Input)
-					if err := json.Unmarshal(inputBytes, &input); err == nil {
-						users, err := a.asanaClient.ListUsers(input.WorkspaceGID)
-						if err != nil {
-							response = fmt.Sprintf("Error listing users: %v", err)
-						} else {
-							response = users
-						}
-					} else {
-						response = fmt.Sprintf("Invalid input: %v", err)
-					}
-				}
-				
-				// Convert response to JSON and add as tool result
-				b, err := json.Marshal(response)
-				if err != nil {
-					b = []byte(fmt.Sprintf("Error marshalling response: %v", err))
+				if a.usage != nil {
+					a.usage.RecordTool(ctx, a.model, content.Name, 1)
 				}
-				
-				log.Printf("[%s] LLM: Tool result: %s", timestamp, string(b))
-				toolResults = append(toolResults, anthropic.NewBetaToolResultBlock(content.ID, string(b), false))
+				recordToolSelection(selection, content.Name)
+				toolCalls = append(toolCalls, content)
 			case anthropic.BetaMCPToolUseBlock:
-				log.Printf("[%s] LLM: Executing MCP tool: %s from server: %s", timestamp, content.Name, content.ServerName)
-				
+				reqid.Logf(ctx, "LLM: Executing MCP tool: %s from server: %s", content.Name, content.ServerName)
+
 				// For MCP tools, the tool execution is handled by the Anthropic API
 				// We just need to add the MCP tool result block
-				log.Printf("[%s] LLM: MCP tool will be executed automatically by API", timestamp)
+				reqid.Logf(ctx, "LLM: MCP tool will be executed automatically by API")
 				// No explicit handling needed for MCP tools - they're executed by the API
 			}
 		}
-		
+
+		if len(toolCalls) > 0 {
+			if reason := a.toolBudgetExhausted(turns, requestTokens); reason != "" {
+				metrics.Inc("llm_tool_budget_exhausted_total", "reason", reason)
+				reqid.Logf(ctx, "LLM: Tool budget exhausted (%s) after %d turns and %d tokens; skipping %d tool calls", reason, turns, requestTokens, len(toolCalls))
+				if finalResult.Len() > 0 {
+					finalResult.WriteString("\n\n")
+				}
+				finalResult.WriteString(fmt.Sprintf("_I hit my tool budget for this request (%d steps, about %d tokens) before finishing. Ask me to continue, or narrow the question._", turns, requestTokens))
+				break
+			}
+		}
+
+		toolResults := a.executeTools(ctx, toolCalls)
+
 		// If no tool results, break the loop
 		if len(toolResults) == 0 {
 			break
 		}
-		
+
 		// Add tool results to conversation and continue
 		messages = append(messages, anthropic.NewBetaUserMessage(toolResults...))
 	}
@@ -291,27 +426,149 @@ func (a *AnthropicBackend) Prompt(ctx context.Context, text string) (string, err
 	if result == "" {
 		// Fallback if no text blocks found
 		result = "I received your message and processed it with Claude, but no text content was returned."
-		log.Printf("[%s] LLM: No text content extracted, using fallback", timestamp)
+		reqid.Logf(ctx, "LLM: No text content extracted, using fallback")
 	} else {
-		log.Printf("[%s] LLM: Successfully extracted response text (%d chars total)", timestamp, len(result))
+		reqid.Logf(ctx, "LLM: Successfully extracted response text (%d chars total)", len(result))
 	}
-	
+	span.SetAttributes(
+		attribute.Int("llm.turns", turns),
+		attribute.Int64("llm.request_tokens", requestTokens),
+		attribute.Int("llm.response_chars", len(result)),
+	)
+	span.End()
+
 	return result, nil
 }
 
+// citationMarkers numbers the sources a text block cites in the request's
+// Sources and returns their [n] markers, to follow the block's text. Without
+// a collector the citations are dropped as before.
+func citationMarkers(ctx context.Context, citations []anthropic.BetaTextCitationUnion) string {
+	sources := SourcesFrom(ctx)
+	if sources == nil || len(citations) == 0 {
+		return ""
+	}
+	var markers strings.Builder
+	seen := make(map[int]bool)
+	for _, citation := range citations {
+		source := Source{Title: citation.DocumentTitle}
+		if citation.Type == "web_search_result_location" {
+			source = Source{Title: citation.Title, URL: citation.URL}
+		}
+		if source.Title == "" && source.URL == "" {
+			continue
+		}
+		if n := sources.Add(source); !seen[n] {
+			seen[n] = true
+			markers.WriteString(fmt.Sprintf(" [%d]", n))
+		}
+	}
+	return markers.String()
+}
+
+// toolBudgetExhausted reports which per-request limit, if any, stops the
+// tool loop from running another round
+func (a *AnthropicBackend) toolBudgetExhausted(turns int, requestTokens int64) string {
+	if a.maxTurns > 0 && turns >= a.maxTurns {
+		return "turns"
+	}
+	if a.maxRequestTokens > 0 && requestTokens >= a.maxRequestTokens {
+		return "tokens"
+	}
+	return ""
+}
+
+// executeTools runs the tool calls from one model turn concurrently, at most
+// toolParallelism at a time, and returns their results in request order
+func (a *AnthropicBackend) executeTools(ctx context.Context, calls []anthropic.BetaToolUseBlock) []anthropic.BetaContentBlockParamUnion {
+	results := make([]anthropic.BetaContentBlockParamUnion, len(calls))
+	if len(calls) == 0 {
+		return results
+	}
+
+	parallelism := a.toolParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if len(calls) > 1 {
+		reqid.Logf(ctx, "LLM: Executing %d tools (up to %d at once)", len(calls), parallelism)
+	}
+
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, call anthropic.BetaToolUseBlock) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = a.executeTool(ctx, call)
+		}(i, call)
+	}
+	wg.Wait()
+	return results
+}
+
+// executeTool runs a single tool call and converts its outcome into a tool result block
+func (a *AnthropicBackend) executeTool(ctx context.Context, call anthropic.BetaToolUseBlock) anthropic.BetaContentBlockParamUnion {
+	reqid.Logf(ctx, "LLM: Executing tool: %s", call.Name)
+	reportToolUse(ctx, call.Name)
+
+	ctx, span := tracing.Start(ctx, "tool.execute", attribute.String("tool.name", call.Name))
+	start := time.Now()
+	inputJSON, _ := json.Marshal(call.Input)
+	response, execErr := a.registry.Execute(ctx, call.Name, inputJSON)
+	tracing.End(span, execErr)
+	isError := execErr != nil
+	var timeoutErr *tools.TimeoutError
+	if errors.As(execErr, &timeoutErr) {
+		metrics.Inc("tool_timeouts_total", "tool", call.Name)
+		reqid.Logf(ctx, "LLM: Tool %s timed out after %v", call.Name, timeoutErr.Timeout)
+		response = toolTimeoutResult(timeoutErr)
+	} else if execErr != nil {
+		response = fmt.Sprintf("Error: %v", execErr)
+	}
+	errorsink.Report(ctx, errorsink.KindTool, execErr, "tool", call.Name)
+
+	// Convert response to JSON and add as tool result
+	b, err := json.Marshal(response)
+	if err != nil {
+		b = []byte(fmt.Sprintf("Error marshalling response: %v", err))
+	}
+
+	duration := time.Since(start)
+	reqid.Logf(ctx, "LLM: Tool %s result after %v: %s", call.Name, duration.Round(time.Millisecond), string(b))
+	if a.auditor != nil {
+		if err := a.auditor.RecordToolCall(ctx, call.Name, inputJSON, len(b), duration, execErr); err != nil {
+			reqid.Logf(ctx, "LLM: Failed to audit tool call %s: %v", call.Name, err)
+		}
+	}
+	return anthropic.NewBetaToolResultBlock(call.ID, string(b), isError)
+}
+
+// toolTimeoutResult tells the model a tool was cancelled so it can retry
+// with a narrower request or answer without it
+func toolTimeoutResult(err *tools.TimeoutError) map[string]interface{} {
+	return map[string]interface{}{
+		"error":           "timeout",
+		"tool":            err.Tool,
+		"timeout_seconds": err.Timeout.Seconds(),
+		"message":         fmt.Sprintf("The tool did not finish within %v and was cancelled. Retry with a narrower request, or answer without it and tell the user the data was unavailable.", err.Timeout),
+	}
+}
+
 // PromptStream provides streaming responses from the LLM
 // For now, this simulates streaming by chunking the regular API response
 // TODO: Implement true streaming when the SDK documentation is clarified
 func (a *AnthropicBackend) PromptStream(ctx context.Context, text string) (<-chan types.StreamChunk, error) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	log.Printf("[%s] LLM_STREAM: Starting simulated streaming response", timestamp)
-	log.Printf("[%s] LLM_STREAM: Model: %s", timestamp, a.model)
-	log.Printf("[%s] LLM_STREAM: Input prompt (%d chars): %s", timestamp, len(text), text)
-	log.Printf("[%s] LLM_STREAM: Max tokens: %d", timestamp, a.maxTokens)
-	
+	reqid.Logf(ctx, "LLM_STREAM: Starting simulated streaming response")
+	reqid.Logf(ctx, "LLM_STREAM: Model: %s", a.model)
+	reqid.Logf(ctx, "LLM_STREAM: Input prompt (%d chars): %s", len(text), text)
+	reqid.Logf(ctx, "LLM_STREAM: Max tokens: %d", a.maxTokens)
+
 	// For now, we'll simulate streaming by using the regular API and chunking the response
 	// This provides the streaming user experience while we work on true streaming integration
-	log.Printf("[%s] LLM_STREAM: Using simulated streaming (chunked response)", timestamp)
+	reqid.Logf(ctx, "LLM_STREAM: Using simulated streaming (chunked response)")
 
 	// Create output channel
 	chunkChan := make(chan types.StreamChunk, 10) // Buffered channel
@@ -321,11 +578,11 @@ func (a *AnthropicBackend) PromptStream(ctx context.Context, text string) (<-cha
 		defer close(chunkChan)
 
 		startTime := time.Now()
-		
+
 		// Get the full response using the regular API
 		response, err := a.Prompt(ctx, text)
 		if err != nil {
-			log.Printf("[%s] LLM_STREAM: API call failed: %v", timestamp, err)
+			reqid.Logf(ctx, "LLM_STREAM: API call failed: %v", err)
 			select {
 			case chunkChan <- types.StreamChunk{
 				Content: "",
@@ -338,16 +595,16 @@ func (a *AnthropicBackend) PromptStream(ctx context.Context, text string) (<-cha
 		}
 
 		duration := time.Since(startTime)
-		log.Printf("[%s] LLM_STREAM: Got response (%d chars) in %v, now chunking", timestamp, len(response), duration)
+		reqid.Logf(ctx, "LLM_STREAM: Got response (%d chars) in %v, now chunking", len(response), duration)
 
 		// Simulate streaming by sending chunks of the response
-		chunkSize := 10 // Characters per chunk
+		chunkSize := 10                     // Characters per chunk
 		chunkDelay := 50 * time.Millisecond // Delay between chunks
 
 		for i := 0; i < len(response); i += chunkSize {
 			select {
 			case <-ctx.Done():
-				log.Printf("[%s] LLM_STREAM: Context cancelled during chunking", timestamp)
+				reqid.Logf(ctx, "LLM_STREAM: Context cancelled during chunking")
 				return
 			default:
 			}
@@ -358,7 +615,7 @@ func (a *AnthropicBackend) PromptStream(ctx context.Context, text string) (<-cha
 			}
 
 			chunk := response[i:end]
-			
+
 			// Send chunk
 			select {
 			case chunkChan <- types.StreamChunk{
@@ -367,7 +624,7 @@ func (a *AnthropicBackend) PromptStream(ctx context.Context, text string) (<-cha
 				Error:   nil,
 			}:
 			case <-ctx.Done():
-				log.Printf("[%s] LLM_STREAM: Context cancelled while sending chunk", timestamp)
+				reqid.Logf(ctx, "LLM_STREAM: Context cancelled while sending chunk")
 				return
 			}
 
@@ -378,7 +635,7 @@ func (a *AnthropicBackend) PromptStream(ctx context.Context, text string) (<-cha
 		}
 
 		// Send completion signal
-		log.Printf("[%s] LLM_STREAM: Finished streaming %d chars", timestamp, len(response))
+		reqid.Logf(ctx, "LLM_STREAM: Finished streaming %d chars", len(response))
 		select {
 		case chunkChan <- types.StreamChunk{
 			Content: "",
@@ -392,41 +649,3 @@ func (a *AnthropicBackend) PromptStream(ctx context.Context, text string) (<-cha
 
 	return chunkChan, nil
 }
-
-// GenerateSchema creates a schema for tool input validation
-func GenerateSchema[T any]() anthropic.ToolInputSchemaParam {
-	reflector := jsonschema.Reflector{
-		AllowAdditionalProperties: false,
-		DoNotReference:            true,
-	}
-	var v T
-	schema := reflector.Reflect(v)
-	return anthropic.ToolInputSchemaParam{
-		Properties: schema.Properties,
-	}
-}
-
-// GenerateBetaSchema creates a beta schema for tool input validation
-func GenerateBetaSchema[T any]() anthropic.BetaToolInputSchemaParam {
-	reflector := jsonschema.Reflector{
-		AllowAdditionalProperties: false,
-		DoNotReference:            true,
-	}
-	var v T
-	schema := reflector.Reflect(v)
-	return anthropic.BetaToolInputSchemaParam{
-		Properties: schema.Properties,
-	}
-}
-
-// Asana tool schemas
-var ListProjectsInputSchema = GenerateSchema[asana.ListProjectsArgs]()
-var ListProjectTasksInputSchema = GenerateSchema[asana.ListProjectTasksArgs]()
-var ListUserTasksInputSchema = GenerateSchema[asana.ListUserTasksArgs]()
-var ListUsersInputSchema = GenerateSchema[asana.ListUsersArgs]()
-
-// Beta Asana tool schemas
-var ListProjectsBetaInputSchema = GenerateBetaSchema[asana.ListProjectsArgs]()
-var ListProjectTasksBetaInputSchema = GenerateBetaSchema[asana.ListProjectTasksArgs]()
-var ListUserTasksBetaInputSchema = GenerateBetaSchema[asana.ListUserTasksArgs]()
-var ListUsersBetaInputSchema = GenerateBetaSchema[asana.ListUsersArgs]()
\ No newline at end of file
@@ -0,0 +1,194 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"agent-bot/errorsink"
+	"agent-bot/metrics"
+	"agent-bot/reqid"
+	"agent-bot/types"
+)
+
+// ErrCircuitOpen is returned without calling the model while its circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("LLM unavailable after repeated failures")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed passes every call through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen rejects calls until the cooldown has passed
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single probe call through to test the model
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker wraps an LLMBackend and stops calling it after threshold
+// consecutive failures. Once open it fails fast with ErrCircuitOpen for the
+// cooldown, then lets one call through: success closes the circuit, failure
+// opens it again. Calls cancelled by the caller don't count either way.
+type CircuitBreaker struct {
+	backend   LLMBackend
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	lastErr  error
+}
+
+// NewCircuitBreaker wraps backend; name labels its logs and metrics. A
+// threshold of zero or less disables the breaker.
+func NewCircuitBreaker(name string, backend LLMBackend, threshold int, cooldown time.Duration) *CircuitBreaker {
+	metrics.Set("llm_circuit_open", 0, "backend", name)
+	return &CircuitBreaker{
+		backend:   backend,
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// Prompt calls the backend unless the circuit is open
+func (b *CircuitBreaker) Prompt(ctx context.Context, text string) (string, error) {
+	if err := b.acquire(); err != nil {
+		return "", err
+	}
+	result, err := b.backend.Prompt(ctx, text)
+	b.record(ctx, err)
+	return result, err
+}
+
+// PromptStream calls the backend unless the circuit is open. The outcome is
+// recorded when the stream ends.
+func (b *CircuitBreaker) PromptStream(ctx context.Context, text string) (<-chan types.StreamChunk, error) {
+	if err := b.acquire(); err != nil {
+		return nil, err
+	}
+	chunks, err := b.backend.PromptStream(ctx, text)
+	if err != nil {
+		b.record(ctx, err)
+		return nil, err
+	}
+
+	out := make(chan types.StreamChunk, cap(chunks))
+	go func() {
+		defer close(out)
+		var streamErr error
+		for chunk := range chunks {
+			if chunk.Error != nil {
+				streamErr = chunk.Error
+			}
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				// The reader is gone; keep draining until the backend stops
+			}
+		}
+		b.record(ctx, streamErr)
+	}()
+	return out, nil
+}
+
+// Ping checks the backend directly, bypassing the breaker, so health checks
+// can see a recovered model before the next probe. Backends that can't be
+// pinged report nil.
+func (b *CircuitBreaker) Ping(ctx context.Context) error {
+	if pinger, ok := b.backend.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Status returns the breaker's state and, while open, how long until it
+// lets a probe through
+func (b *CircuitBreaker) Status() (state BreakerState, retryIn time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		retryIn = b.cooldown - time.Since(b.openedAt)
+		if retryIn < 0 {
+			retryIn = 0
+		}
+	}
+	return b.state, retryIn
+}
+
+// acquire decides whether a call may go through
+func (b *CircuitBreaker) acquire() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if remaining := b.cooldown - time.Since(b.openedAt); remaining > 0 {
+			metrics.Inc("llm_circuit_rejections_total", "backend", b.name)
+			return fmt.Errorf("%w (retrying in %v): %v", ErrCircuitOpen, remaining.Round(time.Second), b.lastErr)
+		}
+		b.state = BreakerHalfOpen
+		log.Printf("[%s] LLM: Circuit for %s half-open, probing", time.Now().Format("2006-01-02 15:04:05"), b.name)
+	case BreakerHalfOpen:
+		if b.probing {
+			metrics.Inc("llm_circuit_rejections_total", "backend", b.name)
+			return fmt.Errorf("%w (probe in progress): %v", ErrCircuitOpen, b.lastErr)
+		}
+	}
+	if b.state == BreakerHalfOpen {
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a call
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasProbe := b.probing
+	b.probing = false
+
+	if err == nil {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			reqid.Logf(ctx, "LLM: Circuit for %s closed, model is responding again", b.name)
+			b.state = BreakerClosed
+			metrics.Set("llm_circuit_open", 0, "backend", b.name)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// Cancelled by the caller, which says nothing about the model
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if wasProbe || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			reqid.Logf(ctx, "LLM: Circuit for %s opened after %d consecutive failures, pausing calls for %v: %v", b.name, b.failures, b.cooldown, err)
+			metrics.Inc("llm_circuit_opened_total", "backend", b.name)
+			errorsink.Report(ctx, errorsink.KindLLM, fmt.Errorf("circuit opened after %d consecutive failures: %w", b.failures, err), "backend", b.name)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		metrics.Set("llm_circuit_open", 1, "backend", b.name)
+	}
+}
@@ -0,0 +1,135 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"agent-bot/types"
+)
+
+// fakeBackend answers Prompt with err when set, and "ok" otherwise
+type fakeBackend struct {
+	err   error
+	calls int
+}
+
+func (f *fakeBackend) Prompt(ctx context.Context, text string) (string, error) {
+	f.calls++
+	if f.err != nil {
+		return "", f.err
+	}
+	return "ok", nil
+}
+
+func (f *fakeBackend) PromptStream(ctx context.Context, text string) (<-chan types.StreamChunk, error) {
+	f.calls++
+	chunks := make(chan types.StreamChunk, 2)
+	if f.err != nil {
+		chunks <- types.StreamChunk{Error: f.err}
+	} else {
+		chunks <- types.StreamChunk{Content: "ok"}
+	}
+	close(chunks)
+	return chunks, nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("overloaded")
+	tests := []struct {
+		name         string
+		threshold    int
+		failures     int
+		wantState    BreakerState
+		wantRejected bool
+	}{
+		{name: "healthy", threshold: 3, failures: 0, wantState: BreakerClosed},
+		{name: "below threshold", threshold: 3, failures: 2, wantState: BreakerClosed},
+		{name: "opens at threshold", threshold: 3, failures: 3, wantState: BreakerOpen, wantRejected: true},
+		{name: "disabled", threshold: 0, failures: 10, wantState: BreakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{err: failure}
+			breaker := NewCircuitBreaker("test", backend, tt.threshold, time.Hour)
+			for i := 0; i < tt.failures; i++ {
+				if _, err := breaker.Prompt(context.Background(), "hi"); !errors.Is(err, failure) {
+					t.Fatalf("call %d error = %v, want %v", i+1, err, failure)
+				}
+			}
+			if state, _ := breaker.Status(); state != tt.wantState {
+				t.Fatalf("state = %s, want %s", state, tt.wantState)
+			}
+
+			backend.err = nil
+			calls := backend.calls
+			_, err := breaker.Prompt(context.Background(), "hi")
+			if tt.wantRejected {
+				if !errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("error = %v, want %v", err, ErrCircuitOpen)
+				}
+				if backend.calls != calls {
+					t.Errorf("open breaker called the backend")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	failure := errors.New("overloaded")
+	tests := []struct {
+		name      string
+		probeErr  error
+		wantState BreakerState
+	}{
+		{name: "probe succeeds", probeErr: nil, wantState: BreakerClosed},
+		{name: "probe fails", probeErr: failure, wantState: BreakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{err: failure}
+			breaker := NewCircuitBreaker("test", backend, 1, time.Millisecond)
+			breaker.Prompt(context.Background(), "hi")
+			time.Sleep(2 * time.Millisecond)
+
+			backend.err = tt.probeErr
+			breaker.Prompt(context.Background(), "hi")
+			if state, _ := breaker.Status(); state != tt.wantState {
+				t.Fatalf("state after probe = %s, want %s", state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerIgnoresCancelledCalls(t *testing.T) {
+	backend := &fakeBackend{err: context.Canceled}
+	breaker := NewCircuitBreaker("test", backend, 1, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	breaker.Prompt(ctx, "hi")
+	if state, _ := breaker.Status(); state != BreakerClosed {
+		t.Fatalf("state = %s after a cancelled call, want %s", state, BreakerClosed)
+	}
+}
+
+func TestCircuitBreakerStreamFailureOpens(t *testing.T) {
+	backend := &fakeBackend{err: errors.New("overloaded")}
+	breaker := NewCircuitBreaker("test", backend, 1, time.Hour)
+
+	chunks, err := breaker.PromptStream(context.Background(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range chunks {
+	}
+	if _, err := breaker.PromptStream(context.Background(), "hi"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error = %v, want %v", err, ErrCircuitOpen)
+	}
+}
@@ -0,0 +1,74 @@
+package llms
+
+import (
+	"context"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const historyKey contextKey = "history"
+
+// Roles of conversation turns
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// conversationStart opens a history whose first turn is the assistant's,
+// since the API wants the user to speak first
+const conversationStart = "(The conversation so far)"
+
+// Turn is an earlier message of the conversation a prompt continues
+type Turn struct {
+	Role string
+	Text string
+}
+
+// WithHistory returns a context whose request sends turns as the earlier
+// messages of the conversation, before the prompt
+func WithHistory(ctx context.Context, turns []Turn) context.Context {
+	return context.WithValue(ctx, historyKey, turns)
+}
+
+// HistoryFrom returns the earlier turns of the request context, if any
+func HistoryFrom(ctx context.Context) []Turn {
+	turns, _ := ctx.Value(historyKey).([]Turn)
+	return turns
+}
+
+// conversation builds the messages of a request: the history, then prompt
+// as the user's message. Consecutive turns of one role share a message. The
+// end of the history is a cache breakpoint, so the next request in the same
+// conversation, and every tool turn of this one, reuses it.
+func conversation(history []Turn, prompt []anthropic.BetaContentBlockParamUnion) []anthropic.BetaMessageParam {
+	var messages []anthropic.BetaMessageParam
+	add := func(role anthropic.BetaMessageParamRole, blocks ...anthropic.BetaContentBlockParamUnion) {
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, blocks...)
+			return
+		}
+		messages = append(messages, anthropic.BetaMessageParam{Role: role, Content: blocks})
+	}
+
+	for _, turn := range history {
+		if strings.TrimSpace(turn.Text) == "" {
+			continue
+		}
+		role := anthropic.BetaMessageParamRoleUser
+		if turn.Role == RoleAssistant {
+			role = anthropic.BetaMessageParamRoleAssistant
+			if len(messages) == 0 {
+				add(anthropic.BetaMessageParamRoleUser, anthropic.NewBetaTextBlock(conversationStart))
+			}
+		}
+		add(role, anthropic.NewBetaTextBlock(turn.Text))
+	}
+	if n := len(messages); n > 0 {
+		last := messages[n-1].Content
+		last[len(last)-1].OfText.CacheControl = anthropic.NewBetaCacheControlEphemeralParam()
+	}
+
+	add(anthropic.BetaMessageParamRoleUser, prompt...)
+	return messages
+}
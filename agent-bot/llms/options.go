@@ -0,0 +1,64 @@
+package llms
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"agent-bot/types"
+)
+
+type contextKey string
+
+const (
+	toolsDisabledKey contextKey = "tools_disabled"
+	imagesKey        contextKey = "images"
+	systemPromptKey  contextKey = "system_prompt"
+)
+
+// UsageRecorder receives token usage and tool invocations from backends.
+// Attribution (user, channel) comes from the request context.
+type UsageRecorder interface {
+	RecordLLM(ctx context.Context, model string, inputTokens, outputTokens int64)
+	RecordTool(ctx context.Context, model, tool string, count int64)
+}
+
+// ToolAuditor receives every tool invocation with its outcome. Attribution
+// comes from the request context.
+type ToolAuditor interface {
+	RecordToolCall(ctx context.Context, tool string, input json.RawMessage, resultBytes int, duration time.Duration, err error) error
+}
+
+// WithoutTools returns a context that disables tool use for a single request,
+// regardless of how the backend was configured
+func WithoutTools(ctx context.Context) context.Context {
+	return context.WithValue(ctx, toolsDisabledKey, true)
+}
+
+// WithImages returns a context that attaches images to the prompt of a single request
+func WithImages(ctx context.Context, images []types.Image) context.Context {
+	return context.WithValue(ctx, imagesKey, images)
+}
+
+// WithSystemPrompt returns a context that sends system as the system prompt of a single request
+func WithSystemPrompt(ctx context.Context, system string) context.Context {
+	return context.WithValue(ctx, systemPromptKey, system)
+}
+
+// systemPromptFrom returns the system prompt of the request context, if any
+func systemPromptFrom(ctx context.Context) string {
+	system, _ := ctx.Value(systemPromptKey).(string)
+	return system
+}
+
+// imagesFrom returns the images attached to the request context
+func imagesFrom(ctx context.Context) []types.Image {
+	images, _ := ctx.Value(imagesKey).([]types.Image)
+	return images
+}
+
+// toolsAllowed reports whether the request context permits tool use
+func toolsAllowed(ctx context.Context) bool {
+	disabled, _ := ctx.Value(toolsDisabledKey).(bool)
+	return !disabled
+}
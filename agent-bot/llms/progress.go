@@ -0,0 +1,25 @@
+package llms
+
+import "context"
+
+const toolProgressKey contextKey = "tool_progress"
+
+// ToolProgress is told the name of each tool the model uses during a
+// request, as the use starts or, for tools the API runs itself, as it is
+// reported
+type ToolProgress func(tool string)
+
+// WithToolProgress returns a context that reports the tools used by a
+// single request to progress, which must not block
+func WithToolProgress(ctx context.Context, progress ToolProgress) context.Context {
+	return context.WithValue(ctx, toolProgressKey, progress)
+}
+
+// reportToolUse tells the request context's ToolProgress, if any, about a
+// tool and records it in the context's ReplyInfo
+func reportToolUse(ctx context.Context, tool string) {
+	ReplyInfoFrom(ctx).addTool(tool)
+	if progress, ok := ctx.Value(toolProgressKey).(ToolProgress); ok && progress != nil {
+		progress(tool)
+	}
+}
@@ -0,0 +1,72 @@
+package llms
+
+import (
+	"context"
+	"sync"
+)
+
+const replyInfoKey contextKey = "reply_info"
+
+// ReplyInfo records which model answered a request made WithReplyInfo and
+// which tools it used
+type ReplyInfo struct {
+	mu    sync.Mutex
+	model string
+	tools []string
+}
+
+func (r *ReplyInfo) setModel(model string) {
+	if r == nil || model == "" {
+		return
+	}
+	r.mu.Lock()
+	r.model = model
+	r.mu.Unlock()
+}
+
+func (r *ReplyInfo) addTool(tool string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, known := range r.tools {
+		if known == tool {
+			return
+		}
+	}
+	r.tools = append(r.tools, tool)
+}
+
+// Model returns the model of the last API call, or "" if none was made
+func (r *ReplyInfo) Model() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.model
+}
+
+// Tools returns the tools used, each once, in the order first used
+func (r *ReplyInfo) Tools() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.tools...)
+}
+
+// WithReplyInfo returns a context that records the model and tools of a
+// single request in info
+func WithReplyInfo(ctx context.Context, info *ReplyInfo) context.Context {
+	return context.WithValue(ctx, replyInfoKey, info)
+}
+
+// ReplyInfoFrom returns where the request context records its model and
+// tools, or nil
+func ReplyInfoFrom(ctx context.Context) *ReplyInfo {
+	info, _ := ctx.Value(replyInfoKey).(*ReplyInfo)
+	return info
+}
@@ -0,0 +1,129 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-bot/metrics"
+	"agent-bot/reqid"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// RetryPolicy controls how API calls that fail with a transient error are
+// retried. Delays double from BaseDelay up to MaxDelay, with jitter, unless
+// the response says how long to wait with retry-after.
+type RetryPolicy struct {
+	// MaxAttempts includes the first call; 1 disables retries
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Deadline stops retries once the next attempt would start this long
+	// after the first; zero leaves it to the caller's context
+	Deadline time.Duration
+}
+
+// DefaultRetryPolicy retries a few times over at most a minute
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    20 * time.Second,
+	Deadline:    time.Minute,
+}
+
+// retryableStatus lists responses worth trying again: rate limits,
+// overloaded (529) and server errors that are usually brief
+var retryableStatus = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+	529:                            true,
+}
+
+// withRetry runs call until it succeeds, fails with an error that isn't
+// transient, or the policy's attempts or deadline run out
+func withRetry[T any](ctx context.Context, policy RetryPolicy, model string, call func() (T, error)) (T, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		result, err := call()
+		if err == nil || attempt >= policy.MaxAttempts {
+			return result, err
+		}
+		status, retryAfter, ok := retryable(err)
+		if !ok {
+			return result, err
+		}
+
+		delay := retryAfter
+		if delay == 0 {
+			delay = backoff(policy, attempt)
+		}
+		if policy.Deadline > 0 && time.Since(start)+delay > policy.Deadline {
+			reqid.Logf(ctx, "LLM: Not retrying %d response, waiting %v would pass the %v deadline", status, delay, policy.Deadline)
+			return result, err
+		}
+
+		reqid.Logf(ctx, "LLM: Attempt %d/%d failed with %d, retrying in %v", attempt, policy.MaxAttempts, status, delay.Round(time.Millisecond))
+		metrics.Inc("llm_retries_total", "model", model, "status", strconv.Itoa(status))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, fmt.Errorf("%w (gave up waiting to retry: %v)", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether err is a transient API error, with its status
+// code and the wait the server asked for, if any
+func retryable(err error) (status int, retryAfter time.Duration, ok bool) {
+	var apiErr *anthropic.Error
+	if !errors.As(err, &apiErr) || !retryableStatus[apiErr.StatusCode] {
+		return 0, 0, false
+	}
+	if apiErr.Response != nil {
+		retryAfter = parseRetryAfter(apiErr.Response.Header)
+	}
+	return apiErr.StatusCode, retryAfter, true
+}
+
+// parseRetryAfter reads retry-after-ms, then retry-after as seconds or an
+// HTTP date. Missing or unparseable headers return zero.
+func parseRetryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("retry-after")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// backoff is the wait before the retry following attempt: the exponential
+// delay, capped, then jittered down by up to half
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	delay := policy.BaseDelay << (attempt - 1)
+	if delay <= 0 || (policy.MaxDelay > 0 && delay > policy.MaxDelay) {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
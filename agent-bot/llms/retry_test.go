@@ -0,0 +1,124 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// apiError builds the error the SDK returns for a response with status
+func apiError(status int, header http.Header) error {
+	req, _ := http.NewRequest(http.MethodPost, "https://api.anthropic.com/v1/messages", nil)
+	if header == nil {
+		header = http.Header{}
+	}
+	return &anthropic.Error{
+		StatusCode: status,
+		Request:    req,
+		Response:   &http.Response{StatusCode: status, Header: header},
+	}
+}
+
+var fastRetries = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestWithRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "first call succeeds", policy: fastRetries, errs: nil, wantCalls: 1},
+		{name: "succeeds after overload", policy: fastRetries, errs: []error{apiError(529, nil), apiError(503, nil)}, wantCalls: 3},
+		{name: "retries exhausted", policy: fastRetries, errs: []error{apiError(429, nil), apiError(429, nil), apiError(429, nil), apiError(429, nil)}, wantCalls: 3, wantErr: true},
+		{name: "client error not retried", policy: fastRetries, errs: []error{apiError(400, nil)}, wantCalls: 1, wantErr: true},
+		{name: "network error not retried", policy: fastRetries, errs: []error{errors.New("connection reset")}, wantCalls: 1, wantErr: true},
+		{name: "retries disabled", policy: RetryPolicy{MaxAttempts: 1}, errs: []error{apiError(500, nil)}, wantCalls: 1, wantErr: true},
+		{name: "wait past deadline", policy: RetryPolicy{MaxAttempts: 3, Deadline: time.Second}, errs: []error{apiError(429, http.Header{"Retry-After": {"30"}})}, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			got, err := withRetry(context.Background(), tt.policy, "test-model", func() (string, error) {
+				calls++
+				if calls <= len(tt.errs) {
+					return "", tt.errs[calls-1]
+				}
+				return "ok", nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("withRetry() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != "ok" {
+				t.Fatalf("withRetry() = %q, %v; want ok", got, err)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	_, err := withRetry(ctx, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}, "test-model", func() (string, error) {
+		calls++
+		cancel()
+		return "", apiError(503, nil)
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("withRetry() made %d calls with error %v; want one call and an error", calls, err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "missing", header: http.Header{}, want: 0},
+		{name: "milliseconds", header: http.Header{"Retry-After-Ms": {"1500"}}, want: 1500 * time.Millisecond},
+		{name: "seconds", header: http.Header{"Retry-After": {"2"}}, want: 2 * time.Second},
+		{name: "milliseconds win", header: http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"9"}}, want: 250 * time.Millisecond},
+		{name: "garbage", header: http.Header{"Retry-After": {"soon"}}, want: 0},
+		{name: "date in the past", header: http.Header{"Retry-After": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header); got != tt.want {
+				t.Errorf("parseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffStaysWithinBounds(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 4 * time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: time.Second},
+		{attempt: 2, max: 2 * time.Second},
+		{attempt: 3, max: 4 * time.Second},
+		{attempt: 10, max: 4 * time.Second},
+		{attempt: 80, max: 4 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := backoff(policy, tt.attempt); got < tt.max/2 || got > tt.max {
+				t.Fatalf("backoff(attempt %d) = %v, want between %v and %v", tt.attempt, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
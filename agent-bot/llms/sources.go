@@ -0,0 +1,62 @@
+package llms
+
+import (
+	"context"
+	"sync"
+)
+
+const sourcesKey contextKey = "sources"
+
+// Source is a web page or document an answer can cite
+type Source struct {
+	Title string
+	// URL is empty for sources without a link, such as indexed chat history
+	URL string
+}
+
+// Sources numbers the sources cited during a request made WithSources. The
+// answer refers to them as [n] markers.
+type Sources struct {
+	mu      sync.Mutex
+	sources []Source
+}
+
+// Add returns the 1-based number of a source, adding it if it is new.
+// Sources with the same URL, or without URLs and with the same title, share
+// a number.
+func (s *Sources) Add(source Source) int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, known := range s.sources {
+		if known.URL == source.URL && (source.URL != "" || known.Title == source.Title) {
+			return i + 1
+		}
+	}
+	s.sources = append(s.sources, source)
+	return len(s.sources)
+}
+
+// List returns the sources in number order
+func (s *Sources) List() []Source {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Source(nil), s.sources...)
+}
+
+// WithSources returns a context that collects the citations of a single
+// request in sources
+func WithSources(ctx context.Context, sources *Sources) context.Context {
+	return context.WithValue(ctx, sourcesKey, sources)
+}
+
+// SourcesFrom returns where the request context collects citations, or nil
+func SourcesFrom(ctx context.Context) *Sources {
+	sources, _ := ctx.Value(sourcesKey).(*Sources)
+	return sources
+}
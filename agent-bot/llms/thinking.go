@@ -0,0 +1,61 @@
+package llms
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// MinThinkingBudget is the smallest extended thinking budget the API accepts
+const MinThinkingBudget = 1024
+
+const thinkingKey contextKey = "thinking"
+
+// Reasoning collects the model's thinking during a request made WithThinking
+type Reasoning struct {
+	mu    sync.Mutex
+	parts []string
+}
+
+func (r *Reasoning) add(text string) {
+	if r == nil || strings.TrimSpace(text) == "" {
+		return
+	}
+	r.mu.Lock()
+	r.parts = append(r.parts, strings.TrimSpace(text))
+	r.mu.Unlock()
+}
+
+// String returns the thinking collected so far, oldest first
+func (r *Reasoning) String() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.parts, "\n\n")
+}
+
+type thinkingRequest struct {
+	budgetTokens int
+	reasoning    *Reasoning
+}
+
+// WithThinking returns a context that turns on extended thinking with a
+// budget of budgetTokens for a single request. The thinking is collected in
+// reasoning unless it is nil. Budgets below MinThinkingBudget are raised to it.
+func WithThinking(ctx context.Context, budgetTokens int, reasoning *Reasoning) context.Context {
+	return context.WithValue(ctx, thinkingKey, thinkingRequest{budgetTokens: max(budgetTokens, MinThinkingBudget), reasoning: reasoning})
+}
+
+// ReasoningFrom returns where the request context collects thinking, or nil
+func ReasoningFrom(ctx context.Context) *Reasoning {
+	thinking, _ := ctx.Value(thinkingKey).(thinkingRequest)
+	return thinking.reasoning
+}
+
+// thinkingFrom returns the extended thinking settings of the request context
+func thinkingFrom(ctx context.Context) (thinkingRequest, bool) {
+	thinking, ok := ctx.Value(thinkingKey).(thinkingRequest)
+	return thinking, ok
+}
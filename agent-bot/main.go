@@ -2,23 +2,76 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"agent-bot/apikeys"
+	"agent-bot/approvals"
+	"agent-bot/asana"
+	"agent-bot/audit"
+	"agent-bot/canary"
+	"agent-bot/controlplane"
+	"agent-bot/distributed"
+	"agent-bot/embeddings"
+	"agent-bot/errorsink"
+	"agent-bot/hooks"
+	"agent-bot/imagegen"
+	"agent-bot/jira"
+	"agent-bot/knowledge"
+	"agent-bot/kube"
+	"agent-bot/language"
 	"agent-bot/llms"
+	"agent-bot/mattermost"
+	"agent-bot/mcpclient"
+	"agent-bot/memory"
+	"agent-bot/metrics"
+	"agent-bot/moderation"
+	"agent-bot/notify"
+	"agent-bot/pagerduty"
+	"agent-bot/pii"
+	"agent-bot/policy"
+	"agent-bot/presence"
+	"agent-bot/prometheus"
+	"agent-bot/prompts"
+	"agent-bot/redis"
+	"agent-bot/render"
+	"agent-bot/repl"
+	"agent-bot/scheduler"
+	"agent-bot/sentiment"
+	"agent-bot/speech"
+	"agent-bot/standup"
+	"agent-bot/store"
+	"agent-bot/styles"
+	"agent-bot/templates"
+	"agent-bot/tools"
+	"agent-bot/tracing"
+	"agent-bot/transcribe"
 	"agent-bot/types"
+	"agent-bot/usage"
+	"agent-bot/webfetch"
+	"agent-bot/wiki"
 
+	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	"github.com/mattermost/mattermost-server/v6/model"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Config struct {
+	// Name is the server profile's name; empty for the primary workspace
+	Name string
+
 	ServerURL         string
 	AccessToken       string
 	BotUserID         string
@@ -31,36 +84,445 @@ type Config struct {
 	DecisionModel     string
 	DecisionMaxTokens int
 	AsanaKey          string
+	AsanaCacheTTL     time.Duration
+	AsanaRateLimit    int
+	AsanaWebhookURL   string
+	// ButtonCallbackURL is the base URL Mattermost calls for interactive buttons
+	ButtonCallbackURL string
+	JiraBaseURL       string
+	JiraEmail         string
+	JiraAPIToken      string
+	PagerDutyAPIToken string
+	PagerDutyEmail    string
+	PrometheusURL     string
+	PrometheusToken   string
+	KubeConfig        string
+	KubeNamespaces    []string
+	KrokiURL          string
+	AdminUserIDs      []string
+	StateFile         string
+	StateDatabaseURL  string
+	ConfigFile        string
+	ContextMaxMsgs    int
+	ContextMaxTokens  int
+	// How conversations are turned into prompts (full, recent, summarized,
+	// rag or channel), overridable per channel with !context
+	ContextStrategy string
+	// Image generation: off, openai (or a compatible server) or stability
+	ImageProvider string
+	ImageAPIURL   string
+	ImageAPIKey   string
+	ImageModel    string
+	// Team wiki tools: off, confluence or notion; WikiSpaces are Confluence
+	// space keys or Notion page and database IDs the bot may read
+	WikiProvider string
+	WikiURL      string
+	WikiEmail    string
+	WikiAPIToken string
+	WikiSpaces   []string
+	WikiMaxChars int
+	// Directory of <name>.tmpl files overriding the built-in prompts
+	PromptsDir string
+	// Signing secrets for /webhooks/github and /webhooks/gitlab deliveries
+	GitHubWebhookSecret string
+	GitLabWebhookToken  string
+	// Decision LLM degradation thresholds
+	DecisionMaxLatency  time.Duration
+	DecisionTokenBudget int
+	// Replies wait this long for quick follow-ups from the same user in the
+	// same thread, which are answered together; 0 replies straight away
+	DebounceWindow time.Duration
+	// At most one unsolicited reply per thread (or channel, for top-level
+	// posts) in this long; 0 turns the cooldown off
+	ThreadReplyCooldown time.Duration
+	// Threads without activity for this long are left; 0 follows them for good
+	ThreadTTL time.Duration
+	// Emoji names mapped to the action reacting with them runs, and the
+	// language the translate action translates into
+	ReactionActions   map[string]string
+	TranslateLanguage string
+	// When standup questions and other proactive DMs are held back until
+	// the user is available
+	QuietHours presence.Policy
+	// Language replies fall back to when a message's language can't be
+	// detected, overridable per channel with !language; empty for none
+	DefaultLanguage string
+	// Content moderation: the built-in profanity list (off, redact or block)
+	// and the classifier (off, llm or api) screening whole messages in
+	// ModerationClassify's direction (both, incoming or outgoing)
+	ModerationProfanity  string
+	ModerationClassifier string
+	ModerationClassify   string
+	ModerationCategories string
+	ModerationAPIURL     string
+	ModerationAPIKey     string
+	ModerationAPIModel   string
+	// Built-in rules (email, phone, ...) whose matches are replaced with
+	// placeholders in prompts, along with the config file's pii_rules
+	PIIRedaction []string
+	// Prompt injection policy (off, flag or refuse) and the optional
+	// classifier (off or llm) double-checking messages heuristics let through
+	PromptInjection     string
+	InjectionClassifier string
+	// Default daily LLM budgets per user and per channel, raised or lowered
+	// for single users and channels with !quota
+	UserQuota    quotaLimit
+	ChannelQuota quotaLimit
+	// ChannelIntro posts an introduction in channels the bot is added to
+	ChannelIntro bool
+	// Usage accounting
+	AdminAPIToken      string
+	UsageExportChannel string
+	// GRPCPort serves the gRPC control plane when set
+	GRPCPort string
+	// TLS and websocket settings for self-hosted servers
+	TLSCAFile             string
+	TLSInsecureSkipVerify bool
+	WebSocketDialTimeout  time.Duration
+	// Daily digest schedule
+	DigestTime     string
+	DigestTimezone string
+	// BriefingTime is when users who ran !briefing on get their Asana tasks
+	BriefingTime string
+	// Imported history used for retrieval
+	KnowledgeIndexFile string
+	// Embedding-based tool preselection; a top-k of 0 sends every tool
+	ToolPreselectTopK       int
+	ToolPreselectSampleRate float64
+	EmbeddingsProvider      string
+	VoyageAPIKey            string
+	VoyageModel             string
+	// Voice message transcription: off, openai (or a compatible server) or command
+	TranscriptionProvider string
+	TranscriptionAPIURL   string
+	TranscriptionAPIKey   string
+	TranscriptionModel    string
+	TranscriptionCommand  string
+	// Spoken replies: off, openai (or a compatible server) or command
+	TTSProvider string
+	TTSAPIURL   string
+	TTSAPIKey   string
+	TTSModel    string
+	TTSVoice    string
+	TTSFormat   string
+	TTSCommand  string
+	// Canary shadowing: the live bot mirrors to CanaryURL; a canary runs with CanaryMode
+	CanaryURL           string
+	CanaryToken         string
+	CanaryMode          bool
+	CanaryReportChannel string
+	// Distributed mode: off, gateway (queues websocket events in Redis) or
+	// worker (answers them); both keep their state in Redis
+	DistributedMode       string
+	RedisURL              string
+	RedisPrefix           string
+	DistributedPartitions int
+	// LeaderElection runs replicas as hot standbys instead: only the one
+	// holding the lease in Redis connects
+	LeaderElection bool
+	// EventSource is websocket, or webhook to receive messages as outgoing
+	// webhooks and slash commands carrying one of WebhookTokens
+	EventSource   string
+	WebhookTokens []string
+	// Ping the LLMs and every tool backend before connecting
+	StartupSelfTest bool
+	// Panics, failed tool calls and open LLM circuits are reported to
+	// Sentry and/or ErrorWebhookURL
+	SentryDSN         string
+	SentryEnvironment string
+	ErrorWebhookURL   string
+	// Deadline for tool calls without their own (see tool_timeouts in the config file)
+	ToolTimeout time.Duration
+	// Tool calls from one model turn run concurrently up to this limit
+	ToolParallelism int
+	// Per-request limits on the tool-use loop
+	ToolMaxTurns         int
+	ToolMaxRequestTokens int
+	// LLM calls stop for LLMBreakerCooldown after this many consecutive
+	// failures; 0 disables the circuit breaker
+	LLMBreakerThreshold int
+	LLMBreakerCooldown  time.Duration
+	// Rate limited, overloaded and 5xx API calls are retried with backoff up
+	// to LLMRetryMaxAttempts calls, giving up once LLMRetryDeadline has passed
+	LLMRetryMaxAttempts int
+	LLMRetryDeadline    time.Duration
+	// Extended thinking for replies, overridable per channel with !thinking;
+	// a budget of 0 turns it off
+	ThinkingBudget        int
+	ThinkingShowReasoning bool
+	// Model routing: off, heuristic or llm (the decision LLM classifies);
+	// simple questions go to RouterSimpleModel
+	ModelRouting      string
+	RouterSimpleModel string
+	// Append-only JSON Lines log of tool calls; empty disables it
+	AuditLogFile string
+	// fetch_url tool limits
+	WebFetchEnabled      bool
+	WebFetchTimeout      time.Duration
+	WebFetchMaxChars     int
+	WebFetchAllowPrivate bool
+	// Channel messages from other teams are ignored; empty serves every team
+	TeamIDs              []string
+	IgnoreDirectMessages bool
+
+	// ChatPlatform selects mattermost, slack (Socket Mode) or discord (gateway)
+	ChatPlatform    string
+	SlackBotToken   string
+	SlackAppToken   string
+	DiscordBotToken string
 }
 
 type Bot struct {
 	client             *model.Client4
 	config             Config
 	wsClient           *model.WebSocketClient
+	wsDialer           *websocket.Dialer
 	reconnectTicker    *time.Ticker
 	stopChan           chan struct{}
 	llmBackend         llms.LLMBackend
 	decisionLLMBackend llms.LLMBackend
+	llmBreaker         *llms.CircuitBreaker
+	decisionBreaker    *llms.CircuitBreaker
+	simpleBreaker      *llms.CircuitBreaker
 	agent              types.Agent
+	store              *store.Store
+	apiKeys            *apikeys.Manager
+	commands           *AdminCommands
+	templates          *templates.Set
+	styles             *channelStyles
+	policies           *channelPolicies
+	languages          *channelLanguages
+	thinking           *channelThinking
+	contexts           *channelContexts
+	prompts            *prompts.Set
+	redactor           *pii.Redactor
+	quotas             *quotas
+	notifications      *notify.Engine
+	asanaHooks         *asanaWebhooks
+	hooks              *hooks.Set
+	memory             *memory.Memory
+	registry           *tools.Registry
+	approvals          *approvals.Manager
+	features           *Features
+	startedAt          time.Time
+	chat               *ChatAdapter
+	sentiment          *sentiment.Monitor
+	actionItems        *actionItems
+	usage              *usage.Tracker
+	auditLog           *audit.Log
+	scheduler          *scheduler.Scheduler
+	teams              *channelTeams
+
+	// when the last websocket event and the last message for the agent
+	// arrived, in Unix nanoseconds (see stamp)
+	lastEventAt   atomic.Int64
+	lastMessageAt atomic.Int64
+	// standby is set while another replica is the leader
+	standby atomic.Bool
+
+	// canary shadowing
+	canaryMirror     *canary.Mirror
+	canaryComparator *canary.Comparator
+	canaryEvents     chan types.PostedMessage
+
+	// distributed mode: the shared Redis, the event queue of a gateway or
+	// worker, and the worker consuming it
+	redis  *redis.Client
+	queue  *distributed.Queue
+	worker *distributed.Worker
+
+	// standups and the lock guarding their rounds in the store
+	standups  []standup.Config
+	standupMu sync.Mutex
 }
 
-func NewBot(config Config, llmBackend, decisionLLMBackend llms.LLMBackend) *Bot {
+func NewBot(config Config, fileConfig *FileConfig, tlsConfig *tls.Config, stateStore *store.Store, registry *tools.Registry, promptSet *prompts.Set, llmBackend, decisionLLMBackend llms.LLMBackend) *Bot {
 	client := model.NewAPIv4Client(config.ServerURL)
+	client.HTTPClient = newHTTPClient(tlsConfig)
 	client.SetToken(config.AccessToken)
 
+	// Every LLM call from this workspace goes through the breakers
+	llmBreaker, decisionBreaker := newLLMBreakers(config, llmBackend, decisionLLMBackend)
+
 	bot := &Bot{
 		client:             client,
 		config:             config,
+		wsDialer:           newWebSocketDialer(tlsConfig, config.WebSocketDialTimeout),
 		stopChan:           make(chan struct{}),
-		llmBackend:         llmBackend,
-		decisionLLMBackend: decisionLLMBackend,
+		llmBackend:         llmBreaker,
+		decisionLLMBackend: decisionBreaker,
+		llmBreaker:         llmBreaker,
+		decisionBreaker:    decisionBreaker,
+		store:              stateStore,
+		apiKeys:            apikeys.NewManager(stateStore),
+		commands:           NewAdminCommands(config.AdminUserIDs),
+		templates:          templates.NewSet(fileConfig.ResponseTemplates),
+		styles:             newChannelStyles(fileConfig, stateStore),
+		policies:           newChannelPolicies(config, fileConfig, stateStore),
+		languages:          newChannelLanguages(config, stateStore),
+		thinking:           newChannelThinking(config, stateStore),
+		contexts:           newChannelContexts(config, stateStore),
+		prompts:            promptSet,
+		redactor:           newRedactor(config, fileConfig),
+		registry:           registry,
+		approvals:          approvals.NewManager(time.Hour),
+		features:           NewFeatures(),
+		startedAt:          time.Now(),
 	}
+	// Prompts reach the LLM APIs with personal data replaced by placeholders
+	bot.llmBackend = newRedactingBackend(llmBreaker, bot.redactor, bot.features)
+	bot.decisionLLMBackend = newRedactingBackend(decisionBreaker, bot.redactor, bot.features)
+
+	// Scheduled jobs run in the digest timezone, validated in main
+	location, err := time.LoadLocation(config.DigestTimezone)
+	if err != nil {
+		location = time.UTC
+	}
+	bot.scheduler = scheduler.New(location)
+	if err := bot.scheduler.Daily("daily-digest", config.DigestTime, bot.runDailyDigests); err != nil {
+		log.Printf("[%s] DIGEST: Daily digest disabled: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+	if err := bot.scheduler.Daily("morning-briefings", config.BriefingTime, bot.runMorningBriefings); err != nil {
+		log.Printf("[%s] MY_TASKS: Morning briefings disabled: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+
+	bot.standups = fileConfig.Standups
+	bot.scheduleStandups()
+
+	// DMs held for DND or quiet hours go out once their users are available
+	bot.scheduler.Every("held-messages", heldRetryInterval, bot.deliverHeldMessages)
+
+	bot.teams = &channelTeams{client: client, teams: make(map[string]string)}
+	bot.usage = usage.NewTracker(stateStore, fileConfig.ModelPrices, bot.teams.resolve)
+	bot.quotas = newQuotas(config, bot.usage, stateStore)
+	bot.simpleBreaker = newSimpleLLM(config, bot.usage)
+
+	// Channel housekeeping tools are admin-only and gated behind !approve
+	for _, tool := range mattermost.NewChannelTools(client, bot.approvals, bot.commands.IsAdmin).Tools() {
+		registry.Register(tool)
+	}
+
+	// Per-channel scratchpad the model can use to remember small facts
+	bot.memory = memory.New(stateStore)
+	for _, tool := range bot.memory.Tools() {
+		registry.Register(tool)
+	}
+	// Per-user facts ("remember that I...") added to that user's prompts
+	for _, tool := range bot.memory.UserTools() {
+		registry.Register(tool)
+	}
+
+	bot.commands.Register("apikey", "Create, revoke and list webhook API keys", bot.handleAPIKeyCommand)
+	bot.commands.Register("templates", "List configured response templates", bot.handleTemplatesCommand)
+	bot.commands.Register("prompts", "List prompt templates or reload them from PROMPTS_DIR: !prompts [reload]", bot.handlePromptsCommand)
+	bot.commands.Register("thinking", "Show or set extended thinking for a channel: !thinking [channel_id|here] [budget_tokens [show|hide]|off|default] | list", bot.handleThinkingCommand)
+	bot.commands.Register("context", "Show or set how a channel's conversations are sent to the model: !context [channel_id|here] [full|recent|summarized|rag|channel|default] | list", bot.handleContextCommand)
+	bot.commands.Register("language", "Show or set a channel's default reply language: !language [channel_id|here] [language|default] | list", bot.handleLanguageCommand)
+	bot.commands.Register("style", "Show or set a channel's reply style: !style [channel_id|here] [verbosity=concise|detailed max_length=N emoji=on|off sources=on|off footer=on|off] | reset | list", bot.handleStyleCommand)
+	bot.commands.Register("policy", "Show or set when the bot answers in a channel without a mention: !policy [channel_id|here] [mode=always|never|mention_only|llm keywords=a,b max_per_hour=N timezone=Zone] | reset | list", bot.handlePolicyCommand)
+	bot.commands.Register("memory", "List or forget facts the model remembered in a channel", bot.handleMemoryCommand)
+	bot.commands.RegisterPublic("forget", "See or forget what the bot remembers about you: !forget [key|all]", bot.handleForgetCommand)
+	bot.commands.RegisterPublic("briefing", "Get a morning DM of your due and overdue Asana tasks: !briefing [on|off]", bot.handleBriefingCommand)
+	bot.commands.Register("rules", "List webhook notification rules", bot.handleRulesCommand)
+	bot.commands.Register("hooks", "List named hooks that triage posted payloads", bot.handleHooksCommand)
+	bot.commands.Register("asana", "List or register Asana project webhooks: !asana webhooks | register | unregister <project_gid>", bot.handleAsanaCommand)
+	bot.commands.Register("asanauser", "Link chat users to Asana users: !asanauser list | match | link <@username|user_id> <asana_gid> | unlink <@username|user_id>", bot.handleAsanaUserCommand)
+	bot.commands.Register("pending", "List actions waiting for approval", bot.handlePendingCommand)
+	bot.commands.Register("approve", "Approve and run a pending action: !approve <id>", bot.handleApproveCommand)
+	bot.commands.Register("deny", "Discard a pending action: !deny <id>", bot.handleDenyCommand)
+	bot.commands.Register("status", "Show connection status and runtime stats", bot.handleStatusCommand)
+	bot.commands.Register("config", "Show the current configuration (secrets masked)", bot.handleConfigCommand)
+	bot.commands.Register("tools", "List tools available to the LLM", bot.handleToolsCommand)
+	bot.commands.Register("moderation", "List recent moderation violations: !moderation [count]", bot.handleModerationCommand)
+	bot.commands.Register("quota", "Show or set daily LLM quotas: !quota list | user|channel <id|here> [tokens=N] [usd=N] | default", bot.handleQuotaCommand)
+	bot.commands.Register("channels", "List the channels the bot was added to and their settings", bot.handleChannelsCommand)
+	bot.commands.Register("feature", "List or toggle runtime features: !feature [name on|off]", bot.handleFeatureCommand)
+	bot.commands.Register("digest", "Toggle the daily channel digest: !digest on|off|now <channel_id> | !digest list", bot.handleDigestCommand)
+	bot.commands.Register("welcome", "Toggle welcoming new members of a channel: !welcome on|off <channel_id|here> | !welcome list", bot.handleWelcomeCommand)
+	bot.commands.Register("audit", "Review recent tool calls: !audit [limit] [user:<id>] [channel:<id>] [tool:<name>] [since:<24h>]", bot.handleAuditCommand)
+	bot.commands.Register("standup", "List standups or run one now: !standup list | ask <name> | post <name>", bot.handleStandupCommand)
+	bot.commands.Register("usage", "Show or export monthly LLM usage and cost: !usage [month] | !usage export <channel_id> [month]", bot.handleUsageCommand)
 
 	// Create the agent with proper dependencies
-	llmAdapter := &LLMAdapter{backend: llmBackend}
-	decisionLLMAdapter := &LLMAdapter{backend: decisionLLMBackend}
-	chatAdapter := &ChatAdapter{bot: bot}
-	bot.agent = NewBotAgent(config.BotUserID, config.BotUsername, config.BotDisplayName, llmAdapter, decisionLLMAdapter, chatAdapter)
+	llmAdapter := &LLMAdapter{backend: bot.llmBackend, features: bot.features}
+	decisionLLMAdapter := &LLMAdapter{backend: bot.decisionLLMBackend, features: bot.features}
+	chatAdapter := &ChatAdapter{bot: bot, users: newUserCache(userCacheTTL), updates: newUpdateQueue()}
+	bot.chat = chatAdapter
+	bot.sentiment = bot.newSentimentMonitor(decisionLLMAdapter)
+	bot.actionItems = bot.newActionItems(decisionLLMAdapter)
+	var agentChat types.Chat = chatAdapter
+	if config.CanaryMode {
+		// A canary must never write to Mattermost on the agent's behalf
+		agentChat = &dryRunChat{Chat: chatAdapter}
+	}
+	agent := NewBotAgent(config.BotUserID, config.BotUsername, config.BotDisplayName, llmAdapter, decisionLLMAdapter, agentChat)
+	agent.commands = bot.commands
+	agent.features = bot.features
+	bot.commands.Register("threads", "List threads the bot is participating in", agent.handleThreadsCommand)
+	bot.commands.Register("sentiment", "Toggle private sentiment alerts for a channel: !sentiment on|off|list", bot.handleSentimentCommand)
+	bot.commands.Register("actionitems", "Offer Asana tasks for commitments made in a channel: !actionitems on <channel_id|here> <project_gid> | off <channel_id|here> | list", bot.handleActionItemsCommand)
+	if !config.CanaryMode {
+		agent.interceptors = append(agent.interceptors, bot.interceptStandupReply, bot.interceptMyTasks)
+		agent.observers = append(agent.observers, bot.observeSentiment, bot.observeActionItems)
+	}
+	if config.CanaryMode {
+		bot.canaryComparator = canary.NewComparator(canaryReplyTimeout, bot.reportCanaryComparison)
+		agent.replyObservers = append(agent.replyObservers, func(message types.PostedMessage, reply string) {
+			bot.canaryComparator.Canary(message.PostId, reply)
+		})
+	}
+	if config.CanaryURL != "" {
+		bot.canaryMirror = canary.NewMirror(config.CanaryURL, config.CanaryToken, nil)
+		agent.replyObservers = append(agent.replyObservers, bot.canaryMirror.Response)
+	}
+	agent.templates = bot.templates
+	agent.styles = bot.styles
+	agent.policies = bot.policies
+	agent.languages = bot.languages
+	agent.moderator = newModerator(config, fileConfig, decisionLLMAdapter, bot.prompts, bot.store)
+	agent.injection = newInjectionGuard(config, decisionLLMAdapter, bot.prompts)
+	agent.quotas = bot.quotas
+	agent.onboarding = newOnboarding(config, bot.registry, bot.store)
+	agent.prompts = bot.prompts
+	agent.thinking = bot.thinking
+	agent.contexts = bot.contexts
+	if bot.simpleBreaker != nil {
+		agent.simpleLLM = &LLMAdapter{backend: newRedactingBackend(bot.simpleBreaker, bot.redactor, bot.features), features: bot.features}
+		agent.routing = config.ModelRouting
+	}
+	agent.memory = bot.memory
+	transcriber, err := newTranscriber(config)
+	if err != nil {
+		log.Fatalf("Invalid transcription settings: %v", err)
+	}
+	agent.transcriber = transcriber
+	synthesizer, err := newSynthesizer(config)
+	if err != nil {
+		log.Fatalf("Invalid text-to-speech settings: %v", err)
+	}
+	if synthesizer != nil {
+		agent.speech = newReplySpeech(synthesizer, config.TTSFormat, bot.store)
+		agent.replyObservers = append(agent.replyObservers, agent.speakReply)
+		bot.commands.RegisterPublic("speech", "Turn audio versions of replies to you on or off: !speech [on|off]", bot.handleSpeechCommand)
+	}
+	agent.restoreThreads(bot.store)
+	agent.sharedThreads = sharesState(config)
+	agent.contextMaxMessages = config.ContextMaxMsgs
+	agent.contextMaxTokens = config.ContextMaxTokens
+	agent.decisionGuard = newDecisionGuard(config.DecisionMaxLatency, config.DecisionTokenBudget)
+	agent.debounce = newDebouncer(config.DebounceWindow)
+	agent.turns = newThreadTurns(config.ThreadReplyCooldown)
+	agent.threadTTL = config.ThreadTTL
+	if len(config.ReactionActions) > 0 {
+		agent.reactionActions = newReactionActions(config.ReactionActions, config.TranslateLanguage)
+	}
+	if index, err := knowledge.Open(config.KnowledgeIndexFile); err != nil {
+		log.Printf("[%s] KNOWLEDGE: Failed to load index, continuing without it: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	} else {
+		log.Printf("[%s] KNOWLEDGE: Loaded %d documents from %s", time.Now().Format("2006-01-02 15:04:05"), index.Len(), config.KnowledgeIndexFile)
+		agent.knowledge = index
+	}
+	bot.agent = agent
 
 	return bot
 }
@@ -84,10 +546,22 @@ func (b *Bot) handleWebSocketEvent(event *model.WebSocketEvent) {
 		return
 	}
 
+	ctx, span := tracing.Start(context.Background(), "mattermost.posted",
+		attribute.String("chat.post_id", post.Id),
+		attribute.String("chat.channel_id", post.ChannelId),
+		attribute.String("workspace", b.profileName()),
+	)
+	defer span.End()
+
 	// Extract channel type from event data
 	channelType, _ := event.GetData()["channel_type"].(string)
 	isDM := channelType == "D"
 
+	if !b.servesPost(eventTeamID(event), post.ChannelId, channelType) {
+		span.SetAttributes(attribute.Bool("workspace.filtered", true))
+		return
+	}
+
 	// Convert to PostedMessage and delegate to agent
 	message := types.PostedMessage{
 		PostId:    post.Id,
@@ -96,11 +570,171 @@ func (b *Bot) handleWebSocketEvent(event *model.WebSocketEvent) {
 		ChannelId: post.ChannelId,
 		Message:   post.Message,
 		IsDM:      isDM,
+		FileIds:   post.FileIds,
+		Mentioned: b.mentionsBot(event, post.Message),
 	}
+	b.dispatchMessage(ctx, message)
+}
 
+// dispatchMessage hands a message from Mattermost to the agent, or to the
+// workers in distributed mode
+func (b *Bot) dispatchMessage(ctx context.Context, message types.PostedMessage) {
+	stamp(&b.lastMessageAt)
+	if b.canaryMirror != nil {
+		b.canaryMirror.Event(message)
+	}
+	if b.queue != nil {
+		affinity := message.ThreadId
+		if affinity == "" {
+			affinity = message.PostId
+		}
+		b.publish(affinity, "post:"+message.PostId, distributed.Event{Kind: distributed.KindPosted, Message: &message})
+		return
+	}
+	if traced, ok := b.agent.(contextAgent); ok {
+		traced.MessagePostedContext(ctx, message)
+		return
+	}
 	b.agent.MessagePosted(message)
 }
 
+// contextAgent is an agent that can join the trace of the event that
+// delivered a message
+type contextAgent interface {
+	MessagePostedContext(ctx context.Context, message types.PostedMessage)
+}
+
+// mentionsBot reports whether a posted event mentions the bot. The server
+// resolves @username, @nickname and custom mention keys into the event's
+// "mentions" list of user IDs. The list is omitted when nobody is mentioned
+// (and by older servers), in which case an exact @username match is used.
+func (b *Bot) mentionsBot(event *model.WebSocketEvent, message string) bool {
+	if raw, ok := event.GetData()["mentions"].(string); ok {
+		var userIDs []string
+		if err := json.Unmarshal([]byte(raw), &userIDs); err == nil {
+			for _, id := range userIDs {
+				if id == b.config.BotUserID {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return mentionsUsername(message, b.config.BotUsername)
+}
+
+// mentionsUsername matches @username only where the mention ends, so that
+// @bot does not match @bot-admin or @bot.old
+func mentionsUsername(message, username string) bool {
+	mention := "@" + strings.ToLower(username)
+	text := strings.ToLower(message)
+	for i := strings.Index(text, mention); i >= 0; {
+		end := i + len(mention)
+		if end == len(text) || !isUsernameChar(text[end]) || (text[end] == '.' && (end+1 == len(text) || !isUsernameChar(text[end+1]))) {
+			if i == 0 || !isUsernameChar(text[i-1]) {
+				return true
+			}
+		}
+		next := strings.Index(text[end:], mention)
+		if next < 0 {
+			break
+		}
+		i = end + next
+	}
+	return false
+}
+
+func isUsernameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_'
+}
+
+// handlePostChangedEvent forwards edits and deletions of the bot's own posts to the agent
+func (b *Bot) handlePostChangedEvent(event *model.WebSocketEvent) {
+	postData, ok := event.GetData()["post"].(string)
+	if !ok {
+		return
+	}
+
+	var post model.Post
+	if err := json.Unmarshal([]byte(postData), &post); err != nil {
+		log.Printf("[%s] ERROR: Failed to parse changed post: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return
+	}
+
+	if post.UserId != b.config.BotUserID {
+		return
+	}
+
+	if b.queue != nil {
+		affinity := post.RootId
+		if affinity == "" {
+			affinity = post.Id
+		}
+		if event.EventType() == model.WebsocketEventPostDeleted {
+			b.publish(affinity, "", distributed.Event{Kind: distributed.KindDeleted, PostID: post.Id})
+		} else {
+			b.publish(affinity, "", distributed.Event{Kind: distributed.KindEdited, PostID: post.Id, Content: post.Message})
+		}
+		return
+	}
+
+	if event.EventType() == model.WebsocketEventPostDeleted {
+		b.agent.MessageDeleted(post.Id)
+	} else {
+		b.agent.MessageEdited(post.Id, post.Message)
+	}
+}
+
+// handleReactionEvent forwards reactions other users add to the agent
+func (b *Bot) handleReactionEvent(event *model.WebSocketEvent) {
+	reactionData, ok := event.GetData()["reaction"].(string)
+	if !ok {
+		return
+	}
+
+	var reaction model.Reaction
+	if err := json.Unmarshal([]byte(reactionData), &reaction); err != nil {
+		log.Printf("[%s] ERROR: Failed to parse reaction: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return
+	}
+
+	channelID := event.GetBroadcast().ChannelId
+	if reaction.UserId == b.config.BotUserID || !b.servesPost(eventTeamID(event), channelID, "") {
+		return
+	}
+
+	added := types.Reaction{
+		PostId:    reaction.PostId,
+		UserId:    reaction.UserId,
+		ChannelId: channelID,
+		Emoji:     reaction.EmojiName,
+	}
+	if b.queue != nil {
+		seenKey := "reaction:" + reaction.PostId + "|" + reaction.UserId + "|" + reaction.EmojiName
+		b.publish(b.reactionThread(reaction.PostId), seenKey, distributed.Event{Kind: distributed.KindReaction, Reaction: &added})
+		return
+	}
+	b.agent.ReactionAdded(added)
+}
+
+// handleUserAddedEvent tells the agent when the bot itself is added to a
+// channel and welcomes other users joining channels with welcomes on
+func (b *Bot) handleUserAddedEvent(event *model.WebSocketEvent) {
+	userID, _ := event.GetData()["user_id"].(string)
+	channelID := event.GetBroadcast().ChannelId
+	if userID == "" || channelID == "" || !b.servesPost(eventTeamID(event), channelID, "") {
+		return
+	}
+	if userID == b.config.BotUserID && b.queue != nil {
+		b.publish(channelID, "", distributed.Event{Kind: distributed.KindJoined, ChannelID: channelID})
+	} else if userID == b.config.BotUserID {
+		b.agent.ChannelJoined(channelID)
+	} else if b.welcomeEnabled(channelID) {
+		// Writing the welcome takes an LLM call; don't hold up other events
+		go b.welcomeMember(channelID, userID)
+	}
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -109,10 +743,10 @@ func min(a, b int) int {
 }
 
 func (b *Bot) connectWebSocket() error {
-	wsURL := strings.Replace(b.config.ServerURL, "http://", "ws://", 1)
+	wsURL := websocketURL(b.config.ServerURL)
 	log.Printf("[%s] WEBSOCKET: Connecting to %s", time.Now().Format("2006-01-02 15:04:05"), wsURL)
 
-	wsClient, err := model.NewWebSocketClient4(wsURL, b.client.AuthToken)
+	wsClient, err := model.NewWebSocketClient4WithDialer(b.wsDialer, wsURL, b.client.AuthToken)
 	if err != nil {
 		return fmt.Errorf("failed to create WebSocket client: %v", err)
 	}
@@ -155,9 +789,16 @@ func (b *Bot) handleWebSocketReconnection() {
 
 func (b *Bot) startEventListener() {
 	go func() {
+		// the event being handled, to tell which conversation a panic came from
+		var current *model.WebSocketEvent
 		defer func() {
 			if r := recover(); r != nil {
 				log.Printf("[%s] WEBSOCKET: Event listener panicked: %v", time.Now().Format("2006-01-02 15:04:05"), r)
+				tags := []string{"workspace", b.profileName()}
+				if current != nil {
+					tags = append(tags, "event", string(current.EventType()), "channel_id", current.GetBroadcast().ChannelId)
+				}
+				errorsink.ReportPanic(context.Background(), r, tags...)
 				b.wsClient = nil
 			}
 		}()
@@ -175,11 +816,20 @@ func (b *Bot) startEventListener() {
 					b.wsClient = nil
 					return
 				}
+				stamp(&b.lastEventAt)
+				current = event
 
-				if event.EventType() == model.WebsocketEventPosted {
+				switch event.EventType() {
+				case model.WebsocketEventPosted:
 					log.Printf("[%s] EVENT: Received post event", time.Now().Format("2006-01-02 15:04:05"))
 					b.handleWebSocketEvent(event)
-				} else {
+				case model.WebsocketEventPostEdited, model.WebsocketEventPostDeleted:
+					b.handlePostChangedEvent(event)
+				case model.WebsocketEventReactionAdded:
+					b.handleReactionEvent(event)
+				case model.WebsocketEventUserAdded:
+					b.handleUserAddedEvent(event)
+				default:
 					log.Printf("[%s] EVENT: Received event type: %s", time.Now().Format("2006-01-02 15:04:05"), event.EventType())
 				}
 			case <-b.stopChan:
@@ -189,58 +839,109 @@ func (b *Bot) startEventListener() {
 	}()
 }
 
-func (b *Bot) start() {
-	log.Printf("[%s] STARTUP: Starting agent bot...", time.Now().Format("2006-01-02 15:04:05"))
+// start connects the workspace to Mattermost, starts its background jobs and
+// registers its HTTP routes on mux
+func (b *Bot) start(mux *http.ServeMux) {
+	log.Printf("[%s] STARTUP: Starting agent bot for %s...", time.Now().Format("2006-01-02 15:04:05"), b.profileName())
 	log.Printf("[%s] CONFIG: Server URL: %s", time.Now().Format("2006-01-02 15:04:05"), b.config.ServerURL)
 	log.Printf("[%s] CONFIG: Bot User ID: %s", time.Now().Format("2006-01-02 15:04:05"), b.config.BotUserID)
 
-	// Initial WebSocket connection
-	if err := b.connectWebSocket(); err != nil {
-		log.Fatalf("[%s] FATAL: Failed to connect to WebSocket: %v", time.Now().Format("2006-01-02 15:04:05"), err)
-	}
+	if b.config.CanaryMode {
+		// Canaries get their messages from the live bot and never post on their own
+		b.startCanary()
+	} else if b.worker != nil {
+		// Workers get their events from the gateway, which also runs the
+		// schedules and serves the HTTP API
+		go b.worker.Run(context.Background())
+	} else {
+		if b.config.EventSource == eventSourceWebhook {
+			// Mattermost posts messages to us instead of over a websocket
+			mux.HandleFunc("/mattermost/outgoing", b.handleOutgoingWebhook)
+			mux.HandleFunc("/mattermost/command", b.handleSlashCommand)
+			log.Printf("[%s] WEBHOOK_EVENTS: Receiving messages from outgoing webhooks and slash commands", time.Now().Format("2006-01-02 15:04:05"))
+		} else {
+			// Initial WebSocket connection
+			if err := b.connectWebSocket(); err != nil {
+				log.Fatalf("[%s] FATAL: Failed to connect to WebSocket: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+			}
 
-	// Start event listener
-	b.startEventListener()
+			// Start event listener
+			b.startEventListener()
 
-	// Start reconnection handler
-	b.handleWebSocketReconnection()
+			// Start reconnection handler
+			b.handleWebSocketReconnection()
+		}
 
-	// Keep HTTP server for health checks
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[%s] HEALTH: Health check requested", time.Now().Format("2006-01-02 15:04:05"))
-		status := "OK"
-		if !b.isWebSocketConnected() {
-			status = "WebSocket Disconnected"
+		// Mirror traffic to the canary
+		if b.canaryMirror != nil {
+			go b.canaryMirror.Run()
 		}
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(status))
-	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
+		// Post monthly exports
+		b.runMonthlyUsageExport()
+
+		// Scheduled jobs
+		b.scheduler.Start()
+
+		// External message API authenticated with scoped API keys
+		mux.HandleFunc("/api/v1/messages", b.handleAPIMessage)
+
+		// OpenAI-compatible chat completions answered by the agent, with the same keys
+		mux.HandleFunc("/v1/chat/completions", b.handleChatCompletions)
+		mux.HandleFunc("/v1/models", b.handleModels)
+
+		// Incoming webhooks routed through notification rules
+		mux.HandleFunc("/webhooks/", b.handleWebhook)
+
+		// Named hooks whose payloads the agent triages into a channel
+		mux.HandleFunc("/hooks/", b.handleHook)
+
+		// Admin usage export and tool audit trail authenticated with ADMIN_API_TOKEN
+		mux.HandleFunc("/admin/usage", b.handleAdminUsage)
+		mux.HandleFunc("/admin/audit", b.handleAdminAudit)
+
+		// Admin web UI over the same token, also accepted as the basic auth password
+		mux.HandleFunc("/admin/dashboard", b.handleDashboard)
+		mux.HandleFunc("/admin/dashboard/state", b.handleDashboardState)
+		mux.HandleFunc("/admin/dashboard/features", b.handleDashboardFeature)
+		mux.HandleFunc("/admin/dashboard/channels", b.handleDashboardChannel)
+
+		// Clicks on the buttons of action item offers and task briefings
+		mux.HandleFunc(actionItemsPath, b.handleActionItemButton)
+		mux.HandleFunc(myTasksPath, b.handleMyTaskButton)
 	}
 
-	log.Printf("[%s] SERVER: Bot listening on port %s (WebSocket connected)", time.Now().Format("2006-01-02 15:04:05"), port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// Persist usage counters
+	go b.usage.Run(context.Background(), time.Minute)
 }
 
 // LLMAdapter adapts llms.LLMBackend to types.LLM interface
 type LLMAdapter struct {
-	backend llms.LLMBackend
+	backend  llms.LLMBackend
+	features *Features
 }
 
-func (l *LLMAdapter) Prompt(message string) (string, error) {
-	return l.backend.Prompt(context.Background(), message)
+// requestContext applies runtime feature flags to an LLM request
+func (l *LLMAdapter) requestContext(ctx context.Context) context.Context {
+	if l.features != nil && !l.features.Enabled(FeatureTools) {
+		return llms.WithoutTools(ctx)
+	}
+	return ctx
+}
+
+func (l *LLMAdapter) Prompt(ctx context.Context, message string) (string, error) {
+	return l.backend.Prompt(l.requestContext(ctx), message)
 }
 
 func (l *LLMAdapter) PromptStream(ctx context.Context, message string) (<-chan types.StreamChunk, error) {
-	return l.backend.PromptStream(ctx, message)
+	return l.backend.PromptStream(l.requestContext(ctx), message)
 }
 
 // ChatAdapter adapts Bot to types.Chat interface
 type ChatAdapter struct {
-	bot *Bot
+	bot     *Bot
+	users   *userCache
+	updates *updateQueue
 }
 
 func (c *ChatAdapter) PostMessage(message types.ChatMessage) (string, error) {
@@ -249,6 +950,9 @@ func (c *ChatAdapter) PostMessage(message types.ChatMessage) (string, error) {
 		Message:   message.Message,
 		RootId:    message.ThreadId,
 	}
+	if len(message.Attachments) > 0 {
+		post.AddProp("attachments", mattermostAttachments(message.Attachments))
+	}
 
 	createdPost, _, err := c.bot.client.CreatePost(post)
 	if err != nil {
@@ -258,13 +962,66 @@ func (c *ChatAdapter) PostMessage(message types.ChatMessage) (string, error) {
 	return createdPost.Id, nil
 }
 
+// mattermostAttachments converts cards to message attachments. Mattermost
+// buttons call an integration rather than open a URL, so link buttons become
+// links at the end of the card's text.
+func mattermostAttachments(cards []types.Attachment) []*model.SlackAttachment {
+	attachments := make([]*model.SlackAttachment, 0, len(cards))
+	for _, card := range cards {
+		attachment := &model.SlackAttachment{
+			Fallback:   card.Fallback,
+			Color:      card.Color,
+			Pretext:    card.Pretext,
+			AuthorName: card.AuthorName,
+			AuthorLink: card.AuthorLink,
+			AuthorIcon: card.AuthorIcon,
+			Title:      card.Title,
+			TitleLink:  card.TitleLink,
+			Text:       strings.TrimSpace(card.Text + "\n\n" + card.ButtonLinks()),
+			Footer:     card.Footer,
+		}
+		for _, field := range card.Fields {
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: field.Title,
+				Value: field.Value,
+				Short: model.SlackCompatibleBool(field.Short),
+			})
+		}
+		for _, action := range card.Actions {
+			attachment.Actions = append(attachment.Actions, &model.PostAction{
+				Id:          action.ID,
+				Type:        model.PostActionTypeButton,
+				Name:        action.Label,
+				Style:       action.Style,
+				Integration: &model.PostActionIntegration{URL: action.URL, Context: action.Context},
+			})
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// UpdateMessage replaces a post's content. Rapid updates to the same post
+// (streaming) are coalesced so only the newest content is written.
 func (c *ChatAdapter) UpdateMessage(messageID string, newContent string) error {
+	return c.updates.submit(messageID, newContent, c.writeMessage)
+}
+
+func (c *ChatAdapter) writeMessage(messageID string, newContent string) error {
 	// Get the existing post
-	post, _, err := c.bot.client.GetPost(messageID, "")
+	post, resp, err := c.bot.client.GetPost(messageID, "")
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return types.ErrMessageDeleted
+		}
 		return fmt.Errorf("failed to get post for update: %v", err)
 	}
 
+	// Updating a deleted post would bring its content back
+	if post.DeleteAt != 0 {
+		return types.ErrMessageDeleted
+	}
+
 	// Update the message content
 	post.Message = newContent
 
@@ -281,7 +1038,7 @@ func (c *ChatAdapter) SendTypingIndicator(channelID, threadID string) error {
 		ChannelId: channelID,
 		ParentId:  threadID,
 	}
-	
+
 	_, err := c.bot.client.PublishUserTyping(c.bot.config.BotUserID, typingRequest)
 	return err
 }
@@ -291,7 +1048,7 @@ func (c *ChatAdapter) GetMessage(messageID string) (*types.Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	
+
 	return &types.Message{
 		ID:        post.Id,
 		UserID:    post.UserId,
@@ -302,38 +1059,268 @@ func (c *ChatAdapter) GetMessage(messageID string) (*types.Message, error) {
 	}, nil
 }
 
+// threadPageSize is how many posts are fetched per page of a long thread
+const threadPageSize = 200
+
 func (c *ChatAdapter) GetThreadMessages(threadID string) ([]*types.Message, error) {
-	threadPosts, _, err := c.bot.client.GetPostThread(threadID, "", true)
-	if err != nil {
-		return nil, err
-	}
-	
-	messages := make([]*types.Message, 0, len(threadPosts.Posts))
-	for _, post := range threadPosts.Posts {
-		messages = append(messages, &types.Message{
-			ID:        post.Id,
-			UserID:    post.UserId,
-			ChannelID: post.ChannelId,
-			ThreadID:  post.RootId,
-			Content:   post.Message,
-			Timestamp: post.CreateAt,
-		})
+	opts := model.GetPostsOptions{PerPage: threadPageSize, Direction: "down"}
+	seen := make(map[string]bool)
+	var messages []*types.Message
+
+	// Long threads are paged oldest first; servers without thread paging
+	// return everything in one response with HasNext unset
+	for {
+		threadPosts, _, err := c.bot.client.GetPostThreadWithOpts(threadID, "", opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var last *model.Post
+		for _, post := range threadPosts.Posts {
+			if last == nil || post.CreateAt > last.CreateAt {
+				last = post
+			}
+			if seen[post.Id] {
+				continue
+			}
+			seen[post.Id] = true
+			messages = append(messages, &types.Message{
+				ID:        post.Id,
+				UserID:    post.UserId,
+				ChannelID: post.ChannelId,
+				ThreadID:  post.RootId,
+				Content:   post.Message,
+				Timestamp: post.CreateAt,
+			})
+		}
+
+		if !threadPosts.HasNext || last == nil || last.Id == opts.FromPost {
+			break
+		}
+		opts.FromPost = last.Id
+		opts.FromCreateAt = last.CreateAt
 	}
-	
+
 	return messages, nil
 }
 
 func (c *ChatAdapter) GetUser(userID string) (*types.User, error) {
+	if cached, ok := c.users.get(userID); ok {
+		return cached, nil
+	}
+
 	user, _, err := c.bot.client.GetUser(userID, "")
 	if err != nil {
 		return nil, err
 	}
-	
-	return &types.User{
+
+	result := &types.User{
 		ID:       user.Id,
 		Username: user.Username,
 		IsBot:    user.IsBot,
-	}, nil
+	}
+	c.users.put(result)
+	return result, nil
+}
+
+func (c *ChatAdapter) GetChannel(channelID string) (*types.Channel, error) {
+	channel, _, err := c.bot.client.GetChannel(channelID, "")
+	if err != nil {
+		return nil, err
+	}
+	result := &types.Channel{
+		ID:          channel.Id,
+		Name:        channel.Name,
+		DisplayName: channel.DisplayName,
+		Purpose:     channel.Purpose,
+		Header:      channel.Header,
+		Private:     channel.Type != model.ChannelTypeOpen,
+	}
+	if channel.TeamId != "" {
+		team, _, err := c.bot.client.GetTeam(channel.TeamId, "")
+		if err != nil {
+			return nil, err
+		}
+		result.Team = team.Name
+	}
+	return result, nil
+}
+
+// Limits on image attachments forwarded to the LLM, matching what the vision API accepts
+const (
+	maxImageAttachments = 5
+	maxImageBytes       = 5 * 1024 * 1024
+)
+
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Limits on voice messages and audio attachments sent for transcription;
+// 25 MB is the largest upload the Whisper API accepts
+const (
+	maxAudioAttachments = 3
+	maxAudioBytes       = 25 * 1024 * 1024
+)
+
+// PostEphemeral shows a message to one user. The bot needs the
+// create_post_ephemeral permission, which system admins have.
+func (c *ChatAdapter) PostEphemeral(userID string, message types.ChatMessage) error {
+	ephemeral := &model.PostEphemeral{
+		UserID: userID,
+		Post: &model.Post{
+			ChannelId: message.ChannelId,
+			Message:   message.Message,
+			RootId:    message.ThreadId,
+		},
+	}
+	if _, _, err := c.bot.client.CreatePostEphemeral(ephemeral); err != nil {
+		return fmt.Errorf("failed to post ephemeral message: %v", err)
+	}
+	return nil
+}
+
+// UploadFile uploads the file to the channel and posts it with the message
+func (c *ChatAdapter) UploadFile(upload types.FileUpload) error {
+	uploaded, _, err := c.bot.client.UploadFile(upload.Data, upload.ChannelId, upload.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", upload.Filename, err)
+	}
+	if len(uploaded.FileInfos) == 0 {
+		return fmt.Errorf("failed to upload %s: no file returned", upload.Filename)
+	}
+
+	post := &model.Post{
+		ChannelId: upload.ChannelId,
+		Message:   upload.Message,
+		RootId:    upload.ThreadId,
+		FileIds:   model.StringArray{uploaded.FileInfos[0].Id},
+	}
+	if _, _, err := c.bot.client.CreatePost(post); err != nil {
+		return fmt.Errorf("failed to post %s: %v", upload.Filename, err)
+	}
+	return nil
+}
+
+func (c *ChatAdapter) AddReaction(messageID, emoji string) error {
+	reaction := &model.Reaction{UserId: c.bot.config.BotUserID, PostId: messageID, EmojiName: emoji}
+	if _, _, err := c.bot.client.SaveReaction(reaction); err != nil {
+		return fmt.Errorf("failed to add reaction: %v", err)
+	}
+	return nil
+}
+
+func (c *ChatAdapter) RemoveReaction(messageID, emoji string) error {
+	reaction := &model.Reaction{UserId: c.bot.config.BotUserID, PostId: messageID, EmojiName: emoji}
+	if _, err := c.bot.client.DeleteReaction(reaction); err != nil {
+		return fmt.Errorf("failed to remove reaction: %v", err)
+	}
+	return nil
+}
+
+func (c *ChatAdapter) GetImages(fileIDs []string) ([]types.Image, error) {
+	var images []types.Image
+	for _, fileID := range fileIDs {
+		if len(images) >= maxImageAttachments {
+			log.Printf("[%s] IMAGES: Skipping attachments beyond the first %d images", time.Now().Format("2006-01-02 15:04:05"), maxImageAttachments)
+			break
+		}
+
+		info, _, err := c.bot.client.GetFileInfo(fileID)
+		if err != nil {
+			return images, fmt.Errorf("failed to get file info for %s: %v", fileID, err)
+		}
+		if !supportedImageTypes[info.MimeType] {
+			continue
+		}
+		if info.Size > maxImageBytes {
+			log.Printf("[%s] IMAGES: Skipping %s, %d bytes is over the %d byte limit", time.Now().Format("2006-01-02 15:04:05"), info.Name, info.Size, maxImageBytes)
+			continue
+		}
+
+		data, _, err := c.bot.client.GetFile(fileID)
+		if err != nil {
+			return images, fmt.Errorf("failed to download %s: %v", info.Name, err)
+		}
+		images = append(images, types.Image{Name: info.Name, MediaType: info.MimeType, Data: data})
+	}
+	return images, nil
+}
+
+func (c *ChatAdapter) GetAudio(fileIDs []string) ([]types.Audio, error) {
+	var audio []types.Audio
+	for _, fileID := range fileIDs {
+		if len(audio) >= maxAudioAttachments {
+			log.Printf("[%s] TRANSCRIBE: Skipping attachments beyond the first %d audio files", time.Now().Format("2006-01-02 15:04:05"), maxAudioAttachments)
+			break
+		}
+
+		info, _, err := c.bot.client.GetFileInfo(fileID)
+		if err != nil {
+			return audio, fmt.Errorf("failed to get file info for %s: %v", fileID, err)
+		}
+		if !strings.HasPrefix(info.MimeType, "audio/") {
+			continue
+		}
+		if info.Size > maxAudioBytes {
+			log.Printf("[%s] TRANSCRIBE: Skipping %s, %d bytes is over the %d byte limit", time.Now().Format("2006-01-02 15:04:05"), info.Name, info.Size, maxAudioBytes)
+			continue
+		}
+
+		data, _, err := c.bot.client.GetFile(fileID)
+		if err != nil {
+			return audio, fmt.Errorf("failed to download %s: %v", info.Name, err)
+		}
+		audio = append(audio, types.Audio{Name: info.Name, MediaType: info.MimeType, Data: data})
+	}
+	return audio, nil
+}
+
+// directChannel returns the ID of the DM channel between the bot and userID, creating it if needed
+func (b *Bot) directChannel(userID string) (string, error) {
+	channel, _, err := b.client.CreateDirectChannel(b.config.BotUserID, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to open direct channel: %v", err)
+	}
+	return channel.Id, nil
+}
+
+// sendDirectMessage posts a message to the DM channel between the bot and
+// userID, held until they are available when the quiet hours policy says so
+func (b *Bot) sendDirectMessage(userID, message string) error {
+	channelID, err := b.directChannel(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.notifyUser(userID, channelID, message, time.Time{})
+	return err
+}
+
+// newToolSelector builds the tool preselector, or nil when preselection is off
+func newToolSelector(config Config) (*tools.Selector, error) {
+	if config.ToolPreselectTopK <= 0 {
+		return nil, nil
+	}
+
+	var embedder tools.Embedder
+	switch config.EmbeddingsProvider {
+	case "hashing":
+		embedder = embeddings.NewHashing()
+	case "voyage":
+		if config.VoyageAPIKey == "" {
+			return nil, fmt.Errorf("EMBEDDINGS_PROVIDER=voyage requires VOYAGE_API_KEY")
+		}
+		embedder = embeddings.NewVoyage(config.VoyageAPIKey, config.VoyageModel, &http.Client{Timeout: 10 * time.Second})
+	default:
+		return nil, fmt.Errorf("unknown EMBEDDINGS_PROVIDER %q (use hashing or voyage)", config.EmbeddingsProvider)
+	}
+
+	log.Printf("[%s] TOOLS: Preselecting the top %d tools per request using %s embeddings", time.Now().Format("2006-01-02 15:04:05"), config.ToolPreselectTopK, config.EmbeddingsProvider)
+	return tools.NewSelector(embedder, config.ToolPreselectTopK, config.ToolPreselectSampleRate), nil
 }
 
 // getEnvWithDefault returns environment variable value or default if not set
@@ -354,18 +1341,79 @@ func getEnvIntWithDefault(key string, defaultValue int) int {
 	return defaultValue
 }
 
-func main() {
+// getEnvFloatWithDefault returns environment variable as float or default if not set
+func getEnvFloatWithDefault(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvBool reports whether an environment variable is set to a true value
+func getEnvBool(key string) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	return err == nil && value
+}
+
+// getEnvList returns a comma-separated environment variable as a list
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// startMCPServers launches the configured stdio MCP servers and bridges their tools.
+// Servers that fail to start are logged and skipped.
+func startMCPServers(servers []mcpclient.ServerConfig, registry *tools.Registry) []*mcpclient.Client {
+	var clients []*mcpclient.Client
+	for _, server := range servers {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		client, err := mcpclient.Start(ctx, server)
+		if err != nil {
+			cancel()
+			log.Printf("[%s] MCP: Failed to start stdio server %s: %v", time.Now().Format("2006-01-02 15:04:05"), server.Name, err)
+			continue
+		}
+
+		if _, err := client.RegisterTools(ctx, registry); err != nil {
+			log.Printf("[%s] MCP: Failed to list tools from %s: %v", time.Now().Format("2006-01-02 15:04:05"), server.Name, err)
+		}
+		cancel()
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// runServer configures the bot from the environment and runs it until it
+// exits. The plugin build runs it inside Mattermost.
+func runServer() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Warning: .env file not found: %v", err)
 	}
 
+	// Offline subcommands run without connecting to Mattermost
+	if len(os.Args) > 1 && os.Args[1] == "import-knowledge" {
+		if err := runImportKnowledge(os.Args[2:]); err != nil {
+			log.Fatalf("Knowledge import failed: %v", err)
+		}
+		return
+	}
+
+	replMode := flag.Bool("repl", false, "chat with the agent in the terminal instead of connecting to a chat server")
+	flag.Parse()
+
 	config := Config{
 		ServerURL:         os.Getenv("MATTERMOST_SERVER_URL"),
 		AccessToken:       os.Getenv("MATTERMOST_ACCESS_TOKEN"),
 		BotUserID:         os.Getenv("MATTERMOST_BOT_USER_ID"),
-		BotUsername:       getEnvWithDefault("BOT_USERNAME", "agent-bot"),
-		BotDisplayName:    getEnvWithDefault("BOT_DISPLAY_NAME", "Assistant"),
+		BotDisplayName:    os.Getenv("BOT_DISPLAY_NAME"),
 		AnthropicKey:      os.Getenv("ANTHROPIC_API_KEY"),
 		AnthropicModel:    getEnvWithDefault("ANTHROPIC_MODEL", "claude-sonnet-4-20250514"),
 		MaxTokens:         getEnvIntWithDefault("LLM_MAX_TOKENS", 4096),
@@ -373,24 +1421,651 @@ func main() {
 		DecisionModel:     getEnvWithDefault("DECISION_MODEL", "claude-haiku-3.5-20241022"),
 		DecisionMaxTokens: getEnvIntWithDefault("DECISION_MAX_TOKENS", 512),
 		AsanaKey:          os.Getenv("ASANA_API_KEY"),
+		AsanaCacheTTL:     time.Duration(getEnvIntWithDefault("ASANA_CACHE_TTL_SECONDS", 300)) * time.Second,
+		AsanaRateLimit:    getEnvIntWithDefault("ASANA_REQUESTS_PER_MINUTE", asana.DefaultRequestsPerMinute),
+		AsanaWebhookURL:   os.Getenv("ASANA_WEBHOOK_URL"),
+		ButtonCallbackURL: os.Getenv("BUTTON_CALLBACK_URL"),
+		JiraBaseURL:       os.Getenv("JIRA_BASE_URL"),
+		JiraEmail:         os.Getenv("JIRA_EMAIL"),
+		JiraAPIToken:      os.Getenv("JIRA_API_TOKEN"),
+		PagerDutyAPIToken: os.Getenv("PAGERDUTY_API_TOKEN"),
+		PagerDutyEmail:    os.Getenv("PAGERDUTY_FROM_EMAIL"),
+		PrometheusURL:     os.Getenv("PROMETHEUS_URL"),
+		PrometheusToken:   os.Getenv("PROMETHEUS_BEARER_TOKEN"),
+		KubeConfig:        os.Getenv("KUBECONFIG"),
+		KubeNamespaces:    getEnvList("KUBE_NAMESPACES"),
+		KrokiURL:          os.Getenv("KROKI_URL"),
+		ImageProvider:     strings.ToLower(getEnvWithDefault("IMAGE_PROVIDER", imageOff)),
+		ImageAPIURL:       os.Getenv("IMAGE_API_URL"),
+		ImageAPIKey:       os.Getenv("IMAGE_API_KEY"),
+		ImageModel:        os.Getenv("IMAGE_MODEL"),
+		WikiProvider:      strings.ToLower(getEnvWithDefault("WIKI_PROVIDER", wikiOff)),
+		WikiURL:           os.Getenv("WIKI_URL"),
+		WikiEmail:         os.Getenv("WIKI_EMAIL"),
+		WikiAPIToken:      os.Getenv("WIKI_API_TOKEN"),
+		WikiSpaces:        getEnvList("WIKI_SPACES"),
+		WikiMaxChars:      getEnvIntWithDefault("WIKI_MAX_CHARS", wiki.DefaultMaxChars),
+		AdminUserIDs:      getEnvList("ADMIN_USER_IDS"),
+		TeamIDs:           getEnvList("MATTERMOST_TEAM_IDS"),
+		StateFile:         getEnvWithDefault("STATE_FILE", "data/state.json"),
+		StateDatabaseURL:  os.Getenv("STATE_DATABASE_URL"),
+		ConfigFile:        getEnvWithDefault("CONFIG_FILE", "config.yaml"),
+		PromptsDir:        os.Getenv("PROMPTS_DIR"),
+		ContextMaxMsgs:    getEnvIntWithDefault("CONTEXT_MAX_MESSAGES", defaultContextMaxMessages),
+		ContextMaxTokens:  getEnvIntWithDefault("CONTEXT_MAX_TOKENS", defaultContextMaxTokens),
+		ContextStrategy:   strings.ToLower(getEnvWithDefault("CONTEXT_STRATEGY", contextSummarized)),
+
+		DecisionMaxLatency:  time.Duration(getEnvIntWithDefault("DECISION_MAX_MEDIAN_LATENCY_MS", 3000)) * time.Millisecond,
+		DecisionTokenBudget: getEnvIntWithDefault("DECISION_TOKEN_BUDGET_PER_HOUR", 0),
+		DebounceWindow:      time.Duration(getEnvIntWithDefault("MESSAGE_DEBOUNCE_MS", 1500)) * time.Millisecond,
+		ThreadReplyCooldown: time.Duration(getEnvIntWithDefault("THREAD_REPLY_COOLDOWN_MINUTES", 5)) * time.Minute,
+		ThreadTTL:           time.Duration(getEnvIntWithDefault("THREAD_TTL_HOURS", 24)) * time.Hour,
+
+		TranslateLanguage: getEnvWithDefault("TRANSLATE_LANGUAGE", "English"),
+		DefaultLanguage:   language.Name(os.Getenv("DEFAULT_LANGUAGE")),
+
+		ModerationProfanity:  getEnvWithDefault("MODERATION_PROFANITY", "off"),
+		ModerationClassifier: getEnvWithDefault("MODERATION_CLASSIFIER", "off"),
+		ModerationClassify:   getEnvWithDefault("MODERATION_CLASSIFY", "both"),
+		ModerationCategories: getEnvWithDefault("MODERATION_CATEGORIES", defaultModerationCategories),
+		ModerationAPIURL:     getEnvWithDefault("MODERATION_API_URL", moderation.DefaultAPIURL),
+		ModerationAPIKey:     os.Getenv("MODERATION_API_KEY"),
+		ModerationAPIModel:   os.Getenv("MODERATION_API_MODEL"),
+
+		PromptInjection:     getEnvWithDefault("PROMPT_INJECTION", injectionFlag),
+		InjectionClassifier: getEnvWithDefault("INJECTION_CLASSIFIER", "off"),
+
+		UserQuota:    quotaLimit{Tokens: int64(getEnvIntWithDefault("QUOTA_USER_DAILY_TOKENS", 0)), USD: getEnvFloatWithDefault("QUOTA_USER_DAILY_USD", 0)},
+		ChannelQuota: quotaLimit{Tokens: int64(getEnvIntWithDefault("QUOTA_CHANNEL_DAILY_TOKENS", 0)), USD: getEnvFloatWithDefault("QUOTA_CHANNEL_DAILY_USD", 0)},
+		ChannelIntro: getEnvWithDefault("CHANNEL_INTRO", "true") != "false",
+
+		AdminAPIToken:      os.Getenv("ADMIN_API_TOKEN"),
+		UsageExportChannel: os.Getenv("USAGE_EXPORT_CHANNEL_ID"),
+		GRPCPort:           os.Getenv("GRPC_PORT"),
+
+		GitHubWebhookSecret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
+		GitLabWebhookToken:  os.Getenv("GITLAB_WEBHOOK_TOKEN"),
+
+		TLSCAFile:             os.Getenv("MATTERMOST_CA_FILE"),
+		TLSInsecureSkipVerify: getEnvBool("MATTERMOST_TLS_INSECURE_SKIP_VERIFY"),
+		WebSocketDialTimeout:  time.Duration(getEnvIntWithDefault("WEBSOCKET_DIAL_TIMEOUT_SECONDS", 10)) * time.Second,
+
+		DigestTime:     getEnvWithDefault("DIGEST_TIME", "09:00"),
+		DigestTimezone: getEnvWithDefault("DIGEST_TIMEZONE", "UTC"),
+		BriefingTime:   getEnvWithDefault("BRIEFING_TIME", "08:30"),
+
+		KnowledgeIndexFile: getEnvWithDefault("KNOWLEDGE_INDEX_FILE", "data/knowledge.json"),
+
+		ToolPreselectTopK:       getEnvIntWithDefault("TOOL_PRESELECT_TOP_K", 0),
+		ToolPreselectSampleRate: getEnvFloatWithDefault("TOOL_PRESELECT_SAMPLE_RATE", 0.05),
+		EmbeddingsProvider:      getEnvWithDefault("EMBEDDINGS_PROVIDER", "hashing"),
+		VoyageAPIKey:            os.Getenv("VOYAGE_API_KEY"),
+		VoyageModel:             getEnvWithDefault("VOYAGE_MODEL", "voyage-3.5-lite"),
+
+		TranscriptionProvider: strings.ToLower(getEnvWithDefault("TRANSCRIPTION_PROVIDER", transcriptionOff)),
+		TranscriptionAPIURL:   getEnvWithDefault("TRANSCRIPTION_API_URL", transcribe.DefaultOpenAIURL),
+		TranscriptionAPIKey:   os.Getenv("TRANSCRIPTION_API_KEY"),
+		TranscriptionModel:    getEnvWithDefault("TRANSCRIPTION_MODEL", "whisper-1"),
+		TranscriptionCommand:  os.Getenv("TRANSCRIPTION_COMMAND"),
+
+		TTSProvider: strings.ToLower(getEnvWithDefault("TTS_PROVIDER", speechOff)),
+		TTSAPIURL:   getEnvWithDefault("TTS_API_URL", speech.DefaultOpenAIURL),
+		TTSAPIKey:   os.Getenv("TTS_API_KEY"),
+		TTSModel:    getEnvWithDefault("TTS_MODEL", "tts-1"),
+		TTSVoice:    getEnvWithDefault("TTS_VOICE", "alloy"),
+		TTSFormat:   strings.ToLower(getEnvWithDefault("TTS_FORMAT", "mp3")),
+		TTSCommand:  os.Getenv("TTS_COMMAND"),
+
+		CanaryURL:           os.Getenv("CANARY_URL"),
+		CanaryToken:         os.Getenv("CANARY_TOKEN"),
+		CanaryMode:          getEnvBool("CANARY_MODE"),
+		CanaryReportChannel: os.Getenv("CANARY_REPORT_CHANNEL_ID"),
+
+		DistributedMode:       strings.ToLower(getEnvWithDefault("DISTRIBUTED_MODE", distributedOff)),
+		RedisURL:              os.Getenv("REDIS_URL"),
+		LeaderElection:        getEnvBool("LEADER_ELECTION"),
+		RedisPrefix:           getEnvWithDefault("REDIS_PREFIX", "agent-bot:"),
+		DistributedPartitions: getEnvIntWithDefault("DISTRIBUTED_PARTITIONS", 32),
+
+		EventSource:   strings.ToLower(getEnvWithDefault("EVENT_SOURCE", eventSourceWebsocket)),
+		WebhookTokens: getEnvList("MATTERMOST_WEBHOOK_TOKENS"),
+
+		StartupSelfTest: getEnvWithDefault("STARTUP_SELF_TEST", "true") != "false",
+
+		SentryDSN:         os.Getenv("SENTRY_DSN"),
+		SentryEnvironment: getEnvWithDefault("SENTRY_ENVIRONMENT", "production"),
+		ErrorWebhookURL:   os.Getenv("ERROR_WEBHOOK_URL"),
+
+		ToolTimeout:     time.Duration(getEnvIntWithDefault("TOOL_TIMEOUT_SECONDS", 30)) * time.Second,
+		ToolParallelism: getEnvIntWithDefault("TOOL_PARALLELISM", 4),
+
+		ToolMaxTurns:         getEnvIntWithDefault("TOOL_MAX_TURNS", 10),
+		ToolMaxRequestTokens: getEnvIntWithDefault("TOOL_MAX_REQUEST_TOKENS", 200000),
+
+		LLMBreakerThreshold: getEnvIntWithDefault("LLM_BREAKER_THRESHOLD", 5),
+		LLMBreakerCooldown:  time.Duration(getEnvIntWithDefault("LLM_BREAKER_COOLDOWN_SECONDS", 60)) * time.Second,
+
+		LLMRetryMaxAttempts: getEnvIntWithDefault("LLM_RETRY_MAX_ATTEMPTS", 4),
+		LLMRetryDeadline:    time.Duration(getEnvIntWithDefault("LLM_RETRY_DEADLINE_SECONDS", 60)) * time.Second,
+
+		ThinkingBudget:        getEnvIntWithDefault("THINKING_BUDGET_TOKENS", 0),
+		ThinkingShowReasoning: getEnvBool("THINKING_SHOW_REASONING"),
+
+		ModelRouting:      getEnvWithDefault("MODEL_ROUTING", routingOff),
+		RouterSimpleModel: getEnvWithDefault("ROUTER_SIMPLE_MODEL", "claude-3-5-haiku-latest"),
+
+		AuditLogFile: getEnvWithDefault("AUDIT_LOG_FILE", "data/audit.jsonl"),
+
+		WebFetchEnabled:      getEnvWithDefault("WEB_FETCH_ENABLED", "true") != "false",
+		WebFetchTimeout:      time.Duration(getEnvIntWithDefault("WEB_FETCH_TIMEOUT_SECONDS", 15)) * time.Second,
+		WebFetchMaxChars:     getEnvIntWithDefault("WEB_FETCH_MAX_CHARS", 20000),
+		WebFetchAllowPrivate: getEnvBool("WEB_FETCH_ALLOW_PRIVATE"),
+
+		ChatPlatform:    getEnvWithDefault("CHAT_PLATFORM", "mattermost"),
+		SlackBotToken:   os.Getenv("SLACK_BOT_TOKEN"),
+		SlackAppToken:   os.Getenv("SLACK_APP_TOKEN"),
+		DiscordBotToken: os.Getenv("DISCORD_BOT_TOKEN"),
 	}
 
-	if config.ServerURL == "" || config.AccessToken == "" {
-		log.Fatal("Missing required environment variables: MATTERMOST_SERVER_URL, MATTERMOST_ACCESS_TOKEN")
+	if *replMode {
+		// The developer at the terminal can run admin commands
+		config.ChatPlatform = "repl"
+		config.AdminUserIDs = append(config.AdminUserIDs, repl.LocalUserID)
 	}
 
 	if config.AnthropicKey == "" {
 		log.Fatal("Missing required environment variable: ANTHROPIC_API_KEY")
 	}
 
+	// Below 1 the bounded history would keep no posts at all
+	if config.ContextMaxMsgs < 1 {
+		log.Fatalf("CONTEXT_MAX_MESSAGES must be at least 1, got %d", config.ContextMaxMsgs)
+	}
+	if config.ContextMaxTokens < 1 {
+		log.Fatalf("CONTEXT_MAX_TOKENS must be at least 1, got %d", config.ContextMaxTokens)
+	}
+
+	// Offline evaluation needs the model settings but no chat server
+	if flag.Arg(0) == "eval" {
+		if err := runEval(config, flag.Args()[1:]); err != nil {
+			log.Fatalf("Evaluation failed: %v", err)
+		}
+		return
+	}
+
 	if config.AsanaKey == "" {
 		log.Fatal("Missing required environment variable: ASANA_API_KEY")
 	}
 
-	// Initialize LLM backends
-	llmBackend := llms.NewAnthropicBackend(config.AnthropicKey, config.AsanaKey, config.AnthropicModel, config.MaxTokens, config.MaxWebSearch, true) // Main LLM with tools
-	decisionLLMBackend := llms.NewAnthropicBackend(config.AnthropicKey, config.AsanaKey, config.DecisionModel, config.DecisionMaxTokens, 0, false) // Decision LLM without tools
+	if (config.JiraBaseURL == "") != (config.JiraAPIToken == "") {
+		log.Fatal("JIRA_BASE_URL and JIRA_API_TOKEN must be set together")
+	}
+
+	if config.PagerDutyEmail != "" && config.PagerDutyAPIToken == "" {
+		log.Fatal("PAGERDUTY_FROM_EMAIL requires PAGERDUTY_API_TOKEN")
+	}
+
+	if config.PrometheusToken != "" && config.PrometheusURL == "" {
+		log.Fatal("PROMETHEUS_BEARER_TOKEN requires PROMETHEUS_URL")
+	}
+
+	if config.KubeConfig != "" && len(config.KubeNamespaces) == 0 {
+		log.Fatal("KUBECONFIG requires KUBE_NAMESPACES, the namespaces the Kubernetes tools may read")
+	}
+
+	if config.StateDatabaseURL != "" {
+		if err := store.CheckDatabaseURL(config.StateDatabaseURL); err != nil {
+			log.Fatalf("Invalid STATE_DATABASE_URL: %v", err)
+		}
+	}
+
+	if err := validContextStrategy(config.ContextStrategy); err != nil {
+		log.Fatalf("Invalid CONTEXT_STRATEGY: %v", err)
+	}
+
+	if config.ThinkingBudget != 0 && config.ThinkingBudget < llms.MinThinkingBudget {
+		log.Fatalf("THINKING_BUDGET_TOKENS must be 0 or at least %d", llms.MinThinkingBudget)
+	}
+
+	reactionActions, err := parseReactionActions(getEnvWithDefault("REACTION_ACTIONS", defaultReactionActions))
+	if err != nil {
+		log.Fatalf("Invalid REACTION_ACTIONS: %v", err)
+	}
+	config.ReactionActions = reactionActions
+
+	quietHours, err := presence.ParsePolicy(os.Getenv("QUIET_HOURS"), getEnvBool("QUIET_WEEKENDS"), getEnvWithDefault("RESPECT_DND", "true") != "false")
+	if err != nil {
+		log.Fatalf("Invalid QUIET_HOURS: %v", err)
+	}
+	config.QuietHours = quietHours
+
+	if value := os.Getenv("DEFAULT_LANGUAGE"); value != "" && config.DefaultLanguage == "" {
+		log.Fatalf("DEFAULT_LANGUAGE %q is not a language name or code", value)
+	}
+
+	switch config.ModerationProfanity {
+	case "off", string(moderation.Redact), string(moderation.Block):
+	default:
+		log.Fatal("MODERATION_PROFANITY must be off, redact or block")
+	}
+	switch config.ModerationClassifier {
+	case "off", "llm":
+	case "api":
+		if config.ModerationAPIKey == "" {
+			log.Fatal("MODERATION_CLASSIFIER=api requires MODERATION_API_KEY")
+		}
+	default:
+		log.Fatal("MODERATION_CLASSIFIER must be off, llm or api")
+	}
+	switch config.ModerationClassify {
+	case "both", string(moderation.Incoming), string(moderation.Outgoing):
+	default:
+		log.Fatal("MODERATION_CLASSIFY must be both, incoming or outgoing")
+	}
 
-	bot := NewBot(config, llmBackend, decisionLLMBackend)
-	bot.start()
+	switch config.PromptInjection {
+	case injectionOff, injectionFlag, injectionRefuse:
+	default:
+		log.Fatalf("PROMPT_INJECTION must be %s, %s or %s", injectionOff, injectionFlag, injectionRefuse)
+	}
+	switch config.InjectionClassifier {
+	case "off", "llm":
+	default:
+		log.Fatal("INJECTION_CLASSIFIER must be off or llm")
+	}
+
+	piiRedaction, err := parsePIIRedaction(getEnvWithDefault("PII_REDACTION", "off"))
+	if err != nil {
+		log.Fatalf("Invalid PII_REDACTION: %v", err)
+	}
+	config.PIIRedaction = piiRedaction
+
+	switch config.ModelRouting {
+	case routingOff, routingHeuristic, routingLLM:
+	default:
+		log.Fatalf("MODEL_ROUTING must be %s, %s or %s", routingOff, routingHeuristic, routingLLM)
+	}
+
+	if _, err := time.LoadLocation(config.DigestTimezone); err != nil {
+		log.Fatalf("Invalid DIGEST_TIMEZONE: %v", err)
+	}
+	if _, _, err := scheduler.ParseTimeOfDay(config.DigestTime); err != nil {
+		log.Fatalf("Invalid DIGEST_TIME: %v", err)
+	}
+	if _, _, err := scheduler.ParseTimeOfDay(config.BriefingTime); err != nil {
+		log.Fatalf("Invalid BRIEFING_TIME: %v", err)
+	}
+
+	toolSelector, err := newToolSelector(config)
+	if err != nil {
+		log.Fatalf("Invalid tool preselection settings: %v", err)
+	}
+
+	fileConfig, err := loadFileConfig(config.ConfigFile)
+	if err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
+
+	// Spans are only exported when an OTLP endpoint is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), "agent-bot")
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+	if tracing.Enabled() {
+		log.Printf("[%s] TRACING: Exporting spans over OTLP", time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	// Errors only reach the logs unless a sink is configured
+	if err := errorsink.Setup(errorsink.Config{SentryDSN: config.SentryDSN, WebhookURL: config.ErrorWebhookURL, Environment: config.SentryEnvironment}); err != nil {
+		log.Fatalf("Invalid error reporting settings: %v", err)
+	}
+	if errorsink.Enabled() {
+		log.Printf("[%s] ERRORS: Reporting panics, tool failures and LLM outages", time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	if err := validateDistributed(config); err != nil {
+		log.Fatalf("Invalid distributed mode: %v", err)
+	}
+
+	if config.ChatPlatform != "mattermost" {
+		runPlatform(config, fileConfig, toolSelector)
+		return
+	}
+
+	if config.ServerURL == "" || config.AccessToken == "" {
+		log.Fatal("Missing required environment variables: MATTERMOST_SERVER_URL, MATTERMOST_ACCESS_TOKEN")
+	}
+
+	tlsConfig, err := loadTLSConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+	if config.TLSInsecureSkipVerify {
+		log.Printf("Warning: TLS certificate verification is disabled for the Mattermost server")
+	}
+
+	// Verify the token and work out who the bot is
+	if err := resolveBotUser(&config, tlsConfig); err != nil {
+		log.Fatalf("Startup self-test failed: %v", err)
+	}
+
+	if (config.CanaryURL != "" || config.CanaryMode) && config.CanaryToken == "" {
+		log.Fatal("CANARY_URL and CANARY_MODE require CANARY_TOKEN")
+	}
+	if config.CanaryMode && config.CanaryReportChannel == "" {
+		log.Fatal("CANARY_MODE requires CANARY_REPORT_CHANNEL_ID")
+	}
+
+	if err := validateServerProfiles(fileConfig.Servers); err != nil {
+		log.Fatalf("Invalid servers: %v", err)
+	}
+	if config.CanaryMode && len(fileConfig.Servers) > 0 {
+		log.Fatal("CANARY_MODE cannot be combined with servers in the config file")
+	}
+
+	shared := startSharedTools(config, fileConfig)
+	defer shared.Close()
+
+	bot := newWorkspaceBot(config, fileConfig, tlsConfig, shared, toolSelector)
+	if config.StartupSelfTest {
+		runStartupSelfTest(config, bot.llmBackend, bot.decisionLLMBackend, shared, fileConfig.MCPServers)
+	}
+	bots := []*Bot{bot}
+
+	// Additional workspaces from the servers section of the config file
+	for _, profile := range fileConfig.Servers {
+		profileCfg := profileConfig(config, profile)
+		if err := resolveBotUser(&profileCfg, tlsConfig); err != nil {
+			log.Fatalf("Startup self-test failed for server %s: %v", profile.Name, err)
+		}
+		profileFileConfig, err := loadProfileFileConfig(fileConfig, profile.ConfigFile)
+		if err != nil {
+			log.Fatalf("Failed to load config file for server %s: %v", profile.Name, err)
+		}
+		bots = append(bots, newWorkspaceBot(profileCfg, profileFileConfig, tlsConfig, shared, toolSelector))
+	}
+	defer func() {
+		for _, bot := range bots {
+			if bot.auditLog != nil {
+				bot.auditLog.Close()
+			}
+		}
+	}()
+
+	// The primary workspace serves its routes at the root, the others under /servers/<name>/
+	startBots := func() {
+		bots[0].start(http.DefaultServeMux)
+		for _, bot := range bots[1:] {
+			mux := http.NewServeMux()
+			bot.start(mux)
+			mux.HandleFunc("/health", handleHealth([]*Bot{bot}))
+			prefix := "/servers/" + bot.config.Name
+			http.Handle(prefix+"/", http.StripPrefix(prefix, mux))
+		}
+	}
+	if config.LeaderElection {
+		// Followers serve health checks while they wait
+		go runForLeader(config, bots, startBots)
+	} else {
+		startBots()
+	}
+
+	// Keep HTTP server for health checks
+	http.HandleFunc("/health", handleHealth(bots))
+
+	// JSON health: /healthz from local state, /readyz with dependency checks
+	dependencies := []dependency{
+		llmDependency("llm", bots[0].llmBackend, true),
+		llmDependency("decision_llm", bots[0].decisionLLMBackend, false),
+	}
+	for _, bot := range bots {
+		dependencies = append(dependencies, bot.mattermostDependency())
+		if bot.redis != nil {
+			dependencies = append(dependencies, bot.redisDependency())
+		}
+	}
+	dependencies = append(dependencies, toolDependencies(shared, fileConfig)...)
+	health := newHealthChecker(func() []workspaceHealth {
+		workspaces := make([]workspaceHealth, 0, len(bots))
+		for _, bot := range bots {
+			workspaces = append(workspaces, bot.workspaceHealth(bot.eventsConnected()))
+		}
+		return workspaces
+	}, dependencies)
+	http.HandleFunc("/healthz", health.handleLive)
+	http.HandleFunc("/readyz", health.handleReady)
+
+	// Prometheus metrics
+	http.Handle("/metrics", metrics.Default.Handler())
+
+	// Runtime diagnostics, with /debug/pprof/, behind the primary workspace's ADMIN_API_TOKEN
+	http.HandleFunc("/debug/state", bots[0].handleDebugState(func(ctx context.Context) []workspaceState {
+		workspaces := make([]workspaceState, 0, len(bots))
+		for _, bot := range bots {
+			workspaces = append(workspaces, bot.runtimeState(ctx))
+		}
+		return workspaces
+	}))
+
+	// gRPC control plane for admin tools, behind the same token
+	if config.GRPCPort != "" {
+		logs := controlplane.NewLogs()
+		log.SetOutput(io.MultiWriter(os.Stderr, logs))
+		if err := startControlPlane(config.GRPCPort, bots, logs); err != nil {
+			log.Fatalf("Failed to start the control plane: %v", err)
+		}
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8081"
+	}
+
+	log.Printf("[%s] SERVER: Bot listening on port %s (%d workspaces)", time.Now().Format("2006-01-02 15:04:05"), port, len(bots))
+	log.Fatal(http.ListenAndServe(":"+port, guardPprof(bots[0], http.DefaultServeMux)))
+}
+
+// newWorkspaceBot wires a bot for one Mattermost workspace: its state store,
+// tool registry, LLM backends and audit log. Configuration errors are fatal.
+func newWorkspaceBot(config Config, fileConfig *FileConfig, tlsConfig *tls.Config, shared *sharedTools, toolSelector *tools.Selector) *Bot {
+	notifications, err := notify.NewEngine(fileConfig.NotificationRules)
+	if err != nil {
+		log.Fatalf("Invalid notification_rules: %v", err)
+	}
+	if err := standup.Validate(fileConfig.Standups); err != nil {
+		log.Fatalf("Invalid standups: %v", err)
+	}
+	if err := asana.ValidateSubscriptions(fileConfig.AsanaNotifications); err != nil {
+		log.Fatalf("Invalid asana_notifications: %v", err)
+	}
+	hookSet, err := hooks.NewSet(fileConfig.Hooks)
+	if err != nil {
+		log.Fatalf("Invalid hooks: %v", err)
+	}
+	if err := styles.Validate(fileConfig.ResponseStyle, fileConfig.ChannelStyles); err != nil {
+		log.Fatalf("Invalid channel_styles: %v", err)
+	}
+	if err := policy.Validate(fileConfig.ReplyPolicy, fileConfig.ChannelPolicies); err != nil {
+		log.Fatalf("Invalid channel_policies: %v", err)
+	}
+	if err := moderation.Validate(fileConfig.ModerationKeywords); err != nil {
+		log.Fatalf("Invalid moderation_keywords: %v", err)
+	}
+	promptSet, err := prompts.Load(config.PromptsDir)
+	if err != nil {
+		log.Fatalf("Invalid prompts: %v", err)
+	}
+
+	if err := validateEventSource(config); err != nil {
+		log.Fatalf("Invalid event source: %v", err)
+	}
+
+	stateStore, redisClient, err := openStateStore(config)
+	if err != nil {
+		log.Fatalf("Failed to open state store: %v", err)
+	}
+
+	// Register tools available to the main LLM
+	registry := tools.NewRegistry()
+	registry.SetTimeouts(config.ToolTimeout, fileConfig.ToolTimeouts)
+	for _, tool := range shared.registry.List() {
+		registry.Register(tool)
+	}
+
+	llmBackend, decisionLLMBackend := newLLMBackends(config, registry, toolSelector)
+	bot := NewBot(config, fileConfig, tlsConfig, stateStore, registry, promptSet, llmBackend, decisionLLMBackend)
+	if redisClient != nil {
+		bot.startDistributed(redisClient)
+	}
+	bot.notifications = notifications
+	bot.hooks = hookSet
+	bot.asanaHooks = newAsanaWebhooks(config, fileConfig, shared.asana, stateStore)
+	// Chat users' Asana GIDs, linked by admins or matched by email
+	for _, tool := range bot.asanaUserTools() {
+		registry.Register(tool)
+	}
+	// Uploads go through the agent's chat, so a canary's are discarded too
+	registerUploadTools(registry, shared, bot.agent.(*BotAgent).chat)
+	if config.AuditLogFile != "" {
+		auditLog, err := audit.Open(config.AuditLogFile)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		bot.auditLog = auditLog
+		llmBackend.SetToolAuditor(auditLog)
+	}
+	llmBackend.SetUsageRecorder(bot.usage)
+	decisionLLMBackend.SetUsageRecorder(bot.usage)
+	return bot
+}
+
+// sharedTools are the tools every workspace gets: Asana, Jira, PagerDuty,
+// Prometheus, Kubernetes, fetch_url and the MCP servers, plus the diagram
+// renderer and image generator the upload tools use, which are started once
+// per process
+type sharedTools struct {
+	registry   *tools.Registry
+	asana      *asana.Client
+	jira       *jira.Client
+	pagerduty  *pagerduty.Client
+	prometheus *prometheus.Client
+	kube       *kube.Client
+	renderer   *render.Client
+	images     imagegen.Provider
+	wiki       wiki.Provider
+	mcpClients []*mcpclient.Client
+}
+
+func startSharedTools(config Config, fileConfig *FileConfig) *sharedTools {
+	shared := &sharedTools{registry: tools.NewRegistry()}
+	shared.asana = asana.NewClient(config.AsanaKey, &http.Client{})
+	shared.asana.SetCacheTTL(config.AsanaCacheTTL)
+	shared.asana.SetRateLimit(config.AsanaRateLimit)
+	for _, tool := range shared.asana.Tools() {
+		shared.registry.Register(tool)
+	}
+	if config.JiraBaseURL != "" {
+		shared.jira = jira.NewClient(config.JiraBaseURL, config.JiraEmail, config.JiraAPIToken, &http.Client{Timeout: 30 * time.Second})
+		for _, tool := range shared.jira.Tools() {
+			shared.registry.Register(tool)
+		}
+	}
+	if config.PagerDutyAPIToken != "" {
+		shared.pagerduty = pagerduty.NewClient(config.PagerDutyAPIToken, config.PagerDutyEmail, &http.Client{Timeout: 30 * time.Second})
+		for _, tool := range shared.pagerduty.Tools() {
+			shared.registry.Register(tool)
+		}
+	}
+	if config.PrometheusURL != "" {
+		shared.prometheus = prometheus.NewClient(config.PrometheusURL, config.PrometheusToken, &http.Client{Timeout: 30 * time.Second})
+		// Without a chat client to upload to, queries return tables only
+		for _, tool := range shared.prometheus.Tools(nil) {
+			shared.registry.Register(tool)
+		}
+	}
+	if len(config.KubeNamespaces) > 0 {
+		client, err := kube.NewClient(config.KubeConfig, config.KubeNamespaces)
+		if err != nil {
+			log.Fatalf("Failed to set up Kubernetes tools: %v", err)
+		}
+		shared.kube = client
+		for _, tool := range shared.kube.Tools() {
+			shared.registry.Register(tool)
+		}
+	}
+	if config.KrokiURL != "" {
+		// render_diagram posts images, so it is registered with the upload tools
+		shared.renderer = render.NewClient(config.KrokiURL, &http.Client{Timeout: 30 * time.Second})
+	}
+	images, err := newImageProvider(config)
+	if err != nil {
+		log.Fatalf("Invalid image generation settings: %v", err)
+	}
+	shared.images = images
+	wikiProvider, err := newWikiProvider(config)
+	if err != nil {
+		log.Fatalf("Invalid wiki settings: %v", err)
+	}
+	if wikiProvider != nil {
+		shared.wiki = wikiProvider
+		for _, tool := range wiki.Tools(wikiProvider, config.WikiMaxChars) {
+			shared.registry.Register(tool)
+		}
+	}
+	if config.WebFetchEnabled {
+		fetcher := webfetch.NewFetcher(config.WebFetchTimeout, config.WebFetchMaxChars, config.WebFetchAllowPrivate)
+		for _, tool := range fetcher.Tools() {
+			shared.registry.Register(tool)
+		}
+	}
+	shared.mcpClients = startMCPServers(fileConfig.MCPServers, shared.registry)
+	return shared
+}
+
+func (s *sharedTools) Close() {
+	for _, client := range s.mcpClients {
+		client.Close()
+	}
+}
+
+// newLLMBackends creates the main LLM, which calls tools from registry, and
+// the decision LLM, which has none
+func newLLMBackends(config Config, registry *tools.Registry, toolSelector *tools.Selector) (*llms.AnthropicBackend, *llms.AnthropicBackend) {
+	llmBackend := llms.NewAnthropicBackend(config.AnthropicKey, config.AnthropicModel, config.MaxTokens, config.MaxWebSearch, true, registry) // Main LLM with tools
+	decisionLLMBackend := llms.NewAnthropicBackend(config.AnthropicKey, config.DecisionModel, config.DecisionMaxTokens, 0, false, nil)        // Decision LLM without tools
+
+	if toolSelector != nil {
+		llmBackend.SetToolSelector(toolSelector)
+	}
+	llmBackend.SetToolParallelism(config.ToolParallelism)
+	llmBackend.SetToolLoopLimits(config.ToolMaxTurns, int64(config.ToolMaxRequestTokens))
+
+	llmBackend.SetRetryPolicy(retryPolicy(config))
+	decisionLLMBackend.SetRetryPolicy(retryPolicy(config))
+	return llmBackend, decisionLLMBackend
+}
+
+// retryPolicy applies LLM_RETRY_MAX_ATTEMPTS and LLM_RETRY_DEADLINE_SECONDS
+func retryPolicy(config Config) llms.RetryPolicy {
+	retry := llms.DefaultRetryPolicy
+	retry.MaxAttempts = config.LLMRetryMaxAttempts
+	retry.Deadline = config.LLMRetryDeadline
+	return retry
+}
+
+// newLLMBreakers wraps the main and decision backends in circuit breakers so
+// a failing model is left alone for a while instead of being retried on
+// every message
+func newLLMBreakers(config Config, llmBackend, decisionLLMBackend llms.LLMBackend) (*llms.CircuitBreaker, *llms.CircuitBreaker) {
+	return llms.NewCircuitBreaker(breakerName(config, "main"), llmBackend, config.LLMBreakerThreshold, config.LLMBreakerCooldown),
+		llms.NewCircuitBreaker(breakerName(config, "decision"), decisionLLMBackend, config.LLMBreakerThreshold, config.LLMBreakerCooldown)
+}
+
+// breakerName names a workspace's circuit breaker in logs and metrics
+func breakerName(config Config, backend string) string {
+	if config.Name != "" {
+		return config.Name + "/" + backend
+	}
+	return backend
 }
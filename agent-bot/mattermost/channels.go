@@ -0,0 +1,267 @@
+// Package mattermost holds Mattermost-specific pieces that don't belong in
+// the generic chat adapter: admin-gated channel housekeeping tools, whose
+// changes wait in an approvals.Manager, and a transport that paces REST
+// calls by the server's rate limit headers.
+package mattermost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"agent-bot/approvals"
+	"agent-bot/tools"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+var (
+	errNotAdmin = errors.New("only bot admins can manage channels")
+	// errNoRequest means the call didn't carry the requesting user, which is
+	// a wiring bug rather than a permission problem
+	errNoRequest = errors.New("channel management needs the requesting user, which this request didn't carry")
+)
+
+type CreateChannelArgs struct {
+	TeamID      string `json:"team_id,omitempty" jsonschema_description:"The team to create the channel in (optional - defaults to the team of the current channel)"`
+	Name        string `json:"name" jsonschema_description:"URL-safe channel name, lowercase letters, numbers and dashes"`
+	DisplayName string `json:"display_name" jsonschema_description:"Human-readable channel name"`
+	Purpose     string `json:"purpose,omitempty" jsonschema_description:"Short description of what the channel is for"`
+	Private     bool   `json:"private,omitempty" jsonschema_description:"Create a private channel instead of a public one"`
+}
+
+type ArchiveChannelArgs struct {
+	ChannelID string `json:"channel_id" jsonschema_description:"The channel ID to archive"`
+}
+
+type SetChannelHeaderArgs struct {
+	ChannelID string `json:"channel_id" jsonschema_description:"The channel ID whose header to set"`
+	Header    string `json:"header" jsonschema_description:"The new channel header (markdown)"`
+}
+
+type StaleChannelsArgs struct {
+	TeamID string `json:"team_id,omitempty" jsonschema_description:"The team to report on (optional - defaults to the team of the current channel)"`
+	Days   int    `json:"days,omitempty" jsonschema_description:"Channels with no posts for this many days are reported (default 90)"`
+}
+
+// StaleChannel is a public channel without recent activity
+type StaleChannel struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LastPostAt  string `json:"last_post_at"`
+	IdleDays    int    `json:"idle_days"`
+}
+
+// ChannelTools exposes admin-gated channel housekeeping tools. Every change
+// is queued in the approval manager and only runs once an admin approves it.
+type ChannelTools struct {
+	client    *model.Client4
+	approvals *approvals.Manager
+	isAdmin   func(userID string) bool
+}
+
+func NewChannelTools(client *model.Client4, approvalManager *approvals.Manager, isAdmin func(userID string) bool) *ChannelTools {
+	return &ChannelTools{
+		client:    client,
+		approvals: approvalManager,
+		isAdmin:   isAdmin,
+	}
+}
+
+// Tools returns the channel management tools
+func (c *ChannelTools) Tools() []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "report_stale_channels",
+			Description: "List public channels in a team that have had no posts for a number of days. Read-only; use before proposing archival.",
+			Schema:      tools.SchemaFor[StaleChannelsArgs](),
+			Handler:     tools.Typed(c.reportStaleChannels),
+		},
+		{
+			Name:        "create_channel",
+			Description: "Request creation of a new channel. Requires admin approval before it takes effect.",
+			Schema:      tools.SchemaFor[CreateChannelArgs](),
+			Handler:     tools.Typed(c.createChannel),
+		},
+		{
+			Name:        "archive_channel",
+			Description: "Request archival of a channel, e.g. one found by report_stale_channels. Requires admin approval before it takes effect.",
+			Schema:      tools.SchemaFor[ArchiveChannelArgs](),
+			Handler:     tools.Typed(c.archiveChannel),
+		},
+		{
+			Name:        "set_channel_header",
+			Description: "Request a change to a channel's header. Requires admin approval before it takes effect.",
+			Schema:      tools.SchemaFor[SetChannelHeaderArgs](),
+			Handler:     tools.Typed(c.setChannelHeader),
+		},
+	}
+}
+
+// authorize returns the request if it comes from an admin
+func (c *ChannelTools) authorize(ctx context.Context) (tools.Request, error) {
+	req, ok := tools.RequestFrom(ctx)
+	if !ok {
+		return req, errNoRequest
+	}
+	if !c.isAdmin(req.UserID) {
+		return req, errNotAdmin
+	}
+	return req, nil
+}
+
+// resolveTeam defaults to the team of the channel the request came from
+func (c *ChannelTools) resolveTeam(ctx context.Context, teamID string) (string, error) {
+	if teamID != "" {
+		return teamID, nil
+	}
+
+	req, _ := tools.RequestFrom(ctx)
+	if req.ChannelID == "" {
+		return "", fmt.Errorf("team_id is required")
+	}
+
+	channel, _, err := c.client.GetChannel(req.ChannelID, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to look up current channel: %w", err)
+	}
+	if channel.TeamId == "" {
+		return "", fmt.Errorf("team_id is required outside of team channels")
+	}
+	return channel.TeamId, nil
+}
+
+func (c *ChannelTools) queue(req tools.Request, description string, run approvals.RunFunc) (interface{}, error) {
+	action, err := c.approvals.Request(req, description, run)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("Queued for approval as action %s: %s. An admin must DM me `!approve %s` (or `!deny %s`) before anything changes.", action.ID, description, action.ID, action.ID), nil
+}
+
+func (c *ChannelTools) reportStaleChannels(ctx context.Context, input StaleChannelsArgs) (interface{}, error) {
+	if _, err := c.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	teamID, err := c.resolveTeam(ctx, input.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	days := input.Days
+	if days <= 0 {
+		days = 90
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	var stale []StaleChannel
+	for page := 0; ; page++ {
+		channels, _, err := c.client.GetPublicChannelsForTeam(teamID, page, 200, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list channels: %w", err)
+		}
+
+		for _, channel := range channels {
+			lastPost := time.UnixMilli(channel.LastPostAt)
+			if channel.DeleteAt != 0 || lastPost.After(cutoff) {
+				continue
+			}
+			stale = append(stale, StaleChannel{
+				ID:          channel.Id,
+				Name:        channel.Name,
+				DisplayName: channel.DisplayName,
+				LastPostAt:  lastPost.Format("2006-01-02"),
+				IdleDays:    int(time.Since(lastPost).Hours() / 24),
+			})
+		}
+
+		if len(channels) < 200 {
+			break
+		}
+	}
+
+	sort.Slice(stale, func(i, j int) bool { return stale[i].IdleDays > stale[j].IdleDays })
+	return stale, nil
+}
+
+func (c *ChannelTools) createChannel(ctx context.Context, input CreateChannelArgs) (interface{}, error) {
+	req, err := c.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if input.Name == "" || input.DisplayName == "" {
+		return nil, fmt.Errorf("name and display_name are required")
+	}
+
+	teamID, err := c.resolveTeam(ctx, input.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	channelType := model.ChannelTypeOpen
+	kind := "public"
+	if input.Private {
+		channelType = model.ChannelTypePrivate
+		kind = "private"
+	}
+
+	description := fmt.Sprintf("create %s channel ~%s (%s)", kind, input.Name, input.DisplayName)
+	return c.queue(req, description, func(ctx context.Context) (string, error) {
+		channel, _, err := c.client.CreateChannel(&model.Channel{
+			TeamId:      teamID,
+			Name:        input.Name,
+			DisplayName: input.DisplayName,
+			Purpose:     input.Purpose,
+			Type:        channelType,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create channel: %w", err)
+		}
+		return fmt.Sprintf("Created channel ~%s.", channel.Name), nil
+	})
+}
+
+func (c *ChannelTools) archiveChannel(ctx context.Context, input ArchiveChannelArgs) (interface{}, error) {
+	req, err := c.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, _, err := c.client.GetChannel(input.ChannelID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up channel: %w", err)
+	}
+
+	description := fmt.Sprintf("archive channel ~%s (%s)", channel.Name, channel.DisplayName)
+	return c.queue(req, description, func(ctx context.Context) (string, error) {
+		if _, err := c.client.DeleteChannel(channel.Id); err != nil {
+			return "", fmt.Errorf("failed to archive channel: %w", err)
+		}
+		return fmt.Sprintf("Archived channel ~%s.", channel.Name), nil
+	})
+}
+
+func (c *ChannelTools) setChannelHeader(ctx context.Context, input SetChannelHeaderArgs) (interface{}, error) {
+	req, err := c.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, _, err := c.client.GetChannel(input.ChannelID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up channel: %w", err)
+	}
+
+	header := input.Header
+	description := fmt.Sprintf("set the header of ~%s to %q", channel.Name, header)
+	return c.queue(req, description, func(ctx context.Context) (string, error) {
+		if _, _, err := c.client.PatchChannel(channel.Id, &model.ChannelPatch{Header: &header}); err != nil {
+			return "", fmt.Errorf("failed to set channel header: %w", err)
+		}
+		return fmt.Sprintf("Updated the header of ~%s.", channel.Name), nil
+	})
+}
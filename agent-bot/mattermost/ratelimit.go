@@ -0,0 +1,129 @@
+package mattermost
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"agent-bot/metrics"
+)
+
+// Bounds on how long a rate-limited request waits before being retried
+const (
+	maxRateLimitRetries = 3
+	maxRateLimitWait    = 30 * time.Second
+)
+
+// RateLimitedTransport paces requests to the Mattermost API. It tracks the
+// server's X-Ratelimit-Remaining/X-Ratelimit-Reset headers, holds requests
+// back while the budget is exhausted, and retries 429 responses after the
+// advertised delay instead of failing them.
+type RateLimitedTransport struct {
+	base http.RoundTripper
+
+	mu         sync.Mutex
+	blockUntil time.Time
+}
+
+func NewRateLimitedTransport(base http.RoundTripper) *RateLimitedTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RateLimitedTransport{base: base}
+}
+
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		t.observe(resp)
+
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= maxRateLimitRetries {
+			return resp, nil
+		}
+
+		// Only retry when the body can be sent again
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		resp.Body.Close()
+
+		metrics.Inc("mattermost_rate_limited_total", "method", req.Method)
+		log.Printf("[%s] RATELIMIT: %s %s throttled by Mattermost, retrying (attempt %d)", time.Now().Format("2006-01-02 15:04:05"), req.Method, req.URL.Path, attempt+1)
+	}
+}
+
+// wait blocks until the rate limit window allows another request
+func (t *RateLimitedTransport) wait(ctx context.Context) error {
+	t.mu.Lock()
+	delay := time.Until(t.blockUntil)
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// observe records the budget advertised by the server
+func (t *RateLimitedTransport) observe(resp *http.Response) {
+	var delay time.Duration
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		delay = headerSeconds(resp.Header, "Retry-After")
+		if delay == 0 {
+			delay = headerSeconds(resp.Header, "X-Ratelimit-Reset")
+		}
+		if delay == 0 {
+			delay = time.Second
+		}
+	case resp.Header.Get("X-Ratelimit-Remaining") == "0":
+		delay = headerSeconds(resp.Header, "X-Ratelimit-Reset")
+	default:
+		return
+	}
+
+	if delay > maxRateLimitWait {
+		delay = maxRateLimitWait
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(delay); until.After(t.blockUntil) {
+		t.blockUntil = until
+	}
+}
+
+// headerSeconds parses a header holding a number of seconds
+func headerSeconds(header http.Header, name string) time.Duration {
+	seconds, err := strconv.Atoi(header.Get(name))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
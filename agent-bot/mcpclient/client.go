@@ -0,0 +1,388 @@
+// Package mcpclient runs local MCP servers over stdio and bridges their
+// tools into the tool registry as <server>__<tool>.
+package mcpclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/tools"
+)
+
+const protocolVersion = "2024-11-05"
+
+// readLoopTimeout bounds how long Close waits for the server's output to
+// end after killing it; a child process it spawned may keep it open
+const readLoopTimeout = 5 * time.Second
+
+// ServerConfig describes a local MCP server launched over stdio
+type ServerConfig struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	// Timeout bounds each request to the server; defaults to 30s
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int64      `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	ID     *int64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// RemoteTool is a tool advertised by an MCP server
+type RemoteTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+type callResult struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	IsError bool `json:"isError"`
+}
+
+// Client talks JSON-RPC to a single MCP server process over stdin/stdout
+type Client struct {
+	config ServerConfig
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpcResponse
+	closed  bool
+
+	// readDone is closed when readLoop returns
+	readDone chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Start launches the server process and performs the MCP initialize handshake
+func Start(ctx context.Context, config ServerConfig) (*Client, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	cmd := exec.Command(config.Command, config.Args...)
+	cmd.Env = os.Environ()
+	for key, value := range config.Env {
+		cmd.Env = append(cmd.Env, key+"="+os.ExpandEnv(value))
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stderr: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", config.Command, err)
+	}
+
+	c := &Client{
+		config:   config,
+		cmd:      cmd,
+		stdin:    stdin,
+		pending:  make(map[int64]chan rpcResponse),
+		readDone: make(chan struct{}),
+	}
+
+	go c.readLoop(stdout)
+	go c.logStderr(stderr)
+
+	initParams := map[string]interface{}{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo": map[string]string{
+			"name":    "agent-bot",
+			"version": "1.0.0",
+		},
+	}
+	if _, err := c.call(ctx, "initialize", initParams); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("initialize failed: %w", err)
+	}
+	if err := c.notify("notifications/initialized"); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("initialized notification failed: %w", err)
+	}
+
+	log.Printf("[%s] MCP: Started stdio server %s (%s)", time.Now().Format("2006-01-02 15:04:05"), config.Name, config.Command)
+	return c, nil
+}
+
+// Name returns the configured server name
+func (c *Client) Name() string {
+	return c.config.Name
+}
+
+// ListTools returns every tool advertised by the server, following pagination
+func (c *Client) ListTools(ctx context.Context) ([]RemoteTool, error) {
+	var all []RemoteTool
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		raw, err := c.call(ctx, "tools/list", params)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tools      []RemoteTool `json:"tools"`
+			NextCursor string       `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse tools/list result: %w", err)
+		}
+
+		all = append(all, page.Tools...)
+		if page.NextCursor == "" {
+			return all, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// CallTool invokes a tool and returns its concatenated text content
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (string, error) {
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+
+	raw, err := c.call(ctx, "tools/call", map[string]interface{}{
+		"name":      name,
+		"arguments": arguments,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var result callResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return "", fmt.Errorf("failed to parse tools/call result: %w", err)
+	}
+
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	if result.IsError {
+		return "", fmt.Errorf("tool %s failed: %s", name, text.String())
+	}
+	return text.String(), nil
+}
+
+var invalidToolChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// RegisterTools bridges every server tool into the registry as
+// "<server>__<tool>" and returns how many were registered. Sanitizing and
+// truncating names can make two tools collide; a tool whose name is already
+// taken, by this server or another, is skipped rather than replacing it.
+func (c *Client) RegisterTools(ctx context.Context, registry *tools.Registry) (int, error) {
+	remoteTools, err := c.ListTools(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	registered := 0
+	for _, remote := range remoteTools {
+		remoteName := remote.Name
+		name := invalidToolChars.ReplaceAllString(c.config.Name+"__"+remoteName, "_")
+		if len(name) > 64 {
+			name = name[:64]
+		}
+		if _, taken := registry.Get(name); taken {
+			log.Printf("[%s] MCP: Skipping tool %s of stdio server %s, its name %s is already taken", time.Now().Format("2006-01-02 15:04:05"), remoteName, c.config.Name, name)
+			continue
+		}
+
+		var schema tools.Schema
+		if len(remote.InputSchema) > 0 {
+			if err := json.Unmarshal(remote.InputSchema, &schema); err != nil {
+				log.Printf("[%s] MCP: Skipping tool %s with unreadable schema: %v", time.Now().Format("2006-01-02 15:04:05"), remoteName, err)
+				continue
+			}
+		}
+
+		registry.Register(tools.Tool{
+			Name:        name,
+			Description: remote.Description,
+			Schema:      schema,
+			Handler: func(ctx context.Context, input json.RawMessage) (interface{}, error) {
+				return c.CallTool(ctx, remoteName, input)
+			},
+			Timeout: c.config.Timeout,
+		})
+		registered++
+	}
+
+	log.Printf("[%s] MCP: Registered %d of %d tools from stdio server %s", time.Now().Format("2006-01-02 15:04:05"), registered, len(remoteTools), c.config.Name)
+	return registered, nil
+}
+
+// Close terminates the server process and fails any pending requests
+func (c *Client) Close() error {
+	c.failPending()
+
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		if c.cmd.Process != nil {
+			c.cmd.Process.Kill()
+		}
+		// Wait closes stdout, so the read loop has to be done with it first
+		select {
+		case <-c.readDone:
+		case <-time.After(readLoopTimeout):
+			log.Printf("[%s] MCP: Stdio server %s kept its output open after being stopped", time.Now().Format("2006-01-02 15:04:05"), c.config.Name)
+		}
+		c.closeErr = c.cmd.Wait()
+	})
+	return c.closeErr
+}
+
+// failPending stops accepting requests and wakes every waiting caller
+func (c *Client) failPending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("mcp server %s is not running", c.config.Name)
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan rpcResponse, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(rpcRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(c.config.Timeout)
+	defer timer.Stop()
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("mcp server %s exited", c.config.Name)
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s: %s (code %d)", method, resp.Error.Message, resp.Error.Code)
+		}
+		return resp.Result, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%s timed out after %v", method, c.config.Timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) notify(method string) error {
+	return c.write(rpcRequest{JSONRPC: "2.0", Method: method})
+}
+
+func (c *Client) write(req rpcRequest) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if _, err := c.stdin.Write(append(payload, '\n')); err != nil {
+		return fmt.Errorf("failed to write to mcp server %s: %w", c.config.Name, err)
+	}
+	return nil
+}
+
+func (c *Client) readLoop(stdout io.Reader) {
+	defer close(c.readDone)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var resp rpcResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil || resp.ID == nil {
+			// Server-initiated notifications and requests are ignored
+			continue
+		}
+
+		c.mu.Lock()
+		if ch, ok := c.pending[*resp.ID]; ok {
+			ch <- resp // buffered, never blocks
+			delete(c.pending, *resp.ID)
+		}
+		c.mu.Unlock()
+	}
+
+	log.Printf("[%s] MCP: Stdio server %s closed its output", time.Now().Format("2006-01-02 15:04:05"), c.config.Name)
+	c.failPending()
+}
+
+func (c *Client) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.Printf("[%s] MCP: [%s] %s", time.Now().Format("2006-01-02 15:04:05"), c.config.Name, scanner.Text())
+	}
+}
@@ -0,0 +1,233 @@
+// Package memory lets the model keep notes per channel (memory_get,
+// memory_set) and facts users ask it to remember about them. Entries live
+// in the state store with size limits and optional TTLs, and expired ones
+// are dropped on read.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/store"
+	"agent-bot/tools"
+)
+
+// Limits keep memory small enough to be a scratchpad rather than a database
+const (
+	MaxKeyLength   = 64
+	MaxValueLength = 1000
+	MaxEntries     = 50
+	MaxTTL         = 365 * 24 * time.Hour
+)
+
+var errNoChannel = errors.New("memory is only available in a channel conversation")
+
+type GetArgs struct {
+	Key string `json:"key,omitempty" jsonschema_description:"The key to read (optional - omit to list every fact remembered in this channel)"`
+}
+
+type SetArgs struct {
+	Key        string `json:"key" jsonschema_description:"Short identifier such as staging_url or oncall_rotation"`
+	Value      string `json:"value" jsonschema_description:"The fact to remember (max 1000 characters); an empty value forgets the key"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty" jsonschema_description:"Forget the fact after this many seconds (optional - defaults to never)"`
+}
+
+// Entry is a remembered fact
+type Entry struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+func (e Entry) expired(now time.Time) bool {
+	if e.ExpiresAt == "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, e.ExpiresAt)
+	return err == nil && now.After(expires)
+}
+
+// Memory is a per-channel key-value scratchpad the model can read and write
+type Memory struct {
+	mu    sync.Mutex
+	store *store.Store
+}
+
+func New(stateStore *store.Store) *Memory {
+	return &Memory{store: stateStore}
+}
+
+// Tools returns the memory_get and memory_set tools
+func (m *Memory) Tools() []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "memory_get",
+			Description: "Read small facts remembered for this channel in earlier conversations (URLs, owners, conventions). Omit key to list everything remembered here.",
+			Schema:      tools.SchemaFor[GetArgs](),
+			Handler:     tools.Typed(m.get),
+		},
+		{
+			Name:        "memory_set",
+			Description: "Remember a small fact for this channel so it is available in future conversations. Only store facts users would expect to be remembered; never store secrets. Set an empty value to forget a key.",
+			Schema:      tools.SchemaFor[SetArgs](),
+			Handler:     tools.Typed(m.set),
+		},
+	}
+}
+
+func bucket(channelID string) string {
+	return "memory:" + channelID
+}
+
+func (m *Memory) get(ctx context.Context, input GetArgs) (interface{}, error) {
+	req, ok := tools.RequestFrom(ctx)
+	if !ok || req.ChannelID == "" {
+		return nil, errNoChannel
+	}
+
+	if input.Key != "" {
+		entry, found, err := m.Get(req.ChannelID, input.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return fmt.Sprintf("Nothing is remembered under %q in this channel.", input.Key), nil
+		}
+		return entry, nil
+	}
+
+	entries := m.List(req.ChannelID)
+	if len(entries) == 0 {
+		return "Nothing is remembered in this channel yet.", nil
+	}
+	return entries, nil
+}
+
+func (m *Memory) set(ctx context.Context, input SetArgs) (interface{}, error) {
+	req, ok := tools.RequestFrom(ctx)
+	if !ok || req.ChannelID == "" {
+		return nil, errNoChannel
+	}
+
+	if strings.TrimSpace(input.Value) == "" {
+		if err := m.Forget(req.ChannelID, input.Key); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("Forgot %q.", input.Key), nil
+	}
+
+	ttl := time.Duration(input.TTLSeconds) * time.Second
+	entry, err := m.Set(req.ChannelID, input.Key, input.Value, req.UserID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Get returns the unexpired entry for key in a channel
+func (m *Memory) Get(channelID, key string) (Entry, bool, error) {
+	return m.getEntry(bucket(channelID), key)
+}
+
+// List returns every unexpired entry in a channel, sorted by key, and drops expired ones
+func (m *Memory) List(channelID string) []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.listLocked(bucket(channelID))
+}
+
+// Set remembers value under key in a channel. A zero ttl never expires.
+func (m *Memory) Set(channelID, key, value, userID string, ttl time.Duration) (Entry, error) {
+	return m.setEntry(bucket(channelID), MaxEntries, key, value, userID, ttl)
+}
+
+// Forget removes key from a channel's memory
+func (m *Memory) Forget(channelID, key string) error {
+	return m.forgetEntry(bucket(channelID), key)
+}
+
+func (m *Memory) getEntry(bucket, key string) (Entry, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entry Entry
+	found, err := m.store.Get(bucket, normalizeKey(key), &entry)
+	if err != nil || !found {
+		return Entry{}, false, err
+	}
+	if entry.expired(time.Now()) {
+		m.store.Delete(bucket, entry.Key)
+		return Entry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+func (m *Memory) listLocked(bucket string) []Entry {
+	now := time.Now()
+	var entries []Entry
+	for _, key := range m.store.Keys(bucket) {
+		var entry Entry
+		if found, err := m.store.Get(bucket, key, &entry); err != nil || !found {
+			continue
+		}
+		if entry.expired(now) {
+			m.store.Delete(bucket, key)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (m *Memory) setEntry(bucket string, maxEntries int, key, value, userID string, ttl time.Duration) (Entry, error) {
+	key = normalizeKey(key)
+	if key == "" || len(key) > MaxKeyLength {
+		return Entry{}, fmt.Errorf("key must be 1-%d characters", MaxKeyLength)
+	}
+	if len(value) > MaxValueLength {
+		return Entry{}, fmt.Errorf("value is %d characters; the limit is %d", len(value), MaxValueLength)
+	}
+	if ttl < 0 || ttl > MaxTTL {
+		return Entry{}, fmt.Errorf("ttl must be between 0 and %d seconds", int(MaxTTL.Seconds()))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var existing Entry
+	exists, _ := m.store.Get(bucket, key, &existing)
+	if !exists && len(m.listLocked(bucket)) >= maxEntries {
+		return Entry{}, fmt.Errorf("already remembering %d facts here; forget one first", maxEntries)
+	}
+
+	now := time.Now()
+	entry := Entry{Key: key, Value: value, UpdatedBy: userID, UpdatedAt: now.Format(time.RFC3339)}
+	if ttl > 0 {
+		entry.ExpiresAt = now.Add(ttl).Format(time.RFC3339)
+	}
+	if err := m.store.Put(bucket, key, entry); err != nil {
+		return Entry{}, fmt.Errorf("failed to save memory: %w", err)
+	}
+	return entry, nil
+}
+
+func (m *Memory) forgetEntry(bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.store.Delete(bucket, normalizeKey(key)); err != nil {
+		return fmt.Errorf("failed to forget memory: %w", err)
+	}
+	return nil
+}
+
+// normalizeKey makes keys case- and whitespace-insensitive
+func normalizeKey(key string) string {
+	return strings.Join(strings.Fields(strings.ToLower(key)), "_")
+}
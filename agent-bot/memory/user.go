@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"agent-bot/tools"
+)
+
+// MaxUserEntries bounds how many facts are kept about one user
+const MaxUserEntries = 30
+
+var errNoUser = errors.New("user memory needs to know who is asking")
+
+type RememberArgs struct {
+	Key   string `json:"key" jsonschema_description:"Short identifier such as team, manager, timezone or preferred_language"`
+	Value string `json:"value" jsonschema_description:"The fact about the user, in their words (max 1000 characters); an empty value forgets the key"`
+}
+
+func userBucket(userID string) string {
+	return "user-memory:" + userID
+}
+
+// UserTools returns the user_memory_set tool. Facts are read back through
+// UserFacts and added to prompts, so there is no read tool.
+func (m *Memory) UserTools() []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "user_memory_set",
+			Description: "Remember a fact about the user you are talking to, for all their future conversations. Only use it when the user explicitly asks you to remember something about themselves (\"remember that I...\") or to forget it; set an empty value to forget. Never store secrets.",
+			Schema:      tools.SchemaFor[RememberArgs](),
+			Handler:     tools.Typed(m.rememberUser),
+		},
+	}
+}
+
+func (m *Memory) rememberUser(ctx context.Context, input RememberArgs) (interface{}, error) {
+	req, ok := tools.RequestFrom(ctx)
+	if !ok || req.UserID == "" {
+		return nil, errNoUser
+	}
+
+	if strings.TrimSpace(input.Value) == "" {
+		if err := m.ForgetUserFact(req.UserID, input.Key); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("Forgot %q.", input.Key), nil
+	}
+
+	entry, err := m.setEntry(userBucket(req.UserID), MaxUserEntries, input.Key, input.Value, req.UserID, 0)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// UserFacts returns everything remembered about a user, sorted by key
+func (m *Memory) UserFacts(userID string) []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.listLocked(userBucket(userID))
+}
+
+// ForgetUserFact removes one fact about a user
+func (m *Memory) ForgetUserFact(userID, key string) error {
+	return m.forgetEntry(userBucket(userID), key)
+}
+
+// ForgetUser removes everything remembered about a user and returns how many facts were dropped
+func (m *Memory) ForgetUser(userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := m.store.Keys(userBucket(userID))
+	for _, key := range keys {
+		if err := m.store.Delete(userBucket(userID), key); err != nil {
+			return 0, fmt.Errorf("failed to forget memory: %w", err)
+		}
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,123 @@
+// Package metrics is a minimal registry of counters and gauges served in the
+// Prometheus text format. The package-level Inc, Add and Set record to the
+// default registry.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds counters and gauges and renders them in the Prometheus text format
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters: make(map[string]float64),
+		gauges:   make(map[string]float64),
+	}
+}
+
+// Default is the process-wide registry used by the package-level helpers
+var Default = NewRegistry()
+
+// Inc adds one to a counter. Labels are given as alternating name/value pairs.
+func Inc(name string, labels ...string) {
+	Default.Add(name, 1, labels...)
+}
+
+// Add increases a counter by delta
+func Add(name string, delta float64, labels ...string) {
+	Default.Add(name, delta, labels...)
+}
+
+// Set sets a gauge to value
+func Set(name string, value float64, labels ...string) {
+	Default.Set(name, value, labels...)
+}
+
+// Add increases a counter by delta
+func (r *Registry) Add(name string, delta float64, labels ...string) {
+	key := seriesKey(name, labels)
+	r.mu.Lock()
+	r.counters[key] += delta
+	r.mu.Unlock()
+}
+
+// Set sets a gauge to value
+func (r *Registry) Set(name string, value float64, labels ...string) {
+	key := seriesKey(name, labels)
+	r.mu.Lock()
+	r.gauges[key] = value
+	r.mu.Unlock()
+}
+
+// Value returns the current value of a counter or gauge series
+func (r *Registry) Value(name string, labels ...string) float64 {
+	key := seriesKey(name, labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if value, ok := r.counters[key]; ok {
+		return value
+	}
+	return r.gauges[key]
+}
+
+// WritePrometheus renders every series in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	writeFamily(w, r.counters, "counter")
+	writeFamily(w, r.gauges, "gauge")
+}
+
+// Handler serves the registry at a /metrics endpoint
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WritePrometheus(w)
+	})
+}
+
+func writeFamily(w io.Writer, series map[string]float64, kind string) {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	typed := make(map[string]bool)
+	for _, key := range keys {
+		name := key
+		if i := strings.IndexByte(key, '{'); i >= 0 {
+			name = key[:i]
+		}
+		if !typed[name] {
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+			typed[name] = true
+		}
+		fmt.Fprintf(w, "%s %g\n", key, series[key])
+	}
+}
+
+func seriesKey(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
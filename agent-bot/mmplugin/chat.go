@@ -0,0 +1,284 @@
+package mmplugin
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Image limits match the REST adapter
+const (
+	maxImageAttachments = 5
+	maxImageBytes       = 5 * 1024 * 1024
+)
+
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Audio limits match the REST adapter
+const (
+	maxAudioAttachments = 3
+	maxAudioBytes       = 25 * 1024 * 1024
+)
+
+// PostMessage posts as the bot, in the thread when ThreadId is set
+func (c *Client) PostMessage(message types.ChatMessage) (string, error) {
+	post := &model.Post{
+		UserId:    c.BotUserID,
+		ChannelId: message.ChannelId,
+		Message:   message.Message,
+		RootId:    message.ThreadId,
+	}
+	if len(message.Attachments) > 0 {
+		post.AddProp("attachments", attachments(message.Attachments))
+	}
+
+	created, appErr := c.api.CreatePost(post)
+	if appErr != nil {
+		return "", fmt.Errorf("failed to post message: %w", appErr)
+	}
+	return created.Id, nil
+}
+
+// attachments converts cards to message attachments. Mattermost buttons call
+// an integration rather than open a URL, so link buttons become links at the
+// end of the card's text.
+func attachments(cards []types.Attachment) []*model.SlackAttachment {
+	converted := make([]*model.SlackAttachment, 0, len(cards))
+	for _, card := range cards {
+		attachment := &model.SlackAttachment{
+			Fallback:   card.Fallback,
+			Color:      card.Color,
+			Pretext:    card.Pretext,
+			AuthorName: card.AuthorName,
+			AuthorLink: card.AuthorLink,
+			AuthorIcon: card.AuthorIcon,
+			Title:      card.Title,
+			TitleLink:  card.TitleLink,
+			Text:       strings.TrimSpace(card.Text + "\n\n" + card.ButtonLinks()),
+			Footer:     card.Footer,
+		}
+		for _, field := range card.Fields {
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: field.Title,
+				Value: field.Value,
+				Short: model.SlackCompatibleBool(field.Short),
+			})
+		}
+		converted = append(converted, attachment)
+	}
+	return converted
+}
+
+// UpdateMessage replaces the text of one of the bot's posts
+func (c *Client) UpdateMessage(messageID string, newContent string) error {
+	post, appErr := c.api.GetPost(messageID)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return types.ErrMessageDeleted
+		}
+		return fmt.Errorf("failed to get post for update: %w", appErr)
+	}
+	// Updating a deleted post would bring its content back
+	if post.DeleteAt != 0 {
+		return types.ErrMessageDeleted
+	}
+
+	post.Message = newContent
+	if _, appErr := c.api.UpdatePost(post); appErr != nil {
+		return fmt.Errorf("failed to update message: %w", appErr)
+	}
+	return nil
+}
+
+func (c *Client) SendTypingIndicator(channelID, threadID string) error {
+	if appErr := c.api.PublishUserTyping(c.BotUserID, channelID, threadID); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func (c *Client) GetMessage(messageID string) (*types.Message, error) {
+	post, appErr := c.api.GetPost(messageID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return message(post), nil
+}
+
+// GetThreadMessages returns a thread oldest first. The plugin API has no
+// paging, so long threads come back in one piece.
+func (c *Client) GetThreadMessages(threadID string) ([]*types.Message, error) {
+	thread, appErr := c.api.GetPostThread(threadID)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	messages := make([]*types.Message, 0, len(thread.Posts))
+	for _, post := range thread.Posts {
+		messages = append(messages, message(post))
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Timestamp < messages[j].Timestamp
+	})
+	return messages, nil
+}
+
+func message(post *model.Post) *types.Message {
+	return &types.Message{
+		ID:        post.Id,
+		UserID:    post.UserId,
+		ChannelID: post.ChannelId,
+		ThreadID:  post.RootId,
+		Content:   post.Message,
+		Timestamp: post.CreateAt,
+	}
+}
+
+func (c *Client) GetUser(userID string) (*types.User, error) {
+	user, appErr := c.api.GetUser(userID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &types.User{ID: user.Id, Username: user.Username, IsBot: user.IsBot}, nil
+}
+
+func (c *Client) GetChannel(channelID string) (*types.Channel, error) {
+	channel, appErr := c.api.GetChannel(channelID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	result := &types.Channel{
+		ID:          channel.Id,
+		Name:        channel.Name,
+		DisplayName: channel.DisplayName,
+		Purpose:     channel.Purpose,
+		Header:      channel.Header,
+		Private:     channel.Type != model.ChannelTypeOpen,
+	}
+	if channel.TeamId != "" {
+		team, appErr := c.api.GetTeam(channel.TeamId)
+		if appErr != nil {
+			return nil, appErr
+		}
+		result.Team = team.Name
+	}
+	return result, nil
+}
+
+func (c *Client) GetImages(fileIDs []string) ([]types.Image, error) {
+	var images []types.Image
+	for _, fileID := range fileIDs {
+		if len(images) >= maxImageAttachments {
+			log.Printf("[%s] IMAGES: Skipping attachments beyond the first %d images", time.Now().Format("2006-01-02 15:04:05"), maxImageAttachments)
+			break
+		}
+
+		info, appErr := c.api.GetFileInfo(fileID)
+		if appErr != nil {
+			return images, fmt.Errorf("failed to get file info for %s: %w", fileID, appErr)
+		}
+		if !supportedImageTypes[info.MimeType] {
+			continue
+		}
+		if info.Size > maxImageBytes {
+			log.Printf("[%s] IMAGES: Skipping %s, %d bytes is over the %d byte limit", time.Now().Format("2006-01-02 15:04:05"), info.Name, info.Size, maxImageBytes)
+			continue
+		}
+
+		data, appErr := c.api.GetFile(fileID)
+		if appErr != nil {
+			return images, fmt.Errorf("failed to download %s: %w", info.Name, appErr)
+		}
+		images = append(images, types.Image{Name: info.Name, MediaType: info.MimeType, Data: data})
+	}
+	return images, nil
+}
+
+func (c *Client) GetAudio(fileIDs []string) ([]types.Audio, error) {
+	var audio []types.Audio
+	for _, fileID := range fileIDs {
+		if len(audio) >= maxAudioAttachments {
+			log.Printf("[%s] TRANSCRIBE: Skipping attachments beyond the first %d audio files", time.Now().Format("2006-01-02 15:04:05"), maxAudioAttachments)
+			break
+		}
+
+		info, appErr := c.api.GetFileInfo(fileID)
+		if appErr != nil {
+			return audio, fmt.Errorf("failed to get file info for %s: %w", fileID, appErr)
+		}
+		if !strings.HasPrefix(info.MimeType, "audio/") {
+			continue
+		}
+		if info.Size > maxAudioBytes {
+			log.Printf("[%s] TRANSCRIBE: Skipping %s, %d bytes is over the %d byte limit", time.Now().Format("2006-01-02 15:04:05"), info.Name, info.Size, maxAudioBytes)
+			continue
+		}
+
+		data, appErr := c.api.GetFile(fileID)
+		if appErr != nil {
+			return audio, fmt.Errorf("failed to download %s: %w", info.Name, appErr)
+		}
+		audio = append(audio, types.Audio{Name: info.Name, MediaType: info.MimeType, Data: data})
+	}
+	return audio, nil
+}
+
+// UploadFile uploads the file to the channel and posts it with the message
+func (c *Client) UploadFile(upload types.FileUpload) error {
+	info, appErr := c.api.UploadFile(upload.Data, upload.ChannelId, upload.Filename)
+	if appErr != nil {
+		return fmt.Errorf("failed to upload %s: %w", upload.Filename, appErr)
+	}
+
+	post := &model.Post{
+		UserId:    c.BotUserID,
+		ChannelId: upload.ChannelId,
+		Message:   upload.Message,
+		RootId:    upload.ThreadId,
+		FileIds:   model.StringArray{info.Id},
+	}
+	if _, appErr := c.api.CreatePost(post); appErr != nil {
+		return fmt.Errorf("failed to post %s: %w", upload.Filename, appErr)
+	}
+	return nil
+}
+
+// PostEphemeral shows a message to one user. Unlike the REST API, plugins
+// need no extra permission for this.
+func (c *Client) PostEphemeral(userID string, message types.ChatMessage) error {
+	c.api.SendEphemeralPost(userID, &model.Post{
+		UserId:    c.BotUserID,
+		ChannelId: message.ChannelId,
+		Message:   message.Message,
+		RootId:    message.ThreadId,
+	})
+	return nil
+}
+
+func (c *Client) AddReaction(messageID, emoji string) error {
+	reaction := &model.Reaction{UserId: c.BotUserID, PostId: messageID, EmojiName: emoji}
+	if _, appErr := c.api.AddReaction(reaction); appErr != nil {
+		return fmt.Errorf("failed to add reaction: %w", appErr)
+	}
+	return nil
+}
+
+func (c *Client) RemoveReaction(messageID, emoji string) error {
+	reaction := &model.Reaction{UserId: c.BotUserID, PostId: messageID, EmojiName: emoji}
+	if appErr := c.api.RemoveReaction(reaction); appErr != nil {
+		return fmt.Errorf("failed to remove reaction: %w", appErr)
+	}
+	return nil
+}
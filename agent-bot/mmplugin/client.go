@@ -0,0 +1,93 @@
+// Package mmplugin runs the agent core inside Mattermost as a server plugin,
+// for installs that can't run a separate container. Events arrive through
+// plugin hooks instead of the websocket, and replies go through the plugin
+// API instead of the REST API.
+package mmplugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+// Client is the bot account of the plugin. It implements types.Chat with
+// Mattermost post, channel and user IDs, like the REST adapter.
+type Client struct {
+	api plugin.API
+
+	// Identity of the bot, filled in by Identify
+	BotUserID   string
+	BotUsername string
+
+	agent   atomic.Pointer[types.Agent]
+	handler atomic.Pointer[http.Handler]
+
+	// teams caches the team of each channel for usage attribution
+	teams sync.Map
+}
+
+// NewClient creates a client acting as the bot user botUserID
+func NewClient(api plugin.API, botUserID string) *Client {
+	return &Client{api: api, BotUserID: botUserID}
+}
+
+// Identify looks up the bot's username
+func (c *Client) Identify(ctx context.Context) error {
+	user, appErr := c.api.GetUser(c.BotUserID)
+	if appErr != nil {
+		return fmt.Errorf("failed to get bot user %s: %w", c.BotUserID, appErr)
+	}
+	c.BotUsername = user.Username
+	return nil
+}
+
+// Identity returns the bot's user ID and username
+func (c *Client) Identity() (userID, username string) {
+	return c.BotUserID, c.BotUsername
+}
+
+// TeamOf returns the team a channel belongs to; DMs and group messages have none
+func (c *Client) TeamOf(channelID string) string {
+	if teamID, ok := c.teams.Load(channelID); ok {
+		return teamID.(string)
+	}
+	channel, appErr := c.api.GetChannel(channelID)
+	if appErr != nil {
+		return ""
+	}
+	c.teams.Store(channelID, channel.TeamId)
+	return channel.TeamId
+}
+
+// Listen delivers hook events to agent until ctx is cancelled. Events that
+// arrive before Listen is called are dropped.
+func (c *Client) Listen(ctx context.Context, agent types.Agent) error {
+	c.agent.Store(&agent)
+	<-ctx.Done()
+	c.agent.Store(nil)
+	return nil
+}
+
+// Connected reports whether an agent is receiving the hooks
+func (c *Client) Connected() bool {
+	return c.agent.Load() != nil
+}
+
+// Handle serves the plugin's HTTP routes, /plugins/<id>/..., with handler
+func (c *Client) Handle(handler http.Handler) {
+	c.handler.Store(&handler)
+}
+
+// currentAgent returns the agent receiving events, or nil before Listen
+func (c *Client) currentAgent() types.Agent {
+	if agent := c.agent.Load(); agent != nil {
+		return *agent
+	}
+	return nil
+}
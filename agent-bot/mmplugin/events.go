@@ -0,0 +1,82 @@
+package mmplugin
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func (c *Client) postPosted(post *model.Post) {
+	agent := c.currentAgent()
+	// Don't respond to our own posts, joins and other system messages
+	if agent == nil || post.UserId == c.BotUserID || post.IsSystemMessage() {
+		return
+	}
+
+	channel, appErr := c.api.GetChannel(post.ChannelId)
+	if appErr != nil {
+		log.Printf("[%s] PLUGIN: Failed to get channel %s of post %s: %v", time.Now().Format("2006-01-02 15:04:05"), post.ChannelId, post.Id, appErr)
+		return
+	}
+	c.teams.Store(channel.Id, channel.TeamId)
+
+	agent.MessagePosted(types.PostedMessage{
+		PostId:    post.Id,
+		UserId:    post.UserId,
+		ThreadId:  post.RootId,
+		ChannelId: post.ChannelId,
+		Message:   post.Message,
+		IsDM:      channel.Type == model.ChannelTypeDirect,
+		FileIds:   post.FileIds,
+		Mentioned: c.mentioned(post.Message),
+	})
+}
+
+// mentioned reports whether text @-mentions the bot. The server works out
+// mentions when it sends notifications, but doesn't pass them to plugins.
+func (c *Client) mentioned(text string) bool {
+	mention := "@" + strings.ToLower(c.BotUsername)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if strings.TrimRight(word, ".,:;!?") == mention {
+			return true
+		}
+	}
+	return false
+}
+
+// postUpdated reports edits to the bot's posts. The v6 plugin API has no
+// hook for deletions; streaming notices them when its next update fails.
+func (c *Client) postUpdated(post *model.Post) {
+	if agent := c.currentAgent(); agent != nil && post.UserId == c.BotUserID {
+		agent.MessageEdited(post.Id, post.Message)
+	}
+}
+
+func (c *Client) reactionAdded(reaction *model.Reaction) {
+	agent := c.currentAgent()
+	if agent == nil || reaction.UserId == c.BotUserID {
+		return
+	}
+	// Reactions don't carry their channel
+	post, appErr := c.api.GetPost(reaction.PostId)
+	if appErr != nil {
+		log.Printf("[%s] PLUGIN: Failed to get post %s for a reaction: %v", time.Now().Format("2006-01-02 15:04:05"), reaction.PostId, appErr)
+		return
+	}
+	agent.ReactionAdded(types.Reaction{
+		PostId:    reaction.PostId,
+		UserId:    reaction.UserId,
+		ChannelId: post.ChannelId,
+		Emoji:     reaction.EmojiName,
+	})
+}
+
+func (c *Client) channelJoined(member *model.ChannelMember) {
+	if agent := c.currentAgent(); agent != nil && member.UserId == c.BotUserID {
+		agent.ChannelJoined(member.ChannelId)
+	}
+}
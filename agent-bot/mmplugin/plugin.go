@@ -0,0 +1,113 @@
+package mmplugin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+// defaultBotUsername is the bot account created when BOT_USERNAME isn't set
+const defaultBotUsername = "agent-bot"
+
+// Plugin implements the server plugin hooks. Settings from the System
+// Console are keyed by environment variable name (ANTHROPIC_API_KEY, ...),
+// so the plugin is configured like the container.
+type Plugin struct {
+	plugin.MattermostPlugin
+
+	// Run starts the agent core with the bot's client once the plugin is
+	// activated. settings holds the non-empty plugin settings.
+	Run func(client *Client, settings map[string]string)
+
+	client *Client
+}
+
+// OnActivate loads the settings, makes sure the bot account exists and
+// starts the agent core in the background
+func (p *Plugin) OnActivate() error {
+	var raw map[string]interface{}
+	if err := p.API.LoadPluginConfiguration(&raw); err != nil {
+		return fmt.Errorf("failed to load plugin settings: %w", err)
+	}
+	// The server stores setting keys lowercased
+	settings := make(map[string]string)
+	for key, value := range raw {
+		if value == nil {
+			continue
+		}
+		if s := fmt.Sprint(value); s != "" {
+			settings[strings.ToUpper(key)] = s
+		}
+	}
+
+	username := settings["BOT_USERNAME"]
+	if username == "" {
+		username = defaultBotUsername
+	}
+	botUserID, err := p.ensureBot(username, settings["BOT_DISPLAY_NAME"])
+	if err != nil {
+		return err
+	}
+
+	p.client = NewClient(p.API, botUserID)
+	go p.Run(p.client, settings)
+	return nil
+}
+
+// ensureBot returns the user ID of the bot account, creating it on first
+// activation
+func (p *Plugin) ensureBot(username, displayName string) (string, error) {
+	if user, appErr := p.API.GetUserByUsername(username); appErr == nil {
+		if !user.IsBot {
+			return "", fmt.Errorf("BOT_USERNAME %q belongs to a user account, not a bot", username)
+		}
+		return user.Id, nil
+	}
+
+	bot, appErr := p.API.CreateBot(&model.Bot{
+		Username:    username,
+		DisplayName: displayName,
+		Description: "AI assistant",
+	})
+	if appErr != nil {
+		return "", fmt.Errorf("failed to create bot account @%s: %w", username, appErr)
+	}
+	return bot.UserId, nil
+}
+
+// ServeHTTP serves the agent's HTTP routes under /plugins/<id>/, once it has
+// started
+func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	if p.client == nil || p.client.handler.Load() == nil {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+	(*p.client.handler.Load()).ServeHTTP(w, r)
+}
+
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if p.client != nil {
+		p.client.postPosted(post)
+	}
+}
+
+func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *model.Post) {
+	if p.client != nil {
+		p.client.postUpdated(newPost)
+	}
+}
+
+func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reaction) {
+	if p.client != nil {
+		p.client.reactionAdded(reaction)
+	}
+}
+
+func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	if p.client != nil {
+		p.client.channelJoined(channelMember)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"agent-bot/llms"
+	"agent-bot/metrics"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/routing"
+	"agent-bot/types"
+)
+
+// MODEL_ROUTING modes
+const (
+	routingOff       = "off"
+	routingHeuristic = "heuristic"
+	routingLLM       = "llm"
+)
+
+// newSimpleLLM returns the small model simple questions are routed to, behind
+// its own circuit breaker, or nil when model routing is off
+func newSimpleLLM(config Config, recorder llms.UsageRecorder) *llms.CircuitBreaker {
+	if config.ModelRouting == routingOff {
+		return nil
+	}
+	backend := llms.NewAnthropicBackend(config.AnthropicKey, config.RouterSimpleModel, config.MaxTokens, 0, false, nil) // Small model without tools
+	backend.SetRetryPolicy(retryPolicy(config))
+	if recorder != nil {
+		backend.SetUsageRecorder(recorder)
+	}
+	return llms.NewCircuitBreaker(breakerName(config, "simple"), backend, config.LLMBreakerThreshold, config.LLMBreakerCooldown)
+}
+
+type routeKey struct{}
+
+// routeReply decides which model answers message and records it in ctx for
+// replyLLM. threadContext is the conversation ending with message.
+func (a *BotAgent) routeReply(ctx context.Context, message types.PostedMessage, threadContext string) context.Context {
+	if a.simpleLLM == nil {
+		return ctx
+	}
+	route, engine := a.classifyReply(ctx, message, threadContext)
+	metrics.Inc("model_routes_total", "route", string(route), "engine", engine)
+	reqid.Logf(ctx, "ROUTING: Routed %s to the %s model (%s)", message.PostId, route, engine)
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// classifyReply returns the route for a reply and what decided it
+func (a *BotAgent) classifyReply(ctx context.Context, message types.PostedMessage, threadContext string) (routing.Route, string) {
+	// The small model gets no images and no thinking budget
+	if len(message.FileIds) > 0 {
+		return routing.Complex, "attachments"
+	}
+	if a.thinking != nil && a.thinking.For(message.ChannelId).BudgetTokens > 0 {
+		return routing.Complex, "thinking"
+	}
+
+	if a.routing == routingLLM {
+		route, err := a.classifyWithLLM(ctx, threadContext)
+		if err == nil {
+			return route, "llm"
+		}
+		reqid.Logf(ctx, "ROUTING: Decision LLM classification failed, using heuristic: %v", err)
+	}
+	return routing.Classify(message.Message), "heuristic"
+}
+
+// classifyWithLLM asks the decision LLM for the route, within the same
+// latency and token limits as reply decisions
+func (a *BotAgent) classifyWithLLM(ctx context.Context, threadContext string) (routing.Route, error) {
+	if allowed, reason := a.decisionGuard.allow(); !allowed {
+		return "", fmt.Errorf("decision LLM skipped (%s)", reason)
+	}
+	prompt, err := a.prompts.Render(prompts.Route, prompts.RouteData{Context: threadContext})
+	if err != nil {
+		return "", err
+	}
+
+	startTime := time.Now()
+	answer, err := a.promptDecisionLLM(ctx, "model_route", prompt)
+	a.decisionGuard.record(time.Since(startTime), estimateTokens(prompt)+estimateTokens(answer))
+	if err != nil {
+		return "", err
+	}
+	route, ok := routing.ParseAnswer(answer)
+	if !ok {
+		return "", fmt.Errorf("unclear answer %q", answer)
+	}
+	return route, nil
+}
+
+// replyLLM returns the model the reply being generated with ctx was routed to
+func (a *BotAgent) replyLLM(ctx context.Context) types.LLM {
+	if route, _ := ctx.Value(routeKey{}).(routing.Route); route == routing.Simple && a.simpleLLM != nil {
+		return a.simpleLLM
+	}
+	return a.llm
+}
+
+// routingSummary describes the model routing settings
+func routingSummary(c Config) string {
+	if c.ModelRouting == routingOff {
+		return "off"
+	}
+	return fmt.Sprintf("%s, simple questions to %s", c.ModelRouting, c.RouterSimpleModel)
+}
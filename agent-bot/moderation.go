@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/metrics"
+	"agent-bot/moderation"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/store"
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+// moderationViolationsBucket keeps recent moderation violations for !moderation
+const moderationViolationsBucket = "moderation_violations"
+
+const (
+	// maxModerationViolations bounds the violations kept in the state file
+	maxModerationViolations = 200
+	// maxViolationExcerpt bounds how much of flagged text is kept
+	maxViolationExcerpt = 200
+)
+
+// defaultModerationCategories is MODERATION_CATEGORIES when unset
+const defaultModerationCategories = "hate speech, harassment, sexual content, graphic violence, self-harm, illegal activity"
+
+// withheldReply replaces replies moderation blocks
+const withheldReply = "_This reply was withheld by content moderation._"
+
+// moderationViolation is one message moderation redacted or blocked
+type moderationViolation struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Action    string    `json:"action"`
+	Screener  string    `json:"screener"`
+	Category  string    `json:"category"`
+	UserID    string    `json:"user_id,omitempty"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	ThreadID  string    `json:"thread_id,omitempty"`
+	// Excerpt is the start of the original text, for admins to judge the call
+	Excerpt string `json:"excerpt"`
+}
+
+// moderator screens the agent's incoming messages and replies and records
+// violations for admins
+type moderator struct {
+	pipeline *moderation.Pipeline
+	store    *store.Store
+}
+
+// newModerator builds the moderation pipeline from the validated keyword
+// lists and settings, or returns nil when there is nothing to screen with
+func newModerator(config Config, fileConfig *FileConfig, decisionLLM types.LLM, promptSet *prompts.Set, stateStore *store.Store) *moderator {
+	lists := append([]moderation.KeywordList(nil), fileConfig.ModerationKeywords...)
+	if config.ModerationProfanity != "off" {
+		profanity, err := moderation.Profanity(moderation.Action(config.ModerationProfanity))
+		if err != nil {
+			log.Fatalf("Invalid MODERATION_PROFANITY: %v", err)
+		}
+		lists = append(lists, profanity)
+	}
+
+	var classifier moderation.Screener
+	switch config.ModerationClassifier {
+	case "llm":
+		classifier = moderation.LLMClassifier{Prompt: func(ctx context.Context, direction moderation.Direction, text string) (string, error) {
+			prompt, err := promptSet.Render(prompts.Moderation, prompts.ModerationData{Direction: string(direction), Categories: config.ModerationCategories, Text: text})
+			if err != nil {
+				return "", err
+			}
+			return decisionLLM.Prompt(ctx, prompt)
+		}}
+	case "api":
+		classifier = moderation.NewAPIClassifier(config.ModerationAPIURL, config.ModerationAPIKey, config.ModerationAPIModel)
+	}
+
+	var classifiers []moderation.Screener
+	if classifier != nil {
+		switch config.ModerationClassify {
+		case "incoming":
+			classifier = moderation.For(moderation.Incoming, classifier)
+		case "outgoing":
+			classifier = moderation.For(moderation.Outgoing, classifier)
+		}
+		classifiers = append(classifiers, classifier)
+	}
+
+	pipeline := moderation.NewPipeline(lists, classifiers...)
+	if pipeline.Empty() {
+		return nil
+	}
+	return &moderator{pipeline: pipeline, store: stateStore}
+}
+
+// screen runs the whole pipeline, letting text through when a classifier fails
+func (m *moderator) screen(ctx context.Context, direction moderation.Direction, text string, req tools.Request) moderation.Verdict {
+	verdict, err := m.pipeline.Screen(ctx, direction, text)
+	if err != nil {
+		reqid.Logf(ctx, "MODERATION: Classifier failed, letting %s text through: %v", direction, err)
+	}
+	if verdict.Action != moderation.Allow {
+		m.record(direction, verdict, text, req)
+	}
+	return verdict
+}
+
+// record logs a violation and keeps it for !moderation
+func (m *moderator) record(direction moderation.Direction, verdict moderation.Verdict, text string, req tools.Request) {
+	log.Printf("[%s] MODERATION: %s %s text from %s in %s (%s: %s)", time.Now().Format("2006-01-02 15:04:05"), verdict.Action, direction, req.UserID, req.ChannelID, verdict.Screener, verdict.Category)
+	metrics.Inc("moderation_violations_total", "direction", string(direction), "action", string(verdict.Action))
+
+	if m.store == nil {
+		return
+	}
+	violation := moderationViolation{
+		Time:      time.Now(),
+		Direction: string(direction),
+		Action:    string(verdict.Action),
+		Screener:  verdict.Screener,
+		Category:  verdict.Category,
+		UserID:    req.UserID,
+		ChannelID: req.ChannelID,
+		ThreadID:  req.ThreadID,
+		Excerpt:   excerpt(text),
+	}
+	// Keys sort by time, so the oldest come first
+	key := fmt.Sprintf("%020d", violation.Time.UnixNano())
+	if err := m.store.Put(moderationViolationsBucket, key, violation); err != nil {
+		log.Printf("[%s] MODERATION: Failed to save violation: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return
+	}
+	keys := m.store.Keys(moderationViolationsBucket)
+	for _, old := range keys[:max(0, len(keys)-maxModerationViolations)] {
+		m.store.Delete(moderationViolationsBucket, old)
+	}
+}
+
+func excerpt(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxViolationExcerpt {
+		return string(runes[:maxViolationExcerpt]) + "…"
+	}
+	return text
+}
+
+// moderating reports whether the agent screens messages right now
+func (a *BotAgent) moderating() bool {
+	return a.moderator != nil && a.features.Enabled(FeatureModeration)
+}
+
+// moderateIncoming screens a message before it is answered. It returns the
+// message to answer, possibly redacted, and false if it was blocked.
+func (a *BotAgent) moderateIncoming(ctx context.Context, message types.PostedMessage) (types.PostedMessage, bool) {
+	if !a.moderating() {
+		return message, true
+	}
+	req := tools.Request{UserID: message.UserId, ChannelID: message.ChannelId, ThreadID: message.ThreadId}
+	verdict := a.moderator.screen(ctx, moderation.Incoming, message.Message, req)
+	if verdict.Action == moderation.Block {
+		notice := types.PostedMessage{UserId: message.UserId, ChannelId: message.ChannelId, ThreadId: message.ThreadId}
+		if notice.ThreadId == "" && message.Mentioned {
+			notice.ThreadId = message.PostId
+		}
+		a.postNotice(ctx, notice, fmt.Sprintf("Sorry, I can't respond to that message: content moderation flagged it (%s).", verdict.Category))
+		return message, false
+	}
+	message.Message = verdict.Text
+	return message, true
+}
+
+// moderateReply screens a finished reply, returning it redacted or replaced
+// with a note when it was blocked
+func (a *BotAgent) moderateReply(ctx context.Context, reply string) string {
+	if !a.moderating() || reply == "" {
+		return reply
+	}
+	req, _ := tools.RequestFrom(ctx)
+	verdict := a.moderator.screen(ctx, moderation.Outgoing, reply, req)
+	if verdict.Action == moderation.Block {
+		return withheldReply
+	}
+	return verdict.Text
+}
+
+// moderatePartial redacts a streamed reply in progress with the keyword
+// lists. It returns false when the update must not be shown at all; the
+// finished reply is screened in full.
+func (a *BotAgent) moderatePartial(text string) (string, bool) {
+	if !a.moderating() {
+		return text, true
+	}
+	verdict := a.moderator.pipeline.ScreenLocal(moderation.Outgoing, text)
+	return verdict.Text, verdict.Action != moderation.Block
+}
+
+// handleModerationCommand implements "!moderation [count]"
+func (b *Bot) handleModerationCommand(message types.PostedMessage, args []string) string {
+	limit := 10
+	if len(args) > 0 {
+		if _, err := fmt.Sscanf(args[0], "%d", &limit); err != nil || limit <= 0 {
+			return "Usage: `!moderation [count]` lists the most recent moderation violations"
+		}
+	}
+
+	keys := b.store.Keys(moderationViolationsBucket)
+	if len(keys) == 0 {
+		return "No moderation violations recorded."
+	}
+	keys = keys[max(0, len(keys)-limit):]
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Moderation violations** (latest %d of %d)\n", len(keys), len(b.store.Keys(moderationViolationsBucket))))
+	for i := len(keys) - 1; i >= 0; i-- {
+		var v moderationViolation
+		if found, err := b.store.Get(moderationViolationsBucket, keys[i], &v); err != nil || !found {
+			continue
+		}
+		who := v.UserID
+		if v.Direction == string(moderation.Outgoing) {
+			who = "reply to " + v.UserID
+		}
+		sb.WriteString(fmt.Sprintf("- %s %s %s (%s, %s) from %s in `%s`: %q\n", v.Time.Format("2006-01-02 15:04"), v.Action, v.Direction, v.Screener, v.Category, who, v.ChannelID, v.Excerpt))
+	}
+	return sb.String()
+}
+
+func (b *Bot) moderationSummary() string {
+	agent, ok := b.agent.(*BotAgent)
+	if !ok || agent.moderator == nil {
+		return "off"
+	}
+	var parts []string
+	if lists := agent.moderator.pipeline.Lists(); len(lists) > 0 {
+		parts = append(parts, "keyword lists "+strings.Join(lists, ", "))
+	}
+	if c := b.config; c.ModerationClassifier != "off" {
+		parts = append(parts, fmt.Sprintf("%s classifier on %s messages", c.ModerationClassifier, c.ModerationClassify))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,138 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LLMClassifier blocks text a language model flags. Prompt sends the
+// classification request and returns the model's answer, JSON like
+// {"flagged": true, "category": "harassment"}.
+type LLMClassifier struct {
+	Prompt func(ctx context.Context, direction Direction, text string) (string, error)
+}
+
+// Screen asks the model about text
+func (c LLMClassifier) Screen(ctx context.Context, direction Direction, text string) (Verdict, error) {
+	response, err := c.Prompt(ctx, direction, text)
+	if err != nil {
+		return Verdict{Text: text}, fmt.Errorf("moderation model: %w", err)
+	}
+	flagged, category, err := ParseClassification(response)
+	if err != nil {
+		return Verdict{Text: text}, err
+	}
+	if !flagged {
+		return Verdict{Text: text}, nil
+	}
+	return Verdict{Action: Block, Screener: "llm", Category: category, Text: text}, nil
+}
+
+// ParseClassification reads the model's JSON answer, tolerating prose or
+// code fences around it
+func ParseClassification(response string) (flagged bool, category string, err error) {
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return false, "", fmt.Errorf("moderation model answered without JSON: %q", response)
+	}
+	var answer struct {
+		Flagged  bool   `json:"flagged"`
+		Category string `json:"category"`
+	}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &answer); err != nil {
+		return false, "", fmt.Errorf("failed to parse moderation answer: %w", err)
+	}
+	if answer.Category == "" {
+		answer.Category = "unspecified"
+	}
+	return answer.Flagged, answer.Category, nil
+}
+
+// DefaultAPIURL is OpenAI's moderation endpoint; compatible services take the same requests
+const DefaultAPIURL = "https://api.openai.com/v1/moderations"
+
+// APIClassifier blocks text a moderation API flags
+type APIClassifier struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+// NewAPIClassifier creates a classifier for an OpenAI-compatible moderation
+// endpoint; an empty model uses the service's default
+func NewAPIClassifier(url, apiKey, model string) *APIClassifier {
+	if url == "" {
+		url = DefaultAPIURL
+	}
+	return &APIClassifier{url: url, apiKey: apiKey, model: model, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+type moderationRequest struct {
+	Model string `json:"model,omitempty"`
+	Input string `json:"input"`
+}
+
+type moderationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// Screen sends text to the moderation API
+func (c *APIClassifier) Screen(ctx context.Context, direction Direction, text string) (Verdict, error) {
+	body, err := json.Marshal(moderationRequest{Model: c.model, Input: text})
+	if err != nil {
+		return Verdict{Text: text}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return Verdict{Text: text}, fmt.Errorf("failed to create moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Verdict{Text: text}, fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Verdict{Text: text}, fmt.Errorf("failed to read moderation response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Verdict{Text: text}, fmt.Errorf("moderation API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+
+	var parsed moderationResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return Verdict{Text: text}, fmt.Errorf("failed to parse moderation response: %w", err)
+	}
+	for _, result := range parsed.Results {
+		if !result.Flagged {
+			continue
+		}
+		var categories []string
+		for category, flagged := range result.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+		sort.Strings(categories)
+		category := strings.Join(categories, ", ")
+		if category == "" {
+			category = "unspecified"
+		}
+		return Verdict{Action: Block, Screener: "moderation API", Category: category, Text: text}, nil
+	}
+	return Verdict{Text: text}, nil
+}
@@ -0,0 +1,146 @@
+// Package moderation screens the messages users send the bot and the replies
+// it sends back. Keyword lists redact or block words and patterns; optional
+// classifiers (an LLM or a moderation API) block whole messages.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Direction says whether text is a user's message or the bot's reply
+type Direction string
+
+const (
+	Incoming Direction = "incoming"
+	Outgoing Direction = "outgoing"
+)
+
+// Action is what happens to text a screener flags
+type Action string
+
+const (
+	// Allow leaves the text alone
+	Allow Action = ""
+	// Redact masks the disallowed words and lets the rest through
+	Redact Action = "redact"
+	// Block stops the whole message
+	Block Action = "block"
+)
+
+// Verdict is a screener's decision on one text
+type Verdict struct {
+	Action Action
+	// Screener names the list or classifier that flagged the text, and
+	// Category what it found, e.g. "profanity" or "harassment"
+	Screener string
+	Category string
+	// Text is the text to use instead when it was redacted
+	Text string
+}
+
+// Screener checks text for disallowed content. Verdicts name the screener,
+// and carry the text unchanged when it is allowed.
+type Screener interface {
+	Screen(ctx context.Context, direction Direction, text string) (Verdict, error)
+}
+
+// KeywordList redacts or blocks words and patterns. Words match whole words
+// case-insensitively, with a trailing * matching any ending ("damn*").
+type KeywordList struct {
+	Name     string   `yaml:"name"`
+	Words    []string `yaml:"words"`
+	Patterns []string `yaml:"patterns"`
+	// Action is redact (the default) or block
+	Action Action `yaml:"action"`
+	// AppliesTo is incoming or outgoing; empty screens both
+	AppliesTo Direction `yaml:"applies_to"`
+
+	pattern *regexp.Regexp
+}
+
+// Validate checks keyword lists, fills in defaults and compiles them
+func Validate(lists []KeywordList) error {
+	seen := make(map[string]bool)
+	for i := range lists {
+		l := &lists[i]
+		if l.Name == "" {
+			return fmt.Errorf("keyword list %d has no name", i+1)
+		}
+		if seen[l.Name] {
+			return fmt.Errorf("duplicate keyword list name %q", l.Name)
+		}
+		seen[l.Name] = true
+		if err := l.compile(); err != nil {
+			return fmt.Errorf("keyword list %q: %w", l.Name, err)
+		}
+	}
+	return nil
+}
+
+func (l *KeywordList) compile() error {
+	if l.Action == Allow {
+		l.Action = Redact
+	}
+	switch l.Action {
+	case Redact, Block:
+	default:
+		return fmt.Errorf("action must be %s or %s, not %q", Redact, Block, l.Action)
+	}
+	switch l.AppliesTo {
+	case "", Incoming, Outgoing:
+	default:
+		return fmt.Errorf("applies_to must be %s or %s, not %q", Incoming, Outgoing, l.AppliesTo)
+	}
+
+	var alternatives []string
+	for _, word := range l.Words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		stem, wildcard := strings.CutSuffix(word, "*")
+		alternative := `\b` + regexp.QuoteMeta(stem)
+		if wildcard {
+			alternative += `\w*`
+		}
+		alternatives = append(alternatives, alternative+`\b`)
+	}
+	for _, pattern := range l.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+	if len(alternatives) == 0 {
+		return fmt.Errorf("needs words or patterns")
+	}
+
+	pattern, err := regexp.Compile(`(?i)` + strings.Join(alternatives, "|"))
+	if err != nil {
+		return err
+	}
+	l.pattern = pattern
+	return nil
+}
+
+// Screen redacts or blocks the list's words in text
+func (l *KeywordList) Screen(ctx context.Context, direction Direction, text string) (Verdict, error) {
+	if l.pattern == nil || l.AppliesTo != "" && l.AppliesTo != direction || !l.pattern.MatchString(text) {
+		return Verdict{Text: text}, nil
+	}
+	verdict := Verdict{Action: l.Action, Screener: l.Name, Category: l.Name, Text: text}
+	if l.Action == Redact {
+		verdict.Text = l.pattern.ReplaceAllStringFunc(text, mask)
+	}
+	return verdict, nil
+}
+
+// mask replaces a word with asterisks, keeping its first letter
+func mask(word string) string {
+	first, size := utf8.DecodeRuneInString(word)
+	return string(first) + strings.Repeat("*", utf8.RuneCountInString(word[size:]))
+}
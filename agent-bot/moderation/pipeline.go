@@ -0,0 +1,122 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+)
+
+// profanity is the built-in list behind Profanity
+var profanity = []string{
+	"fuck*", "motherfuck*", "shit*", "bullshit*", "bitch*", "asshole*", "bastard*",
+	"cunt*", "dickhead*", "wanker*", "twat*", "prick*", "piss off", "pissed off",
+}
+
+// Profanity returns a built-in list of common English swear words
+func Profanity(action Action) (KeywordList, error) {
+	list := KeywordList{Name: "profanity", Words: profanity, Action: action}
+	err := list.compile()
+	return list, err
+}
+
+// Pipeline runs keyword lists, then classifiers. Redactions accumulate and
+// the first block stops the message.
+type Pipeline struct {
+	lists       []KeywordList
+	classifiers []Screener
+}
+
+// NewPipeline creates a pipeline from validated keyword lists and classifiers
+func NewPipeline(lists []KeywordList, classifiers ...Screener) *Pipeline {
+	return &Pipeline{lists: lists, classifiers: classifiers}
+}
+
+// Lists returns the names of the keyword lists, in screening order
+func (p *Pipeline) Lists() []string {
+	names := make([]string, 0, len(p.lists))
+	for _, list := range p.lists {
+		names = append(names, list.Name)
+	}
+	return names
+}
+
+// Empty reports whether the pipeline has nothing to screen with
+func (p *Pipeline) Empty() bool {
+	return len(p.lists) == 0 && len(p.classifiers) == 0
+}
+
+// Screen screens text with every list and classifier. If a classifier fails,
+// the verdict so far is returned with the error.
+func (p *Pipeline) Screen(ctx context.Context, direction Direction, text string) (Verdict, error) {
+	verdict := p.ScreenLocal(direction, text)
+	if verdict.Action == Block {
+		return verdict, nil
+	}
+	for _, classifier := range p.classifiers {
+		result, err := classifier.Screen(ctx, direction, verdict.Text)
+		if err != nil {
+			return verdict, err
+		}
+		if verdict = merge(verdict, result); verdict.Action == Block {
+			return verdict, nil
+		}
+	}
+	return verdict, nil
+}
+
+// ScreenLocal screens text with the keyword lists only, which is cheap
+// enough for every update of a streamed reply
+func (p *Pipeline) ScreenLocal(direction Direction, text string) Verdict {
+	verdict := Verdict{Text: text}
+	for i := range p.lists {
+		// Keyword lists don't fail
+		result, _ := p.lists[i].Screen(context.Background(), direction, verdict.Text)
+		if verdict = merge(verdict, result); verdict.Action == Block {
+			break
+		}
+	}
+	return verdict
+}
+
+// merge adds a screener's verdict to the verdict so far
+func merge(so Verdict, result Verdict) Verdict {
+	switch result.Action {
+	case Allow:
+		return so
+	case Block:
+		result.Text = so.Text
+		return result
+	}
+	if so.Action == Allow {
+		return result
+	}
+	so.Text = result.Text
+	so.Screener = joinNames(so.Screener, result.Screener)
+	so.Category = joinNames(so.Category, result.Category)
+	return so
+}
+
+func joinNames(a, b string) string {
+	for _, name := range strings.Split(a, ", ") {
+		if name == b {
+			return a
+		}
+	}
+	return a + ", " + b
+}
+
+// For applies screener to one direction only and allows the other
+func For(direction Direction, screener Screener) Screener {
+	return oneWay{direction: direction, screener: screener}
+}
+
+type oneWay struct {
+	direction Direction
+	screener  Screener
+}
+
+func (o oneWay) Screen(ctx context.Context, direction Direction, text string) (Verdict, error) {
+	if direction != o.direction {
+		return Verdict{Text: text}, nil
+	}
+	return o.screener.Screen(ctx, direction, text)
+}
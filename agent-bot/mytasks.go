@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"agent-bot/asana"
+	"agent-bot/llms"
+	"agent-bot/prompts"
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+const (
+	// briefingUsersBucket stores the users who asked for a morning DM of their tasks
+	briefingUsersBucket = "briefing_users"
+	// myTaskButtonsBucket stores the task and owner behind each briefing card's
+	// buttons, keyed by a random ID
+	myTaskButtonsBucket = "my_task_buttons"
+)
+
+// myTaskButtonTTL is how long a briefing's buttons keep working
+const myTaskButtonTTL = 7 * 24 * time.Hour
+
+// myTasksPath is where Mattermost posts the briefing buttons' clicks
+const myTasksPath = "/mattermost/actions/my-tasks"
+
+// myTasksTimeout bounds looking up and summarizing one user's tasks
+const myTasksTimeout = 60 * time.Second
+
+// myTaskButton is the task a briefing card's buttons act on, and whose
+// briefing it was
+type myTaskButton struct {
+	UserID    string    `json:"user_id"`
+	TaskGID   string    `json:"task_gid"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// myTasksPattern recognizes "my tasks", "show me my Asana tasks", "what are my tasks for today?" and similar
+var myTasksPattern = regexp.MustCompile(`(?i)^(please\s+|can you\s+|could you\s+)?((show|list|give)\s+(me\s+)?|what\s+are\s+)?my\s+(asana\s+)?tasks(\s+(for\s+)?today)?(\s+please)?[.!?]*$`)
+
+// interceptMyTasks answers "@agent my tasks" with the user's briefing
+// instead of an LLM reply
+func (b *Bot) interceptMyTasks(message types.PostedMessage) bool {
+	if !message.Mentioned && !message.IsDM {
+		return false
+	}
+	text := strings.ReplaceAll(message.Message, "@"+b.config.BotUsername, "")
+	if !myTasksPattern.MatchString(strings.TrimSpace(text)) {
+		return false
+	}
+
+	threadID := message.ThreadId
+	if threadID == "" && !message.IsDM {
+		threadID = message.PostId
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), myTasksTimeout)
+		defer cancel()
+		briefing, err := b.myTasksBriefing(ctx, message.UserId, true)
+		if err != nil {
+			log.Printf("[%s] MY_TASKS: Failed to build the briefing for %s: %v", time.Now().Format("2006-01-02 15:04:05"), message.UserId, err)
+			briefing = types.ChatMessage{Message: "Sorry, I couldn't get your tasks from Asana right now."}
+		}
+		briefing.ChannelId = message.ChannelId
+		briefing.ThreadId = threadID
+		if _, err := b.chat.PostMessage(briefing); err != nil {
+			log.Printf("[%s] MY_TASKS: Failed to post the briefing: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+	}()
+	return true
+}
+
+// userToday returns the user's current date in their Mattermost time zone,
+// falling back to the scheduler's
+func (b *Bot) userToday(userID string) time.Time {
+	location := b.scheduler.Location()
+	if user, _, err := b.client.GetUser(userID, ""); err == nil {
+		if name := user.GetPreferredTimezone(); name != "" {
+			if userLocation, err := time.LoadLocation(name); err == nil {
+				location = userLocation
+			}
+		}
+	}
+	return time.Now().In(location)
+}
+
+// myTasksBriefing builds the message listing the user's overdue tasks and
+// those due today: a short summary from the main model, then a card per task
+// with Complete and Snooze buttons. With explain false, users without a
+// linked Asana account or with nothing due get an empty message.
+func (b *Bot) myTasksBriefing(ctx context.Context, userID string, explain bool) (types.ChatMessage, error) {
+	gid, err := b.asanaUserGID(ctx, userID)
+	if err != nil {
+		return types.ChatMessage{}, err
+	}
+	if gid == "" {
+		if !explain {
+			return types.ChatMessage{}, nil
+		}
+		return types.ChatMessage{Message: "I don't know which Asana account is yours: none has your email address. Ask an admin to link it with `!asanauser link`."}, nil
+	}
+
+	today := b.userToday(userID)
+	todayDate := today.Format("2006-01-02")
+	tasks, err := b.asanaHooks.client.ListUserAgenda(ctx, gid, "", todayDate)
+	if err != nil {
+		return types.ChatMessage{}, err
+	}
+	if len(tasks) == 0 {
+		if !explain {
+			return types.ChatMessage{}, nil
+		}
+		return types.ChatMessage{Message: "Nothing in Asana is due today or overdue for you."}, nil
+	}
+
+	username := "you"
+	if user, err := b.chat.GetUser(userID); err == nil {
+		username = user.Username
+	}
+	summary := b.summarizeMyTasks(ctx, userID, username, today, tasks)
+	if summary == "" {
+		summary = fmt.Sprintf("You have %d Asana tasks due today or overdue.", len(tasks))
+	}
+
+	shown := tasks
+	if len(shown) > maxCards {
+		shown = shown[:maxCards]
+		summary += fmt.Sprintf("\n\n_Showing the %d most overdue of %d tasks._", maxCards, len(tasks))
+	}
+	message := types.ChatMessage{Message: summary}
+	b.expireMyTaskButtons()
+	for _, task := range shown {
+		message.Attachments = append(message.Attachments, b.myTaskCard(userID, task, todayDate))
+	}
+	return message, nil
+}
+
+// summarizeMyTasks asks the model for the briefing's text. It returns ""
+// when that fails, so the caller can fall back to a plain count.
+func (b *Bot) summarizeMyTasks(ctx context.Context, userID, username string, today time.Time, tasks []asana.AgendaTask) string {
+	tasksJSON, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		return ""
+	}
+	prompt, err := b.prompts.Render(prompts.MyTasks, prompts.MyTasksData{Username: username, Today: today.Format("Monday 2006-01-02"), Tasks: string(tasksJSON)})
+	if err != nil {
+		log.Printf("[%s] MY_TASKS: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return ""
+	}
+	ctx = llms.WithoutTools(ctx)
+	ctx = tools.WithRequest(ctx, tools.Request{UserID: userID})
+	summary, err := b.llmBackend.Prompt(ctx, prompt)
+	if err != nil {
+		log.Printf("[%s] MY_TASKS: Failed to summarize tasks, posting a plain briefing: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return ""
+	}
+	return strings.TrimSpace(summary)
+}
+
+// myTaskCard shows one task; its buttons only act for userID
+func (b *Bot) myTaskCard(userID string, task asana.AgendaTask, today string) types.Attachment {
+	due := task.DueOn
+	color := cardColors["warning"]
+	if task.DueOn < today {
+		due += " (overdue)"
+		color = cardColors["danger"]
+	}
+	card := types.Attachment{
+		Fallback:  task.Name,
+		Color:     color,
+		Title:     task.Name,
+		TitleLink: task.URL,
+		Fields:    []types.AttachmentField{{Title: "Due", Value: due, Short: true}},
+	}
+	if len(task.Projects) > 0 {
+		card.Fields = append(card.Fields, types.AttachmentField{Title: "Project", Value: strings.Join(task.Projects, ", "), Short: true})
+	}
+	url := b.buttonCallbackURL(myTasksPath)
+	if url == "" {
+		return card
+	}
+	// The click only carries a random ID; the task and its owner stay here
+	buttonID := newButtonID()
+	button := myTaskButton{UserID: userID, TaskGID: task.GID, Name: task.Name, CreatedAt: time.Now()}
+	if err := b.store.Put(myTaskButtonsBucket, buttonID, button); err != nil {
+		log.Printf("[%s] MY_TASKS: Failed to store buttons for task %s, posting the card without them: %v", time.Now().Format("2006-01-02 15:04:05"), task.GID, err)
+		return card
+	}
+	context := func(action string) map[string]interface{} {
+		return map[string]interface{}{"button": buttonID, "action": action}
+	}
+	card.Actions = []types.ActionButton{
+		{ID: "complete", Label: "Complete", Style: "success", URL: url, Context: context("complete")},
+		{ID: "snooze", Label: "Snooze a day", URL: url, Context: context("snooze")},
+	}
+	return card
+}
+
+// expireMyTaskButtons drops the buttons of briefings older than myTaskButtonTTL
+func (b *Bot) expireMyTaskButtons() {
+	for _, buttonID := range b.store.Keys(myTaskButtonsBucket) {
+		var button myTaskButton
+		if found, err := b.store.Get(myTaskButtonsBucket, buttonID, &button); err == nil && found && time.Since(button.CreatedAt) > myTaskButtonTTL {
+			b.store.Delete(myTaskButtonsBucket, buttonID)
+		}
+	}
+}
+
+// handleMyTaskButton serves the Complete and Snooze buttons of a briefing.
+// Anyone can post to this endpoint, so the task and its owner come from the
+// stored button, never from the request.
+func (b *Bot) handleMyTaskButton(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	buttonID, _ := req.Context["button"].(string)
+	action, _ := req.Context["action"].(string)
+
+	var button myTaskButton
+	found, err := b.store.Get(myTaskButtonsBucket, buttonID, &button)
+	if buttonID == "" || err != nil || !found || time.Since(button.CreatedAt) > myTaskButtonTTL {
+		writeJSON(w, http.StatusOK, model.PostActionIntegrationResponse{EphemeralText: "This briefing has expired or the task was already completed. Ask me \"my tasks\" for a new one."})
+		return
+	}
+	taskGID, name, owner := button.TaskGID, button.Name, button.UserID
+	if req.UserId != owner {
+		writeJSON(w, http.StatusOK, model.PostActionIntegrationResponse{EphemeralText: "These buttons only work for the person the briefing is for."})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var reply string
+	switch action {
+	case "complete":
+		if err := b.asanaHooks.client.CompleteTask(ctx, taskGID); err != nil {
+			log.Printf("[%s] MY_TASKS: Failed to complete task %s: %v", time.Now().Format("2006-01-02 15:04:05"), taskGID, err)
+			reply = fmt.Sprintf("Couldn't complete the task: %v", err)
+			break
+		}
+		b.store.Delete(myTaskButtonsBucket, buttonID)
+		reply = fmt.Sprintf("Marked %q complete.", name)
+
+	case "snooze":
+		tomorrow := b.userToday(owner).AddDate(0, 0, 1).Format("2006-01-02")
+		if err := b.asanaHooks.client.SetTaskDueOn(ctx, taskGID, tomorrow); err != nil {
+			log.Printf("[%s] MY_TASKS: Failed to snooze task %s: %v", time.Now().Format("2006-01-02 15:04:05"), taskGID, err)
+			reply = fmt.Sprintf("Couldn't snooze the task: %v", err)
+			break
+		}
+		reply = fmt.Sprintf("Moved %q to %s.", name, tomorrow)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, model.PostActionIntegrationResponse{EphemeralText: reply})
+}
+
+// runMorningBriefings DMs each opted-in user their tasks. Users who are in
+// DND or quiet hours, have nothing due or have no Asana account are skipped;
+// a stale briefing is no use later in the day.
+func (b *Bot) runMorningBriefings() {
+	sent := 0
+	for _, userID := range b.store.Keys(briefingUsersBucket) {
+		if !b.briefingEnabled(userID) {
+			continue
+		}
+		if reason := b.holdReason(userID); reason != "" {
+			log.Printf("[%s] MY_TASKS: Skipping the briefing for %s (%s)", time.Now().Format("2006-01-02 15:04:05"), userID, reason)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), myTasksTimeout)
+		briefing, err := b.myTasksBriefing(ctx, userID, false)
+		cancel()
+		if err != nil {
+			log.Printf("[%s] MY_TASKS: Failed to build the briefing for %s: %v", time.Now().Format("2006-01-02 15:04:05"), userID, err)
+			continue
+		}
+		if briefing.Message == "" {
+			continue
+		}
+
+		channelID, err := b.directChannel(userID)
+		if err != nil {
+			log.Printf("[%s] MY_TASKS: Failed to open a DM with %s: %v", time.Now().Format("2006-01-02 15:04:05"), userID, err)
+			continue
+		}
+		briefing.ChannelId = channelID
+		if _, err := b.chat.PostMessage(briefing); err != nil {
+			log.Printf("[%s] MY_TASKS: Failed to send the briefing to %s: %v", time.Now().Format("2006-01-02 15:04:05"), userID, err)
+			continue
+		}
+		sent++
+	}
+	log.Printf("[%s] MY_TASKS: Sent %d morning briefings", time.Now().Format("2006-01-02 15:04:05"), sent)
+}
+
+func (b *Bot) briefingEnabled(userID string) bool {
+	var enabled bool
+	found, err := b.store.Get(briefingUsersBucket, userID, &enabled)
+	return err == nil && found && enabled
+}
+
+// handleBriefingCommand implements "!briefing [on|off]" for the user sending it
+func (b *Bot) handleBriefingCommand(message types.PostedMessage, args []string) string {
+	if len(args) == 0 {
+		if b.briefingEnabled(message.UserId) {
+			return fmt.Sprintf("You get a DM of your due and overdue Asana tasks at %s (%s). Send `!briefing off` to stop it.", b.config.BriefingTime, b.config.DigestTimezone)
+		}
+		return "Morning briefings are off. Send `!briefing on` to get a DM of your due and overdue Asana tasks each morning, or ask me \"my tasks\" any time."
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		if err := b.store.Put(briefingUsersBucket, message.UserId, true); err != nil {
+			return fmt.Sprintf("Failed to turn briefings on: %v", err)
+		}
+		return fmt.Sprintf("You'll get a DM of your due and overdue Asana tasks at %s (%s) on days you have any.", b.config.BriefingTime, b.config.DigestTimezone)
+	case "off":
+		if err := b.store.Delete(briefingUsersBucket, message.UserId); err != nil {
+			return fmt.Sprintf("Failed to turn briefings off: %v", err)
+		}
+		return "Morning briefings are off."
+	}
+	return "Usage: `!briefing [on|off]`"
+}
@@ -0,0 +1,291 @@
+// Package notify routes webhook events to chat. Rules match the source, the
+// event type and dotted payload paths with globs, and render text/template
+// messages for their targets.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Target is where a matching event is delivered
+type Target struct {
+	ChannelID string `yaml:"channel_id"`
+	UserID    string `yaml:"user_id"`
+}
+
+// Rule routes events from a source that match every condition to its targets
+type Rule struct {
+	Name string `yaml:"name"`
+	// Source and Event are glob patterns for the webhook source and event type
+	Source string `yaml:"source"`
+	Event  string `yaml:"event"`
+	// Match maps dotted payload paths (e.g. "issue.labels.0.name") to glob patterns
+	Match    map[string]string `yaml:"match"`
+	Notify   []Target          `yaml:"notify"`
+	Template string            `yaml:"template"`
+	// Continue lets later rules match the same event
+	Continue bool `yaml:"continue"`
+	// Summarize has the model condense the payload into the notification,
+	// with the rendered template as a hint
+	Summarize bool `yaml:"summarize"`
+	// FollowUp makes the bot follow the thread under a channel notification
+	// and answer replies there
+	FollowUp bool `yaml:"follow_up"`
+}
+
+// Event is an incoming webhook payload
+type Event struct {
+	Source  string
+	Type    string
+	Payload map[string]interface{}
+}
+
+// Notification is a rendered message for one target
+type Notification struct {
+	Rule    string
+	Target  Target
+	Message string
+	// Summarize and FollowUp are copied from the rule; Event is kept for the summary
+	Summarize bool
+	FollowUp  bool
+	Event     Event
+}
+
+type compiledRule struct {
+	Rule
+	template *template.Template
+}
+
+// Engine evaluates rules in order against events
+type Engine struct {
+	mu    sync.RWMutex
+	rules []compiledRule
+}
+
+// NewEngine validates the rules and compiles their templates
+func NewEngine(rules []Rule) (*Engine, error) {
+	compiled, err := compile(rules)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{rules: compiled}, nil
+}
+
+// Replace swaps in new rules, e.g. on a config reload. Invalid rules leave
+// the current ones in place.
+func (e *Engine) Replace(rules []Rule) error {
+	compiled, err := compile(rules)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.rules = compiled
+	e.mu.Unlock()
+	return nil
+}
+
+func compile(rules []Rule) ([]compiledRule, error) {
+	var compiled []compiledRule
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if len(rule.Notify) == 0 {
+			return nil, fmt.Errorf("rule %s: notify must list at least one target", rule.Name)
+		}
+		for _, target := range rule.Notify {
+			if (target.ChannelID == "") == (target.UserID == "") {
+				return nil, fmt.Errorf("rule %s: each target needs exactly one of channel_id or user_id", rule.Name)
+			}
+		}
+		for key, pattern := range rule.Match {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %s: bad pattern for %s: %w", rule.Name, key, err)
+			}
+		}
+
+		text := rule.Template
+		if text == "" {
+			text = "**{{.source}}** event `{{.type}}`"
+		}
+		tmpl, err := template.New(rule.Name).Funcs(template.FuncMap{"field": lookup}).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: bad template: %w", rule.Name, err)
+		}
+
+		compiled = append(compiled, compiledRule{Rule: rule, template: tmpl})
+	}
+	return compiled, nil
+}
+
+// Rules returns the configured rules in evaluation order
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	rules := make([]Rule, len(e.rules))
+	for i, rule := range e.rules {
+		rules[i] = rule.Rule
+	}
+	return rules
+}
+
+// Route returns the notifications for an event. Rules are evaluated in order
+// and evaluation stops at the first match unless that rule sets continue.
+func (e *Engine) Route(event Event) ([]Notification, error) {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	var notifications []Notification
+	for _, rule := range rules {
+		if !rule.matches(event) {
+			continue
+		}
+
+		message, err := rule.render(event)
+		if err != nil {
+			return notifications, fmt.Errorf("rule %s: failed to render template: %w", rule.Name, err)
+		}
+		for _, target := range rule.Notify {
+			notifications = append(notifications, Notification{
+				Rule:      rule.Name,
+				Target:    target,
+				Message:   message,
+				Summarize: rule.Summarize,
+				FollowUp:  rule.FollowUp,
+				Event:     event,
+			})
+		}
+
+		if !rule.Continue {
+			break
+		}
+	}
+	return notifications, nil
+}
+
+func (r *compiledRule) matches(event Event) bool {
+	if !glob(r.Source, event.Source) || !glob(r.Event, event.Type) {
+		return false
+	}
+	for key, pattern := range r.Match {
+		if !glob(pattern, stringify(lookup(event.Payload, key))) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *compiledRule) render(event Event) (string, error) {
+	// Templates see the payload fields directly, plus source and type
+	data := make(map[string]interface{}, len(event.Payload)+2)
+	for key, value := range event.Payload {
+		data[key] = value
+	}
+	data["source"] = event.Source
+	data["type"] = event.Type
+
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// glob matches value against pattern; an empty pattern matches anything
+func glob(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+// lookup resolves a dotted path such as "pull_request.user.login" or "labels.0.name"
+func lookup(value interface{}, key string) interface{} {
+	for _, part := range strings.Split(key, ".") {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			value = current[part]
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil
+			}
+			value = current[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// noisyKeys are payload fields that only cost tokens in a summary: API
+// links, avatars and internal IDs
+var noisyKeys = []string{"*_url", "node_id", "gravatar_id", "_links"}
+
+// keptKeys are links worth keeping despite matching noisyKeys
+var keptKeys = map[string]bool{"html_url": true, "web_url": true}
+
+// Compact returns the payload as JSON without noisy fields, cut to at most
+// maxChars characters, for sending to a model
+func Compact(payload map[string]interface{}, maxChars int) string {
+	raw, _ := json.Marshal(prune(payload))
+	text := string(raw)
+	if len(text) > maxChars {
+		text = strings.ToValidUTF8(text[:maxChars], "") + "…"
+	}
+	return text
+}
+
+func prune(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		pruned := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			if field == nil || (!keptKeys[key] && noisy(key)) {
+				continue
+			}
+			pruned[key] = prune(field)
+		}
+		return pruned
+	case []interface{}:
+		pruned := make([]interface{}, len(v))
+		for i, item := range v {
+			pruned[i] = prune(item)
+		}
+		return pruned
+	default:
+		return value
+	}
+}
+
+func noisy(key string) bool {
+	for _, pattern := range noisyKeys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	default:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/prompts"
+	"agent-bot/store"
+	"agent-bot/tools"
+	"agent-bot/tracing"
+	"agent-bot/types"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// channelsBucket registers the channels the bot has been added to, keyed by
+// channel ID. Channels start on the default settings; !language, !thinking,
+// !digest, !sentiment and !welcome change them per channel.
+const channelsBucket = "channels"
+
+// joinedChannel is a channel's entry in channelsBucket
+type joinedChannel struct {
+	Name     string    `json:"name"`
+	Purpose  string    `json:"purpose,omitempty"`
+	Header   string    `json:"header,omitempty"`
+	JoinedAt time.Time `json:"joined_at"`
+	// IntroPostID is the intro the bot posted, empty while it has posted none
+	IntroPostID string `json:"intro_post_id,omitempty"`
+}
+
+// onboarding registers channels the bot joins and introduces it there
+type onboarding struct {
+	store    *store.Store
+	registry *tools.Registry
+	// intro is CHANNEL_INTRO: whether to post an introduction at all
+	intro bool
+}
+
+func newOnboarding(config Config, registry *tools.Registry, stateStore *store.Store) *onboarding {
+	return &onboarding{store: stateStore, registry: registry, intro: config.ChannelIntro}
+}
+
+// ChannelJoined registers the channel and, the first time the bot is added
+// to it, posts an introduction tailored to the channel's purpose and header
+func (a *BotAgent) ChannelJoined(channelID string) {
+	if a.onboarding == nil {
+		return
+	}
+	ctx, span := tracing.Start(context.Background(), "agent.channel_joined",
+		attribute.String("chat.channel_id", channelID),
+	)
+	defer span.End()
+
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	channel, err := a.chat.GetChannel(channelID)
+	if err != nil {
+		log.Printf("[%s] ONBOARDING: Failed to get channel %s, introducing without its purpose: %v", timestamp, channelID, err)
+		channel = &types.Channel{ID: channelID, Name: channelID, DisplayName: channelID}
+	}
+
+	var entry joinedChannel
+	rejoined, err := a.onboarding.store.Get(channelsBucket, channelID, &entry)
+	if err != nil {
+		log.Printf("[%s] ONBOARDING: Failed to read channel %s: %v", timestamp, channelID, err)
+	}
+	entry.Name = channel.DisplayName
+	if entry.Name == "" {
+		entry.Name = channel.Name
+	}
+	entry.Purpose, entry.Header, entry.JoinedAt = channel.Purpose, channel.Header, a.now()
+	if rejoined {
+		log.Printf("[%s] ONBOARDING: Added back to channel %s (%s)", timestamp, channelID, entry.Name)
+	} else {
+		log.Printf("[%s] ONBOARDING: Added to channel %s (%s)", timestamp, channelID, entry.Name)
+	}
+
+	if entry.IntroPostID != "" {
+		log.Printf("[%s] ONBOARDING: Already introduced in %s, not posting again", timestamp, channelID)
+	} else if a.onboarding.intro && a.features.Enabled(FeatureChannelIntro) {
+		entry.IntroPostID = a.postIntro(ctx, channel, entry.Name)
+	}
+
+	if err := a.onboarding.store.Put(channelsBucket, channelID, entry); err != nil {
+		log.Printf("[%s] ONBOARDING: Failed to register channel %s: %v", timestamp, channelID, err)
+	}
+}
+
+// postIntro posts the bot's introduction to channel and returns its ID, or
+// "" when nothing could be posted
+func (a *BotAgent) postIntro(ctx context.Context, channel *types.Channel, name string) string {
+	data := prompts.ChannelIntroData{
+		BotName: a.botDisplayName,
+		Channel: name,
+		Purpose: channel.Purpose,
+		Header:  channel.Header,
+	}
+	if data.BotName == "" {
+		data.BotName = a.botUsername
+	}
+	for _, tool := range a.onboarding.registry.List() {
+		data.Tools = append(data.Tools, tool.Name+": "+tool.Description)
+	}
+
+	intro := ""
+	if prompt, err := a.prompts.Render(prompts.ChannelIntro, data); err != nil {
+		log.Printf("[%s] ONBOARDING: Failed to render intro prompt: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	} else if intro, err = a.llm.Prompt(ctx, prompt); err != nil {
+		log.Printf("[%s] ONBOARDING: Failed to write intro for %s, posting the generic one: %v", time.Now().Format("2006-01-02 15:04:05"), channel.ID, err)
+	}
+	if intro = strings.TrimSpace(intro); intro == "" {
+		intro = fmt.Sprintf("Hi, I'm %s! Mention @%s to ask me a question or to get help with a task, and I'll reply in a thread.", data.BotName, a.botUsername)
+	}
+
+	postID, err := a.postMessage(ctx, types.ChatMessage{ChannelId: channel.ID, Message: intro})
+	if err != nil {
+		log.Printf("[%s] ONBOARDING: Failed to post intro in %s: %v", time.Now().Format("2006-01-02 15:04:05"), channel.ID, err)
+		return ""
+	}
+	return postID
+}
+
+// handleChannelsCommand implements "!channels"
+func (b *Bot) handleChannelsCommand(message types.PostedMessage, args []string) string {
+	channelIDs := b.store.Keys(channelsBucket)
+	if len(channelIDs) == 0 {
+		return "The bot hasn't been added to any channels since it started registering them."
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Channels (%d)**\n", len(channelIDs)))
+	for _, channelID := range channelIDs {
+		var entry joinedChannel
+		if found, err := b.store.Get(channelsBucket, channelID, &entry); err != nil || !found {
+			continue
+		}
+		settings := []string{"language " + languageSummary(b.languages.For(channelID))}
+		if b.digestEnabled(channelID) {
+			settings = append(settings, "digest")
+		}
+		if b.sentimentEnabled(channelID) {
+			settings = append(settings, "sentiment")
+		}
+		if b.welcomeEnabled(channelID) {
+			settings = append(settings, "welcome")
+		}
+		if project := b.actionItemsProject(channelID); project != "" {
+			settings = append(settings, "action items to "+project)
+		}
+		intro := "no intro"
+		if entry.IntroPostID != "" {
+			intro = "introduced"
+		}
+		sb.WriteString(fmt.Sprintf("- %s (`%s`) — joined %s, %s; %s\n", entry.Name, channelID, entry.JoinedAt.Format("2006-01-02"), intro, strings.Join(settings, ", ")))
+	}
+	return sb.String()
+}
+
+// channelIntroSummary describes CHANNEL_INTRO for !config
+func (b *Bot) channelIntroSummary() string {
+	if !b.config.ChannelIntro {
+		return "off"
+	}
+	return "on"
+}
@@ -0,0 +1,423 @@
+// Package pagerduty is a PagerDuty REST API client and the on-call and
+// incident tools built on it. Acknowledging and triggering incidents needs
+// a From email, sent with each write as PagerDuty requires.
+package pagerduty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultBaseURL is PagerDuty's REST API; EU accounts use https://api.eu.pagerduty.com
+const DefaultBaseURL = "https://api.pagerduty.com"
+
+type Client struct {
+	BaseURL  string
+	APIToken string
+	// FromEmail is the PagerDuty user acknowledging and triggering incidents;
+	// without it the client is read-only
+	FromEmail  string
+	HTTPClient *http.Client
+}
+
+type WhoIsOnCallArgs struct {
+	Query string `json:"query,omitempty" jsonschema_description:"Service, team or escalation policy name to look up, e.g. payments (optional - omit for everyone on call)"`
+}
+
+type ListIncidentsArgs struct {
+	Query string `json:"query,omitempty" jsonschema_description:"Only incidents on services whose name matches this (optional)"`
+}
+
+type AcknowledgeIncidentArgs struct {
+	IncidentID string `json:"incident_id" jsonschema_description:"The incident ID, e.g. PT4KHLK"`
+}
+
+type TriggerIncidentArgs struct {
+	Service string `json:"service" jsonschema_description:"Name or ID of the service to open the incident on"`
+	Title   string `json:"title" jsonschema_description:"One-line incident title"`
+	Details string `json:"details,omitempty" jsonschema_description:"What is happening and what was already tried (optional)"`
+	Urgency string `json:"urgency,omitempty" jsonschema_description:"high or low (optional - defaults to the service's setting)"`
+}
+
+// OnCall is a user on call at one level of an escalation policy
+type OnCall struct {
+	EscalationPolicy string `json:"escalation_policy"`
+	Level            int    `json:"level"`
+	User             string `json:"user"`
+	Email            string `json:"email,omitempty"`
+	Schedule         string `json:"schedule,omitempty"`
+	// Start and End are empty for permanent on-call assignments
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// Incident is a summary of a PagerDuty incident
+type Incident struct {
+	ID        string   `json:"id"`
+	Number    int      `json:"number"`
+	Title     string   `json:"title"`
+	Status    string   `json:"status"`
+	Urgency   string   `json:"urgency"`
+	Service   string   `json:"service"`
+	Assignees []string `json:"assignees,omitempty"`
+	CreatedAt string   `json:"created_at"`
+	URL       string   `json:"url"`
+}
+
+type reference struct {
+	ID      string `json:"id"`
+	Summary string `json:"summary"`
+}
+
+type rawIncident struct {
+	ID          string    `json:"id"`
+	Number      int       `json:"incident_number"`
+	Title       string    `json:"title"`
+	Status      string    `json:"status"`
+	Urgency     string    `json:"urgency"`
+	Service     reference `json:"service"`
+	Assignments []struct {
+		Assignee reference `json:"assignee"`
+	} `json:"assignments"`
+	CreatedAt string `json:"created_at"`
+	HTMLURL   string `json:"html_url"`
+}
+
+func (r rawIncident) incident() Incident {
+	incident := Incident{
+		ID:        r.ID,
+		Number:    r.Number,
+		Title:     r.Title,
+		Status:    r.Status,
+		Urgency:   r.Urgency,
+		Service:   r.Service.Summary,
+		CreatedAt: r.CreatedAt,
+		URL:       r.HTMLURL,
+	}
+	for _, assignment := range r.Assignments {
+		incident.Assignees = append(incident.Assignees, assignment.Assignee.Summary)
+	}
+	return incident
+}
+
+// NewClient creates a PagerDuty client authenticated with a REST API key.
+// fromEmail may be empty for a read-only client.
+func NewClient(apiToken, fromEmail string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		APIToken:   apiToken,
+		FromEmail:  fromEmail,
+		HTTPClient: httpClient,
+	}
+}
+
+// CanWrite reports whether incidents can be acknowledged and triggered
+func (c *Client) CanWrite() bool {
+	return c.FromEmail != ""
+}
+
+func (c *Client) makeRequest(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Token token="+c.APIToken)
+	req.Header.Set("Accept", "application/vnd.pagerduty+json;version=2")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Writes are made on behalf of a PagerDuty user
+	if method != http.MethodGet && c.FromEmail != "" {
+		req.Header.Set("From", c.FromEmail)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return respBody, nil
+}
+
+// escalationPolicies returns the IDs and names of the escalation policies
+// whose own name, or whose service or team name, matches query
+func (c *Client) escalationPolicies(ctx context.Context, query string) (map[string]string, error) {
+	policies := make(map[string]string)
+
+	body, err := c.makeRequest(ctx, "GET", "/escalation_policies?query="+url.QueryEscape(query), nil)
+	if err != nil {
+		return nil, err
+	}
+	var byName struct {
+		EscalationPolicies []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"escalation_policies"`
+	}
+	if err := json.Unmarshal(body, &byName); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	for _, policy := range byName.EscalationPolicies {
+		policies[policy.ID] = policy.Name
+	}
+
+	// "Who's on call for payments?" usually names a service
+	services, err := c.findServices(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		policies[service.EscalationPolicy.ID] = service.EscalationPolicy.Summary
+	}
+
+	return policies, nil
+}
+
+type rawService struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	EscalationPolicy reference `json:"escalation_policy"`
+}
+
+func (c *Client) findServices(ctx context.Context, query string) ([]rawService, error) {
+	body, err := c.makeRequest(ctx, "GET", "/services?query="+url.QueryEscape(query), nil)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Services []rawService `json:"services"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return response.Services, nil
+}
+
+// WhoIsOnCall returns who is on call now for the escalation policies
+// matching query by policy or service name, or everyone when query is empty
+func (c *Client) WhoIsOnCall(ctx context.Context, query string) ([]OnCall, error) {
+	params := url.Values{}
+	params.Add("include[]", "users")
+	params.Set("limit", "100")
+	if query != "" {
+		policies, err := c.escalationPolicies(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		if len(policies) == 0 {
+			return nil, fmt.Errorf("no service or escalation policy matches %q", query)
+		}
+		for id := range policies {
+			params.Add("escalation_policy_ids[]", id)
+		}
+	}
+
+	body, err := c.makeRequest(ctx, "GET", "/oncalls?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		OnCalls []struct {
+			EscalationPolicy reference `json:"escalation_policy"`
+			Level            int       `json:"escalation_level"`
+			User             struct {
+				reference
+				Name  string `json:"name"`
+				Email string `json:"email"`
+			} `json:"user"`
+			Schedule *reference `json:"schedule"`
+			Start    string     `json:"start"`
+			End      string     `json:"end"`
+		} `json:"oncalls"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	oncalls := make([]OnCall, 0, len(response.OnCalls))
+	for _, raw := range response.OnCalls {
+		oncall := OnCall{
+			EscalationPolicy: raw.EscalationPolicy.Summary,
+			Level:            raw.Level,
+			User:             raw.User.Name,
+			Email:            raw.User.Email,
+			Start:            raw.Start,
+			End:              raw.End,
+		}
+		if oncall.User == "" {
+			oncall.User = raw.User.Summary
+		}
+		if raw.Schedule != nil {
+			oncall.Schedule = raw.Schedule.Summary
+		}
+		oncalls = append(oncalls, oncall)
+	}
+	sort.SliceStable(oncalls, func(i, j int) bool {
+		if oncalls[i].EscalationPolicy != oncalls[j].EscalationPolicy {
+			return oncalls[i].EscalationPolicy < oncalls[j].EscalationPolicy
+		}
+		return oncalls[i].Level < oncalls[j].Level
+	})
+	return oncalls, nil
+}
+
+// ListIncidents returns open (triggered or acknowledged) incidents, only on
+// services whose name matches query when it is set
+func (c *Client) ListIncidents(ctx context.Context, query string) ([]Incident, error) {
+	params := url.Values{}
+	params.Add("statuses[]", "triggered")
+	params.Add("statuses[]", "acknowledged")
+	params.Set("limit", "50")
+	if query != "" {
+		services, err := c.findServices(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		if len(services) == 0 {
+			return nil, fmt.Errorf("no service matches %q", query)
+		}
+		for _, service := range services {
+			params.Add("service_ids[]", service.ID)
+		}
+	}
+
+	body, err := c.makeRequest(ctx, "GET", "/incidents?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Incidents []rawIncident `json:"incidents"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	incidents := make([]Incident, 0, len(response.Incidents))
+	for _, raw := range response.Incidents {
+		incidents = append(incidents, raw.incident())
+	}
+	return incidents, nil
+}
+
+// AcknowledgeIncident acknowledges an incident as FromEmail
+func (c *Client) AcknowledgeIncident(ctx context.Context, incidentID string) (*Incident, error) {
+	if !c.CanWrite() {
+		return nil, fmt.Errorf("acknowledging incidents needs PAGERDUTY_FROM_EMAIL")
+	}
+	payload := map[string]interface{}{
+		"incident": map[string]string{"type": "incident_reference", "status": "acknowledged"},
+	}
+	body, err := c.makeRequest(ctx, "PUT", "/incidents/"+url.PathEscape(incidentID), payload)
+	if err != nil {
+		return nil, err
+	}
+	return parseIncident(body)
+}
+
+// TriggerIncident opens an incident on the service named or identified by
+// service, as FromEmail
+func (c *Client) TriggerIncident(ctx context.Context, service, title, details, urgency string) (*Incident, error) {
+	if !c.CanWrite() {
+		return nil, fmt.Errorf("triggering incidents needs PAGERDUTY_FROM_EMAIL")
+	}
+	if urgency != "" && urgency != "high" && urgency != "low" {
+		return nil, fmt.Errorf("urgency must be high or low, not %q", urgency)
+	}
+
+	serviceID, err := c.serviceID(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	incident := map[string]interface{}{
+		"type":    "incident",
+		"title":   title,
+		"service": map[string]string{"id": serviceID, "type": "service_reference"},
+	}
+	if urgency != "" {
+		incident["urgency"] = urgency
+	}
+	if details != "" {
+		incident["body"] = map[string]string{"type": "incident_body", "details": details}
+	}
+	body, err := c.makeRequest(ctx, "POST", "/incidents", map[string]interface{}{"incident": incident})
+	if err != nil {
+		return nil, err
+	}
+	return parseIncident(body)
+}
+
+// serviceID resolves a service name to its ID; a name matching several
+// services is an error listing them
+func (c *Client) serviceID(ctx context.Context, service string) (string, error) {
+	services, err := c.findServices(ctx, service)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, s := range services {
+		if s.ID == service || strings.EqualFold(s.Name, service) {
+			return s.ID, nil
+		}
+		names = append(names, s.Name)
+	}
+	switch len(services) {
+	case 0:
+		// The search only matches names; the caller may have passed an ID
+		return service, nil
+	case 1:
+		return services[0].ID, nil
+	}
+	return "", fmt.Errorf("%q matches several services: %s", service, strings.Join(names, ", "))
+}
+
+func parseIncident(body []byte) (*Incident, error) {
+	var response struct {
+		Incident rawIncident `json:"incident"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	incident := response.Incident.incident()
+	return &incident, nil
+}
+
+// Ping verifies the API key by fetching one ability of the account
+func (c *Client) Ping(ctx context.Context) error {
+	if _, err := c.makeRequest(ctx, "GET", "/abilities", nil); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package pagerduty
+
+import (
+	"context"
+	"fmt"
+
+	"agent-bot/tools"
+)
+
+// Tools returns the PagerDuty tools backed by this client. Acknowledging and
+// triggering incidents are only offered when the client can write.
+func (c *Client) Tools() []tools.Tool {
+	list := []tools.Tool{
+		{
+			Name:        "pagerduty_who_is_on_call",
+			Description: "Find who is on call right now in PagerDuty, for a service, team or escalation policy name or for everyone",
+			Schema:      tools.SchemaFor[WhoIsOnCallArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input WhoIsOnCallArgs) (interface{}, error) {
+				oncalls, err := c.WhoIsOnCall(ctx, input.Query)
+				if err != nil {
+					return nil, fmt.Errorf("error looking up on-call: %w", err)
+				}
+				return oncalls, nil
+			}),
+		},
+		{
+			Name:        "list_pagerduty_incidents",
+			Description: "List open (triggered or acknowledged) PagerDuty incidents, optionally for services matching a name",
+			Schema:      tools.SchemaFor[ListIncidentsArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input ListIncidentsArgs) (interface{}, error) {
+				incidents, err := c.ListIncidents(ctx, input.Query)
+				if err != nil {
+					return nil, fmt.Errorf("error listing incidents: %w", err)
+				}
+				return incidents, nil
+			}),
+		},
+	}
+	if !c.CanWrite() {
+		return list
+	}
+	return append(list,
+		tools.Tool{
+			Name:        "acknowledge_pagerduty_incident",
+			Description: "Acknowledge a PagerDuty incident. Only acknowledge incidents the user explicitly asked to.",
+			Schema:      tools.SchemaFor[AcknowledgeIncidentArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input AcknowledgeIncidentArgs) (interface{}, error) {
+				incident, err := c.AcknowledgeIncident(ctx, input.IncidentID)
+				if err != nil {
+					return nil, fmt.Errorf("error acknowledging incident: %w", err)
+				}
+				return incident, nil
+			}),
+		},
+		tools.Tool{
+			Name:        "trigger_pagerduty_incident",
+			Description: "Open a PagerDuty incident on a service, paging whoever is on call. Only trigger incidents the user explicitly asked for.",
+			Schema:      tools.SchemaFor[TriggerIncidentArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input TriggerIncidentArgs) (interface{}, error) {
+				incident, err := c.TriggerIncident(ctx, input.Service, input.Title, input.Details, input.Urgency)
+				if err != nil {
+					return nil, fmt.Errorf("error triggering incident: %w", err)
+				}
+				return incident, nil
+			}),
+		},
+	)
+}
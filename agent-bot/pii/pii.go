@@ -0,0 +1,167 @@
+// Package pii redacts personal data from text sent to external LLMs. Each
+// value found is replaced with a placeholder such as [EMAIL_1], and a Session
+// remembers the placeholders so the model's answer can be given the original
+// values back.
+package pii
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rule finds one kind of personal data. Pattern is a regular expression;
+// Words lists literal terms, such as person or customer names, matched as
+// whole words case-insensitively. Name becomes the placeholder label.
+type Rule struct {
+	Name    string   `yaml:"name"`
+	Pattern string   `yaml:"pattern"`
+	Words   []string `yaml:"words"`
+
+	pattern *regexp.Regexp
+	// valid filters out matches that only look like the data, e.g. card
+	// numbers failing the Luhn check
+	valid func(match string) bool
+}
+
+// builtins are the rules PII_REDACTION can name, in the order they run
+var builtins = []Rule{
+	{Name: "email", Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`},
+	{Name: "credit_card", Pattern: `\b\d(?:[ -]?\d){12,18}\b`, valid: luhn},
+	{Name: "phone", Pattern: `(?:\+\d{1,3}[\s.-]?)?(?:\(\d{1,4}\)|\b\d{1,4})(?:[\s.-]?\d{2,4}){2,4}\b`, valid: phoneDigits},
+	{Name: "ip_address", Pattern: `\b(?:\d{1,3}\.){3}\d{1,3}\b`, valid: func(match string) bool { return net.ParseIP(match) != nil }},
+}
+
+// DefaultBuiltins are the rules PII_REDACTION=on enables
+var DefaultBuiltins = []string{"email", "credit_card", "phone"}
+
+// BuiltinNames returns the names of the built-in rules
+func BuiltinNames() []string {
+	names := make([]string, len(builtins))
+	for i, rule := range builtins {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+var ruleName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Redactor applies a fixed set of rules
+type Redactor struct {
+	rules []Rule
+}
+
+// New compiles the named built-in rules followed by the custom ones. It
+// returns nil when there are no rules at all.
+func New(builtinNames []string, custom []Rule) (*Redactor, error) {
+	var rules []Rule
+	for _, name := range builtinNames {
+		found := false
+		for _, rule := range builtins {
+			if rule.Name == name {
+				rules = append(rules, rule)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown built-in rule %q (expected one of %s)", name, strings.Join(BuiltinNames(), ", "))
+		}
+	}
+	rules = append(rules, custom...)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	for i := range rules {
+		rule := &rules[i]
+		if !ruleName.MatchString(rule.Name) {
+			return nil, fmt.Errorf("rule name %q must be lowercase letters, digits and underscores", rule.Name)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		seen[rule.Name] = true
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+	return &Redactor{rules: rules}, nil
+}
+
+func (r *Rule) compile() error {
+	var alternatives []string
+	if r.Pattern != "" {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+		alternatives = append(alternatives, "(?:"+r.Pattern+")")
+	}
+	var words []string
+	for _, word := range r.Words {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	// Longer terms first, so "Jane Doe" wins over "Jane"
+	sort.SliceStable(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	for _, word := range words {
+		alternatives = append(alternatives, `(?i:\b`+regexp.QuoteMeta(word)+`\b)`)
+	}
+	if len(alternatives) == 0 {
+		return fmt.Errorf("needs a pattern or words")
+	}
+
+	pattern, err := regexp.Compile(strings.Join(alternatives, "|"))
+	if err != nil {
+		return err
+	}
+	r.pattern = pattern
+	return nil
+}
+
+// Rules returns the names of the rules applied, in order
+func (r *Redactor) Rules() []string {
+	if r == nil {
+		return nil
+	}
+	names := make([]string, len(r.rules))
+	for i, rule := range r.rules {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+// luhn reports whether the digits of match pass the Luhn checksum
+func luhn(match string) bool {
+	sum, double := 0, false
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// phoneDigits keeps matches with as many digits as phone numbers have,
+// leaving shorter numbers such as ticket IDs alone
+func phoneDigits(match string) bool {
+	digits := 0
+	for _, c := range match {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15
+}
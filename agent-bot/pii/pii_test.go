@@ -0,0 +1,147 @@
+package pii
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		builtins []string
+		custom   []Rule
+		wantErr  string
+	}{
+		{name: "unknown builtin", builtins: []string{"ssn"}, wantErr: "unknown built-in rule"},
+		{name: "bad name", custom: []Rule{{Name: "Customer", Words: []string{"Acme"}}}, wantErr: "must be lowercase"},
+		{name: "duplicate", builtins: []string{"email"}, custom: []Rule{{Name: "email", Words: []string{"x"}}}, wantErr: "duplicate rule name"},
+		{name: "bad pattern", custom: []Rule{{Name: "ticket", Pattern: "("}}, wantErr: "pattern"},
+		{name: "empty rule", custom: []Rule{{Name: "empty"}}, wantErr: "needs a pattern or words"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.builtins, tt.custom)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("New() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewWithoutRulesIsNil(t *testing.T) {
+	redactor, err := New(nil, nil)
+	if err != nil || redactor != nil {
+		t.Fatalf("New(nil, nil) = %v, %v; want nil, nil", redactor, err)
+	}
+	if got := redactor.Session().Redact("mail jane@example.com"); got != "mail jane@example.com" {
+		t.Errorf("nil redactor changed text to %q", got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	redactor, err := New([]string{"email", "credit_card", "phone", "ip_address"}, []Rule{
+		{Name: "customer", Words: []string{"Acme", "Acme Corp"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "email", text: "mail jane@example.com today", want: "mail [EMAIL_1] today"},
+		{name: "same value same placeholder", text: "jane@example.com and JANE@example.com", want: "[EMAIL_1] and [EMAIL_1]"},
+		{name: "valid card", text: "card 4111 1111 1111 1111", want: "card [CREDIT_CARD_1]"},
+		{name: "card failing luhn", text: "order 4111 1111 1111 1112", want: "order 4111 1111 1111 1112"},
+		{name: "phone", text: "call +1 415 555 2671", want: "call [PHONE_1]"},
+		{name: "short number is not a phone", text: "ticket 4521", want: "ticket 4521"},
+		{name: "ip address", text: "host 10.0.0.12 is down", want: "host [IP_ADDRESS_1] is down"},
+		{name: "not an ip address", text: "version 999.1.1.1", want: "version 999.1.1.1"},
+		{name: "longest word wins", text: "Acme Corp and acme", want: "[CUSTOMER_1] and [CUSTOMER_2]"},
+		{name: "words match whole words only", text: "Acmeville", want: "Acmeville"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := redactor.Session()
+			if got := session.Redact(tt.text); got != tt.want {
+				t.Fatalf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	redactor, err := New([]string{"email"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := redactor.Session()
+	session.Redact("jane@example.com")
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "known placeholder", text: "Wrote to [EMAIL_1].", want: "Wrote to jane@example.com."},
+		{name: "unknown placeholder", text: "Wrote to [EMAIL_2].", want: "Wrote to [EMAIL_2]."},
+		{name: "no placeholders", text: "Done.", want: "Done."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := session.Restore(tt.text); got != tt.want {
+				t.Fatalf("Restore(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestorerHoldsBackSplitPlaceholders(t *testing.T) {
+	redactor, err := New([]string{"email"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := redactor.Session()
+	session.Redact("jane@example.com")
+
+	restorer := session.Restorer()
+	var out strings.Builder
+	for _, chunk := range []string{"Sent to [EMA", "IL_1] just now", " [EMAIL"} {
+		out.WriteString(restorer.Write(chunk))
+	}
+	out.WriteString(restorer.Flush())
+
+	if want := "Sent to jane@example.com just now [EMAIL"; out.String() != want {
+		t.Fatalf("restored stream = %q, want %q", out.String(), want)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	redactor, err := New([]string{"email"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := redactor.Session()
+
+	redacted := session.RedactValue(map[string]interface{}{"to": "jane@example.com", "count": 2})
+	raw, err := json.Marshal(redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"count":2,"to":"[EMAIL_1]"}`; string(raw) != want {
+		t.Fatalf("RedactValue = %s, want %s", raw, want)
+	}
+
+	restored := session.RestoreJSON(json.RawMessage(`{"to":"[EMAIL_1]","cc":["[EMAIL_1]"]}`))
+	if want := `{"cc":["jane@example.com"],"to":"jane@example.com"}`; string(restored) != want {
+		t.Fatalf("RestoreJSON = %s, want %s", restored, want)
+	}
+
+	invalid := json.RawMessage(`{"to":`)
+	if got := session.RestoreJSON(invalid); string(got) != string(invalid) {
+		t.Errorf("RestoreJSON changed invalid JSON to %s", got)
+	}
+}
@@ -0,0 +1,195 @@
+package pii
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Session redacts the text of one exchange with the model and restores the
+// model's output. The same value always gets the same placeholder, so the
+// model can still tell that two mentions are of one person.
+type Session struct {
+	redactor *Redactor
+
+	mu           sync.Mutex
+	placeholders map[string]string // rule name + value -> placeholder
+	values       map[string]string // placeholder -> value
+	counts       map[string]int    // rule name -> values seen
+}
+
+// Session starts a session; a nil Redactor's sessions change nothing
+func (r *Redactor) Session() *Session {
+	return &Session{
+		redactor:     r,
+		placeholders: make(map[string]string),
+		values:       make(map[string]string),
+		counts:       make(map[string]int),
+	}
+}
+
+// placeholderPattern matches the placeholders sessions create
+var placeholderPattern = regexp.MustCompile(`\[[A-Z][A-Z0-9_]*_\d+\]`)
+
+// Redact replaces the personal data in text with placeholders
+func (s *Session) Redact(text string) string {
+	if s == nil || s.redactor == nil {
+		return text
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rule := range s.redactor.rules {
+		text = rule.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if rule.valid != nil && !rule.valid(match) {
+				return match
+			}
+			return s.placeholder(rule.Name, match)
+		})
+	}
+	return text
+}
+
+func (s *Session) placeholder(rule, value string) string {
+	key := rule + "\x00" + strings.ToLower(value)
+	if placeholder, ok := s.placeholders[key]; ok {
+		return placeholder
+	}
+	s.counts[rule]++
+	placeholder := fmt.Sprintf("[%s_%d]", strings.ToUpper(rule), s.counts[rule])
+	s.placeholders[key] = placeholder
+	s.values[placeholder] = value
+	return placeholder
+}
+
+// Restore puts the original values back in place of the session's placeholders
+func (s *Session) Restore(text string) string {
+	if s == nil {
+		return text
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) == 0 {
+		return text
+	}
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if value, ok := s.values[placeholder]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// Counts returns how many distinct values each rule has redacted
+func (s *Session) Counts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int, len(s.counts))
+	for rule, n := range s.counts {
+		counts[rule] = n
+	}
+	return counts
+}
+
+// RestoreJSON restores the placeholders in the strings of a JSON document,
+// such as a tool call's input. Invalid JSON is returned unchanged.
+func (s *Session) RestoreJSON(raw json.RawMessage) json.RawMessage {
+	return s.rewriteJSON(raw, s.Restore)
+}
+
+// RedactValue redacts the strings of a value that is about to be sent to the
+// model as JSON, such as a tool's result
+func (s *Session) RedactValue(value interface{}) interface{} {
+	if s == nil || s.redactor == nil || value == nil {
+		return value
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	return s.rewriteJSON(raw, s.Redact)
+}
+
+func (s *Session) rewriteJSON(raw json.RawMessage, rewrite func(string) string) json.RawMessage {
+	if s == nil || len(raw) == 0 {
+		return raw
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return raw
+	}
+	rewritten, err := json.Marshal(rewriteStrings(doc, rewrite))
+	if err != nil {
+		return raw
+	}
+	return rewritten
+}
+
+func rewriteStrings(value interface{}, rewrite func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return rewrite(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = rewriteStrings(v[i], rewrite)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = rewriteStrings(item, rewrite)
+		}
+	}
+	return value
+}
+
+// Restorer restores a streamed answer chunk by chunk, holding back the end of
+// a chunk that may be the start of a placeholder
+type Restorer struct {
+	session *Session
+	pending string
+}
+
+// Restorer starts restoring a stream
+func (s *Session) Restorer() *Restorer {
+	return &Restorer{session: s}
+}
+
+// maxPlaceholderLen bounds how much text is held back waiting for a "]"
+const maxPlaceholderLen = 48
+
+var partialPlaceholder = regexp.MustCompile(`\[[A-Z0-9_]*$`)
+
+// Write returns the restored text that can be shown so far
+func (r *Restorer) Write(chunk string) string {
+	text := r.pending + chunk
+	r.pending = ""
+	if loc := partialPlaceholder.FindStringIndex(text); loc != nil && loc[1]-loc[0] < maxPlaceholderLen {
+		text, r.pending = text[:loc[0]], text[loc[0]:]
+	}
+	return r.session.Restore(text)
+}
+
+// Flush returns whatever was held back when the stream ends
+func (r *Restorer) Flush() string {
+	text := r.pending
+	r.pending = ""
+	return r.session.Restore(text)
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context carrying session, so tools called during the
+// request can restore their inputs and redact their results
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFrom returns the session of ctx, or nil
+func SessionFrom(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-bot/llms"
+	"agent-bot/metrics"
+	"agent-bot/pii"
+	"agent-bot/types"
+)
+
+// parsePIIRedaction parses PII_REDACTION: off, on (the default built-in
+// rules) or a comma-separated list of built-in rule names
+func parsePIIRedaction(value string) ([]string, error) {
+	switch strings.TrimSpace(value) {
+	case "", "off":
+		return nil, nil
+	case "on":
+		return pii.DefaultBuiltins, nil
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if _, err := pii.New(names, nil); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// newRedactor compiles PII_REDACTION and the config file's pii_rules, or
+// returns nil when nothing is redacted
+func newRedactor(config Config, fileConfig *FileConfig) *pii.Redactor {
+	redactor, err := pii.New(config.PIIRedaction, fileConfig.PIIRules)
+	if err != nil {
+		log.Fatalf("Invalid pii_rules: %v", err)
+	}
+	return redactor
+}
+
+// redactingBackend replaces personal data in prompts with placeholders before
+// they reach the LLM API and puts the original values back in the answers.
+// Tools called during the request see the original values too.
+type redactingBackend struct {
+	backend  llms.LLMBackend
+	redactor *pii.Redactor
+	features *Features
+}
+
+// newRedactingBackend wraps backend, or returns it as is without a redactor
+func newRedactingBackend(backend llms.LLMBackend, redactor *pii.Redactor, features *Features) llms.LLMBackend {
+	if redactor == nil || backend == nil {
+		return backend
+	}
+	return &redactingBackend{backend: backend, redactor: redactor, features: features}
+}
+
+// session starts redacting one request, or returns nil while the feature is off
+func (r *redactingBackend) session(ctx context.Context) (context.Context, *pii.Session) {
+	if r.features != nil && !r.features.Enabled(FeaturePIIRedaction) {
+		return ctx, nil
+	}
+	session := r.redactor.Session()
+	return pii.WithSession(ctx, session), session
+}
+
+func (r *redactingBackend) Prompt(ctx context.Context, text string) (string, error) {
+	ctx, session := r.session(ctx)
+	if session == nil {
+		return r.backend.Prompt(ctx, text)
+	}
+	response, err := r.backend.Prompt(redactHistory(ctx, session), redactPrompt(session, text))
+	return session.Restore(response), err
+}
+
+func (r *redactingBackend) PromptStream(ctx context.Context, text string) (<-chan types.StreamChunk, error) {
+	ctx, session := r.session(ctx)
+	if session == nil {
+		return r.backend.PromptStream(ctx, text)
+	}
+	chunks, err := r.backend.PromptStream(redactHistory(ctx, session), redactPrompt(session, text))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan types.StreamChunk, cap(chunks))
+	go func() {
+		defer close(out)
+		send := func(chunk types.StreamChunk) {
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				// The reader is gone; keep draining until the backend stops
+			}
+		}
+		restorer := session.Restorer()
+		for chunk := range chunks {
+			content := restorer.Write(chunk.Content)
+			if chunk.Done || chunk.Error != nil {
+				// Readers stop at these, so held back text goes first
+				if content += restorer.Flush(); content != "" {
+					send(types.StreamChunk{Content: content})
+				}
+				chunk.Content = ""
+				send(chunk)
+				continue
+			}
+			if content != "" {
+				send(types.StreamChunk{Content: content})
+			}
+		}
+		if rest := restorer.Flush(); rest != "" {
+			send(types.StreamChunk{Content: rest})
+		}
+	}()
+	return out, nil
+}
+
+// Ping passes health checks through to the backend
+func (r *redactingBackend) Ping(ctx context.Context) error {
+	if pinger, ok := r.backend.(llms.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// redactHistory redacts the earlier conversation turns sent with a prompt.
+// The session counts what it finds, so redactPrompt logs it with the prompt's.
+func redactHistory(ctx context.Context, session *pii.Session) context.Context {
+	history := llms.HistoryFrom(ctx)
+	if len(history) == 0 {
+		return ctx
+	}
+	redacted := make([]llms.Turn, len(history))
+	for i, turn := range history {
+		redacted[i] = llms.Turn{Role: turn.Role, Text: session.Redact(turn.Text)}
+	}
+	return llms.WithHistory(ctx, redacted)
+}
+
+// redactPrompt redacts text, logging and counting what was found
+func redactPrompt(session *pii.Session, text string) string {
+	redacted := session.Redact(text)
+	counts := session.Counts()
+	if len(counts) == 0 {
+		return redacted
+	}
+
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	found := make([]string, len(rules))
+	for i, rule := range rules {
+		found[i] = fmt.Sprintf("%d %s", counts[rule], rule)
+		metrics.Add("pii_redactions_total", float64(counts[rule]), "rule", rule)
+	}
+	log.Printf("[%s] PII: Redacted %s from prompt", time.Now().Format("2006-01-02 15:04:05"), strings.Join(found, ", "))
+	return redacted
+}
+
+// piiSummary describes PII redaction for !config
+func (b *Bot) piiSummary() string {
+	if b.redactor == nil {
+		return "off"
+	}
+	return strings.Join(b.redactor.Rules(), ", ")
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"agent-bot/audit"
+	"agent-bot/discord"
+	"agent-bot/knowledge"
+	"agent-bot/memory"
+	"agent-bot/metrics"
+	"agent-bot/moderation"
+	"agent-bot/policy"
+	"agent-bot/prompts"
+	"agent-bot/repl"
+	"agent-bot/slack"
+	"agent-bot/styles"
+	"agent-bot/templates"
+	"agent-bot/tools"
+	"agent-bot/types"
+	"agent-bot/usage"
+)
+
+// chatPlatform is a chat service other than Mattermost that the agent core
+// can serve: a types.Chat plus the event source feeding it
+type chatPlatform interface {
+	types.Chat
+
+	// Identify verifies the credentials and looks up the bot's account
+	Identify(ctx context.Context) error
+	Identity() (userID, username string)
+
+	// TeamOf attributes a channel's usage to a workspace or server
+	TeamOf(channelID string) string
+
+	// Listen delivers messages to agent until ctx is cancelled
+	Listen(ctx context.Context, agent types.Agent) error
+	Connected() bool
+}
+
+// chatPlatformPlugin is set by the plugin build, which receives events
+// through server plugin hooks instead of a connection of its own
+const chatPlatformPlugin = "mattermost-plugin"
+
+// newChatPlatform creates the client for CHAT_PLATFORM
+func newChatPlatform(config Config) (chatPlatform, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	switch config.ChatPlatform {
+	case "slack":
+		if config.SlackBotToken == "" || config.SlackAppToken == "" {
+			return nil, fmt.Errorf("CHAT_PLATFORM=slack requires SLACK_BOT_TOKEN and SLACK_APP_TOKEN")
+		}
+		return slack.NewClient(config.SlackBotToken, config.SlackAppToken, httpClient), nil
+	case "discord":
+		if config.DiscordBotToken == "" {
+			return nil, fmt.Errorf("CHAT_PLATFORM=discord requires DISCORD_BOT_TOKEN")
+		}
+		return discord.NewClient(config.DiscordBotToken, httpClient)
+	case "repl":
+		return repl.New(os.Stdin, os.Stdout, "agent"), nil
+	case chatPlatformPlugin:
+		return pluginPlatform()
+	default:
+		return nil, fmt.Errorf("unknown CHAT_PLATFORM %q (use mattermost, slack or discord)", config.ChatPlatform)
+	}
+}
+
+// platformChat coalesces streaming updates like the Mattermost adapter does,
+// which keeps edits under the platform's rate limits
+type platformChat struct {
+	chatPlatform
+	updates *updateQueue
+}
+
+func (c *platformChat) UpdateMessage(messageID string, newContent string) error {
+	return c.updates.submit(messageID, newContent, c.chatPlatform.UpdateMessage)
+}
+
+// stampingAgent records when the platform last delivered a message, which
+// the Mattermost event loop does itself
+type stampingAgent struct {
+	types.Agent
+	bot *Bot
+}
+
+func (a *stampingAgent) MessagePosted(message types.PostedMessage) {
+	stamp(&a.bot.lastEventAt)
+	stamp(&a.bot.lastMessageAt)
+	a.Agent.MessagePosted(message)
+}
+
+// runPlatform serves a Slack workspace, Discord servers, the terminal REPL or,
+// as a plugin, the Mattermost server it runs in with the same agent core.
+// Features built on the Mattermost REST client (channel housekeeping, digests,
+// sentiment alerts, standups, webhooks and the message API) are not available there.
+func runPlatform(config Config, fileConfig *FileConfig, toolSelector *tools.Selector) {
+	client, err := newChatPlatform(config)
+	if err != nil {
+		log.Fatalf("Invalid chat platform settings: %v", err)
+	}
+	if err := styles.Validate(fileConfig.ResponseStyle, fileConfig.ChannelStyles); err != nil {
+		log.Fatalf("Invalid channel_styles: %v", err)
+	}
+	if err := policy.Validate(fileConfig.ReplyPolicy, fileConfig.ChannelPolicies); err != nil {
+		log.Fatalf("Invalid channel_policies: %v", err)
+	}
+	if err := moderation.Validate(fileConfig.ModerationKeywords); err != nil {
+		log.Fatalf("Invalid moderation_keywords: %v", err)
+	}
+	promptSet, err := prompts.Load(config.PromptsDir)
+	if err != nil {
+		log.Fatalf("Invalid prompts: %v", err)
+	}
+
+	// Verify the token and work out who the bot is
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	err = client.Identify(ctx)
+	cancel()
+	if err != nil {
+		log.Fatalf("Startup self-test failed: %v; check the %s bot token", err, config.ChatPlatform)
+	}
+	config.BotUserID, config.BotUsername = client.Identity()
+	if config.BotDisplayName == "" {
+		config.BotDisplayName = config.BotUsername
+	}
+	log.Printf("[%s] SELFTEST: OK   %s token (authenticated as @%s, %s)", time.Now().Format("2006-01-02 15:04:05"), config.ChatPlatform, config.BotUsername, config.BotUserID)
+
+	shared := startSharedTools(config, fileConfig)
+	defer shared.Close()
+
+	stateStore, _, err := openStateStore(config)
+	if err != nil {
+		log.Fatalf("Failed to open state store: %v", err)
+	}
+
+	registry := tools.NewRegistry()
+	registry.SetTimeouts(config.ToolTimeout, fileConfig.ToolTimeouts)
+	for _, tool := range shared.registry.List() {
+		registry.Register(tool)
+	}
+
+	llmBackend, decisionLLMBackend := newLLMBackends(config, registry, toolSelector)
+	if config.StartupSelfTest {
+		runStartupSelfTest(config, llmBackend, decisionLLMBackend, shared, fileConfig.MCPServers)
+	}
+
+	llmBreaker, decisionBreaker := newLLMBreakers(config, llmBackend, decisionLLMBackend)
+
+	// Only the parts of Bot that don't talk to Mattermost are set
+	bot := &Bot{
+		config:             config,
+		llmBackend:         llmBreaker,
+		decisionLLMBackend: decisionBreaker,
+		llmBreaker:         llmBreaker,
+		decisionBreaker:    decisionBreaker,
+		store:              stateStore,
+		commands:           NewAdminCommands(config.AdminUserIDs),
+		templates:          templates.NewSet(fileConfig.ResponseTemplates),
+		styles:             newChannelStyles(fileConfig, stateStore),
+		policies:           newChannelPolicies(config, fileConfig, stateStore),
+		languages:          newChannelLanguages(config, stateStore),
+		thinking:           newChannelThinking(config, stateStore),
+		contexts:           newChannelContexts(config, stateStore),
+		prompts:            promptSet,
+		redactor:           newRedactor(config, fileConfig),
+		registry:           registry,
+		features:           NewFeatures(),
+		startedAt:          time.Now(),
+	}
+	// Prompts reach the LLM APIs with personal data replaced by placeholders
+	bot.llmBackend = newRedactingBackend(llmBreaker, bot.redactor, bot.features)
+	bot.decisionLLMBackend = newRedactingBackend(decisionBreaker, bot.redactor, bot.features)
+	bot.usage = usage.NewTracker(stateStore, fileConfig.ModelPrices, client.TeamOf)
+	bot.quotas = newQuotas(config, bot.usage, stateStore)
+	llmBackend.SetUsageRecorder(bot.usage)
+	decisionLLMBackend.SetUsageRecorder(bot.usage)
+	bot.simpleBreaker = newSimpleLLM(config, bot.usage)
+	if config.AuditLogFile != "" {
+		auditLog, err := audit.Open(config.AuditLogFile)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer auditLog.Close()
+		bot.auditLog = auditLog
+		llmBackend.SetToolAuditor(auditLog)
+	}
+
+	bot.memory = memory.New(stateStore)
+	for _, tool := range bot.memory.Tools() {
+		registry.Register(tool)
+	}
+	for _, tool := range bot.memory.UserTools() {
+		registry.Register(tool)
+	}
+
+	bot.commands.Register("templates", "List configured response templates", bot.handleTemplatesCommand)
+	bot.commands.Register("prompts", "List prompt templates or reload them from PROMPTS_DIR: !prompts [reload]", bot.handlePromptsCommand)
+	bot.commands.Register("thinking", "Show or set extended thinking for a channel: !thinking [channel_id|here] [budget_tokens [show|hide]|off|default] | list", bot.handleThinkingCommand)
+	bot.commands.Register("context", "Show or set how a channel's conversations are sent to the model: !context [channel_id|here] [full|recent|summarized|rag|channel|default] | list", bot.handleContextCommand)
+	bot.commands.Register("language", "Show or set a channel's default reply language: !language [channel_id|here] [language|default] | list", bot.handleLanguageCommand)
+	bot.commands.Register("style", "Show or set a channel's reply style: !style [channel_id|here] [verbosity=concise|detailed max_length=N emoji=on|off sources=on|off footer=on|off] | reset | list", bot.handleStyleCommand)
+	bot.commands.Register("policy", "Show or set when the bot answers in a channel without a mention: !policy [channel_id|here] [mode=always|never|mention_only|llm keywords=a,b max_per_hour=N timezone=Zone] | reset | list", bot.handlePolicyCommand)
+	bot.commands.Register("memory", "List or forget facts the model remembered in a channel", bot.handleMemoryCommand)
+	bot.commands.RegisterPublic("forget", "See or forget what the bot remembers about you: !forget [key|all]", bot.handleForgetCommand)
+	bot.commands.Register("tools", "List tools available to the LLM", bot.handleToolsCommand)
+	bot.commands.Register("moderation", "List recent moderation violations: !moderation [count]", bot.handleModerationCommand)
+	bot.commands.Register("quota", "Show or set daily LLM quotas: !quota list | user|channel <id|here> [tokens=N] [usd=N] | default", bot.handleQuotaCommand)
+	bot.commands.Register("channels", "List the channels the bot was added to and their settings", bot.handleChannelsCommand)
+	bot.commands.Register("feature", "List or toggle runtime features: !feature [name on|off]", bot.handleFeatureCommand)
+
+	llmAdapter := &LLMAdapter{backend: bot.llmBackend, features: bot.features}
+	decisionLLMAdapter := &LLMAdapter{backend: bot.decisionLLMBackend, features: bot.features}
+	chat := &platformChat{chatPlatform: client, updates: newUpdateQueue()}
+	agent := NewBotAgent(config.BotUserID, config.BotUsername, config.BotDisplayName, llmAdapter, decisionLLMAdapter, chat)
+	registerUploadTools(registry, shared, chat)
+	agent.commands = bot.commands
+	agent.features = bot.features
+	agent.templates = bot.templates
+	agent.styles = bot.styles
+	agent.policies = bot.policies
+	agent.languages = bot.languages
+	agent.moderator = newModerator(config, fileConfig, decisionLLMAdapter, bot.prompts, bot.store)
+	agent.injection = newInjectionGuard(config, decisionLLMAdapter, bot.prompts)
+	agent.quotas = bot.quotas
+	agent.onboarding = newOnboarding(config, bot.registry, bot.store)
+	agent.prompts = bot.prompts
+	agent.thinking = bot.thinking
+	agent.contexts = bot.contexts
+	if bot.simpleBreaker != nil {
+		agent.simpleLLM = &LLMAdapter{backend: newRedactingBackend(bot.simpleBreaker, bot.redactor, bot.features), features: bot.features}
+		agent.routing = config.ModelRouting
+	}
+	agent.memory = bot.memory
+	transcriber, err := newTranscriber(config)
+	if err != nil {
+		log.Fatalf("Invalid transcription settings: %v", err)
+	}
+	agent.transcriber = transcriber
+	synthesizer, err := newSynthesizer(config)
+	if err != nil {
+		log.Fatalf("Invalid text-to-speech settings: %v", err)
+	}
+	if synthesizer != nil {
+		agent.speech = newReplySpeech(synthesizer, config.TTSFormat, bot.store)
+		agent.replyObservers = append(agent.replyObservers, agent.speakReply)
+		bot.commands.RegisterPublic("speech", "Turn audio versions of replies to you on or off: !speech [on|off]", bot.handleSpeechCommand)
+	}
+	agent.restoreThreads(bot.store)
+	agent.contextMaxMessages = config.ContextMaxMsgs
+	agent.contextMaxTokens = config.ContextMaxTokens
+	agent.decisionGuard = newDecisionGuard(config.DecisionMaxLatency, config.DecisionTokenBudget)
+	agent.turns = newThreadTurns(config.ThreadReplyCooldown)
+	agent.threadTTL = config.ThreadTTL
+	if config.ChatPlatform != "repl" {
+		// The REPL is turn by turn, so there's nothing to wait for
+		agent.debounce = newDebouncer(config.DebounceWindow)
+	}
+	if len(config.ReactionActions) > 0 {
+		agent.reactionActions = newReactionActions(config.ReactionActions, config.TranslateLanguage)
+	}
+	if index, err := knowledge.Open(config.KnowledgeIndexFile); err != nil {
+		log.Printf("[%s] KNOWLEDGE: Failed to load index, continuing without it: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	} else {
+		agent.knowledge = index
+	}
+	bot.agent = agent
+	bot.commands.Register("threads", "List threads the bot is participating in", agent.handleThreadsCommand)
+
+	// Persist usage counters
+	go bot.usage.Run(context.Background(), time.Minute)
+
+	// The REPL runs next to a real deployment, so it doesn't take the port
+	if config.ChatPlatform != "repl" {
+		http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+			status := "OK"
+			if !client.Connected() {
+				status = "Disconnected"
+			} else if llmStatus := bot.llmHealth(); llmStatus != "" {
+				status = llmStatus
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(status))
+		})
+		health := newHealthChecker(func() []workspaceHealth {
+			workspace := bot.workspaceHealth(client.Connected())
+			workspace.PendingUpdates = chat.updates.depth()
+			return []workspaceHealth{workspace}
+		}, append([]dependency{
+			llmDependency("llm", bot.llmBackend, true),
+			llmDependency("decision_llm", bot.decisionLLMBackend, false),
+		}, toolDependencies(shared, fileConfig)...))
+		http.HandleFunc("/healthz", health.handleLive)
+		http.HandleFunc("/readyz", health.handleReady)
+		http.Handle("/metrics", metrics.Default.Handler())
+		http.HandleFunc("/debug/state", bot.handleDebugState(func(ctx context.Context) []workspaceState {
+			state := bot.runtimeState(ctx)
+			state.PendingUpdates = chat.updates.depth()
+			return []workspaceState{state}
+		}))
+		handler := guardPprof(bot, http.DefaultServeMux)
+		if routed, ok := client.(interface{ Handle(http.Handler) }); ok {
+			// Mattermost serves a plugin's routes under /plugins/<id>/
+			routed.Handle(handler)
+		} else {
+			port := os.Getenv("PORT")
+			if port == "" {
+				port = "8081"
+			}
+			go func() {
+				log.Printf("[%s] SERVER: Bot listening on port %s (%s)", time.Now().Format("2006-01-02 15:04:05"), port, config.ChatPlatform)
+				log.Fatal(http.ListenAndServe(":"+port, handler))
+			}()
+		}
+	}
+
+	if err := client.Listen(context.Background(), &stampingAgent{Agent: agent, bot: bot}); err != nil {
+		log.Fatalf("[%s] FATAL: %s connection stopped: %v", time.Now().Format("2006-01-02 15:04:05"), config.ChatPlatform, err)
+	}
+	if err := bot.usage.Flush(); err != nil {
+		log.Printf("[%s] USAGE: Failed to save usage: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build mattermostplugin
+
+package main
+
+import (
+	"os"
+
+	"agent-bot/mmplugin"
+
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+// pluginDataDir keeps the plugin's files next to the server's own data
+// directory, since a plugin runs in the server's working directory
+const pluginDataDir = "data/agent-bot/"
+
+// pluginClient is the bot account the plugin was activated with
+var pluginClient *mmplugin.Client
+
+func main() {
+	plugin.ClientMain(&mmplugin.Plugin{Run: runPlugin})
+}
+
+// runPlugin applies the plugin settings as environment variables and runs
+// the agent core against the server
+func runPlugin(client *mmplugin.Client, settings map[string]string) {
+	defaults := map[string]string{
+		"STATE_FILE":           pluginDataDir + "state.json",
+		"KNOWLEDGE_INDEX_FILE": pluginDataDir + "knowledge.json",
+		"AUDIT_LOG_FILE":       pluginDataDir + "audit.jsonl",
+		"CONFIG_FILE":          pluginDataDir + "config.yaml",
+	}
+	for name, value := range defaults {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, value)
+		}
+	}
+	for name, value := range settings {
+		os.Setenv(name, value)
+	}
+	os.Setenv("CHAT_PLATFORM", chatPlatformPlugin)
+
+	pluginClient = client
+	runServer()
+}
+
+func pluginPlatform() (chatPlatform, error) {
+	return pluginClient, nil
+}
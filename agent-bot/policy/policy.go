@@ -0,0 +1,330 @@
+// Package policy decides, per channel, which messages the bot may answer
+// without being mentioned: always, never, only when mentioned, or as the
+// decision LLM sees fit, with keyword triggers, time-of-day rules and a cap
+// on unsolicited replies.
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modes
+const (
+	// Always answers every message in the channel
+	Always = "always"
+	// Never stays silent in the channel, even when mentioned
+	Never = "never"
+	// MentionOnly answers mentions and keyword triggers only
+	MentionOnly = "mention_only"
+	// LLM answers mentions and keyword triggers, and lets the decision LLM
+	// choose in threads the bot takes part in. It is the default.
+	LLM = "llm"
+)
+
+// Policy is a channel's reply policy. Zero values mean "no preference", so
+// policies can be layered with Merge.
+type Policy struct {
+	Mode string `yaml:"mode" json:"mode,omitempty"`
+	// Keywords are words or phrases that get a message answered as if the
+	// bot had been mentioned
+	Keywords []string `yaml:"keywords" json:"keywords,omitempty"`
+	// Schedule changes the mode at certain times; the first matching window wins
+	Schedule []Window `yaml:"schedule" json:"schedule,omitempty"`
+	// Timezone is the IANA zone the schedule is read in
+	Timezone string `yaml:"timezone" json:"timezone,omitempty"`
+	// MaxUnsolicitedPerHour caps replies the bot wasn't asked for: keyword
+	// triggers, thread participation and always mode
+	MaxUnsolicitedPerHour int `yaml:"max_unsolicited_per_hour" json:"max_unsolicited_per_hour,omitempty"`
+}
+
+// Window is a time-of-day rule, e.g. mention_only outside office hours
+type Window struct {
+	// Days limits the window to these weekdays (mon, tue, ...); empty is every day
+	Days []string `yaml:"days" json:"days,omitempty"`
+	// From and To are HH:MM; a window with To before From spans midnight
+	From string `yaml:"from" json:"from"`
+	To   string `yaml:"to" json:"to"`
+	Mode string `yaml:"mode" json:"mode"`
+}
+
+// ChannelPolicy is a policy configured for one channel
+type ChannelPolicy struct {
+	ChannelID string `yaml:"channel_id"`
+	Policy    `yaml:",inline"`
+}
+
+// Empty reports whether the policy expresses no preference
+func (p Policy) Empty() bool {
+	return p.Mode == "" && p.Keywords == nil && p.Schedule == nil && p.Timezone == "" && p.MaxUnsolicitedPerHour == 0
+}
+
+// Merge returns p with every preference set in over replacing its own
+func (p Policy) Merge(over Policy) Policy {
+	if over.Mode != "" {
+		p.Mode = over.Mode
+	}
+	if over.Keywords != nil {
+		p.Keywords = over.Keywords
+	}
+	if over.Schedule != nil {
+		p.Schedule = over.Schedule
+	}
+	if over.Timezone != "" {
+		p.Timezone = over.Timezone
+	}
+	if over.MaxUnsolicitedPerHour != 0 {
+		p.MaxUnsolicitedPerHour = over.MaxUnsolicitedPerHour
+	}
+	return p
+}
+
+func validMode(mode string) error {
+	switch mode {
+	case Always, Never, MentionOnly, LLM:
+		return nil
+	}
+	return fmt.Errorf("mode must be %s, %s, %s or %s, not %q", Always, Never, MentionOnly, LLM, mode)
+}
+
+// Validate checks the policy's values
+func (p Policy) Validate() error {
+	if p.Mode != "" {
+		if err := validMode(p.Mode); err != nil {
+			return err
+		}
+	}
+	if p.MaxUnsolicitedPerHour < 0 {
+		return fmt.Errorf("max_unsolicited_per_hour must not be negative")
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("timezone: %w", err)
+		}
+	}
+	for i, window := range p.Schedule {
+		if err := window.validate(); err != nil {
+			return fmt.Errorf("schedule[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (w Window) validate() error {
+	if err := validMode(w.Mode); err != nil {
+		return err
+	}
+	if _, err := parseClock(w.From); err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	if _, err := parseClock(w.To); err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown day %q (use mon, tue, wed, thu, fri, sat or sun)", day)
+		}
+	}
+	return nil
+}
+
+// Validate checks the default policy and every channel policy
+func Validate(defaults Policy, channels []ChannelPolicy) error {
+	if err := defaults.Validate(); err != nil {
+		return fmt.Errorf("reply_policy: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i, channel := range channels {
+		if channel.ChannelID == "" {
+			return fmt.Errorf("channel_policies[%d]: channel_id is required", i)
+		}
+		if seen[channel.ChannelID] {
+			return fmt.Errorf("channel_policies[%d]: duplicate channel_id %s", i, channel.ChannelID)
+		}
+		seen[channel.ChannelID] = true
+		if err := channel.Validate(); err != nil {
+			return fmt.Errorf("channel_policies[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock reads HH:MM as minutes after midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ModeAt returns the mode in effect at now: the first schedule window
+// containing it, else Mode, else LLM. fallback is the zone used when the
+// policy has no Timezone.
+func (p Policy) ModeAt(now time.Time, fallback *time.Location) string {
+	location := fallback
+	if p.Timezone != "" {
+		if loaded, err := time.LoadLocation(p.Timezone); err == nil {
+			location = loaded
+		}
+	}
+	if location != nil {
+		now = now.In(location)
+	}
+	for _, window := range p.Schedule {
+		if window.contains(now) {
+			return window.Mode
+		}
+	}
+	if p.Mode == "" {
+		return LLM
+	}
+	return p.Mode
+}
+
+func (w Window) contains(now time.Time) bool {
+	from, errFrom := parseClock(w.From)
+	to, errTo := parseClock(w.To)
+	if errFrom != nil || errTo != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	day := now.Weekday()
+	if from > to && minute < to {
+		// The early part of a window that began the day before
+		day = (day + 6) % 7
+	}
+	if len(w.Days) > 0 {
+		matched := false
+		for _, name := range w.Days {
+			if weekdays[strings.ToLower(name)] == day {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if from <= to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+// Triggered returns the keyword text contains, matched case-insensitively
+// on word boundaries, or ""
+func (p Policy) Triggered(text string) string {
+	lower := strings.ToLower(text)
+	for _, keyword := range p.Keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" {
+			continue
+		}
+		for start := 0; ; {
+			i := strings.Index(lower[start:], keyword)
+			if i < 0 {
+				break
+			}
+			i += start
+			end := i + len(keyword)
+			if (i == 0 || !isWordByte(lower[i-1])) && (end == len(lower) || !isWordByte(lower[end])) {
+				return keyword
+			}
+			start = i + 1
+		}
+	}
+	return ""
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// Set changes one preference from its name and a textual value, as typed in
+// an admin command: mode=mention_only, keywords=deploy,outage,
+// max_per_hour=4, timezone=Europe/Berlin. "default" clears the preference.
+// The schedule is only set in the config file.
+func (p *Policy) Set(name, value string) error {
+	value = strings.TrimSpace(value)
+	clear := strings.ToLower(value) == "default"
+
+	switch strings.ToLower(name) {
+	case "mode":
+		if clear {
+			p.Mode = ""
+			return nil
+		}
+		mode := strings.ToLower(strings.ReplaceAll(value, "-", "_"))
+		if err := validMode(mode); err != nil {
+			return err
+		}
+		p.Mode = mode
+	case "keywords":
+		if clear {
+			p.Keywords = nil
+			return nil
+		}
+		keywords := []string{}
+		for _, keyword := range strings.Split(value, ",") {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				keywords = append(keywords, keyword)
+			}
+		}
+		p.Keywords = keywords
+	case "max_per_hour", "max_unsolicited_per_hour":
+		if clear {
+			p.MaxUnsolicitedPerHour = 0
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("max_per_hour must be a number of replies")
+		}
+		p.MaxUnsolicitedPerHour = n
+	case "timezone":
+		if clear {
+			p.Timezone = ""
+			return nil
+		}
+		if _, err := time.LoadLocation(value); err != nil {
+			return fmt.Errorf("timezone: %w", err)
+		}
+		p.Timezone = value
+	default:
+		return fmt.Errorf("unknown setting %q (use mode, keywords, max_per_hour or timezone)", name)
+	}
+	return nil
+}
+
+// String summarizes the policy for admin commands
+func (p Policy) String() string {
+	mode := p.Mode
+	if mode == "" {
+		mode = LLM
+	}
+	parts := []string{mode}
+	if len(p.Keywords) > 0 {
+		parts = append(parts, "keywords "+strings.Join(p.Keywords, ", "))
+	}
+	for _, window := range p.Schedule {
+		days := "daily"
+		if len(window.Days) > 0 {
+			days = strings.Join(window.Days, "/")
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s-%s", window.Mode, days, window.From, window.To))
+	}
+	if p.Timezone != "" {
+		parts = append(parts, p.Timezone)
+	}
+	if p.MaxUnsolicitedPerHour > 0 {
+		parts = append(parts, fmt.Sprintf("max %d unsolicited/hour", p.MaxUnsolicitedPerHour))
+	}
+	return strings.Join(parts, ", ")
+}
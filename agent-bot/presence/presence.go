@@ -0,0 +1,181 @@
+// Package presence decides whether the bot may ping a user now, from their
+// chat status, custom status and local time, so proactive messages such as
+// standup questions don't arrive during Do Not Disturb or at night.
+package presence
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"agent-bot/scheduler"
+)
+
+// Status is what the chat knows about a user's availability
+type Status struct {
+	// DND is set while the user is in Do Not Disturb; DNDUntil is when it
+	// ends, zero when it lasts until they turn it off
+	DND      bool
+	DNDUntil time.Time
+
+	// The user's custom status; CustomExpires is zero when it doesn't expire
+	CustomEmoji   string
+	CustomText    string
+	CustomExpires time.Time
+
+	// Location is the user's time zone, nil when unknown
+	Location *time.Location
+}
+
+// awayEmoji are custom status emoji that mean the user is out
+var awayEmoji = map[string]bool{
+	"palm_tree":             true,
+	"desert_island":         true,
+	"airplane":              true,
+	"face_with_thermometer": true,
+	"thermometer":           true,
+	"sleeping":              true,
+}
+
+// awayWords are custom status words that mean the user is out
+var awayWords = []string{"vacation", "holiday", "out of office", "ooo", "pto", "sick", "on leave", "parental leave"}
+
+// Policy says when users must not be pinged
+type Policy struct {
+	// Quiet hours as minutes since midnight in the user's time zone;
+	// QuietStart == QuietEnd means there are none
+	QuietStart int
+	QuietEnd   int
+	// QuietWeekends treats all of Saturday and Sunday as quiet
+	QuietWeekends bool
+	// RespectDND defers pings to users in Do Not Disturb or whose custom
+	// status says they are away
+	RespectDND bool
+}
+
+// ParsePolicy builds a policy from a "22:00-08:00" quiet hours window, empty
+// for none
+func ParsePolicy(quietHours string, quietWeekends, respectDND bool) (Policy, error) {
+	policy := Policy{QuietWeekends: quietWeekends, RespectDND: respectDND}
+	quietHours = strings.TrimSpace(quietHours)
+	if quietHours == "" {
+		return policy, nil
+	}
+
+	start, end, ok := strings.Cut(quietHours, "-")
+	if !ok {
+		return Policy{}, fmt.Errorf("quiet hours %q must look like 22:00-08:00", quietHours)
+	}
+	startHour, startMinute, err := scheduler.ParseTimeOfDay(strings.TrimSpace(start))
+	if err != nil {
+		return Policy{}, fmt.Errorf("quiet hours start: %w", err)
+	}
+	endHour, endMinute, err := scheduler.ParseTimeOfDay(strings.TrimSpace(end))
+	if err != nil {
+		return Policy{}, fmt.Errorf("quiet hours end: %w", err)
+	}
+	policy.QuietStart = startHour*60 + startMinute
+	policy.QuietEnd = endHour*60 + endMinute
+	if policy.QuietStart == policy.QuietEnd {
+		return Policy{}, fmt.Errorf("quiet hours %q start and end at the same time", quietHours)
+	}
+	return policy, nil
+}
+
+// Active reports whether the policy ever defers anything
+func (p Policy) Active() bool {
+	return p.QuietStart != p.QuietEnd || p.QuietWeekends || p.RespectDND
+}
+
+// String describes the policy, e.g. "22:00-08:00, weekends, DND"
+func (p Policy) String() string {
+	var parts []string
+	if p.QuietStart != p.QuietEnd {
+		parts = append(parts, fmt.Sprintf("%02d:%02d-%02d:%02d", p.QuietStart/60, p.QuietStart%60, p.QuietEnd/60, p.QuietEnd%60))
+	}
+	if p.QuietWeekends {
+		parts = append(parts, "weekends")
+	}
+	if p.RespectDND {
+		parts = append(parts, "DND")
+	}
+	if len(parts) == 0 {
+		return "off"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Defer returns why the user shouldn't be pinged at now, or "" if they can
+// be. Quiet hours use the user's time zone, or fallback when it is unknown.
+func (p Policy) Defer(status Status, now time.Time, fallback *time.Location) string {
+	if p.RespectDND {
+		if status.DND && (status.DNDUntil.IsZero() || now.Before(status.DNDUntil)) {
+			return "do not disturb"
+		}
+		if (status.CustomExpires.IsZero() || now.Before(status.CustomExpires)) && away(status.CustomEmoji, status.CustomText) {
+			return "away (custom status)"
+		}
+	}
+
+	location := status.Location
+	if location == nil {
+		location = fallback
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	if p.QuietWeekends && (local.Weekday() == time.Saturday || local.Weekday() == time.Sunday) {
+		return "weekend"
+	}
+	if p.quiet(local.Hour()*60 + local.Minute()) {
+		return "quiet hours"
+	}
+	return ""
+}
+
+// quiet reports whether minute of the day is within quiet hours, which may
+// wrap past midnight
+func (p Policy) quiet(minute int) bool {
+	switch {
+	case p.QuietStart == p.QuietEnd:
+		return false
+	case p.QuietStart < p.QuietEnd:
+		return minute >= p.QuietStart && minute < p.QuietEnd
+	default:
+		return minute >= p.QuietStart || minute < p.QuietEnd
+	}
+}
+
+func away(emoji, text string) bool {
+	if awayEmoji[strings.Trim(emoji, ":")] {
+		return true
+	}
+	text = " " + strings.ToLower(text) + " "
+	for _, word := range awayWords {
+		if wordBounded(text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// wordBounded reports whether word appears in text on word boundaries, so
+// "ooo" matches "OOO until Monday" but not "cooool"
+func wordBounded(text, word string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], word)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(word)
+		if !isLetter(text[start-1]) && (end >= len(text) || !isLetter(text[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,177 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	chartWidth   = 800
+	chartHeight  = 360
+	chartPadding = 24
+	// chartGridLines are the horizontal lines dividing the value axis
+	chartGridLines = 4
+)
+
+// palette colors the series in order; the legend refers to them by name
+var palette = []struct {
+	name  string
+	color color.RGBA
+}{
+	{"blue", color.RGBA{0x1f, 0x77, 0xb4, 0xff}},
+	{"orange", color.RGBA{0xff, 0x7f, 0x0e, 0xff}},
+	{"green", color.RGBA{0x2c, 0xa0, 0x2c, 0xff}},
+	{"red", color.RGBA{0xd6, 0x27, 0x28, 0xff}},
+	{"purple", color.RGBA{0x94, 0x67, 0xbd, 0xff}},
+	{"brown", color.RGBA{0x8c, 0x56, 0x4b, 0xff}},
+	{"pink", color.RGBA{0xe3, 0x77, 0xc2, 0xff}},
+	{"grey", color.RGBA{0x7f, 0x7f, 0x7f, 0xff}},
+}
+
+// Chart draws the series of a range query as a PNG line chart. The image has
+// no text, so it comes with a caption giving the value scale and a legend.
+func (r *Result) Chart() ([]byte, string, error) {
+	if r.Type != "matrix" || len(r.Series) == 0 {
+		return nil, "", fmt.Errorf("only range queries with data can be charted")
+	}
+	series := r.Series
+	if len(series) > len(palette) {
+		series = series[:len(palette)]
+	}
+
+	// Bounds of the plotted values and times
+	lo, hi := math.Inf(1), math.Inf(-1)
+	var start, end time.Time
+	for _, s := range series {
+		for _, sample := range s.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			lo, hi = math.Min(lo, sample.Value), math.Max(hi, sample.Value)
+			if start.IsZero() || sample.Time.Before(start) {
+				start = sample.Time
+			}
+			if end.IsZero() || sample.Time.After(end) {
+				end = sample.Time
+			}
+		}
+	}
+	if math.IsInf(lo, 1) {
+		return nil, "", fmt.Errorf("no finite values to chart")
+	}
+	if hi == lo {
+		hi, lo = hi+1, lo-1
+	}
+	span := end.Sub(start).Seconds()
+	if span == 0 {
+		span = 1
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	fill(img, img.Bounds(), color.RGBA{0xff, 0xff, 0xff, 0xff})
+
+	plot := image.Rect(chartPadding, chartPadding, chartWidth-chartPadding, chartHeight-chartPadding)
+	grid := color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	for i := 0; i <= chartGridLines; i++ {
+		y := plot.Min.Y + i*plot.Dy()/chartGridLines
+		line(img, plot.Min.X, y, plot.Max.X, y, grid)
+	}
+	axis := color.RGBA{0x60, 0x60, 0x60, 0xff}
+	line(img, plot.Min.X, plot.Min.Y, plot.Min.X, plot.Max.Y, axis)
+	line(img, plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y, axis)
+
+	point := func(sample Sample) (int, int) {
+		x := plot.Min.X + int(sample.Time.Sub(start).Seconds()/span*float64(plot.Dx()))
+		y := plot.Max.Y - int((sample.Value-lo)/(hi-lo)*float64(plot.Dy()))
+		return x, y
+	}
+	var legend []string
+	for i, s := range series {
+		c := palette[i].color
+		var prevX, prevY int
+		drawn := false
+		for _, sample := range s.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				drawn = false
+				continue
+			}
+			x, y := point(sample)
+			if drawn {
+				thickLine(img, prevX, prevY, x, y, c)
+			} else {
+				fill(img, image.Rect(x-1, y-1, x+2, y+2), c)
+			}
+			prevX, prevY, drawn = x, y, true
+		}
+		legend = append(legend, fmt.Sprintf("%s: `%s`", palette[i].name, s.Name()))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", fmt.Errorf("failed to encode chart: %w", err)
+	}
+
+	caption := fmt.Sprintf("%s to %s UTC, y axis %s to %s (grid every %s)\n%s",
+		start.UTC().Format("2006-01-02 15:04"), end.UTC().Format("15:04"),
+		formatValue(lo), formatValue(hi), formatValue((hi-lo)/chartGridLines), strings.Join(legend, "\n"))
+	if hidden := len(r.Series) - len(series); hidden > 0 {
+		caption += fmt.Sprintf("\n_%d more series not drawn_", hidden)
+	}
+	return buf.Bytes(), caption, nil
+}
+
+func fill(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	rect = rect.Intersect(img.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// line draws a one pixel line with Bresenham's algorithm
+func line(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	for err := dx + dy; ; {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// thickLine draws a two pixel wide line, readable once chat clients scale
+// the image down
+func thickLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	line(img, x0, y0, x1, y1, c)
+	line(img, x0, y0+1, x1, y1+1, c)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,294 @@
+// Package prometheus runs PromQL queries for the query_prometheus tool and
+// formats the result as per-series summaries, a Markdown table and
+// optionally a PNG line chart drawn with the standard library.
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPoints bounds the samples per series of a range query; the step is
+// widened to stay under it
+const maxPoints = 300
+
+type Client struct {
+	BaseURL string
+	// BearerToken is sent as Authorization when set, for Prometheus behind
+	// an authenticating proxy
+	BearerToken string
+	HTTPClient  *http.Client
+}
+
+type QueryArgs struct {
+	Query string `json:"query" jsonschema_description:"PromQL expression, e.g. sum(rate(http_requests_total{job=\"api-gateway\",code=~\"5..\"}[5m]))"`
+	Range string `json:"range,omitempty" jsonschema_description:"How far back to query as a duration like 15m, 1h or 7d (optional - omit for the current value only)"`
+	Step  string `json:"step,omitempty" jsonschema_description:"Resolution of a range query, e.g. 1m (optional - defaults to about 60 points)"`
+	Chart bool   `json:"chart,omitempty" jsonschema_description:"Post a line chart of a range query to the conversation (optional)"`
+}
+
+// Sample is one value of a series
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is one labelled time series of a result; instant vectors and
+// scalars have a single sample
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Result is the result of a query
+type Result struct {
+	// Type is vector, matrix, scalar or string
+	Type   string
+	Series []Series
+	// Warnings are returned by Prometheus alongside a successful result
+	Warnings []string
+}
+
+// NewClient creates a client for the Prometheus HTTP API at baseURL
+func NewClient(baseURL, bearerToken string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		BaseURL:     strings.TrimRight(baseURL, "/"),
+		BearerToken: bearerToken,
+		HTTPClient:  httpClient,
+	}
+}
+
+func (c *Client) makeRequest(ctx context.Context, path string, params url.Values) (json.RawMessage, []string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+path, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response struct {
+		Status    string          `json:"status"`
+		Data      json.RawMessage `json:"data"`
+		ErrorType string          `json:"errorType"`
+		Error     string          `json:"error"`
+		Warnings  []string        `json:"warnings"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	// Bad queries come back as 400 with an error the model can act on
+	if response.Status != "success" {
+		return nil, nil, fmt.Errorf("%s: %s", response.ErrorType, response.Error)
+	}
+	return response.Data, response.Warnings, nil
+}
+
+// Query evaluates an instant query at time at
+func (c *Client) Query(ctx context.Context, query string, at time.Time) (*Result, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", formatTime(at))
+	data, warnings, err := c.makeRequest(ctx, "/api/v1/query", params)
+	if err != nil {
+		return nil, err
+	}
+	return parseResult(data, warnings)
+}
+
+// QueryRange evaluates a query over [start, end] every step
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*Result, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", formatTime(start))
+	params.Set("end", formatTime(end))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	data, warnings, err := c.makeRequest(ctx, "/api/v1/query_range", params)
+	if err != nil {
+		return nil, err
+	}
+	return parseResult(data, warnings)
+}
+
+// Ping checks that the server answers queries
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Query(ctx, "1", time.Now())
+	return err
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
+
+func parseResult(data json.RawMessage, warnings []string) (*Result, error) {
+	var raw struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	result := &Result{Type: raw.ResultType, Warnings: warnings}
+	switch raw.ResultType {
+	case "vector", "matrix":
+		var series []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+			Values [][]interface{}   `json:"values"`
+		}
+		if err := json.Unmarshal(raw.Result, &series); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		for _, s := range series {
+			parsed := Series{Labels: s.Metric}
+			if s.Value != nil {
+				s.Values = append(s.Values, s.Value)
+			}
+			for _, pair := range s.Values {
+				sample, err := parseSample(pair)
+				if err != nil {
+					return nil, err
+				}
+				parsed.Samples = append(parsed.Samples, sample)
+			}
+			result.Series = append(result.Series, parsed)
+		}
+	case "scalar", "string":
+		var pair []interface{}
+		if err := json.Unmarshal(raw.Result, &pair); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		sample, err := parseSample(pair)
+		if err != nil {
+			return nil, err
+		}
+		result.Series = []Series{{Samples: []Sample{sample}}}
+	default:
+		return nil, fmt.Errorf("unknown result type %q", raw.ResultType)
+	}
+	return result, nil
+}
+
+// parseSample reads a [unix seconds, "value"] pair
+func parseSample(pair []interface{}) (Sample, error) {
+	if len(pair) != 2 {
+		return Sample{}, fmt.Errorf("malformed sample %v", pair)
+	}
+	seconds, ok := pair[0].(float64)
+	text, isText := pair[1].(string)
+	if !ok || !isText {
+		return Sample{}, fmt.Errorf("malformed sample %v", pair)
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		// "string" results are not numbers
+		value = math.NaN()
+	}
+	return Sample{Time: time.UnixMilli(int64(seconds * 1000)), Value: value}, nil
+}
+
+// Name renders a series' labels as PromQL would, e.g.
+// http_requests_total{code="500",job="api"}
+func (s Series) Name() string {
+	var keys []string
+	for key := range s.Labels {
+		if key != "__name__" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", key, s.Labels[key]))
+	}
+	name := s.Labels["__name__"]
+	if len(pairs) == 0 {
+		if name == "" {
+			return "{}"
+		}
+		return name
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// ParseDuration reads a Prometheus duration such as 90s, 15m, 1h30m, 7d or 2w
+func ParseDuration(text string) (time.Duration, error) {
+	text = strings.TrimSpace(text)
+	var total time.Duration
+	for rest := text; rest != ""; {
+		i := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", text)
+		}
+		n, _ := strconv.Atoi(rest[:i])
+		rest = rest[i:]
+
+		units := []struct {
+			suffix string
+			unit   time.Duration
+		}{{"ms", time.Millisecond}, {"s", time.Second}, {"m", time.Minute}, {"h", time.Hour}, {"d", 24 * time.Hour}, {"w", 7 * 24 * time.Hour}, {"y", 365 * 24 * time.Hour}}
+		matched := false
+		for _, u := range units {
+			if strings.HasPrefix(rest, u.suffix) {
+				total += time.Duration(n) * u.unit
+				rest = rest[len(u.suffix):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, fmt.Errorf("invalid duration %q", text)
+		}
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", text)
+	}
+	return total, nil
+}
+
+// rangeStep picks the step for a range query: the requested one, widened so
+// no series has more than maxPoints samples, or about 60 points by default
+func rangeStep(window time.Duration, requested string) (time.Duration, error) {
+	step := window / 60
+	if requested != "" {
+		var err error
+		if step, err = ParseDuration(requested); err != nil {
+			return 0, err
+		}
+	}
+	if minimum := window / maxPoints; step < minimum {
+		step = minimum
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+	return step.Round(time.Second), nil
+}
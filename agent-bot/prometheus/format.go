@@ -0,0 +1,94 @@
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxSeries bounds the series summarized for the model and drawn in a chart
+const maxSeries = 20
+
+// SeriesSummary condenses a series for the model: the latest value and, for
+// range queries, the min, max and average over the window
+type SeriesSummary struct {
+	Series string   `json:"series"`
+	Latest float64  `json:"latest"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+	Avg    *float64 `json:"avg,omitempty"`
+	// Points is the number of samples in the window
+	Points int `json:"points,omitempty"`
+}
+
+// Summarize returns a summary per series, at most maxSeries of them. NaN and
+// infinite samples are skipped, as is a series with no other samples.
+func (r *Result) Summarize() []SeriesSummary {
+	var summaries []SeriesSummary
+	for _, series := range r.Series {
+		if len(summaries) == maxSeries {
+			break
+		}
+		var values []float64
+		for _, sample := range series.Samples {
+			if !math.IsNaN(sample.Value) && !math.IsInf(sample.Value, 0) {
+				values = append(values, sample.Value)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		summary := SeriesSummary{Series: series.Name(), Latest: values[len(values)-1]}
+		if r.Type == "matrix" {
+			lo, hi, sum := math.Inf(1), math.Inf(-1), 0.0
+			for _, v := range values {
+				lo, hi, sum = math.Min(lo, v), math.Max(hi, v), sum+v
+			}
+			avg := sum / float64(len(values))
+			summary.Min, summary.Max, summary.Avg = &lo, &hi, &avg
+			summary.Points = len(values)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// Table renders the summaries as a Markdown table
+func (r *Result) Table() string {
+	summaries := r.Summarize()
+	if len(summaries) == 0 {
+		return "_no data_"
+	}
+
+	var sb strings.Builder
+	if r.Type == "matrix" {
+		sb.WriteString("| Series | Latest | Min | Max | Avg |\n|---|---:|---:|---:|---:|\n")
+		for _, s := range summaries {
+			fmt.Fprintf(&sb, "| `%s` | %s | %s | %s | %s |\n", s.Series, formatValue(s.Latest), formatValue(*s.Min), formatValue(*s.Max), formatValue(*s.Avg))
+		}
+	} else {
+		sb.WriteString("| Series | Value |\n|---|---:|\n")
+		for _, s := range summaries {
+			fmt.Fprintf(&sb, "| `%s` | %s |\n", s.Series, formatValue(s.Latest))
+		}
+	}
+	if hidden := len(r.Series) - len(summaries); hidden > 0 {
+		fmt.Fprintf(&sb, "\n_%d more series not shown or without data_\n", hidden)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// formatValue prints a value with about four significant digits
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 0):
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case v == math.Trunc(v) && math.Abs(v) < 1e12:
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
@@ -0,0 +1,95 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+// ChartUploader posts a file with a message, such as types.Chat.UploadFile
+type ChartUploader func(upload types.FileUpload) error
+
+// QueryResult is what query_prometheus returns to the model
+type QueryResult struct {
+	ResultType string          `json:"result_type"`
+	Series     []SeriesSummary `json:"series"`
+	// SeriesCount is the number of series returned, including any not summarized
+	SeriesCount int      `json:"series_count"`
+	Table       string   `json:"table"`
+	Chart       string   `json:"chart,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// Tools returns the query_prometheus tool. With a nil upload the tool only
+// returns tables; otherwise it can also post a chart to the conversation.
+func (c *Client) Tools(upload ChartUploader) []tools.Tool {
+	description := "Run a PromQL query against Prometheus for the current value or, with range, over a time window. Returns per-series latest/min/max/avg and a Markdown table to show the user."
+	if upload != nil {
+		description += " Set chart to also post a line chart of a range query to the conversation."
+	}
+	return []tools.Tool{
+		{
+			Name:        "query_prometheus",
+			Description: description,
+			Schema:      tools.SchemaFor[QueryArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input QueryArgs) (interface{}, error) {
+				result, err := c.run(ctx, input)
+				if err != nil {
+					return nil, fmt.Errorf("error querying Prometheus: %w", err)
+				}
+
+				output := QueryResult{
+					ResultType:  result.Type,
+					Series:      result.Summarize(),
+					SeriesCount: len(result.Series),
+					Table:       result.Table(),
+					Warnings:    result.Warnings,
+				}
+				if input.Chart {
+					output.Chart = postChart(ctx, upload, input.Query, result)
+				}
+				return output, nil
+			}),
+		},
+	}
+}
+
+func (c *Client) run(ctx context.Context, input QueryArgs) (*Result, error) {
+	now := time.Now()
+	if input.Range == "" {
+		return c.Query(ctx, input.Query, now)
+	}
+	window, err := ParseDuration(input.Range)
+	if err != nil {
+		return nil, err
+	}
+	step, err := rangeStep(window, input.Step)
+	if err != nil {
+		return nil, err
+	}
+	return c.QueryRange(ctx, input.Query, now.Add(-window), now, step)
+}
+
+// postChart uploads a chart of result to the requesting conversation and
+// says what happened, so the model can tell the user
+func postChart(ctx context.Context, upload ChartUploader, query string, result *Result) string {
+	if upload == nil {
+		return "charts are not supported on this chat platform"
+	}
+	req, ok := tools.RequestFrom(ctx)
+	if !ok || req.ChannelID == "" {
+		return "no conversation to post the chart to"
+	}
+	data, caption, err := result.Chart()
+	if err != nil {
+		return "not posted: " + err.Error()
+	}
+	err = upload(types.FileUpload{ChannelId: req.ChannelID, ThreadId: req.ThreadID, Filename: "prometheus.png", Data: data, Message: fmt.Sprintf("`%s`\n%s", query, caption)})
+	if err != nil {
+		return "failed to post: " + err.Error()
+	}
+	return "posted to the conversation"
+}
@@ -0,0 +1,402 @@
+// Package prompts renders the prompts sent to the LLMs from text/template
+// files. Defaults are built in; a directory of <name>.tmpl files overrides
+// them by name, so prompts can be changed without rebuilding the bot.
+package prompts
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+//go:embed defaults/*.tmpl
+var defaults embed.FS
+
+// Prompt names, which are also the template file names without ".tmpl"
+const (
+	// System is sent as the system prompt of replies; rendered with SystemData
+	System = "system"
+	// Decision asks the decision model whether to reply in a thread; rendered with DecisionData
+	Decision = "decision"
+	// Context frames the conversation sent to the main model; rendered with ContextData
+	Context = "context"
+	// ContextSummary condenses posts that don't fit the context; rendered with ContextSummaryData
+	ContextSummary = "context_summary"
+	// ThreadSummary answers "summarize this thread"; rendered with ThreadSummaryData
+	ThreadSummary = "thread_summary"
+	// ThreadNotes condenses one part of a long thread before summarizing; rendered with ThreadNotesData
+	ThreadNotes = "thread_notes"
+	// Translate translates a message for a reaction; rendered with TranslateData
+	Translate = "translate"
+	// ThreadTranslate answers "translate this thread to X"; rendered with ThreadTranslateData
+	ThreadTranslate = "thread_translate"
+	// Moderation asks the decision model whether a message is disallowed; rendered with ModerationData
+	Moderation = "moderation"
+	// Injection asks the decision model whether a message is a prompt injection attempt; rendered with InjectionData
+	Injection = "injection"
+	// Route asks the decision model whether a reply needs the main model; rendered with RouteData
+	Route = "route"
+	// AsanaEvent turns Asana project activity into a channel notification; rendered with AsanaEventData
+	AsanaEvent = "asana_event"
+	// WebhookEvent condenses a webhook payload into a notification for summarize rules; rendered with WebhookEventData
+	WebhookEvent = "webhook_event"
+	// Hook triages payloads posted to /hooks/<name> without a prompt of their own; rendered with HookData
+	Hook = "hook"
+	// ChannelIntro introduces the bot to a channel it was added to; rendered with ChannelIntroData
+	ChannelIntro = "channel_intro"
+	// Welcome greets a member who joined a channel with welcomes on; rendered with WelcomeData
+	Welcome = "welcome"
+	// MyTasks introduces a user's briefing of due and overdue Asana tasks; rendered with MyTasksData
+	MyTasks = "my_tasks"
+)
+
+// SystemData is available to the system prompt
+type SystemData struct {
+	BotName     string
+	BotUsername string
+	// Date is today's date, e.g. "Monday, January 2, 2006"
+	Date string
+}
+
+// DecisionData is available to the decision prompt
+type DecisionData struct {
+	// Context is the rendered context prompt
+	Context        string
+	BotUsername    string
+	BotDisplayName string
+}
+
+// Post is one message of a conversation. Content is escaped for quoting in
+// <message> tags; Flagged marks posts that look like prompt injection.
+type Post struct {
+	Speaker string
+	Content string
+	Flagged bool
+	// FromBot is set on the bot's own posts
+	FromBot bool
+}
+
+// Quote renders the post in the <message> tags the context prompt uses
+func (p Post) Quote() string {
+	flagged := ""
+	if p.Flagged {
+		flagged = ` flagged="possible prompt injection"`
+	}
+	return fmt.Sprintf("<message from=\"%s\"%s>\n%s\n</message>", p.Speaker, flagged, p.Content)
+}
+
+// ContextData is available to the context prompt
+type ContextData struct {
+	// Summary covers earlier posts that didn't fit the context budget, if any
+	Summary string
+	Posts   []Post
+	// Channel holds recent top-level posts elsewhere in the channel, for the
+	// channel context strategy
+	Channel []Post
+	// The message being answered and who sent it
+	Speaker        string
+	Message        string
+	MessageFlagged bool
+}
+
+// Flagged reports whether any post or the message looks like prompt injection
+func (d ContextData) Flagged() bool {
+	for _, p := range append(d.Posts, d.Channel...) {
+		if p.Flagged {
+			return true
+		}
+	}
+	return d.MessageFlagged
+}
+
+// ContextSummaryData is available to the context summary prompt
+type ContextSummaryData struct {
+	// Previous is the summary being extended; empty for a new one
+	Previous string
+	Posts    []Post
+}
+
+// ThreadSummaryData is available to the thread summary prompt. Long threads
+// are Partial: they come as Notes on each part instead of a Transcript.
+type ThreadSummaryData struct {
+	Participants []string
+	Transcript   string
+	Partial      bool
+	Notes        []string
+}
+
+// ThreadNotesData is available to the thread notes prompt
+type ThreadNotesData struct {
+	Part       int
+	Parts      int
+	Transcript string
+}
+
+// TranslateData is available to the translate prompt
+type TranslateData struct {
+	// Language is the language to translate into, e.g. "English"
+	Language string
+	Message  string
+}
+
+// ThreadTranslateData is available to the thread translate prompt
+type ThreadTranslateData struct {
+	Language   string
+	Transcript string
+	// Omitted counts the oldest posts left out of long threads
+	Omitted int
+}
+
+// ModerationData is available to the moderation prompt
+type ModerationData struct {
+	// Direction is "incoming" for users' messages, "outgoing" for the bot's replies
+	Direction string
+	// Categories lists what is disallowed, e.g. "hate, harassment, violence"
+	Categories string
+	Text       string
+}
+
+// InjectionData is available to the injection prompt
+type InjectionData struct {
+	Text string
+}
+
+// RouteData is available to the route prompt
+type RouteData struct {
+	// Context is the rendered context prompt, ending with the message to answer
+	Context string
+}
+
+// AsanaEventData is available to the Asana event prompt
+type AsanaEventData struct {
+	// Event says what happened, e.g. "was completed"
+	Event string
+	// Task is the task's details as JSON
+	Task string
+	// Comment and CommentAuthor are set for new comments
+	Comment       string
+	CommentAuthor string
+}
+
+// WebhookEventData is available to the webhook event prompt
+type WebhookEventData struct {
+	// Source is the webhook source, e.g. "github", and Event its event type
+	Source string
+	Event  string
+	// Notification is the rule's rendered template
+	Notification string
+	// Payload is the event's JSON without link and ID noise, possibly cut short
+	Payload string
+}
+
+// HookData is available to the hook prompt and to prompts configured on hooks
+type HookData struct {
+	// Name is the hook's name
+	Name string
+	// Payload is the posted JSON, for picking out fields such as .Payload.alerts
+	Payload map[string]interface{}
+	// JSON is the payload as JSON without link and ID noise, possibly cut short
+	JSON string
+}
+
+// ChannelIntroData is available to the channel intro prompt
+type ChannelIntroData struct {
+	BotName string
+	// Channel is the channel's display name; Purpose and Header may be empty
+	Channel string
+	Purpose string
+	Header  string
+	// Tools lists the tools the bot can use as "name: description"
+	Tools []string
+}
+
+// WelcomeData is available to the welcome prompt
+type WelcomeData struct {
+	// Username is the new member's username, without the @
+	Username string
+	// Channel is the channel's display name; Purpose and Header may be empty
+	Channel string
+	Purpose string
+	Header  string
+	// Pinned holds the newest pinned posts, shortened
+	Pinned []string
+}
+
+// MyTasksData is available to the my tasks prompt
+type MyTasksData struct {
+	// Username is the user's username, without the @
+	Username string
+	// Today is the user's date, e.g. "Monday 2006-01-02"
+	Today string
+	// Tasks are the tasks due today or earlier as JSON, soonest first
+	Tasks string
+}
+
+// samples are the data each prompt is rendered with, used to check templates
+// when they are loaded
+var samples = map[string]any{
+	System:          SystemData{},
+	Decision:        DecisionData{},
+	Context:         ContextData{},
+	ContextSummary:  ContextSummaryData{},
+	ThreadSummary:   ThreadSummaryData{},
+	ThreadNotes:     ThreadNotesData{},
+	Translate:       TranslateData{},
+	ThreadTranslate: ThreadTranslateData{},
+	Moderation:      ModerationData{},
+	Injection:       InjectionData{},
+	Route:           RouteData{},
+	AsanaEvent:      AsanaEventData{},
+	WebhookEvent:    WebhookEventData{},
+	Hook:            HookData{},
+	ChannelIntro:    ChannelIntroData{},
+	Welcome:         WelcomeData{},
+	MyTasks:         MyTasksData{},
+}
+
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	"inc":  func(i int) int { return i + 1 },
+}
+
+// Funcs returns the functions available to prompt templates, for templates
+// configured elsewhere that are rendered with the same data
+func Funcs() template.FuncMap {
+	return funcs
+}
+
+// Set is a loaded set of prompt templates
+type Set struct {
+	dir string
+
+	mu         sync.RWMutex
+	templates  map[string]*template.Template
+	overridden []string
+}
+
+var builtin = mustLoadBuiltin()
+
+func mustLoadBuiltin() *Set {
+	s, err := Load("")
+	if err != nil {
+		panic(fmt.Sprintf("built-in prompts: %v", err))
+	}
+	return s
+}
+
+// Default returns the built-in prompts
+func Default() *Set {
+	return builtin
+}
+
+// Load reads the built-in prompts, overridden by the <name>.tmpl files in
+// dir. An empty dir uses only the built-in prompts.
+func Load(dir string) (*Set, error) {
+	s := &Set{dir: dir}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the override directory. On error the prompts in use are kept.
+func (s *Set) Reload() error {
+	parsed := make(map[string]*template.Template, len(samples))
+	var overridden []string
+	for name, sample := range samples {
+		text, err := defaults.ReadFile("defaults/" + name + ".tmpl")
+		if err != nil {
+			return fmt.Errorf("built-in %s prompt: %w", name, err)
+		}
+		if s.dir != "" {
+			custom, err := os.ReadFile(filepath.Join(s.dir, name+".tmpl"))
+			switch {
+			case err == nil:
+				text = custom
+				overridden = append(overridden, name)
+			case !errors.Is(err, fs.ErrNotExist):
+				return fmt.Errorf("read %s prompt: %w", name, err)
+			}
+		}
+
+		tmpl, err := template.New(name).Funcs(funcs).Parse(string(text))
+		if err != nil {
+			return fmt.Errorf("parse %s prompt: %w", name, err)
+		}
+		// Catch references to fields the prompt's data doesn't have
+		if err := tmpl.Execute(io.Discard, sample); err != nil {
+			return fmt.Errorf("check %s prompt: %w", name, err)
+		}
+		parsed[name] = tmpl
+	}
+
+	if s.dir != "" {
+		entries, err := os.ReadDir(s.dir)
+		if err != nil {
+			return fmt.Errorf("read prompts directory: %w", err)
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), ".tmpl")
+			if ok && parsed[name] == nil {
+				return fmt.Errorf("unknown prompt %s (expected one of %s)", entry.Name(), strings.Join(Names(), ", "))
+			}
+		}
+	}
+	sort.Strings(overridden)
+
+	s.mu.Lock()
+	s.templates = parsed
+	s.overridden = overridden
+	s.mu.Unlock()
+	return nil
+}
+
+// Render renders the named prompt with data, trimming surrounding whitespace.
+// A nil Set renders the built-in prompts.
+func (s *Set) Render(name string, data any) (string, error) {
+	if s == nil {
+		s = builtin
+	}
+	s.mu.RLock()
+	tmpl, ok := s.templates[name]
+	s.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown prompt %q", name)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render %s prompt: %w", name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// Dir returns the override directory, empty when only built-in prompts are used
+func (s *Set) Dir() string {
+	return s.dir
+}
+
+// Overridden returns the names of the prompts loaded from the override directory
+func (s *Set) Overridden() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.overridden...)
+}
+
+// Names returns every prompt name, sorted
+func Names() []string {
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
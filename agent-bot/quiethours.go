@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"agent-bot/presence"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// heldMessagesBucket stores proactive DMs waiting for their user to be available
+const heldMessagesBucket = "held_messages"
+
+// heldRetryInterval is how often held DMs are retried
+const heldRetryInterval = 5 * time.Minute
+
+// heldMessage is a DM held back by the quiet hours policy
+type heldMessage struct {
+	UserID    string    `json:"user_id"`
+	ChannelID string    `json:"channel_id"`
+	Message   string    `json:"message"`
+	Reason    string    `json:"reason"`
+	HeldAt    time.Time `json:"held_at"`
+	// Expires drops the message if it still can't be sent by then; zero
+	// keeps it until it can
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// userPresence reads a user's DND state, custom status and time zone
+func (b *Bot) userPresence(userID string) (presence.Status, error) {
+	var status presence.Status
+	user, _, err := b.client.GetUser(userID, "")
+	if err != nil {
+		return status, fmt.Errorf("failed to get user: %v", err)
+	}
+	if name := user.GetPreferredTimezone(); name != "" {
+		if location, err := time.LoadLocation(name); err == nil {
+			status.Location = location
+		}
+	}
+	if custom := user.GetCustomStatus(); custom != nil {
+		status.CustomEmoji = custom.Emoji
+		status.CustomText = custom.Text
+		status.CustomExpires = custom.ExpiresAt
+	}
+
+	userStatus, _, err := b.client.GetUserStatus(userID, "")
+	if err != nil {
+		return status, fmt.Errorf("failed to get user status: %v", err)
+	}
+	if userStatus.Status == model.StatusDnd {
+		status.DND = true
+		if userStatus.DNDEndTime > 0 {
+			status.DNDUntil = time.Unix(userStatus.DNDEndTime, 0)
+		}
+	}
+	return status, nil
+}
+
+// holdReason returns why userID shouldn't be messaged now, or "" if they can
+// be. Users whose status can't be read are messaged rather than held.
+func (b *Bot) holdReason(userID string) string {
+	if !b.config.QuietHours.Active() || !b.features.Enabled(FeatureQuietHours) {
+		return ""
+	}
+	status, err := b.userPresence(userID)
+	if err != nil {
+		log.Printf("[%s] QUIET: Failed to check whether %s is available, messaging anyway: %v", time.Now().Format("2006-01-02 15:04:05"), userID, err)
+		return ""
+	}
+	return b.config.QuietHours.Defer(status, time.Now(), b.scheduler.Location())
+}
+
+// notifyUser posts message to a user's DM channel, or holds it until they
+// are available. It reports whether the message was held.
+func (b *Bot) notifyUser(userID, channelID, message string, expires time.Time) (bool, error) {
+	if reason := b.holdReason(userID); reason != "" {
+		held := heldMessage{UserID: userID, ChannelID: channelID, Message: message, Reason: reason, HeldAt: time.Now(), Expires: expires}
+		key := fmt.Sprintf("%s-%d", userID, held.HeldAt.UnixNano())
+		if err := b.store.Put(heldMessagesBucket, key, held); err != nil {
+			return false, fmt.Errorf("failed to hold message: %v", err)
+		}
+		log.Printf("[%s] QUIET: Holding message to %s (%s)", time.Now().Format("2006-01-02 15:04:05"), userID, reason)
+		return true, nil
+	}
+
+	if _, _, err := b.client.CreatePost(&model.Post{ChannelId: channelID, Message: message}); err != nil {
+		return false, fmt.Errorf("failed to send direct message: %v", err)
+	}
+	return false, nil
+}
+
+// deliverHeldMessages sends the held DMs whose users are available now and
+// drops the ones that expired
+func (b *Bot) deliverHeldMessages() {
+	// Check each user once per round, not once per message
+	reasons := make(map[string]string)
+	for _, key := range b.store.Keys(heldMessagesBucket) {
+		var held heldMessage
+		if found, err := b.store.Get(heldMessagesBucket, key, &held); err != nil || !found {
+			continue
+		}
+		if !held.Expires.IsZero() && time.Now().After(held.Expires) {
+			log.Printf("[%s] QUIET: Dropping message to %s held since %s, it expired", time.Now().Format("2006-01-02 15:04:05"), held.UserID, held.HeldAt.Format("2006-01-02 15:04"))
+			b.dropHeldMessage(key)
+			continue
+		}
+
+		reason, checked := reasons[held.UserID]
+		if !checked {
+			reason = b.holdReason(held.UserID)
+			reasons[held.UserID] = reason
+		}
+		if reason != "" {
+			continue
+		}
+
+		if _, _, err := b.client.CreatePost(&model.Post{ChannelId: held.ChannelID, Message: held.Message}); err != nil {
+			log.Printf("[%s] QUIET: Failed to deliver held message to %s, will retry: %v", time.Now().Format("2006-01-02 15:04:05"), held.UserID, err)
+			continue
+		}
+		log.Printf("[%s] QUIET: Delivered message to %s held since %s", time.Now().Format("2006-01-02 15:04:05"), held.UserID, held.HeldAt.Format("2006-01-02 15:04"))
+		b.dropHeldMessage(key)
+	}
+}
+
+func (b *Bot) dropHeldMessage(key string) {
+	if err := b.store.Delete(heldMessagesBucket, key); err != nil {
+		log.Printf("[%s] QUIET: Failed to remove held message %s: %v", time.Now().Format("2006-01-02 15:04:05"), key, err)
+	}
+}
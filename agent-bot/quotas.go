@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"agent-bot/metrics"
+	"agent-bot/reqid"
+	"agent-bot/store"
+	"agent-bot/types"
+	"agent-bot/usage"
+)
+
+// quotasBucket holds limits admins set for single users and channels with
+// !quota, keyed "user:<id>" or "channel:<id>"
+const quotasBucket = "quotas"
+
+// quotaLimit is a daily LLM budget; zero fields are unlimited
+type quotaLimit struct {
+	Tokens int64   `json:"tokens,omitempty"`
+	USD    float64 `json:"usd,omitempty"`
+}
+
+// unlimited reports whether the limit allows any amount of use
+func (l quotaLimit) unlimited() bool {
+	return l.Tokens <= 0 && l.USD <= 0
+}
+
+// exceededBy reports whether daily usage is at or over the limit
+func (l quotaLimit) exceededBy(daily usage.Daily) bool {
+	return l.Tokens > 0 && daily.Tokens() >= l.Tokens || l.USD > 0 && daily.CostUSD >= l.USD
+}
+
+func (l quotaLimit) String() string {
+	var parts []string
+	if l.Tokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d tokens", l.Tokens))
+	}
+	if l.USD > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f", l.USD))
+	}
+	if len(parts) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(parts, " or ") + " a day"
+}
+
+// quotas enforces daily budgets per user and per channel from the usage
+// tracker's daily totals
+type quotas struct {
+	usage    *usage.Tracker
+	store    *store.Store
+	defaults map[usage.Scope]quotaLimit
+}
+
+func newQuotas(config Config, tracker *usage.Tracker, stateStore *store.Store) *quotas {
+	return &quotas{
+		usage: tracker,
+		store: stateStore,
+		defaults: map[usage.Scope]quotaLimit{
+			usage.ScopeUser:    config.UserQuota,
+			usage.ScopeChannel: config.ChannelQuota,
+		},
+	}
+}
+
+func quotaKey(scope usage.Scope, id string) string {
+	return string(scope) + ":" + id
+}
+
+// limit returns the limit for a user or channel: the admin's override, else the default
+func (q *quotas) limit(scope usage.Scope, id string) (limit quotaLimit, overridden bool) {
+	if found, err := q.store.Get(quotasBucket, quotaKey(scope, id), &limit); err == nil && found {
+		return limit, true
+	}
+	return q.defaults[scope], false
+}
+
+// exceeded returns the first of the user's and the channel's budgets used
+// up today, if any
+func (q *quotas) exceeded(userID, channelID string) (scope usage.Scope, limit quotaLimit, used usage.Daily, over bool) {
+	for _, check := range []struct {
+		scope usage.Scope
+		id    string
+	}{{usage.ScopeUser, userID}, {usage.ScopeChannel, channelID}} {
+		if check.id == "" {
+			continue
+		}
+		limit, _ := q.limit(check.scope, check.id)
+		if limit.unlimited() {
+			continue
+		}
+		if used := q.usage.Today(check.scope, check.id); limit.exceededBy(used) {
+			return check.scope, limit, used, true
+		}
+	}
+	return "", quotaLimit{}, usage.Daily{}, false
+}
+
+// withinQuota reports whether the sender and channel of message still have
+// budget left today. When they don't, a message addressed to the bot gets a
+// notice; unprompted thread replies are skipped quietly.
+func (a *BotAgent) withinQuota(ctx context.Context, message types.PostedMessage) bool {
+	if a.quotas == nil || !a.features.Enabled(FeatureQuotas) {
+		return true
+	}
+	scope, limit, used, over := a.quotas.exceeded(message.UserId, message.ChannelId)
+	if !over {
+		return true
+	}
+
+	id := message.UserId
+	if scope == usage.ScopeChannel {
+		id = message.ChannelId
+	}
+	reqid.Logf(ctx, "QUOTA: %s %s is over its daily quota (%d tokens, $%.2f of %s), not replying", scope, id, used.Tokens(), used.CostUSD, limit)
+	metrics.Inc("quota_refusals_total", "scope", string(scope))
+	if !a.isAddressed(message) {
+		return false
+	}
+
+	whose := "You've used your"
+	if scope == usage.ScopeChannel {
+		whose = "This channel has used its"
+	}
+	notice := types.PostedMessage{UserId: message.UserId, ChannelId: message.ChannelId, ThreadId: message.ThreadId}
+	if notice.ThreadId == "" && message.Mentioned {
+		notice.ThreadId = message.PostId
+	}
+	a.postNotice(ctx, notice, fmt.Sprintf("%s daily AI budget (%s). It resets at midnight UTC; an admin can raise it with `!quota`.", whose, limit))
+	return false
+}
+
+// handleQuotaCommand implements "!quota"
+func (b *Bot) handleQuotaCommand(message types.PostedMessage, args []string) string {
+	help := "Usage: `!quota list`, `!quota user|channel <id|here>` to show usage today, `!quota user|channel <id|here> [tokens=N] [usd=N]` to set a daily limit (0 is unlimited), `!quota user|channel <id|here> default`"
+	if len(args) == 0 || strings.ToLower(args[0]) == "list" {
+		return b.listQuotas()
+	}
+	if len(args) < 2 {
+		return help
+	}
+
+	scope := usage.Scope(strings.ToLower(args[0]))
+	if scope != usage.ScopeUser && scope != usage.ScopeChannel {
+		return help
+	}
+	id := args[1]
+	if id == "here" {
+		id = message.ChannelId
+		if scope == usage.ScopeUser {
+			id = message.UserId
+		}
+	}
+
+	if len(args) == 2 {
+		limit, overridden := b.quotas.limit(scope, id)
+		source := "default"
+		if overridden {
+			source = "set with !quota"
+		}
+		used := b.usage.Today(scope, id)
+		return fmt.Sprintf("Quota for %s `%s`: %s (%s). Used today: %d tokens, $%.4f.", scope, id, limit, source, used.Tokens(), used.CostUSD)
+	}
+
+	if strings.EqualFold(args[2], "default") {
+		if err := b.store.Delete(quotasBucket, quotaKey(scope, id)); err != nil {
+			return fmt.Sprintf("Failed to reset quota: %v", err)
+		}
+		limit, _ := b.quotas.limit(scope, id)
+		return fmt.Sprintf("Quota for %s `%s` reset to the default, %s.", scope, id, limit)
+	}
+
+	// Unset fields keep their current values, so raising one leaves the other
+	limit, _ := b.quotas.limit(scope, id)
+	for _, arg := range args[2:] {
+		key, value, ok := strings.Cut(strings.ToLower(arg), "=")
+		if !ok {
+			return help
+		}
+		switch key {
+		case "tokens":
+			tokens, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || tokens < 0 {
+				return "tokens must be a whole number, 0 for unlimited"
+			}
+			limit.Tokens = tokens
+		case "usd":
+			usd, err := strconv.ParseFloat(strings.TrimPrefix(value, "$"), 64)
+			if err != nil || usd < 0 {
+				return "usd must be a number such as 2.50, 0 for unlimited"
+			}
+			limit.USD = usd
+		default:
+			return help
+		}
+	}
+	if err := b.store.Put(quotasBucket, quotaKey(scope, id), limit); err != nil {
+		return fmt.Sprintf("Failed to save quota: %v", err)
+	}
+	return fmt.Sprintf("Quota for %s `%s` is now %s.", scope, id, limit)
+}
+
+func (b *Bot) listQuotas() string {
+	var sb strings.Builder
+	sb.WriteString("**Daily quotas**\n")
+	sb.WriteString(fmt.Sprintf("- Users: %s\n- Channels: %s\n", b.quotas.defaults[usage.ScopeUser], b.quotas.defaults[usage.ScopeChannel]))
+	for _, key := range b.store.Keys(quotasBucket) {
+		var limit quotaLimit
+		if found, err := b.store.Get(quotasBucket, key, &limit); err != nil || !found {
+			continue
+		}
+		scope, id, _ := strings.Cut(key, ":")
+		used := b.usage.Today(usage.Scope(scope), id)
+		sb.WriteString(fmt.Sprintf("- %s `%s`: %s (used today: %d tokens, $%.2f)\n", scope, id, limit, used.Tokens(), used.CostUSD))
+	}
+	return sb.String()
+}
+
+// quotaSummary describes the default quotas for !config
+func (b *Bot) quotaSummary() string {
+	return fmt.Sprintf("users %s, channels %s", b.config.UserQuota, b.config.ChannelQuota)
+}
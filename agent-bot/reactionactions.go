@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/llms"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/tracing"
+	"agent-bot/types"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Actions an emoji reaction can trigger
+const (
+	actionSummarize  = "summarize"
+	actionTranslate  = "translate"
+	actionRegenerate = "regenerate"
+	actionLeave      = "leave"
+)
+
+// defaultReactionActions is REACTION_ACTIONS when unset: 🧵, 🌐, 🔁 and 🙅
+const defaultReactionActions = "thread=summarize,globe_with_meridians=translate,repeat=regenerate,no_good=leave"
+
+const (
+	// reactionCooldown stops several people adding the same reaction from
+	// running the same action on a post more than once
+	reactionCooldown = 10 * time.Minute
+	// maxAnsweredReplies bounds the replies remembered for regeneration
+	maxAnsweredReplies = 200
+)
+
+// parseReactionActions parses "emoji=action,..." pairs; "off" maps nothing
+func parseReactionActions(value string) (map[string]string, error) {
+	actions := make(map[string]string)
+	if strings.TrimSpace(value) == "off" {
+		return actions, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		emoji, action, ok := strings.Cut(pair, "=")
+		emoji = strings.Trim(strings.TrimSpace(emoji), ":")
+		action = strings.TrimSpace(action)
+		if !ok || emoji == "" {
+			return nil, fmt.Errorf("%q is not emoji=action", pair)
+		}
+		switch action {
+		case actionSummarize, actionTranslate, actionRegenerate, actionLeave:
+		default:
+			return nil, fmt.Errorf("unknown action %q for :%s: (expected %s, %s, %s or %s)", action, emoji, actionSummarize, actionTranslate, actionRegenerate, actionLeave)
+		}
+		actions[emoji] = action
+	}
+	return actions, nil
+}
+
+// answeredReply is what a reply was generated from
+type answeredReply struct {
+	message types.PostedMessage
+	prompt  string
+	history []llms.Turn
+}
+
+// reactionActions runs agent actions for emoji reactions and remembers what
+// each recent reply was generated from, so it can be regenerated
+type reactionActions struct {
+	actions  map[string]string
+	language string
+
+	mu sync.Mutex
+	// answered maps reply post IDs to their source, oldest first in order
+	answered map[string]answeredReply
+	order    []string
+	// handled is when each post ID and action last ran
+	handled map[string]time.Time
+}
+
+func newReactionActions(actions map[string]string, language string) *reactionActions {
+	return &reactionActions{
+		actions:  actions,
+		language: language,
+		answered: make(map[string]answeredReply),
+		handled:  make(map[string]time.Time),
+	}
+}
+
+// remember records that replyID answered message with prompt, following
+// the conversation turns in history
+func (r *reactionActions) remember(replyID string, message types.PostedMessage, prompt string, history []llms.Turn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.answered[replyID]; !ok {
+		r.order = append(r.order, replyID)
+	}
+	r.answered[replyID] = answeredReply{message: message, prompt: prompt, history: history}
+	for len(r.order) > maxAnsweredReplies {
+		delete(r.answered, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// source returns what replyID was generated from
+func (r *reactionActions) source(replyID string) (answeredReply, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reply, ok := r.answered[replyID]
+	return reply, ok
+}
+
+// claim reports whether action may run on postID now, and if so records it
+func (r *reactionActions) claim(postID, action string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, at := range r.handled {
+		if now.Sub(at) >= reactionCooldown {
+			delete(r.handled, key)
+		}
+	}
+	key := postID + "/" + action
+	if _, ok := r.handled[key]; ok {
+		return false
+	}
+	r.handled[key] = now
+	return true
+}
+
+// rememberReply records the message, prompt and conversation turns a reply
+// post was generated from, for regeneration
+func (a *BotAgent) rememberReply(ctx context.Context, replyID string, message types.PostedMessage, prompt string) {
+	if a.reactionActions != nil {
+		a.reactionActions.remember(replyID, message, prompt, llms.HistoryFrom(ctx))
+	}
+}
+
+// ReactionAdded runs the action mapped to the emoji, if any
+func (a *BotAgent) ReactionAdded(reaction types.Reaction) {
+	if a.reactionActions == nil || reaction.UserId == a.botUserID || !a.features.Enabled(FeatureReactionActions) {
+		return
+	}
+	action, ok := a.reactionActions.actions[reaction.Emoji]
+	if !ok {
+		return
+	}
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	if !a.reactionActions.claim(reaction.PostId, action, a.now()) {
+		log.Printf("[%s] REACTION: Already ran %s on %s recently, ignoring :%s:", timestamp, action, reaction.PostId, reaction.Emoji)
+		return
+	}
+
+	ctx, span := tracing.Start(reqid.Ensure(context.Background()), "agent.reaction",
+		attribute.String("chat.post_id", reaction.PostId),
+		attribute.String("chat.channel_id", reaction.ChannelId),
+		attribute.String("reaction.action", action),
+	)
+	span.SetAttributes(attribute.String("request.id", reqid.From(ctx)))
+	defer span.End()
+
+	post, err := a.chat.GetMessage(reaction.PostId)
+	if err != nil {
+		reqid.Logf(ctx, "REACTION: Failed to get message %s for %s: %v", reaction.PostId, action, err)
+		return
+	}
+	thread := post.ThreadID
+	if thread == "" {
+		thread = post.ID
+	}
+	reqid.Logf(ctx, "REACTION: :%s: from %s on %s, running %s", reaction.Emoji, reaction.UserId, post.ID, action)
+
+	// Leaving costs nothing, and the bot is leaving whether or not it was in
+	// the thread
+	if action == actionLeave {
+		a.optOut(thread)
+		reqid.Logf(ctx, "THREAD: Left thread %s at the request of %s", thread, reaction.UserId)
+		a.postNotice(ctx, types.PostedMessage{UserId: reaction.UserId, ChannelId: post.ChannelID, ThreadId: thread}, "I'll stay out of this thread unless you mention me again.")
+		return
+	}
+
+	// Actions spend the reacting user's budget
+	if !a.withinQuota(ctx, types.PostedMessage{UserId: reaction.UserId, ChannelId: post.ChannelID, ThreadId: thread, Mentioned: true}) {
+		return
+	}
+
+	switch action {
+	case actionSummarize:
+		// No PostId: every post of the thread is summarized
+		a.summarizeThread(ctx, types.PostedMessage{UserId: reaction.UserId, ChannelId: post.ChannelID, ThreadId: thread})
+	case actionTranslate:
+		a.translatePost(ctx, post, thread, reaction.UserId)
+	case actionRegenerate:
+		a.regenerateReply(ctx, post)
+	}
+}
+
+// translatePost replies in the post's thread with a translation of it
+func (a *BotAgent) translatePost(ctx context.Context, post *types.Message, thread, userID string) {
+	if strings.TrimSpace(post.Content) == "" {
+		return
+	}
+	// A channel's !language default is the language its readers want
+	into := a.reactionActions.language
+	if a.languages != nil {
+		if name, ok := a.languages.override(post.ChannelID); ok {
+			into = name
+		}
+	}
+	prompt, err := a.prompts.Render(prompts.Translate, prompts.TranslateData{Language: into, Message: post.Content})
+	if err != nil {
+		reqid.Logf(ctx, "REACTION: Failed to render translate prompt: %v", err)
+		return
+	}
+	a.sendTypingIndicator(post.ChannelID, thread)
+	a.respondWithStream(ctx, types.PostedMessage{PostId: post.ID, UserId: userID, ChannelId: post.ChannelID, ThreadId: thread}, prompt)
+}
+
+// regenerateReply posts a new answer from the prompt one of the bot's replies
+// was generated from
+func (a *BotAgent) regenerateReply(ctx context.Context, reply *types.Message) {
+	if reply.UserID != a.botUserID {
+		return
+	}
+	source, ok := a.reactionActions.source(reply.ID)
+	if !ok {
+		reqid.Logf(ctx, "REACTION: Don't know what %s was generated from, can't regenerate it", reply.ID)
+		return
+	}
+	a.sendTypingIndicator(source.message.ChannelId, reply.ThreadID)
+	a.respondWithStream(llms.WithHistory(ctx, source.history), source.message, source.prompt)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"agent-bot/types"
+)
+
+// Reactions that acknowledge a mention: received while the reply is being
+// written, then answered or failed
+const (
+	reactionReceived = "eyes"
+	reactionAnswered = "white_check_mark"
+	reactionFailed   = "x"
+)
+
+// replyOutcome is how an attempt to reply ended
+type replyOutcome int
+
+const (
+	// replyPosted means the answer was delivered
+	replyPosted replyOutcome = iota
+	// replyFailed means the model or chat failed; an apology or partial
+	// answer may have been posted
+	replyFailed
+	// replyDropped means the reply was abandoned on purpose: superseded by a
+	// newer message or its post deleted
+	replyDropped
+)
+
+// acknowledge reacts to a mention as soon as the bot decides to answer it
+func (a *BotAgent) acknowledge(message types.PostedMessage) {
+	if !message.Mentioned || !a.features.Enabled(FeatureReactions) {
+		return
+	}
+	a.react(message.PostId, reactionReceived, true)
+}
+
+// settleAcknowledgements replaces the received reaction on each acknowledged
+// message with the outcome; a dropped reply just clears it
+func (a *BotAgent) settleAcknowledgements(messages []types.PostedMessage, outcome replyOutcome) {
+	if !a.features.Enabled(FeatureReactions) {
+		return
+	}
+	for _, message := range messages {
+		if !message.Mentioned {
+			continue
+		}
+		a.react(message.PostId, reactionReceived, false)
+		switch outcome {
+		case replyPosted:
+			a.react(message.PostId, reactionAnswered, true)
+		case replyFailed:
+			a.react(message.PostId, reactionFailed, true)
+		}
+	}
+}
+
+// react adds or removes a reaction; failures only cost the acknowledgement,
+// so they are logged and otherwise ignored
+func (a *BotAgent) react(postID, emoji string, add bool) {
+	var err error
+	if add {
+		err = a.chat.AddReaction(postID, emoji)
+	} else {
+		err = a.chat.RemoveReaction(postID, emoji)
+	}
+	if err != nil {
+		log.Printf("[%s] WARNING: Failed to update %s reaction on %s: %v", time.Now().Format("2006-01-02 15:04:05"), emoji, postID, err)
+	}
+}
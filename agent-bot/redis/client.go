@@ -0,0 +1,265 @@
+// Package redis is a small Redis client speaking RESP2 over TCP, with just
+// what the bot's distributed mode needs: commands as string arguments, a
+// pool of connections and blocking list pops.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNil is returned by the typed helpers when Redis answers with a nil reply,
+// e.g. GET of a missing key
+var ErrNil = errors.New("redis: nil reply")
+
+// Error is an error reply from the server, e.g. "WRONGTYPE ..."
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// maxIdle bounds the connections kept open between commands
+const maxIdle = 8
+
+// Client sends commands to one Redis server. It is safe for concurrent use;
+// each command borrows a pooled connection.
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	// timeout bounds dialing and each command without a context deadline
+	timeout time.Duration
+
+	idle chan *conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Open parses a URL like redis://[[user]:password@]host[:port][/db], or
+// rediss:// for TLS, and checks the server answers
+func Open(ctx context.Context, rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	c := &Client{timeout: 5 * time.Second, idle: make(chan *conn, maxIdle)}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid Redis URL: scheme must be redis or rediss, not %q", u.Scheme)
+	}
+	c.addr = u.Host
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis URL: database %q is not a number", db)
+		}
+	}
+
+	if err := c.Ping(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Addr is the server's host:port
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Ping checks the server answers
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Do sends a command and returns the reply: a string for simple strings and
+// bulk strings, int64 for integers, []any for arrays and nil for nil
+// replies. Error replies are returned as Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.roundTrip(ctx, cn, args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection may be mid-reply; don't reuse it
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+func (c *Client) roundTrip(ctx context.Context, cn *conn, args []string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.timeout)
+	}
+	cn.SetDeadline(deadline)
+
+	if _, err := cn.Write(encode(args)); err != nil {
+		return nil, fmt.Errorf("redis: failed to send %s: %w", args[0], err)
+	}
+	reply, err := readReply(cn.r)
+	if err != nil {
+		var replyErr Error
+		if errors.As(err, &replyErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("redis: failed to read reply to %s: %w", args[0], err)
+	}
+	return reply, nil
+}
+
+// get borrows an idle connection or dials a new one
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var nc net.Conn
+	var err error
+	if c.tls != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: c.tls}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to connect to %s: %w", c.addr, err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	var setup [][]string
+	if c.password != "" {
+		if c.username != "" {
+			setup = append(setup, []string{"AUTH", c.username, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(ctx, cn, args); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool, closing it when the pool is full
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// Close closes the idle connections
+func (c *Client) Close() {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// encode writes a command as a RESP array of bulk strings
+func encode(args []string) []byte {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		sb.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return []byte(sb.String())
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("malformed bulk length %q", body)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("malformed array length %q", body)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, count)
+		for i := range items {
+			item, err := readReply(r)
+			// Errors inside an array, e.g. from EXEC, are values; the rest of
+			// the array must still be read
+			var replyErr Error
+			if errors.As(err, &replyErr) {
+				items[i] = replyErr
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
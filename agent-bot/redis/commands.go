@@ -0,0 +1,81 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// String runs a command whose reply is a string, returning ErrNil for nil
+func (c *Client) String(ctx context.Context, args ...string) (string, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return "", ErrNil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	}
+	return "", fmt.Errorf("redis: %s replied %T, not a string", args[0], reply)
+}
+
+// Int runs a command whose reply is an integer
+func (c *Client) Int(ctx context.Context, args ...string) (int64, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := reply.(int64); ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("redis: %s replied %T, not an integer", args[0], reply)
+}
+
+// Strings runs a command whose reply is an array of strings; nil elements
+// become ""
+func (c *Client) Strings(ctx context.Context, args ...string) ([]string, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("redis: %s replied %T, not an array", args[0], reply)
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i], _ = item.(string)
+	}
+	return values, nil
+}
+
+// SetNX sets key to value with a TTL if it doesn't exist yet, reporting
+// whether it was set
+func (c *Client) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	reply, err := c.Do(ctx, "SET", key, value, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return reply != nil, err
+}
+
+// BRPop pops from the tail of the first non-empty list among keys, waiting
+// up to timeout. It returns the list and the value, or ErrNil on timeout.
+func (c *Client) BRPop(ctx context.Context, timeout time.Duration, keys ...string) (string, string, error) {
+	// The connection waits as long as the server does, plus a margin
+	ctx, cancel := context.WithTimeout(ctx, timeout+c.timeout)
+	defer cancel()
+
+	args := append([]string{"BRPOP"}, keys...)
+	args = append(args, strconv.FormatFloat(timeout.Seconds(), 'f', 3, 64))
+	values, err := c.Strings(ctx, args...)
+	if err != nil {
+		return "", "", err
+	}
+	if len(values) != 2 {
+		return "", "", ErrNil
+	}
+	return values[0], values[1], nil
+}
@@ -0,0 +1,118 @@
+// Package render turns chart and diagram specs written by the model into PNG
+// images, using a Kroki server (https://kroki.io)
+package render
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxSourceBytes bounds a spec sent for rendering
+	maxSourceBytes = 64 * 1024
+	// maxImageBytes bounds a rendered image
+	maxImageBytes = 10 * 1024 * 1024
+)
+
+// Formats are the spec languages that can be rendered, as Kroki names them
+var Formats = []string{"mermaid", "vegalite", "graphviz", "plantuml"}
+
+// aliases maps other common names to Formats
+var aliases = map[string]string{
+	"vega-lite": "vegalite",
+	"dot":       "graphviz",
+}
+
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client for the Kroki server at baseURL
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: httpClient}
+}
+
+// Format normalizes a format name, or returns an error naming the supported ones
+func Format(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if !slices.Contains(Formats, name) {
+		return "", fmt.Errorf("unsupported format %q (expected one of %s)", name, strings.Join(Formats, ", "))
+	}
+	return name, nil
+}
+
+// Render renders source in format to a PNG. Kroki's error text for a bad
+// spec is returned as is, so the model can fix the spec and try again.
+func (c *Client) Render(ctx context.Context, format, source string) ([]byte, error) {
+	format, err := Format(format)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("the spec is empty")
+	}
+	if len(source) > maxSourceBytes {
+		return nil, fmt.Errorf("the spec is %d bytes, more than the %d allowed", len(source), maxSourceBytes)
+	}
+	if format == "vegalite" && !json.Valid([]byte(source)) {
+		return nil, fmt.Errorf("a vegalite spec must be JSON")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/"+format+"/png", strings.NewReader(source))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Accept", "image/png")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rendering failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if len(body) > maxImageBytes {
+		return nil, fmt.Errorf("the rendered image is larger than %d bytes", maxImageBytes)
+	}
+	if !bytes.HasPrefix(body, []byte("\x89PNG")) {
+		return nil, fmt.Errorf("the renderer did not return a PNG")
+	}
+	return body, nil
+}
+
+// Ping checks the server is up
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
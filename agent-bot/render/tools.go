@@ -0,0 +1,61 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"agent-bot/tools"
+	"agent-bot/types"
+)
+
+type RenderArgs struct {
+	Format  string `json:"format" jsonschema_description:"Spec language: mermaid (flowcharts, sequence diagrams, timelines, pie charts), vegalite (data charts, as JSON with inline data values), graphviz (DOT) or plantuml"`
+	Source  string `json:"source" jsonschema_description:"The complete spec"`
+	Title   string `json:"title,omitempty" jsonschema_description:"Short title, used for the file name (optional)"`
+	Message string `json:"message,omitempty" jsonschema_description:"Short note posted with the image (optional)"`
+}
+
+// Tools returns render_diagram, which posts the rendered image to the
+// requesting conversation with upload, e.g. types.Chat.UploadFile
+func (c *Client) Tools(upload func(types.FileUpload) error) []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "render_diagram",
+			Description: "Render a chart or diagram spec (Mermaid, Vega-Lite, Graphviz or PlantUML) to an image and post it to the conversation. Use it to answer data questions visually or to sketch flows; then refer to the image in your reply instead of repeating the spec.",
+			Schema:      tools.SchemaFor[RenderArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input RenderArgs) (interface{}, error) {
+				req, ok := tools.RequestFrom(ctx)
+				if !ok || req.ChannelID == "" {
+					return nil, fmt.Errorf("no conversation to post the image to")
+				}
+				image, err := c.Render(ctx, input.Format, input.Source)
+				if err != nil {
+					return nil, fmt.Errorf("error rendering %s: %w", input.Format, err)
+				}
+
+				filename := fileName(input.Title) + ".png"
+				err = upload(types.FileUpload{ChannelId: req.ChannelID, ThreadId: req.ThreadID, Filename: filename, Data: image, Message: input.Message})
+				if err != nil {
+					return nil, fmt.Errorf("error posting image: %w", err)
+				}
+				return map[string]interface{}{"posted": filename, "bytes": len(image)}, nil
+			}),
+		},
+	}
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// fileName turns a title into a file name such as error-rate-by-service
+func fileName(title string) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(name) > 60 {
+		name = strings.TrimRight(name[:60], "-")
+	}
+	if name == "" {
+		return "diagram"
+	}
+	return name
+}
@@ -0,0 +1,450 @@
+// Package repl is a terminal chat for local development. It implements
+// types.Chat on stdin/stdout with in-memory channels and threads, so prompts
+// and tools can be tried without a chat server.
+package repl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/types"
+)
+
+const (
+	// LocalUserID is the user typing at the terminal
+	LocalUserID   = "local-user"
+	botUserID     = "repl-bot"
+	channelID     = "repl"
+	dmChannelID   = "repl-dm"
+	maxImageBytes = 5 * 1024 * 1024
+	maxAudioBytes = 25 * 1024 * 1024
+)
+
+const helpText = `Type a message and press enter. Commands:
+  /dm        talk to the bot directly; every message is answered (default)
+  /channel   post in a channel; the bot only answers mentions and its threads
+  /thread N  continue thread N (the number shown next to a post)
+  /new       leave the current thread
+  /attach P  attach the image or audio file at path P to the next message
+  /threads   list threads
+  /quit      exit`
+
+// Terminal is a chat on stdin/stdout
+type Terminal struct {
+	in          io.Reader
+	out         io.Writer
+	botUsername string
+	username    string
+
+	mu       sync.Mutex
+	nextID   int
+	messages map[string]*types.Message
+	threads  map[string][]string
+	printed  map[string]string
+	midLine  bool
+
+	// Where the next line goes
+	dm          bool
+	thread      string
+	attachments []string
+}
+
+// New creates a terminal chat where the bot is called botUsername
+func New(in io.Reader, out io.Writer, botUsername string) *Terminal {
+	username := os.Getenv("USER")
+	if username == "" {
+		username = "you"
+	}
+	return &Terminal{
+		in:          in,
+		out:         out,
+		botUsername: botUsername,
+		username:    username,
+		messages:    make(map[string]*types.Message),
+		threads:     make(map[string][]string),
+		printed:     make(map[string]string),
+		dm:          true,
+	}
+}
+
+// Identify is a no-op; there is no server to authenticate against
+func (t *Terminal) Identify(ctx context.Context) error {
+	return nil
+}
+
+// Identity returns the bot's user ID and username
+func (t *Terminal) Identity() (userID, username string) {
+	return botUserID, t.botUsername
+}
+
+// TeamOf attributes all usage to one local team
+func (t *Terminal) TeamOf(channelID string) string {
+	return "local"
+}
+
+// Connected is always true
+func (t *Terminal) Connected() bool {
+	return true
+}
+
+// Listen reads lines from the terminal and delivers them to agent until
+// /quit, end of input or ctx is cancelled
+func (t *Terminal) Listen(ctx context.Context, agent types.Agent) error {
+	fmt.Fprintln(t.out, helpText)
+	scanner := bufio.NewScanner(t.in)
+	for {
+		t.prompt()
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			if quit := t.command(line); quit {
+				return nil
+			}
+			continue
+		}
+		agent.MessagePosted(t.post(line))
+	}
+}
+
+// post records a line typed by the user and turns it into an event
+func (t *Terminal) post(line string) types.PostedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	channel := channelID
+	if t.dm {
+		channel = dmChannelID
+	}
+	id := t.addLocked(LocalUserID, channel, t.thread, line)
+	message := types.PostedMessage{
+		PostId:    id,
+		UserId:    LocalUserID,
+		ThreadId:  t.thread,
+		ChannelId: channel,
+		Message:   line,
+		IsDM:      t.dm,
+		FileIds:   t.attachments,
+		Mentioned: strings.Contains(line, "@"+t.botUsername),
+	}
+	t.attachments = nil
+	return message
+}
+
+func (t *Terminal) command(line string) (quit bool) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch name {
+	case "/quit", "/exit":
+		return true
+	case "/dm":
+		t.dm, t.thread = true, ""
+		fmt.Fprintln(t.out, "Talking to the bot directly.")
+	case "/channel":
+		t.dm, t.thread = false, ""
+		fmt.Fprintf(t.out, "Posting in a channel; mention @%s to get an answer.\n", t.botUsername)
+	case "/thread":
+		id := "m" + strings.TrimPrefix(arg, "m")
+		message, ok := t.messages[id]
+		if !ok {
+			fmt.Fprintf(t.out, "No post %s.\n", arg)
+			return false
+		}
+		if message.ThreadID != "" {
+			id = message.ThreadID
+		}
+		t.thread = id
+		t.dm = t.messages[id].ChannelID == dmChannelID
+		fmt.Fprintf(t.out, "In thread %s.\n", id)
+	case "/new":
+		t.thread = ""
+		fmt.Fprintln(t.out, "Left the thread.")
+	case "/attach":
+		if _, err := os.Stat(arg); err != nil {
+			fmt.Fprintf(t.out, "Cannot attach %q: %v\n", arg, err)
+			return false
+		}
+		t.attachments = append(t.attachments, arg)
+		fmt.Fprintf(t.out, "Attached %s to the next message.\n", filepath.Base(arg))
+	case "/threads":
+		roots := make([]string, 0, len(t.threads))
+		for root := range t.threads {
+			roots = append(roots, root)
+		}
+		sort.Strings(roots)
+		for _, root := range roots {
+			fmt.Fprintf(t.out, "  %s (%d replies): %s\n", root, len(t.threads[root]), firstLine(t.messages[root].Content))
+		}
+	default:
+		fmt.Fprintln(t.out, helpText)
+	}
+	return false
+}
+
+func (t *Terminal) addLocked(userID, channel, threadID, content string) string {
+	t.nextID++
+	id := fmt.Sprintf("m%d", t.nextID)
+	t.messages[id] = &types.Message{
+		ID:        id,
+		UserID:    userID,
+		ChannelID: channel,
+		ThreadID:  threadID,
+		Content:   content,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if threadID != "" {
+		t.threads[threadID] = append(t.threads[threadID], id)
+	}
+	return id
+}
+
+func (t *Terminal) prompt() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	where := "dm"
+	if !t.dm {
+		where = "channel"
+	}
+	if t.thread != "" {
+		where += " " + t.thread
+	}
+	if t.midLine {
+		fmt.Fprint(t.out, "\n\n")
+		t.midLine = false
+	}
+	fmt.Fprintf(t.out, "[%s] > ", where)
+}
+
+// PostMessage prints the bot's message and records it
+func (t *Terminal) PostMessage(message types.ChatMessage) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if message.ThreadId != "" {
+		if _, ok := t.messages[message.ThreadId]; !ok {
+			return "", fmt.Errorf("no thread %s", message.ThreadId)
+		}
+		if _, ok := t.threads[message.ThreadId]; !ok {
+			t.threads[message.ThreadId] = nil
+		}
+	}
+	// Cards are printed as text after the message
+	content := message.Message
+	for _, card := range message.Attachments {
+		content = strings.TrimSpace(content + "\n\n" + card.Markdown())
+	}
+	id := t.addLocked(botUserID, message.ChannelId, message.ThreadId, content)
+	t.printed[id] = content
+	fmt.Fprintf(t.out, "\n%s: %s", t.label(id), content)
+	t.midLine = true
+	return id, nil
+}
+
+// UpdateMessage prints only what was appended when the bot streams, or the
+// whole message again when it was rewritten
+func (t *Terminal) UpdateMessage(messageID string, newContent string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	message, ok := t.messages[messageID]
+	if !ok {
+		return types.ErrMessageDeleted
+	}
+	message.Content = newContent
+
+	printed := t.printed[messageID]
+	if strings.HasPrefix(newContent, printed) {
+		fmt.Fprint(t.out, newContent[len(printed):])
+	} else {
+		fmt.Fprintf(t.out, "\n%s: %s", t.label(messageID), newContent)
+	}
+	t.printed[messageID] = newContent
+	t.midLine = true
+	return nil
+}
+
+// label names a bot post and where it went, e.g. "@agent [m4 in thread m1]"
+func (t *Terminal) label(id string) string {
+	message := t.messages[id]
+	if message.ThreadID == "" {
+		return fmt.Sprintf("@%s [%s]", t.botUsername, id)
+	}
+	return fmt.Sprintf("@%s [%s in thread %s]", t.botUsername, id, message.ThreadID)
+}
+
+// PostEphemeral prints a notice that isn't kept in the conversation
+func (t *Terminal) PostEphemeral(userID string, message types.ChatMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.out, "\n@%s (only visible to you): %s", t.botUsername, message.Message)
+	t.midLine = true
+	return nil
+}
+
+// AddReaction is a no-op; the replies themselves show progress
+func (t *Terminal) AddReaction(messageID, emoji string) error {
+	return nil
+}
+
+// RemoveReaction is a no-op
+func (t *Terminal) RemoveReaction(messageID, emoji string) error {
+	return nil
+}
+
+// SendTypingIndicator is a no-op
+func (t *Terminal) SendTypingIndicator(channelID, threadID string) error {
+	return nil
+}
+
+// GetMessage returns a recorded message
+func (t *Terminal) GetMessage(messageID string) (*types.Message, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	message, ok := t.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("no message %s", messageID)
+	}
+	copied := *message
+	return &copied, nil
+}
+
+// GetThreadMessages returns a thread's root and replies, oldest first
+func (t *Terminal) GetThreadMessages(threadID string) ([]*types.Message, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	root, ok := t.messages[threadID]
+	if !ok {
+		return nil, fmt.Errorf("no thread %s", threadID)
+	}
+	copiedRoot := *root
+	result := []*types.Message{&copiedRoot}
+	for _, id := range t.threads[threadID] {
+		copied := *t.messages[id]
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// GetUser knows the local user and the bot
+func (t *Terminal) GetUser(userID string) (*types.User, error) {
+	switch userID {
+	case LocalUserID:
+		return &types.User{ID: LocalUserID, Username: t.username}, nil
+	case botUserID:
+		return &types.User{ID: botUserID, Username: t.botUsername, IsBot: true}, nil
+	}
+	return nil, fmt.Errorf("unknown user %s", userID)
+}
+
+// GetChannel knows the terminal's channel and DM
+func (t *Terminal) GetChannel(id string) (*types.Channel, error) {
+	switch id {
+	case channelID, dmChannelID:
+		return &types.Channel{ID: id, Name: id, DisplayName: id}, nil
+	}
+	return nil, fmt.Errorf("unknown channel %s", id)
+}
+
+// UploadFile saves the file to a temporary directory and posts its path
+func (t *Terminal) UploadFile(upload types.FileUpload) error {
+	dir := filepath.Join(os.TempDir(), "agent-bot-uploads")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to save %s: %w", upload.Filename, err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(upload.Filename)))
+	if err := os.WriteFile(path, upload.Data, 0o644); err != nil {
+		return fmt.Errorf("failed to save %s: %w", upload.Filename, err)
+	}
+
+	message := strings.TrimSpace(fmt.Sprintf("%s\n[attached %s, %d bytes]", upload.Message, path, len(upload.Data)))
+	_, err := t.PostMessage(types.ChatMessage{ChannelId: upload.ChannelId, ThreadId: upload.ThreadId, Message: message})
+	return err
+}
+
+// GetImages reads attached files; file IDs are local paths
+func (t *Terminal) GetImages(fileIDs []string) ([]types.Image, error) {
+	var images []types.Image
+	for _, path := range fileIDs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return images, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		mediaType := http.DetectContentType(data)
+		if strings.HasPrefix(audioType(path, data), "audio/") {
+			continue
+		}
+		if !strings.HasPrefix(mediaType, "image/") || len(data) > maxImageBytes {
+			fmt.Fprintf(t.out, "Skipping %s (%s, %d bytes)\n", path, mediaType, len(data))
+			continue
+		}
+		images = append(images, types.Image{Name: filepath.Base(path), MediaType: mediaType, Data: data})
+	}
+	return images, nil
+}
+
+// GetAudio reads attached audio files; file IDs are local paths
+func (t *Terminal) GetAudio(fileIDs []string) ([]types.Audio, error) {
+	var audio []types.Audio
+	for _, path := range fileIDs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return audio, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		mediaType := audioType(path, data)
+		if !strings.HasPrefix(mediaType, "audio/") {
+			continue
+		}
+		if len(data) > maxAudioBytes {
+			fmt.Fprintf(t.out, "Skipping %s (%s, %d bytes)\n", path, mediaType, len(data))
+			continue
+		}
+		audio = append(audio, types.Audio{Name: filepath.Base(path), MediaType: mediaType, Data: data})
+	}
+	return audio, nil
+}
+
+// audioTypes maps the extensions of common voice formats, which content
+// sniffing misses or reports as application/ogg, to their media types
+var audioTypes = map[string]string{
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".webm": "audio/webm",
+}
+
+// audioType returns a file's media type, by extension for voice formats
+func audioType(path string, data []byte) string {
+	if mediaType, ok := audioTypes[strings.ToLower(filepath.Ext(path))]; ok {
+		return mediaType
+	}
+	return http.DetectContentType(data)
+}
+
+func firstLine(content string) string {
+	line, _, _ := strings.Cut(content, "\n")
+	return line
+}
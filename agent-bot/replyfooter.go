@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"agent-bot/llms"
+)
+
+// footerKey holds the replyFooter of a reply in its context
+type footerKey struct{}
+
+// replyFooter is what a reply's footer reports: the model and tools the
+// request recorded and when the reply was started
+type replyFooter struct {
+	info    *llms.ReplyInfo
+	started time.Time
+}
+
+// withReplyFooter records the model and tools of the reply when its channel's
+// style turns the footer on
+func (a *BotAgent) withReplyFooter(ctx context.Context, channelID string) context.Context {
+	if a.styles == nil {
+		return ctx
+	}
+	if show := a.styles.For(channelID).Footer; show == nil || !*show {
+		return ctx
+	}
+	info := &llms.ReplyInfo{}
+	ctx = llms.WithReplyInfo(ctx, info)
+	return context.WithValue(ctx, footerKey{}, replyFooter{info: info, started: a.now()})
+}
+
+// withFooterLine appends the footer of the reply in ctx as a small italic
+// line: model, tools used and response time
+func (a *BotAgent) withFooterLine(ctx context.Context, reply string) string {
+	footer, ok := ctx.Value(footerKey{}).(replyFooter)
+	if !ok || reply == "" {
+		return reply
+	}
+
+	var parts []string
+	if model := footer.info.Model(); model != "" {
+		parts = append(parts, model)
+	}
+	if tools := footer.info.Tools(); len(tools) > 0 {
+		parts = append(parts, "tools: "+strings.Join(tools, ", "))
+	} else {
+		parts = append(parts, "no tools")
+	}
+	parts = append(parts, fmt.Sprintf("%.1fs", a.now().Sub(footer.started).Seconds()))
+	return reply + "\n\n_" + strings.Join(parts, " · ") + "_"
+}
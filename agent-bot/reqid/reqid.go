@@ -0,0 +1,52 @@
+// Package reqid gives each incoming message a correlation ID that follows it
+// through the agent, LLM calls and tool calls, so one conversation's whole
+// lifecycle can be grepped out of the logs with "req=<id>".
+package reqid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+type idKey struct{}
+
+// New returns a fresh random ID
+func New() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// With attaches id to ctx
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// Ensure returns ctx with an ID, keeping one that is already attached
+func Ensure(ctx context.Context) context.Context {
+	if From(ctx) != "" {
+		return ctx
+	}
+	return With(ctx, New())
+}
+
+// From returns the ID attached to ctx, or ""
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// Logf logs like the rest of the bot, "[time] CATEGORY: message", with the
+// ID of ctx after the time when there is one
+func Logf(ctx context.Context, format string, args ...any) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	if id := From(ctx); id != "" {
+		log.Printf("[%s] [req=%s] %s", timestamp, id, fmt.Sprintf(format, args...))
+		return
+	}
+	log.Printf("[%s] %s", timestamp, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,62 @@
+// Package routing decides whether a request is simple enough for a small,
+// fast model or needs the main one.
+package routing
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Route is the model a request is sent to
+type Route string
+
+const (
+	// Simple requests go to the small model: greetings, small talk and short
+	// questions answered from general knowledge
+	Simple Route = "simple"
+	// Complex requests go to the main model: anything needing tools, current
+	// information, analysis or a long answer
+	Complex Route = "complex"
+)
+
+// maxSimpleWords is the longest message the heuristic treats as simple
+const maxSimpleWords = 30
+
+// complexPattern matches requests that likely need tools, current
+// information, multi-step reasoning or a long answer
+var complexPattern = regexp.MustCompile(`(?i)\b(asana|jira|tickets?|issues?|tasks?|projects?|search|look\s*up|find|latest|current|today|tomorrow|yesterday|news|price|weather|fetch|links?|url|website|docs?|documents?|summari[sz]e|recap|analy[sz]e|compare|why|debug|errors?|logs?|stack\s*trace|code|script|query|sql|regex|write|draft|rewrite|plan|design|architecture|calculate|estimate|step[-\s]by[-\s]step|pros\s+and\s+cons|remind|schedule|create|update|assign|remember)\b`)
+
+// Classify is the heuristic classifier: short messages without code, links,
+// several questions or words suggesting tools or analysis are simple
+func Classify(message string) Route {
+	text := strings.TrimSpace(message)
+	switch {
+	case text == "":
+		return Simple
+	case len(strings.Fields(text)) > maxSimpleWords:
+		return Complex
+	case strings.Contains(text, "```") || strings.Count(text, "\n") >= 3:
+		return Complex
+	case strings.Contains(text, "http://") || strings.Contains(text, "https://"):
+		return Complex
+	case strings.Count(text, "?") > 1:
+		return Complex
+	case complexPattern.MatchString(text):
+		return Complex
+	}
+	return Simple
+}
+
+// ParseAnswer reads a classifier model's "SIMPLE" or "COMPLEX" answer
+func ParseAnswer(answer string) (Route, bool) {
+	answer = strings.ToUpper(answer)
+	simple := strings.Contains(answer, "SIMPLE")
+	complex := strings.Contains(answer, "COMPLEX")
+	switch {
+	case simple && !complex:
+		return Simple, true
+	case complex && !simple:
+		return Complex, true
+	}
+	return "", false
+}
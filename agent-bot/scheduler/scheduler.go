@@ -0,0 +1,136 @@
+// Package scheduler runs named jobs daily at a time of day or at a fixed
+// interval, in one time zone.
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a unit of scheduled work
+type Job func()
+
+type entry struct {
+	name string
+	// next returns the first run time strictly after t
+	next func(t time.Time) time.Time
+	job  Job
+}
+
+// Scheduler runs jobs at fixed times of day or fixed intervals
+type Scheduler struct {
+	location *time.Location
+
+	mu      sync.Mutex
+	entries []*entry
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func New(location *time.Location) *Scheduler {
+	if location == nil {
+		location = time.UTC
+	}
+	return &Scheduler{location: location, stop: make(chan struct{})}
+}
+
+// Location returns the time zone jobs are scheduled in
+func (s *Scheduler) Location() *time.Location {
+	return s.location
+}
+
+// ParseTimeOfDay parses "15:04" into hours and minutes
+func ParseTimeOfDay(at string) (hour, minute int, err error) {
+	parsed, err := time.Parse("15:04", at)
+	if err != nil {
+		return 0, 0, fmt.Errorf("time of day must look like 15:04: %w", err)
+	}
+	return parsed.Hour(), parsed.Minute(), nil
+}
+
+// Daily runs job every day at the given "15:04" time in the scheduler's location
+func (s *Scheduler) Daily(name, at string, job Job) error {
+	hour, minute, err := ParseTimeOfDay(at)
+	if err != nil {
+		return err
+	}
+
+	s.add(&entry{
+		name: name,
+		next: func(t time.Time) time.Time {
+			t = t.In(s.location)
+			run := time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, s.location)
+			if !run.After(t) {
+				run = run.AddDate(0, 0, 1)
+			}
+			return run
+		},
+		job: job,
+	})
+	return nil
+}
+
+// Every runs job at a fixed interval, starting one interval from now
+func (s *Scheduler) Every(name string, interval time.Duration, job Job) {
+	s.add(&entry{
+		name: name,
+		next: func(t time.Time) time.Time { return t.Add(interval) },
+		job:  job,
+	})
+}
+
+func (s *Scheduler) add(e *entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+}
+
+// Start launches one goroutine per job; jobs added after Start are not run
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		s.wg.Add(1)
+		go s.run(e)
+	}
+}
+
+// Stop cancels all pending runs and waits for running jobs to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(e *entry) {
+	defer s.wg.Done()
+
+	for {
+		next := e.next(time.Now())
+		log.Printf("[%s] SCHEDULER: Next run of %s at %s", time.Now().Format("2006-01-02 15:04:05"), e.name, next.Format("2006-01-02 15:04:05 MST"))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			s.runJob(e)
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (s *Scheduler) runJob(e *entry) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[%s] SCHEDULER: Job %s panicked: %v", time.Now().Format("2006-01-02 15:04:05"), e.name, r)
+		}
+	}()
+
+	start := time.Now()
+	log.Printf("[%s] SCHEDULER: Running %s", start.Format("2006-01-02 15:04:05"), e.name)
+	e.job()
+	log.Printf("[%s] SCHEDULER: Finished %s in %v", time.Now().Format("2006-01-02 15:04:05"), e.name, time.Since(start))
+}
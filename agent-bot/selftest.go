@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"agent-bot/llms"
+	"agent-bot/mcpclient"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// selfTestTimeout bounds each startup check
+const selfTestTimeout = 30 * time.Second
+
+// selfTestFailure is a failed startup check with a hint on how to fix it
+type selfTestFailure struct {
+	check string
+	err   error
+	hint  string
+}
+
+// selfTest collects the results of startup checks
+type selfTest struct {
+	failures []selfTestFailure
+}
+
+func (t *selfTest) run(check, hint string, fn func(ctx context.Context) (string, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	started := time.Now()
+	detail, err := fn(ctx)
+	if err != nil {
+		log.Printf("[%s] SELFTEST: FAIL %s: %v", time.Now().Format("2006-01-02 15:04:05"), check, err)
+		t.failures = append(t.failures, selfTestFailure{check: check, err: err, hint: hint})
+		return
+	}
+	log.Printf("[%s] SELFTEST: OK   %s (%s, %v)", time.Now().Format("2006-01-02 15:04:05"), check, detail, time.Since(started).Round(time.Millisecond))
+}
+
+// fatalOnFailure stops the process with every failure and its fix
+func (t *selfTest) fatalOnFailure() {
+	if len(t.failures) == 0 {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Startup self-test failed (%d checks):", len(t.failures)))
+	for _, failure := range t.failures {
+		sb.WriteString(fmt.Sprintf("\n  - %s: %v\n    fix: %s", failure.check, failure.err, failure.hint))
+	}
+	sb.WriteString("\nSet STARTUP_SELF_TEST=false to start anyway.")
+	log.Fatal(sb.String())
+}
+
+// resolveBotUser verifies the access token and fills in the bot's identity
+// from the token's user, so mentions are always matched against the account
+// the bot actually posts as. A configured MATTERMOST_BOT_USER_ID must match.
+func resolveBotUser(config *Config, tlsConfig *tls.Config) error {
+	client := model.NewAPIv4Client(config.ServerURL)
+	client.HTTPClient = newHTTPClient(tlsConfig)
+	client.SetToken(config.AccessToken)
+
+	me, resp, err := client.GetMe("")
+	if err != nil {
+		if resp != nil && resp.StatusCode == 401 {
+			return fmt.Errorf("MATTERMOST_ACCESS_TOKEN was rejected by %s; create a new bot access token under Integrations > Bot Accounts", config.ServerURL)
+		}
+		return fmt.Errorf("could not reach Mattermost at %s (%v); check MATTERMOST_SERVER_URL and TLS settings", config.ServerURL, err)
+	}
+
+	if config.BotUserID != "" && config.BotUserID != me.Id {
+		return fmt.Errorf("MATTERMOST_BOT_USER_ID is %s but the access token belongs to @%s (%s); remove MATTERMOST_BOT_USER_ID to detect it automatically", config.BotUserID, me.Username, me.Id)
+	}
+	config.BotUserID = me.Id
+
+	if name := os.Getenv("BOT_USERNAME"); name != "" && name != me.Username {
+		log.Printf("Warning: BOT_USERNAME=%s is ignored; the token belongs to @%s", name, me.Username)
+	}
+	config.BotUsername = me.Username
+
+	// BOT_DISPLAY_NAME overrides how the bot is named in conversation transcripts
+	if config.BotDisplayName == "" {
+		config.BotDisplayName = me.GetDisplayName(model.ShowNicknameFullName)
+	}
+
+	log.Printf("[%s] SELFTEST: OK   Mattermost token (authenticated as @%s, %s, display name %q)", time.Now().Format("2006-01-02 15:04:05"), me.Username, me.Id, config.BotDisplayName)
+	return nil
+}
+
+// runStartupSelfTest pings the LLM backends, the services behind the shared
+// tools and the MCP servers and exits with actionable errors if any of them is unusable
+func runStartupSelfTest(config Config, llmBackend, decisionLLMBackend llms.LLMBackend, shared *sharedTools, mcpConfigs []mcpclient.ServerConfig) {
+	test := &selfTest{}
+
+	ping := func(backend llms.LLMBackend) func(ctx context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			reply, err := backend.Prompt(llms.WithoutTools(ctx), "Reply with the single word OK.")
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("replied %q", strings.TrimSpace(reply)), nil
+		}
+	}
+	test.run("LLM "+config.AnthropicModel, "check ANTHROPIC_API_KEY and that ANTHROPIC_MODEL is a model your key can use", ping(llmBackend))
+	test.run("Decision LLM "+config.DecisionModel, "check DECISION_MODEL is a model your key can use", ping(decisionLLMBackend))
+
+	test.run("Asana", "check ASANA_API_KEY is a valid personal access token", func(ctx context.Context) (string, error) {
+		if err := shared.asana.Ping(ctx); err != nil {
+			return "", err
+		}
+		return "token accepted", nil
+	})
+
+	if shared.jira != nil {
+		test.run("Jira", "check JIRA_BASE_URL, and that JIRA_API_TOKEN is an API token for JIRA_EMAIL (or a personal access token with JIRA_EMAIL unset)", func(ctx context.Context) (string, error) {
+			if err := shared.jira.Ping(ctx); err != nil {
+				return "", err
+			}
+			return "token accepted", nil
+		})
+	}
+
+	if shared.pagerduty != nil {
+		test.run("PagerDuty", "check PAGERDUTY_API_TOKEN is a REST API key (not an integration key)", func(ctx context.Context) (string, error) {
+			if err := shared.pagerduty.Ping(ctx); err != nil {
+				return "", err
+			}
+			return "token accepted", nil
+		})
+	}
+
+	if shared.prometheus != nil {
+		test.run("Prometheus", "check PROMETHEUS_URL is the server's base URL (without /api/v1) and PROMETHEUS_BEARER_TOKEN if it sits behind a proxy", func(ctx context.Context) (string, error) {
+			if err := shared.prometheus.Ping(ctx); err != nil {
+				return "", err
+			}
+			return "answered a query", nil
+		})
+	}
+
+	if shared.kube != nil {
+		test.run("Kubernetes", "check KUBECONFIG (or the pod's service account) and that it may list pods, events and deployments in every namespace in KUBE_NAMESPACES", func(ctx context.Context) (string, error) {
+			if err := shared.kube.Ping(ctx); err != nil {
+				return "", err
+			}
+			return "can list pods in " + strings.Join(shared.kube.Namespaces(), ", "), nil
+		})
+	}
+
+	if shared.wiki != nil {
+		test.run("Wiki", "check WIKI_API_TOKEN, and WIKI_URL and WIKI_EMAIL for Confluence", func(ctx context.Context) (string, error) {
+			if err := shared.wiki.Ping(ctx); err != nil {
+				return "", err
+			}
+			return "token accepted", nil
+		})
+	}
+	if shared.renderer != nil {
+		test.run("Diagram renderer", "check KROKI_URL is the base URL of a running Kroki server", func(ctx context.Context) (string, error) {
+			if err := shared.renderer.Ping(ctx); err != nil {
+				return "", err
+			}
+			return "healthy", nil
+		})
+	}
+
+	started := make(map[string]*mcpclient.Client, len(shared.mcpClients))
+	for _, client := range shared.mcpClients {
+		started[client.Name()] = client
+	}
+	for _, server := range mcpConfigs {
+		server := server
+		test.run("MCP server "+server.Name, fmt.Sprintf("check that %q runs inside the container and speaks MCP over stdio, or remove it from mcp_servers", server.Command), func(ctx context.Context) (string, error) {
+			client, ok := started[server.Name]
+			if !ok {
+				return "", fmt.Errorf("server did not start (see earlier MCP log lines)")
+			}
+			remote, err := client.ListTools(ctx)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d tools", len(remote)), nil
+		})
+	}
+
+	test.fatalOnFailure()
+}
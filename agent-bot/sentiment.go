@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/sentiment"
+	"agent-bot/types"
+)
+
+// sentimentBucket stores the channels that opted in to sentiment monitoring
+const sentimentBucket = "sentiment_channels"
+
+// newSentimentMonitor wires the sentiment monitor to the decision LLM and moderator DMs
+func (b *Bot) newSentimentMonitor(decisionLLM types.LLM) *sentiment.Monitor {
+	classify := func(transcript string) (sentiment.Assessment, error) {
+		response, err := decisionLLM.Prompt(context.Background(), sentiment.ClassificationPrompt(transcript))
+		if err != nil {
+			return sentiment.Assessment{}, err
+		}
+		return sentiment.ParseAssessment(response)
+	}
+	return sentiment.NewMonitor(sentiment.DefaultConfig(), classify, b.alertModerators)
+}
+
+func (b *Bot) sentimentEnabled(channelID string) bool {
+	var enabled bool
+	found, err := b.store.Get(sentimentBucket, channelID, &enabled)
+	return err == nil && found && enabled
+}
+
+// observeSentiment feeds messages from opted-in channels into the monitor
+func (b *Bot) observeSentiment(message types.PostedMessage) {
+	if message.IsDM || !b.sentimentEnabled(message.ChannelId) {
+		return
+	}
+
+	speaker := "User"
+	if user, err := b.chat.GetUser(message.UserId); err == nil {
+		speaker = user.Username
+	}
+	b.sentiment.Observe(message.ChannelId, speaker, message.Message)
+}
+
+// channelModerators returns the channel admins, falling back to the bot admins
+func (b *Bot) channelModerators(channelID string) []string {
+	var moderators []string
+	for page := 0; page < 10; page++ {
+		members, _, err := b.client.GetChannelMembers(channelID, page, 200, "")
+		if err != nil {
+			log.Printf("[%s] SENTIMENT: Failed to list members of %s: %v", time.Now().Format("2006-01-02 15:04:05"), channelID, err)
+			break
+		}
+		for _, member := range members {
+			if member.SchemeAdmin && member.UserId != b.config.BotUserID {
+				moderators = append(moderators, member.UserId)
+			}
+		}
+		if len(members) < 200 {
+			break
+		}
+	}
+
+	if len(moderators) == 0 {
+		return b.config.AdminUserIDs
+	}
+	return moderators
+}
+
+// alertModerators privately tells channel moderators about a sustained negative conversation
+func (b *Bot) alertModerators(channelID string, assessment sentiment.Assessment) {
+	channelName := channelID
+	if channel, _, err := b.client.GetChannel(channelID, ""); err == nil {
+		channelName = "~" + channel.Name
+	}
+
+	alert := fmt.Sprintf("**Heads up:** the conversation in %s has been tense for a while (tone score %d/10).\n\n> %s\n\n_This is a private note to channel moderators; nothing was posted in the channel._",
+		channelName, assessment.Score, strings.TrimSpace(assessment.Summary))
+
+	moderators := b.channelModerators(channelID)
+	for _, userID := range moderators {
+		if err := b.sendDirectMessage(userID, alert); err != nil {
+			log.Printf("[%s] SENTIMENT: Failed to alert moderator %s: %v", time.Now().Format("2006-01-02 15:04:05"), userID, err)
+		}
+	}
+
+	log.Printf("[%s] SENTIMENT: Alerted %d moderators about channel %s", time.Now().Format("2006-01-02 15:04:05"), len(moderators), channelID)
+}
+
+// handleSentimentCommand implements "!sentiment on|off|list [channel_id]"
+func (b *Bot) handleSentimentCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!sentiment on <channel_id>`, `!sentiment off <channel_id>`, `!sentiment list`"
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		var channels []string
+		for _, channelID := range b.store.Keys(sentimentBucket) {
+			if b.sentimentEnabled(channelID) {
+				channels = append(channels, "`"+channelID+"`")
+			}
+		}
+		if len(channels) == 0 {
+			return "Sentiment monitoring is not enabled in any channel."
+		}
+		return "Sentiment monitoring is enabled in: " + strings.Join(channels, ", ")
+
+	case "on":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := b.store.Put(sentimentBucket, args[1], true); err != nil {
+			return fmt.Sprintf("Failed to enable sentiment monitoring: %v", err)
+		}
+		return fmt.Sprintf("Sentiment monitoring enabled for `%s`. Moderators will be alerted privately about sustained tension.", args[1])
+
+	case "off":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := b.store.Delete(sentimentBucket, args[1]); err != nil {
+			return fmt.Sprintf("Failed to disable sentiment monitoring: %v", err)
+		}
+		b.sentiment.Forget(args[1])
+		return fmt.Sprintf("Sentiment monitoring disabled for `%s`.", args[1])
+	}
+
+	return usage
+}
@@ -0,0 +1,174 @@
+// Package sentiment watches opted-in channels for sustained negative tone.
+// A Monitor keeps a sliding window of messages per channel, asks a
+// Classifier about it and raises an alert for the channel's moderators.
+package sentiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Assessment is the classifier's view of a window of conversation
+type Assessment struct {
+	// Score is 0 (calm) to 10 (hostile)
+	Score    int    `json:"score"`
+	Conflict bool   `json:"conflict"`
+	Summary  string `json:"summary"`
+}
+
+// Classifier rates the tone of a conversation transcript
+type Classifier func(transcript string) (Assessment, error)
+
+// Alerter privately notifies the people responsible for a channel
+type Alerter func(channelID string, assessment Assessment)
+
+// Config tunes how sensitive the monitor is
+type Config struct {
+	WindowSize int           // messages kept per channel
+	EvalEvery  int           // new messages between evaluations
+	Threshold  int           // score at or above which a window counts as negative
+	Sustained  int           // consecutive negative evaluations before alerting
+	Cooldown   time.Duration // minimum time between alerts for a channel
+}
+
+// DefaultConfig returns conservative defaults
+func DefaultConfig() Config {
+	return Config{
+		WindowSize: 20,
+		EvalEvery:  5,
+		Threshold:  7,
+		Sustained:  2,
+		Cooldown:   time.Hour,
+	}
+}
+
+type channelState struct {
+	window     []string
+	sinceEval  int
+	negatives  int
+	lastAlert  time.Time
+	evaluating bool
+}
+
+// Monitor keeps a sliding window of messages per opted-in channel and
+// escalates sustained negativity to an Alerter
+type Monitor struct {
+	config   Config
+	classify Classifier
+	alert    Alerter
+
+	mu       sync.Mutex
+	channels map[string]*channelState
+}
+
+func NewMonitor(config Config, classify Classifier, alert Alerter) *Monitor {
+	return &Monitor{
+		config:   config,
+		classify: classify,
+		alert:    alert,
+		channels: make(map[string]*channelState),
+	}
+}
+
+// Observe adds a message to the channel window and evaluates it in the
+// background every EvalEvery messages
+func (m *Monitor) Observe(channelID, speaker, text string) {
+	m.mu.Lock()
+	state, ok := m.channels[channelID]
+	if !ok {
+		state = &channelState{}
+		m.channels[channelID] = state
+	}
+
+	state.window = append(state.window, fmt.Sprintf("%s: %s", speaker, text))
+	if len(state.window) > m.config.WindowSize {
+		state.window = state.window[len(state.window)-m.config.WindowSize:]
+	}
+	state.sinceEval++
+
+	if state.sinceEval < m.config.EvalEvery || state.evaluating {
+		m.mu.Unlock()
+		return
+	}
+	state.sinceEval = 0
+	state.evaluating = true
+	transcript := strings.Join(state.window, "\n")
+	m.mu.Unlock()
+
+	go m.evaluate(channelID, transcript)
+}
+
+// Forget drops all state for a channel, e.g. when monitoring is turned off
+func (m *Monitor) Forget(channelID string) {
+	m.mu.Lock()
+	delete(m.channels, channelID)
+	m.mu.Unlock()
+}
+
+func (m *Monitor) evaluate(channelID, transcript string) {
+	assessment, err := m.classify(transcript)
+
+	m.mu.Lock()
+	state, ok := m.channels[channelID]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	state.evaluating = false
+
+	if err != nil {
+		m.mu.Unlock()
+		log.Printf("[%s] SENTIMENT: Classification failed for channel %s: %v", time.Now().Format("2006-01-02 15:04:05"), channelID, err)
+		return
+	}
+
+	negative := assessment.Score >= m.config.Threshold || assessment.Conflict
+	if negative {
+		state.negatives++
+	} else {
+		state.negatives = 0
+	}
+
+	log.Printf("[%s] SENTIMENT: Channel %s scored %d (conflict: %v, streak: %d)", time.Now().Format("2006-01-02 15:04:05"), channelID, assessment.Score, assessment.Conflict, state.negatives)
+
+	shouldAlert := state.negatives >= m.config.Sustained && time.Since(state.lastAlert) >= m.config.Cooldown
+	if shouldAlert {
+		state.lastAlert = time.Now()
+		state.negatives = 0
+	}
+	m.mu.Unlock()
+
+	if shouldAlert {
+		m.alert(channelID, assessment)
+	}
+}
+
+// ClassificationPrompt builds the decision-LLM prompt for a transcript
+func ClassificationPrompt(transcript string) string {
+	return fmt.Sprintf(`Assess the overall tone of this recent chat conversation.
+
+Conversation:
+%s
+
+Respond with ONLY a JSON object of the form:
+{"score": <0-10, 0 = calm and friendly, 10 = hostile>, "conflict": <true if people are in an escalating disagreement>, "summary": "<one or two neutral sentences describing what the disagreement is about, without quoting or blaming anyone>"}`, transcript)
+}
+
+// ParseAssessment extracts the JSON assessment from an LLM response
+func ParseAssessment(response string) (Assessment, error) {
+	var assessment Assessment
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start < 0 || end <= start {
+		return assessment, fmt.Errorf("no JSON object in response: %q", response)
+	}
+
+	if err := json.Unmarshal([]byte(response[start:end+1]), &assessment); err != nil {
+		return assessment, fmt.Errorf("failed to parse assessment: %w", err)
+	}
+	return assessment, nil
+}
@@ -0,0 +1,14 @@
+//go:build !mattermostplugin
+
+package main
+
+import "errors"
+
+func main() {
+	runServer()
+}
+
+// pluginPlatform is only available in the plugin build
+func pluginPlatform() (chatPlatform, error) {
+	return nil, errors.New("CHAT_PLATFORM=mattermost-plugin only works inside Mattermost; install the bundle from build-plugin.sh instead")
+}
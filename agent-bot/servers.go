@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"agent-bot/llms"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// serverNamePattern keeps profile names usable in URL paths and file names
+var serverNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ServerProfile is an additional Mattermost workspace served by the same
+// process. Each profile gets its own websocket connection, agent, state and
+// HTTP routes under /servers/<name>/. The environment configures the primary
+// workspace; everything not set here is inherited from it.
+type ServerProfile struct {
+	Name           string   `yaml:"name"`
+	ServerURL      string   `yaml:"server_url"`
+	AccessToken    string   `yaml:"access_token"`
+	BotDisplayName string   `yaml:"bot_display_name"`
+	AdminUserIDs   []string `yaml:"admin_user_ids"`
+
+	// TeamIDs limits channel messages to these teams; empty serves every team
+	TeamIDs []string `yaml:"team_ids"`
+	// IgnoreDirectMessages leaves DMs to another profile sharing the bot account
+	IgnoreDirectMessages bool `yaml:"ignore_direct_messages"`
+	// EventSource and WebhookTokens override EVENT_SOURCE and
+	// MATTERMOST_WEBHOOK_TOKENS for this workspace
+	EventSource   string   `yaml:"event_source"`
+	WebhookTokens []string `yaml:"webhook_tokens"`
+
+	// ConfigFile holds this workspace's response_templates, channel_styles, notification_rules, hooks, asana_notifications and standups
+	ConfigFile         string `yaml:"config_file"`
+	StateFile          string `yaml:"state_file"`
+	KnowledgeIndexFile string `yaml:"knowledge_index_file"`
+	AuditLogFile       string `yaml:"audit_log_file"`
+	UsageExportChannel string `yaml:"usage_export_channel_id"`
+}
+
+// validateServerProfiles checks that every profile can be connected and routed
+func validateServerProfiles(profiles []ServerProfile) error {
+	seen := make(map[string]bool)
+	for i, profile := range profiles {
+		if !serverNamePattern.MatchString(profile.Name) {
+			return fmt.Errorf("servers[%d]: name %q must be lowercase letters, digits, '-' or '_'", i, profile.Name)
+		}
+		if seen[profile.Name] {
+			return fmt.Errorf("servers[%d]: duplicate name %q", i, profile.Name)
+		}
+		seen[profile.Name] = true
+
+		if profile.ServerURL == "" || os.ExpandEnv(profile.AccessToken) == "" {
+			return fmt.Errorf("server %s: server_url and access_token are required", profile.Name)
+		}
+	}
+	return nil
+}
+
+// profileConfig derives a workspace's configuration from the primary one.
+// Files default to a directory named after the profile next to the primary's,
+// so workspaces never share state.
+func profileConfig(base Config, profile ServerProfile) Config {
+	config := base
+	config.Name = profile.Name
+	config.ServerURL = profile.ServerURL
+	config.AccessToken = os.ExpandEnv(profile.AccessToken)
+	config.BotUserID = ""
+	config.BotUsername = ""
+	config.BotDisplayName = profile.BotDisplayName
+	config.AdminUserIDs = profile.AdminUserIDs
+	config.TeamIDs = profile.TeamIDs
+	config.IgnoreDirectMessages = profile.IgnoreDirectMessages
+	if profile.EventSource != "" {
+		config.EventSource = strings.ToLower(profile.EventSource)
+	}
+	config.WebhookTokens = nil
+	for _, token := range profile.WebhookTokens {
+		config.WebhookTokens = append(config.WebhookTokens, os.ExpandEnv(token))
+	}
+	config.ConfigFile = profile.ConfigFile
+	config.UsageExportChannel = profile.UsageExportChannel
+
+	config.StateFile = profileFile(profile.StateFile, base.StateFile, profile.Name, "state.json")
+	config.KnowledgeIndexFile = profileFile(profile.KnowledgeIndexFile, base.KnowledgeIndexFile, profile.Name, "knowledge.json")
+	config.AuditLogFile = profile.AuditLogFile
+	if config.AuditLogFile == "" && base.AuditLogFile != "" {
+		config.AuditLogFile = profileFile("", base.AuditLogFile, profile.Name, "audit.jsonl")
+	}
+
+	// Canary shadowing is configured for the primary workspace only
+	config.CanaryURL = ""
+	config.CanaryMode = false
+	return config
+}
+
+func profileFile(configured, primary, name, file string) string {
+	if configured != "" {
+		return configured
+	}
+	return filepath.Join(filepath.Dir(primary), name, file)
+}
+
+// loadProfileFileConfig reads a workspace's own config file. Process-wide
+// settings (mcp_servers, model_prices, tool_timeouts) always come from the
+// main config file.
+func loadProfileFileConfig(main *FileConfig, path string) (*FileConfig, error) {
+	fileConfig, err := loadFileConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	fileConfig.MCPServers = main.MCPServers
+	fileConfig.ToolTimeouts = main.ToolTimeouts
+	if fileConfig.ModelPrices == nil {
+		fileConfig.ModelPrices = main.ModelPrices
+	}
+	fileConfig.Servers = nil
+	return fileConfig, nil
+}
+
+// servesPost reports whether a post in teamID's channelID belongs to this
+// workspace, so profiles sharing one server can split it by team. Without
+// a team ID, the channel's team is looked up.
+func (b *Bot) servesPost(teamID, channelID, channelType string) bool {
+	if channelType == "D" || channelType == "G" {
+		return !b.config.IgnoreDirectMessages
+	}
+	if len(b.config.TeamIDs) == 0 {
+		return true
+	}
+
+	if teamID == "" {
+		teamID = b.teams.resolve(channelID)
+	}
+	return slices.Contains(b.config.TeamIDs, teamID)
+}
+
+// eventTeamID is the team a websocket event was broadcast to, if any
+func eventTeamID(event *model.WebSocketEvent) string {
+	teamID, _ := event.GetData()["team_id"].(string)
+	return teamID
+}
+
+// profileName names the workspace in logs and health output
+func (b *Bot) profileName() string {
+	return workspaceName(b.config)
+}
+
+// workspaceName is the profile name of a workspace's configuration
+func workspaceName(config Config) string {
+	if config.Name == "" {
+		return "default"
+	}
+	return config.Name
+}
+
+// healthStatus is the workspace's line in /health
+func (b *Bot) healthStatus() string {
+	if b.standby.Load() {
+		return "OK (standby)"
+	}
+	if b.config.CanaryMode {
+		return "OK (canary)"
+	}
+	if b.worker != nil && !b.worker.Healthy() {
+		return "Redis Unreachable"
+	}
+	if b.worker == nil && !b.eventsConnected() {
+		return "WebSocket Disconnected"
+	}
+	if status := b.llmHealth(); status != "" {
+		return status
+	}
+	if b.worker != nil {
+		return fmt.Sprintf("OK (worker, consuming %d of %d partitions)", len(b.worker.Owned()), b.queue.Partitions())
+	}
+	return "OK"
+}
+
+// llmHealth describes LLM circuit breakers that aren't closed, or returns ""
+// when both are. Without the decision LLM the bot still answers, using
+// heuristics to decide when.
+func (b *Bot) llmHealth() string {
+	if b.llmBreaker != nil {
+		switch state, retryIn := b.llmBreaker.Status(); state {
+		case llms.BreakerOpen:
+			return fmt.Sprintf("LLM Unavailable (circuit open, retrying in %v)", retryIn.Round(time.Second))
+		case llms.BreakerHalfOpen:
+			return "LLM Unavailable (circuit half-open, probing)"
+		}
+	}
+	if b.decisionBreaker != nil {
+		if state, _ := b.decisionBreaker.Status(); state != llms.BreakerClosed {
+			return fmt.Sprintf("OK (decision LLM circuit %s, using heuristics)", state)
+		}
+	}
+	return ""
+}
+
+// handleHealth serves /health for every workspace. A single workspace keeps
+// the plain "OK" response; with several, each one gets a line when any of
+// them is unhealthy.
+func handleHealth(bots []*Bot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[%s] HEALTH: Health check requested", time.Now().Format("2006-01-02 15:04:05"))
+		if len(bots) == 1 {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(bots[0].healthStatus()))
+			return
+		}
+
+		healthy := true
+		lines := make([]string, 0, len(bots))
+		for _, bot := range bots {
+			status := bot.healthStatus()
+			if !strings.HasPrefix(status, "OK") {
+				healthy = false
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", bot.profileName(), status))
+		}
+		w.WriteHeader(http.StatusOK)
+		if healthy {
+			w.Write([]byte("OK"))
+			return
+		}
+		w.Write([]byte(strings.Join(lines, "\n")))
+	}
+}
@@ -0,0 +1,420 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/types"
+
+	slackapi "github.com/slack-go/slack"
+)
+
+// Image limits match the Mattermost adapter
+const (
+	maxImageAttachments = 5
+	maxImageBytes       = 5 * 1024 * 1024
+)
+
+var supportedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Audio limits match the Mattermost adapter
+const (
+	maxAudioAttachments = 3
+	maxAudioBytes       = 25 * 1024 * 1024
+)
+
+// requestTimeout bounds each Web API call made on behalf of the agent
+const requestTimeout = 30 * time.Second
+
+// PostMessage posts to a channel, or into a thread when ThreadId is set
+func (c *Client) PostMessage(message types.ChatMessage) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	options := []slackapi.MsgOption{slackapi.MsgOptionText(ToMrkdwn(message.Message), false)}
+	if message.ThreadId != "" {
+		_, threadTS, err := SplitMessageID(message.ThreadId)
+		if err != nil {
+			return "", err
+		}
+		options = append(options, slackapi.MsgOptionTS(threadTS))
+	}
+	if len(message.Attachments) > 0 {
+		options = append(options, slackapi.MsgOptionAttachments(attachments(message.Attachments)...))
+	}
+
+	var ts string
+	err := withRetry(ctx, func() error {
+		var err error
+		_, ts, err = c.api.PostMessageContext(ctx, message.ChannelId, options...)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to post message: %w", err)
+	}
+	return MessageID(message.ChannelId, ts), nil
+}
+
+// attachments converts cards to Slack's legacy attachments, which keep
+// fields, colors and link buttons in one place
+func attachments(cards []types.Attachment) []slackapi.Attachment {
+	converted := make([]slackapi.Attachment, 0, len(cards))
+	for _, card := range cards {
+		attachment := slackapi.Attachment{
+			Fallback:   card.Fallback,
+			Color:      card.Color,
+			Pretext:    ToMrkdwn(card.Pretext),
+			AuthorName: card.AuthorName,
+			AuthorLink: card.AuthorLink,
+			AuthorIcon: card.AuthorIcon,
+			Title:      card.Title,
+			TitleLink:  card.TitleLink,
+			Text:       ToMrkdwn(card.Text),
+			Footer:     card.Footer,
+			MarkdownIn: []string{"pretext", "text", "fields"},
+		}
+		for _, field := range card.Fields {
+			attachment.Fields = append(attachment.Fields, slackapi.AttachmentField{Title: field.Title, Value: ToMrkdwn(field.Value), Short: field.Short})
+		}
+		for i, button := range card.Buttons {
+			attachment.Actions = append(attachment.Actions, slackapi.AttachmentAction{
+				Name: fmt.Sprintf("link-%d", i),
+				Text: button.Label,
+				Type: slackapi.ActionType("button"),
+				URL:  button.URL,
+			})
+		}
+		converted = append(converted, attachment)
+	}
+	return converted
+}
+
+// UpdateMessage replaces the text of one of the bot's messages
+func (c *Client) UpdateMessage(messageID string, newContent string) error {
+	channelID, ts, err := SplitMessageID(messageID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	err = withRetry(ctx, func() error {
+		_, _, _, err := c.api.UpdateMessageContext(ctx, channelID, ts, slackapi.MsgOptionText(ToMrkdwn(newContent), false))
+		return err
+	})
+	var apiErr slackapi.SlackErrorResponse
+	if errors.As(err, &apiErr) && apiErr.Err == "message_not_found" {
+		return types.ErrMessageDeleted
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update message: %w", err)
+	}
+	return nil
+}
+
+// PostEphemeral shows a message to one user, in the thread when ThreadId is set
+func (c *Client) PostEphemeral(userID string, message types.ChatMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	options := []slackapi.MsgOption{slackapi.MsgOptionText(ToMrkdwn(message.Message), false)}
+	if message.ThreadId != "" {
+		_, threadTS, err := SplitMessageID(message.ThreadId)
+		if err != nil {
+			return err
+		}
+		options = append(options, slackapi.MsgOptionTS(threadTS))
+	}
+
+	err := withRetry(ctx, func() error {
+		_, err := c.api.PostEphemeralContext(ctx, message.ChannelId, userID, options...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to post ephemeral message: %w", err)
+	}
+	return nil
+}
+
+// UploadFile shares a file in the channel, or in the thread when ThreadId is set
+func (c *Client) UploadFile(upload types.FileUpload) error {
+	params := slackapi.UploadFileV2Parameters{
+		Channel:        upload.ChannelId,
+		Filename:       upload.Filename,
+		Title:          upload.Filename,
+		Reader:         bytes.NewReader(upload.Data),
+		FileSize:       len(upload.Data),
+		InitialComment: ToMrkdwn(upload.Message),
+	}
+	if upload.ThreadId != "" {
+		_, threadTS, err := SplitMessageID(upload.ThreadId)
+		if err != nil {
+			return err
+		}
+		params.ThreadTimestamp = threadTS
+	}
+
+	// Files are uploaded in several calls, so this gets longer than requestTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), 2*requestTimeout)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		params.Reader = bytes.NewReader(upload.Data)
+		_, err := c.api.UploadFileV2Context(ctx, params)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", upload.Filename, err)
+	}
+	return nil
+}
+
+// AddReaction reacts to a message
+func (c *Client) AddReaction(messageID, emoji string) error {
+	return c.react(messageID, emoji, c.api.AddReactionContext)
+}
+
+// RemoveReaction removes the bot's reaction from a message
+func (c *Client) RemoveReaction(messageID, emoji string) error {
+	return c.react(messageID, emoji, c.api.RemoveReactionContext)
+}
+
+func (c *Client) react(messageID, emoji string, call func(context.Context, string, slackapi.ItemRef) error) error {
+	channelID, ts, err := SplitMessageID(messageID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	err = withRetry(ctx, func() error {
+		return call(ctx, emoji, slackapi.NewRefToMessage(channelID, ts))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update reaction: %w", err)
+	}
+	return nil
+}
+
+// SendTypingIndicator is a no-op: Slack has no typing indicator for apps
+func (c *Client) SendTypingIndicator(channelID, threadID string) error {
+	return nil
+}
+
+// GetMessage fetches a single message
+func (c *Client) GetMessage(messageID string) (*types.Message, error) {
+	channelID, ts, err := SplitMessageID(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var messages []slackapi.Message
+	err = withRetry(ctx, func() error {
+		var err error
+		messages, _, _, err = c.api.GetConversationRepliesContext(ctx, &slackapi.GetConversationRepliesParameters{
+			ChannelID: channelID,
+			Timestamp: ts,
+			Latest:    ts,
+			Inclusive: true,
+			Limit:     1,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	for _, msg := range messages {
+		if msg.Timestamp == ts {
+			return c.toMessage(channelID, msg), nil
+		}
+	}
+	return nil, fmt.Errorf("message %s not found", messageID)
+}
+
+// GetThreadMessages returns the root message and every reply, oldest first
+func (c *Client) GetThreadMessages(threadID string) ([]*types.Message, error) {
+	channelID, ts, err := SplitMessageID(threadID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var result []*types.Message
+	cursor := ""
+	for {
+		var messages []slackapi.Message
+		var hasMore bool
+		var next string
+		err := withRetry(ctx, func() error {
+			var err error
+			messages, hasMore, next, err = c.api.GetConversationRepliesContext(ctx, &slackapi.GetConversationRepliesParameters{
+				ChannelID: channelID,
+				Timestamp: ts,
+				Cursor:    cursor,
+				Limit:     200,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get thread: %w", err)
+		}
+		for _, msg := range messages {
+			result = append(result, c.toMessage(channelID, msg))
+		}
+		if !hasMore || next == "" {
+			return result, nil
+		}
+		cursor = next
+	}
+}
+
+// GetUser looks up a user by ID
+func (c *Client) GetUser(userID string) (*types.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var user *slackapi.User
+	err := withRetry(ctx, func() error {
+		var err error
+		user, err = c.api.GetUserInfoContext(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &types.User{ID: user.ID, Username: user.Name, IsBot: user.IsBot}, nil
+}
+
+// GetChannel looks up a channel's name, purpose and topic
+func (c *Client) GetChannel(channelID string) (*types.Channel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var channel *slackapi.Channel
+	err := withRetry(ctx, func() error {
+		var err error
+		channel, err = c.api.GetConversationInfoContext(ctx, &slackapi.GetConversationInfoInput{ChannelID: channelID})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel: %w", err)
+	}
+	return &types.Channel{
+		ID:          channel.ID,
+		Name:        channel.Name,
+		DisplayName: channel.Name,
+		Purpose:     channel.Purpose.Value,
+		Header:      channel.Topic.Value,
+		Private:     channel.IsPrivate || channel.IsIM || channel.IsMpIM,
+	}, nil
+}
+
+// GetImages downloads the image files among fileIDs
+func (c *Client) GetImages(fileIDs []string) ([]types.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var images []types.Image
+	for _, fileID := range fileIDs {
+		if len(images) >= maxImageAttachments {
+			log.Printf("[%s] IMAGES: Skipping attachments beyond the first %d images", time.Now().Format("2006-01-02 15:04:05"), maxImageAttachments)
+			break
+		}
+
+		var file *slackapi.File
+		err := withRetry(ctx, func() error {
+			var err error
+			file, _, _, err = c.api.GetFileInfoContext(ctx, fileID, 0, 0)
+			return err
+		})
+		if err != nil {
+			return images, fmt.Errorf("failed to get file info for %s: %w", fileID, err)
+		}
+		if !supportedImageTypes[file.Mimetype] {
+			continue
+		}
+		if file.Size > maxImageBytes {
+			log.Printf("[%s] IMAGES: Skipping %s, %d bytes is over the %d byte limit", time.Now().Format("2006-01-02 15:04:05"), file.Name, file.Size, maxImageBytes)
+			continue
+		}
+
+		var data bytes.Buffer
+		if err := c.api.GetFileContext(ctx, file.URLPrivateDownload, &data); err != nil {
+			return images, fmt.Errorf("failed to download %s: %w", file.Name, err)
+		}
+		images = append(images, types.Image{Name: file.Name, MediaType: file.Mimetype, Data: data.Bytes()})
+	}
+	return images, nil
+}
+
+// GetAudio downloads the audio clips and audio files among fileIDs
+func (c *Client) GetAudio(fileIDs []string) ([]types.Audio, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var audio []types.Audio
+	for _, fileID := range fileIDs {
+		if len(audio) >= maxAudioAttachments {
+			log.Printf("[%s] TRANSCRIBE: Skipping attachments beyond the first %d audio files", time.Now().Format("2006-01-02 15:04:05"), maxAudioAttachments)
+			break
+		}
+
+		var file *slackapi.File
+		err := withRetry(ctx, func() error {
+			var err error
+			file, _, _, err = c.api.GetFileInfoContext(ctx, fileID, 0, 0)
+			return err
+		})
+		if err != nil {
+			return audio, fmt.Errorf("failed to get file info for %s: %w", fileID, err)
+		}
+		if !strings.HasPrefix(file.Mimetype, "audio/") {
+			continue
+		}
+		if file.Size > maxAudioBytes {
+			log.Printf("[%s] TRANSCRIBE: Skipping %s, %d bytes is over the %d byte limit", time.Now().Format("2006-01-02 15:04:05"), file.Name, file.Size, maxAudioBytes)
+			continue
+		}
+
+		var data bytes.Buffer
+		if err := c.api.GetFileContext(ctx, file.URLPrivateDownload, &data); err != nil {
+			return audio, fmt.Errorf("failed to download %s: %w", file.Name, err)
+		}
+		audio = append(audio, types.Audio{Name: file.Name, MediaType: file.Mimetype, Data: data.Bytes()})
+	}
+	return audio, nil
+}
+
+func (c *Client) toMessage(channelID string, msg slackapi.Message) *types.Message {
+	message := &types.Message{
+		ID:        MessageID(channelID, msg.Timestamp),
+		UserID:    msg.User,
+		ChannelID: channelID,
+		Content:   c.fromSlack(msg.Text),
+		Timestamp: timestampMillis(msg.Timestamp),
+	}
+	if msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp {
+		message.ThreadID = MessageID(channelID, msg.ThreadTimestamp)
+	}
+	if message.UserID == "" && msg.BotID == c.BotID {
+		message.UserID = c.BotUserID
+	}
+	return message
+}
@@ -0,0 +1,117 @@
+// Package slack connects the agent core to a Slack workspace. Events arrive
+// over Socket Mode, so no public endpoint is needed, and replies go through
+// the Web API.
+package slack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	slackapi "github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// maxRateLimitRetries bounds how often one Web API call waits out a 429
+const maxRateLimitRetries = 3
+
+// Client is a Slack bot connection. It implements types.Chat; because Slack
+// addresses messages by channel and timestamp, message and thread IDs have the
+// form "<channel>:<ts>".
+type Client struct {
+	api    *slackapi.Client
+	socket *socketmode.Client
+
+	// Identity of the bot, filled in by Identify
+	BotUserID   string
+	BotUsername string
+	BotID       string
+	TeamID      string
+
+	connected atomic.Bool
+}
+
+// NewClient creates a client for a bot token (xoxb-) and an app-level token
+// (xapp-) with the connections:write scope
+func NewClient(botToken, appToken string, httpClient *http.Client) *Client {
+	api := slackapi.New(botToken, slackapi.OptionAppLevelToken(appToken), slackapi.OptionHTTPClient(httpClient))
+	return &Client{
+		api:    api,
+		socket: socketmode.New(api),
+	}
+}
+
+// Identify verifies the bot token and records who the bot is
+func (c *Client) Identify(ctx context.Context) error {
+	auth, err := c.api.AuthTestContext(ctx)
+	if err != nil {
+		return fmt.Errorf("slack auth.test failed: %w", err)
+	}
+	c.BotUserID = auth.UserID
+	c.BotUsername = auth.User
+	c.BotID = auth.BotID
+	c.TeamID = auth.TeamID
+	return nil
+}
+
+// Connected reports whether the Socket Mode connection is up
+func (c *Client) Connected() bool {
+	return c.connected.Load()
+}
+
+// MessageID joins a channel and message timestamp into a message ID
+func MessageID(channelID, ts string) string {
+	return channelID + ":" + ts
+}
+
+// SplitMessageID splits a message ID into its channel and timestamp
+func SplitMessageID(messageID string) (channelID, ts string, err error) {
+	channelID, ts, ok := strings.Cut(messageID, ":")
+	if !ok || channelID == "" || ts == "" {
+		return "", "", fmt.Errorf("invalid slack message id %q", messageID)
+	}
+	return channelID, ts, nil
+}
+
+// timestampMillis converts a Slack ts ("1700000000.000100") to Unix milliseconds
+func timestampMillis(ts string) int64 {
+	seconds, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(seconds * 1000)
+}
+
+// withRetry runs a Web API call, waiting out rate limits
+func withRetry(ctx context.Context, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		var limited *slackapi.RateLimitedError
+		if !errors.As(err, &limited) || attempt >= maxRateLimitRetries {
+			return err
+		}
+
+		log.Printf("[%s] SLACK: Rate limited, retrying in %v", time.Now().Format("2006-01-02 15:04:05"), limited.RetryAfter)
+		select {
+		case <-time.After(limited.RetryAfter):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Identity returns the bot's user ID and username
+func (c *Client) Identity() (userID, username string) {
+	return c.BotUserID, c.BotUsername
+}
+
+// TeamOf returns the workspace a channel belongs to; a bot token is bound to one
+func (c *Client) TeamOf(channelID string) string {
+	return c.TeamID
+}
@@ -0,0 +1,133 @@
+package slack
+
+import (
+	"context"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/types"
+
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Listen connects over Socket Mode and delivers message events to agent
+// until ctx is cancelled. Reconnection is handled by the Socket Mode client.
+func (c *Client) Listen(ctx context.Context, agent types.Agent) error {
+	go func() {
+		for {
+			select {
+			case event := <-c.socket.Events:
+				c.handleEvent(event, agent)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c.socket.RunContext(ctx)
+}
+
+func (c *Client) handleEvent(event socketmode.Event, agent types.Agent) {
+	switch event.Type {
+	case socketmode.EventTypeConnecting:
+		log.Printf("[%s] SLACK: Connecting to Socket Mode...", time.Now().Format("2006-01-02 15:04:05"))
+	case socketmode.EventTypeConnected:
+		c.connected.Store(true)
+		log.Printf("[%s] SLACK: Connected as @%s (%s) in team %s", time.Now().Format("2006-01-02 15:04:05"), c.BotUsername, c.BotUserID, c.TeamID)
+	case socketmode.EventTypeConnectionError, socketmode.EventTypeDisconnect:
+		c.connected.Store(false)
+		log.Printf("[%s] SLACK: Connection lost, reconnecting: %v", time.Now().Format("2006-01-02 15:04:05"), event.Data)
+	case socketmode.EventTypeInvalidAuth:
+		c.connected.Store(false)
+		log.Printf("[%s] SLACK: SLACK_APP_TOKEN was rejected", time.Now().Format("2006-01-02 15:04:05"))
+	case socketmode.EventTypeEventsAPI:
+		// Acknowledge first; Slack redelivers events not acked within 3 seconds
+		if event.Request != nil {
+			c.socket.Ack(*event.Request)
+		}
+		apiEvent, ok := event.Data.(slackevents.EventsAPIEvent)
+		if !ok || apiEvent.Type != slackevents.CallbackEvent {
+			return
+		}
+		switch inner := apiEvent.InnerEvent.Data.(type) {
+		case *slackevents.MessageEvent:
+			c.handleMessage(inner, agent)
+		case *slackevents.ReactionAddedEvent:
+			c.handleReaction(inner, agent)
+		case *slackevents.MemberJoinedChannelEvent:
+			if inner.User == c.BotUserID {
+				agent.ChannelJoined(inner.Channel)
+			}
+		}
+	}
+}
+
+func (c *Client) handleReaction(event *slackevents.ReactionAddedEvent, agent types.Agent) {
+	if event.Item.Type != "message" || event.User == c.BotUserID {
+		return
+	}
+	// Skin tones arrive as "thumbsup::skin-tone-2"
+	name, _, _ := strings.Cut(event.Reaction, "::")
+	agent.ReactionAdded(types.Reaction{
+		PostId:    MessageID(event.Item.Channel, event.Item.Timestamp),
+		UserId:    event.User,
+		ChannelId: event.Item.Channel,
+		Emoji:     name,
+	})
+}
+
+func (c *Client) handleMessage(event *slackevents.MessageEvent, agent types.Agent) {
+	switch event.SubType {
+	case "", "file_share", "thread_broadcast":
+	case "message_changed":
+		if event.Message != nil && c.isOwn(event.Message.User, event.Message.BotID) {
+			agent.MessageEdited(MessageID(event.Channel, event.Message.Timestamp), c.fromSlack(event.Message.Text))
+		}
+		return
+	case "message_deleted":
+		if event.PreviousMessage != nil && c.isOwn(event.PreviousMessage.User, event.PreviousMessage.BotID) {
+			agent.MessageDeleted(MessageID(event.Channel, event.DeletedTimeStamp))
+		}
+		return
+	default:
+		// Joins, topic changes and other system messages
+		return
+	}
+
+	// Don't respond to our own messages
+	if c.isOwn(event.User, event.BotID) {
+		return
+	}
+
+	message := types.PostedMessage{
+		PostId:    MessageID(event.Channel, event.TimeStamp),
+		UserId:    event.User,
+		ChannelId: event.Channel,
+		Message:   c.fromSlack(event.Text),
+		IsDM:      event.ChannelType == "im",
+		Mentioned: strings.Contains(event.Text, "<@"+c.BotUserID+">"),
+	}
+	if event.ThreadTimeStamp != "" && event.ThreadTimeStamp != event.TimeStamp {
+		message.ThreadId = MessageID(event.Channel, event.ThreadTimeStamp)
+	}
+	if event.Message != nil {
+		for _, file := range event.Message.Files {
+			message.FileIds = append(message.FileIds, file.ID)
+		}
+	}
+
+	agent.MessagePosted(message)
+}
+
+func (c *Client) isOwn(userID, botID string) bool {
+	return userID == c.BotUserID || (botID != "" && botID == c.BotID)
+}
+
+// fromSlack turns Slack message text into the plain form the agent expects:
+// the bot's mention becomes @username and HTML entities are decoded
+func (c *Client) fromSlack(text string) string {
+	text = strings.ReplaceAll(text, "<@"+c.BotUserID+">", "@"+c.BotUsername)
+	return html.UnescapeString(text)
+}
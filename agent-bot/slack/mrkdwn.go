@@ -0,0 +1,38 @@
+package slack
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownBold    = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	markdownLink    = regexp.MustCompile(`\[([^\]\n]+)\]\((https?://[^)\s]+)\)`)
+	markdownHeading = regexp.MustCompile(`^#{1,6}\s+(.+)$`)
+	markdownStrike  = regexp.MustCompile(`~~([^~\n]+)~~`)
+)
+
+// ToMrkdwn converts the Markdown the model writes into Slack's mrkdwn:
+// **bold** becomes *bold*, [text](url) becomes <url|text>, headings become
+// bold lines and ~~strike~~ becomes ~strike~. Code blocks are left alone.
+func ToMrkdwn(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		if match := markdownHeading.FindStringSubmatch(line); match != nil {
+			line = "**" + strings.TrimSpace(match[1]) + "**"
+		}
+		line = markdownLink.ReplaceAllString(line, "<$2|$1>")
+		line = markdownBold.ReplaceAllString(line, "*$1*")
+		line = markdownStrike.ReplaceAllString(line, "~$1~")
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"agent-bot/metrics"
+	"agent-bot/reqid"
+	"agent-bot/speech"
+	"agent-bot/store"
+	"agent-bot/types"
+)
+
+// Text-to-speech providers
+const (
+	speechOff     = "off"
+	speechOpenAI  = "openai"
+	speechCommand = "command"
+)
+
+// speechUsersBucket stores the users who turned on spoken replies with !speech
+const speechUsersBucket = "speech_users"
+
+// speechTimeout bounds rendering one reply as audio
+const speechTimeout = 2 * time.Minute
+
+// sayItPattern recognizes "say it", "read that aloud", "can you say this out
+// loud please" and similar
+var sayItPattern = regexp.MustCompile(`(?i)^(please\s+|can you\s+|could you\s+)?(say|read|speak)\s+(it|that|this)(\s+(out\s+)?loud|\s+aloud)?(\s+please)?[.!?]*$`)
+
+// Markdown that reads badly aloud
+var (
+	speechCodeBlockPattern = regexp.MustCompile("(?s)```.*?```")
+	speechLinkPattern      = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	speechURLPattern       = regexp.MustCompile(`https?://\S+`)
+	speechLinePrefix       = regexp.MustCompile(`(?m)^[ \t]*[#>]+[ \t]*`)
+	speechMarkupPattern    = regexp.MustCompile("[*_`#>|~]+")
+	speechBlankLines       = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// Synthesizer renders text as audio
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+}
+
+// newSynthesizer builds the synthesizer for TTS_PROVIDER, or nil when it is off
+func newSynthesizer(config Config) (Synthesizer, error) {
+	switch config.TTSProvider {
+	case speechOff:
+		return nil, nil
+	case speechOpenAI:
+		return speech.NewOpenAI(config.TTSAPIURL, config.TTSAPIKey, config.TTSModel, config.TTSVoice, config.TTSFormat, &http.Client{Timeout: speechTimeout}), nil
+	case speechCommand:
+		if config.TTSCommand == "" {
+			return nil, fmt.Errorf("TTS_PROVIDER=command requires TTS_COMMAND")
+		}
+		return speech.NewCommand(config.TTSCommand, config.TTSFormat)
+	default:
+		return nil, fmt.Errorf("unknown TTS_PROVIDER %q (use off, openai or command)", config.TTSProvider)
+	}
+}
+
+// replySpeech attaches audio renderings of replies for the users who want them
+type replySpeech struct {
+	synthesizer Synthesizer
+	format      string
+	store       *store.Store
+}
+
+func newReplySpeech(synthesizer Synthesizer, format string, stateStore *store.Store) *replySpeech {
+	return &replySpeech{synthesizer: synthesizer, format: format, store: stateStore}
+}
+
+// speechEnabled reports whether userID turned on spoken replies
+func speechEnabled(stateStore *store.Store, userID string) bool {
+	var enabled bool
+	found, err := stateStore.Get(speechUsersBucket, userID, &enabled)
+	return err == nil && found && enabled
+}
+
+// isSayItRequest reports whether a message addressed to the bot asks it to
+// read its last reply aloud
+func (a *BotAgent) isSayItRequest(message types.PostedMessage) bool {
+	text := strings.ReplaceAll(message.Message, "@"+a.botUsername, "")
+	return sayItPattern.MatchString(strings.TrimSpace(text))
+}
+
+// sayLastReply attaches the audio of the bot's latest reply in the thread
+func (a *BotAgent) sayLastReply(ctx context.Context, message types.PostedMessage) {
+	if message.ThreadId == "" {
+		a.postNotice(ctx, types.PostedMessage{UserId: message.UserId, ChannelId: message.ChannelId, ThreadId: message.PostId}, "Ask me from inside the thread with the reply you want to hear.")
+		return
+	}
+
+	posts, err := a.chat.GetThreadMessages(message.ThreadId)
+	if err != nil {
+		reqid.Logf(ctx, "SPEECH: Failed to load thread %s: %v", message.ThreadId, err)
+		a.postNotice(ctx, message, "Sorry, I couldn't load this thread to read my reply.")
+		return
+	}
+	var reply string
+	for _, post := range posts {
+		if post.UserID == a.botUserID && strings.TrimSpace(post.Content) != "" {
+			reply = post.Content
+		}
+	}
+	if reply == "" {
+		a.postNotice(ctx, message, "I haven't replied in this thread yet, so there's nothing to read.")
+		return
+	}
+
+	if err := a.attachSpeech(ctx, message.ChannelId, message.ThreadId, reply); err != nil {
+		reqid.Logf(ctx, "SPEECH: Failed to attach audio: %v", err)
+		a.postNotice(ctx, message, "Sorry, I couldn't turn my reply into audio.")
+	}
+}
+
+// speakReply is a reply observer that attaches the audio of each reply to
+// a user who turned spoken replies on
+func (a *BotAgent) speakReply(message types.PostedMessage, reply string) {
+	if a.speech == nil || !speechEnabled(a.speech.store, message.UserId) {
+		return
+	}
+	threadID := message.ThreadId
+	if threadID == "" {
+		threadID = message.PostId
+	}
+
+	// Rendering takes a few seconds; the text reply is already posted
+	go func() {
+		if err := a.attachSpeech(context.Background(), message.ChannelId, threadID, reply); err != nil {
+			log.Printf("[%s] SPEECH: Failed to attach audio to the reply to %s: %v", time.Now().Format("2006-01-02 15:04:05"), message.PostId, err)
+		}
+	}()
+}
+
+// attachSpeech renders text as audio and posts it in the thread
+func (a *BotAgent) attachSpeech(ctx context.Context, channelID, threadID, text string) error {
+	text = speakableText(text)
+	if text == "" {
+		return fmt.Errorf("nothing to read aloud")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, speechTimeout)
+	defer cancel()
+	started := time.Now()
+	audio, err := a.speech.synthesizer.Synthesize(ctx, text)
+	if err != nil {
+		metrics.Inc("speech_replies_total", "status", "error")
+		return err
+	}
+	metrics.Inc("speech_replies_total", "status", "ok")
+	reqid.Logf(ctx, "SPEECH: Rendered %d characters as %d bytes of audio in %v", len(text), len(audio), time.Since(started).Round(time.Millisecond))
+
+	return a.chat.UploadFile(types.FileUpload{
+		ChannelId: channelID,
+		ThreadId:  threadID,
+		Filename:  "reply." + a.speech.format,
+		Data:      audio,
+	})
+}
+
+// speakableText strips the Markdown that doesn't read well aloud, such as code
+// blocks and link targets, and cuts text to what one request can render
+func speakableText(text string) string {
+	text = speechCodeBlockPattern.ReplaceAllString(text, "(code block omitted)")
+	text = speechLinkPattern.ReplaceAllString(text, "$1")
+	text = speechURLPattern.ReplaceAllString(text, "(link)")
+	text = speechLinePrefix.ReplaceAllString(text, "")
+	text = speechMarkupPattern.ReplaceAllString(text, "")
+	text = strings.TrimSpace(speechBlankLines.ReplaceAllString(text, "\n\n"))
+	if len(text) <= speech.MaxInputChars {
+		return text
+	}
+
+	// End at the last sentence that fits
+	cut := text[:speech.MaxInputChars]
+	if end := strings.LastIndexAny(cut, ".!?"); end > speech.MaxInputChars/2 {
+		return cut[:end+1]
+	}
+	return strings.ToValidUTF8(cut, "")
+}
+
+// handleSpeechCommand implements "!speech [on|off]" for the user sending it
+func (b *Bot) handleSpeechCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!speech` to see whether your replies come with audio, `!speech on`, `!speech off`"
+	if len(args) == 0 {
+		if speechEnabled(b.store, message.UserId) {
+			return "My replies to you come with an audio version. Send `!speech off` to stop that."
+		}
+		return "My replies to you are text only. Send `!speech on` to get an audio version too, or ask me to \"say it\" in a thread."
+	}
+	if len(args) != 1 {
+		return usage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		if err := b.store.Put(speechUsersBucket, message.UserId, true); err != nil {
+			return fmt.Sprintf("Failed to turn on spoken replies: %v", err)
+		}
+		return "Done, my replies to you will come with an audio version."
+	case "off":
+		if err := b.store.Delete(speechUsersBucket, message.UserId); err != nil {
+			return fmt.Sprintf("Failed to turn off spoken replies: %v", err)
+		}
+		return "Done, my replies to you will be text only."
+	}
+	return usage
+}
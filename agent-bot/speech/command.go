@@ -0,0 +1,67 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FilePlaceholder is replaced with the output file's path in a Command's arguments
+const FilePlaceholder = "{file}"
+
+// Command synthesizes with a local program such as Piper. The text is written
+// to the program's standard input and it writes the audio to the file whose
+// path replaces {file} in the arguments.
+type Command struct {
+	Path   string
+	Args   []string
+	Format string
+}
+
+// NewCommand parses a command line like "piper --model en_US-amy-medium.onnx --output_file {file}".
+// Arguments are split on whitespace; there is no shell quoting. format is
+// the extension of the file the program writes, e.g. wav.
+func NewCommand(commandLine, format string) (*Command, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("speech command is empty")
+	}
+	if !strings.Contains(commandLine, FilePlaceholder) {
+		return nil, fmt.Errorf("speech command must contain %s where the audio file goes", FilePlaceholder)
+	}
+	return &Command{Path: fields[0], Args: fields[1:], Format: format}, nil
+}
+
+// Synthesize runs the command on text and returns the file it wrote
+func (c *Command) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	file, err := os.CreateTemp("", "agent-bot-speech-*."+c.Format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = strings.ReplaceAll(arg, FilePlaceholder, file.Name())
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", c.Path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Name(), err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s wrote no audio", c.Path)
+	}
+	return data, nil
+}
@@ -0,0 +1,88 @@
+// Package speech renders text as audio for spoken replies.
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultOpenAIURL is the base URL of the OpenAI API
+const DefaultOpenAIURL = "https://api.openai.com/v1"
+
+// MaxInputChars is the longest text the OpenAI speech API accepts
+const MaxInputChars = 4096
+
+// OpenAI synthesizes with the /audio/speech endpoint of the OpenAI API, or of
+// a local server offering the same API such as openedai-speech or Kokoro-FastAPI
+type OpenAI struct {
+	BaseURL    string
+	APIKey     string
+	Model      string
+	Voice      string
+	Format     string
+	HTTPClient *http.Client
+}
+
+func NewOpenAI(baseURL, apiKey, model, voice, format string, httpClient *http.Client) *OpenAI {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIURL
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &OpenAI{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		Model:      model,
+		Voice:      voice,
+		Format:     format,
+		HTTPClient: httpClient,
+	}
+}
+
+type openAIRequest struct {
+	Model          string `json:"model"`
+	Input          string `json:"input"`
+	Voice          string `json:"voice"`
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+// Synthesize returns the audio of text in the configured format
+func (o *OpenAI) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	body, err := json.Marshal(openAIRequest{Model: o.Model, Input: text, Voice: o.Voice, ResponseFormat: o.Format})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.BaseURL+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("speech API error %d: %s", resp.StatusCode, string(raw))
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("speech API returned no audio")
+	}
+	return raw, nil
+}
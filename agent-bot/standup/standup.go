@@ -0,0 +1,175 @@
+// Package standup describes scheduled standups and tracks each round: who
+// was asked, in which DM channel, and the updates they sent back.
+package standup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"agent-bot/scheduler"
+)
+
+// DefaultQuestions are asked when a standup does not configure its own
+var DefaultQuestions = []string{
+	"What did you get done since the last standup?",
+	"What are you working on today?",
+	"Is anything blocking you?",
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Config describes one recurring standup
+type Config struct {
+	Name      string   `yaml:"name"`
+	ChannelID string   `yaml:"channel_id"`
+	Members   []string `yaml:"members"`
+	// AskAt and PostAt are "15:04" times in DIGEST_TIMEZONE
+	AskAt     string   `yaml:"ask_at"`
+	PostAt    string   `yaml:"post_at"`
+	Days      []string `yaml:"days"`
+	Questions []string `yaml:"questions"`
+}
+
+// Validate checks a list of standups and fills in defaults
+func Validate(configs []Config) error {
+	seen := make(map[string]bool)
+	for i := range configs {
+		c := &configs[i]
+		if c.Name == "" {
+			return fmt.Errorf("standup %d has no name", i+1)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("duplicate standup name %q", c.Name)
+		}
+		seen[c.Name] = true
+		if c.ChannelID == "" || len(c.Members) == 0 {
+			return fmt.Errorf("standup %q needs a channel_id and at least one member", c.Name)
+		}
+
+		askHour, askMinute, err := scheduler.ParseTimeOfDay(c.AskAt)
+		if err != nil {
+			return fmt.Errorf("standup %q ask_at: %w", c.Name, err)
+		}
+		postHour, postMinute, err := scheduler.ParseTimeOfDay(c.PostAt)
+		if err != nil {
+			return fmt.Errorf("standup %q post_at: %w", c.Name, err)
+		}
+		if postHour*60+postMinute <= askHour*60+askMinute {
+			return fmt.Errorf("standup %q must post after it asks", c.Name)
+		}
+
+		if len(c.Days) == 0 {
+			c.Days = []string{"mon", "tue", "wed", "thu", "fri"}
+		}
+		for j, day := range c.Days {
+			day = strings.ToLower(day)
+			if len(day) > 3 {
+				day = day[:3]
+			}
+			if _, ok := weekdays[day]; !ok {
+				return fmt.Errorf("standup %q has unknown day %q", c.Name, c.Days[j])
+			}
+			c.Days[j] = day
+		}
+		if len(c.Questions) == 0 {
+			c.Questions = DefaultQuestions
+		}
+	}
+	return nil
+}
+
+// RunsOn reports whether the standup is held on t's weekday
+func (c Config) RunsOn(t time.Time) bool {
+	for _, day := range c.Days {
+		if weekdays[day] == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// Prompt is the DM sent to each member
+func (c Config) Prompt() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Time for the **%s** standup! Reply here before %s and I'll share it with the team:\n", c.Name, c.PostAt))
+	for _, question := range c.Questions {
+		sb.WriteString("- " + question + "\n")
+	}
+	return sb.String()
+}
+
+// Round is the state of one day's standup
+type Round struct {
+	Name string `json:"name"`
+	Date string `json:"date"`
+	// DMChannels maps each member to the direct channel they were asked in
+	DMChannels map[string]string `json:"dm_channels"`
+	Updates    map[string]string `json:"updates"`
+	Posted     bool              `json:"posted"`
+}
+
+// NewRound starts a round for the given date
+func NewRound(name string, date time.Time) *Round {
+	return &Round{
+		Name:       name,
+		Date:       date.Format("2006-01-02"),
+		DMChannels: make(map[string]string),
+		Updates:    make(map[string]string),
+	}
+}
+
+// Accepts reports whether a DM from userID in channelID belongs to this round
+func (r *Round) Accepts(userID, channelID string) bool {
+	return !r.Posted && r.DMChannels[userID] == channelID
+}
+
+// Record appends a reply to the member's update and reports whether it was their first
+func (r *Round) Record(userID, text string) bool {
+	existing, ok := r.Updates[userID]
+	if ok {
+		r.Updates[userID] = existing + "\n" + text
+	} else {
+		r.Updates[userID] = text
+	}
+	return !ok
+}
+
+// Missing returns the members that were asked but have not replied, sorted
+func (r *Round) Missing() []string {
+	var missing []string
+	for userID := range r.DMChannels {
+		if _, ok := r.Updates[userID]; !ok {
+			missing = append(missing, userID)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+var summaryTemplate = template.Must(template.New("standup-summary").Parse(`Compile these standup updates for the "{{.Name}}" standup on {{.Date}} into one post for the team channel.
+{{range .Updates}}
+--- @{{.Username}} ---
+{{.Text}}
+{{end}}
+Write one short section per person headed with their @username, using bullets for done, today and blockers. After that add a "Blockers" section that collects every blocker with its owner, or say there are none. Keep each person's meaning; do not invent work.`))
+
+// SummaryPrompt builds the LLM prompt that compiles the round's updates.
+// usernames maps user IDs to usernames.
+func (r *Round) SummaryPrompt(usernames map[string]string) string {
+	type update struct{ Username, Text string }
+	var updates []update
+	for userID, text := range r.Updates {
+		updates = append(updates, update{Username: usernames[userID], Text: text})
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Username < updates[j].Username })
+
+	var sb strings.Builder
+	summaryTemplate.Execute(&sb, map[string]interface{}{"Name": r.Name, "Date": r.Date, "Updates": updates})
+	return sb.String()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/llms"
+	"agent-bot/scheduler"
+	"agent-bot/standup"
+	"agent-bot/tools"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// standupBucket stores the latest round of each standup, keyed by name
+const standupBucket = "standup_rounds"
+
+// scheduleStandups registers the ask and post jobs of every configured standup
+func (b *Bot) scheduleStandups() {
+	for _, config := range b.standups {
+		config := config
+		if err := b.scheduler.Daily("standup-ask-"+config.Name, config.AskAt, func() { b.askStandup(config, false) }); err != nil {
+			log.Printf("[%s] STANDUP: Failed to schedule %s: %v", time.Now().Format("2006-01-02 15:04:05"), config.Name, err)
+			continue
+		}
+		if err := b.scheduler.Daily("standup-post-"+config.Name, config.PostAt, func() { b.postStandup(config) }); err != nil {
+			log.Printf("[%s] STANDUP: Failed to schedule %s: %v", time.Now().Format("2006-01-02 15:04:05"), config.Name, err)
+		}
+	}
+}
+
+func (b *Bot) findStandup(name string) (standup.Config, bool) {
+	for _, config := range b.standups {
+		if strings.EqualFold(config.Name, name) {
+			return config, true
+		}
+	}
+	return standup.Config{}, false
+}
+
+func (b *Bot) loadStandupRound(name string) (*standup.Round, bool) {
+	var round standup.Round
+	found, err := b.store.Get(standupBucket, name, &round)
+	if err != nil || !found {
+		return nil, false
+	}
+	return &round, true
+}
+
+// askStandup starts today's round by DMing every member. Unless forced, it
+// does nothing on days the standup is not held.
+func (b *Bot) askStandup(config standup.Config, force bool) error {
+	now := time.Now().In(b.scheduler.Location())
+	if !force && !config.RunsOn(now) {
+		log.Printf("[%s] STANDUP: %s is not held on %s, skipping", time.Now().Format("2006-01-02 15:04:05"), config.Name, now.Weekday())
+		return nil
+	}
+
+	b.standupMu.Lock()
+	defer b.standupMu.Unlock()
+
+	// Questions held for members in DND or quiet hours are dropped once the
+	// round is posted
+	postHour, postMinute, _ := scheduler.ParseTimeOfDay(config.PostAt)
+	postAt := time.Date(now.Year(), now.Month(), now.Day(), postHour, postMinute, 0, 0, now.Location())
+
+	round := standup.NewRound(config.Name, now)
+	held := 0
+	for _, userID := range config.Members {
+		channelID, err := b.directChannel(userID)
+		var wasHeld bool
+		if err == nil {
+			wasHeld, err = b.notifyUser(userID, channelID, config.Prompt(), postAt)
+		}
+		if err != nil {
+			log.Printf("[%s] STANDUP: Failed to ask %s for the %s standup: %v", time.Now().Format("2006-01-02 15:04:05"), userID, config.Name, err)
+			continue
+		}
+		if wasHeld {
+			held++
+		}
+		round.DMChannels[userID] = channelID
+	}
+
+	if err := b.store.Put(standupBucket, config.Name, round); err != nil {
+		return fmt.Errorf("failed to save standup round: %v", err)
+	}
+	log.Printf("[%s] STANDUP: Asked %d of %d members for the %s standup (%d held until they are available)", time.Now().Format("2006-01-02 15:04:05"), len(round.DMChannels), len(config.Members), config.Name, held)
+	return nil
+}
+
+// interceptStandupReply records DMs from members of an open standup round
+// as their update, so the agent does not answer them as questions
+func (b *Bot) interceptStandupReply(message types.PostedMessage) bool {
+	if !message.IsDM || len(b.standups) == 0 {
+		return false
+	}
+
+	b.standupMu.Lock()
+	defer b.standupMu.Unlock()
+
+	for _, config := range b.standups {
+		round, ok := b.loadStandupRound(config.Name)
+		if !ok || !round.Accepts(message.UserId, message.ChannelId) {
+			continue
+		}
+
+		first := round.Record(message.UserId, message.Message)
+		if err := b.store.Put(standupBucket, config.Name, round); err != nil {
+			log.Printf("[%s] STANDUP: Failed to save update from %s: %v", time.Now().Format("2006-01-02 15:04:05"), message.UserId, err)
+			return false
+		}
+		log.Printf("[%s] STANDUP: Recorded update from %s for %s", time.Now().Format("2006-01-02 15:04:05"), message.UserId, config.Name)
+
+		if first {
+			ack := fmt.Sprintf("Thanks! I'll include this in the %s standup at %s. Anything else you send before then is added to it.", config.Name, config.PostAt)
+			if _, _, err := b.client.CreatePost(&model.Post{ChannelId: message.ChannelId, Message: ack}); err != nil {
+				log.Printf("[%s] STANDUP: Failed to acknowledge update: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// postStandup compiles today's round into a summary and posts it to the standup channel
+func (b *Bot) postStandup(config standup.Config) error {
+	b.standupMu.Lock()
+	round, ok := b.loadStandupRound(config.Name)
+	today := time.Now().In(b.scheduler.Location()).Format("2006-01-02")
+	if !ok || round.Posted || round.Date != today {
+		b.standupMu.Unlock()
+		log.Printf("[%s] STANDUP: No open round of %s for %s, nothing to post", time.Now().Format("2006-01-02 15:04:05"), config.Name, today)
+		return nil
+	}
+	// Close the round before the slow LLM call so late replies go to the agent
+	round.Posted = true
+	err := b.store.Put(standupBucket, config.Name, round)
+	b.standupMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save standup round: %v", err)
+	}
+
+	usernames := make(map[string]string, len(round.DMChannels))
+	for userID := range round.DMChannels {
+		usernames[userID] = userID
+		if user, err := b.chat.GetUser(userID); err == nil {
+			usernames[userID] = user.Username
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("#### %s standup — %s\n\n", config.Name, round.Date))
+	if len(round.Updates) == 0 {
+		sb.WriteString("Nobody sent an update today.")
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		ctx = llms.WithoutTools(ctx)
+		ctx = tools.WithRequest(ctx, tools.Request{UserID: "standup", ChannelID: config.ChannelID})
+
+		summary, err := b.llmBackend.Prompt(ctx, round.SummaryPrompt(usernames))
+		if err != nil {
+			return fmt.Errorf("failed to compile standup: %v", err)
+		}
+		sb.WriteString(strings.TrimSpace(summary))
+	}
+
+	if missing := round.Missing(); len(missing) > 0 {
+		names := make([]string, len(missing))
+		for i, userID := range missing {
+			names[i] = "@" + usernames[userID]
+		}
+		sb.WriteString("\n\n_No update from " + strings.Join(names, ", ") + "._")
+	}
+
+	if _, _, err := b.client.CreatePost(&model.Post{ChannelId: config.ChannelID, Message: sb.String()}); err != nil {
+		return fmt.Errorf("failed to post standup: %v", err)
+	}
+	log.Printf("[%s] STANDUP: Posted %s with %d updates to channel %s", time.Now().Format("2006-01-02 15:04:05"), config.Name, len(round.Updates), config.ChannelID)
+	return nil
+}
+
+// handleStandupCommand implements "!standup list", "!standup ask <name>" and "!standup post <name>"
+func (b *Bot) handleStandupCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!standup list`, `!standup ask <name>`, `!standup post <name>`"
+	if len(args) == 0 {
+		return usage
+	}
+
+	if strings.ToLower(args[0]) == "list" {
+		if len(b.standups) == 0 {
+			return "No standups are configured. Add them under `standups` in the config file."
+		}
+		var sb strings.Builder
+		sb.WriteString("**Standups**\n")
+		for _, config := range b.standups {
+			status := "no round yet"
+			if round, ok := b.loadStandupRound(config.Name); ok {
+				state := "open"
+				if round.Posted {
+					state = "posted"
+				}
+				status = fmt.Sprintf("%s round %s, %d/%d updates", state, round.Date, len(round.Updates), len(round.DMChannels))
+			}
+			sb.WriteString(fmt.Sprintf("- `%s` in `%s`: asks %s, posts %s on %s, %d members (%s)\n", config.Name, config.ChannelID, config.AskAt, config.PostAt, strings.Join(config.Days, "/"), len(config.Members), status))
+		}
+		return sb.String()
+	}
+
+	if len(args) != 2 {
+		return usage
+	}
+	config, ok := b.findStandup(args[1])
+	if !ok {
+		return fmt.Sprintf("Unknown standup `%s`.", args[1])
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "ask":
+		if err := b.askStandup(config, true); err != nil {
+			return fmt.Sprintf("Failed to start the standup: %v", err)
+		}
+		return fmt.Sprintf("Asked the %d members of `%s` for their updates.", len(config.Members), config.Name)
+	case "post":
+		if err := b.postStandup(config); err != nil {
+			return fmt.Sprintf("Failed to post the standup: %v", err)
+		}
+		return fmt.Sprintf("Posted `%s` to `%s` (nothing is posted without an open round today).", config.Name, config.ChannelID)
+	}
+	return usage
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"agent-bot/redis"
+	"agent-bot/store"
+)
+
+// openStateStore opens a workspace's state: the database at
+// STATE_DATABASE_URL, a Redis keyspace of its own when replicas share state,
+// or the state file. The Redis client, which distributed mode also queues
+// events through, is nil when state isn't shared.
+func openStateStore(config Config) (*store.Store, *redis.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var client *redis.Client
+	if sharesState(config) {
+		var err error
+		if client, err = redis.Open(ctx, config.RedisURL); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch {
+	case config.StateDatabaseURL != "":
+		stateStore, err := store.OpenSQL(ctx, config.StateDatabaseURL, workspaceName(config))
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("[%s] STATE: Keeping state in %s", time.Now().Format("2006-01-02 15:04:05"), stateSummary(config))
+		return stateStore, client, nil
+	case client != nil:
+		log.Printf("[%s] STATE: Keeping state in Redis at %s under %q", time.Now().Format("2006-01-02 15:04:05"), client.Addr(), redisPrefix(config))
+		return store.OpenRedis(client, redisPrefix(config)), client, nil
+	}
+	stateStore, err := store.Open(config.StateFile)
+	return stateStore, nil, err
+}
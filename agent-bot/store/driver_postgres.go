@@ -0,0 +1,6 @@
+//go:build postgres
+
+package store
+
+// The pgx driver for postgres:// state databases
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package store
+
+// A pure Go SQLite driver for sqlite: state databases, so builds stay CGO-free
+import _ "modernc.org/sqlite"
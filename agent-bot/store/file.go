@@ -0,0 +1,115 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileBackend keeps every bucket in memory and rewrites a JSON file on each
+// change. An empty path keeps everything in memory.
+type fileBackend struct {
+	mu   sync.RWMutex
+	path string
+	data map[string]map[string]json.RawMessage
+}
+
+// Open loads the store from path, creating it on first write if missing
+func Open(path string) (*Store, error) {
+	b := &fileBackend{
+		path: path,
+		data: make(map[string]map[string]json.RawMessage),
+	}
+
+	if path == "" {
+		return New(b), nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return New(b), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &b.data); err != nil {
+			return nil, fmt.Errorf("failed to parse state file: %w", err)
+		}
+	}
+
+	return New(b), nil
+}
+
+func (b *fileBackend) Get(bucket, key string) ([]byte, bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	value, ok := b.data[bucket][key]
+	return value, ok, nil
+}
+
+func (b *fileBackend) Put(bucket, key string, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.data[bucket] == nil {
+		b.data[bucket] = make(map[string]json.RawMessage)
+	}
+	b.data[bucket][key] = value
+
+	return b.persist()
+}
+
+func (b *fileBackend) Delete(bucket, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.data[bucket][key]; !ok {
+		return nil
+	}
+	delete(b.data[bucket], key)
+
+	return b.persist()
+}
+
+func (b *fileBackend) Keys(bucket string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	keys := make([]string, 0, len(b.data[bucket]))
+	for key := range b.data[bucket] {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// persist writes the file atomically; callers must hold the write lock
+func (b *fileBackend) persist() error {
+	if b.path == "" {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(b.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if dir := filepath.Dir(b.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create state directory: %w", err)
+		}
+	}
+
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
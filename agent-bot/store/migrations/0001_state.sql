@@ -0,0 +1,9 @@
+-- Every bucket of every workspace, one row per key. value is JSON.
+CREATE TABLE IF NOT EXISTS state (
+    namespace  TEXT NOT NULL,
+    bucket     TEXT NOT NULL,
+    item       TEXT NOT NULL,
+    value      TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (namespace, bucket, item)
+);
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+
+	"agent-bot/redis"
+)
+
+// redisBackend keeps each bucket in a Redis hash, shared by every replica
+type redisBackend struct {
+	client *redis.Client
+	prefix string
+}
+
+// OpenRedis returns a store kept in Redis hashes named <prefix>store:<bucket>
+func OpenRedis(client *redis.Client, prefix string) *Store {
+	return New(&redisBackend{client: client, prefix: prefix})
+}
+
+func (b *redisBackend) hash(bucket string) string {
+	return b.prefix + "store:" + bucket
+}
+
+func (b *redisBackend) Get(bucket, key string) ([]byte, bool, error) {
+	value, err := b.client.String(context.Background(), "HGET", b.hash(bucket), key)
+	if err == redis.ErrNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(value), true, nil
+}
+
+func (b *redisBackend) Put(bucket, key string, value []byte) error {
+	_, err := b.client.Do(context.Background(), "HSET", b.hash(bucket), key, string(value))
+	return err
+}
+
+func (b *redisBackend) Delete(bucket, key string) error {
+	_, err := b.client.Do(context.Background(), "HDEL", b.hash(bucket), key)
+	return err
+}
+
+func (b *redisBackend) Keys(bucket string) ([]string, error) {
+	return b.client.Strings(context.Background(), "HKEYS", b.hash(bucket))
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations are applied in file name order; each runs once per database
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// sqlTimeout bounds each query
+const sqlTimeout = 10 * time.Second
+
+// migrationLock is the Postgres advisory lock held while migrating, so
+// replicas starting together don't race
+const migrationLock = 7246180
+
+// Dialects of SQL databases the store supports, with the database/sql
+// driver each needs and the build tag that links it in
+var dialects = map[string]struct {
+	driver string
+	tag    string
+}{
+	"postgres": {driver: "pgx", tag: "postgres"},
+	"sqlite":   {driver: "sqlite", tag: "sqlite"},
+}
+
+// sqlBackend keeps a workspace's buckets in the state table of a SQL database
+type sqlBackend struct {
+	db        *sql.DB
+	dialect   string
+	namespace string
+}
+
+// OpenSQL connects to a database URL, postgres://... or sqlite:<path>,
+// applies pending migrations and returns a store keeping its values under
+// namespace, so workspaces can share a database
+func OpenSQL(ctx context.Context, databaseURL, namespace string) (*Store, error) {
+	if err := CheckDatabaseURL(databaseURL); err != nil {
+		return nil, err
+	}
+	dialect, dsn, _ := parseDatabaseURL(databaseURL)
+	db, err := sql.Open(dialects[dialect].driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", dialect, err)
+	}
+	if dialect == "sqlite" {
+		// SQLite allows one writer at a time; queue writes here rather than
+		// fail them with SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	}
+	b := &sqlBackend{db: db, dialect: dialect, namespace: namespace}
+	if err := b.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return New(b), nil
+}
+
+// CheckDatabaseURL reports whether OpenSQL can use databaseURL: its scheme
+// is known and this build links in the driver for it
+func CheckDatabaseURL(databaseURL string) error {
+	dialect, _, err := parseDatabaseURL(databaseURL)
+	if err != nil {
+		return err
+	}
+	driver := dialects[dialect]
+	if !slices.Contains(sql.Drivers(), driver.driver) {
+		return fmt.Errorf("this build has no %s driver; rebuild with -tags %s (the Docker image links both drivers unless BUILD_TAGS overrides it)", dialect, driver.tag)
+	}
+	return nil
+}
+
+// parseDatabaseURL splits a database URL into its dialect and driver DSN
+func parseDatabaseURL(databaseURL string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(databaseURL, "postgres://"), strings.HasPrefix(databaseURL, "postgresql://"):
+		return "postgres", databaseURL, nil
+	case strings.HasPrefix(databaseURL, "sqlite:"):
+		file := strings.TrimPrefix(strings.TrimPrefix(databaseURL, "sqlite:"), "//")
+		if file == "" {
+			return "", "", fmt.Errorf("invalid database URL %q: missing the SQLite file", databaseURL)
+		}
+		return "sqlite", file + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", nil
+	}
+	return "", "", fmt.Errorf("invalid database URL: scheme must be postgres or sqlite")
+}
+
+// migrate applies the embedded migrations the database hasn't seen yet,
+// recording each in schema_migrations
+func (b *sqlBackend) migrate(ctx context.Context) error {
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the %s database: %w", b.dialect, err)
+	}
+	defer conn.Close()
+
+	if b.dialect == "postgres" {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+			return fmt.Errorf("failed to lock for migrations: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock)
+	}
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	applied := make(map[int]bool)
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+
+	files, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		version, err := strconv.Atoi(strings.SplitN(file.Name(), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("migration %s has no version number", file.Name())
+		}
+		if applied[version] {
+			continue
+		}
+		script, err := migrations.ReadFile(path.Join("migrations", file.Name()))
+		if err != nil {
+			return err
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", file.Name(), err)
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %s: %w", file.Name(), err)
+		}
+		if _, err := tx.ExecContext(ctx, b.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"), version, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", file.Name(), err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", file.Name(), err)
+		}
+	}
+	return nil
+}
+
+// rebind turns ? placeholders into Postgres' $1, $2...
+func (b *sqlBackend) rebind(query string) string {
+	if b.dialect != "postgres" {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func (b *sqlBackend) Get(bucket, key string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlTimeout)
+	defer cancel()
+	var value string
+	err := b.db.QueryRowContext(ctx, b.rebind("SELECT value FROM state WHERE namespace = ? AND bucket = ? AND item = ?"), b.namespace, bucket, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(value), true, nil
+}
+
+func (b *sqlBackend) Put(bucket, key string, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlTimeout)
+	defer cancel()
+	_, err := b.db.ExecContext(ctx, b.rebind(`INSERT INTO state (namespace, bucket, item, value, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (namespace, bucket, item) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`),
+		b.namespace, bucket, key, string(value), time.Now().UTC())
+	return err
+}
+
+func (b *sqlBackend) Delete(bucket, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlTimeout)
+	defer cancel()
+	_, err := b.db.ExecContext(ctx, b.rebind("DELETE FROM state WHERE namespace = ? AND bucket = ? AND item = ?"), b.namespace, bucket, key)
+	return err
+}
+
+func (b *sqlBackend) Keys(bucket string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlTimeout)
+	defer cancel()
+	rows, err := b.db.QueryContext(ctx, b.rebind("SELECT item FROM state WHERE namespace = ? AND bucket = ?"), b.namespace, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
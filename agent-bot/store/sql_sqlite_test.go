@@ -0,0 +1,60 @@
+//go:build sqlite
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	url := "sqlite:" + filepath.Join(t.TempDir(), "state.db")
+	workspace, err := OpenSQL(context.Background(), url, "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := OpenSQL(context.Background(), url, "other")
+	if err != nil {
+		t.Fatalf("reopening a migrated database failed: %v", err)
+	}
+
+	if err := workspace.Put("keys", "k1", map[string]string{"name": "ci"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.Put("keys", "k1", map[string]string{"name": "deploy"}); err != nil {
+		t.Fatalf("overwriting a value failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		store    *Store
+		wantOK   bool
+		wantName string
+	}{
+		{name: "same namespace", store: workspace, wantOK: true, wantName: "deploy"},
+		{name: "other namespace", store: other, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			ok, err := tt.store.Get("keys", "k1", &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK || got["name"] != tt.wantName {
+				t.Errorf("Get() = %v, %v; want %v, %q", got, ok, tt.wantOK, tt.wantName)
+			}
+		})
+	}
+
+	if keys := workspace.Keys("keys"); len(keys) != 1 || keys[0] != "k1" {
+		t.Errorf("Keys() = %v, want [k1]", keys)
+	}
+	if err := workspace.Delete("keys", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := workspace.Get("keys", "k1", new(map[string]string)); ok {
+		t.Error("value still there after Delete")
+	}
+}
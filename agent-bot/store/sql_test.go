@@ -0,0 +1,90 @@
+package store
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDatabaseURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		wantDialect string
+		wantDSN     string
+		wantErr     bool
+	}{
+		{name: "postgres", url: "postgres://bot@db/state", wantDialect: "postgres", wantDSN: "postgres://bot@db/state"},
+		{name: "postgresql", url: "postgresql://bot@db/state", wantDialect: "postgres", wantDSN: "postgresql://bot@db/state"},
+		{name: "sqlite path", url: "sqlite:/data/state.db", wantDialect: "sqlite", wantDSN: "/data/state.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"},
+		{name: "sqlite with slashes", url: "sqlite:///data/state.db", wantDialect: "sqlite", wantDSN: "/data/state.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"},
+		{name: "sqlite without file", url: "sqlite:", wantErr: true},
+		{name: "unknown scheme", url: "mysql://bot@db/state", wantErr: true},
+		{name: "empty", url: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect, dsn, err := parseDatabaseURL(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseDatabaseURL(%q) = %q, %q; want an error", tt.url, dialect, dsn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDatabaseURL(%q) error = %v", tt.url, err)
+			}
+			if dialect != tt.wantDialect || dsn != tt.wantDSN {
+				t.Errorf("parseDatabaseURL(%q) = %q, %q; want %q, %q", tt.url, dialect, dsn, tt.wantDialect, tt.wantDSN)
+			}
+		})
+	}
+}
+
+func TestCheckDatabaseURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "postgres", url: "postgres://bot@db/state"},
+		{name: "sqlite", url: "sqlite:/data/state.db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect, _, _ := parseDatabaseURL(tt.url)
+			linked := slices.Contains(sql.Drivers(), dialects[dialect].driver)
+			err := CheckDatabaseURL(tt.url)
+			if linked && err != nil {
+				t.Fatalf("CheckDatabaseURL(%q) error = %v with the driver linked", tt.url, err)
+			}
+			if !linked && (err == nil || !strings.Contains(err.Error(), "-tags "+dialects[dialect].tag)) {
+				t.Fatalf("CheckDatabaseURL(%q) error = %v, want a hint to rebuild with -tags %s", tt.url, err, dialects[dialect].tag)
+			}
+		})
+	}
+
+	if err := CheckDatabaseURL("mysql://bot@db/state"); err == nil {
+		t.Error("CheckDatabaseURL accepted an unknown scheme")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect string
+		query   string
+		want    string
+	}{
+		{dialect: "postgres", query: "SELECT value FROM state WHERE bucket = ? AND item = ?", want: "SELECT value FROM state WHERE bucket = $1 AND item = $2"},
+		{dialect: "sqlite", query: "SELECT value FROM state WHERE bucket = ? AND item = ?", want: "SELECT value FROM state WHERE bucket = ? AND item = ?"},
+		{dialect: "postgres", query: "SELECT 1", want: "SELECT 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			b := &sqlBackend{dialect: tt.dialect}
+			if got := b.rebind(tt.query); got != tt.want {
+				t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Package store persists bot state as JSON values in named buckets, in a
+// file, Redis or a SQL database.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Store is a small key-value store for bot state. Values are JSON, grouped
+// into buckets (active threads, memories, usage, settings...) and written
+// through on every change. Where they are kept is up to the Backend: a JSON
+// file (Open), Redis (OpenRedis) or a SQL database (OpenSQL).
+//
+// Backends deliberately see buckets rather than domain methods (threads,
+// memories, usage, reminders, feedback...): dozens of features keep state
+// here, and a domain interface would make each new one touch every backend.
+// A feature that needs to query its state in SQL gets a table of its own
+// through a migration.
+type Store struct {
+	backend Backend
+}
+
+// Backend keeps the encoded values of a Store. Implementations must be safe
+// for concurrent use.
+type Backend interface {
+	// Get returns the value under bucket/key, reporting whether it exists
+	Get(bucket, key string) ([]byte, bool, error)
+	Put(bucket, key string, value []byte) error
+	// Delete removes bucket/key; deleting a missing key is not an error
+	Delete(bucket, key string) error
+	// Keys returns the keys of a bucket in any order
+	Keys(bucket string) ([]string, error)
+}
+
+// New returns a store kept in backend
+func New(backend Backend) *Store {
+	return &Store{backend: backend}
+}
+
+// Get decodes the value stored under bucket/key into v, reporting whether it exists
+func (s *Store) Get(bucket, key string, v interface{}) (bool, error) {
+	raw, found, err := s.backend.Get(bucket, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s/%s: %w", bucket, key, err)
+	}
+	if !found {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("failed to decode %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+// Put stores v under bucket/key
+func (s *Store) Put(bucket, key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s/%s: %w", bucket, key, err)
+	}
+	if err := s.backend.Put(bucket, key, raw); err != nil {
+		return fmt.Errorf("failed to write %s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// Delete removes bucket/key
+func (s *Store) Delete(bucket, key string) error {
+	if err := s.backend.Delete(bucket, key); err != nil {
+		return fmt.Errorf("failed to delete %s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// Keys returns the sorted keys of a bucket. A backend that can't be reached
+// reports none.
+func (s *Store) Keys(bucket string) []string {
+	keys, err := s.backend.Keys(bucket)
+	if err != nil {
+		log.Printf("[%s] STORE: Failed to list %s: %v", time.Now().Format("2006-01-02 15:04:05"), bucket, err)
+		return nil
+	}
+	sort.Strings(keys)
+	return keys
+}
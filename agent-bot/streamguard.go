@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/tracing"
+	"agent-bot/types"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// streamTarget tracks a post the bot is streaming into so that edits or
+// deletions by other people can be told apart from the bot's own updates
+type streamTarget struct {
+	// the two most recent contents the bot wrote; edit events for older
+	// updates can arrive after a newer one was sent
+	written [2]string
+	edited  bool
+	deleted bool
+}
+
+func (a *BotAgent) trackStream(messageID, content string) {
+	a.streamsMu.Lock()
+	defer a.streamsMu.Unlock()
+	a.streams[messageID] = &streamTarget{written: [2]string{content, content}}
+}
+
+func (a *BotAgent) untrackStream(messageID string) {
+	a.streamsMu.Lock()
+	defer a.streamsMu.Unlock()
+	delete(a.streams, messageID)
+}
+
+// streamState reports whether someone else edited or deleted a streaming post
+func (a *BotAgent) streamState(messageID string) (edited, deleted bool) {
+	a.streamsMu.Lock()
+	defer a.streamsMu.Unlock()
+	if target, ok := a.streams[messageID]; ok {
+		return target.edited, target.deleted
+	}
+	return false, false
+}
+
+// updateStream writes content to a streaming post, recording it first so the
+// resulting edit event is recognised as the bot's own
+func (a *BotAgent) updateStream(ctx context.Context, messageID, content string) error {
+	a.streamsMu.Lock()
+	if target, ok := a.streams[messageID]; ok {
+		target.written[0], target.written[1] = target.written[1], content
+	}
+	a.streamsMu.Unlock()
+
+	_, span := tracing.Start(ctx, "chat.update",
+		attribute.String("chat.post_id", messageID),
+		attribute.Int("chat.message_chars", len(content)),
+	)
+	err := a.chat.UpdateMessage(messageID, content)
+	tracing.End(span, err)
+	if errors.Is(err, types.ErrMessageDeleted) {
+		a.MessageDeleted(messageID)
+	}
+	return err
+}
+
+// MessageEdited is called when a post changes; edits to a streaming post that
+// don't match what the bot wrote came from someone else
+func (a *BotAgent) MessageEdited(messageID, content string) {
+	a.streamsMu.Lock()
+	defer a.streamsMu.Unlock()
+
+	target, ok := a.streams[messageID]
+	if !ok || target.edited {
+		return
+	}
+	// The server may normalise surrounding whitespace
+	content = strings.TrimSpace(content)
+	if content == strings.TrimSpace(target.written[0]) || content == strings.TrimSpace(target.written[1]) {
+		return
+	}
+	target.edited = true
+	log.Printf("[%s] STREAM: Message %s was edited by someone else, detaching stream", time.Now().Format("2006-01-02 15:04:05"), messageID)
+}
+
+// MessageDeleted is called when a post is deleted
+func (a *BotAgent) MessageDeleted(messageID string) {
+	a.streamsMu.Lock()
+	defer a.streamsMu.Unlock()
+
+	target, ok := a.streams[messageID]
+	if !ok || target.deleted {
+		return
+	}
+	target.deleted = true
+	log.Printf("[%s] STREAM: Message %s was deleted, aborting stream", time.Now().Format("2006-01-02 15:04:05"), messageID)
+}
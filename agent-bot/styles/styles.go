@@ -0,0 +1,212 @@
+// Package styles shapes replies per channel: how long and detailed they
+// are, whether they use emoji, whether they cite sources and whether they
+// end with a footer about how they were made.
+package styles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Verbosity levels
+const (
+	Concise  = "concise"
+	Detailed = "detailed"
+)
+
+// Style is a set of reply preferences. Zero values mean "no preference", so
+// styles can be layered with Merge.
+type Style struct {
+	// MaxLength is a target upper bound on the reply in characters
+	MaxLength int    `yaml:"max_length" json:"max_length,omitempty"`
+	Verbosity string `yaml:"verbosity" json:"verbosity,omitempty"`
+	Emoji     *bool  `yaml:"emoji" json:"emoji,omitempty"`
+	Sources   *bool  `yaml:"sources" json:"sources,omitempty"`
+	// Footer adds a line with the model, tools and response time to replies
+	Footer *bool `yaml:"footer" json:"footer,omitempty"`
+}
+
+// ChannelStyle is a style configured for one channel
+type ChannelStyle struct {
+	ChannelID string `yaml:"channel_id"`
+	Style     `yaml:",inline"`
+}
+
+// Empty reports whether the style expresses no preference
+func (s Style) Empty() bool {
+	return s.MaxLength == 0 && s.Verbosity == "" && s.Emoji == nil && s.Sources == nil && s.Footer == nil
+}
+
+// Merge returns s with every preference set in over replacing its own
+func (s Style) Merge(over Style) Style {
+	if over.MaxLength != 0 {
+		s.MaxLength = over.MaxLength
+	}
+	if over.Verbosity != "" {
+		s.Verbosity = over.Verbosity
+	}
+	if over.Emoji != nil {
+		s.Emoji = over.Emoji
+	}
+	if over.Sources != nil {
+		s.Sources = over.Sources
+	}
+	if over.Footer != nil {
+		s.Footer = over.Footer
+	}
+	return s
+}
+
+// Validate checks the style's values
+func (s Style) Validate() error {
+	if s.MaxLength < 0 {
+		return fmt.Errorf("max_length must not be negative")
+	}
+	switch s.Verbosity {
+	case "", Concise, Detailed:
+	default:
+		return fmt.Errorf("verbosity must be %q or %q, not %q", Concise, Detailed, s.Verbosity)
+	}
+	return nil
+}
+
+// Validate checks the default style and every channel style
+func Validate(defaults Style, channels []ChannelStyle) error {
+	if err := defaults.Validate(); err != nil {
+		return fmt.Errorf("response_style: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i, channel := range channels {
+		if channel.ChannelID == "" {
+			return fmt.Errorf("channel_styles[%d]: channel_id is required", i)
+		}
+		if seen[channel.ChannelID] {
+			return fmt.Errorf("channel_styles[%d]: duplicate channel_id %s", i, channel.ChannelID)
+		}
+		seen[channel.ChannelID] = true
+		if err := channel.Validate(); err != nil {
+			return fmt.Errorf("channel_styles[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Set changes one preference from its name and a textual value, as typed in
+// an admin command: max_length=600, verbosity=concise, emoji=off, sources=on, footer=on.
+// "default" clears the preference.
+func (s *Style) Set(name, value string) error {
+	value = strings.ToLower(strings.TrimSpace(value))
+	clear := value == "default"
+
+	switch strings.ToLower(name) {
+	case "max_length", "length":
+		if clear {
+			s.MaxLength = 0
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("max_length must be a number of characters")
+		}
+		s.MaxLength = n
+	case "verbosity":
+		if clear {
+			s.Verbosity = ""
+			return nil
+		}
+		s.Verbosity = value
+		return s.Validate()
+	case "emoji":
+		return setFlag(&s.Emoji, "emoji", value, clear)
+	case "sources":
+		return setFlag(&s.Sources, "sources", value, clear)
+	case "footer":
+		return setFlag(&s.Footer, "footer", value, clear)
+	default:
+		return fmt.Errorf("unknown setting %q (use max_length, verbosity, emoji, sources or footer)", name)
+	}
+	return nil
+}
+
+func setFlag(flag **bool, name, value string, clear bool) error {
+	if clear {
+		*flag = nil
+		return nil
+	}
+	switch value {
+	case "on", "true", "yes":
+		on := true
+		*flag = &on
+	case "off", "false", "no":
+		off := false
+		*flag = &off
+	default:
+		return fmt.Errorf("%s must be on, off or default", name)
+	}
+	return nil
+}
+
+// Instructions renders the style as guidance appended to the prompt; empty
+// when the style has no preferences
+func (s Style) Instructions() string {
+	var lines []string
+	switch s.Verbosity {
+	case Concise:
+		lines = append(lines, "Be concise: answer in a few sentences or a short list, and skip background unless asked.")
+	case Detailed:
+		lines = append(lines, "Be thorough: explain your reasoning and include relevant details and examples.")
+	}
+	if s.MaxLength > 0 {
+		lines = append(lines, fmt.Sprintf("Keep the whole reply under %d characters.", s.MaxLength))
+	}
+	if s.Emoji != nil {
+		if *s.Emoji {
+			lines = append(lines, "Emoji are welcome where they fit.")
+		} else {
+			lines = append(lines, "Don't use emoji.")
+		}
+	}
+	if s.Sources != nil {
+		if *s.Sources {
+			lines = append(lines, "Cite your sources (links, ticket IDs or document names) for facts you looked up.")
+		} else {
+			lines = append(lines, "Don't list sources or links unless asked.")
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Reply style for this channel:\n- " + strings.Join(lines, "\n- ")
+}
+
+// String summarizes the style for admin commands
+func (s Style) String() string {
+	if s.Empty() {
+		return "default"
+	}
+	var parts []string
+	if s.Verbosity != "" {
+		parts = append(parts, s.Verbosity)
+	}
+	if s.MaxLength > 0 {
+		parts = append(parts, fmt.Sprintf("max %d chars", s.MaxLength))
+	}
+	if s.Emoji != nil {
+		parts = append(parts, "emoji "+onOff(*s.Emoji))
+	}
+	if s.Sources != nil {
+		parts = append(parts, "sources "+onOff(*s.Sources))
+	}
+	if s.Footer != nil {
+		parts = append(parts, "footer "+onOff(*s.Footer))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/types"
+)
+
+// summaryChunkTokens bounds each piece of a long thread sent for partial notes
+const summaryChunkTokens = 20000
+
+// summarizeRequestPattern recognizes "summarize this thread", "tl;dr", "recap the discussion" and similar
+var summarizeRequestPattern = regexp.MustCompile(`(?i)^(please\s+|can you\s+|could you\s+)?(summari[sz]e|recap|tl;?dr)\b(\s+(this|the))?(\s+(thread|conversation|discussion))?\s*(please)?[.!?]*$`)
+
+// isSummarizeRequest reports whether a message addressed to the bot asks for a thread summary
+func (a *BotAgent) isSummarizeRequest(message types.PostedMessage) bool {
+	text := strings.ReplaceAll(message.Message, "@"+a.botUsername, "")
+	return summarizeRequestPattern.MatchString(strings.TrimSpace(text))
+}
+
+// summarizeThread posts a structured summary of the whole thread the message was sent in
+func (a *BotAgent) summarizeThread(ctx context.Context, message types.PostedMessage) {
+	if message.ThreadId == "" {
+		a.postNotice(ctx, types.PostedMessage{UserId: message.UserId, ChannelId: message.ChannelId, ThreadId: message.PostId}, "Ask me to summarize from inside a thread and I'll recap the whole conversation.")
+		return
+	}
+
+	a.sendTypingIndicator(message.ChannelId, message.ThreadId)
+
+	history, users, err := a.loadThread(message.ThreadId, message.PostId, "")
+	if err != nil {
+		reqid.Logf(ctx, "SUMMARY: Failed to load thread %s: %v", message.ThreadId, err)
+		a.postNotice(ctx, message, "Sorry, I couldn't load this thread to summarize it.")
+		return
+	}
+
+	var participants []string
+	seen := make(map[string]bool)
+	var chunks []string
+	var current strings.Builder
+	for _, p := range history {
+		if p.UserID != a.botUserID && !seen[p.UserID] {
+			seen[p.UserID] = true
+			if user, ok := users[p.UserID]; ok {
+				participants = append(participants, "@"+user.Username)
+			}
+		}
+
+		line := a.formatPost(p, users)
+		if current.Len() > 0 && estimateTokens(current.String())+estimateTokens(line) > summaryChunkTokens {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+
+	data := prompts.ThreadSummaryData{Participants: participants}
+	if len(chunks) == 1 {
+		data.Transcript = chunks[0]
+	} else {
+		// Long threads are condensed part by part, then summarized from the notes
+		data.Partial = true
+		for i, chunk := range chunks {
+			prompt, err := a.prompts.Render(prompts.ThreadNotes, prompts.ThreadNotesData{Part: i + 1, Parts: len(chunks), Transcript: chunk})
+			var notes string
+			if err == nil {
+				notes, err = a.promptDecisionLLM(ctx, "thread_notes", prompt)
+			}
+			if err != nil {
+				reqid.Logf(ctx, "SUMMARY: Failed to take notes on part %d of thread %s: %v", i+1, message.ThreadId, err)
+				notes = fmt.Sprintf("(part %d could not be read)", i+1)
+			}
+			data.Notes = append(data.Notes, strings.TrimSpace(notes))
+		}
+	}
+
+	prompt, err := a.prompts.Render(prompts.ThreadSummary, data)
+	if err != nil {
+		reqid.Logf(ctx, "SUMMARY: Failed to render summary prompt: %v", err)
+		a.postNotice(ctx, message, "Sorry, I couldn't summarize this thread.")
+		return
+	}
+
+	reqid.Logf(ctx, "SUMMARY: Summarizing thread %s (%d posts, %d parts)", message.ThreadId, len(history), len(chunks))
+	a.respondWithStream(ctx, message, prompt)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"agent-bot/metrics"
+	"agent-bot/types"
+)
+
+// errSuperseded cancels a reply when the same user sends a follow-up in the
+// same conversation before it finished
+var errSuperseded = errors.New("superseded by a newer message")
+
+// supersededNote ends a reply cut short by a follow-up
+const supersededNote = "_Stopped here to answer your newer message._"
+
+// inFlightReply is a reply being generated, registered under conversationKey
+type inFlightReply struct {
+	postID string
+	cancel context.CancelCauseFunc
+}
+
+// conversationKey identifies where a user's message lands: the thread it is
+// in, or the channel for top-level posts
+func conversationKey(message types.PostedMessage) string {
+	if message.ThreadId != "" {
+		return message.UserId + "/" + message.ThreadId
+	}
+	return message.UserId + "/" + message.ChannelId
+}
+
+// replyKey is the conversation the reply to message continues in: the thread,
+// the one a mention starts, or the channel for unthreaded replies like DMs
+func replyKey(message types.PostedMessage) string {
+	if message.ThreadId == "" && message.Mentioned {
+		return message.UserId + "/" + message.PostId
+	}
+	return conversationKey(message)
+}
+
+// beginReply registers a reply to message so a follow-up can cancel it. The
+// returned function must be called when the reply is done.
+func (a *BotAgent) beginReply(ctx context.Context, message types.PostedMessage) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	reply := &inFlightReply{postID: message.PostId, cancel: cancel}
+	key := replyKey(message)
+
+	a.inFlightMu.Lock()
+	a.inFlight[key] = reply
+	a.inFlightMu.Unlock()
+
+	return ctx, func() {
+		a.inFlightMu.Lock()
+		if a.inFlight[key] == reply {
+			delete(a.inFlight, key)
+		}
+		a.inFlightMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// supersede cancels the reply still being generated for the sender's previous
+// message in the same conversation, if there is one
+func (a *BotAgent) supersede(message types.PostedMessage) {
+	key := conversationKey(message)
+	a.inFlightMu.Lock()
+	reply, ok := a.inFlight[key]
+	if ok {
+		delete(a.inFlight, key)
+	}
+	a.inFlightMu.Unlock()
+	if !ok {
+		return
+	}
+
+	log.Printf("[%s] SUPERSEDE: Cancelling reply to %s, answering %s instead", time.Now().Format("2006-01-02 15:04:05"), reply.postID, message.PostId)
+	metrics.Inc("replies_superseded_total")
+	reply.cancel(errSuperseded)
+}
+
+// superseded reports whether ctx was cancelled by supersede
+func superseded(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errSuperseded)
+}
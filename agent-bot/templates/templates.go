@@ -0,0 +1,110 @@
+// Package templates holds admin-defined answer templates for recurring
+// requests. The decision model picks the matching template, and its fields
+// become instructions in the prompt.
+package templates
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// NoTemplate is the classification answer for requests that match no template
+const NoTemplate = "none"
+
+// Field is a section the LLM must fill in when answering with a template
+type Field struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Required    bool   `yaml:"required"`
+}
+
+// Template is an admin-defined structure for a recurring type of answer,
+// such as an incident update or a release note
+type Template struct {
+	Name        string  `yaml:"name"`
+	Description string  `yaml:"description"`
+	Fields      []Field `yaml:"fields"`
+	// Preamble is optional extra guidance, e.g. tone or links to always include
+	Preamble string `yaml:"preamble"`
+}
+
+// Instructions renders the template as formatting instructions for the LLM
+func (t *Template) Instructions() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Answer using the \"%s\" response template. ", t.Name))
+	if t.Preamble != "" {
+		b.WriteString(t.Preamble + " ")
+	}
+	b.WriteString("Use exactly these sections, in this order, each starting with its bold title on its own line:\n\n")
+	for _, field := range t.Fields {
+		requirement := "optional, omit if unknown"
+		if field.Required {
+			requirement = "required, write \"Unknown\" if the information is missing"
+		}
+		b.WriteString(fmt.Sprintf("**%s** (%s): %s\n", field.Name, requirement, field.Description))
+	}
+	return b.String()
+}
+
+// Set is the collection of configured templates
+type Set struct {
+	mu        sync.RWMutex
+	templates []Template
+}
+
+func NewSet(templates []Template) *Set {
+	return &Set{templates: templates}
+}
+
+// Replace swaps in newly configured templates, e.g. on a config reload
+func (s *Set) Replace(templates []Template) {
+	s.mu.Lock()
+	s.templates = templates
+	s.mu.Unlock()
+}
+
+// Empty reports whether no templates are configured
+func (s *Set) Empty() bool {
+	return len(s.All()) == 0
+}
+
+// All returns the configured templates
+func (s *Set) All() []Template {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.templates
+}
+
+// ClassificationPrompt asks the decision LLM which template, if any, fits the request
+func (s *Set) ClassificationPrompt(request string) string {
+	var b strings.Builder
+	b.WriteString("Classify the latest request in this conversation into one of the following response types.\n\n")
+	for _, t := range s.All() {
+		b.WriteString(fmt.Sprintf("- %s: %s\n", t.Name, t.Description))
+	}
+	b.WriteString(fmt.Sprintf("- %s: the request does not match any of the types above\n\n", NoTemplate))
+	b.WriteString("Conversation:\n")
+	b.WriteString(request)
+	b.WriteString("\n\nRespond with ONLY the response type name.\n\nAnswer:")
+	return b.String()
+}
+
+// Match finds the template named in a classification answer
+func (s *Set) Match(answer string) (*Template, bool) {
+	answer = strings.ToLower(strings.Trim(strings.TrimSpace(answer), "`\"'."))
+	templates := s.All()
+	if len(templates) == 0 || answer == NoTemplate {
+		return nil, false
+	}
+
+	for i := range templates {
+		if strings.ToLower(templates[i].Name) == answer {
+			return &templates[i], true
+		}
+	}
+	return nil, false
+}
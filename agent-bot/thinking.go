@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"agent-bot/llms"
+	"agent-bot/reqid"
+	"agent-bot/store"
+	"agent-bot/types"
+)
+
+// thinkingChannelsBucket stores per-channel extended thinking settings set
+// with !thinking, which replace THINKING_BUDGET_TOKENS and
+// THINKING_SHOW_REASONING in that channel
+const thinkingChannelsBucket = "thinking_channels"
+
+// maxReasoningChars bounds the reasoning section appended to replies, so
+// answer and reasoning fit in one post
+const maxReasoningChars = 4000
+
+// thinkingSettings configures extended thinking for replies
+type thinkingSettings struct {
+	// BudgetTokens is the thinking budget; 0 turns thinking off
+	BudgetTokens int `json:"budget_tokens"`
+	// ShowReasoning appends the model's reasoning to the reply
+	ShowReasoning bool `json:"show_reasoning"`
+}
+
+func (s thinkingSettings) String() string {
+	if s.BudgetTokens == 0 {
+		return "off"
+	}
+	if s.ShowReasoning {
+		return fmt.Sprintf("%d token budget, reasoning shown", s.BudgetTokens)
+	}
+	return fmt.Sprintf("%d token budget, reasoning hidden", s.BudgetTokens)
+}
+
+// channelThinking resolves the extended thinking settings of a channel
+type channelThinking struct {
+	defaults thinkingSettings
+	store    *store.Store
+}
+
+func newChannelThinking(config Config, stateStore *store.Store) *channelThinking {
+	return &channelThinking{
+		defaults: thinkingSettings{BudgetTokens: config.ThinkingBudget, ShowReasoning: config.ThinkingShowReasoning},
+		store:    stateStore,
+	}
+}
+
+// For returns the settings for replies in channelID
+func (c *channelThinking) For(channelID string) thinkingSettings {
+	settings, _ := c.override(channelID)
+	return settings
+}
+
+// override returns the settings set with !thinking for a channel, or the
+// defaults and false when there are none
+func (c *channelThinking) override(channelID string) (thinkingSettings, bool) {
+	if c.store != nil {
+		var settings thinkingSettings
+		if found, err := c.store.Get(thinkingChannelsBucket, channelID, &settings); found && err == nil {
+			return settings, true
+		}
+	}
+	return c.defaults, false
+}
+
+// withThinking turns on extended thinking for the reply if its channel asks
+// for it, collecting the reasoning when it is to be shown
+func (a *BotAgent) withThinking(ctx context.Context, channelID string) context.Context {
+	if a.thinking == nil {
+		return ctx
+	}
+	settings := a.thinking.For(channelID)
+	if settings.BudgetTokens == 0 {
+		return ctx
+	}
+
+	var reasoning *llms.Reasoning
+	if settings.ShowReasoning {
+		reasoning = &llms.Reasoning{}
+	}
+	reqid.Logf(ctx, "THINKING: Extended thinking for channel %s (%s)", channelID, settings)
+	return llms.WithThinking(ctx, settings.BudgetTokens, reasoning)
+}
+
+// withReasoning appends the reasoning collected for the request in ctx to a
+// reply, as a quoted section after the answer. Mattermost collapses long
+// posts, so the answer stays in view and the reasoning behind "Show more".
+func withReasoning(ctx context.Context, reply string) string {
+	reasoning := llms.ReasoningFrom(ctx).String()
+	if reasoning == "" || reply == "" {
+		return reply
+	}
+	if len(reasoning) > maxReasoningChars {
+		reasoning = strings.ToValidUTF8(reasoning[:maxReasoningChars], "") + "…"
+	}
+	return reply + "\n\n---\n> **Reasoning**\n> " + strings.ReplaceAll(reasoning, "\n", "\n> ")
+}
+
+// handleThinkingCommand implements "!thinking"
+func (b *Bot) handleThinkingCommand(message types.PostedMessage, args []string) string {
+	usage := fmt.Sprintf("Usage: `!thinking [channel_id|here]` to show a channel's setting, `!thinking <channel_id|here> <budget_tokens> [show|hide]` to turn extended thinking on (budget at least %d), `!thinking <channel_id|here> off`, `!thinking <channel_id|here> default`, `!thinking list`", llms.MinThinkingBudget)
+	if len(args) == 0 {
+		args = []string{"here"}
+	}
+
+	if strings.ToLower(args[0]) == "list" {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("**Extended thinking**\n- Default: %s\n", b.thinking.defaults))
+		channelIDs := b.store.Keys(thinkingChannelsBucket)
+		sort.Strings(channelIDs)
+		for _, channelID := range channelIDs {
+			sb.WriteString(fmt.Sprintf("- `%s`: %s\n", channelID, b.thinking.For(channelID)))
+		}
+		return sb.String()
+	}
+
+	channelID := args[0]
+	if channelID == "here" {
+		channelID = message.ChannelId
+	}
+	if len(args) == 1 {
+		settings, overridden := b.thinking.override(channelID)
+		if !overridden {
+			return fmt.Sprintf("Extended thinking in `%s`: %s (default)", channelID, settings)
+		}
+		return fmt.Sprintf("Extended thinking in `%s`: %s", channelID, settings)
+	}
+
+	var settings thinkingSettings
+	switch value := strings.ToLower(args[1]); value {
+	case "default":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := b.store.Delete(thinkingChannelsBucket, channelID); err != nil {
+			return fmt.Sprintf("Failed to reset extended thinking: %v", err)
+		}
+		return fmt.Sprintf("Extended thinking in `%s` reset to the default: %s", channelID, b.thinking.defaults)
+	case "off":
+		if len(args) != 2 {
+			return usage
+		}
+	default:
+		budget, err := strconv.Atoi(value)
+		if err != nil || budget < llms.MinThinkingBudget || len(args) > 3 {
+			return usage
+		}
+		settings.BudgetTokens = budget
+		if len(args) == 3 {
+			switch strings.ToLower(args[2]) {
+			case "show":
+				settings.ShowReasoning = true
+			case "hide":
+			default:
+				return usage
+			}
+		}
+	}
+
+	if err := b.store.Put(thinkingChannelsBucket, channelID, settings); err != nil {
+		return fmt.Sprintf("Failed to save extended thinking: %v", err)
+	}
+	return fmt.Sprintf("Extended thinking in `%s` is now: %s", channelID, settings)
+}
+
+// thinkingSummary describes the default extended thinking settings
+func thinkingSummary(c Config) string {
+	return thinkingSettings{BudgetTokens: c.ThinkingBudget, ShowReasoning: c.ThinkingShowReasoning}.String() + " (per channel with !thinking)"
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"agent-bot/types"
+)
+
+// threadTurns remembers, per conversation, when a person last wrote, when
+// the bot last posted and when it last replied unasked, so unsolicited
+// replies can be spaced out and never follow the bot's own post. A
+// conversation is a thread, or a channel for top-level posts.
+type threadTurns struct {
+	// cooldown is the least time between unsolicited replies in one
+	// conversation; 0 turns the cooldown off
+	cooldown time.Duration
+
+	mu    sync.Mutex
+	turns map[string]*turns
+}
+
+type turns struct {
+	lastHuman       time.Time
+	lastBot         time.Time
+	lastUnsolicited time.Time
+	// botLast is set while the bot's post is the latest; it is kept apart
+	// from the times, which tie when both land within the clock's resolution
+	botLast bool
+}
+
+// unsolicitedKey marks the context of a reply the bot decided to give
+// without being asked
+type unsolicitedKey struct{}
+
+func withUnsolicited(ctx context.Context, unsolicited bool) context.Context {
+	return context.WithValue(ctx, unsolicitedKey{}, unsolicited)
+}
+
+// isUnsolicited reports whether ctx is that of an unsolicited reply, as
+// opposed to a mention, DM or reaction action
+func isUnsolicited(ctx context.Context) bool {
+	unsolicited, _ := ctx.Value(unsolicitedKey{}).(bool)
+	return unsolicited
+}
+
+func newThreadTurns(cooldown time.Duration) *threadTurns {
+	return &threadTurns{cooldown: cooldown, turns: make(map[string]*turns)}
+}
+
+// turnsKey is the thread of a message, or its channel when it is a
+// top-level post
+func turnsKey(channelID, threadID string) string {
+	if threadID != "" {
+		return threadID
+	}
+	return channelID
+}
+
+func (t *threadTurns) get(key string) *turns {
+	entry, ok := t.turns[key]
+	if !ok {
+		entry = &turns{}
+		t.turns[key] = entry
+	}
+	return entry
+}
+
+// human records a message from someone other than the bot
+func (t *threadTurns) human(message types.PostedMessage, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry := t.get(turnsKey(message.ChannelId, message.ThreadId))
+	entry.lastHuman, entry.botLast = now, false
+}
+
+// bot records a post by the bot
+func (t *threadTurns) bot(channelID, threadID string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry := t.get(turnsKey(channelID, threadID))
+	entry.lastBot, entry.botLast = now, true
+}
+
+// unsolicited records an unsolicited reply being started
+func (t *threadTurns) unsolicited(message types.PostedMessage, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(turnsKey(message.ChannelId, message.ThreadId)).lastUnsolicited = now
+}
+
+// coolingDown reports whether the conversation had an unsolicited reply
+// less than cooldown ago
+func (t *threadTurns) coolingDown(message types.PostedMessage, now time.Time) bool {
+	if t.cooldown <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.turns[turnsKey(message.ChannelId, message.ThreadId)]
+	return ok && !entry.lastUnsolicited.IsZero() && now.Sub(entry.lastUnsolicited) < t.cooldown
+}
+
+// botSpokeLast reports whether the bot has posted in the conversation since
+// anyone else last wrote there
+func (t *threadTurns) botSpokeLast(channelID, threadID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.turns[turnsKey(channelID, threadID)]
+	return ok && entry.botLast
+}
+
+// cleanup forgets conversations quiet for longer than maxAge
+func (t *threadTurns) cleanup(now time.Time, maxAge time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, entry := range t.turns {
+		latest := entry.lastHuman
+		if entry.lastBot.After(latest) {
+			latest = entry.lastBot
+		}
+		if now.Sub(latest) > maxAge {
+			delete(t.turns, key)
+		}
+	}
+}
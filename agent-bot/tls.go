@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"agent-bot/mattermost"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketURL derives the websocket endpoint from the Mattermost server URL
+func websocketURL(serverURL string) string {
+	switch {
+	case strings.HasPrefix(serverURL, "https://"):
+		return "wss://" + strings.TrimPrefix(serverURL, "https://")
+	case strings.HasPrefix(serverURL, "http://"):
+		return "ws://" + strings.TrimPrefix(serverURL, "http://")
+	}
+	return serverURL
+}
+
+// loadTLSConfig builds the TLS settings shared by the REST and websocket
+// clients. It returns nil when the system defaults should be used.
+func loadTLSConfig(config Config) (*tls.Config, error) {
+	if config.TLSCAFile == "" && !config.TLSInsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.TLSInsecureSkipVerify,
+	}
+
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// newHTTPClient returns the HTTP client used for the Mattermost REST API,
+// paced by the server's rate limit headers
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	var transport http.RoundTripper = http.DefaultTransport
+	if tlsConfig != nil {
+		custom := http.DefaultTransport.(*http.Transport).Clone()
+		custom.TLSClientConfig = tlsConfig
+		transport = custom
+	}
+	return &http.Client{Transport: mattermost.NewRateLimitedTransport(transport)}
+}
+
+// newWebSocketDialer returns the dialer used for the Mattermost websocket
+func newWebSocketDialer(tlsConfig *tls.Config, timeout time.Duration) *websocket.Dialer {
+	return &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: timeout,
+		TLSClientConfig:  tlsConfig,
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-bot/audit"
+	"agent-bot/types"
+)
+
+// defaultAuditLimit is how many entries !audit and /admin/audit return by default
+const defaultAuditLimit = 20
+
+// authorizeAdmin checks the ADMIN_API_TOKEN bearer token on a GET request,
+// writing the error response if it fails
+func (b *Bot) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	return b.authorizeAdminMethod(w, r, http.MethodGet)
+}
+
+// authorizeAdminMethod checks the ADMIN_API_TOKEN and the request method.
+// Browsers may send the token as the basic auth password instead of a bearer
+// token, so the dashboard can be opened directly.
+func (b *Bot) authorizeAdminMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if b.config.AdminAPIToken == "" {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "admin API is disabled"})
+		return false
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(b.config.AdminAPIToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="agent-bot admin"`)
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid admin token"})
+		return false
+	}
+
+	if r.Method != method {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return false
+	}
+	return true
+}
+
+// handleAdminAudit serves GET /admin/audit?user=&channel=&tool=&since=&limit=,
+// authenticated with ADMIN_API_TOKEN. since is RFC 3339 or a duration like 24h.
+func (b *Bot) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAdmin(w, r) {
+		return
+	}
+	if b.auditLog == nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "audit log is disabled"})
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		UserID:    query.Get("user"),
+		ChannelID: query.Get("channel"),
+		Tool:      query.Get("tool"),
+		Limit:     100,
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "limit must be a non-negative number"})
+			return
+		}
+		filter.Limit = n
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+			return
+		}
+		filter.Since = t
+	}
+
+	entries, err := b.auditLog.Query(filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	log.Printf("[%s] AUDIT: Returning %d entries via admin API", time.Now().Format("2006-01-02 15:04:05"), len(entries))
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// parseSince accepts an RFC 3339 time or a duration before now
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("since must be an RFC 3339 time or a duration like 24h")
+}
+
+// handleAuditCommand implements "!audit [limit] [user:<id>] [channel:<id>] [tool:<name>] [since:<24h>]"
+func (b *Bot) handleAuditCommand(message types.PostedMessage, args []string) string {
+	helpText := "Usage: `!audit [limit] [user:<id>] [channel:<id>] [tool:<name>] [since:<24h|2006-01-02T15:04:05Z>]`"
+	if b.auditLog == nil {
+		return "The audit log is disabled (set `AUDIT_LOG_FILE`)."
+	}
+
+	filter := audit.Filter{Limit: defaultAuditLimit}
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, ":")
+		if !found {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 || n > 200 {
+				return helpText
+			}
+			filter.Limit = n
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "user":
+			filter.UserID = value
+		case "channel":
+			filter.ChannelID = value
+		case "tool":
+			filter.Tool = value
+		case "since":
+			t, err := parseSince(value)
+			if err != nil {
+				return err.Error()
+			}
+			filter.Since = t
+		default:
+			return helpText
+		}
+	}
+
+	entries, err := b.auditLog.Query(filter)
+	if err != nil {
+		return fmt.Sprintf("Failed to read the audit log: %v", err)
+	}
+	if len(entries) == 0 {
+		return "No matching tool calls."
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Last %d tool calls**\n\n| Time (UTC) | Tool | User | Channel | Result | Duration |\n|---|---|---|---|---|---|\n", len(entries)))
+	for _, entry := range entries {
+		user := entry.UserID
+		if user == "" {
+			user = "_none_"
+		} else if u, err := b.chat.GetUser(user); err == nil {
+			user = "@" + u.Username
+		}
+		channel := entry.ChannelID
+		if channel == "" {
+			channel = "_none_"
+		}
+		result := fmt.Sprintf("ok, %d bytes", entry.ResultBytes)
+		if !entry.Success {
+			result = "failed: " + truncateForTable(entry.Error, 60)
+		}
+		sb.WriteString(fmt.Sprintf("| %s | `%s` | %s | `%s` | %s | %dms |\n", entry.Time.UTC().Format("2006-01-02 15:04:05"), entry.Tool, user, channel, result, entry.DurationMS))
+	}
+	sb.WriteString("\nArguments are included in `GET /admin/audit`.")
+	return sb.String()
+}
+
+// truncateForTable shortens text and keeps it on one Markdown table row
+func truncateForTable(text string, max int) string {
+	text = strings.Join(strings.Fields(strings.ReplaceAll(text, "|", "/")), " ")
+	if len(text) > max {
+		return text[:max] + "…"
+	}
+	return text
+}
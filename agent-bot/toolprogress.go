@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"agent-bot/llms"
+)
+
+// toolStatuses label tool calls in the streaming placeholder, matched by the
+// first keyword the tool name contains
+var toolStatuses = []struct {
+	keyword string
+	status  string
+}{
+	{"web_search", "🔍 Searching the web…"},
+	{"fetch_url", "🌐 Reading the link…"},
+	{"asana", "📋 Fetching Asana tasks…"},
+	{"jira", "🎫 Checking Jira…"},
+	{"pagerduty", "🚨 Checking PagerDuty…"},
+	{"prometheus", "📈 Querying metrics…"},
+	{"kubernetes", "☸️ Checking Kubernetes…"},
+	{"wiki", "📚 Searching the wiki…"},
+	{"generate_image", "🎨 Generating an image…"},
+	{"render_diagram", "📊 Drawing a diagram…"},
+	{"memory", "🧠 Using my notes…"},
+	{"channel", "💬 Working on channels…"},
+}
+
+// toolStatus describes a tool call for users waiting on the reply
+func toolStatus(tool string) string {
+	for _, s := range toolStatuses {
+		if strings.Contains(tool, s.keyword) {
+			return s.status
+		}
+	}
+	return "⚙️ Running " + strings.ReplaceAll(tool, "_", " ") + "…"
+}
+
+// withToolProgress reports the tools the reply's request uses on the
+// returned channel, so the placeholder can say what the bot is doing.
+// Progress arriving faster than it is shown is dropped.
+func withToolProgress(ctx context.Context) (context.Context, <-chan string) {
+	progress := make(chan string, 8)
+	return llms.WithToolProgress(ctx, func(tool string) {
+		select {
+		case progress <- tool:
+		default:
+		}
+	}), progress
+}
@@ -0,0 +1,220 @@
+// Package tools is the registry of tools the model can call. Registry runs
+// each call with its timeout and, when the context carries a PII session,
+// restores placeholders in the input and redacts the result. Request carries
+// who asked and where through the context, and Selector narrows the tools
+// sent with a prompt to the most relevant ones.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"agent-bot/pii"
+
+	"github.com/invopop/jsonschema"
+)
+
+// Schema describes the JSON object a tool accepts as input
+type Schema struct {
+	Properties interface{} `json:"properties,omitempty"`
+	Required   []string    `json:"required,omitempty"`
+}
+
+// Handler executes a tool call with the model-provided JSON input
+type Handler func(ctx context.Context, input json.RawMessage) (interface{}, error)
+
+// Tool is a function the LLM can call
+type Tool struct {
+	Name        string
+	Description string
+	Schema      Schema
+	Handler     Handler
+	// Timeout bounds each call; zero uses the registry default
+	Timeout time.Duration
+}
+
+// TimeoutError is returned when a tool call outlives its deadline
+type TimeoutError struct {
+	Tool    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("tool %s timed out after %v", e.Tool, e.Timeout)
+}
+
+// Registry holds the tools available to LLM backends
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+	order []string
+
+	defaultTimeout time.Duration
+	timeouts       map[string]time.Duration // per-tool overrides from configuration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		tools: make(map[string]Tool),
+	}
+}
+
+// Register adds a tool, replacing any existing tool with the same name
+func (r *Registry) Register(tool Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tools[tool.Name]; !exists {
+		r.order = append(r.order, tool.Name)
+	}
+	r.tools[tool.Name] = tool
+}
+
+// Unregister removes a tool by name
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tools[name]; !exists {
+		return
+	}
+	delete(r.tools, name)
+	for i, n := range r.order {
+		if n == name {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Get returns a tool by name
+func (r *Registry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// List returns all tools in registration order
+func (r *Registry) List() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		list = append(list, r.tools[name])
+	}
+	return list
+}
+
+// SetTimeouts sets the deadline for tools without their own and per-tool
+// overrides that take precedence over Tool.Timeout. Zero disables the deadline.
+func (r *Registry) SetTimeouts(defaultTimeout time.Duration, overrides map[string]time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.defaultTimeout = defaultTimeout
+	r.timeouts = overrides
+}
+
+// TimeoutFor returns the deadline applied to calls of the named tool
+func (r *Registry) TimeoutFor(name string) time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if timeout, ok := r.timeouts[name]; ok {
+		return timeout
+	}
+	if tool, ok := r.tools[name]; ok && tool.Timeout > 0 {
+		return tool.Timeout
+	}
+	return r.defaultTimeout
+}
+
+// Execute runs the named tool with the given input. The handler's context is
+// cancelled when the tool's timeout elapses, and a *TimeoutError is returned
+// without waiting further for handlers that ignore cancellation. During
+// requests whose prompts had personal data redacted, the input gets the
+// original values back and the result goes back to the model redacted.
+func (r *Registry) Execute(ctx context.Context, name string, input json.RawMessage) (interface{}, error) {
+	session := pii.SessionFrom(ctx)
+	if session == nil {
+		return r.execute(ctx, name, input)
+	}
+	value, err := r.execute(ctx, name, session.RestoreJSON(input))
+	return session.RedactValue(value), err
+}
+
+func (r *Registry) execute(ctx context.Context, name string, input json.RawMessage) (interface{}, error) {
+	tool, ok := r.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown tool: %s", name)
+	}
+
+	timeout := r.TimeoutFor(name)
+	if timeout <= 0 {
+		return tool.Handler(ctx, input)
+	}
+
+	toolCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := tool.Handler(toolCtx, input)
+		done <- result{value, err}
+	}()
+
+	timedOut := func() bool {
+		return ctx.Err() == nil && errors.Is(toolCtx.Err(), context.DeadlineExceeded)
+	}
+
+	select {
+	case res := <-done:
+		if res.err != nil && timedOut() {
+			return nil, &TimeoutError{Tool: name, Timeout: timeout}
+		}
+		return res.value, res.err
+	case <-toolCtx.Done():
+		if timedOut() {
+			return nil, &TimeoutError{Tool: name, Timeout: timeout}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// SchemaFor reflects a Go struct into a tool input schema
+func SchemaFor[T any]() Schema {
+	reflector := jsonschema.Reflector{
+		AllowAdditionalProperties: false,
+		DoNotReference:            true,
+	}
+	var v T
+	schema := reflector.Reflect(v)
+	return Schema{
+		Properties: schema.Properties,
+		Required:   schema.Required,
+	}
+}
+
+// Typed adapts a function taking a decoded input struct into a Handler
+func Typed[T any](fn func(ctx context.Context, input T) (interface{}, error)) Handler {
+	return func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+		var input T
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &input); err != nil {
+				return nil, fmt.Errorf("invalid input: %w", err)
+			}
+		}
+		return fn(ctx, input)
+	}
+}
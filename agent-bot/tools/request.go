@@ -0,0 +1,24 @@
+package tools
+
+import "context"
+
+// Request identifies who triggered an LLM call and where, so tools can
+// apply permissions and post follow-ups in the right place
+type Request struct {
+	UserID    string
+	ChannelID string
+	ThreadID  string
+}
+
+type requestKey struct{}
+
+// WithRequest attaches request information to ctx
+func WithRequest(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFrom returns the request attached to ctx, if any
+func RequestFrom(ctx context.Context) (Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(Request)
+	return req, ok
+}
@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Embedder turns texts into vectors whose cosine similarity reflects relatedness
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// Selection is the outcome of preselecting tools for a request
+type Selection struct {
+	// Tools are the tools to offer the model
+	Tools []Tool
+	// Selected names the top-k tools by similarity, even when Shadow sends them all
+	Selected map[string]bool
+	// Rank is each tool's 1-based position by similarity
+	Rank map[string]int
+	// Shadow is set when every tool is sent so the selection can be scored
+	Shadow bool
+}
+
+// Selector picks the tools most likely to be relevant to a request so that
+// only their schemas are sent to the model
+type Selector struct {
+	embedder   Embedder
+	topK       int
+	sampleRate float64
+
+	mu    sync.Mutex
+	cache map[string][]float64 // tool name + description -> embedding
+}
+
+// NewSelector offers the topK most similar tools per request. A fraction
+// sampleRate of requests still sends every tool so accuracy can be measured.
+func NewSelector(embedder Embedder, topK int, sampleRate float64) *Selector {
+	return &Selector{
+		embedder:   embedder,
+		topK:       topK,
+		sampleRate: sampleRate,
+		cache:      make(map[string][]float64),
+	}
+}
+
+// Select ranks tools by similarity to query and returns the ones to offer.
+// With no more than topK tools everything is offered without embedding.
+func (s *Selector) Select(ctx context.Context, query string, tools []Tool) (Selection, error) {
+	if len(tools) <= s.topK {
+		return Selection{Tools: tools}, nil
+	}
+
+	vectors, err := s.toolVectors(ctx, tools)
+	if err != nil {
+		return Selection{Tools: tools}, err
+	}
+	queryVectors, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil || len(queryVectors) != 1 {
+		return Selection{Tools: tools}, fmt.Errorf("failed to embed request: %w", err)
+	}
+
+	type scored struct {
+		tool  Tool
+		score float64
+	}
+	ranked := make([]scored, len(tools))
+	for i, tool := range tools {
+		ranked[i] = scored{tool: tool, score: cosine(queryVectors[0], vectors[i])}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	selection := Selection{Selected: make(map[string]bool), Rank: make(map[string]int)}
+	for i, r := range ranked {
+		selection.Rank[r.tool.Name] = i + 1
+		if i < s.topK {
+			selection.Selected[r.tool.Name] = true
+			selection.Tools = append(selection.Tools, r.tool)
+		}
+	}
+
+	if s.sampleRate > 0 && rand.Float64() < s.sampleRate {
+		selection.Shadow = true
+		selection.Tools = tools
+	}
+	return selection, nil
+}
+
+// toolVectors embeds each tool's name and description, reusing cached vectors
+func (s *Selector) toolVectors(ctx context.Context, tools []Tool) ([][]float64, error) {
+	texts := make([]string, len(tools))
+	vectors := make([][]float64, len(tools))
+	var missing []int
+
+	s.mu.Lock()
+	for i, tool := range tools {
+		texts[i] = tool.Name + ": " + tool.Description
+		if vector, ok := s.cache[texts[i]]; ok {
+			vectors[i] = vector
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	batch := make([]string, len(missing))
+	for j, i := range missing {
+		batch[j] = texts[i]
+	}
+	embedded, err := s.embedder.Embed(ctx, batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed tools: %w", err)
+	}
+	if len(embedded) != len(batch) {
+		return nil, fmt.Errorf("embedder returned %d vectors for %d tools", len(embedded), len(batch))
+	}
+
+	s.mu.Lock()
+	for j, i := range missing {
+		vectors[i] = embedded[j]
+		s.cache[texts[i]] = embedded[j]
+	}
+	s.mu.Unlock()
+
+	return vectors, nil
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
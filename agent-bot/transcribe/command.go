@@ -0,0 +1,65 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FilePlaceholder is replaced with the audio file's path in a Command's arguments
+const FilePlaceholder = "{file}"
+
+// Command transcribes with a local program such as whisper.cpp's whisper-cli.
+// The audio is written to a temporary file whose path replaces {file} in the
+// arguments, and the program's standard output is the transcript.
+type Command struct {
+	Path string
+	Args []string
+}
+
+// NewCommand parses a command line like "whisper-cli -m model.bin -nt -f {file}".
+// Arguments are split on whitespace; there is no shell quoting.
+func NewCommand(commandLine string) (*Command, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("transcription command is empty")
+	}
+	if !strings.Contains(commandLine, FilePlaceholder) {
+		return nil, fmt.Errorf("transcription command must contain %s where the audio file goes", FilePlaceholder)
+	}
+	return &Command{Path: fields[0], Args: fields[1:]}, nil
+}
+
+// Transcribe runs the command on the audio and returns what it printed
+func (c *Command) Transcribe(ctx context.Context, filename string, data []byte) (string, error) {
+	// Keep the extension; transcribers pick the decoder by it
+	file, err := os.CreateTemp("", "agent-bot-audio-*"+filepath.Ext(filename))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to write %s: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", file.Name(), err)
+	}
+
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = strings.ReplaceAll(arg, FilePlaceholder, file.Name())
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s failed: %w: %s", c.Path, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,96 @@
+// Package transcribe turns voice messages and audio attachments into text.
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// DefaultOpenAIURL is the base URL of the OpenAI API
+const DefaultOpenAIURL = "https://api.openai.com/v1"
+
+// OpenAI transcribes with the /audio/transcriptions endpoint of the OpenAI
+// API, or of a local server offering the same API such as a whisper.cpp or
+// faster-whisper server
+type OpenAI struct {
+	BaseURL    string
+	APIKey     string
+	Model      string
+	HTTPClient *http.Client
+}
+
+func NewOpenAI(baseURL, apiKey, model string, httpClient *http.Client) *OpenAI {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIURL
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &OpenAI{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		Model:      model,
+		HTTPClient: httpClient,
+	}
+}
+
+type openAIResponse struct {
+	Text string `json:"text"`
+}
+
+// Transcribe uploads the audio file and returns its text
+func (o *OpenAI) Transcribe(ctx context.Context, filename string, data []byte) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("model", o.Model); err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	if err := form.WriteField("response_format", "json"); err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.BaseURL+"/audio/transcriptions", &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcription API error %d: %s", resp.StatusCode, string(raw))
+	}
+
+	var parsed openAIResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return strings.TrimSpace(parsed.Text), nil
+}
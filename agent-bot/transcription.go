@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/metrics"
+	"agent-bot/reqid"
+	"agent-bot/transcribe"
+	"agent-bot/types"
+)
+
+// Transcription providers
+const (
+	transcriptionOff     = "off"
+	transcriptionOpenAI  = "openai"
+	transcriptionCommand = "command"
+)
+
+// transcriptionTimeout bounds transcribing one audio file
+const transcriptionTimeout = 2 * time.Minute
+
+// transcriptCacheSize is how many transcripts are kept for thread history
+const transcriptCacheSize = 500
+
+// Transcriber turns an audio file into text
+type Transcriber interface {
+	Transcribe(ctx context.Context, filename string, data []byte) (string, error)
+}
+
+// newTranscriber builds the transcriber for TRANSCRIPTION_PROVIDER, or nil when it is off
+func newTranscriber(config Config) (Transcriber, error) {
+	switch config.TranscriptionProvider {
+	case transcriptionOff:
+		return nil, nil
+	case transcriptionOpenAI:
+		return transcribe.NewOpenAI(config.TranscriptionAPIURL, config.TranscriptionAPIKey, config.TranscriptionModel, &http.Client{Timeout: transcriptionTimeout}), nil
+	case transcriptionCommand:
+		if config.TranscriptionCommand == "" {
+			return nil, fmt.Errorf("TRANSCRIPTION_PROVIDER=command requires TRANSCRIPTION_COMMAND")
+		}
+		return transcribe.NewCommand(config.TranscriptionCommand)
+	default:
+		return nil, fmt.Errorf("unknown TRANSCRIPTION_PROVIDER %q (use off, openai or command)", config.TranscriptionProvider)
+	}
+}
+
+// transcribeAudio adds the transcript of the message's voice messages and
+// audio attachments to its text, so the rest of the pipeline treats a voice
+// message like a typed one. Only messages the bot may answer are
+// transcribed; the rest of the channel's audio is left alone.
+func (a *BotAgent) transcribeAudio(ctx context.Context, message types.PostedMessage) types.PostedMessage {
+	if a.transcriber == nil || len(message.FileIds) == 0 {
+		return message
+	}
+	if !a.isAddressed(message) && !a.isActiveThread(message.ThreadId) {
+		return message
+	}
+
+	audio, err := a.chat.GetAudio(message.FileIds)
+	if err != nil {
+		reqid.Logf(ctx, "WARNING: Failed to load audio attachments: %v", err)
+	}
+	var transcripts []string
+	for _, file := range audio {
+		started := time.Now()
+		fileCtx, cancel := context.WithTimeout(ctx, transcriptionTimeout)
+		text, err := a.transcriber.Transcribe(fileCtx, file.Name, file.Data)
+		cancel()
+		if err != nil {
+			metrics.Inc("transcriptions_total", "status", "error")
+			reqid.Logf(ctx, "TRANSCRIBE: Failed to transcribe %s: %v", file.Name, err)
+			continue
+		}
+		metrics.Inc("transcriptions_total", "status", "ok")
+		reqid.Logf(ctx, "TRANSCRIBE: Transcribed %s (%d bytes) in %v", file.Name, len(file.Data), time.Since(started).Round(time.Millisecond))
+		if text != "" {
+			transcripts = append(transcripts, text)
+		}
+	}
+	if len(transcripts) == 0 {
+		return message
+	}
+
+	transcript := formatTranscript(transcripts)
+	a.transcripts.put(message.PostId, transcript)
+	message.Message = strings.TrimSpace(message.Message + "\n\n" + transcript)
+	return message
+}
+
+// formatTranscript labels transcribed text so the model knows it was spoken
+func formatTranscript(transcripts []string) string {
+	lines := make([]string, len(transcripts))
+	for i, text := range transcripts {
+		lines[i] = "[Voice message] " + text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withTranscript returns p with the transcript of its audio, if it had any
+// that was transcribed, so earlier voice messages stay in the thread history
+func (a *BotAgent) withTranscript(p *types.Message) *types.Message {
+	transcript, ok := a.transcripts.get(p.ID)
+	if !ok {
+		return p
+	}
+	copied := *p
+	copied.Content = strings.TrimSpace(copied.Content + "\n\n" + transcript)
+	return &copied
+}
+
+// transcriptCache keeps the latest transcripts by post ID, in memory only
+type transcriptCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+	order   []string
+	next    int
+}
+
+func newTranscriptCache(size int) *transcriptCache {
+	return &transcriptCache{entries: make(map[string]string), order: make([]string, 0, size)}
+}
+
+func (c *transcriptCache) put(postID, transcript string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[postID]; ok {
+		c.entries[postID] = transcript
+		return
+	}
+	if len(c.order) < cap(c.order) {
+		c.order = append(c.order, postID)
+	} else {
+		delete(c.entries, c.order[c.next])
+		c.order[c.next] = postID
+		c.next = (c.next + 1) % len(c.order)
+	}
+	c.entries[postID] = transcript
+}
+
+func (c *transcriptCache) get(postID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	transcript, ok := c.entries[postID]
+	return transcript, ok
+}
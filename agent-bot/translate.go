@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"agent-bot/language"
+	"agent-bot/prompts"
+	"agent-bot/reqid"
+	"agent-bot/types"
+)
+
+// translateRequestPattern recognizes "translate to Spanish", "translate this thread into
+// French please" and similar; the last group is the language
+var translateRequestPattern = regexp.MustCompile(`(?i)^(please\s+|can you\s+|could you\s+)?translate(\s+(this|the))?(\s+(thread|conversation|discussion))?\s+(to|into|in)\s+(.+?)(\s+please)?[.!?]*$`)
+
+// translateRequest returns the language a message addressed to the bot asks
+// to translate the thread into, or "" when it isn't a translate request
+func (a *BotAgent) translateRequest(message types.PostedMessage) string {
+	text := strings.ReplaceAll(message.Message, "@"+a.botUsername, "")
+	match := translateRequestPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return ""
+	}
+	return language.Name(match[7])
+}
+
+// translateThread posts a translation of the thread the message was sent in.
+// Long threads are cut to their most recent posts.
+func (a *BotAgent) translateThread(ctx context.Context, message types.PostedMessage, into string) {
+	if message.ThreadId == "" {
+		a.postNotice(ctx, types.PostedMessage{UserId: message.UserId, ChannelId: message.ChannelId, ThreadId: message.PostId}, "Ask me to translate from inside a thread and I'll translate the whole conversation.")
+		return
+	}
+
+	a.sendTypingIndicator(message.ChannelId, message.ThreadId)
+
+	history, users, err := a.loadThread(message.ThreadId, message.PostId, "")
+	if err != nil {
+		reqid.Logf(ctx, "TRANSLATE: Failed to load thread %s: %v", message.ThreadId, err)
+		a.postNotice(ctx, message, "Sorry, I couldn't load this thread to translate it.")
+		return
+	}
+
+	// Keep the most recent posts that fit one request
+	var lines []string
+	tokens := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		line := a.formatPost(history[i], users)
+		tokens += estimateTokens(line)
+		if len(lines) > 0 && tokens > summaryChunkTokens {
+			break
+		}
+		lines = append(lines, line)
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	data := prompts.ThreadTranslateData{Language: into, Transcript: strings.Join(lines, ""), Omitted: len(history) - len(lines)}
+	prompt, err := a.prompts.Render(prompts.ThreadTranslate, data)
+	if err != nil {
+		reqid.Logf(ctx, "TRANSLATE: Failed to render translate prompt: %v", err)
+		a.postNotice(ctx, message, "Sorry, I couldn't translate this thread.")
+		return
+	}
+
+	reqid.Logf(ctx, "TRANSLATE: Translating thread %s into %s (%d posts, %d left out)", message.ThreadId, into, len(lines), data.Omitted)
+	a.respondWithStream(ctx, message, prompt)
+}
@@ -0,0 +1,93 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Attachment is a structured card, modelled on Mattermost and Slack message
+// attachments
+type Attachment struct {
+	// Fallback is the plain text shown in notifications
+	Fallback string
+	// Color is the hex color of the card's edge, e.g. "#2eb886"
+	Color      string
+	Pretext    string
+	AuthorName string
+	AuthorLink string
+	AuthorIcon string
+	Title      string
+	TitleLink  string
+	Text       string
+	Fields     []AttachmentField
+	Footer     string
+	Buttons    []LinkButton
+	// Actions are buttons that call back into the bot; chats without them
+	// leave them out
+	Actions []ActionButton
+}
+
+// AttachmentField is a labelled value on a card; short fields sit side by side
+type AttachmentField struct {
+	Title string
+	Value string
+	Short bool
+}
+
+// LinkButton is a card button that opens a URL
+type LinkButton struct {
+	Label string
+	URL   string
+}
+
+// ActionButton is a card button that posts its context to URL when clicked
+type ActionButton struct {
+	ID    string
+	Label string
+	// Style is default, primary, success, good, warning or danger
+	Style   string
+	URL     string
+	Context map[string]interface{}
+}
+
+// Markdown renders the card as text, for chats without cards
+func (a Attachment) Markdown() string {
+	var b strings.Builder
+	line := func(s string) {
+		if s != "" {
+			b.WriteString(s + "\n")
+		}
+	}
+	line(a.Pretext)
+	if a.AuthorName != "" {
+		line("_" + markdownLink(a.AuthorName, a.AuthorLink) + "_")
+	}
+	if a.Title != "" {
+		line("**" + markdownLink(a.Title, a.TitleLink) + "**")
+	}
+	line(a.Text)
+	for _, field := range a.Fields {
+		line(fmt.Sprintf("**%s:** %s", field.Title, field.Value))
+	}
+	line(a.ButtonLinks())
+	if a.Footer != "" {
+		line("_" + a.Footer + "_")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// ButtonLinks renders the buttons as Markdown links separated by dots
+func (a Attachment) ButtonLinks() string {
+	links := make([]string, 0, len(a.Buttons))
+	for _, button := range a.Buttons {
+		links = append(links, markdownLink(button.Label, button.URL))
+	}
+	return strings.Join(links, " · ")
+}
+
+func markdownLink(text, url string) string {
+	if url == "" {
+		return text
+	}
+	return "[" + text + "](" + url + ")"
+}
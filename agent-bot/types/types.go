@@ -1,6 +1,15 @@
+// Package types defines the interfaces between the agent and the rest of the
+// bot: Agent handles chat events, Chat is a platform-neutral chat API and
+// LLM is a language model.
 package types
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrMessageDeleted is returned by Chat.UpdateMessage when the target message no longer exists
+var ErrMessageDeleted = errors.New("message was deleted")
 
 // Message represents a generic chat message
 type Message struct {
@@ -19,6 +28,20 @@ type User struct {
 	IsBot    bool
 }
 
+// Image is an image attachment passed to the LLM
+type Image struct {
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
+// Audio is a voice message or audio attachment to be transcribed
+type Audio struct {
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
 // PostedMessage represents an incoming message event
 type PostedMessage struct {
 	PostId    string
@@ -27,11 +50,50 @@ type PostedMessage struct {
 	ChannelId string
 	Message   string
 	IsDM      bool
+	FileIds   []string
+	// Mentioned is set when the chat reports that the message mentions the bot
+	Mentioned bool
 }
 
 // Agent handles incoming messages
 type Agent interface {
 	MessagePosted(message PostedMessage)
+
+	// MessageEdited and MessageDeleted report changes to the bot's own messages
+	MessageEdited(messageID, content string)
+	MessageDeleted(messageID string)
+
+	// ReactionAdded reports an emoji reaction someone else added to any message
+	ReactionAdded(reaction Reaction)
+
+	// ChannelJoined reports that the bot was added to a channel
+	ChannelJoined(channelID string)
+}
+
+// Channel describes a chat channel
+type Channel struct {
+	ID   string
+	Name string
+	// DisplayName is the name shown to users, which may differ from Name
+	DisplayName string
+	// Purpose and Header (a Slack topic, a Discord topic) may be empty
+	Purpose string
+	Header  string
+	// Team is the name of the channel's team, empty for direct messages and
+	// on platforms without teams
+	Team string
+	// Private is set for channels not open to everyone in the team,
+	// including direct and group messages
+	Private bool
+}
+
+// Reaction is an emoji reaction added to a message
+type Reaction struct {
+	PostId    string
+	UserId    string
+	ChannelId string
+	// Emoji is the Mattermost/Slack emoji name, e.g. "repeat"
+	Emoji string
 }
 
 // ChatMessage represents an outgoing message
@@ -39,6 +101,19 @@ type ChatMessage struct {
 	ThreadId  string
 	ChannelId string
 	Message   string
+	// Attachments are shown as cards below the message, or as text where the
+	// chat has no cards. Only PostMessage sends them; updates keep them.
+	Attachments []Attachment
+}
+
+// FileUpload is a file attached to a new message
+type FileUpload struct {
+	ThreadId  string
+	ChannelId string
+	Filename  string
+	Data      []byte
+	// Message is posted with the file and may be empty
+	Message string
 }
 
 // StreamChunk represents a piece of streaming response
@@ -50,7 +125,8 @@ type StreamChunk struct {
 
 // Chat provides generic chat platform operations
 type Chat interface {
-	// Send a message and return the message ID
+	// Send a message and return the ID of the created post. Streaming
+	// responses update this exact post, so it must never be guessed.
 	PostMessage(message ChatMessage) (string, error)
 
 	// Update an existing message
@@ -67,12 +143,34 @@ type Chat interface {
 
 	// Get user information
 	GetUser(userID string) (*User, error)
+
+	// Get a channel's name, purpose and header
+	GetChannel(channelID string) (*Channel, error)
+
+	// Download the image attachments among fileIDs; other files are skipped
+	GetImages(fileIDs []string) ([]Image, error)
+
+	// Download the voice messages and audio attachments among fileIDs; other
+	// files are skipped
+	GetAudio(fileIDs []string) ([]Audio, error)
+
+	// Post a message with a file attached, in the thread when ThreadId is set
+	UploadFile(upload FileUpload) error
+
+	// Post a message only userID can see, such as an error or an admin
+	// command's reply. It isn't stored, so it has no ID and can't be updated.
+	PostEphemeral(userID string, message ChatMessage) error
+
+	// Add or remove the bot's reaction to a message. Emoji are named as in
+	// Mattermost and Slack, e.g. "eyes" or "white_check_mark".
+	AddReaction(messageID, emoji string) error
+	RemoveReaction(messageID, emoji string) error
 }
 
 // LLM provides language model operations
 type LLM interface {
-	// Synchronous prompt
-	Prompt(message string) (string, error)
+	// Synchronous prompt; ctx carries the request's history, tools and cancellation
+	Prompt(ctx context.Context, message string) (string, error)
 
 	// Streaming prompt - returns a channel of chunks
 	PromptStream(ctx context.Context, message string) (<-chan StreamChunk, error)
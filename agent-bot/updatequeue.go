@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+
+	"agent-bot/metrics"
+)
+
+// pendingUpdate is the newest content waiting to be written to a post
+type pendingUpdate struct {
+	content string
+	waiters []chan error
+	running bool
+}
+
+// updateQueue serializes updates per post and coalesces them: while one
+// write is in flight (possibly held back by rate limiting), later updates
+// replace each other and only the newest content is sent next.
+type updateQueue struct {
+	mu      sync.Mutex
+	pending map[string]*pendingUpdate
+}
+
+func newUpdateQueue() *updateQueue {
+	return &updateQueue{pending: make(map[string]*pendingUpdate)}
+}
+
+// submit queues content for postID and blocks until it, or newer content
+// that superseded it, has been written with write
+func (q *updateQueue) submit(postID, content string, write func(postID, content string) error) error {
+	done := make(chan error, 1)
+
+	q.mu.Lock()
+	p := q.pending[postID]
+	if p == nil {
+		p = &pendingUpdate{}
+		q.pending[postID] = p
+	}
+	p.content = content
+	p.waiters = append(p.waiters, done)
+	worker := !p.running
+	p.running = true
+	q.mu.Unlock()
+
+	// The first caller for a post writes on behalf of everyone queued behind it
+	if worker {
+		q.drain(postID, p, write)
+	}
+	return <-done
+}
+
+func (q *updateQueue) drain(postID string, p *pendingUpdate, write func(postID, content string) error) {
+	for {
+		q.mu.Lock()
+		if len(p.waiters) == 0 {
+			p.running = false
+			delete(q.pending, postID)
+			q.mu.Unlock()
+			return
+		}
+		content, waiters := p.content, p.waiters
+		p.waiters = nil
+		q.mu.Unlock()
+
+		if len(waiters) > 1 {
+			metrics.Add("mattermost_updates_coalesced_total", float64(len(waiters)-1))
+		}
+
+		err := write(postID, content)
+		for _, waiter := range waiters {
+			waiter <- err
+		}
+	}
+}
+
+// depth is the number of posts with updates waiting or being written
+func (q *updateQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
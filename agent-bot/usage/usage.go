@@ -0,0 +1,404 @@
+// Package usage accounts for model tokens, tool calls and their cost per
+// month and per day, by user, channel and team. Totals live in the state
+// store, and Flush adds what was recorded since the last flush, so replicas
+// sharing a store merge their counts instead of overwriting them.
+package usage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/store"
+	"agent-bot/tools"
+)
+
+const bucketPrefix = "usage:"
+
+// dailyBucket keeps each user's and channel's usage per day, for quotas
+const dailyBucket = "usage_daily"
+
+// dailyRetention is how many days of daily usage are kept
+const dailyRetention = 7
+
+// Scope is what daily usage is kept for
+type Scope string
+
+const (
+	ScopeUser    Scope = "user"
+	ScopeChannel Scope = "channel"
+)
+
+// Daily is one user's or channel's LLM usage on one UTC day
+type Daily struct {
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// Tokens returns the input and output tokens together
+func (d Daily) Tokens() int64 {
+	return d.InputTokens + d.OutputTokens
+}
+
+func dailyKey(day string, scope Scope, id string) string {
+	return day + "|" + string(scope) + "|" + id
+}
+
+// Price is the cost of a model in USD per million tokens
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// DefaultPrices covers the models configured by default
+var DefaultPrices = map[string]Price{
+	"claude-opus-4-20250514":    {Input: 15, Output: 75},
+	"claude-sonnet-4-20250514":  {Input: 3, Output: 15},
+	"claude-3-7-sonnet-latest":  {Input: 3, Output: 15},
+	"claude-haiku-3.5-20241022": {Input: 0.8, Output: 4},
+	"claude-3-5-haiku-latest":   {Input: 0.8, Output: 4},
+}
+
+// Row is aggregated usage for one team/channel/user/model/tool combination in a month
+type Row struct {
+	Month        string  `json:"month"`
+	TeamID       string  `json:"team_id"`
+	ChannelID    string  `json:"channel_id"`
+	UserID       string  `json:"user_id"`
+	Model        string  `json:"model"`
+	Tool         string  `json:"tool"`
+	Requests     int64   `json:"requests"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+func (r *Row) key() string {
+	return strings.Join([]string{r.TeamID, r.ChannelID, r.UserID, r.Model, r.Tool}, "|")
+}
+
+// TeamResolver maps a channel to its team
+type TeamResolver func(channelID string) string
+
+// Tracker aggregates usage per month in memory and periodically flushes it to the store
+type Tracker struct {
+	store       *store.Store
+	prices      map[string]Price
+	resolveTeam TeamResolver
+
+	mu     sync.Mutex
+	months map[string]map[string]*Row
+	dirty  map[string]bool
+	// daily usage for the days loaded so far, by dailyKey
+	days       map[string]*Daily
+	loadedDays map[string]bool
+	dirtyDays  map[string]bool
+	// what the store held after the last load or flush, so a flush adds only
+	// what was recorded since to the stored values
+	flushedRows map[string]map[string]Row
+	flushedDays map[string]Daily
+}
+
+func NewTracker(s *store.Store, prices map[string]Price, resolveTeam TeamResolver) *Tracker {
+	merged := make(map[string]Price, len(DefaultPrices)+len(prices))
+	for model, price := range DefaultPrices {
+		merged[model] = price
+	}
+	for model, price := range prices {
+		merged[model] = price
+	}
+
+	return &Tracker{
+		store:       s,
+		prices:      merged,
+		resolveTeam: resolveTeam,
+		months:      make(map[string]map[string]*Row),
+		dirty:       make(map[string]bool),
+		days:        make(map[string]*Daily),
+		loadedDays:  make(map[string]bool),
+		dirtyDays:   make(map[string]bool),
+		flushedRows: make(map[string]map[string]Row),
+		flushedDays: make(map[string]Daily),
+	}
+}
+
+// RecordLLM adds one LLM API call attributed to the request in ctx
+func (t *Tracker) RecordLLM(ctx context.Context, model string, inputTokens, outputTokens int64) {
+	price := t.prices[model]
+	cost := (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1_000_000
+
+	t.add(ctx, Row{
+		Model:        model,
+		Requests:     1,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		CostUSD:      cost,
+	})
+}
+
+// RecordTool adds count invocations of a tool attributed to the request in ctx
+func (t *Tracker) RecordTool(ctx context.Context, model, tool string, count int64) {
+	t.add(ctx, Row{Model: model, Tool: tool, Requests: count})
+}
+
+func (t *Tracker) add(ctx context.Context, row Row) {
+	if req, ok := tools.RequestFrom(ctx); ok {
+		row.UserID = req.UserID
+		row.ChannelID = req.ChannelID
+		if req.ChannelID != "" && t.resolveTeam != nil {
+			row.TeamID = t.resolveTeam(req.ChannelID)
+		}
+	}
+	now := time.Now().UTC()
+	row.Month = now.Format("2006-01")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	day := now.Format("2006-01-02")
+	t.addDailyLocked(day, ScopeUser, row.UserID, row)
+	t.addDailyLocked(day, ScopeChannel, row.ChannelID, row)
+
+	rows := t.loadLocked(row.Month)
+	existing, ok := rows[row.key()]
+	if !ok {
+		copied := row
+		rows[row.key()] = &copied
+	} else {
+		existing.Requests += row.Requests
+		existing.InputTokens += row.InputTokens
+		existing.OutputTokens += row.OutputTokens
+		existing.CostUSD += row.CostUSD
+	}
+	t.dirty[row.Month] = true
+}
+
+func (t *Tracker) addDailyLocked(day string, scope Scope, id string, row Row) {
+	if id == "" {
+		return
+	}
+	t.loadDayLocked(day)
+	key := dailyKey(day, scope, id)
+	daily, ok := t.days[key]
+	if !ok {
+		daily = &Daily{}
+		t.days[key] = daily
+	}
+	daily.InputTokens += row.InputTokens
+	daily.OutputTokens += row.OutputTokens
+	daily.CostUSD += row.CostUSD
+	t.dirtyDays[key] = true
+}
+
+// Today returns a user's or channel's usage so far today (UTC)
+func (t *Tracker) Today(scope Scope, id string) Daily {
+	day := time.Now().UTC().Format("2006-01-02")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.loadDayLocked(day)
+	if daily, ok := t.days[dailyKey(day, scope, id)]; ok {
+		return *daily
+	}
+	return Daily{}
+}
+
+// loadDayLocked loads a day's usage from the store on first use
+func (t *Tracker) loadDayLocked(day string) {
+	if t.loadedDays[day] {
+		return
+	}
+	for _, key := range t.store.Keys(dailyBucket) {
+		if !strings.HasPrefix(key, day+"|") {
+			continue
+		}
+		var daily Daily
+		if found, err := t.store.Get(dailyBucket, key, &daily); err == nil && found {
+			t.days[key] = &daily
+			t.flushedDays[key] = daily
+		}
+	}
+	t.loadedDays[day] = true
+}
+
+// Month returns the aggregated rows for a month ("2006-01"), sorted for stable exports
+func (t *Tracker) Month(month string) []Row {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rows := t.loadLocked(month)
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].key() < result[j].key() })
+	return result
+}
+
+// loadLocked returns the in-memory rows for month, loading them from the store on first use
+func (t *Tracker) loadLocked(month string) map[string]*Row {
+	if rows, ok := t.months[month]; ok {
+		return rows
+	}
+
+	rows := make(map[string]*Row)
+	flushed := make(map[string]Row)
+	for _, key := range t.store.Keys(bucketPrefix + month) {
+		var row Row
+		if found, err := t.store.Get(bucketPrefix+month, key, &row); err == nil && found {
+			rows[key] = &row
+			flushed[key] = row
+		}
+	}
+	t.months[month] = rows
+	t.flushedRows[month] = flushed
+	return rows
+}
+
+// Flush persists months with unsaved usage. What was recorded since the last
+// flush is added to the stored values rather than replacing them, so replicas
+// sharing a store (see DISTRIBUTED_MODE) each add their own usage.
+func (t *Tracker) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for month := range t.dirty {
+		for key, row := range t.months[month] {
+			base := t.flushedRows[month][key]
+			var stored Row
+			if _, err := t.store.Get(bucketPrefix+month, key, &stored); err != nil {
+				return err
+			}
+			merged := *row
+			merged.Requests = stored.Requests + row.Requests - base.Requests
+			merged.InputTokens = stored.InputTokens + row.InputTokens - base.InputTokens
+			merged.OutputTokens = stored.OutputTokens + row.OutputTokens - base.OutputTokens
+			merged.CostUSD = stored.CostUSD + row.CostUSD - base.CostUSD
+			if err := t.store.Put(bucketPrefix+month, key, merged); err != nil {
+				return err
+			}
+			*row = merged
+			t.flushedRows[month][key] = merged
+		}
+		delete(t.dirty, month)
+	}
+
+	for key := range t.dirtyDays {
+		daily, base := t.days[key], t.flushedDays[key]
+		var stored Daily
+		if _, err := t.store.Get(dailyBucket, key, &stored); err != nil {
+			return err
+		}
+		merged := Daily{
+			InputTokens:  stored.InputTokens + daily.InputTokens - base.InputTokens,
+			OutputTokens: stored.OutputTokens + daily.OutputTokens - base.OutputTokens,
+			CostUSD:      stored.CostUSD + daily.CostUSD - base.CostUSD,
+		}
+		if err := t.store.Put(dailyBucket, key, merged); err != nil {
+			return err
+		}
+		*daily = merged
+		t.flushedDays[key] = merged
+		delete(t.dirtyDays, key)
+	}
+	// Days older than the retention are only needed for the monthly rows
+	cutoff := time.Now().UTC().AddDate(0, 0, -dailyRetention).Format("2006-01-02")
+	for _, key := range t.store.Keys(dailyBucket) {
+		if key < cutoff {
+			t.store.Delete(dailyBucket, key)
+		}
+	}
+	for key := range t.days {
+		if key < cutoff {
+			delete(t.days, key)
+			delete(t.flushedDays, key)
+		}
+	}
+	return nil
+}
+
+// Run flushes periodically until ctx is done, then flushes one last time
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				log.Printf("[%s] USAGE: Failed to flush usage: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+			}
+		case <-ctx.Done():
+			t.Flush()
+			return
+		}
+	}
+}
+
+// WriteCSV writes rows as CSV with a header line
+func WriteCSV(w io.Writer, rows []Row) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"month", "team_id", "channel_id", "user_id", "model", "tool", "requests", "input_tokens", "output_tokens", "cost_usd"})
+	for _, row := range rows {
+		writer.Write([]string{
+			row.Month,
+			row.TeamID,
+			row.ChannelID,
+			row.UserID,
+			row.Model,
+			row.Tool,
+			strconv.FormatInt(row.Requests, 10),
+			strconv.FormatInt(row.InputTokens, 10),
+			strconv.FormatInt(row.OutputTokens, 10),
+			strconv.FormatFloat(row.CostUSD, 'f', 6, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON writes rows as a JSON array
+func WriteJSON(w io.Writer, rows []Row) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+// Totals sums LLM requests, tokens and cost over rows; tool invocation rows
+// only contribute their cost
+func Totals(rows []Row) Row {
+	var total Row
+	for _, row := range rows {
+		if row.Tool == "" {
+			total.Requests += row.Requests
+		}
+		total.InputTokens += row.InputTokens
+		total.OutputTokens += row.OutputTokens
+		total.CostUSD += row.CostUSD
+	}
+	return total
+}
+
+// PreviousMonth returns the month before now in "2006-01" form
+func PreviousMonth(now time.Time) string {
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return firstOfMonth.AddDate(0, -1, 0).Format("2006-01")
+}
+
+// ValidMonth reports whether month is in "2006-01" form
+func ValidMonth(month string) error {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return fmt.Errorf("month must look like 2006-01")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-bot/types"
+	"agent-bot/usage"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// usageExportsBucket records which months were already posted to the finance channel
+const usageExportsBucket = "usage_exports"
+
+// channelTeams caches channel to team lookups for usage attribution
+type channelTeams struct {
+	client *model.Client4
+
+	mu    sync.Mutex
+	teams map[string]string
+}
+
+func (c *channelTeams) resolve(channelID string) string {
+	c.mu.Lock()
+	teamID, ok := c.teams[channelID]
+	c.mu.Unlock()
+	if ok {
+		return teamID
+	}
+
+	channel, _, err := c.client.GetChannel(channelID, "")
+	if err != nil {
+		log.Printf("[%s] USAGE: Failed to resolve team for channel %s: %v", time.Now().Format("2006-01-02 15:04:05"), channelID, err)
+		return ""
+	}
+
+	// DMs and group messages have no team; they are cached as such
+	c.mu.Lock()
+	c.teams[channelID] = channel.TeamId
+	c.mu.Unlock()
+	return channel.TeamId
+}
+
+// handleAdminUsage serves GET /admin/usage?month=2006-01&format=csv|json,
+// authenticated with ADMIN_API_TOKEN
+func (b *Bot) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAdmin(w, r) {
+		return
+	}
+
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	}
+	if err := usage.ValidMonth(month); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	rows := b.usage.Month(month)
+	log.Printf("[%s] USAGE: Exporting %d rows for %s via admin API", time.Now().Format("2006-01-02 15:04:05"), len(rows), month)
+
+	switch r.URL.Query().Get("format") {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "usage-"+month+".csv"))
+		usage.WriteCSV(w, rows)
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		usage.WriteJSON(w, rows)
+	default:
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "format must be csv or json"})
+	}
+}
+
+// postUsageExport uploads the month's usage as a CSV attachment to a channel
+func (b *Bot) postUsageExport(channelID, month string) error {
+	rows := b.usage.Month(month)
+
+	var buf bytes.Buffer
+	if err := usage.WriteCSV(&buf, rows); err != nil {
+		return fmt.Errorf("failed to render CSV: %v", err)
+	}
+
+	upload, _, err := b.client.UploadFile(buf.Bytes(), channelID, "usage-"+month+".csv")
+	if err != nil {
+		return fmt.Errorf("failed to upload export: %v", err)
+	}
+
+	var fileIDs model.StringArray
+	for _, info := range upload.FileInfos {
+		fileIDs = append(fileIDs, info.Id)
+	}
+
+	total := usage.Totals(rows)
+	_, _, err = b.client.CreatePost(&model.Post{
+		ChannelId: channelID,
+		Message:   fmt.Sprintf("**LLM usage for %s:** %d requests, %d input / %d output tokens, $%.2f", month, total.Requests, total.InputTokens, total.OutputTokens, total.CostUSD),
+		FileIds:   fileIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to post export: %v", err)
+	}
+	return nil
+}
+
+// runMonthlyUsageExport posts the previous month's usage to the finance channel
+// once the month has rolled over
+func (b *Bot) runMonthlyUsageExport() {
+	if b.config.UsageExportChannel == "" {
+		return
+	}
+
+	check := func() {
+		month := usage.PreviousMonth(time.Now().UTC())
+		var exported bool
+		if found, err := b.store.Get(usageExportsBucket, month, &exported); err == nil && found && exported {
+			return
+		}
+
+		if err := b.postUsageExport(b.config.UsageExportChannel, month); err != nil {
+			log.Printf("[%s] USAGE: Monthly export for %s failed: %v", time.Now().Format("2006-01-02 15:04:05"), month, err)
+			return
+		}
+		if err := b.store.Put(usageExportsBucket, month, true); err != nil {
+			log.Printf("[%s] USAGE: Failed to record export for %s: %v", time.Now().Format("2006-01-02 15:04:05"), month, err)
+		}
+		log.Printf("[%s] USAGE: Posted usage export for %s to channel %s", time.Now().Format("2006-01-02 15:04:05"), month, b.config.UsageExportChannel)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		check()
+		for {
+			select {
+			case <-ticker.C:
+				check()
+			case <-b.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// handleUsageCommand implements "!usage [month]" and "!usage export <channel_id> [month]"
+func (b *Bot) handleUsageCommand(message types.PostedMessage, args []string) string {
+	helpText := "Usage: `!usage [2006-01]`, `!usage export <channel_id> [2006-01]`"
+	month := time.Now().UTC().Format("2006-01")
+
+	if len(args) > 0 && strings.ToLower(args[0]) == "export" {
+		if len(args) < 2 || len(args) > 3 {
+			return helpText
+		}
+		if len(args) == 3 {
+			month = args[2]
+		}
+		if err := usage.ValidMonth(month); err != nil {
+			return err.Error()
+		}
+		if err := b.postUsageExport(args[1], month); err != nil {
+			return fmt.Sprintf("Failed to export usage: %v", err)
+		}
+		return fmt.Sprintf("Posted the %s usage export to `%s`.", month, args[1])
+	}
+
+	if len(args) > 1 {
+		return helpText
+	}
+	if len(args) == 1 {
+		month = args[0]
+	}
+	if err := usage.ValidMonth(month); err != nil {
+		return err.Error()
+	}
+
+	rows := b.usage.Month(month)
+	if len(rows) == 0 {
+		return fmt.Sprintf("No usage recorded for %s.", month)
+	}
+
+	// Summarize per team and model; the full breakdown is in the export
+	type summaryKey struct{ team, model string }
+	summary := make(map[summaryKey]*usage.Row)
+	var keys []summaryKey
+	for _, row := range rows {
+		key := summaryKey{team: row.TeamID, model: row.Model}
+		if _, ok := summary[key]; !ok {
+			summary[key] = &usage.Row{}
+			keys = append(keys, key)
+		}
+		if row.Tool == "" {
+			summary[key].Requests += row.Requests
+		}
+		summary[key].InputTokens += row.InputTokens
+		summary[key].OutputTokens += row.OutputTokens
+		summary[key].CostUSD += row.CostUSD
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Usage for %s**\n\n| Team | Model | Requests | Input tokens | Output tokens | Cost |\n|---|---|---|---|---|---|\n", month))
+	for _, key := range keys {
+		row := summary[key]
+		team := key.team
+		if team == "" {
+			team = "_none_"
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %s | %d | %d | %d | $%.2f |\n", team, key.model, row.Requests, row.InputTokens, row.OutputTokens, row.CostUSD))
+	}
+	total := usage.Totals(rows)
+	sb.WriteString(fmt.Sprintf("\n**Total:** %d requests, $%.2f. Use `!usage export <channel_id>` for the per-channel, per-user CSV.", total.Requests, total.CostUSD))
+	return sb.String()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"agent-bot/types"
+)
+
+// userCacheTTL bounds how stale a cached username can get
+const userCacheTTL = 10 * time.Minute
+
+type cachedUser struct {
+	user    *types.User
+	expires time.Time
+}
+
+// userCache is an in-memory TTL cache of chat users
+type userCache struct {
+	mu        sync.RWMutex
+	ttl       time.Duration
+	entries   map[string]cachedUser
+	lastSweep time.Time
+}
+
+func newUserCache(ttl time.Duration) *userCache {
+	return &userCache{
+		ttl:       ttl,
+		entries:   make(map[string]cachedUser),
+		lastSweep: time.Now(),
+	}
+}
+
+func (c *userCache) get(userID string) (*types.User, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[userID]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.user, true
+}
+
+func (c *userCache) put(user *types.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop expired entries once per TTL so the cache can't grow unbounded
+	now := time.Now()
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for id, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, id)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	c.entries[user.ID] = cachedUser{user: user, expires: now.Add(c.ttl)}
+}
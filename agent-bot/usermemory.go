@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/types"
+)
+
+// withUserMemory prepends the facts the sender asked the bot to remember about them
+func (a *BotAgent) withUserMemory(userID, prompt string) string {
+	if a.memory == nil || userID == "" || !a.features.Enabled(FeatureUserMemory) {
+		return prompt
+	}
+
+	facts := a.memory.UserFacts(userID)
+	if len(facts) == 0 {
+		return prompt
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Facts the user you are replying to asked you to remember about them (use them when relevant; don't recite them):\n")
+	for _, fact := range facts {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", fact.Key, fact.Value))
+	}
+	sb.WriteString("\n")
+
+	log.Printf("[%s] MEMORY: Added %d remembered facts about user %s to the prompt", time.Now().Format("2006-01-02 15:04:05"), len(facts), userID)
+	return sb.String() + prompt
+}
+
+// handleForgetCommand implements "!forget [key|all]" for the user sending it
+func (b *Bot) handleForgetCommand(message types.PostedMessage, args []string) string {
+	if len(args) == 0 {
+		facts := b.memory.UserFacts(message.UserId)
+		if len(facts) == 0 {
+			return "I don't remember anything about you. Tell me \"remember that I...\" to change that."
+		}
+		var sb strings.Builder
+		sb.WriteString("**What I remember about you**\n")
+		for _, fact := range facts {
+			sb.WriteString(fmt.Sprintf("- `%s`: %s\n", fact.Key, fact.Value))
+		}
+		sb.WriteString("\nSend `!forget <key>` to drop one fact or `!forget all` to drop everything.")
+		return sb.String()
+	}
+
+	if len(args) == 1 && strings.ToLower(args[0]) == "all" {
+		count, err := b.memory.ForgetUser(message.UserId)
+		if err != nil {
+			return fmt.Sprintf("Failed to forget: %v", err)
+		}
+		return fmt.Sprintf("Done, I forgot %d facts about you.", count)
+	}
+
+	key := strings.Join(args, " ")
+	if err := b.memory.ForgetUserFact(message.UserId, key); err != nil {
+		return fmt.Sprintf("Failed to forget: %v", err)
+	}
+	return fmt.Sprintf("Done, I forgot `%s`.", key)
+}
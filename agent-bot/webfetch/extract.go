@@ -0,0 +1,89 @@
+package webfetch
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// skippedElements never contain readable text
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "svg": true, "template": true,
+	"iframe": true, "canvas": true, "nav": true, "footer": true, "form": true,
+}
+
+// blockElements start a new line in the extracted text
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true,
+	"article": true, "header": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "pre": true, "blockquote": true, "table": true, "ul": true,
+	"ol": true, "dt": true, "dd": true, "hr": true, "main": true, "aside": true,
+}
+
+// extractText returns the page title and its readable text, one block per line
+func extractText(r io.Reader) (string, string) {
+	tokenizer := html.NewTokenizer(r)
+	var title string
+	var text strings.Builder
+	var line strings.Builder
+	skipDepth := 0
+	inTitle := false
+
+	flush := func() {
+		if s := strings.Join(strings.Fields(line.String()), " "); s != "" {
+			text.WriteString(s)
+			text.WriteString("\n")
+		}
+		line.Reset()
+	}
+
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			flush()
+			return strings.TrimSpace(title), strings.TrimSpace(text.String())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = true
+			}
+			if skippedElements[tag] && tokenType == html.StartTagToken {
+				skipDepth++
+			}
+			if blockElements[tag] {
+				flush()
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = false
+			}
+			if skippedElements[tag] && skipDepth > 0 {
+				skipDepth--
+			}
+			if blockElements[tag] {
+				flush()
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+				continue
+			}
+			if skipDepth == 0 {
+				line.Write(tokenizer.Text())
+				line.WriteString(" ")
+			}
+		}
+	}
+}
+
+// HTMLText returns the readable text of an HTML document or fragment, one
+// block per line
+func HTMLText(r io.Reader) string {
+	_, text := extractText(r)
+	return text
+}
@@ -0,0 +1,157 @@
+// Package webfetch downloads web pages for the fetch_url tool and extracts
+// their title and readable text. It refuses loopback, private and
+// link-local addresses unless told otherwise.
+package webfetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"agent-bot/tools"
+)
+
+// maxBodyBytes caps how much of a response is downloaded before extraction
+const maxBodyBytes = 2 << 20
+
+var errPrivateAddress = errors.New("refusing to fetch a private or local address")
+
+type FetchArgs struct {
+	URL string `json:"url" jsonschema_description:"The http or https URL to fetch"`
+}
+
+// Page is the readable content of a fetched URL
+type Page struct {
+	URL       string `json:"url"`
+	Title     string `json:"title,omitempty"`
+	Content   string `json:"content"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// Fetcher downloads web pages and reduces them to plain text for the LLM
+type Fetcher struct {
+	client   *http.Client
+	maxChars int
+}
+
+// NewFetcher creates a fetcher that gives up after timeout and returns at
+// most maxChars characters per page. Unless allowPrivate is set, requests to
+// loopback, private and link-local addresses are refused so the tool cannot
+// reach services inside the deployment network.
+func NewFetcher(timeout time.Duration, maxChars int, allowPrivate bool) *Fetcher {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	if !allowPrivate {
+		// A proxy would make the address check see only the proxy
+		transport.Proxy = nil
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return errors.New("too many redirects")
+				}
+				return nil
+			},
+		},
+		maxChars: maxChars,
+	}
+}
+
+// Tools returns the fetch_url tool
+func (f *Fetcher) Tools() []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "fetch_url",
+			Description: "Fetch a web page or text document by URL and return its readable text. Use it when the user shares a link or asks about a specific page.",
+			Schema:      tools.SchemaFor[FetchArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input FetchArgs) (interface{}, error) {
+				return f.Fetch(ctx, input.URL)
+			}),
+		},
+	}
+}
+
+// Fetch downloads rawURL and extracts its text
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Page, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("not an http(s) URL: %q", rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", parsed.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "agent-bot/1.0 (+fetch_url)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.5")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			return nil, errPrivateAddress
+		}
+		return nil, fmt.Errorf("failed to fetch %s: %w", parsed.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned status %d", parsed.Host, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	page := &Page{URL: resp.Request.URL.String()}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" || (mediaType == "" && bytes.Contains(bytes.ToLower(body[:min(len(body), 512)]), []byte("<html"))):
+		page.Title, page.Content = extractText(bytes.NewReader(body))
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "application/xml" || mediaType == "":
+		if !utf8.Valid(body) {
+			return nil, fmt.Errorf("%s returned binary content", parsed.Host)
+		}
+		page.Content = strings.TrimSpace(string(body))
+	default:
+		return nil, fmt.Errorf("cannot read %s content", mediaType)
+	}
+
+	if len(body) == maxBodyBytes {
+		page.Truncated = true
+	}
+	if f.maxChars > 0 && utf8.RuneCountInString(page.Content) > f.maxChars {
+		page.Content = string([]rune(page.Content)[:f.maxChars])
+		page.Truncated = true
+	}
+	return page, nil
+}
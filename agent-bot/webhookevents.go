@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"agent-bot/errorsink"
+	"agent-bot/reqid"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Event sources (EVENT_SOURCE). Hosted Mattermost setups that don't allow a
+// long-lived websocket can deliver messages as outgoing webhooks and slash
+// commands instead; replies go through the REST API either way.
+const (
+	eventSourceWebsocket = "websocket"
+	eventSourceWebhook   = "webhook"
+)
+
+// validateEventSource checks the event source of a workspace
+func validateEventSource(config Config) error {
+	switch config.EventSource {
+	case eventSourceWebsocket:
+		return nil
+	case eventSourceWebhook:
+	default:
+		return fmt.Errorf("EVENT_SOURCE must be websocket or webhook, not %q", config.EventSource)
+	}
+	if len(config.WebhookTokens) == 0 {
+		return errors.New("EVENT_SOURCE=webhook requires MATTERMOST_WEBHOOK_TOKENS, the tokens of the outgoing webhooks and slash commands")
+	}
+	if config.LeaderElection {
+		return errors.New("EVENT_SOURCE=webhook cannot be combined with LEADER_ELECTION; standbys don't serve the webhook routes")
+	}
+	return nil
+}
+
+// webhookPayload is the body of an outgoing webhook or slash command. Both
+// are form-encoded by default; outgoing webhooks can also send JSON.
+type webhookPayload struct {
+	Token       string `json:"token"`
+	TeamID      string `json:"team_id"`
+	ChannelID   string `json:"channel_id"`
+	UserID      string `json:"user_id"`
+	UserName    string `json:"user_name"`
+	PostID      string `json:"post_id"`
+	RootID      string `json:"root_id"`
+	Text        string `json:"text"`
+	TriggerWord string `json:"trigger_word"`
+	Command     string `json:"command"`
+	FileIDs     string `json:"file_ids"`
+}
+
+// readWebhookPayload parses the request and checks its token against
+// MATTERMOST_WEBHOOK_TOKENS, writing the error response if either fails
+func (b *Bot) readWebhookPayload(w http.ResponseWriter, r *http.Request) (*webhookPayload, bool) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return nil, false
+	}
+
+	var payload webhookPayload
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON body"})
+			return nil, false
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid form body"})
+			return nil, false
+		}
+		payload = webhookPayload{
+			Token:       r.PostForm.Get("token"),
+			TeamID:      r.PostForm.Get("team_id"),
+			ChannelID:   r.PostForm.Get("channel_id"),
+			UserID:      r.PostForm.Get("user_id"),
+			UserName:    r.PostForm.Get("user_name"),
+			PostID:      r.PostForm.Get("post_id"),
+			RootID:      r.PostForm.Get("root_id"),
+			Text:        r.PostForm.Get("text"),
+			TriggerWord: r.PostForm.Get("trigger_word"),
+			Command:     r.PostForm.Get("command"),
+			FileIDs:     r.PostForm.Get("file_ids"),
+		}
+	}
+
+	for _, token := range b.config.WebhookTokens {
+		if subtle.ConstantTimeCompare([]byte(payload.Token), []byte(token)) == 1 {
+			return &payload, true
+		}
+	}
+	log.Printf("[%s] WEBHOOK_EVENTS: Rejected request for channel %s with an unknown token", time.Now().Format("2006-01-02 15:04:05"), payload.ChannelID)
+	writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid token"})
+	return nil, false
+}
+
+// handleOutgoingWebhook serves POST /mattermost/outgoing, where Mattermost
+// sends posts matching an outgoing webhook's channel or trigger words
+func (b *Bot) handleOutgoingWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, ok := b.readWebhookPayload(w, r)
+	if !ok {
+		return
+	}
+	// Reply through the REST API; an empty response posts nothing
+	w.WriteHeader(http.StatusOK)
+
+	if payload.UserID == b.config.BotUserID || payload.PostID == "" {
+		return
+	}
+	// Outgoing webhooks only fire in public channels
+	if !b.servesPost(payload.TeamID, payload.ChannelID, string(model.ChannelTypeOpen)) {
+		return
+	}
+
+	message := types.PostedMessage{
+		PostId:    payload.PostID,
+		UserId:    payload.UserID,
+		ChannelId: payload.ChannelID,
+		Message:   payload.Text,
+		// A trigger word is the bot's name as far as the webhook is concerned
+		Mentioned: payload.TriggerWord != "" || mentionsUsername(payload.Text, b.config.BotUsername),
+	}
+	if payload.FileIDs != "" {
+		message.FileIds = strings.Split(payload.FileIDs, ",")
+	}
+	// The payload doesn't say which thread the post is in
+	if post, _, err := b.client.GetPost(payload.PostID, ""); err != nil {
+		log.Printf("[%s] WEBHOOK_EVENTS: Failed to get post %s, treating it as a new thread: %v", time.Now().Format("2006-01-02 15:04:05"), payload.PostID, err)
+	} else {
+		message.ThreadId = post.RootId
+	}
+
+	b.dispatchAsync(message)
+}
+
+// handleSlashCommand serves POST /mattermost/command for a slash command
+// like "/agent what changed in the last deploy?". There is no post to reply
+// to, so the bot posts the question itself and answers in its thread.
+func (b *Bot) handleSlashCommand(w http.ResponseWriter, r *http.Request) {
+	payload, ok := b.readWebhookPayload(w, r)
+	if !ok {
+		return
+	}
+	if strings.TrimSpace(payload.Text) == "" {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"response_type": "ephemeral",
+			"text":          fmt.Sprintf("Usage: `%s <question>`", payload.Command),
+		})
+		return
+	}
+
+	channelType := model.ChannelTypeOpen
+	if channel, _, err := b.client.GetChannel(payload.ChannelID, ""); err == nil {
+		channelType = channel.Type
+	}
+	if !b.servesPost(payload.TeamID, payload.ChannelID, string(channelType)) {
+		writeJSON(w, http.StatusOK, map[string]string{"response_type": "ephemeral", "text": "I don't answer in this channel."})
+		return
+	}
+
+	question, _, err := b.client.CreatePost(&model.Post{
+		ChannelId: payload.ChannelID,
+		RootId:    payload.RootID,
+		Message:   fmt.Sprintf("**%s** asked: %s", payload.UserName, payload.Text),
+	})
+	if err != nil {
+		log.Printf("[%s] WEBHOOK_EVENTS: Failed to post %s question in channel %s: %v", time.Now().Format("2006-01-02 15:04:05"), payload.Command, payload.ChannelID, err)
+		writeJSON(w, http.StatusOK, map[string]string{"response_type": "ephemeral", "text": "Sorry, I couldn't post in this channel."})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	b.dispatchAsync(types.PostedMessage{
+		PostId:    question.Id,
+		UserId:    payload.UserID,
+		ThreadId:  payload.RootID,
+		ChannelId: payload.ChannelID,
+		Message:   payload.Text,
+		IsDM:      channelType == model.ChannelTypeDirect,
+		Mentioned: true,
+	})
+}
+
+// dispatchAsync answers a message from a webhook after its request has been
+// acknowledged; Mattermost gives up on slow webhooks
+func (b *Bot) dispatchAsync(message types.PostedMessage) {
+	go func() {
+		ctx := reqid.Ensure(context.Background())
+		defer func() {
+			if r := recover(); r != nil {
+				reqid.Logf(ctx, "WEBHOOK_EVENTS: Handling post %s panicked: %v", message.PostId, r)
+				errorsink.ReportPanic(ctx, r, "workspace", b.profileName(), "event", "webhook", "channel_id", message.ChannelId)
+			}
+		}()
+		reqid.Logf(ctx, "WEBHOOK_EVENTS: Received post %s in channel %s", message.PostId, message.ChannelId)
+		b.dispatchMessage(ctx, message)
+	}()
+}
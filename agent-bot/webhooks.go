@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-bot/apikeys"
+	"agent-bot/llms"
+	"agent-bot/metrics"
+	"agent-bot/notify"
+	"agent-bot/prompts"
+	"agent-bot/tools"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// maxWebhookBodyBytes bounds incoming webhook payloads
+const maxWebhookBodyBytes = 1 << 20
+
+// maxWebhookSummaryPayloadChars bounds the payload sent to the model for a
+// summarized notification
+const maxWebhookSummaryPayloadChars = 12000
+
+// webhookSummaryTimeout bounds summarizing and posting one notification
+const webhookSummaryTimeout = 2 * time.Minute
+
+type webhookResponse struct {
+	Delivered int `json:"delivered"`
+	// Queued counts summarized notifications, posted once the model answers
+	Queued int      `json:"queued,omitempty"`
+	Rules  []string `json:"rules"`
+}
+
+// handleWebhook accepts POST /webhooks/<source> with a JSON body and fans it
+// out through the notification rules. Requests are authenticated by the
+// source's signature when GITHUB_WEBHOOK_SECRET or GITLAB_WEBHOOK_TOKEN is
+// set, otherwise by API key; services that cannot set headers may pass the
+// key as ?token=.
+func (b *Bot) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	source := strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhooks/"), "/")
+	if source == "" || strings.Contains(source, "/") {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "unknown webhook source"})
+		return
+	}
+
+	// Asana webhooks authenticate with their handshake secret, not an API key
+	if source == "asana" && b.asanaHooks != nil && isAsanaDelivery(r) {
+		b.handleAsanaWebhook(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read body"})
+		return
+	}
+
+	// A verified signature stands in for an API key; the rules are the
+	// admin's own, so their targets need no key scope
+	var key *apikeys.Key
+	sender := source + " signature"
+	signed, err := b.verifyWebhookSignature(source, r, body)
+	if err != nil {
+		log.Printf("[%s] WEBHOOK: Rejected %s delivery: %v", time.Now().Format("2006-01-02 15:04:05"), source, err)
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid signature"})
+		return
+	}
+	if !signed {
+		if r.Header.Get("Authorization") == "" {
+			if token := r.URL.Query().Get("token"); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		var ok bool
+		if key, ok = b.authenticateAPIKey(w, r); !ok {
+			return
+		}
+		sender = "key " + key.ID
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON body"})
+		return
+	}
+
+	event := notify.Event{Source: source, Type: webhookEventType(r, payload), Payload: payload}
+	notifications, err := b.notifications.Route(event)
+	if err != nil {
+		log.Printf("[%s] WEBHOOK: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+
+	response := webhookResponse{Rules: []string{}}
+	for _, notification := range notifications {
+		if key != nil && notification.Target.ChannelID != "" && !key.CanPostTo(notification.Target.ChannelID) {
+			log.Printf("[%s] WEBHOOK: Key %s is not scoped to channel %s, skipping rule %s", time.Now().Format("2006-01-02 15:04:05"), key.ID, notification.Target.ChannelID, notification.Rule)
+			continue
+		}
+		if notification.Summarize {
+			// The sender would time out waiting for the model
+			go b.deliverSummarizedNotification(notification)
+			response.Queued++
+		} else {
+			if err := b.deliverNotification(notification); err != nil {
+				log.Printf("[%s] WEBHOOK: Rule %s failed to deliver: %v", time.Now().Format("2006-01-02 15:04:05"), notification.Rule, err)
+				continue
+			}
+			metrics.Inc("webhook_notifications_total", "source", source, "rule", notification.Rule)
+			response.Delivered++
+		}
+		if !containsString(response.Rules, notification.Rule) {
+			response.Rules = append(response.Rules, notification.Rule)
+		}
+	}
+
+	log.Printf("[%s] WEBHOOK: %s event %q from %s delivered %d notifications, queued %d", time.Now().Format("2006-01-02 15:04:05"), source, event.Type, sender, response.Delivered, response.Queued)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// verifyWebhookSignature checks the signature of GitHub and GitLab
+// deliveries when their secret is configured. It reports false without an
+// error for sources that aren't signed, which fall back to API keys.
+func (b *Bot) verifyWebhookSignature(source string, r *http.Request, body []byte) (bool, error) {
+	switch {
+	case source == "github" && b.config.GitHubWebhookSecret != "":
+		signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return false, fmt.Errorf("missing X-Hub-Signature-256")
+		}
+		expected, err := hex.DecodeString(signature)
+		if err != nil {
+			return false, fmt.Errorf("malformed X-Hub-Signature-256")
+		}
+		mac := hmac.New(sha256.New, []byte(b.config.GitHubWebhookSecret))
+		mac.Write(body)
+		if !hmac.Equal(mac.Sum(nil), expected) {
+			return false, fmt.Errorf("X-Hub-Signature-256 does not match GITHUB_WEBHOOK_SECRET")
+		}
+		return true, nil
+	case source == "gitlab" && b.config.GitLabWebhookToken != "":
+		token := r.Header.Get("X-Gitlab-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(b.config.GitLabWebhookToken)) != 1 {
+			return false, fmt.Errorf("X-Gitlab-Token does not match GITLAB_WEBHOOK_TOKEN")
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// webhookEventType finds the event name from common provider headers, the
+// ?event= parameter, or an "event"/"type" payload field
+func webhookEventType(r *http.Request, payload map[string]interface{}) string {
+	for _, header := range []string{"X-GitHub-Event", "X-Gitlab-Event", "X-Event-Type"} {
+		if value := r.Header.Get(header); value != "" {
+			return value
+		}
+	}
+	if value := r.URL.Query().Get("event"); value != "" {
+		return value
+	}
+	for _, field := range []string{"event", "type"} {
+		if value, ok := payload[field].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func (b *Bot) deliverNotification(notification notify.Notification) error {
+	if notification.Target.UserID != "" {
+		return b.sendDirectMessage(notification.Target.UserID, notification.Message)
+	}
+	post, _, err := b.client.CreatePost(&model.Post{ChannelId: notification.Target.ChannelID, Message: notification.Message})
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %v", err)
+	}
+	if notification.FollowUp {
+		// Replies under the notification are answered like any thread the agent is in
+		if agent, ok := b.agent.(*BotAgent); ok {
+			agent.joinThread(post.Id)
+		}
+	}
+	return nil
+}
+
+// deliverSummarizedNotification has the model condense the event into the
+// notification, falling back to the rule's template when that fails
+func (b *Bot) deliverSummarizedNotification(notification notify.Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookSummaryTimeout)
+	defer cancel()
+
+	if summary, err := b.summarizeWebhookEvent(ctx, notification); err != nil {
+		log.Printf("[%s] WEBHOOK: Rule %s failed to summarize, posting the template: %v", time.Now().Format("2006-01-02 15:04:05"), notification.Rule, err)
+	} else {
+		notification.Message = summary
+	}
+
+	if err := b.deliverNotification(notification); err != nil {
+		log.Printf("[%s] WEBHOOK: Rule %s failed to deliver: %v", time.Now().Format("2006-01-02 15:04:05"), notification.Rule, err)
+		return
+	}
+	metrics.Inc("webhook_notifications_total", "source", notification.Event.Source, "rule", notification.Rule)
+}
+
+func (b *Bot) summarizeWebhookEvent(ctx context.Context, notification notify.Notification) (string, error) {
+	prompt, err := b.prompts.Render(prompts.WebhookEvent, prompts.WebhookEventData{
+		Source:       notification.Event.Source,
+		Event:        notification.Event.Type,
+		Notification: notification.Message,
+		Payload:      notify.Compact(notification.Event.Payload, maxWebhookSummaryPayloadChars),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ctx = llms.WithoutTools(ctx)
+	ctx = tools.WithRequest(ctx, tools.Request{UserID: "webhook", ChannelID: notification.Target.ChannelID})
+	summary, err := b.llmBackend.Prompt(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	if summary = strings.TrimSpace(summary); summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return summary, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRulesCommand implements "!rules"
+func (b *Bot) handleRulesCommand(message types.PostedMessage, args []string) string {
+	rules := b.notifications.Rules()
+	if len(rules) == 0 {
+		return "No notification rules are configured. Add them under `notification_rules` in the config file."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Notification rules** (evaluated in order)\n")
+	for _, rule := range rules {
+		var targets []string
+		for _, target := range rule.Notify {
+			if target.ChannelID != "" {
+				targets = append(targets, "channel `"+target.ChannelID+"`")
+			} else {
+				targets = append(targets, "user `"+target.UserID+"`")
+			}
+		}
+		when := fmt.Sprintf("source `%s`", orAny(rule.Source))
+		if rule.Event != "" {
+			when += fmt.Sprintf(", event `%s`", rule.Event)
+		}
+		keys := make([]string, 0, len(rule.Match))
+		for key := range rule.Match {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			when += fmt.Sprintf(", `%s` = `%s`", key, rule.Match[key])
+		}
+		var extras []string
+		if rule.Summarize {
+			extras = append(extras, "summarized")
+		}
+		if rule.FollowUp {
+			extras = append(extras, "follows up in thread")
+		}
+		if len(extras) > 0 {
+			targets[len(targets)-1] += " (" + strings.Join(extras, ", ") + ")"
+		}
+		sb.WriteString(fmt.Sprintf("- `%s`: %s → %s\n", rule.Name, when, strings.Join(targets, ", ")))
+	}
+	return sb.String()
+}
+
+func orAny(pattern string) string {
+	if pattern == "" {
+		return "*"
+	}
+	return pattern
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"action":"opened"}`)
+	mac := hmac.New(sha256.New, []byte("gh-secret"))
+	mac.Write(body)
+	goodSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	signed := Config{GitHubWebhookSecret: "gh-secret", GitLabWebhookToken: "gl-token"}
+	tests := []struct {
+		name       string
+		config     Config
+		source     string
+		headers    map[string]string
+		wantSigned bool
+		wantErr    bool
+	}{
+		{name: "github good signature", config: signed, source: "github", headers: map[string]string{"X-Hub-Signature-256": goodSignature}, wantSigned: true},
+		{name: "github bad signature", config: signed, source: "github", headers: map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(make([]byte, 32))}, wantErr: true},
+		{name: "github malformed signature", config: signed, source: "github", headers: map[string]string{"X-Hub-Signature-256": "sha256=zz"}, wantErr: true},
+		{name: "github missing signature", config: signed, source: "github", wantErr: true},
+		{name: "github sha1 signature", config: signed, source: "github", headers: map[string]string{"X-Hub-Signature-256": "sha1=abc"}, wantErr: true},
+		{name: "github without secret", config: Config{}, source: "github", headers: map[string]string{"X-Hub-Signature-256": goodSignature}},
+		{name: "gitlab good token", config: signed, source: "gitlab", headers: map[string]string{"X-Gitlab-Token": "gl-token"}, wantSigned: true},
+		{name: "gitlab wrong token", config: signed, source: "gitlab", headers: map[string]string{"X-Gitlab-Token": "guess"}, wantErr: true},
+		{name: "gitlab missing token", config: signed, source: "gitlab", wantErr: true},
+		{name: "unsigned source", config: signed, source: "grafana"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bot{config: tt.config}
+			r := httptest.NewRequest(http.MethodPost, "/webhooks/"+tt.source, nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			got, err := b.verifyWebhookSignature(tt.source, r, body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyWebhookSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantSigned {
+				t.Errorf("verifyWebhookSignature() = %v, want %v", got, tt.wantSigned)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookSignatureCoversTheBody(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("gh-secret"))
+	mac.Write([]byte(`{"action":"opened"}`))
+
+	b := &Bot{config: Config{GitHubWebhookSecret: "gh-secret"}}
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/github", nil)
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	if _, err := b.verifyWebhookSignature("github", r, []byte(`{"action":"deleted"}`)); err == nil {
+		t.Fatal("signature for one body accepted another")
+	}
+}
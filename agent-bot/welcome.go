@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-bot/llms"
+	"agent-bot/metrics"
+	"agent-bot/prompts"
+	"agent-bot/tools"
+	"agent-bot/types"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	// welcomeBucket stores the channels that opted in to welcoming new members
+	welcomeBucket = "welcome_channels"
+	// welcomedBucket remembers who was welcomed where, keyed "<channel_id>|<user_id>",
+	// so leaving and rejoining doesn't bring a second welcome
+	welcomedBucket = "welcomed_members"
+)
+
+// Limits on the pinned posts passed to the welcome prompt
+const (
+	maxWelcomePinned      = 5
+	maxWelcomePinnedChars = 400
+)
+
+func (b *Bot) welcomeEnabled(channelID string) bool {
+	var enabled bool
+	found, err := b.store.Get(welcomeBucket, channelID, &enabled)
+	return err == nil && found && enabled
+}
+
+// welcomeMember greets a user who joined an opted-in channel, pointing them
+// at the channel's purpose and pinned posts
+func (b *Bot) welcomeMember(channelID, userID string) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	user, err := b.chat.GetUser(userID)
+	if err != nil {
+		log.Printf("[%s] WELCOME: Failed to get user %s: %v", timestamp, userID, err)
+		return
+	}
+	if user.IsBot {
+		return
+	}
+	key := channelID + "|" + userID
+	if welcomed, err := b.store.Get(welcomedBucket, key, new(time.Time)); err == nil && welcomed {
+		log.Printf("[%s] WELCOME: @%s was already welcomed to %s, skipping", timestamp, user.Username, channelID)
+		return
+	}
+
+	data := prompts.WelcomeData{Username: user.Username, Channel: channelID}
+	if channel, err := b.chat.GetChannel(channelID); err != nil {
+		log.Printf("[%s] WELCOME: Failed to get channel %s, welcoming without its purpose: %v", timestamp, channelID, err)
+	} else {
+		data.Channel, data.Purpose, data.Header = channel.DisplayName, channel.Purpose, channel.Header
+	}
+	data.Pinned = b.pinnedExcerpts(channelID)
+
+	message := b.writeWelcome(channelID, data)
+	if _, _, err := b.client.CreatePost(&model.Post{ChannelId: channelID, Message: message}); err != nil {
+		log.Printf("[%s] WELCOME: Failed to welcome @%s to %s: %v", timestamp, user.Username, channelID, err)
+		return
+	}
+	if err := b.store.Put(welcomedBucket, key, time.Now()); err != nil {
+		log.Printf("[%s] WELCOME: Failed to record welcome of @%s: %v", timestamp, user.Username, err)
+	}
+	metrics.Inc("welcomes_total")
+	log.Printf("[%s] WELCOME: Welcomed @%s to %s (%d pinned posts)", timestamp, user.Username, channelID, len(data.Pinned))
+}
+
+// pinnedExcerpts returns the newest pinned posts of the channel, shortened
+func (b *Bot) pinnedExcerpts(channelID string) []string {
+	postList, _, err := b.client.GetPinnedPosts(channelID, "")
+	if err != nil {
+		log.Printf("[%s] WELCOME: Failed to get pinned posts of %s: %v", time.Now().Format("2006-01-02 15:04:05"), channelID, err)
+		return nil
+	}
+	// SortByCreateAt orders newest first
+	postList.SortByCreateAt()
+	var excerpts []string
+	for _, post := range postList.ToSlice() {
+		text := strings.TrimSpace(post.Message)
+		if text == "" || post.DeleteAt != 0 {
+			continue
+		}
+		if runes := []rune(text); len(runes) > maxWelcomePinnedChars {
+			text = string(runes[:maxWelcomePinnedChars]) + "…"
+		}
+		excerpts = append(excerpts, text)
+		if len(excerpts) == maxWelcomePinned {
+			break
+		}
+	}
+	return excerpts
+}
+
+// writeWelcome asks the model for the welcome, falling back to a plain one
+func (b *Bot) writeWelcome(channelID string, data prompts.WelcomeData) string {
+	fallback := fmt.Sprintf("Welcome to %s, @%s! 👋", data.Channel, data.Username)
+	if len(data.Pinned) > 0 {
+		fallback += " The pinned posts are a good place to start."
+	}
+
+	prompt, err := b.prompts.Render(prompts.Welcome, data)
+	if err != nil {
+		log.Printf("[%s] WELCOME: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return fallback
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	ctx = llms.WithoutTools(ctx)
+	ctx = tools.WithRequest(ctx, tools.Request{UserID: "welcome", ChannelID: channelID})
+	welcome, err := b.llmBackend.Prompt(ctx, prompt)
+	if err != nil || strings.TrimSpace(welcome) == "" {
+		log.Printf("[%s] WELCOME: Failed to write welcome, posting a plain one: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+		return fallback
+	}
+	welcome = strings.TrimSpace(welcome)
+	// The greeting must reach the new member even if the model left the mention out
+	if !strings.Contains(welcome, "@"+data.Username) {
+		welcome = "@" + data.Username + " " + welcome
+	}
+	return welcome
+}
+
+// handleWelcomeCommand implements "!welcome on|off <channel_id|here>" and "!welcome list"
+func (b *Bot) handleWelcomeCommand(message types.PostedMessage, args []string) string {
+	usage := "Usage: `!welcome on <channel_id|here>`, `!welcome off <channel_id|here>`, `!welcome list`"
+	if len(args) == 0 {
+		return usage
+	}
+
+	channelID := ""
+	if len(args) == 2 {
+		channelID = args[1]
+		if channelID == "here" {
+			channelID = message.ChannelId
+		}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		var channels []string
+		for _, id := range b.store.Keys(welcomeBucket) {
+			if b.welcomeEnabled(id) {
+				channels = append(channels, "`"+id+"`")
+			}
+		}
+		if len(channels) == 0 {
+			return "New members aren't welcomed in any channel."
+		}
+		return "New members are welcomed in: " + strings.Join(channels, ", ")
+
+	case "on":
+		if channelID == "" {
+			return usage
+		}
+		if err := b.store.Put(welcomeBucket, channelID, true); err != nil {
+			return fmt.Sprintf("Failed to enable welcomes: %v", err)
+		}
+		return fmt.Sprintf("New members of `%s` will be welcomed.", channelID)
+
+	case "off":
+		if channelID == "" {
+			return usage
+		}
+		if err := b.store.Delete(welcomeBucket, channelID); err != nil {
+			return fmt.Sprintf("Failed to disable welcomes: %v", err)
+		}
+		return fmt.Sprintf("New members of `%s` won't be welcomed anymore.", channelID)
+	}
+
+	return usage
+}
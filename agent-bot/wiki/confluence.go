@@ -0,0 +1,175 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"agent-bot/webfetch"
+)
+
+// Confluence reads pages through the Confluence REST API
+type Confluence struct {
+	baseURL    string
+	email      string
+	apiToken   string
+	spaces     allowlist
+	httpClient *http.Client
+}
+
+// NewConfluence creates a Confluence provider for baseURL, e.g.
+// https://example.atlassian.net/wiki. As with Jira, an email sends the token
+// as basic auth (Cloud); without one it is a bearer personal access token
+// (Server/Data Center). Only pages in spaces (space keys) are read; empty
+// allows every space the token can see.
+func NewConfluence(baseURL, email, apiToken string, spaces []string, httpClient *http.Client) *Confluence {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Confluence{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		email:      email,
+		apiToken:   apiToken,
+		spaces:     newAllowlist(spaces, strings.ToUpper),
+		httpClient: httpClient,
+	}
+}
+
+func (c *Confluence) Name() string {
+	return "Confluence"
+}
+
+type confluenceContent struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Space struct {
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"space"`
+	Version struct {
+		When string `json:"when"`
+	} `json:"version"`
+	Body struct {
+		Storage struct {
+			Value string `json:"value"`
+		} `json:"storage"`
+	} `json:"body"`
+	Links struct {
+		WebUI string `json:"webui"`
+		Base  string `json:"base"`
+	} `json:"_links"`
+}
+
+func (c *Confluence) page(content confluenceContent, base string) Page {
+	if content.Links.Base != "" {
+		base = content.Links.Base
+	}
+	if base == "" {
+		base = c.baseURL
+	}
+	page := Page{ID: content.ID, Title: content.Title, Space: content.Space.Key, Updated: content.Version.When}
+	if content.Links.WebUI != "" {
+		page.URL = base + content.Links.WebUI
+	}
+	return page
+}
+
+// Search runs a CQL text search over pages in the allowed spaces
+func (c *Confluence) Search(ctx context.Context, query string, limit int) ([]Page, error) {
+	cql := fmt.Sprintf(`type = page AND text ~ "%s"`, cqlEscape(query))
+	if spaces := c.spaces.sorted(); len(spaces) > 0 {
+		quoted := make([]string, len(spaces))
+		for i, space := range spaces {
+			quoted[i] = `"` + cqlEscape(space) + `"`
+		}
+		cql += " AND space in (" + strings.Join(quoted, ",") + ")"
+	}
+
+	path := fmt.Sprintf("/rest/api/content/search?cql=%s&limit=%d&expand=space,version", url.QueryEscape(cql), limit)
+	var response struct {
+		Results []confluenceContent `json:"results"`
+		Links   struct {
+			Base string `json:"base"`
+		} `json:"_links"`
+	}
+	if err := c.get(ctx, path, &response); err != nil {
+		return nil, err
+	}
+
+	pages := make([]Page, 0, len(response.Results))
+	for _, content := range response.Results {
+		// The CQL already limits spaces; this guards against CQL quirks
+		if c.spaces.allows(strings.ToUpper(content.Space.Key)) {
+			pages = append(pages, c.page(content, response.Links.Base))
+		}
+	}
+	return pages, nil
+}
+
+var confluencePageID = regexp.MustCompile(`(?:/pages/|pageId=)(\d+)`)
+
+// Get returns a page's text, from its ID or URL
+func (c *Confluence) Get(ctx context.Context, id string, maxChars int) (*Document, error) {
+	if match := confluencePageID.FindStringSubmatch(id); match != nil {
+		id = match[1]
+	}
+	var content confluenceContent
+	if err := c.get(ctx, "/rest/api/content/"+url.PathEscape(id)+"?expand=body.storage,space,version", &content); err != nil {
+		return nil, err
+	}
+	if !c.spaces.allows(strings.ToUpper(content.Space.Key)) {
+		return nil, ErrNotAllowed
+	}
+
+	return &Document{
+		Page:    c.page(content, ""),
+		Content: webfetch.HTMLText(strings.NewReader(content.Body.Storage.Value)),
+	}, nil
+}
+
+// Ping verifies the credentials by fetching the authenticated user
+func (c *Confluence) Ping(ctx context.Context) error {
+	var user json.RawMessage
+	return c.get(ctx, "/rest/api/user/current", &user)
+}
+
+func (c *Confluence) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.email != "" {
+		req.SetBasicAuth(c.email, c.apiToken)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.apiToken)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// cqlEscape escapes a value for a double-quoted CQL string
+func cqlEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
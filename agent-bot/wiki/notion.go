@@ -0,0 +1,360 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// NotionAPIURL is the Notion API's base URL
+	NotionAPIURL  = "https://api.notion.com/v1"
+	notionVersion = "2022-06-28"
+	// notionMaxDepth bounds how far up the page tree the allowlist is checked
+	notionMaxDepth = 10
+	// notionBlockDepth bounds how deep nested blocks are read
+	notionBlockDepth = 3
+)
+
+// Notion reads pages through the Notion API, as an internal integration
+type Notion struct {
+	token      string
+	roots      allowlist
+	httpClient *http.Client
+
+	// allowed caches whether page, database and block IDs are under a root
+	mu      sync.Mutex
+	allowed map[string]bool
+}
+
+// NewNotion creates a Notion provider. Notion has no spaces, so roots are
+// the page and database IDs whose subtrees the bot may read; empty allows
+// every page shared with the integration.
+func NewNotion(token string, roots []string, httpClient *http.Client) *Notion {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Notion{
+		token:      token,
+		roots:      newAllowlist(roots, normalizeNotionID),
+		httpClient: httpClient,
+		allowed:    make(map[string]bool),
+	}
+}
+
+func (n *Notion) Name() string {
+	return "Notion"
+}
+
+type notionParent struct {
+	Type       string `json:"type"`
+	PageID     string `json:"page_id"`
+	DatabaseID string `json:"database_id"`
+	BlockID    string `json:"block_id"`
+}
+
+// id returns the parent's ID and the API path it is read from, or "" for
+// the workspace
+func (p notionParent) id() (string, string) {
+	switch p.Type {
+	case "page_id":
+		return p.PageID, "/pages/"
+	case "database_id":
+		return p.DatabaseID, "/databases/"
+	case "block_id":
+		return p.BlockID, "/blocks/"
+	}
+	return "", ""
+}
+
+type notionRichText struct {
+	PlainText string `json:"plain_text"`
+}
+
+type notionPage struct {
+	ID             string       `json:"id"`
+	URL            string       `json:"url"`
+	LastEditedTime string       `json:"last_edited_time"`
+	Parent         notionParent `json:"parent"`
+	Properties     map[string]struct {
+		Type  string           `json:"type"`
+		Title []notionRichText `json:"title"`
+	} `json:"properties"`
+}
+
+func (p notionPage) page() Page {
+	page := Page{ID: p.ID, URL: p.URL, Updated: p.LastEditedTime}
+	for _, property := range p.Properties {
+		if property.Type == "title" {
+			page.Title = plainText(property.Title)
+		}
+	}
+	return page
+}
+
+func plainText(texts []notionRichText) string {
+	var sb strings.Builder
+	for _, text := range texts {
+		sb.WriteString(text.PlainText)
+	}
+	return sb.String()
+}
+
+// Search finds pages shared with the integration and keeps those under a root
+func (n *Notion) Search(ctx context.Context, query string, limit int) ([]Page, error) {
+	request := map[string]interface{}{
+		"query":     query,
+		"filter":    map[string]string{"property": "object", "value": "page"},
+		"page_size": 100,
+	}
+	var response struct {
+		Results []notionPage `json:"results"`
+	}
+	if err := n.request(ctx, "POST", "/search", request, &response); err != nil {
+		return nil, err
+	}
+
+	var pages []Page
+	for _, result := range response.Results {
+		if len(pages) >= limit {
+			break
+		}
+		allowed, err := n.isAllowed(ctx, result.ID, result.Parent)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			pages = append(pages, result.page())
+		}
+	}
+	return pages, nil
+}
+
+// isAllowed walks up from an object to see whether it is under a root
+func (n *Notion) isAllowed(ctx context.Context, id string, parent notionParent) (bool, error) {
+	if len(n.roots) == 0 {
+		return true, nil
+	}
+
+	var visited []string
+	remember := func(allowed bool) (bool, error) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for _, seen := range visited {
+			n.allowed[seen] = allowed
+		}
+		return allowed, nil
+	}
+
+	for depth := 0; depth < notionMaxDepth; depth++ {
+		id = normalizeNotionID(id)
+		if n.roots[id] {
+			return remember(true)
+		}
+		n.mu.Lock()
+		known, cached := n.allowed[id]
+		n.mu.Unlock()
+		if cached {
+			return remember(known)
+		}
+		visited = append(visited, id)
+
+		parentID, path := parent.id()
+		if parentID == "" {
+			return remember(false)
+		}
+		var object struct {
+			Parent notionParent `json:"parent"`
+		}
+		if err := n.request(ctx, "GET", path+parentID, nil, &object); err != nil {
+			return false, err
+		}
+		id, parent = parentID, object.Parent
+	}
+	return remember(false)
+}
+
+// notionPageID finds the page ID at the end of a Notion URL
+var notionPageID = regexp.MustCompile(`([0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12})(?:[?#].*)?$`)
+
+// Get returns a page's text, from its ID or URL
+func (n *Notion) Get(ctx context.Context, id string, maxChars int) (*Document, error) {
+	if match := notionPageID.FindStringSubmatch(strings.TrimSpace(id)); match != nil {
+		id = match[1]
+	}
+	var page notionPage
+	if err := n.request(ctx, "GET", "/pages/"+normalizeNotionID(id), nil, &page); err != nil {
+		return nil, err
+	}
+	allowed, err := n.isAllowed(ctx, page.ID, page.Parent)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrNotAllowed
+	}
+
+	var text strings.Builder
+	truncated, err := n.readBlocks(ctx, page.ID, 0, maxChars, &text)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{Page: page.page(), Content: strings.TrimSpace(text.String()), Truncated: truncated}, nil
+}
+
+type notionBlock struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	HasChildren bool   `json:"has_children"`
+	// Every text block keeps its text in a field named after its type
+	Content map[string]json.RawMessage `json:"-"`
+}
+
+// blockPrefixes mark block types in the plain text
+var blockPrefixes = map[string]string{
+	"heading_1":          "# ",
+	"heading_2":          "## ",
+	"heading_3":          "### ",
+	"bulleted_list_item": "- ",
+	"numbered_list_item": "1. ",
+	"to_do":              "[ ] ",
+	"quote":              "> ",
+	"callout":            "> ",
+}
+
+// readBlocks appends the text of a block's children to text until it holds
+// maxChars, and reports whether it stopped early
+func (n *Notion) readBlocks(ctx context.Context, blockID string, depth, maxChars int, text *strings.Builder) (bool, error) {
+	cursor := ""
+	for {
+		path := "/blocks/" + blockID + "/children?page_size=100"
+		if cursor != "" {
+			path += "&start_cursor=" + cursor
+		}
+		var response struct {
+			Results    []json.RawMessage `json:"results"`
+			HasMore    bool              `json:"has_more"`
+			NextCursor string            `json:"next_cursor"`
+		}
+		if err := n.request(ctx, "GET", path, nil, &response); err != nil {
+			return false, err
+		}
+
+		for _, raw := range response.Results {
+			var block notionBlock
+			if err := json.Unmarshal(raw, &block); err != nil {
+				continue
+			}
+			if err := json.Unmarshal(raw, &block.Content); err != nil {
+				continue
+			}
+			if line := blockText(block); line != "" {
+				text.WriteString(strings.Repeat("  ", depth) + line + "\n")
+			}
+			if text.Len() >= maxChars {
+				return true, nil
+			}
+			// Child pages are read on their own, and only if allowed
+			if block.HasChildren && block.Type != "child_page" && block.Type != "child_database" && depth+1 < notionBlockDepth {
+				truncated, err := n.readBlocks(ctx, block.ID, depth+1, maxChars, text)
+				if err != nil || truncated {
+					return truncated, err
+				}
+			}
+		}
+
+		if !response.HasMore || response.NextCursor == "" {
+			return false, nil
+		}
+		cursor = response.NextCursor
+	}
+}
+
+// blockText renders a block's text, or "" for blocks without any
+func blockText(block notionBlock) string {
+	raw, ok := block.Content[block.Type]
+	if !ok {
+		return ""
+	}
+	var content struct {
+		RichText []notionRichText `json:"rich_text"`
+		Title    string           `json:"title"`
+		Checked  bool             `json:"checked"`
+	}
+	if err := json.Unmarshal(raw, &content); err != nil {
+		return ""
+	}
+
+	switch block.Type {
+	case "child_page", "child_database":
+		return "[Page: " + content.Title + "]"
+	case "code":
+		return "```\n" + plainText(content.RichText) + "\n```"
+	case "to_do":
+		if content.Checked {
+			return "[x] " + plainText(content.RichText)
+		}
+	}
+	text := plainText(content.RichText)
+	if text == "" {
+		return ""
+	}
+	return blockPrefixes[block.Type] + text
+}
+
+// Ping verifies the token by fetching the integration's bot user
+func (n *Notion) Ping(ctx context.Context) error {
+	var user json.RawMessage
+	return n.request(ctx, "GET", "/users/me", nil, &user)
+}
+
+func (n *Notion) request(ctx context.Context, method, path string, payload, v interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, NotionAPIURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	req.Header.Set("Notion-Version", notionVersion)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if err := json.Unmarshal(respBody, v); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// normalizeNotionID lowercases an ID and drops its dashes, so IDs copied
+// from URLs and returned by the API compare equal
+func normalizeNotionID(id string) string {
+	return strings.ToLower(strings.ReplaceAll(id, "-", ""))
+}
@@ -0,0 +1,153 @@
+// Package wiki gives the model read access to the team wiki, in Confluence
+// or Notion, limited to allowlisted spaces
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"agent-bot/tools"
+)
+
+const (
+	// DefaultMaxChars bounds the page text get_wiki_page returns
+	DefaultMaxChars = 20000
+	// maxResults bounds search_wiki's results
+	maxResults = 20
+	// defaultResults is the number of search results without max_results
+	defaultResults = 10
+)
+
+// ErrNotAllowed is returned for pages outside the allowlisted spaces
+var ErrNotAllowed = errors.New("page is outside the spaces the bot may read")
+
+// Page is a wiki page found by a search
+type Page struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Space   string `json:"space,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Excerpt string `json:"excerpt,omitempty"`
+	Updated string `json:"updated,omitempty"`
+}
+
+// Document is a page with its text
+type Document struct {
+	Page
+	Content string `json:"content"`
+	// Truncated is set when Content was cut to the size limit
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Provider searches and reads wiki pages. Implementations only return pages
+// in their allowlisted spaces and report others as ErrNotAllowed.
+type Provider interface {
+	// Name is the wiki's product name, used in tool descriptions
+	Name() string
+	Search(ctx context.Context, query string, limit int) ([]Page, error)
+	// Get returns the page's text, which implementations may stop reading
+	// after maxChars
+	Get(ctx context.Context, id string, maxChars int) (*Document, error)
+	Ping(ctx context.Context) error
+}
+
+type SearchArgs struct {
+	Query      string `json:"query" jsonschema_description:"Words to search page titles and text for"`
+	MaxResults int    `json:"max_results,omitempty" jsonschema_description:"Maximum number of pages to return (optional - default 10, max 20)"`
+}
+
+type GetPageArgs struct {
+	PageID string `json:"page_id" jsonschema_description:"The page ID from search_wiki results or a page URL"`
+}
+
+// Tools returns search_wiki and get_wiki_page backed by provider. Page text
+// is cut to maxChars so one page can't fill the context.
+func Tools(provider Provider, maxChars int) []tools.Tool {
+	if maxChars <= 0 {
+		maxChars = DefaultMaxChars
+	}
+	return []tools.Tool{
+		{
+			Name:        "search_wiki",
+			Description: fmt.Sprintf("Search the team wiki (%s) for pages about a topic. Returns page IDs, titles, spaces and links; read a page with get_wiki_page before answering from it, and link the pages you used.", provider.Name()),
+			Schema:      tools.SchemaFor[SearchArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input SearchArgs) (interface{}, error) {
+				query := strings.TrimSpace(input.Query)
+				if query == "" {
+					return nil, fmt.Errorf("the query is empty")
+				}
+				limit := input.MaxResults
+				if limit <= 0 {
+					limit = defaultResults
+				}
+				if limit > maxResults {
+					limit = maxResults
+				}
+				pages, err := provider.Search(ctx, query, limit)
+				if err != nil {
+					return nil, fmt.Errorf("error searching the wiki: %w", err)
+				}
+				return pages, nil
+			}),
+		},
+		{
+			Name:        "get_wiki_page",
+			Description: fmt.Sprintf("Read a page of the team wiki (%s) as plain text. Long pages are cut short and marked truncated.", provider.Name()),
+			Schema:      tools.SchemaFor[GetPageArgs](),
+			Handler: tools.Typed(func(ctx context.Context, input GetPageArgs) (interface{}, error) {
+				id := strings.TrimSpace(input.PageID)
+				if id == "" {
+					return nil, fmt.Errorf("page_id is empty")
+				}
+				doc, err := provider.Get(ctx, id, maxChars)
+				if err != nil {
+					return nil, fmt.Errorf("error reading page: %w", err)
+				}
+				doc.Content, doc.Truncated = truncate(doc.Content, maxChars, doc.Truncated)
+				return doc, nil
+			}),
+		},
+	}
+}
+
+// truncate cuts text to maxChars at a rune boundary
+func truncate(text string, maxChars int, truncated bool) (string, bool) {
+	if len(text) <= maxChars {
+		return text, truncated
+	}
+	cut := maxChars
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut], true
+}
+
+// allowlist is a set of space keys or IDs; an empty one allows everything
+type allowlist map[string]bool
+
+func newAllowlist(entries []string, normalize func(string) string) allowlist {
+	allowed := make(allowlist)
+	for _, entry := range entries {
+		if entry = normalize(strings.TrimSpace(entry)); entry != "" {
+			allowed[entry] = true
+		}
+	}
+	return allowed
+}
+
+func (a allowlist) allows(entry string) bool {
+	return len(a) == 0 || a[entry]
+}
+
+func (a allowlist) sorted() []string {
+	entries := make([]string, 0, len(a))
+	for entry := range a {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}